@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/codec/msgpack"
+	"github.com/kataras/neffos/gorilla"
+)
+
+// Usage:
+// go run main.go server # once
+// go run main.go client # one or more times
+//
+// Both server and client use the MessagePack codec,
+// `Conn#Marshal` encodes and `Message#Unmarshal` decodes through it.
+// A client which dials without the same codec fails with `neffos.ErrCodecMismatch`.
+const (
+	addr      = "localhost:8080"
+	endpoint  = "/echo"
+	namespace = "default"
+)
+
+type userMessage struct {
+	Username string
+	Text     string
+	SentAt   time.Time
+}
+
+var events = neffos.Namespaces{
+	namespace: neffos.Events{
+		"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+			var userMsg userMessage
+			if err := msg.Unmarshal(&userMsg); err != nil {
+				return err
+			}
+
+			if !c.Conn.IsClient() {
+				log.Printf("[%s] %s says: %s", c, userMsg.Username, userMsg.Text)
+				c.Conn.Server().Broadcast(c, msg)
+				return nil
+			}
+
+			log.Printf("%s says: %s (%s)", userMsg.Username, userMsg.Text, userMsg.SentAt.Format(time.Kitchen))
+			return nil
+		},
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("expected program to start with 'server' or 'client' argument")
+	}
+
+	switch side := os.Args[1]; side {
+	case "server":
+		startServer()
+	case "client":
+		startClient()
+	default:
+		log.Fatalf("unexpected argument, expected 'server' or 'client' but got '%s'", side)
+	}
+}
+
+func startServer() {
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	server.Codec = msgpack.New()
+
+	log.Printf("Listening on: %s\nPress CTRL/CMD+C to interrupt.", addr)
+	http.Handle(endpoint, server)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+func startClient() {
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, addr+endpoint, events, neffos.WithCodec(msgpack.New()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		c.EmitBinary("chat", c.Conn.Marshal(userMessage{
+			Username: client.ID,
+			Text:     "hello from msgpack",
+			SentAt:   time.Now(),
+		}))
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build go1.18
+
+package neffos
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The seed corpus is at the testdata/fuzz/FuzzHandleACK directory, run with:
+// go test -run=XXX -fuzz=FuzzHandleACK
+// The "frames" are the messages before the ack, separated by a zero byte.
+func FuzzHandleACK(f *testing.F) {
+	f.Add([]byte("M"), true)
+	f.Add([]byte("Aid"), false)
+	f.Add([]byte("Hrejected"), false)
+	f.Add([]byte(";default;;_OnNamespaceConnect;0;0;\x00M"), true)
+
+	server := New(nil, Namespaces{"default": Events{}})
+	defer server.Close()
+
+	f.Fuzz(func(t *testing.T, frames []byte, serverSide bool) {
+		var s *Server
+		if serverSide {
+			s = server
+		}
+
+		c, remote := newTestACKConn(s)
+		defer remote.NetConn().Close()
+
+		ok := handleACKFrames(c, bytes.Split(frames, []byte{0}))
+		if !ok && c.isAcknowledged() {
+			t.Fatalf("expected a rejected connection to not be acknowledged")
+		}
+
+		if serverSide && c.ID() != "conn" {
+			t.Fatalf("expected the server-side ID to be kept but got: %q", c.ID())
+		}
+
+		c.queueMutex.Lock()
+		queued := 0
+		for _, q := range c.queue {
+			queued += len(q)
+		}
+		c.queueMutex.Unlock()
+
+		if queued > maxQueueBeforeACK {
+			t.Fatalf("expected the queue to be limited but got: %d", queued)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package neffos
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// newTestACKConn returns a connection, over the pipe sockets, before its ack and its remote socket.
+// A non-nil "server" makes it a server-side one of the "server" which passed its `Server.OnConnect`.
+func newTestACKConn(server *Server) (*Conn, Socket) {
+	remote, socket := NewPipeSockets()
+	if server == nil {
+		return newConn(socket, Namespaces{"default": Events{}}), remote
+	}
+
+	c := newConn(socket, server.namespaces)
+	c.server = server
+	c.setID("conn")
+	c.readiness.unwait(nil)
+	return c, remote
+}
+
+// handleACKFrames handles the "frames" like the reader does before the ack,
+// it reports false if the connection should be closed.
+func handleACKFrames(c *Conn, frames [][]byte) bool {
+	for _, b := range frames {
+		if c.isAcknowledged() {
+			break
+		}
+
+		if !c.handleACK(TextMessage, b) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestHandleACK(t *testing.T) {
+	server := New(nil, Namespaces{"default": Events{}})
+	defer server.Close()
+
+	connect := ";default;;" + OnNamespaceConnect + ";0;0;"
+	tests := []struct {
+		name         string
+		server       bool
+		frames       []string
+		ok           bool
+		acknowledged bool
+		id           string
+	}{
+		{"empty", true, []string{""}, true, false, "conn"},
+		{"server ack", true, []string{"M"}, true, true, "conn"},
+		{"codec mismatch", true, []string{"Mmsgpack"}, false, false, "conn"},
+		{"truncated ack with capabilities", true, []string{"M?"}, true, true, "conn"},
+		{"client sends the server's ack", true, []string{"Aevil"}, false, false, "conn"},
+		{"client sends a rejection", true, []string{"H"}, false, false, "conn"},
+		{"messages before the ack", true, []string{connect, "", "native", "M"}, true, true, "conn"},
+		{"too many messages before the ack", true, []string{strings.Repeat(connect+"\x00", maxQueueBeforeACK+1) + "M"}, false, false, "conn"},
+		{"client ack", false, []string{"Aid"}, true, true, "id"},
+		{"empty id", false, []string{"A"}, true, true, ""},
+		{"empty rejection", false, []string{"H"}, false, false, ""},
+		{"rejection", false, []string{"Hrejected"}, false, false, ""},
+		{"server sends the client's ack", false, []string{"M"}, false, false, ""},
+		{"messages before the id", false, []string{connect, "Aid"}, true, true, "id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s *Server
+			if tt.server {
+				s = server
+			}
+
+			c, remote := newTestACKConn(s)
+			defer remote.NetConn().Close()
+
+			var frames [][]byte
+			for _, frame := range tt.frames {
+				frames = append(frames, bytes.Split([]byte(frame), []byte{0})...)
+			}
+
+			if ok := handleACKFrames(c, frames); ok != tt.ok {
+				t.Fatalf("expected: %v but got: %v", tt.ok, ok)
+			}
+			if acknowledged := c.isAcknowledged(); acknowledged != tt.acknowledged {
+				t.Fatalf("expected acknowledged: %v but got: %v", tt.acknowledged, acknowledged)
+			}
+			if id := c.ID(); id != tt.id {
+				t.Fatalf("expected the ID: %q but got: %q", tt.id, id)
+			}
+			if !tt.server && !tt.ok && c.readiness.wait() == nil {
+				t.Fatalf("expected the Dial to fail")
+			}
+		})
+	}
+}
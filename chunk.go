@@ -0,0 +1,250 @@
+package neffos
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Large payloads are split into chunks, see `NSConn#EmitLarge`.
+// Each chunk is a regular message of the same namespace and event
+// which carries its transfer's header as the `extensionChunk` message extension,
+// i.e "c=<transfer id>.<index>.<count>.<total size>".
+// The receiver reassembles the chunks and dispatches the complete body as one `Message`.
+const (
+	// DefaultChunkSize is the default size in bytes of a chunk's body,
+	// see `Server#SetChunking` and `WithChunking`.
+	DefaultChunkSize = 32 * 1024
+	// DefaultMaxTransferSize is the default maximum total size in bytes
+	// of an incoming chunked transfer.
+	DefaultMaxTransferSize = 32 * 1024 * 1024
+	// DefaultTransferTimeout is the default maximum duration of an incoming chunked transfer,
+	// it's counted from its first chunk.
+	DefaultTransferTimeout = 30 * time.Second
+)
+
+// ErrIncompleteTransfer is the `Message.Err` of the event callback
+// when an incoming chunked transfer is aborted by the sender,
+// its chunks arrive out of order or it times out, see `NSConn#EmitLarge`.
+// A transfer which exceeds the maximum size fails with the `ErrMessageTooLarge` instead.
+var ErrIncompleteTransfer = errors.New("incomplete transfer")
+
+const chunkHeaderSeparator = "."
+
+type chunkHeader struct {
+	id    string
+	index int
+	count int
+	size  int64
+}
+
+func (h chunkHeader) String() string {
+	return h.id + chunkHeaderSeparator + strconv.Itoa(h.index) + chunkHeaderSeparator +
+		strconv.Itoa(h.count) + chunkHeaderSeparator + strconv.FormatInt(h.size, 10)
+}
+
+func parseChunkHeader(s string) (h chunkHeader, ok bool) {
+	parts := strings.Split(s, chunkHeaderSeparator)
+	if len(parts) != 4 || parts[0] == "" {
+		return
+	}
+
+	var err error
+	if h.index, err = strconv.Atoi(parts[1]); err != nil || h.index < 0 {
+		return
+	}
+	if h.count, err = strconv.Atoi(parts[2]); err != nil || h.count <= h.index {
+		return
+	}
+	if h.size, err = strconv.ParseInt(parts[3], 10, 64); err != nil || h.size < 0 {
+		return
+	}
+
+	h.id = parts[0]
+	return h, true
+}
+
+// transfer is an incoming chunked transfer, see `Conn#reassemble`.
+type transfer struct {
+	namespace string
+	event     string
+	header    chunkHeader
+	next      int
+	body      *bytes.Buffer
+	timer     *time.Timer
+}
+
+// EmitLarge method sends "totalSize" bytes of "r" to the remote side
+// as a series of chunks of the same "event", so payloads larger than the remote side's
+// maximum message size (see `Server#SetMaxMessageSize`) can be sent too.
+// The remote side buffers the chunks and fires the event callback once,
+// with the complete body. If the transfer is incomplete, i.e "r" returns less than "totalSize" bytes,
+// the remote event callback is fired with the `ErrIncompleteTransfer` as its `Message.Err`.
+//
+// Each transfer has its own ID, so many transfers can run concurrently on the same connection.
+// The size of the chunks is set by the `Server#SetChunking` and `WithChunking`,
+// it should be smaller than the remote side's maximum message size.
+func (ns *NSConn) EmitLarge(event string, r io.Reader, totalSize int64) error {
+	if ns == nil {
+		return ErrWrite
+	}
+
+	c := ns.Conn
+	chunkSize := int64(c.chunkSize)
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	count := (totalSize + chunkSize - 1) / chunkSize
+	if count == 0 {
+		count = 1
+	}
+
+	header := chunkHeader{
+		id:    strconv.FormatUint(atomic.AddUint64(c.transferSeq, 1), 36),
+		count: int(count),
+		size:  totalSize,
+	}
+
+	buf := make([]byte, chunkSize)
+	remaining := totalSize
+	for ; header.index < header.count; header.index++ {
+		n := chunkSize
+		if remaining < n {
+			n = remaining
+		}
+
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			// let the remote side drop the transfer.
+			c.Write(Message{Namespace: ns.namespace, Event: event, Err: ErrIncompleteTransfer, chunk: header})
+			return err
+		}
+
+		if !c.Write(Message{Namespace: ns.namespace, Event: event, Body: buf[:n], SetBinary: ns.binary, chunk: header}) {
+			return ErrWrite
+		}
+
+		remaining -= n
+	}
+
+	return nil
+}
+
+// reassemble buffers an incoming chunk "msg" and reports whether its transfer is complete,
+// if so it returns the message with the complete body.
+// On failure the transfer is dropped and its event callback is fired with the error.
+func (c *Conn) reassemble(msg Message) (Message, bool) {
+	h := msg.chunk
+
+	c.transfersMutex.Lock()
+	t, ok := c.transfers[h.id]
+	if !ok {
+		t = &transfer{namespace: msg.Namespace, event: msg.Event, header: h}
+	}
+
+	err := t.add(msg, c.maxTransferSize)
+	if err == nil && !ok {
+		if c.transfers == nil {
+			c.transfers = make(map[string]*transfer)
+		}
+		c.transfers[h.id] = t
+
+		timeout := c.transferTimeout
+		if timeout <= 0 {
+			timeout = DefaultTransferTimeout
+		}
+		t.timer = time.AfterFunc(timeout, func() {
+			if c.dropTransfer(h.id, t) {
+				c.failTransfer(t, ErrIncompleteTransfer)
+			}
+		})
+	}
+
+	complete := err == nil && t.next == h.count
+	if ok && (complete || err != nil) {
+		delete(c.transfers, h.id)
+		t.timer.Stop()
+	}
+	c.transfersMutex.Unlock()
+
+	if err != nil {
+		c.failTransfer(t, err)
+		return msg, false
+	}
+
+	if !complete {
+		return msg, false
+	}
+
+	msg.Body = t.body.Bytes()
+	msg.chunk = chunkHeader{}
+	return msg, true
+}
+
+func (t *transfer) add(msg Message, limit int64) error {
+	h := msg.chunk
+	if msg.Err != nil || h.index != t.next || h.count != t.header.count || h.size != t.header.size ||
+		msg.Namespace != t.namespace || msg.Event != t.event {
+		return ErrIncompleteTransfer
+	}
+
+	if limit <= 0 {
+		limit = DefaultMaxTransferSize
+	}
+
+	if h.size > limit {
+		return ErrMessageTooLarge
+	}
+
+	if t.body == nil {
+		t.body = new(bytes.Buffer)
+		t.body.Grow(int(h.size))
+	}
+
+	if int64(t.body.Len()+len(msg.Body)) > h.size {
+		return ErrIncompleteTransfer
+	}
+
+	t.body.Write(msg.Body)
+	t.next++
+	if t.next == h.count && int64(t.body.Len()) != h.size {
+		return ErrIncompleteTransfer
+	}
+
+	return nil
+}
+
+// dropTransfer removes the "t" transfer, it reports false if it was already removed.
+func (c *Conn) dropTransfer(id string, t *transfer) bool {
+	c.transfersMutex.Lock()
+	defer c.transfersMutex.Unlock()
+
+	if c.transfers[id] != t {
+		return false
+	}
+
+	delete(c.transfers, id)
+	return true
+}
+
+func (c *Conn) failTransfer(t *transfer, err error) {
+	c.fireError(err)
+
+	if ns := c.Namespace(t.namespace); ns != nil {
+		ns.fireEvent(Message{Namespace: t.namespace, Event: t.event, Err: err, IsLocal: true})
+	}
+}
+
+// dropTransfers removes all the incoming transfers, called on `Close`.
+func (c *Conn) dropTransfers() {
+	c.transfersMutex.Lock()
+	for id, t := range c.transfers {
+		t.timer.Stop()
+		delete(c.transfers, id)
+	}
+	c.transfersMutex.Unlock()
+}
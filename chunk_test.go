@@ -0,0 +1,149 @@
+package neffos
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestChunkHeader(t *testing.T) {
+	h := chunkHeader{id: "1a", index: 2, count: 3, size: 1024}
+	got, ok := parseChunkHeader(h.String())
+	if !ok || got != h {
+		t.Fatalf("expected header: %#+v but got: %#+v", h, got)
+	}
+
+	for _, invalid := range []string{"", "1a", ".0.1.10", "1a.1.1.10", "1a.-1.1.10", "1a.0.1.-10", "1a.0.x.10"} {
+		if _, ok := parseChunkHeader(invalid); ok {
+			t.Fatalf("expected header: %q to be invalid", invalid)
+		}
+	}
+}
+
+func newChunkTestConn(t *testing.T, maxTransferSize int64, transferTimeout time.Duration) (*Conn, chan Message) {
+	t.Helper()
+
+	received := make(chan Message, 8)
+	events := Events{
+		"file": func(ns *NSConn, msg Message) error {
+			received <- msg
+			return nil
+		},
+	}
+
+	c := newConn(new(recordSocket), Namespaces{"default": events})
+	c.connectedNamespaces["default"] = newNSConn(c, "default", events)
+	c.maxTransferSize = maxTransferSize
+	c.transferTimeout = transferTimeout
+	c.readiness.unwait(nil)
+	return c, received
+}
+
+func TestChunkReassemble(t *testing.T) {
+	c, received := newChunkTestConn(t, 0, 0)
+	header := chunkHeader{id: "1", count: 3, size: 7}
+
+	for i, body := range []string{"abc", "de", "fg"} {
+		header.index = i
+		if err := c.handleMessage(Message{Namespace: "default", Event: "file", Body: []byte(body), chunk: header}); err != nil {
+			t.Fatal(err)
+		}
+
+		if i < header.count-1 && len(received) > 0 {
+			t.Fatalf("[%d] expected event callback to be fired only after the last chunk", i)
+		}
+	}
+
+	msg := <-received
+	if msg.Err != nil || string(msg.Body) != "abcdefg" {
+		t.Fatalf("expected complete body but got: %q (%v)", msg.Body, msg.Err)
+	}
+
+	if len(c.transfers) != 0 {
+		t.Fatalf("expected completed transfer to be removed")
+	}
+}
+
+func TestChunkReassembleFailures(t *testing.T) {
+	var tests = []struct {
+		name   string
+		chunks []Message
+		err    error
+	}{
+		{"out of order", []Message{
+			{Body: []byte("ab"), chunk: chunkHeader{id: "1", index: 0, count: 3, size: 6}},
+			{Body: []byte("ef"), chunk: chunkHeader{id: "1", index: 2, count: 3, size: 6}},
+		}, ErrIncompleteTransfer},
+		{"aborted", []Message{
+			{Body: []byte("ab"), chunk: chunkHeader{id: "1", index: 0, count: 2, size: 4}},
+			{Err: ErrIncompleteTransfer, chunk: chunkHeader{id: "1", index: 1, count: 2, size: 4}},
+		}, ErrIncompleteTransfer},
+		{"larger than its size", []Message{
+			{Body: []byte("abc"), chunk: chunkHeader{id: "1", index: 0, count: 1, size: 2}},
+		}, ErrIncompleteTransfer},
+		{"larger than the limit", []Message{
+			{Body: []byte("ab"), chunk: chunkHeader{id: "1", index: 0, count: 2, size: 2048}},
+		}, ErrMessageTooLarge},
+	}
+
+	for _, tt := range tests {
+		c, received := newChunkTestConn(t, 1024, 0)
+		for _, chunk := range tt.chunks {
+			chunk.Namespace = "default"
+			chunk.Event = "file"
+			c.handleMessage(chunk)
+		}
+
+		select {
+		case msg := <-received:
+			if msg.Err != tt.err {
+				t.Fatalf("[%s] expected error: %v but got: %v", tt.name, tt.err, msg.Err)
+			}
+		default:
+			t.Fatalf("[%s] expected event callback to be fired with the error", tt.name)
+		}
+
+		if len(c.transfers) != 0 {
+			t.Fatalf("[%s] expected failed transfer to be removed", tt.name)
+		}
+	}
+}
+
+func TestChunkReassembleTimeout(t *testing.T) {
+	c, received := newChunkTestConn(t, 0, 50*time.Millisecond)
+	c.handleMessage(Message{Namespace: "default", Event: "file", Body: []byte("ab"), chunk: chunkHeader{id: "1", count: 2, size: 4}})
+
+	select {
+	case msg := <-received:
+		if msg.Err != ErrIncompleteTransfer {
+			t.Fatalf("expected error: %v but got: %v", ErrIncompleteTransfer, msg.Err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected incomplete transfer to time out")
+	}
+
+	// a late chunk of the dropped transfer.
+	c.handleMessage(Message{Namespace: "default", Event: "file", Body: []byte("cd"), chunk: chunkHeader{id: "1", index: 1, count: 2, size: 4}})
+	if msg := <-received; msg.Err != ErrIncompleteTransfer {
+		t.Fatalf("expected late chunk to fail with: %v but got: %v", ErrIncompleteTransfer, msg.Err)
+	}
+}
+
+func TestEmitLargeShortReader(t *testing.T) {
+	c, _ := newChunkTestConn(t, 0, 0)
+	c.chunkSize = 4
+	socket := c.socket.(*recordSocket)
+
+	err := c.Namespace("default").EmitLarge("file", bytes.NewReader([]byte("abcdef")), 10)
+	if err == nil {
+		t.Fatalf("expected an error when the reader returns less than the total size")
+	}
+
+	if expected, got := 2, len(socket.written); expected != got {
+		t.Fatalf("expected %d written messages (a chunk and the abort one) but got %d", expected, got)
+	}
+
+	if msg := DeserializeMessage(TextMessage, socket.written[1], false, false); msg.Err == nil || msg.chunk.id == "" {
+		t.Fatalf("expected last message to abort the transfer but got: %#+v", msg)
+	}
+}
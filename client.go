@@ -3,6 +3,7 @@ package neffos
 import (
 	"context"
 	"strings"
+	"time"
 )
 
 // Client is the neffos client. Contains the neffos client-side connection
@@ -53,25 +54,170 @@ func (c *Client) Connect(ctx context.Context, namespace string) (*NSConn, error)
 	return c.conn.Connect(ctx, namespace)
 }
 
+// Metrics returns a snapshot of the client's counters.
+func (c *Client) Metrics() Metrics {
+	m := c.conn.counters.snapshot()
+	m.HandlerPool = handlerPoolStats(c.conn.namespaces)
+	return m
+}
+
 // Dialer is the definition type of a dialer, gorilla or gobwas or custom.
 // It is the second parameter of the `Dial` function.
 type Dialer func(ctx context.Context, url string) (Socket, error)
 
+// DialOption is the type of the optional input arguments of the `Dial` function.
+// It configures the client-side connection before its acknowledgement process.
+type DialOption func(c *Conn)
+
+// WithExpiryTolerance is a `DialOption` which sets the clock skew tolerance
+// between the client and the server when checking a `Message.Expiry`.
+// See `Server.ExpiryTolerance` too.
+func WithExpiryTolerance(tolerance time.Duration) DialOption {
+	return func(c *Conn) {
+		c.expiryTolerance = tolerance
+	}
+}
+
+// WithStampSentAt is a `DialOption` which fills the `Message.SentAt`
+// of the messages written by the client connection, unless it is already set.
+// See `Server.StampSentAt` too.
+func WithStampSentAt() DialOption {
+	return func(c *Conn) {
+		c.stampSentAt = true
+	}
+}
+
+// WithHeartbeat is a `DialOption` which makes the client send a heartbeat ping every "interval",
+// in the same format as the neffos.js client, so a server can tell a live but quiet connection apart.
+// The server answers with a pong, see `HeartbeatPing` and `Conn#LastActivity`.
+func WithHeartbeat(interval time.Duration) DialOption {
+	return func(c *Conn) {
+		c.heartbeatInterval = interval
+	}
+}
+
+// WithClock is a `DialOption` which sets the source of the time of the client connection, see `Clock`.
+// See `Server.Clock` too.
+func WithClock(clock Clock) DialOption {
+	return func(c *Conn) {
+		c.clock = clockOrSystem(clock)
+	}
+}
+
+// WithOnBeforeEvent is a `DialOption` which registers the "fn" to be called
+// before the callback of each event of the client connection.
+// See `Server.OnBeforeEvent` too.
+func WithOnBeforeEvent(fn func(ns *NSConn, msg Message) error) DialOption {
+	return func(c *Conn) {
+		c.onBeforeEvent = fn
+	}
+}
+
+// WithOnAfterEvent is a `DialOption` which registers the "fn" to be called
+// after the callback of each event of the client connection.
+// See `Server.OnAfterEvent` too.
+func WithOnAfterEvent(fn func(ns *NSConn, msg Message, dur time.Duration, err error)) DialOption {
+	return func(c *Conn) {
+		c.onAfterEvent = fn
+	}
+}
+
+// WithOnMessageProcessed is a `DialOption` which registers the "fn" to be called
+// after each incoming message of the client connection is handled.
+// See `Server.OnMessageProcessed` too.
+func WithOnMessageProcessed(fn func(c *Conn, msg Message, dur time.Duration, err error)) DialOption {
+	return func(c *Conn) {
+		c.onMessageProcessed = fn
+	}
+}
+
+// WithNativeUnknownNamespaces is a `DialOption` which fires the incoming messages of a namespace which is not registered
+// as native messages on the mixed mode. See `Server.NativeUnknownNamespaces` too.
+func WithNativeUnknownNamespaces() DialOption {
+	return func(c *Conn) {
+		c.nativeUnknownNamespaces = true
+	}
+}
+
+// WithGenerateTraceID is a `DialOption` which generates the `Message.TraceID`
+// of the messages written by the client connection, unless it is already set or inherited.
+// See `Server.GenerateTraceID` too.
+func WithGenerateTraceID() DialOption {
+	return func(c *Conn) {
+		c.generateTraceID = true
+	}
+}
+
+// WithCompression is a `DialOption` which enables the application-level compression
+// of the message bodies which are larger than "threshold" bytes.
+// It's used only if the server enables it too, see `Server#SetCompression`.
+func WithCompression(threshold int) DialOption {
+	return func(c *Conn) {
+		c.compressionThreshold = threshold
+	}
+}
+
+// WithChunking is a `DialOption` which sets the size in bytes of the chunks of the `NSConn#EmitLarge`
+// and the maximum total size and duration of an incoming chunked transfer.
+// See `Server#SetChunking` too.
+func WithChunking(chunkSize int, maxTransferSize int64, transferTimeout time.Duration) DialOption {
+	return func(c *Conn) {
+		c.chunkSize = chunkSize
+		c.maxTransferSize = maxTransferSize
+		c.transferTimeout = transferTimeout
+	}
+}
+
+// WithMaxMessageSize is a `DialOption` which sets the maximum size in bytes of an incoming message.
+// See `Server#SetMaxMessageSize` too.
+func WithMaxMessageSize(bytes int64) DialOption {
+	return func(c *Conn) {
+		c.maxMessageSize = bytes
+	}
+}
+
+// WithWriteBuffer is a `DialOption` which enables the buffering of the written messages, up to "size" bytes.
+// See `Server#SetWriteBuffer` too.
+func WithWriteBuffer(size int, maxLatency time.Duration) DialOption {
+	return func(c *Conn) {
+		c.writeBufferSize = size
+		c.writeBufferMaxLatency = maxLatency
+	}
+}
+
+// WithMessageLimits is a `DialOption` which sets the limits of the fields of the incoming messages.
+// See `Server#SetMessageLimits` too.
+func WithMessageLimits(limits MessageLimits) DialOption {
+	return func(c *Conn) {
+		c.messageLimits = limits
+	}
+}
+
+// WithCodec is a `DialOption` which sets the `MessageCodec` of the client connection.
+// It should match the server's one, see `Server.Codec`.
+func WithCodec(codec MessageCodec) DialOption {
+	return func(c *Conn) {
+		c.codec = codec
+	}
+}
+
 // Dial establishes a new neffos client connection.
 // Context "ctx" is used for handshake timeout.
 // Dialer "dial" can be either `gobwas.Dialer/DefaultDialer` or `gorilla.Dialer/DefaultDialer`,
 // custom dialers can be used as well when complete the `Socket` and `Dialer` interfaces for valid client.
-// URL "url" is the endpoint of the neffos server, i.e "ws://localhost:8080/echo".
+// URL "url" is the endpoint of the neffos server, i.e "ws://localhost:8080/echo",
+// or a unix domain socket one, i.e "unix:///run/app.sock:/echo", see `ParseUnixURL`.
 // The last parameter, and the most important one is the "connHandler", it can be
 // filled as `Namespaces`, `Events` or `WithTimeout`, same namespaces and events can be used on the server-side as well.
+// Optional "options" can be passed to customize the client-side connection, e.g. `WithExpiryTolerance`.
 //
 // See examples for more.
-func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler) (*Client, error) {
+func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler, options ...DialOption) (*Client, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
-	if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+	if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") && !strings.HasPrefix(url, UnixScheme) {
 		url = "ws://" + url
 	}
 
@@ -84,16 +230,31 @@ func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler)
 		connHandler = Namespaces{}
 	}
 
-	c := newConn(underline, connHandler.GetNamespaces())
+	namespaces := connHandler.GetNamespaces()
+	namespaces.makeLive()
+	c := newConn(underline, namespaces)
+	c.tlsState = socketTLSState(underline.Request(), underline.NetConn())
 	readTimeout, writeTimeout := getTimeouts(connHandler)
 	c.readTimeout = readTimeout
 	c.writeTimeout = writeTimeout
 
+	for _, opt := range options {
+		if opt != nil {
+			opt(c)
+		}
+	}
+	// the time of its creation follows the clock of the `WithClock`, if any.
+	c.touch()
+
 	go c.startReader()
 
 	if err = c.sendClientACK(); err != nil {
 		return nil, err
 	}
 
-	return &Client{conn: c, ID: c.id, NotifyClose: c.closeCh}, nil
+	if c.heartbeatInterval > 0 {
+		go c.startHeartbeat(c.heartbeatInterval)
+	}
+
+	return &Client{conn: c, ID: c.ID(), NotifyClose: c.closeCh}, nil
 }
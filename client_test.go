@@ -8,16 +8,46 @@ import (
 
 	gobwas "github.com/kataras/neffos/gobwas"
 	gorilla "github.com/kataras/neffos/gorilla"
+	nhooyr "github.com/kataras/neffos/nhooyr"
+	tcp "github.com/kataras/neffos/tcp"
 )
 
-func runTestClient(addr string, connHandler neffos.ConnHandler, testFn func(string, *neffos.Client)) func() error {
-	gobwasClient, err := neffos.Dial(context.TODO(), gobwas.DefaultDialer, fmt.Sprintf("ws://%s/gobwas", addr), connHandler)
+func runTestClient(addr string, connHandler neffos.ConnHandler, testFn func(string, *neffos.Client), options ...neffos.DialOption) func() error {
+	gobwasClient, err := neffos.Dial(context.TODO(), gobwas.DefaultDialer, fmt.Sprintf("ws://%s/gobwas", addr), connHandler, options...)
 	if err != nil {
 		return func() error {
 			return err
 		}
 	}
-	gorillaClient, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, fmt.Sprintf("ws://%s/gorilla", addr), connHandler)
+	gorillaClient, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, fmt.Sprintf("ws://%s/gorilla", addr), connHandler, options...)
+	if err != nil {
+		return func() error {
+			return err
+		}
+	}
+
+	nhooyrClient, err := neffos.Dial(context.TODO(), nhooyr.DefaultDialer, fmt.Sprintf("ws://%s/nhooyr", addr), connHandler, options...)
+	if err != nil {
+		return func() error {
+			return err
+		}
+	}
+
+	tcpClient, err := neffos.Dial(context.TODO(), tcp.DefaultDialer, testTCPAddr(addr), connHandler, options...)
+	if err != nil {
+		return func() error {
+			return err
+		}
+	}
+
+	pipeServer, ok := testPipeServers.Load(addr)
+	if !ok {
+		return func() error {
+			return fmt.Errorf("no pipe server at: %s", addr)
+		}
+	}
+
+	pipeClient, err := neffos.Dial(context.TODO(), neffos.PipeDialer(pipeServer.(*neffos.Server)), "/pipe", connHandler, options...)
 	if err != nil {
 		return func() error {
 			return err
@@ -28,10 +58,16 @@ func runTestClient(addr string, connHandler neffos.ConnHandler, testFn func(stri
 	teardown := func() error {
 		gobwasClient.Close()
 		gorillaClient.Close()
+		nhooyrClient.Close()
+		tcpClient.Close()
+		pipeClient.Close()
 		return nil
 	}
 
 	testFn("gobwas", gobwasClient)
 	testFn("gorilla", gorillaClient)
+	testFn("nhooyr", nhooyrClient)
+	testFn("tcp", tcpClient)
+	testFn("pipe", pipeClient)
 	return teardown
 }
@@ -0,0 +1,50 @@
+package neffos
+
+import "time"
+
+// Clock is the source of the time of the time-based behavior of the connections:
+// the ack wait of the `Conn#Connect`, the deadline check of the `Conn#Ask`, the heartbeats,
+// the `Conn#LastActivity`, the durations of the `Server.OnMessageProcessed` and `Server.OnAfterEvent`,
+// the rate limits and the read and write timeouts of the pipe sockets.
+// The server's one is the clock of the expiration of its `Ask` wait tokens too, see `Server#SetWaitingMessagesTTL`.
+//
+// It defaults to the `SystemClock`, a fake one makes their tests deterministic,
+// see the `neffostest.Clock`, `Server.Clock` and `WithClock`.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer returns a `Timer` which fires once, after "d".
+	NewTimer(d time.Duration) Timer
+	// Sleep blocks for "d".
+	Sleep(d time.Duration)
+}
+
+// Timer is the timer of a `Clock`, like the `time.Timer`.
+type Timer interface {
+	// C returns the channel which receives the time when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, it reports false if it has fired or stopped already.
+	Stop() bool
+}
+
+// SystemClock is the `Clock` of the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                 { return time.Now() }
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+func (systemClock) Sleep(d time.Duration)          { time.Sleep(d) }
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// clockOrSystem returns the "clock" or the `SystemClock` if it's nil.
+func clockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+
+	return clock
+}
@@ -0,0 +1,33 @@
+package neffos
+
+import (
+	"errors"
+)
+
+// MessageCodec describes a codec which encodes and decodes objects to and from a `Message.Body`.
+// Register a codec through the `Server.Codec` field and the `WithCodec` dial option,
+// then use the `Conn#Marshal` and `Message#Unmarshal` methods.
+//
+// See the "codec/msgpack" sub-package for a MessagePack implementation.
+type MessageCodec interface {
+	// Name is sent by the client to the server on the handshake,
+	// server and client should use codecs with the same name.
+	Name() string
+	// Marshal returns the encoded "v" value.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes the "data" into the "outPtr".
+	Unmarshal(data []byte, outPtr interface{}) error
+}
+
+// ErrCodecMismatch is returned from the `Dial` function when
+// the client's codec does not match the server's one, see `MessageCodec`.
+var ErrCodecMismatch = errors.New("codec mismatch")
+
+// codecName returns the name of the "codec", empty for the default one.
+func codecName(codec MessageCodec) string {
+	if codec == nil {
+		return ""
+	}
+
+	return codec.Name()
+}
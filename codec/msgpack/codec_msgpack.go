@@ -0,0 +1,40 @@
+package msgpack
+
+import (
+	"github.com/kataras/neffos"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Name is the name of the MessagePack codec, exchanged on the handshake.
+const Name = "msgpack"
+
+// Codec is a `neffos.MessageCodec` for MessagePack
+// based on https://github.com/vmihailenco/msgpack.
+//
+// Usage:
+// server.Codec = msgpack.New()
+// neffos.Dial(ctx, dialer, url, namespaces, neffos.WithCodec(msgpack.New()))
+type Codec struct{}
+
+var _ neffos.MessageCodec = (*Codec)(nil)
+
+// New returns a new MessagePack codec.
+func New() *Codec {
+	return new(Codec)
+}
+
+// Name returns the "msgpack".
+func (*Codec) Name() string {
+	return Name
+}
+
+// Marshal returns the MessagePack encoding of "v".
+func (*Codec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal decodes the MessagePack-encoded "data" into the "outPtr".
+func (*Codec) Unmarshal(data []byte, outPtr interface{}) error {
+	return msgpack.Unmarshal(data, outPtr)
+}
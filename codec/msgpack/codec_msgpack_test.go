@@ -0,0 +1,190 @@
+package msgpack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+)
+
+type (
+	author struct {
+		Name   string
+		Avatar []byte
+	}
+
+	post struct {
+		ID        int64
+		Title     string
+		Tags      []string
+		Author    author
+		Payload   []byte
+		CreatedAt time.Time
+		Meta      map[string]string
+	}
+)
+
+func newPost() post {
+	return post{
+		ID:        42,
+		Title:     "MessagePack and neffos",
+		Tags:      []string{"go", "websocket", "msgpack"},
+		Author:    author{Name: "neffos", Avatar: []byte{0x89, 'P', 'N', 'G', 0x00, ';'}},
+		Payload:   bytes.Repeat([]byte{0x00, 0xff, ';'}, 64),
+		CreatedAt: time.Date(2020, time.May, 18, 10, 30, 15, 123456789, time.UTC),
+		Meta:      map[string]string{"lang": "en", "draft": "false"},
+	}
+}
+
+func testPost(t *testing.T, expected, got post) {
+	t.Helper()
+
+	if !got.CreatedAt.Equal(expected.CreatedAt) {
+		t.Fatalf("expected time: %s but got: %s", expected.CreatedAt, got.CreatedAt)
+	}
+	got.CreatedAt = expected.CreatedAt
+
+	if g, e := got.Author.Name, expected.Author.Name; g != e {
+		t.Fatalf("expected nested author: %s but got: %s", e, g)
+	}
+
+	if !bytes.Equal(got.Author.Avatar, expected.Author.Avatar) || !bytes.Equal(got.Payload, expected.Payload) {
+		t.Fatalf("expected byte fields to match")
+	}
+
+	if got.ID != expected.ID || got.Title != expected.Title ||
+		strings.Join(got.Tags, ",") != strings.Join(expected.Tags, ",") ||
+		len(got.Meta) != len(expected.Meta) || got.Meta["lang"] != expected.Meta["lang"] {
+		t.Fatalf("expected: %#+v but got: %#+v", expected, got)
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec := New()
+	expected := newPost()
+
+	b, err := codec.Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got post
+	if err = codec.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	testPost(t, expected, got)
+}
+
+func TestCodecWire(t *testing.T) {
+	var (
+		namespace = "default"
+		expected  = newPost()
+		events    = neffos.Namespaces{namespace: neffos.Events{
+			"post": func(c *neffos.NSConn, msg neffos.Message) error {
+				var p post
+				if err := msg.Unmarshal(&p); err != nil {
+					return err
+				}
+
+				p.ID++
+				return neffos.Reply(c.Conn.Marshal(p))
+			},
+		}}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	server.Codec = New()
+	defer server.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	url := strings.Replace(httpServer.URL, "http", "ws", 1)
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, neffos.Namespaces{namespace: neffos.Events{}}, neffos.WithCodec(New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := c.Ask(context.TODO(), "post", c.Conn.Marshal(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got post
+	if err = response.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected.ID++
+	testPost(t, expected, got)
+
+	// a client without the same codec should fail on dial.
+	_, err = neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, neffos.Namespaces{namespace: neffos.Events{}})
+	if err != neffos.ErrCodecMismatch {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrCodecMismatch, err)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	codec := New()
+	p := newPost()
+
+	b.Run("msgpack", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := codec.Marshal(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	codec := New()
+	p := newPost()
+	msgpackData, _ := codec.Marshal(p)
+	jsonData, _ := json.Marshal(p)
+
+	b.Run("msgpack", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var got post
+			if err := codec.Unmarshal(msgpackData, &got); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var got post
+			if err := json.Unmarshal(jsonData, &got); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
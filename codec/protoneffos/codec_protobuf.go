@@ -0,0 +1,56 @@
+package protoneffos
+
+import (
+	"errors"
+
+	"github.com/kataras/neffos"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Name is the name of the protocol buffers codec, exchanged on the handshake.
+const Name = "protobuf"
+
+// ErrNotProtoMessage is returned from the `Codec` methods
+// when the given value does not implement the `proto.Message`.
+var ErrNotProtoMessage = errors.New("value is not a proto.Message")
+
+// Codec is a `neffos.MessageCodec` for protocol buffers
+// based on https://pkg.go.dev/google.golang.org/protobuf.
+//
+// Usage:
+// server.Codec = protoneffos.New()
+// neffos.Dial(ctx, dialer, url, namespaces, neffos.WithCodec(protoneffos.New()))
+type Codec struct{}
+
+var _ neffos.MessageCodec = (*Codec)(nil)
+
+// New returns a new protocol buffers codec.
+func New() *Codec {
+	return new(Codec)
+}
+
+// Name returns the "protobuf".
+func (*Codec) Name() string {
+	return Name
+}
+
+// Marshal returns the wire-format encoding of "v", which must be a `proto.Message`.
+func (*Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+
+	return proto.Marshal(m)
+}
+
+// Unmarshal parses the wire-format "data" into the "outPtr", which must be a `proto.Message`.
+func (*Codec) Unmarshal(data []byte, outPtr interface{}) error {
+	m, ok := outPtr.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+
+	return proto.Unmarshal(data, m)
+}
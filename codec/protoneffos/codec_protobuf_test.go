@@ -0,0 +1,145 @@
+package protoneffos
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/codec/protoneffos/internal/testpb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// memSocket is an in-memory `neffos.Socket`, see `newMemSockets`.
+type memSocket struct {
+	in, out   chan []byte
+	closed    chan struct{}
+	closeOnce *sync.Once
+	r         *http.Request
+}
+
+// newMemSockets returns two connected in-memory sockets,
+// the first is for the server and the second for the client.
+func newMemSockets() (*memSocket, *memSocket) {
+	var (
+		serverToClient = make(chan []byte, 64)
+		clientToServer = make(chan []byte, 64)
+		closed         = make(chan struct{})
+		closeOnce      = new(sync.Once)
+		r              = httptest.NewRequest(http.MethodGet, "/", nil)
+	)
+
+	return &memSocket{in: clientToServer, out: serverToClient, closed: closed, closeOnce: closeOnce, r: r},
+		&memSocket{in: serverToClient, out: clientToServer, closed: closed, closeOnce: closeOnce, r: r}
+}
+
+func (s *memSocket) NetConn() net.Conn { return s }
+
+func (s *memSocket) Request() *http.Request { return s.r }
+
+func (s *memSocket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
+	select {
+	case b := <-s.in:
+		return b, neffos.BinaryMessage, nil
+	case <-s.closed:
+		return nil, 0, io.EOF
+	}
+}
+
+func (s *memSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	select {
+	case s.out <- append([]byte(nil), body...):
+		return nil
+	case <-s.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (s *memSocket) WriteText(body []byte, timeout time.Duration) error {
+	return s.WriteBinary(body, timeout)
+}
+
+// net.Conn, only Close is used by neffos.
+func (s *memSocket) Read(b []byte) (int, error)         { return 0, io.EOF }
+func (s *memSocket) Write(b []byte) (int, error)        { return len(b), nil }
+func (s *memSocket) LocalAddr() net.Addr                { return nil }
+func (s *memSocket) RemoteAddr() net.Addr               { return nil }
+func (s *memSocket) SetDeadline(t time.Time) error      { return nil }
+func (s *memSocket) SetReadDeadline(t time.Time) error  { return nil }
+func (s *memSocket) SetWriteDeadline(t time.Time) error { return nil }
+func (s *memSocket) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+func runMemConn(t *testing.T, serverEvents, clientEvents neffos.Events, configureServer func(*neffos.Server)) (*neffos.NSConn, func()) {
+	t.Helper()
+
+	serverSocket, clientSocket := newMemSockets()
+
+	server := neffos.New(func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
+		return serverSocket, nil
+	}, neffos.Namespaces{"default": serverEvents})
+	server.Codec = New()
+	if configureServer != nil {
+		configureServer(server)
+	}
+
+	go server.Upgrade(httptest.NewRecorder(), serverSocket.r, nil, nil)
+
+	client, err := neffos.Dial(context.TODO(), func(ctx context.Context, url string) (neffos.Socket, error) {
+		return clientSocket, nil
+	}, "mem", neffos.Namespaces{"default": clientEvents}, neffos.WithCodec(New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.Connect(context.TODO(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, func() {
+		client.Close()
+		server.Close()
+	}
+}
+
+func newChatMessage() *testpb.ChatMessage {
+	return &testpb.ChatMessage{
+		Author:     &testpb.Author{Username: "neffos", Avatar: []byte{0x89, 'P', 'N', 'G', ';'}},
+		Text:       "hello; protobuf",
+		Attachment: bytes.Repeat([]byte{0x00, ';', 0xff}, 32),
+		Tags:       []string{"go", "protobuf"},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec := New()
+	expected := newChatMessage()
+
+	b, err := codec.Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(testpb.ChatMessage)
+	if err = codec.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !proto.Equal(expected, got) {
+		t.Fatalf("expected: %v but got: %v", expected, got)
+	}
+
+	if _, err = codec.Marshal(struct{}{}); err != ErrNotProtoMessage {
+		t.Fatalf("expected error: %v but got: %v", ErrNotProtoMessage, err)
+	}
+}
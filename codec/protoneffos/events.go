@@ -0,0 +1,71 @@
+//go:build go1.18
+
+package protoneffos
+
+import (
+	"fmt"
+
+	"github.com/kataras/neffos"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DecodeError is returned, and fired on the `neffos.Server.OnError`,
+// when the body of an incoming message could not be decoded to the event's `proto.Message`.
+type DecodeError struct {
+	Namespace string
+	Event     string
+	Err       error
+}
+
+// Error returns the text of the decode error, including the namespace and the event.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("protoneffos: decode %s:%s: %v", e.Namespace, e.Event, e.Err)
+}
+
+// Unwrap returns the underline protobuf error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// On registers a typed event callback "fn" to the "events".
+// The incoming `neffos.Message.Body` is decoded to a new "T" before "fn" is called,
+// remote errors (`neffos.Message.Err`) are returned to the caller as they are and "fn" is not called.
+// Use the `Reply` function to answer an `Ask` with a `proto.Message`.
+//
+// Example:
+//
+//	protoneffos.On(events, "chat", func(c *neffos.NSConn, msg *pb.ChatMessage) error {
+//		return protoneffos.Reply(&pb.ChatReply{Text: msg.Text})
+//	})
+func On[T proto.Message](events neffos.Events, event string, fn func(*neffos.NSConn, T) error) {
+	var zero T
+	newT := func() T {
+		return zero.ProtoReflect().New().Interface().(T)
+	}
+
+	events.On(event, func(c *neffos.NSConn, msg neffos.Message) error {
+		if msg.Err != nil {
+			return msg.Err
+		}
+
+		v := newT()
+		if err := proto.Unmarshal(msg.Body, v); err != nil {
+			// the server fires its OnError with it, wrapped by a `neffos.EventError`.
+			return &DecodeError{Namespace: msg.Namespace, Event: msg.Event, Err: err}
+		}
+
+		return fn(c, v)
+	})
+}
+
+// Reply returns a `neffos.Reply` with the encoded "v" as its body,
+// or the encoding error.
+func Reply(v proto.Message) error {
+	body, err := proto.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return neffos.Reply(body)
+}
@@ -0,0 +1,80 @@
+//go:build go1.18
+
+package protoneffos
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/codec/protoneffos/internal/testpb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOn(t *testing.T) {
+	var (
+		expected = newChatMessage()
+		received = make(chan *testpb.ChatMessage, 1)
+		errs     = make(chan error, 1)
+	)
+
+	serverEvents := make(neffos.Events)
+	On(serverEvents, "chat", func(c *neffos.NSConn, msg *testpb.ChatMessage) error {
+		received <- msg
+		return Reply(&testpb.ChatReply{Id: 1, Text: msg.Text})
+	})
+
+	c, teardown := runMemConn(t, serverEvents, neffos.Events{}, func(server *neffos.Server) {
+		server.OnError = func(c *neffos.Conn, err error) {
+			errs <- err
+		}
+	})
+	defer teardown()
+
+	response, err := c.Ask(context.TODO(), "chat", c.Conn.Marshal(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-received; !proto.Equal(expected, got) {
+		t.Fatalf("expected handler input: %v but got: %v", expected, got)
+	}
+
+	reply := new(testpb.ChatReply)
+	if err = response.Unmarshal(reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Id != 1 || reply.Text != expected.Text {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+
+	// invalid wire data, the handler should not be called.
+	_, err = c.Ask(context.TODO(), "chat", []byte{0xff, 0xff, 0xff})
+	if err == nil {
+		t.Fatalf("expected a decode error")
+	}
+
+	select {
+	case err = <-errs:
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("expected a decode error but got: %#+v", err)
+		}
+
+		if decodeErr.Namespace != "default" || decodeErr.Event != "chat" {
+			t.Fatalf("expected decode error with event context but got: %s:%s", decodeErr.Namespace, decodeErr.Event)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the decode error to be fired on the server's OnError")
+	}
+
+	select {
+	case msg := <-received:
+		t.Fatalf("handler should not be called on decode failure but got: %v", msg)
+	default:
+	}
+}
@@ -0,0 +1,108 @@
+package neffos
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Application-level compression of the message bodies,
+// for peers which do not support the websocket permessage-deflate extension.
+// It is negotiated on the acknowledgement process: the client appends its capabilities
+// to the ack message, e.g. "M?z" or "Mmsgpack?z", and the server replies with the enabled ones,
+// e.g. "A<id>?z", so old peers never receive compressed bodies.
+// A compressed body is marked by the `extensionCompressed` message extension.
+// See `Server#SetCompression` and `WithCompression`.
+const (
+	ackCapabilitiesSeparator = '?'
+	capabilityCompression    = "z"
+)
+
+// splitACK splits the rest of an ack message to its value (the codec name or the connection ID)
+// and its capabilities, reports false if no capabilities were sent.
+func splitACK(b []byte) (string, string, bool) {
+	s := string(b)
+	if idx := strings.LastIndexByte(s, ackCapabilitiesSeparator); idx >= 0 {
+		return s[:idx], s[idx+1:], true
+	}
+
+	return s, "", false
+}
+
+func hasCapability(capabilities, capability string) bool {
+	for _, c := range strings.Split(capabilities, ",") {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the compression level of the message bodies, speed matters more than the size on a live connection.
+const compressionLevel = flate.BestSpeed
+
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, compressionLevel)
+		return w
+	},
+}
+
+func compressBody(body []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Grow(len(body) / 4)
+
+	w := flateWriterPool.Get().(*flate.Writer)
+	w.Reset(buf)
+	w.Write(body)
+	w.Close()
+	flateWriterPool.Put(w)
+
+	return buf.Bytes()
+}
+
+// decompressBody returns the decompressed "body",
+// if "limit" is positive and the result exceeds it then it returns the `ErrMessageTooLarge`.
+// DefaultMaxDecompressedSize is the maximum size of a decompressed body when the max message size is not set,
+// so a small compressed message can not expand without bounds, a zero or negative one disables it.
+// See `Server#SetMaxMessageSize` and `WithMaxMessageSize`.
+var DefaultMaxDecompressedSize int64 = 64 * 1024 * 1024
+
+func decompressBody(body []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(body))
+	defer r.Close()
+
+	if limit <= 0 {
+		if limit = DefaultMaxDecompressedSize; limit <= 0 {
+			return ioutil.ReadAll(r)
+		}
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err == nil && int64(len(b)) > limit {
+		err = ErrMessageTooLarge
+	}
+
+	return b, err
+}
+
+// decompressMessage decompresses the body of a compressed "msg",
+// the message is marked as invalid on failure.
+func decompressMessage(msg *Message, limit int64) {
+	if !msg.compressed {
+		return
+	}
+
+	body, err := decompressBody(msg.Body, limit)
+	if err != nil {
+		msg.isInvalid = true
+		body = nil
+	}
+
+	msg.Body = body
+	msg.compressed = false
+}
@@ -0,0 +1,236 @@
+package neffos
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func newCompressionTestConn(threshold int, negotiated bool) (*Conn, *recordSocket) {
+	socket := new(recordSocket)
+	c := newConn(socket, Namespaces{"default": Events{}})
+	c.connectedNamespaces["default"] = newNSConn(c, "default", Events{})
+	c.readiness.unwait(nil)
+	c.compressionThreshold = threshold
+	if negotiated {
+		*c.compression = 1
+	}
+
+	return c, socket
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	threshold := 64
+	c, socket := newCompressionTestConn(threshold, true)
+
+	var tests = []struct {
+		body       []byte
+		compressed bool
+		smaller    bool
+	}{
+		{bytes.Repeat([]byte("a"), threshold-1), false, false},
+		{bytes.Repeat([]byte("a"), threshold), false, false},
+		{bytes.Repeat([]byte("a"), threshold+1), true, false},
+		{bytes.Repeat([]byte("a;"), threshold*10), true, true},
+	}
+
+	for i, tt := range tests {
+		if !c.Write(Message{Namespace: "default", Event: "chat", Body: tt.body}) {
+			t.Fatalf("[%d] expected write to succeed", i)
+		}
+
+		written := socket.written[len(socket.written)-1]
+		if compressed := bytes.Contains(written, []byte("z=1;")); compressed != tt.compressed {
+			t.Fatalf("[%d] expected compressed: %v but got: %v for: %s", i, tt.compressed, compressed, written)
+		}
+
+		if tt.smaller && len(written) >= len(tt.body) {
+			t.Fatalf("[%d] expected written message to be smaller than its body", i)
+		}
+
+		if msg := c.DeserializeMessage(TextMessage, written); !bytes.Equal(msg.Body, tt.body) {
+			t.Fatalf("[%d] expected body: %s but got: %s", i, tt.body, msg.Body)
+		}
+	}
+}
+
+func TestCompressionNotNegotiated(t *testing.T) {
+	c, socket := newCompressionTestConn(64, false)
+	body := bytes.Repeat([]byte("a"), 1024)
+
+	c.Write(Message{Namespace: "default", Event: "chat", Body: body})
+	if written := socket.written[0]; !bytes.HasSuffix(written, body) {
+		t.Fatalf("expected a not compressed body when the remote side did not negotiate it but got: %s", written)
+	}
+}
+
+func TestCompressionDecompressLimit(t *testing.T) {
+	c, socket := newCompressionTestConn(64, true)
+	body := bytes.Repeat([]byte("a"), 4096)
+	c.Write(Message{Namespace: "default", Event: "chat", Body: body})
+
+	receiver, _ := newCompressionTestConn(0, false)
+	receiver.maxMessageSize = 1024
+	if msg := receiver.DeserializeMessage(TextMessage, socket.written[0]); !msg.isInvalid {
+		t.Fatalf("expected a decompressed body larger than the limit to be invalid")
+	}
+}
+
+func TestCompressionDefaultDecompressLimit(t *testing.T) {
+	c, socket := newCompressionTestConn(64, true)
+	c.Write(Message{Namespace: "default", Event: "chat", Body: bytes.Repeat([]byte("a"), 4096)})
+
+	defer func(limit int64) { DefaultMaxDecompressedSize = limit }(DefaultMaxDecompressedSize)
+	DefaultMaxDecompressedSize = 1024
+
+	// no max message size.
+	receiver, _ := newCompressionTestConn(0, false)
+	if msg := receiver.DeserializeMessage(TextMessage, socket.written[0]); !msg.isInvalid {
+		t.Fatalf("expected a decompressed body larger than the default limit to be invalid")
+	}
+	if msg := DeserializeMessage(TextMessage, socket.written[0], false, false); !msg.isInvalid {
+		t.Fatalf("expected a decompressed body larger than the default limit to be invalid")
+	}
+}
+
+func newCompressionBenchBody(size int) []byte {
+	buf := new(bytes.Buffer)
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(buf, `{"id":%d,"name":"widget-%d","value":%d.%d,"tags":["dashboard","snapshot"]},`, i, i%97, i*31, i%10)
+	}
+
+	return buf.Bytes()[:size]
+}
+
+func BenchmarkCompression(b *testing.B) {
+	for _, size := range []int{200 * 1024, 800 * 1024} {
+		body := newCompressionBenchBody(size)
+		compressed := compressBody(body)
+
+		b.Run(fmt.Sprintf("compress/%dKB", size/1024), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				compressBody(body)
+			}
+			b.ReportMetric(float64(len(compressed))/float64(size), "ratio")
+		})
+
+		b.Run(fmt.Sprintf("decompress/%dKB", size/1024), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := decompressBody(compressed, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestExchangeEnvelopeCompression(t *testing.T) {
+	threshold := 256
+	c := newCounters()
+	compression := &exchangeCompression{threshold: threshold, counters: c}
+
+	var tests = []struct {
+		body       []byte
+		compressed bool
+	}{
+		{[]byte("small"), false},
+		{newCompressionBenchBody(4096), true},
+		{bytes.Repeat([]byte{0x1A}, threshold), true}, // starts with the flag byte.
+	}
+
+	for i, tt := range tests {
+		expected := Message{Namespace: "default", Room: "room", Event: "chat", Body: tt.body, FromStackExchange: true}
+
+		msg := expected
+		msg.exchangeCompression = compression
+		b := msg.SerializeExchange()
+
+		version, body, err := readExchangeEnvelope(b)
+		if err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		if version != ExchangeEnvelopeVersion {
+			t.Fatalf("[%d] expected the version to be readable but got: %d", i, version)
+		}
+		if compressed := b[2] == exchangeEnvelopeCompressed; compressed != tt.compressed {
+			t.Fatalf("[%d] expected compressed: %v but got: %v", i, tt.compressed, compressed)
+		}
+		if tt.compressed && len(b) >= len(body) {
+			t.Fatalf("[%d] expected the envelope to be smaller than its body", i)
+		}
+
+		if got := DeserializeExchangeMessage(b); !bytes.Equal(got.Body, tt.body) || got.Room != expected.Room || got.isInvalid {
+			t.Fatalf("[%d] expected envelope to be:\n%#+v\n\tbut got:\n%#+v", i, expected, got)
+		}
+	}
+
+	m := c.snapshot().StackExchange
+	if m.Compressed != 2 || m.CompressedBytes == 0 || m.CompressedBytes >= m.CompressedRawBytes {
+		t.Fatalf("expected the compressed sizes of 2 envelopes but got: %d envelopes of %d raw and %d compressed bytes",
+			m.Compressed, m.CompressedRawBytes, m.CompressedBytes)
+	}
+
+	// random bodies are published as they are.
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	if b := (Message{Body: random, exchangeCompression: compression}).SerializeExchange(); b[2] == exchangeEnvelopeCompressed {
+		t.Fatalf("expected an incompressible envelope to be published uncompressed")
+	}
+
+	// rolling upgrade from servers before the versioned envelope.
+	ExchangeEnvelopeWriteVersion = 0
+	defer func() { ExchangeEnvelopeWriteVersion = ExchangeEnvelopeVersion }()
+
+	msg := Message{Namespace: "default", Event: "chat", Body: newCompressionBenchBody(4096), exchangeCompression: compression}
+	if b := msg.SerializeExchange(); !bytes.Equal(b, writeMessage(new(bytes.Buffer), msg, true)) {
+		t.Fatalf("expected a version 0 envelope to be uncompressed")
+	}
+
+	if msg := DeserializeExchangeMessage([]byte{exchangeEnvelopeMagic, ExchangeEnvelopeVersion, exchangeEnvelopeCompressed, 0xFF}); !msg.isInvalid {
+		t.Fatalf("expected a corrupted compressed envelope to be invalid")
+	}
+}
+
+// BenchmarkExchangeEnvelopeCompression measures the CPU cost of `Server.SetStackExchangeCompression`
+// against the uncompressed envelopes. On JSON bodies the compression runs at about 350MB/s
+// for 16KB and larger and its ratio is about 0.1, small bodies cost more per byte.
+func BenchmarkExchangeEnvelopeCompression(b *testing.B) {
+	for _, size := range []int{1024, 16 * 1024, 256 * 1024} {
+		msg := Message{Namespace: "default", Event: "chat", Body: newCompressionBenchBody(size)}
+		raw := msg.SerializeExchange()
+
+		compressedMsg := msg
+		compressedMsg.exchangeCompression = &exchangeCompression{threshold: 1}
+		compressed := compressedMsg.SerializeExchange()
+
+		b.Run(fmt.Sprintf("serialize/%dKB", size/1024), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				compressedMsg.SerializeExchange()
+			}
+			b.ReportMetric(float64(len(compressed))/float64(len(raw)), "ratio")
+		})
+
+		b.Run(fmt.Sprintf("serialize-raw/%dKB", size/1024), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				msg.SerializeExchange()
+			}
+		})
+
+		b.Run(fmt.Sprintf("deserialize/%dKB", size/1024), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				DeserializeExchangeMessage(compressed)
+			}
+		})
+	}
+}
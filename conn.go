@@ -1,10 +1,15 @@
 package neffos
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,11 +25,69 @@ type (
 		// ReadData reads binary or text messages from the remote connection.
 		ReadData(timeout time.Duration) (body []byte, typ MessageType, err error)
 		// WriteBinary sends a binary message to the remote connection.
+		// The "body" may be reused after the call returns, implementations should not retain it.
 		WriteBinary(body []byte, timeout time.Duration) error
 		// WriteText sends a text message to the remote connection.
+		// Like `WriteBinary`, implementations should not retain the "body".
 		WriteText(body []byte, timeout time.Duration) error
 	}
 
+	// SocketReadLimiter is an optional interface that a `Socket` can implement
+	// to limit the size of the incoming messages at the protocol level.
+	// The `ReadData` should return the `ErrMessageTooLarge` when the limit is exceeded.
+	// See `Server#SetMaxMessageSize` and `WithMaxMessageSize`.
+	SocketReadLimiter interface {
+		// SetReadLimit sets the maximum size in bytes for a message read from the remote connection.
+		SetReadLimit(limit int64)
+	}
+
+	// SocketCloser is an optional interface that a `Socket` can implement
+	// to send a close frame with a status code before the connection is terminated.
+	SocketCloser interface {
+		// WriteClose sends a close message with the "code" and "reason" to the remote connection.
+		WriteClose(code int, reason string, timeout time.Duration) error
+	}
+
+	// SocketWriteBuffer is an optional interface that a `Socket` can implement
+	// to buffer its writes, see `Server#SetWriteBuffer`, `WithWriteBuffer` and the `WriteBuffer`.
+	// A socket which buffers its writes should implement the `SocketFlusher` too.
+	SocketWriteBuffer interface {
+		// SetWriteBuffer enables the buffering of the written messages, up to "size" bytes,
+		// the buffered messages are written at the latest after the "maxLatency".
+		SetWriteBuffer(size int, maxLatency time.Duration)
+	}
+
+	// SocketFlusher is an optional interface that a `Socket` which buffers its writes can implement,
+	// the connection flushes it when the messages of a broadcast are written and before it's closed.
+	// The close messages of the `SocketCloser` should be written immediately, with the buffered ones before them.
+	SocketFlusher interface {
+		// Flush writes the buffered messages to the remote connection.
+		Flush() error
+	}
+
+	// SocketPreparedWriter is an optional interface that a `Socket` can implement
+	// to write the messages of a `Server#Broadcast` as prepared messages,
+	// which are prepared once for all of its connections, i.e compressed once with permessage-deflate.
+	SocketPreparedWriter interface {
+		// PrepareMessage prepares the text or binary "data" of a message, the prepared value
+		// is shared by the sockets of the same type. The "data" is not reused after the call.
+		PrepareMessage(data []byte, binary bool) (interface{}, error)
+		// WritePrepared sends a message prepared by the `PrepareMessage` to the remote connection.
+		WritePrepared(pm interface{}, timeout time.Duration) error
+	}
+
+	// SocketControlObserver is an optional interface that a `Socket` can implement
+	// to report the control frames it receives, see the `OnPing`, `OnPong` and `OnCloseFrame` events.
+	SocketControlObserver interface {
+		// SetControlObserver registers the "observer" which is called, on the reader goroutine,
+		// with the type and the payload of each received control frame, after the socket has handled it,
+		// i.e replied to a ping.
+		SetControlObserver(observer func(typ ControlFrameType, payload []byte))
+	}
+
+	// ControlFrameType is the type of a control frame, see `SocketControlObserver`.
+	ControlFrameType uint8
+
 	// MessageType is a type for readen and to-send data, helpful to set `msg.SetBinary`
 	// to the rest of the clients through a Broadcast, as SetBinary is not part of the deserialization.
 	MessageType uint8
@@ -36,16 +99,40 @@ const (
 	BinaryMessage
 )
 
+// See `ControlFrameType` definition for details.
+const (
+	PingControlFrame ControlFrameType = iota + 1
+	PongControlFrame
+	CloseControlFrame
+)
+
+// event returns the event which is fired for a control frame of this type.
+func (typ ControlFrameType) event() string {
+	switch typ {
+	case PingControlFrame:
+		return OnPing
+	case PongControlFrame:
+		return OnPong
+	case CloseControlFrame:
+		return OnCloseFrame
+	default:
+		return ""
+	}
+}
+
 // Conn contains the websocket connection and the neffos communication functionality.
 // Its `Connection` will return a new `NSConn` instance.
 // Each connection can connect to one or more declared namespaces.
 // Each `NSConn` can join to multiple rooms.
 type Conn struct {
-	// the ID generated by `Server#IDGenerator`.
-	id string
+	// the ID generated by `Server#IDGenerator`, a string.
+	// The client-side one is stored by the reader on the acknowledgement,
+	// while the `ID` may be called from any goroutine, see `setID`.
+	id atomic.Value
 	// serverConnID is unique per server instance and it can be comparable only within the
 	// same server instance. Even if Server#IDGenerator
 	// returns the same ID from the request.
+	// It's set before the connection is served, like the `ReconnectTries`.
 	serverConnID string
 	// a context-scope storage, initialized on first `Set`.
 	store      map[string]interface{}
@@ -53,6 +140,8 @@ type Conn struct {
 
 	// the gorilla or gobwas socket.
 	socket Socket
+	// see `TLSState`.
+	tlsState *tls.ConnectionState
 	// ReconnectTries, if > 0 then this connection is a result of a client-side reconnection,
 	// see `WasReconnected() bool`.
 	ReconnectTries int
@@ -67,9 +156,13 @@ type Conn struct {
 	// maximum wait time allowed to read a message from the connection.
 	// Defaults to no timeout.
 	readTimeout time.Duration
+	// see `Server.Clock` and `WithClock`.
+	clock Clock
 	// maximum wait time allowed to write a message to the connection.
 	// Defaults to no timeout.
 	writeTimeout time.Duration
+	// see `Server.SlowWriteThreshold`.
+	slowWrites *slowWrites
 
 	// the defined namespaces, allowed to connect.
 	namespaces Namespaces
@@ -89,17 +182,94 @@ type Conn struct {
 	// messages that this connection waits for a reply.
 	waitingMessages      map[string]chan Message
 	waitingMessagesMutex sync.RWMutex
+	// random component of the wait tokens generated by this connection,
+	// replies with a token of another scope (i.e of a previous session) are rejected.
+	waitScope string
+	// makes the wait tokens unique even if generated at the same time.
+	waitSeq *uint64
 
 	allowNativeMessages            bool
 	shouldHandleOnlyNativeMessages bool
+	// the namespace connection of the native messages, see `OnNativeMessage`.
+	nativeNS *NSConn
+	// see `Server.NativeUnknownNamespaces`.
+	nativeUnknownNamespaces bool
 
+	// the messages which arrived before the ack, up to the `maxQueueBeforeACK`.
 	queue      map[MessageType][][]byte
 	queueMutex sync.Mutex
 
+	// maximum size of an incoming message, ack messages are excluded.
+	// Defaults to 0, no limit.
+	maxMessageSize int64
+	// the write buffering of the socket, see `SocketWriteBuffer`.
+	writeBufferSize       int
+	writeBufferMaxLatency time.Duration
+
+	// see `Codec`.
+	codec MessageCodec
+
+	// tolerance of clock skew when checking the `Message.Expiry`.
+	expiryTolerance time.Duration
+	// fills the `Message.SentAt` on `Write`.
+	stampSentAt bool
+	// called around the callback of each event, see `Server.OnBeforeEvent` and `Server.OnAfterEvent`.
+	onBeforeEvent func(ns *NSConn, msg Message) error
+	onAfterEvent  func(ns *NSConn, msg Message, dur time.Duration, err error)
+	// see `Server.OnMessageProcessed`.
+	onMessageProcessed func(c *Conn, msg Message, dur time.Duration, err error)
+	// bodies larger than that are compressed on `Write`,
+	// if the remote side supports it, see `compression`.
+	// Defaults to 0, disabled.
+	compressionThreshold int
+	// more than 0 if the compression is negotiated on the ack.
+	compression *uint32
+	// the size of the outgoing chunks and the limits of the incoming chunked transfers,
+	// see `NSConn#EmitLarge`. Zero values fallback to their defaults.
+	chunkSize       int
+	maxTransferSize int64
+	transferTimeout time.Duration
+	// generates the IDs of the outgoing transfers.
+	transferSeq *uint64
+	// the incoming transfers by their IDs.
+	transfers      map[string]*transfer
+	transfersMutex sync.Mutex
+	// server-side connections share the server's counters.
+	counters *counters
+
+	// see `JSONProtocol`.
+	jsonProtocol bool
+
+	// see `Server.StrictParsing`.
+	strictParsing bool
+	// the limits of the incoming messages, see `Server#SetMessageLimits`.
+	messageLimits MessageLimits
+	// see `Server#SetLogger`.
+	logger Logger
+	// see `Server#SetFrameDump`.
+	frameDump *frameDumper
+
+	// generates the `Message.TraceID` on `Write`.
+	generateTraceID bool
+	// the interval of the client's heartbeat pings, see `WithHeartbeat`.
+	heartbeatInterval time.Duration
+	// the unix nanoseconds of the last incoming message, see `LastActivity`.
+	lastActivity *int64
+	// the trace ID of the incoming message which is currently handled, see `TraceID`.
+	traceID atomic.Value
+
+	// the state of a server-side connection's cluster presence and its aliases,
+	// see `Server.announcePresence`.
+	presenceState   uint32
+	presenceAliases []string
+
 	// used to fire `conn#Close` once.
 	closed *uint32
 	// useful to terminate the broadcaster, see `Server#ServeHTTP.waitMessages`.
 	closeCh chan struct{}
+	// cancelled on `Close`, see `Context`.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func newConn(socket Socket, namespaces Namespaces) *Conn {
@@ -112,11 +282,21 @@ func newConn(socket Socket, namespaces Namespaces) *Conn {
 		processes:                      newProcesses(),
 		isInsideHandler:                new(uint32),
 		waitingMessages:                make(map[string]chan Message),
+		waitScope:                      genWaitScope(),
+		waitSeq:                        new(uint64),
+		compression:                    new(uint32),
+		transferSeq:                    new(uint64),
 		allowNativeMessages:            false,
 		shouldHandleOnlyNativeMessages: false,
+		counters:                       newCounters(),
 		closed:                         new(uint32),
 		closeCh:                        make(chan struct{}),
+		lastActivity:                   new(int64),
+		slowWrites:                     new(slowWrites),
+		clock:                          SystemClock,
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.touch()
 
 	if emptyNamespace := namespaces[""]; emptyNamespace != nil && emptyNamespace[OnNativeMessage] != nil {
 		c.allowNativeMessages = true
@@ -126,17 +306,51 @@ func newConn(socket Socket, namespaces Namespaces) *Conn {
 		// then no need to call Connect(...) because:
 		// client-side can use raw websocket without the neffos.js library
 		// so no access to connect to a namespace.
-		if len(c.namespaces) == 1 && len(emptyNamespace) == 1 {
-			c.connectedNamespaces[""] = newNSConn(c, "", emptyNamespace)
+		c.nativeNS = newNSConn(c, "", emptyNamespace)
+		if len(c.namespaces) == 1 && len(emptyNamespace.current()) == 1 {
+			c.connectedNamespaces[""] = c.nativeNS
 			c.shouldHandleOnlyNativeMessages = true
 			atomic.StoreUint32(c.acknowledged, 1)
 			c.readiness.unwait(nil)
 		}
 	}
 
+	c.observeControlFrames()
+
 	return c
 }
 
+// observeControlFrames fires the `OnPing`, `OnPong` and `OnCloseFrame` events of the empty namespace, if any,
+// for the control frames which the socket receives, see `SocketControlObserver`.
+func (c *Conn) observeControlFrames() {
+	events := c.namespaces[""]
+	observer, ok := c.socket.(SocketControlObserver)
+	if !ok || events == nil {
+		return
+	}
+
+	if current := events.current(); current[OnPing] == nil && current[OnPong] == nil && current[OnCloseFrame] == nil {
+		return
+	}
+
+	// the empty namespace may not be connected.
+	detached := newNSConn(c, "", events)
+
+	observer.SetControlObserver(func(typ ControlFrameType, payload []byte) {
+		event := typ.event()
+		if event == "" || detached.current()[event] == nil {
+			return
+		}
+
+		ns := c.Namespace("")
+		if ns == nil {
+			ns = detached
+		}
+
+		ns.fireEvent(Message{Event: event, Body: payload, IsLocal: true})
+	})
+}
+
 // Is reports whether the "connID" is part of this server's connections and their IDs are equal.
 func (c *Conn) Is(connID string) bool {
 	if connID == "" {
@@ -144,7 +358,7 @@ func (c *Conn) Is(connID string) bool {
 	}
 
 	if c.IsClient() {
-		return c.id == connID
+		return c.ID() == connID
 	}
 
 	return c.serverConnID == connID
@@ -154,7 +368,12 @@ func (c *Conn) Is(connID string) bool {
 // If this is a server-side connection then this value is the generated one by the `Server#IDGenerator`.
 // If this is a client-side connection then this value is filled on the acknowledgment process which is done on the `Client#Dial`.
 func (c *Conn) ID() string {
-	return c.id
+	id, _ := c.id.Load().(string)
+	return id
+}
+
+func (c *Conn) setID(id string) {
+	c.id.Store(id)
 }
 
 // String method simply returns the ID(). Useful for fmt usage and
@@ -169,6 +388,28 @@ func (c *Conn) Socket() Socket {
 	return c.socket
 }
 
+// TLSState returns the TLS connection state of the connection, i.e the certificates of a mutual TLS peer,
+// it's nil if the connection is not made over TLS.
+// Server-side it's filled on upgrade, from the request or the net connection,
+// so it's available on the `Server#IDGenerator` (through the request's TLS field), `OnConnect` and the events.
+func (c *Conn) TLSState() *tls.ConnectionState {
+	return c.tlsState
+}
+
+// socketTLSState returns the TLS connection state of the "r" request or of the "netConn", if any.
+func socketTLSState(r *http.Request, netConn net.Conn) *tls.ConnectionState {
+	if r != nil && r.TLS != nil {
+		return r.TLS
+	}
+
+	if conn, ok := netConn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		state := conn.ConnectionState()
+		return &state
+	}
+
+	return nil
+}
+
 // IsClient method reports whether this connections is a client-side connetion.
 func (c *Conn) IsClient() bool {
 	return c.server == nil
@@ -298,6 +539,131 @@ var (
 	ackNotOKBinaryB = []byte{ackNotOKBinary}
 )
 
+// The payloads of the heartbeat messages, they are sent as text frames after the acknowledgement,
+// i.e by the neffos.js client. A ping is answered with a pong by the connection itself,
+// the event callbacks are not fired for them. See `WithHeartbeat` and `Conn#LastActivity`.
+// They are not intercepted on the connections which accept native messages, see `OnNativeMessage`.
+const (
+	HeartbeatPing = "_ping"
+	HeartbeatPong = "_pong"
+)
+
+var (
+	heartbeatPingB = []byte(HeartbeatPing)
+	heartbeatPongB = []byte(HeartbeatPong)
+)
+
+// handleHeartbeat answers a heartbeat ping and reports whether the "b" was a heartbeat message.
+// The heartbeats are text frames of the neffos protocol, the native messages are never intercepted,
+// i.e a raw websocket client of the `OnNativeMessage` which sends a "_ping" text.
+func (c *Conn) handleHeartbeat(msgTyp MessageType, b []byte) bool {
+	if msgTyp != TextMessage || c.allowNativeMessages {
+		return false
+	}
+
+	switch {
+	case bytes.Equal(b, heartbeatPingB):
+		c.counters.incr(&c.counters.heartbeats)
+		c.write(heartbeatPongB, false)
+	case bytes.Equal(b, heartbeatPongB):
+		c.counters.incr(&c.counters.heartbeats)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// startHeartbeat sends a heartbeat ping every "interval" until the connection is closed.
+func (c *Conn) startHeartbeat(interval time.Duration) {
+	for {
+		timer := c.clock.NewTimer(interval)
+
+		select {
+		case <-c.closeCh:
+			timer.Stop()
+			return
+		case <-timer.C():
+			if !c.write(heartbeatPingB, false) {
+				return
+			}
+		}
+	}
+}
+
+// touch records the current time of the connection's clock as its last activity.
+func (c *Conn) touch() {
+	atomic.StoreInt64(c.lastActivity, c.clock.Now().UnixNano())
+}
+
+// LastActivity returns the time of the last message, including the heartbeats,
+// which this connection received. It's the time of its creation if it has not received any yet.
+func (c *Conn) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(c.lastActivity))
+}
+
+func isACK(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+
+	switch b[0] {
+	case ackBinary, ackIDBinary, ackNotOKBinary:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrMessageTooLarge is fired on the `Server.OnError` when an incoming message
+// exceeds the `Server#SetMaxMessageSize` or the `WithMaxMessageSize` limit.
+// The connection is closed with the 1009 (message too big) close code.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// See `ErrMessageTooLarge`.
+const closeCodeMessageTooBig = 1009
+
+// applyReadLimit sets the protocol-level read limit to the socket, if supported.
+// It's called after the ack so the limit never applies to the ack messages.
+func (c *Conn) applyReadLimit() {
+	if c.maxMessageSize <= 0 {
+		return
+	}
+
+	if limiter, ok := c.socket.(SocketReadLimiter); ok {
+		limiter.SetReadLimit(c.maxMessageSize)
+	}
+}
+
+func (c *Conn) isMessageTooLarge(b []byte) bool {
+	return c.maxMessageSize > 0 && int64(len(b)) > c.maxMessageSize && (c.isAcknowledged() || !isACK(b))
+}
+
+func (c *Conn) closeMessageTooLarge() {
+	c.fireError(ErrMessageTooLarge)
+
+	if closer, ok := c.socket.(SocketCloser); ok {
+		closer.WriteClose(closeCodeMessageTooBig, ErrMessageTooLarge.Error(), c.writeTimeout)
+	}
+}
+
+// fireError notifies the `Server.OnError`, if any, client-side errors are not reported yet.
+func (c *Conn) fireError(err error) {
+	if eventErr, ok := err.(*EventError); ok {
+		c.logError("event failed", "namespace", eventErr.Namespace, "event", eventErr.Event, "err", eventErr.Err)
+	} else {
+		c.logError("connection failed", "err", err)
+	}
+
+	if c.IsClient() {
+		return
+	}
+
+	if c.server.OnError != nil {
+		c.server.OnError(c, err)
+	}
+}
+
 func (c *Conn) sendClientACK() error {
 	// if neffos client used but in reality nor of its features are used
 	// because end-dev set it as native only sender and receiver so any webscoket client can be used
@@ -306,7 +672,12 @@ func (c *Conn) sendClientACK() error {
 		return nil
 	}
 
-	ok := c.write(ackBinaryB, false)
+	ack := append(ackBinaryB, codecName(c.codec)...)
+	if c.compressionThreshold > 0 {
+		ack = append(append(ack, ackCapabilitiesSeparator), capabilityCompression...)
+	}
+
+	ok := c.write(ack, false)
 	if !ok {
 		c.Close()
 		return ErrWrite
@@ -324,14 +695,32 @@ func (c *Conn) startReader() {
 	if c.IsClosed() {
 		return
 	}
-	defer c.Close()
+
+	// the reason the connection is closed by its reader.
+	var reason interface{} = "acknowledgement failed"
+	defer func() {
+		if c.IsClosed() {
+			c.logDebug("connection closed", "reason", "local")
+		} else {
+			c.logInfo("connection closed", "reason", reason)
+		}
+		c.Close()
+	}()
+
+	if c.jsonProtocol && !c.acknowledgeJSON() {
+		return
+	}
 
 	// CLIENT is ready when ACK done
 	// SERVER is ready when ACK is done AND `Server#OnConnected` returns with nil error.
 	for {
 		b, msgTyp, err := c.socket.ReadData(c.readTimeout)
 		if err != nil {
+			if err == ErrMessageTooLarge {
+				c.closeMessageTooLarge()
+			}
 			c.readiness.unwait(err)
+			reason = err
 			return
 		}
 
@@ -339,6 +728,19 @@ func (c *Conn) startReader() {
 			continue
 		}
 
+		if c.frameDump != nil {
+			c.frameDump.dump(c, false, msgTyp == BinaryMessage, b)
+		}
+
+		c.touch()
+
+		if c.isMessageTooLarge(b) {
+			c.closeMessageTooLarge()
+			c.readiness.unwait(ErrMessageTooLarge)
+			reason = ErrMessageTooLarge
+			return
+		}
+
 		if !c.isAcknowledged() {
 			if !c.handleACK(msgTyp, b) {
 				return
@@ -346,27 +748,72 @@ func (c *Conn) startReader() {
 			continue
 		}
 
+		if c.handleHeartbeat(msgTyp, b) {
+			continue
+		}
+
 		atomic.StoreUint32(c.isInsideHandler, 1)
 		c.HandlePayload(msgTyp, b)
 		atomic.StoreUint32(c.isInsideHandler, 0)
 	}
 }
 
+// maxQueueBeforeACK is the maximum number of the messages which are queued before the ack,
+// a remote side which sends more is dropped.
+const maxQueueBeforeACK = 1024
+
 func (c *Conn) handleACK(msgTyp MessageType, b []byte) bool {
+	if len(b) == 0 {
+		return true
+	}
+
+	if b[0] != ackBinary && c.allowNativeMessages && !c.IsClient() {
+		// a raw client of the mixed mode, it does not send an ack.
+		return c.acknowledgeNative(msgTyp, b)
+	}
+
+	if isACK(b) && (b[0] == ackBinary) == c.IsClient() {
+		// i.e a client which sends the server's ack to skip the `Server.OnConnect`.
+		c.logError("unexpected acknowledgement", "ack", string(b[:1]))
+		c.readiness.unwait(ErrInvalidPayload)
+		return false
+	}
+
 	switch typ := b[0]; typ {
 	case ackBinary:
 		// from client startup to server.
+		name, capabilities, hasCapabilities := splitACK(b[1:])
+		if name != codecName(c.codec) {
+			c.fireError(ErrCodecMismatch)
+			c.write(append(ackNotOKBinaryB, ErrCodecMismatch.Error()...), false)
+			return false
+		}
+
 		err := c.readiness.wait()
 		if err != nil {
 			// it's not Ok, send error which client's Dial should return.
 			c.write(append(ackNotOKBinaryB, []byte(err.Error())...), false)
 			return false
 		}
+		ack := append(ackIDBinaryB, []byte(c.ID())...)
+		if hasCapabilities {
+			// reply with the enabled ones, the client knows how to parse them.
+			ack = append(ack, ackCapabilitiesSeparator)
+			if c.compressionThreshold > 0 && hasCapability(capabilities, capabilityCompression) {
+				atomic.StoreUint32(c.compression, 1)
+				ack = append(ack, capabilityCompression...)
+			}
+		}
+
 		atomic.StoreUint32(c.acknowledged, 1)
+		c.logDebug("connection acknowledged", "codec", name)
+		c.applyReadLimit()
 		c.handleQueue()
 
 		// it's ok send ID.
-		return c.write(append(ackIDBinaryB, []byte(c.id)...), false)
+		ok := c.write(ack, false)
+		c.applyWriteBuffer()
+		return ok
 
 	// case ackOKBinary:
 	// 	// from client to server.
@@ -377,9 +824,20 @@ func (c *Conn) handleACK(msgTyp MessageType, b []byte) bool {
 	case ackIDBinary:
 		// from server to client.
 		id := string(b[1:])
-		c.id = id
+		if c.compressionThreshold > 0 {
+			// capabilities were sent, so the server replies with the enabled ones.
+			var capabilities string
+			id, capabilities, _ = splitACK(b[1:])
+			if hasCapability(capabilities, capabilityCompression) {
+				atomic.StoreUint32(c.compression, 1)
+			}
+		}
+		c.setID(id)
 
 		atomic.StoreUint32(c.acknowledged, 1)
+		c.logDebug("connection acknowledged")
+		c.applyReadLimit()
+		c.applyWriteBuffer()
 		c.readiness.unwait(nil)
 		// c.write([]byte{ackOKBinary})
 		// println("ackIDBinary: pass with nil")
@@ -388,6 +846,10 @@ func (c *Conn) handleACK(msgTyp MessageType, b []byte) bool {
 		// from server to client.
 		errText := string(b[1:])
 		err := errors.New(errText)
+		if errText == ErrCodecMismatch.Error() {
+			err = ErrCodecMismatch
+		}
+		c.logInfo("connection rejected", "err", err)
 		c.readiness.unwait(err)
 		return false
 	default:
@@ -395,6 +857,15 @@ func (c *Conn) handleACK(msgTyp MessageType, b []byte) bool {
 		if c.queue == nil {
 			c.queue = make(map[MessageType][][]byte)
 		}
+		queued := 0
+		for _, q := range c.queue {
+			queued += len(q)
+		}
+		if queued >= maxQueueBeforeACK {
+			c.queueMutex.Unlock()
+			c.logError("too many messages before the acknowledgement", "queued", queued)
+			return false
+		}
 		c.queue[msgTyp] = append(c.queue[msgTyp], b)
 		c.queueMutex.Unlock()
 	}
@@ -403,6 +874,27 @@ func (c *Conn) handleACK(msgTyp MessageType, b []byte) bool {
 
 }
 
+// acknowledgeNative acknowledges a server-side connection of the mixed mode which did not send an ack,
+// its first message "b" is handled as an incoming one. It reports false if `Server.OnConnect` failed.
+func (c *Conn) acknowledgeNative(msgTyp MessageType, b []byte) bool {
+	if err := c.readiness.wait(); err != nil {
+		return false
+	}
+
+	c.connectedNamespacesMutex.Lock()
+	if c.connectedNamespaces[""] == nil {
+		c.connectedNamespaces[""] = c.nativeNS
+	}
+	c.connectedNamespacesMutex.Unlock()
+
+	atomic.StoreUint32(c.acknowledged, 1)
+	c.logDebug("connection acknowledged", "native", true)
+	c.applyReadLimit()
+	c.applyWriteBuffer()
+	c.HandlePayload(msgTyp, b)
+	return true
+}
+
 func (c *Conn) handleQueue() {
 	c.queueMutex.Lock()
 	defer c.queueMutex.Unlock()
@@ -420,28 +912,99 @@ func (c *Conn) handleQueue() {
 // In the future it may be exposed by an error listener.
 var ErrInvalidPayload = errors.New("invalid payload")
 
+// ErrMessageExpired can be returned by the internal `handleMessage`
+// when an incoming message's `Message.Expiry` has passed, the message is dropped before dispatch.
+var ErrMessageExpired = errors.New("message expired")
+
+// ErrStaleReply can be returned by the internal `handleMessage`
+// when an incoming reply carries a wait token which was not generated by this connection,
+// i.e a late reply of a previous session after a reconnect. The reply is dropped.
+var ErrStaleReply = errors.New("stale reply")
+
+func genWaitScope() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+var (
+	// traceIDPrefix is unique per process, so the trace IDs of many servers do not collide.
+	traceIDPrefix = genWaitScope()
+	traceIDSeq    uint64
+)
+
+// genTraceID returns a new `Message.TraceID`, e.g. "4f2a9c0d1e3b5a76-1".
+func genTraceID() string {
+	return traceIDPrefix + string(waitScopeSeparator) + strconv.FormatUint(atomic.AddUint64(&traceIDSeq, 1), 36)
+}
+
+// genWait returns a new wait token for an `Ask` of this connection,
+// e.g. "$1589790000000000000-1-4f2a9c0d1e3b5a76" for client-side connections.
+func (c *Conn) genWait() string {
+	seq := atomic.AddUint64(c.waitSeq, 1)
+	return genWait(c.IsClient()) + string(waitScopeSeparator) + strconv.FormatUint(seq, 10) + string(waitScopeSeparator) + c.waitScope
+}
+
+// isStaleReply reports whether the "wait" token was generated by this side of the connection
+// but for another connection, see `genWait`.
+func (c *Conn) isStaleReply(wait string, isClient bool) bool {
+	if isOwn := wait[0] == waitComesFromClientPrefix; isOwn != isClient {
+		return false
+	}
+
+	scope := waitScope(wait)
+	return scope != "" && scope != c.waitScope
+}
+
 func (c *Conn) handleMessage(msg Message) error {
 	if msg.isInvalid {
+		c.reportParseError(msg)
 		return ErrInvalidPayload
 	}
 
+	if msg.isExpired(nowMillis(), c.expiryTolerance) {
+		c.counters.incr(&c.counters.expiredInbound)
+		return ErrMessageExpired
+	}
+
+	if msg.TraceID != "" {
+		c.traceID.Store(msg.TraceID)
+		defer c.traceID.Store("")
+	}
+
 	if msg.IsNative && c.allowNativeMessages {
 		ns := c.Namespace("")
-		return ns.events.fireEvent(ns, msg)
+		if ns == nil {
+			// mixed mode, the empty namespace is not connected.
+			ns = c.nativeNS
+		}
+
+		err := ns.fireEvent(msg)
+		if body, ok := isReply(err); ok {
+			// reply in kind.
+			c.Write(Message{Body: body, IsNative: true, SetBinary: msg.SetBinary})
+			return nil
+		}
+
+		return err
 	}
 
 	if isClient := c.IsClient(); msg.IsWait(isClient) {
+		if c.isStaleReply(msg.wait, isClient) {
+			c.counters.incr(&c.counters.staleReplies)
+			return ErrStaleReply
+		}
+
 		if !isClient {
-			if msg.FromStackExchange && c.server.usesStackExchange() {
+			if msg.FromStackExchange && c.server.stackExchangeOpen() {
 				// Currently let's not export the wait field, instead
 				// just accept it on the stackexchange.
-				return c.server.StackExchange.NotifyAsk(msg, msg.wait)
+				return c.server.StackExchange.NotifyAsk(msg, stackExchangeWaitToken(msg.wait))
 			}
-			c.server.waitingMessagesMutex.RLock()
-			ch, ok := c.server.waitingMessages[msg.wait]
-			c.server.waitingMessagesMutex.RUnlock()
-			if ok {
-				ch <- msg
+			if c.server.waitingMessages.notify(msg) {
 				return nil
 			}
 		}
@@ -456,6 +1019,39 @@ func (c *Conn) handleMessage(msg Message) error {
 
 	}
 
+	if isLocalEvent(msg.Event) {
+		// i.e a remote side which tries to fire the `OnNamespaceConnected` callback of this side.
+		c.fireError(ErrReservedEvent)
+		if msg.wait != "" {
+			msg.Err = ErrReservedEvent
+			c.Write(msg)
+		}
+		return ErrReservedEvent
+	}
+
+	if msg.chunk.id != "" {
+		if c.Namespace(msg.Namespace) == nil {
+			return ErrBadNamespace
+		}
+
+		complete, ok := c.reassemble(msg)
+		if !ok {
+			return nil
+		}
+		msg = complete
+	}
+
+	if !c.IsClient() {
+		if err := c.server.validateMessage(c, &msg); err != nil {
+			c.fireError(err)
+			if msg.wait != "" {
+				msg.Err = err
+				c.Write(msg)
+			}
+			return err
+		}
+	}
+
 	switch msg.Event {
 	case OnNamespaceConnect:
 		c.replyConnect(msg)
@@ -477,25 +1073,273 @@ func (c *Conn) handleMessage(msg Message) error {
 		}
 
 		msg.IsLocal = false
-		err := ns.events.fireEvent(ns, msg)
-		if err != nil {
-			msg.Err = err
-			c.Write(msg)
+		cfg := ns.config()
+		if err := cfg.rejectIncoming(msg.Event, c.IsClient()); err != nil {
+			c.fireError(err)
+			return ns.replyIncoming(msg, err)
+		}
+
+		if rate, ok := cfg.rate(msg.Event); ok && !ns.allow(msg.Event, rate, c.clock.Now()) {
+			c.fireError(&EventError{Namespace: ns.namespace, Event: msg.Event, ConnID: c.ID(), TraceID: msg.TraceID, Err: ErrRateLimited})
+			if msg.wait != "" {
+				return ns.replyIncoming(msg, ErrRateLimited)
+			}
+
+			return ErrRateLimited
+		}
+
+		if pool := cfg.pool(msg.Event); pool != nil {
+			err := pool.submit(c, func() { ns.fireDetached(msg, cfg) })
+			if err == ErrHandlerPoolFull {
+				c.fireError(err)
+				return ns.replyIncoming(msg, err)
+			}
+
+			return err
+		}
+
+		if cfg.isConcurrent(msg.Event) {
+			return ns.fireConcurrent(msg, cfg)
+		}
+
+		return ns.replyIncoming(msg, ns.fireIncoming(msg, cfg))
+	}
+
+	return nil
+}
+
+// fireDetached fires the callback of an incoming "msg" outside of the connection's reader goroutine
+// and sends its result back, unless the namespace was disconnected in the meantime.
+func (ns *NSConn) fireDetached(msg Message, cfg *eventsConfig) {
+	if ns.Conn.IsClosed() || ns.Conn.Namespace(ns.namespace) != ns {
+		return
+	}
+
+	ns.replyIncoming(msg, ns.fireIncoming(msg, cfg))
+}
+
+// fireConcurrent fires the callback of an incoming "msg" on its own goroutine, see `Events#Concurrent`.
+// It waits, if the concurrency limit of the namespace connection is reached, for a running callback to return.
+// It's called by the reader goroutine only.
+func (ns *NSConn) fireConcurrent(msg Message, cfg *eventsConfig) error {
+	if ns.concurrency == nil {
+		ns.concurrency = make(chan struct{}, cfg.concurrencyLimit())
+	}
+
+	select {
+	case ns.concurrency <- struct{}{}:
+	case <-ns.Conn.closeCh:
+		return ErrWrite
+	}
+
+	go func(concurrency chan struct{}) {
+		defer func() { <-concurrency }()
+		ns.fireDetached(msg, cfg)
+	}(ns.concurrency)
+
+	return nil
+}
+
+// replyIncoming sends the error or the reply of the callback of an incoming "msg" back to the remote side.
+func (ns *NSConn) replyIncoming(msg Message, err error) error {
+	if err == ErrReplyDeferred {
+		// the reply will be sent through the `NSConn#DeferReply`.
+		return nil
+	}
+
+	if err != nil {
+		msg.Err = err
+		if eventErr, ok := err.(*EventError); ok {
+			// the context is kept for the server's logs only.
+			msg.Err = eventErr.Err
+		}
+
+		msg.Err = ns.translateError(msg, msg.Err)
+		if msg.Err == nil {
+			if msg.wait != "" {
+				ns.Conn.writeEmptyReply(msg.wait)
+			}
 			return err
 		}
+
+		ns.Conn.Write(msg)
+		return err
 	}
 
 	return nil
 }
 
+// translateError returns the error of the callback of an incoming "msg" as it should be sent
+// to the remote side, see `Server#SetErrorTranslator`.
+func (ns *NSConn) translateError(msg Message, err error) error {
+	if err == nil || ns.Conn.IsClient() || ns.Conn.server.errorTranslator == nil {
+		return err
+	}
+
+	if _, ok := isReply(err); ok {
+		return err
+	}
+
+	if _, ok := asError(err); ok {
+		return err
+	}
+
+	return ns.Conn.server.errorTranslator(ns, msg, err)
+}
+
+// translateRejection is like `translateError` but for the refusal of a namespace connect/disconnect
+// or a room join/leave, which can not be turned into an acceptance.
+func (ns *NSConn) translateRejection(msg Message, err error) error {
+	if err = ns.translateError(msg, err); err == nil {
+		return ErrRejected
+	}
+
+	return err
+}
+
+// eventError wraps the "err" of the callback of an incoming "msg" with its context
+// and reports it to the `Server.OnError`, see `EventError`.
+func (ns *NSConn) eventError(msg Message, err error) error {
+	if err == nil || err == ErrReplyDeferred {
+		return err
+	}
+
+	if _, ok := isReply(err); ok {
+		return err
+	}
+
+	err = &EventError{Namespace: ns.namespace, Event: msg.Event, ConnID: ns.Conn.ID(), TraceID: msg.TraceID, Err: err}
+	ns.Conn.fireError(err)
+	return err
+}
+
+// fireIncoming fires the callback of an incoming application event with its context,
+// bounded by the event's timeout, if any, see `Events#WithTimeout`.
+func (ns *NSConn) fireIncoming(msg Message, cfg *eventsConfig) error {
+	timeout := cfg.timeout(msg.Event)
+	if timeout <= 0 {
+		msg.ctx = contextWithTraceID(ns.Conn.ctx, msg.TraceID)
+		return ns.eventError(msg, ns.current().fire(ns, msg, cfg))
+	}
+
+	ctx, cancel := context.WithTimeout(ns.Conn.ctx, timeout)
+	defer cancel()
+	msg.ctx = contextWithTraceID(ctx, msg.TraceID)
+
+	// buffered, the result of a timed out callback is discarded.
+	done := make(chan error, 1)
+	go func() {
+		done <- ns.current().fire(ns, msg, cfg)
+	}()
+
+	select {
+	case err := <-done:
+		return ns.eventError(msg, err)
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			// closed.
+			return ErrWrite
+		}
+
+		// the callback keeps running, see `Events#WithTimeout`.
+		return ns.eventError(msg, ErrHandlerTimeout)
+	}
+}
+
+// Context returns the context of the connection, it's cancelled when the connection is closed.
+// The contexts of the event callbacks derive from it, see `Message.Context`.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// TraceID returns the `Message.TraceID` of the incoming message which is currently handled
+// by the reader of this connection, if any. It can be used by the `Server.OnError` to join the logs
+// of the errors of the reader, i.e the validation ones, the `EventError` carries the trace ID of its message.
+// It is not inherited by the written messages, the callbacks carry the trace ID of their message
+// through its context, see `NSConn#EmitCtx` and `TraceIDFromContext`.
+func (c *Conn) TraceID() string {
+	traceID, _ := c.traceID.Load().(string)
+	return traceID
+}
+
 // DeserializeMessage returns a Message from the "payload".
 func (c *Conn) DeserializeMessage(msgTyp MessageType, payload []byte) Message {
-	return DeserializeMessage(msgTyp, payload, c.allowNativeMessages, c.shouldHandleOnlyNativeMessages)
+	if c.jsonProtocol {
+		msg := deserializeJSONMessage(msgTyp, payload)
+		if msg.isInvalid && c.strictParsing {
+			msg.wait, msg.parseErr = parseJSONPayload(payload)
+		}
+		msg.codec = c.codec
+		return msg
+	}
+
+	msg := deserializeMessage(msgTyp, payload, c.allowNativeMessages, c.shouldHandleOnlyNativeMessages, false, &c.messageLimits)
+	if c.nativeUnknownNamespaces && c.allowNativeMessages && !msg.IsNative && !msg.isInvalid {
+		if _, ok := c.namespaces.lookup(msg.Namespace); !ok {
+			return Message{Event: OnNativeMessage, Body: payload, IsNative: true, SetBinary: msgTyp == BinaryMessage, codec: c.codec}
+		}
+	}
+
+	if c.strictParsing && !msg.IsNative {
+		if wait, parseErr := parsePayload(payload, &c.messageLimits); parseErr != nil {
+			msg = Message{wait: wait, isInvalid: true, parseErr: parseErr}
+		}
+	}
+
+	bodyOffset := len(payload) - len(msg.Body)
+	decompressMessage(&msg, c.maxMessageSize)
+	if msg.isInvalid && c.strictParsing && msg.parseErr == nil {
+		msg.parseErr = newParseError(payload, bodyOffset, "body")
+	}
+	msg.codec = c.codec
+	return msg
+}
+
+// DeserializeExchangeMessage returns a Message from a StackExchange envelope "payload",
+// see `Message.SerializeExchange`.
+func (c *Conn) DeserializeExchangeMessage(payload []byte) Message {
+	msg := DeserializeExchangeMessage(payload)
+	msg.codec = c.codec
+	return msg
+}
+
+// Codec returns the `MessageCodec` of this connection, if any.
+// See `Server.Codec` and `WithCodec`.
+func (c *Conn) Codec() MessageCodec {
+	return c.codec
+}
+
+// Marshal acts like the package-level `Marshal` function
+// but it uses the connection's `Codec`, if any, instead of the `DefaultMarshaler`.
+func (c *Conn) Marshal(v interface{}) []byte {
+	if c.codec == nil {
+		return Marshal(v)
+	}
+
+	return marshal(v, c.codec.Marshal)
 }
 
 // HandlePayload fires manually a local event based on the "payload".
 func (c *Conn) HandlePayload(msgTyp MessageType, payload []byte) error {
-	return c.handleMessage(c.DeserializeMessage(msgTyp, payload))
+	if c.onMessageProcessed == nil {
+		return c.handleMessage(c.DeserializeMessage(msgTyp, payload))
+	}
+
+	start := c.clock.Now()
+	msg := c.DeserializeMessage(msgTyp, payload)
+	err := c.handleMessage(msg)
+	dur := c.clock.Now().Sub(start)
+	if _, ok := isReply(err); ok {
+		// replied, like the `Server.OnAfterEvent`.
+		c.onMessageProcessed(c, msg, dur, nil)
+	} else {
+		c.onMessageProcessed(c, msg, dur, err)
+	}
+	return err
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
 const syncWaitDur = 15 * time.Millisecond
@@ -517,18 +1361,18 @@ func (c *Conn) Connect(ctx context.Context, namespace string) (*NSConn, error) {
 		c.readiness.unwait(nil)
 		// server-side check for ack-ed, it should be done almost immediately the client connected
 		// but give it sometime for slow networks and add an extra check for closed after 5 seconds and a deadline of 10seconds.
-		t := maxSyncWaitDur
+		start := c.clock.Now()
 		for !c.isAcknowledged() {
-			time.Sleep(syncWaitDur)
-			t -= syncWaitDur
+			c.clock.Sleep(syncWaitDur)
+			elapsed := c.clock.Now().Sub(start)
 
-			if t <= maxSyncWaitDur/2 { // check once after 5 seconds if closed.
+			if elapsed >= maxSyncWaitDur/2 { // check once after 5 seconds if closed.
 				if c.IsClosed() {
 					return nil, ErrWrite
 				}
 			}
 
-			if t == 0 {
+			if elapsed >= maxSyncWaitDur {
 				// when maxSyncWaitDur passed,
 				// we could use the context's deadline but it will make things slower (extracting its value slower than the sleep time).
 				if c.IsClosed() {
@@ -573,7 +1417,7 @@ func (c *Conn) WaitConnect(ctx context.Context, namespace string) (ns *NSConn, e
 				return
 			}
 
-			time.Sleep(syncWaitDur)
+			c.clock.Sleep(syncWaitDur)
 		}
 	}
 }
@@ -588,7 +1432,14 @@ func (c *Conn) Namespace(namespace string) *NSConn {
 }
 
 func (c *Conn) tryNamespace(in Message) (*NSConn, bool) {
-	c.processes.get(in.Namespace).Wait() // wait any `askConnect` process (if any) of that "in.Namespace".
+	if _, ok := c.namespaces.lookup(in.Namespace); !ok {
+		// reject it before the processes, the remote side should not grow them.
+		in.Err = ErrBadNamespace
+		c.Write(in)
+		return nil, false
+	}
+
+	c.processes.wait(in.Namespace) // wait any `askConnect` process (if any) of that "in.Namespace".
 
 	ns := c.Namespace(in.Namespace)
 	if ns == nil {
@@ -611,6 +1462,7 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 	p := c.processes.get(namespace)
 	p.Start() // block any `tryNamespace` with that "namespace".
 
+	defer c.processes.release(namespace, p)
 	defer p.Done() // unblock.
 
 	//	defer c.processes.get(namespace).run()()
@@ -622,7 +1474,7 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 		return ns, nil
 	}
 
-	events, ok := c.namespaces[namespace]
+	events, ok := c.namespaces.lookup(namespace)
 	if !ok {
 		return nil, ErrBadNamespace
 	}
@@ -634,16 +1486,19 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 	}
 
 	ns = newNSConn(c, namespace, events)
-	err := events.fireEvent(ns, connectMessage)
+	err := ns.fireEvent(connectMessage)
 	if err != nil {
+		c.logInfo("namespace connect rejected", "namespace", namespace, "err", err)
 		return nil, err
 	}
 
 	// println("ask connect")
-	_, err = c.Ask(ctx, connectMessage) // waits for answer no matter if already connected on the other side.
+	reply, err := c.Ask(ctx, connectMessage) // waits for answer no matter if already connected on the other side.
 	if err != nil {
+		c.logInfo("namespace connect rejected", "namespace", namespace, "err", err)
 		return nil, err
 	}
+	ns.connectData = reply.Body
 	// println("got connect")
 	// re-check, maybe connected so far (can happen by a simultaneously `Connect` calls on both server and client, which is not the standard way)
 	// c.connectedNamespacesMutex.RLock()
@@ -656,6 +1511,7 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 	c.connectedNamespacesMutex.Lock()
 	c.connectedNamespaces[namespace] = ns
 	c.connectedNamespacesMutex.Unlock()
+	c.logInfo("namespace connected", "namespace", namespace)
 
 	// println("we're connected")
 
@@ -680,7 +1536,7 @@ func (c *Conn) replyConnect(msg Message) {
 		return
 	}
 
-	events, ok := c.namespaces[msg.Namespace]
+	events, ok := c.namespaces.lookup(msg.Namespace)
 	if !ok {
 		msg.Err = ErrBadNamespace
 		c.Write(msg)
@@ -688,9 +1544,15 @@ func (c *Conn) replyConnect(msg Message) {
 	}
 
 	ns = newNSConn(c, msg.Namespace, events)
-	err := events.fireEvent(ns, msg)
+	err := ns.fireEvent(msg)
+	if body, ok := isReply(err); ok {
+		ns.SetConnectReply(body)
+		err = nil
+	}
+
 	if err != nil {
-		msg.Err = err
+		c.logInfo("namespace connect rejected", "namespace", msg.Namespace, "err", err)
+		msg.Err = ns.translateRejection(msg, err)
 		c.Write(msg)
 		return
 	}
@@ -698,23 +1560,29 @@ func (c *Conn) replyConnect(msg Message) {
 	c.connectedNamespacesMutex.Lock()
 	c.connectedNamespaces[msg.Namespace] = ns
 	c.connectedNamespacesMutex.Unlock()
+	c.logInfo("namespace connected", "namespace", msg.Namespace)
 
-	c.writeEmptyReply(msg.wait)
+	if len(ns.connectData) > 0 {
+		msg.Body = ns.connectData
+		c.Write(msg)
+	} else {
+		c.writeEmptyReply(msg.wait)
+	}
 
 	c.notifyNamespaceConnected(ns, msg)
 }
 
 func (c *Conn) notifyNamespaceConnected(ns *NSConn, connectMsg Message) {
 	connectMsg.Event = OnNamespaceConnected
-	ns.events.fireEvent(ns, connectMsg) // omit error, it's connected.
+	ns.fireEvent(connectMsg) // omit error, it's connected.
 
-	if !c.IsClient() && c.server.usesStackExchange() {
+	if !c.IsClient() && c.server.stackExchangeOpen() {
 		c.server.StackExchange.Subscribe(c, ns.namespace)
 	}
 }
 
 func (c *Conn) notifyNamespaceDisconnect(ns *NSConn, disconnectMsg Message) {
-	if !c.IsClient() && c.server.usesStackExchange() {
+	if !c.IsClient() && c.server.stackExchangeOpen() {
 		c.server.StackExchange.Unsubscribe(c, disconnectMsg.Namespace)
 	}
 }
@@ -774,9 +1642,10 @@ func (c *Conn) askDisconnect(ctx context.Context, msg Message, lock bool) error
 	if lock {
 		c.connectedNamespacesMutex.Unlock()
 	}
+	c.logInfo("namespace disconnected", "namespace", msg.Namespace)
 
 	msg.IsLocal = true
-	ns.events.fireEvent(ns, msg)
+	ns.fireEvent(msg)
 
 	c.notifyNamespaceDisconnect(ns, msg)
 	return nil
@@ -802,17 +1671,19 @@ func (c *Conn) replyDisconnect(msg Message) {
 		c.connectedNamespacesMutex.Lock()
 		delete(c.connectedNamespaces, msg.Namespace)
 		c.connectedNamespacesMutex.Unlock()
+		c.logInfo("namespace disconnected", "namespace", msg.Namespace)
 
 		c.writeEmptyReply(msg.wait)
 
-		ns.events.fireEvent(ns, msg)
+		ns.fireEvent(msg)
 		return
 	}
 
 	// server-side, check for error on the local event first.
-	err := ns.events.fireEvent(ns, msg)
+	err := ns.fireEvent(msg)
 	if err != nil {
-		msg.Err = err
+		c.logInfo("namespace disconnect rejected", "namespace", msg.Namespace, "err", err)
+		msg.Err = ns.translateRejection(msg, err)
 		c.Write(msg)
 		return
 	}
@@ -822,6 +1693,7 @@ func (c *Conn) replyDisconnect(msg Message) {
 	c.connectedNamespacesMutex.Lock()
 	delete(c.connectedNamespaces, msg.Namespace)
 	c.connectedNamespacesMutex.Unlock()
+	c.logInfo("namespace disconnected", "namespace", msg.Namespace)
 
 	c.notifyNamespaceDisconnect(ns, msg)
 
@@ -829,6 +1701,15 @@ func (c *Conn) replyDisconnect(msg Message) {
 }
 
 func (c *Conn) write(b []byte, binary bool) bool {
+	if c.frameDump != nil {
+		c.frameDump.dump(c, true, binary, b)
+	}
+
+	var start time.Time
+	if c.observesWrites() {
+		start = time.Now()
+	}
+
 	var err error
 	if binary {
 		err = c.socket.WriteBinary(b, c.writeTimeout)
@@ -836,16 +1717,65 @@ func (c *Conn) write(b []byte, binary bool) bool {
 		err = c.socket.WriteText(b, c.writeTimeout)
 	}
 
+	if !start.IsZero() {
+		c.observeWrite(start, err)
+	}
+
 	if err != nil {
-		if IsCloseError(err) {
-			c.Close()
-		}
+		c.writeFailed(err)
+		return false
+	}
+
+	return true
+}
+
+// writePrepared is like `write` but it sends a message prepared by its `SocketPreparedWriter` socket.
+func (c *Conn) writePrepared(pm interface{}) bool {
+	var start time.Time
+	if c.observesWrites() {
+		start = time.Now()
+	}
+
+	err := c.socket.(SocketPreparedWriter).WritePrepared(pm, c.writeTimeout)
+	if !start.IsZero() {
+		c.observeWrite(start, err)
+	}
+
+	if err != nil {
+		c.writeFailed(err)
 		return false
 	}
 
 	return true
 }
 
+// writeFailed logs the "err" of a write and closes the connection if it's a close error,
+// these are expected after the remote side is gone so they are logged as debug ones.
+func (c *Conn) writeFailed(err error) {
+	if IsCloseError(err) {
+		c.logDebug("write failed", "err", err)
+		c.Close()
+		return
+	}
+
+	c.logError("write failed", "err", err)
+}
+
+// prepare returns the prepared message of the serialized broadcast message "b" for the connection's socket,
+// nil if the message was not broadcasted or the socket does not implement the `SocketPreparedWriter`.
+func (c *Conn) prepare(p *preparedMessage, b []byte, binary bool) interface{} {
+	if p == nil {
+		return nil
+	}
+
+	w, ok := c.socket.(SocketPreparedWriter)
+	if !ok {
+		return nil
+	}
+
+	return p.load(w, b, binary)
+}
+
 func (c *Conn) canWrite(msg Message) bool {
 	if c.IsClosed() {
 		return false
@@ -904,6 +1834,12 @@ func (c *Conn) canWrite(msg Message) bool {
 		return false
 	}
 
+	// don't write if this connection was excluded by `Server#Broadcast`,
+	// checked here for the messages coming from a StackExchange, see `publishMessages` too.
+	if msg.from != "" && msg.from == c.ID() {
+		return false
+	}
+
 	return true
 }
 
@@ -911,16 +1847,77 @@ func (c *Conn) canWrite(msg Message) bool {
 // reports whether the connection is still available
 // or when this message is not allowed to be sent to the remote side.
 func (c *Conn) Write(msg Message) bool {
+	if msg.FromStackExchange && msg.origin != "" && !c.IsClient() && msg.origin == c.server.uuid && c.server.writesLocal() {
+		// published by this server instance, it's already written to its local connections.
+		c.counters.incr(&c.counters.suppressedEchoes)
+		return false
+	}
+
+	if msg.FromStackExchange && !c.IsClient() && atomic.LoadUint32(&c.server.stackExchangeClosed) == 1 {
+		// delivered while the server shuts down, see `Server.Shutdown`.
+		return false
+	}
+
 	if !c.canWrite(msg) {
 		return false
 	}
 
+	if msg.isExpired(nowMillis(), c.expiryTolerance) {
+		c.counters.incr(&c.counters.expiredOutbound)
+		return false
+	}
+
+	if msg.FromStackExchange && msg.publishID != "" && !c.IsClient() &&
+		c.server.exchangeDedup != nil && c.server.exchangeDedup.seen(msg.publishID, c) {
+		// delivered by both stackexchanges of a `CompositeStackExchange`.
+		return false
+	}
+
+	if msg.FromStackExchange && !c.IsClient() && c.server.instrumentStackExchange {
+		c.counters.observeExchangeReceive(msg)
+	}
+
 	msg.FromExplicit = ""
-	return c.write(serializeMessage(msg), msg.SetBinary)
+	if c.stampSentAt && msg.SentAt == 0 {
+		msg.SentAt = nowMillis()
+	}
+
+	if msg.TraceID == "" && !msg.IsNative && c.generateTraceID {
+		msg.TraceID = genTraceID()
+	}
+
+	if c.shouldCompress(msg) {
+		msg.Body = compressBody(msg.Body)
+		msg.compressed = true
+	}
+
+	if c.jsonProtocol {
+		return c.writeJSON(msg)
+	}
+
+	buf := acquireBuffer()
+	b, binary := serializeMessageTo(buf, msg), msg.SetBinary || msg.compressed
+
+	var ok bool
+	if pm := c.prepare(msg.prepared, b, binary); pm != nil {
+		if c.frameDump != nil {
+			c.frameDump.dump(c, true, binary, b)
+		}
+		ok = c.writePrepared(pm)
+	} else {
+		ok = c.write(b, binary)
+	}
+
+	releaseBuffer(buf)
+	return ok
 }
 
 // used when `Ask` caller cares only for successful call and not the message, for performance reasons we just use raw bytes.
 func (c *Conn) writeEmptyReply(wait string) bool {
+	if c.jsonProtocol {
+		return c.writeJSON(Message{wait: wait})
+	}
+
 	return c.write(genEmptyReplyToWait(wait), false)
 }
 
@@ -939,6 +1936,11 @@ func (c *Conn) writeEmptyReply(wait string) bool {
 // 	c.writeEmptyReply(wait)
 // }
 
+func (c *Conn) shouldCompress(msg Message) bool {
+	return c.compressionThreshold > 0 && len(msg.Body) > c.compressionThreshold &&
+		!msg.IsNative && msg.Err == nil && atomic.LoadUint32(c.compression) > 0
+}
+
 // Ask method sends a message to the remote side and blocks until a response or an error received from the specific `Message.Event`.
 func (c *Conn) Ask(ctx context.Context, msg Message) (Message, error) {
 	mustWaitOnlyTheNextMessage := atomic.LoadUint32(c.isInsideHandler) == 1
@@ -955,18 +1957,22 @@ func (c *Conn) ask(ctx context.Context, msg Message, mustWaitOnlyTheNextMessage
 		return msg, CloseError{Code: -1, error: ErrWrite}
 	}
 
+	if c.isReceiveOnly() {
+		return c.askReceiveOnly(msg)
+	}
+
 	if ctx == nil {
 		ctx = context.TODO()
 	} else {
 		if deadline, has := ctx.Deadline(); has {
-			if deadline.Before(time.Now().Add(-1 * time.Second)) {
+			if deadline.Before(c.clock.Now().Add(-1 * time.Second)) {
 				return Message{}, context.DeadlineExceeded
 			}
 		}
 	}
 
 	ch := make(chan Message, 1)
-	msg.wait = genWait(c.IsClient())
+	msg.wait = c.genWait()
 
 	if mustWaitOnlyTheNextMessage {
 		// msg.wait is not required on this state
@@ -984,6 +1990,12 @@ func (c *Conn) ask(ctx context.Context, msg Message, mustWaitOnlyTheNextMessage
 		c.waitingMessagesMutex.Lock()
 		c.waitingMessages[msg.wait] = ch
 		c.waitingMessagesMutex.Unlock()
+
+		defer func() {
+			c.waitingMessagesMutex.Lock()
+			delete(c.waitingMessages, msg.wait)
+			c.waitingMessagesMutex.Unlock()
+		}()
 	}
 
 	if !c.Write(msg) {
@@ -997,12 +2009,6 @@ func (c *Conn) ask(ctx context.Context, msg Message, mustWaitOnlyTheNextMessage
 		}
 		return Message{}, ctx.Err()
 	case receive := <-ch:
-		if !mustWaitOnlyTheNextMessage {
-			c.waitingMessagesMutex.Lock()
-			delete(c.waitingMessages, msg.wait)
-			c.waitingMessagesMutex.Unlock()
-		}
-
 		return receive, receive.Err
 	}
 }
@@ -1020,8 +2026,9 @@ func (c *Conn) Close() {
 				ns.forceLeaveAll(true)
 
 				disconnectMsg.Namespace = ns.namespace
-				ns.events.fireEvent(ns, disconnectMsg)
+				ns.fireEvent(disconnectMsg)
 				delete(c.connectedNamespaces, namespace)
+				c.logDebug("namespace disconnected", "namespace", namespace, "forced", true)
 			}
 			c.connectedNamespacesMutex.Unlock()
 
@@ -1033,14 +2040,17 @@ func (c *Conn) Close() {
 		}
 
 		atomic.StoreUint32(c.acknowledged, 0)
+		c.dropTransfers()
 
 		if !c.IsClient() {
-			go func() {
-				c.server.disconnect <- c
-			}()
+			go c.server.notifyDisconnect(c)
 		}
 
 		close(c.closeCh)
+		c.cancel()
+		if f, ok := c.socket.(SocketFlusher); ok {
+			f.Flush()
+		}
 		c.socket.NetConn().Close()
 	}
 }
@@ -1,8 +1,13 @@
 package neffos
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,8 +41,85 @@ func (e Events) GetNamespaces() Namespaces {
 	return Namespaces{"": e}
 }
 
+// Describe returns the names of the events of the empty namespace, see `Namespaces#Describe`.
+func (e Events) Describe() map[string][]string {
+	return e.GetNamespaces().Describe()
+}
+
 func (e Events) fireEvent(c *NSConn, msg Message) error {
-	if h, ok := e[msg.Event]; ok {
+	s := e.state()
+	if s == nil {
+		return e.fire(c, msg, nil)
+	}
+
+	return s.current().fire(c, msg, s.configuration())
+}
+
+// fire fires the callback of the "msg" event, wrapped by the middleware of the server and the "cfg",
+// between the before and after event hooks of the connection, if any.
+// The "e" are the current callbacks of the events, see `eventsState`.
+func (e Events) fire(c *NSConn, msg Message, cfg *eventsConfig) error {
+	if c == nil || c.Conn == nil || (c.Conn.onBeforeEvent == nil && c.Conn.onAfterEvent == nil) {
+		return e.fireMiddleware(c, msg, cfg)
+	}
+
+	if before := c.Conn.onBeforeEvent; before != nil {
+		if err := before(c, msg); err != nil {
+			return err
+		}
+	}
+
+	after := c.Conn.onAfterEvent
+	if after == nil {
+		return e.fireMiddleware(c, msg, cfg)
+	}
+
+	// the duration follows the connection's clock, see `Server#Clock`.
+	start := c.Conn.clock.Now()
+	err := e.fireMiddleware(c, msg, cfg)
+
+	failure := err
+	if _, ok := isReply(err); ok || err == ErrReplyDeferred {
+		failure = nil
+	}
+	after(c, msg, c.Conn.clock.Now().Sub(start), failure)
+
+	return err
+}
+
+// fireMiddleware fires the callback of the "msg" event, wrapped by the middleware of the server and the "cfg".
+func (e Events) fireMiddleware(c *NSConn, msg Message, cfg *eventsConfig) error {
+	var middleware []Middleware
+	if c != nil && c.Conn != nil && c.Conn.server != nil {
+		middleware = c.Conn.server.middleware
+	}
+
+	if cfg != nil {
+		middleware = append(middleware[:len(middleware):len(middleware)], cfg.middleware...)
+	}
+
+	if len(middleware) == 0 {
+		if cfg.isAnyEventAlways() {
+			return e.fireAlways(c, msg)
+		}
+
+		return e.dispatch(c, msg)
+	}
+
+	next := e.dispatch
+	if cfg.isAnyEventAlways() {
+		next = e.fireAlways
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		next = middleware[i](next)
+	}
+
+	return next(c, msg)
+}
+
+// dispatch fires the callback of the "msg" event.
+func (e Events) dispatch(c *NSConn, msg Message) error {
+	if h, ok := e.match(msg.Event); ok {
 		return h(c, msg)
 	}
 
@@ -48,12 +130,679 @@ func (e Events) fireEvent(c *NSConn, msg Message) error {
 	return nil
 }
 
+// fireAlways fires the `OnAnyEvent` callback before the callback of an application event,
+// see `AnyEventAlways`.
+func (e Events) fireAlways(c *NSConn, msg Message) error {
+	h, ok := e.match(msg.Event)
+
+	if anyHandler := e[OnAnyEvent]; anyHandler != nil && !IsSystemEvent(msg.Event) {
+		if err := anyHandler(c, msg); err != nil || !ok {
+			return err
+		}
+	}
+
+	if ok {
+		return h(c, msg)
+	}
+
+	return nil
+}
+
+// eventsState keeps the state of the events which is not an event callback, aside of their map,
+// so a remote side cannot reach it through an event name: the callbacks which are modified
+// while the events are served (see `Events#Set`) and their configuration (see `eventsConfig`).
+// A namespace connection loads it once, see `newNSConn`.
+type eventsState struct {
+	// the map of the events, it keeps the key of the state valid.
+	events Events
+
+	mu     sync.Mutex // guards the modifications.
+	served bool
+	// the modified copy of the served callbacks, the callbacks are replaced by a modified copy,
+	// so a message which is handled keeps the ones it started with. Nil until a served callback is modified.
+	live   atomic.Value // Events.
+	config atomic.Value // *eventsConfig.
+}
+
+var (
+	eventsStatesMu sync.RWMutex
+	// the states of the configured and the served events, keyed by the identity of their map.
+	// They are kept while the program runs, like the events which are registered to a server or a client.
+	eventsStates = make(map[uintptr]*eventsState)
+)
+
+// state returns the state of the events, it's nil if they are neither configured nor served.
+func (e Events) state() *eventsState {
+	if e == nil {
+		return nil
+	}
+
+	eventsStatesMu.RLock()
+	s := eventsStates[reflect.ValueOf(e).Pointer()]
+	eventsStatesMu.RUnlock()
+	return s
+}
+
+// loadOrStoreState returns the state of the events, it's created if they have none.
+func (e Events) loadOrStoreState() *eventsState {
+	if s := e.state(); s != nil {
+		return s
+	}
+
+	key := reflect.ValueOf(e).Pointer()
+
+	eventsStatesMu.Lock()
+	defer eventsStatesMu.Unlock()
+
+	s, ok := eventsStates[key]
+	if !ok {
+		s = &eventsState{events: e}
+		eventsStates[key] = s
+	}
+
+	return s
+}
+
+// current returns the callbacks of the events, the modified copy if any.
+func (s *eventsState) current() Events {
+	if events, ok := s.live.Load().(Events); ok {
+		return events
+	}
+
+	return s.events
+}
+
+// configuration returns the configuration of the events, it's nil if there is none.
+func (s *eventsState) configuration() *eventsConfig {
+	if s == nil {
+		return nil
+	}
+
+	cfg, _ := s.config.Load().(*eventsConfig)
+	return cfg
+}
+
+// makeLive makes the events safe to be modified through the `Set` and `Remove` methods
+// while they are used by connections. It's called once the events are passed to the `New` and `Dial` functions.
+// The events which are registered directly to the map after that, i.e through the `On` method,
+// are fired too, until a callback is modified through the `Set` and `Remove` methods.
+func (e Events) makeLive() {
+	s := e.loadOrStoreState()
+	s.mu.Lock()
+	s.served = true
+	s.mu.Unlock()
+}
+
+// current returns the callbacks of the events, the modified ones if they are served.
+func (e Events) current() Events {
+	if s := e.state(); s != nil {
+		return s.current()
+	}
+
+	return e
+}
+
+// copy returns a copy of the current callbacks of the events.
+func (e Events) copy() Events {
+	current := e.current()
+	events := make(Events, len(current))
+	for event, h := range current {
+		events[event] = h
+	}
+
+	return events
+}
+
+// modify calls the "update" with the events, or with a copy of the served ones
+// which replaces them when the "update" returns.
+func (e Events) modify(update func(e Events)) {
+	s := e.state()
+	if s == nil {
+		update(e)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.served {
+		update(e)
+		return
+	}
+
+	events := e.copy()
+	update(events)
+	s.live.Store(events)
+}
+
+// Set registers the "msgHandler" as the callback of the "event", like the `On` method,
+// but it's safe to be called while the events are used by the connections of a server or a client,
+// i.e by a plugin which adds its events to a running server.
+// The callback is fired for the existing connections too, on their next message.
+// See `Remove` and `Server#SetEvent` too.
+func (e Events) Set(event string, msgHandler MessageHandlerFunc) {
+	e.modify(func(e Events) {
+		e[event] = msgHandler
+	})
+}
+
+// Remove removes the callback of the "event", it's safe to be called while the events are used
+// by the connections of a server or a client. A message which is handled by the callback
+// at the same time is not affected. See `Set` too.
+func (e Events) Remove(event string) {
+	e.modify(func(e Events) {
+		delete(e, event)
+	})
+}
+
+// ReplyHandlerFunc is the definition type of an event callback which answers its message, see `Events#SetWithReply`.
+type ReplyHandlerFunc func(ns *NSConn, msg Message) ([]byte, error)
+
+// UnaskedReply decides what happens to the reply of a `ReplyHandlerFunc`
+// when its message was not sent by an `Ask`, see `Events#SetWithReply`.
+type UnaskedReply uint8
+
+const (
+	// DiscardUnaskedReply discards the reply. It's the default behavior.
+	DiscardUnaskedReply UnaskedReply = iota
+	// EmitUnaskedReply sends the reply back to the remote side as a regular event,
+	// with the same namespace, room and event of the message.
+	EmitUnaskedReply
+)
+
+// SetWithReply registers the "fn" as the callback of the "event", its result answers the message:
+// when the message was sent by an `Ask`, the returned body is the body of the response
+// and the returned error is its `Message.Err`, the remote `Ask` returns it.
+// When it was not, the body is discarded or sent back as a regular event, see the optional "unasked" argument,
+// and the error is handled like the error of any other callback.
+//
+// It's safe to be called while the events are used, like the `Set` method,
+// the events of both callback types can be registered on the same events.
+func (e Events) SetWithReply(event string, fn ReplyHandlerFunc, unasked ...UnaskedReply) {
+	emit := len(unasked) > 0 && unasked[0] == EmitUnaskedReply
+
+	e.Set(event, func(ns *NSConn, msg Message) error {
+		body, err := fn(ns, msg)
+		if err != nil {
+			return err
+		}
+
+		if msg.wait != "" || emit {
+			return Reply(body)
+		}
+
+		return nil
+	})
+}
+
+// Middleware wraps the "next" event callback, see `Events#Use`.
+// It may return an error without calling the "next" one, i.e when the connection is not authorized,
+// the error is handled like the error of the event's callback.
+type Middleware = func(next MessageHandlerFunc) MessageHandlerFunc
+
+// eventsConfig is the configuration of the events which is not an event callback,
+// i.e the middleware of the `Events#Use`, the timeouts of the `Events#WithTimeout`,
+// the pools of the `Events#Async`, the directions of the `Events#ServerOnly` and `Events#ClientOnly`,
+// the concurrent events of the `Events#Concurrent`, the rate limits of the `Events#SetLimited`,
+// the precedence of the `Events#OnAny` and the binary namespaces of the `WithBinaryNamespace`.
+// It's kept by the state of the events, see `eventsState`.
+type eventsConfig struct {
+	middleware     []Middleware
+	timeouts       map[string]time.Duration
+	pools          map[string]*HandlerPool
+	directions     map[string]eventDirection
+	concurrent     map[string]bool
+	limit          int
+	rates          map[string]Rate
+	anyEventAlways bool
+	binary         bool
+}
+
+// config returns the configuration of the events, it's nil if there is none.
+func (e Events) config() *eventsConfig {
+	return e.state().configuration()
+}
+
+// updateConfig stores a copy of the configuration of the events, modified by the "update".
+// The configuration is copied so the events which share it keep theirs, see `shareConfig`.
+func (e Events) updateConfig(update func(cfg *eventsConfig)) {
+	s := e.loadOrStoreState()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := s.configuration().clone()
+	update(cfg)
+	s.config.Store(cfg)
+}
+
+// shareConfig shares the configuration of the "from" events, if any, with these, i.e with a copy of them,
+// see `JoinConnHandlers`. It's copied when one of them updates it.
+func (e Events) shareConfig(from Events) {
+	if cfg := from.config(); cfg != nil {
+		e.loadOrStoreState().config.Store(cfg)
+	}
+}
+
+// clone returns a copy of the configuration, an empty one if it's nil.
+func (cfg *eventsConfig) clone() *eventsConfig {
+	clone := new(eventsConfig)
+	if cfg == nil {
+		return clone
+	}
+
+	clone.middleware = append(clone.middleware, cfg.middleware...)
+	if cfg.timeouts != nil {
+		clone.timeouts = make(map[string]time.Duration, len(cfg.timeouts))
+		for event, timeout := range cfg.timeouts {
+			clone.timeouts[event] = timeout
+		}
+	}
+	if cfg.pools != nil {
+		clone.pools = make(map[string]*HandlerPool, len(cfg.pools))
+		for event, pool := range cfg.pools {
+			clone.pools[event] = pool
+		}
+	}
+	if cfg.directions != nil {
+		clone.directions = make(map[string]eventDirection, len(cfg.directions))
+		for event, direction := range cfg.directions {
+			clone.directions[event] = direction
+		}
+	}
+	if cfg.concurrent != nil {
+		clone.concurrent = make(map[string]bool, len(cfg.concurrent))
+		for event, concurrent := range cfg.concurrent {
+			clone.concurrent[event] = concurrent
+		}
+	}
+	clone.limit = cfg.limit
+	if cfg.rates != nil {
+		clone.rates = make(map[string]Rate, len(cfg.rates))
+		for event, rate := range cfg.rates {
+			clone.rates[event] = rate
+		}
+	}
+	clone.anyEventAlways = cfg.anyEventAlways
+	clone.binary = cfg.binary
+
+	return clone
+}
+
+// merge adds the "other" configuration to this one, the "other" wins on the same event.
+// Its middleware wrap the callbacks after the ones of this configuration.
+func (cfg *eventsConfig) merge(other *eventsConfig) {
+	cfg.middleware = append(cfg.middleware, other.middleware...)
+	for event, timeout := range other.timeouts {
+		if cfg.timeouts == nil {
+			cfg.timeouts = make(map[string]time.Duration)
+		}
+		cfg.timeouts[event] = timeout
+	}
+	for event, pool := range other.pools {
+		if cfg.pools == nil {
+			cfg.pools = make(map[string]*HandlerPool)
+		}
+		cfg.pools[event] = pool
+	}
+	for event, direction := range other.directions {
+		if cfg.directions == nil {
+			cfg.directions = make(map[string]eventDirection)
+		}
+		cfg.directions[event] = direction
+	}
+	for event, concurrent := range other.concurrent {
+		if cfg.concurrent == nil {
+			cfg.concurrent = make(map[string]bool)
+		}
+		cfg.concurrent[event] = concurrent
+	}
+	if other.limit > 0 {
+		cfg.limit = other.limit
+	}
+	for event, rate := range other.rates {
+		if cfg.rates == nil {
+			cfg.rates = make(map[string]Rate)
+		}
+		cfg.rates[event] = rate
+	}
+	cfg.anyEventAlways = cfg.anyEventAlways || other.anyEventAlways
+	cfg.binary = cfg.binary || other.binary
+}
+
+// Use registers one or more middleware to the events, they wrap the callback of each event
+// which is fired on this namespace, including the lifecycle events (i.e `OnNamespaceConnect` and `OnRoomJoin`)
+// and the events without a callback. The middleware are called in the order they are registered,
+// after the ones of the `Server#Use`, the first one is the outermost.
+// The callbacks which are registered after the `Use` are wrapped too.
+//
+// Example:
+//
+//	events.Use(func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+//		return func(c *neffos.NSConn, msg neffos.Message) error {
+//			if c.Conn.Get("user") == nil {
+//				return errUnauthorized
+//			}
+//
+//			return next(c, msg)
+//		}
+//	})
+//
+// See the "kataras/neffos/middleware" subpackage for builtin middleware.
+func (e Events) Use(middleware ...Middleware) {
+	if len(middleware) == 0 {
+		return
+	}
+
+	e.updateConfig(func(cfg *eventsConfig) {
+		cfg.middleware = append(cfg.middleware, middleware...)
+	})
+}
+
+// WithTimeout sets the maximum duration of the callback of an incoming "event", including its middleware.
+// The `Message.Context` of the callback is cancelled after the "timeout",
+// the connection stops waiting for it and reads its next message,
+// its `ErrHandlerTimeout` is sent back to the remote side (an `Ask` returns it) and it fires the `Server#OnError`
+// wrapped by an `EventError`.
+// The callback keeps running on its own goroutine, its result is discarded,
+// so it should return early when its context is done. Until it returns, it overlaps with the callbacks
+// of the next messages of the same namespace connection, their order is not kept, and the reader
+// of the connection no longer treats it as its running callback, i.e on its `Conn#Ask` calls.
+//
+// The `OnAnyEvent` "event" sets the default timeout of the events of this namespace,
+// a zero "timeout" removes the timeout of the "event".
+// The lifecycle events (i.e `OnNamespaceConnect`) are not affected.
+func (e Events) WithTimeout(event string, timeout time.Duration) Events {
+	e.updateConfig(func(cfg *eventsConfig) {
+		if timeout <= 0 {
+			delete(cfg.timeouts, event)
+			return
+		}
+
+		if cfg.timeouts == nil {
+			cfg.timeouts = make(map[string]time.Duration)
+		}
+		cfg.timeouts[event] = timeout
+	})
+
+	return e
+}
+
+// timeout returns the timeout of the "event", see `WithTimeout`.
+func (cfg *eventsConfig) timeout(event string) time.Duration {
+	if cfg == nil || len(cfg.timeouts) == 0 {
+		return 0
+	}
+
+	if timeout, ok := cfg.timeouts[event]; ok {
+		return timeout
+	}
+
+	return cfg.timeouts[OnAnyEvent]
+}
+
+// Async fires the callback of an incoming "event" on the "pool" instead of the connection's reader goroutine,
+// so the connection reads its next message while the callback is running.
+// The `HandlerPoolOptions` of the "pool" decide whether the messages of a connection keep their order
+// and what happens when its queue is full.
+// The result of the callback is sent back to the remote side when it returns,
+// an `Ask` receives it as if the callback was fired synchronously, see `NSConn#DeferReply` too.
+// The timeout of the "event", if any, starts when a worker fires the callback, see `WithTimeout`.
+//
+// The `OnAnyEvent` "event" sets the default pool of the events of this namespace,
+// a nil "pool" removes the pool of the "event".
+// The lifecycle events (i.e `OnNamespaceConnect`) are always fired synchronously.
+//
+// Example:
+//
+//	pool := neffos.NewHandlerPool(neffos.HandlerPoolOptions{Workers: 16, Overflow: neffos.OverflowReject})
+//	events.Async("report", pool)
+func (e Events) Async(event string, pool *HandlerPool) Events {
+	e.updateConfig(func(cfg *eventsConfig) {
+		if pool == nil {
+			delete(cfg.pools, event)
+			return
+		}
+
+		if cfg.pools == nil {
+			cfg.pools = make(map[string]*HandlerPool)
+		}
+		cfg.pools[event] = pool
+	})
+
+	return e
+}
+
+// pool returns the pool of the "event", see `Async`.
+func (cfg *eventsConfig) pool(event string) *HandlerPool {
+	if cfg == nil || len(cfg.pools) == 0 {
+		return nil
+	}
+
+	if pool, ok := cfg.pools[event]; ok {
+		return pool
+	}
+
+	return cfg.pools[OnAnyEvent]
+}
+
+// DefaultConcurrencyLimit is the default maximum number of the running callbacks
+// of the concurrent events of a namespace connection, see `Events#Concurrent`.
+const DefaultConcurrencyLimit = 64
+
+// Concurrent declares the "events" as concurrent ones, the callbacks of their messages are fired
+// on their own goroutines, so they may run at the same time, even for the same connection,
+// and the connection reads its next message while they are running.
+// Their results are sent back when they return, see `Async` too.
+//
+// The rest of the events are ordered ones: the callbacks of the messages of a connection
+// are fired one at a time, in the order they were received, on the connection's reader goroutine.
+// The `OnAnyEvent` "event" makes the events of this namespace concurrent by default,
+// use the `Ordered` method to declare the exceptions.
+//
+// The running callbacks of a namespace connection are limited to the `DefaultConcurrencyLimit`,
+// see `SetConcurrencyLimit`. The events with a pool are fired through their `HandlerPool`,
+// its ordering applies to them instead. The lifecycle events (i.e `OnNamespaceConnect`) are always ordered.
+func (e Events) Concurrent(events ...string) Events {
+	return e.setConcurrent(true, events)
+}
+
+// Ordered declares the "events" as ordered ones, when the namespace's events are concurrent by default.
+// See `Concurrent`.
+func (e Events) Ordered(events ...string) Events {
+	return e.setConcurrent(false, events)
+}
+
+func (e Events) setConcurrent(concurrent bool, events []string) Events {
+	if len(events) == 0 {
+		return e
+	}
+
+	e.updateConfig(func(cfg *eventsConfig) {
+		if cfg.concurrent == nil {
+			cfg.concurrent = make(map[string]bool)
+		}
+
+		for _, event := range events {
+			cfg.concurrent[event] = concurrent
+		}
+	})
+
+	return e
+}
+
+// SetConcurrencyLimit sets the maximum number of the running callbacks of the concurrent events
+// of a namespace connection, the connection waits for a running callback to return before it reads
+// its next message when the "limit" is reached. See `Concurrent`.
+//
+// Defaults to the `DefaultConcurrencyLimit`.
+func (e Events) SetConcurrencyLimit(limit int) Events {
+	e.updateConfig(func(cfg *eventsConfig) {
+		cfg.limit = limit
+	})
+
+	return e
+}
+
+// isConcurrent reports whether the "event" is a concurrent one, see `Concurrent`.
+func (cfg *eventsConfig) isConcurrent(event string) bool {
+	if cfg == nil || len(cfg.concurrent) == 0 {
+		return false
+	}
+
+	if concurrent, ok := cfg.concurrent[event]; ok {
+		return concurrent
+	}
+
+	return cfg.concurrent[OnAnyEvent]
+}
+
+func (cfg *eventsConfig) concurrencyLimit() int {
+	if cfg == nil || cfg.limit <= 0 {
+		return DefaultConcurrencyLimit
+	}
+
+	return cfg.limit
+}
+
+// eventDirection is the side which an event can be sent from, see `Events#ServerOnly`.
+type eventDirection uint8
+
+const (
+	serverOnly eventDirection = iota + 1
+	clientOnly
+)
+
+// ServerOnly declares the "events" as events which only the server sends, i.e notifications like "grantAdmin".
+// The server rejects an incoming message of these events with the `ErrServerOnlyEvent`, which is sent back
+// to the client (an `Ask` returns it) and fires the `Server#OnError`, their callbacks are not fired.
+// The server emits them as usual and the client receives them.
+//
+// The same events can be used on both sides, see `JoinConnHandlers`.
+func (e Events) ServerOnly(events ...string) Events {
+	return e.setDirection(serverOnly, events)
+}
+
+// ClientOnly declares the "events" as events which only the client sends,
+// the client rejects an incoming message of these events with the `ErrClientOnlyEvent`,
+// their callbacks are not fired. See `ServerOnly` too.
+func (e Events) ClientOnly(events ...string) Events {
+	return e.setDirection(clientOnly, events)
+}
+
+func (e Events) setDirection(direction eventDirection, events []string) Events {
+	if len(events) == 0 {
+		return e
+	}
+
+	e.updateConfig(func(cfg *eventsConfig) {
+		if cfg.directions == nil {
+			cfg.directions = make(map[string]eventDirection)
+		}
+
+		for _, event := range events {
+			cfg.directions[event] = direction
+		}
+	})
+
+	return e
+}
+
+// rejectIncoming returns the error of an incoming "event" which is received
+// by the wrong side, see `ServerOnly` and `ClientOnly`.
+func (cfg *eventsConfig) rejectIncoming(event string, isClient bool) error {
+	if cfg == nil || len(cfg.directions) == 0 {
+		return nil
+	}
+
+	switch cfg.directions[event] {
+	case serverOnly:
+		if !isClient {
+			return ErrServerOnlyEvent
+		}
+	case clientOnly:
+		if isClient {
+			return ErrClientOnlyEvent
+		}
+	}
+
+	return nil
+}
+
+// AnyEventPrecedence decides when the `OnAnyEvent` callback of a namespace is fired,
+// see `Events#OnAny`.
+type AnyEventPrecedence uint8
+
+const (
+	// AnyEventFallback fires the `OnAnyEvent` callback instead of the callback of an event
+	// which is not registered, neither by its exact name nor by a prefix.
+	// The lifecycle events, i.e the `OnNamespaceConnect`, are passed to it too.
+	// It's the default precedence.
+	AnyEventFallback AnyEventPrecedence = iota
+	// AnyEventAlways fires the `OnAnyEvent` callback for each incoming application event,
+	// before its own callback, if any, with the same message. The lifecycle events are not passed to it,
+	// see `IsSystemEvent`. A non-nil error of the `OnAnyEvent` callback is handled like the error of the event's callback,
+	// the event's callback is not fired then.
+	AnyEventAlways
+)
+
+// OnAny registers the "msgHandler" as the `OnAnyEvent` callback, i.e an audit callback of all the events,
+// with the "precedence" over the callbacks of the rest of the events.
+func (e Events) OnAny(precedence AnyEventPrecedence, msgHandler MessageHandlerFunc) {
+	e.updateConfig(func(cfg *eventsConfig) {
+		cfg.anyEventAlways = precedence == AnyEventAlways
+	})
+	e.Set(OnAnyEvent, msgHandler)
+}
+
+// isAnyEventAlways reports whether the `OnAnyEvent` callback is fired before the callback of each event,
+// see `AnyEventAlways`.
+func (cfg *eventsConfig) isAnyEventAlways() bool {
+	return cfg != nil && cfg.anyEventAlways
+}
+
+// EventPrefixWildcard is the suffix of the event names which match all the events under their prefix,
+// i.e "doc.edit.*" matches the "doc.edit.insert" and "doc.edit.cursor.move" events. See `Events#OnPrefix`.
+const EventPrefixWildcard = ".*"
+
+// match returns the callback of the "event", an exact match
+// takes precedence over the longest matched prefix.
+func (e Events) match(event string) (MessageHandlerFunc, bool) {
+	if h, ok := e[event]; ok {
+		return h, true
+	}
+
+	for prefix := event; ; {
+		idx := strings.LastIndexByte(prefix, '.')
+		if idx <= 0 {
+			return nil, false
+		}
+
+		prefix = prefix[:idx]
+		if h, ok := e[prefix+EventPrefixWildcard]; ok {
+			return h, true
+		}
+	}
+}
+
 // On is a shortcut of Events { eventName: msgHandler }.
 // It registers a callback "msgHandler" for an event "eventName".
+// Use the `Set` method to register an event while the events are used by a server or a client.
 func (e Events) On(eventName string, msgHandler MessageHandlerFunc) {
 	e[eventName] = msgHandler
 }
 
+// OnPrefix registers a callback "msgHandler" for all the events under the "prefix",
+// i.e the "doc.edit" prefix matches the "doc.edit.insert" and "doc.edit.delete" events.
+// It's a shortcut of Events { "doc.edit.*": msgHandler }, see `EventPrefixWildcard`.
+//
+// The callback is fired when there is no exact event callback registered,
+// the longest matched prefix wins and the `OnAnyEvent` is fired only if no prefix matches.
+// The `Message.Event` keeps the incoming event name.
+func (e Events) OnPrefix(prefix string, msgHandler MessageHandlerFunc) {
+	prefix = strings.TrimSuffix(strings.TrimSuffix(prefix, EventPrefixWildcard), ".")
+	e[prefix+EventPrefixWildcard] = msgHandler
+}
+
 // Namespaces completes the `ConnHandler` interface.
 // Can be used to register one or more namespaces on the `New` and `Dial` functions.
 // The key is the namespace literal and the value is the `Events`,
@@ -76,6 +825,121 @@ func (nss Namespaces) On(namespace, eventName string, msgHandler MessageHandlerF
 	return nss[namespace]
 }
 
+// makeLive makes the events of the namespaces live, see `Events#Set`.
+func (nss Namespaces) makeLive() {
+	for _, events := range nss {
+		if events != nil {
+			events.makeLive()
+		}
+	}
+}
+
+// Use registers one or more middleware to the events of all the namespaces, see `Events#Use`.
+// It should be called after the namespaces are registered.
+func (nss Namespaces) Use(middleware ...Middleware) {
+	for _, events := range nss {
+		events.Use(middleware...)
+	}
+}
+
+// Describe returns the names of the events of each namespace, including the cached dynamic namespaces
+// (see `SetDynamic`), i.e for an admin UI or a contract test. The events which are registered
+// through a reserved name, i.e the lifecycle events (see `IsSystemEvent`) and the `OnAnyEvent`,
+// keep their "_" prefixed names and they are listed first, the rest follow, each group is sorted by name.
+// The result is a new map which is safe to be modified.
+func (nss Namespaces) Describe() map[string][]string {
+	description := make(map[string][]string, len(nss))
+	for namespace, events := range nss {
+		description[namespace] = events.describe()
+	}
+
+	if dynamic := nss.dynamic(); dynamic != nil {
+		for namespace, events := range dynamic.cached() {
+			if _, exists := description[namespace]; !exists {
+				description[namespace] = events.describe()
+			}
+		}
+	}
+
+	return description
+}
+
+// describe returns the sorted names of the events, see `Namespaces#Describe`.
+func (e Events) describe() []string {
+	current := e.current()
+	names := make([]string, 0, len(current))
+	for event := range current {
+		names = append(names, event)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if iReserved, jReserved := strings.HasPrefix(names[i], "_"), strings.HasPrefix(names[j], "_"); iReserved != jReserved {
+			return iReserved
+		}
+
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// WithShared returns a copy of the namespaces where the events of each namespace
+// are merged with the shared "base" events, i.e the auth refresh and error reporting events
+// which are common to all of them. The events of a namespace override the "base" ones,
+// see `MergeEvents` for the "options". The events of the dynamic namespaces are merged too, see `SetDynamic`.
+//
+// Example:
+//
+//	neffos.New(upgrader, neffos.Namespaces{
+//		"chat":  chatEvents,
+//		"admin": adminEvents,
+//	}.WithShared(sharedEvents, neffos.ChainLifecycle))
+func (nss Namespaces) WithShared(base Events, options ...MergeOption) Namespaces {
+	namespaces := make(Namespaces, len(nss))
+	for namespace, events := range nss {
+		namespaces[namespace] = MergeEvents(base, events, options...)
+	}
+
+	if dynamic := nss.dynamic(); dynamic != nil {
+		namespaces.SetDynamic(func(namespace string) (Events, bool) {
+			events, ok := dynamic.factory(namespace)
+			if !ok {
+				return nil, false
+			}
+
+			return MergeEvents(base, events, options...), true
+		})
+	}
+
+	return namespaces
+}
+
+// WithBinaryNamespace returns a `ConnHandler` which declares the "namespaces" as binary ones,
+// the messages emitted through their `NSConn` (and its rooms) are sent as binary frames by default,
+// as if their `Message.SetBinary` was true. Use the `NSConn#EmitText` to send a text frame instead.
+//
+// It should be joined with the rest of the namespaces through `JoinConnHandlers`,
+// so the same declaration applies to both the server and the client side, e.g.
+//
+//	neffos.JoinConnHandlers(namespaces, neffos.WithBinaryNamespace("voice-meta"))
+func WithBinaryNamespace(namespaces ...string) ConnHandler {
+	nss := make(Namespaces, len(namespaces))
+	for _, namespace := range namespaces {
+		events := Events{}
+		events.updateConfig(func(cfg *eventsConfig) {
+			cfg.binary = true
+		})
+		nss[namespace] = events
+	}
+
+	return nss
+}
+
+// isBinary reports whether the namespace is a binary one, see `WithBinaryNamespace`.
+func (cfg *eventsConfig) isBinary() bool {
+	return cfg != nil && cfg.binary
+}
+
 // WithTimeout completes the `ConnHandler` interface.
 // Can be used to register namespaces and events or just events on an empty namespace
 // with Read and Write timeouts.
@@ -95,6 +959,11 @@ func (t WithTimeout) GetNamespaces() Namespaces {
 	return JoinConnHandlers(t.Namespaces, t.Events).GetNamespaces()
 }
 
+// Describe returns the names of the events of each namespace, see `Namespaces#Describe`.
+func (t WithTimeout) Describe() map[string][]string {
+	return t.GetNamespaces().Describe()
+}
+
 func getTimeouts(h ConnHandler) (readTimeout time.Duration, writeTimeout time.Duration) {
 	if t, ok := h.(WithTimeout); ok {
 		readTimeout = t.ReadTimeout
@@ -127,6 +996,11 @@ type Struct struct {
 	// This field is set when external dependency injection system is used.
 	injector StructInjector
 
+	// set by the `NewStructFactory`, the "ptr" is invalid then.
+	factory reflect.Value
+	// the values which can be passed to the "factory", see `Provide`.
+	dependencies []reflect.Value
+
 	events Events
 }
 
@@ -193,6 +1067,22 @@ func (s *Struct) SetInjector(fn StructInjector) *Struct {
 	return s
 }
 
+// Provide registers one or more values, i.e shared services like a database,
+// which are passed to the input arguments of the factory of a `NewStructFactory` by their type.
+// An input argument of an interface type accepts the first value which implements it.
+// It should be called before the `Struct` is passed to the `New` or `Dial` functions.
+func (s *Struct) Provide(values ...interface{}) *Struct {
+	for _, value := range values {
+		if value == nil {
+			panic("Provide: value is nil")
+		}
+
+		s.dependencies = append(s.dependencies, reflect.ValueOf(value))
+	}
+
+	return s
+}
+
 // NewStruct returns a new Struct value instance type of ConnHandler.
 // The "ptr" should be a pointer to a struct.
 // This function is used when you want to convert a structure to
@@ -253,6 +1143,47 @@ func NewStruct(ptr interface{}) *Struct {
 	}
 }
 
+// NewStructFactory returns a new Struct value instance type of ConnHandler
+// which creates a controller for each connection to its namespace through the "factory",
+// so a controller can keep the state of its connection on its own fields, without locks.
+// The "factory" should be a function which returns a pointer to a struct, and optionally an error
+// which refuses the namespace connection. Its input arguments are the `*NSConn` of the connection
+// and the values registered through the `Provide` method, e.g.
+//
+//	func(c *neffos.NSConn, db *sql.DB) *chatController
+//
+// The exported methods of the controller are its events, they can be like
+// func(msg neffos.Message) error or like any event callback: func(c *neffos.NSConn, msg neffos.Message) error.
+// The system events (i.e `OnNamespaceConnected` and `OnNamespaceDisconnect`) are recognized too,
+// the controller is created right before its `OnNamespaceConnect`.
+//
+// The methods and the input arguments of the "factory" are resolved once, when the events are built,
+// and the methods are bound once per connection, an event is not looked up by reflection on each message.
+// See `SetNamespace` and `SetEventMatcher` too.
+func NewStructFactory(factory interface{}) *Struct {
+	if factory == nil {
+		panic("NewStructFactory: factory is nil")
+	}
+
+	v := reflect.ValueOf(factory)
+	typ := v.Type()
+	if typ.Kind() != reflect.Func {
+		panic("NewStructFactory: factory should be a function")
+	}
+
+	if numOut := typ.NumOut(); numOut == 0 || numOut > 2 || (numOut == 2 && typ.Out(1) != errType) {
+		panic("NewStructFactory: factory should return a controller and optionally an error")
+	}
+
+	if out := typ.Out(0); out.Kind() != reflect.Ptr || out.Elem().Kind() != reflect.Struct {
+		panic("NewStructFactory: factory should return a pointer to a struct")
+	}
+
+	return &Struct{
+		factory: v,
+	}
+}
+
 // Events builds and returns the Events.
 // Callers of this method is users that want to add Structs to different namespaces
 // in the same application.
@@ -263,6 +1194,11 @@ func (s *Struct) Events() Events {
 		return s.events
 	}
 
+	if s.factory.IsValid() {
+		s.events = makeEventsFromFactory(s.factory, s.eventMatcher, s.dependencies)
+		return s.events
+	}
+
 	s.events = makeEventsFromStruct(s.ptr, s.eventMatcher, s.injector)
 	return s.events
 }
@@ -271,7 +1207,12 @@ func (s *Struct) Events() Events {
 // pointer to struct value provided by the "s".
 func (s *Struct) GetNamespaces() Namespaces { // completes the `ConnHandler` interface.
 	if s.namespace == "" {
-		s.namespace, _ = resolveStructNamespace(s.ptr)
+		if s.factory.IsValid() {
+			// through the `Namespace() string` method of a zero controller.
+			s.namespace, _ = resolveStructNamespace(reflect.New(s.factory.Type().Out(0).Elem()))
+		} else {
+			s.namespace, _ = resolveStructNamespace(s.ptr)
+		}
 	}
 
 	return Namespaces{
@@ -279,6 +1220,11 @@ func (s *Struct) GetNamespaces() Namespaces { // completes the `ConnHandler` int
 	}
 }
 
+// Describe returns the names of the events of the struct's namespace, see `Namespaces#Describe`.
+func (s *Struct) Describe() map[string][]string {
+	return s.GetNamespaces().Describe()
+}
+
 // JoinConnHandlers combines two or more "connHandlers"
 // and returns a result of a single `ConnHandler` that
 // can be passed on the `New` and `Dial` functions.
@@ -292,23 +1238,110 @@ func JoinConnHandlers(connHandlers ...ConnHandler) ConnHandler {
 				if events == nil {
 					continue
 				}
-				clonedEvents := make(Events, len(events))
-				for evt, cb := range events {
-					clonedEvents[evt] = cb
-				}
+				clonedEvents := events.copy()
 
 				if curEvents, exists := namespaces[namespace]; exists {
 					// fill missing events.
 					for evt, cb := range clonedEvents {
 						curEvents[evt] = cb
 					}
-
+					if cfg := events.config(); cfg != nil {
+						curEvents.updateConfig(func(merged *eventsConfig) {
+							merged.merge(cfg)
+						})
+					}
 				} else {
+					clonedEvents.shareConfig(events)
 					namespaces[namespace] = clonedEvents
 				}
 			}
 		}
+
+		if dynamic := nss.dynamic(); dynamic != nil {
+			namespaces.setDynamic(dynamic)
+		}
 	}
 
 	return namespaces
 }
+
+// MergeOption modifies the behavior of the `MergeEvents`.
+type MergeOption uint8
+
+const (
+	// ChainLifecycle fires both the base and the override callbacks of a lifecycle event
+	// (i.e `OnNamespaceConnected`, see `IsSystemEvent`), the base one first.
+	// The override one is not fired when the base one returns an error, which is returned instead,
+	// so a base `OnNamespaceConnect` can still reject the connection.
+	ChainLifecycle MergeOption = iota + 1
+)
+
+// ErrDuplicateEvent is returned from the `MergeEventsStrict`
+// when an event, other than a lifecycle one, is registered on both the base and the override events.
+var ErrDuplicateEvent = errors.New("duplicate event")
+
+// MergeEvents returns new events which contain the callbacks of both the "base" and the "overrides" events,
+// the callback of the "overrides" wins when an event is registered on both,
+// except of the lifecycle events when the `ChainLifecycle` option is passed.
+// The configuration of the events (i.e the middleware of the `Events#Use`) is merged too,
+// the middleware of the "base" wrap the ones of the "overrides".
+//
+// The "base" can be shared by many namespaces, see `Namespaces#WithShared` and `MergeEventsStrict` too.
+func MergeEvents(base, overrides Events, options ...MergeOption) Events {
+	chain := false
+	for _, option := range options {
+		if option == ChainLifecycle {
+			chain = true
+		}
+	}
+
+	merged := base.copy()
+	merged.shareConfig(base)
+	for event, cb := range overrides.copy() {
+		if prev := merged[event]; chain && prev != nil && cb != nil && IsSystemEvent(event) {
+			cb = chainEvents(prev, cb)
+		}
+
+		merged[event] = cb
+	}
+
+	if cfg := overrides.config(); cfg != nil {
+		merged.updateConfig(func(merged *eventsConfig) {
+			merged.merge(cfg)
+		})
+	}
+
+	return merged
+}
+
+// MergeEventsStrict is like the `MergeEvents` but it returns an error which wraps the `ErrDuplicateEvent`
+// when an event, other than a lifecycle one, is registered on both the "base" and the "overrides" events,
+// so an override cannot replace a shared callback by accident.
+func MergeEventsStrict(base, overrides Events, options ...MergeOption) (Events, error) {
+	var duplicates []string
+	current := base.current()
+	for event := range overrides.current() {
+		if _, exists := current[event]; exists && !IsSystemEvent(event) {
+			duplicates = append(duplicates, event)
+		}
+	}
+
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateEvent, strings.Join(duplicates, ", "))
+	}
+
+	return MergeEvents(base, overrides, options...), nil
+}
+
+// chainEvents returns a callback which fires the "first" and then the "second" callback,
+// the "second" is not fired when the "first" returns an error.
+func chainEvents(first, second MessageHandlerFunc) MessageHandlerFunc {
+	return func(c *NSConn, msg Message) error {
+		if err := first(c, msg); err != nil {
+			return err
+		}
+
+		return second(c, msg)
+	}
+}
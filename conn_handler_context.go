@@ -0,0 +1,120 @@
+package neffos
+
+import (
+	"context"
+)
+
+// ContextHandlerFunc is the definition type of an event callback which receives a context,
+// see `Events#SetCtx`.
+type ContextHandlerFunc func(ctx context.Context, ns *NSConn, msg Message) error
+
+type handlerContextKey uint8
+
+const (
+	nsConnContextKey handlerContextKey = iota
+	messageContextKey
+	traceIDContextKey
+)
+
+// traceContext is the context of a handled message which carries its trace ID, see `TraceIDFromContext`.
+type traceContext struct {
+	context.Context
+	traceID string
+}
+
+func (ctx *traceContext) Value(key interface{}) interface{} {
+	if key == traceIDContextKey {
+		return ctx.traceID
+	}
+
+	return ctx.Context.Value(key)
+}
+
+// contextWithTraceID returns the "parent" as it is if the "traceID" is empty,
+// so the untraced messages do not allocate.
+func contextWithTraceID(parent context.Context, traceID string) context.Context {
+	if traceID == "" {
+		return parent
+	}
+
+	return &traceContext{Context: parent, traceID: traceID}
+}
+
+// handlerContext is the context of a `ContextHandlerFunc`,
+// it carries the namespace connection and the message without an allocation for each value.
+type handlerContext struct {
+	context.Context
+	ns  *NSConn
+	msg Message
+}
+
+func (ctx *handlerContext) Value(key interface{}) interface{} {
+	switch key {
+	case nsConnContextKey:
+		return ctx.ns
+	case messageContextKey:
+		return ctx.msg
+	case traceIDContextKey:
+		return ctx.msg.TraceID
+	default:
+		return ctx.Context.Value(key)
+	}
+}
+
+// SetCtx registers the "fn" as the callback of the "event", like the `Set` method,
+// the callback receives a context which can be passed to the downstream calls.
+// The context derives from the `Message#Context`, so it's cancelled when the connection is closed
+// or when the callback times out (see `WithTimeout`), it keeps the values which the middleware
+// set through the `Message#WithContext` and it carries the namespace connection and the message,
+// see `NSConnFromContext`, `MessageFromContext` and `TraceIDFromContext`.
+//
+// The events of both callback types can be registered on the same events.
+func (e Events) SetCtx(event string, fn ContextHandlerFunc) {
+	e.Set(event, func(ns *NSConn, msg Message) error {
+		parent := msg.ctx
+		if parent == nil {
+			// i.e a lifecycle event.
+			parent = context.Background()
+			if ns != nil && ns.Conn != nil {
+				parent = ns.Conn.ctx
+			}
+		}
+
+		return fn(&handlerContext{Context: parent, ns: ns, msg: msg}, ns, msg)
+	})
+}
+
+// WithContext returns a copy of the message with its context replaced by the "ctx",
+// i.e a middleware can add a value to the context of the callback:
+//
+//	return next(c, msg.WithContext(context.WithValue(msg.Context(), claimsKey, claims)))
+//
+// See `Context` and `Events#SetCtx`.
+func (m *Message) WithContext(ctx context.Context) Message {
+	msg := *m
+	msg.ctx = ctx
+	return msg
+}
+
+// NSConnFromContext returns the namespace connection of the context of a `ContextHandlerFunc`, if any.
+func NSConnFromContext(ctx context.Context) *NSConn {
+	ns, _ := ctx.Value(nsConnContextKey).(*NSConn)
+	return ns
+}
+
+// MessageFromContext returns the incoming message of the context of a `ContextHandlerFunc`, if any.
+func MessageFromContext(ctx context.Context) (Message, bool) {
+	msg, ok := ctx.Value(messageContextKey).(Message)
+	return msg, ok
+}
+
+// TraceIDFromContext returns the `Message.TraceID` of the incoming message
+// of the context of an event callback, i.e its `Message#Context` or the context of a `ContextHandlerFunc`, if any.
+func TraceIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	traceID, _ := ctx.Value(traceIDContextKey).(string)
+	return traceID
+}
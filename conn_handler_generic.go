@@ -0,0 +1,126 @@
+//go:build go1.18
+
+package neffos
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// BodyDecodeError is returned from the callback of an event registered through the `On`
+// when the incoming `Message.Body` cannot be decoded, it's sent back to the remote side like any other error.
+type BodyDecodeError struct {
+	// Event is the name of the incoming event.
+	Event string
+	// Type is the name of the type which the body was decoded into.
+	Type string
+	// Err is the error of the codec.
+	Err error
+}
+
+func (e *BodyDecodeError) Error() string {
+	return fmt.Sprintf("decode body of event %q into %s: %v", e.Event, e.Type, e.Err)
+}
+
+// Unwrap returns the error of the codec.
+func (e *BodyDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// On registers the "fn" as the callback of the "event" of the "events",
+// the incoming `Message.Body` is decoded into a "T" value through the connection's codec,
+// see `Message#Unmarshal`, before the "fn" is called with it.
+// The "T" can be a pointer, a new value is allocated for each message then, or a value type.
+// An empty body is passed as the zero "T", i.e nil for a pointer.
+// A body which cannot be decoded is not passed to the "fn", the `BodyDecodeError` is returned instead.
+//
+// Example:
+//
+//	neffos.On(events, "chat", func(c *neffos.NSConn, msg neffos.Message, body chatMessage) error {
+//		return neffos.ReplyValue(c, chatAck{ID: body.ID})
+//	})
+func On[T any](events Events, event string, fn func(ns *NSConn, msg Message, body T) error) {
+	events[event] = decodeBody(event, fn)
+}
+
+// decodeBody returns a callback which decodes the body into a "T" and calls the "fn" with it.
+func decodeBody[T any](event string, fn func(ns *NSConn, msg Message, body T) error) MessageHandlerFunc {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	typeName := typ.String()
+
+	if typ.Kind() == reflect.Ptr {
+		elem := typ.Elem()
+
+		return func(ns *NSConn, msg Message) error {
+			var body T
+			if len(msg.Body) > 0 {
+				body = reflect.New(elem).Interface().(T)
+				if err := msg.Unmarshal(body); err != nil {
+					return &BodyDecodeError{Event: msg.Event, Type: typeName, Err: err}
+				}
+			}
+
+			return fn(ns, msg, body)
+		}
+	}
+
+	// the decoders reuse their values, so a value "T" is not allocated for each message.
+	pool := sync.Pool{New: func() interface{} { return new(T) }}
+
+	return func(ns *NSConn, msg Message) error {
+		var body T
+		if len(msg.Body) > 0 {
+			ptr := pool.Get().(*T)
+			err := msg.Unmarshal(ptr)
+			body, *ptr = *ptr, body // reset it, do not keep the decoded value alive.
+			pool.Put(ptr)
+
+			if err != nil {
+				return &BodyDecodeError{Event: msg.Event, Type: typeName, Err: err}
+			}
+		}
+
+		return fn(ns, msg, body)
+	}
+}
+
+// ReplyValue returns a `Reply` of the "v" value which is encoded through the codec of the "ns" connection,
+// see `Conn#Marshal`. It should be returned from an event callback to answer an `Ask`, see `AskValue` too.
+func ReplyValue[T any](ns *NSConn, v T) error {
+	if ns == nil || ns.Conn == nil {
+		return Reply(Marshal(v))
+	}
+
+	return Reply(ns.Conn.Marshal(v))
+}
+
+// AskValue performs an `NSConn#Ask` of the "v" value, encoded through the codec of the "ns" connection,
+// and decodes the body of the response into a "R" value, see `ReplyValue`.
+// An empty response body is returned as the zero "R".
+func AskValue[R any, T any](ctx context.Context, ns *NSConn, event string, v T) (R, error) {
+	var out R
+
+	if ns == nil {
+		return out, ErrWrite
+	}
+
+	response, err := ns.Ask(ctx, event, ns.Conn.Marshal(v))
+	if err != nil || len(response.Body) == 0 {
+		return out, err
+	}
+
+	if typ := reflect.TypeOf((*R)(nil)).Elem(); typ.Kind() == reflect.Ptr {
+		out = reflect.New(typ.Elem()).Interface().(R)
+		err = response.Unmarshal(out)
+	} else {
+		err = response.Unmarshal(&out)
+	}
+
+	if err != nil {
+		return out, &BodyDecodeError{Event: event, Type: reflect.TypeOf((*R)(nil)).Elem().String(), Err: err}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,136 @@
+//go:build go1.18
+
+package neffos_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kataras/neffos"
+)
+
+type testGenericRequest struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type testGenericResponse struct {
+	Greeting string `json:"greeting"`
+}
+
+func TestOnGeneric(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = make(neffos.Events)
+		empty     = make(chan bool, 2)
+	)
+
+	neffos.On(events, "value", func(c *neffos.NSConn, msg neffos.Message, body testGenericRequest) error {
+		return neffos.ReplyValue(c, testGenericResponse{Greeting: "hello " + body.Name})
+	})
+	neffos.On(events, "pointer", func(c *neffos.NSConn, msg neffos.Message, body *testGenericRequest) error {
+		if body == nil {
+			empty <- true
+			return nil
+		}
+
+		return neffos.ReplyValue(c, &testGenericResponse{Greeting: "hi " + body.Name})
+	})
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := neffos.AskValue[testGenericResponse](context.TODO(), c, "value", testGenericRequest{Name: "value"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "hello value", res.Greeting; expected != got {
+			t.Fatalf("[%s] expected greeting: %s but got: %s", dialer, expected, got)
+		}
+
+		resPtr, err := neffos.AskValue[*testGenericResponse](context.TODO(), c, "pointer", &testGenericRequest{Name: "pointer"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "hi pointer", resPtr.Greeting; expected != got {
+			t.Fatalf("[%s] expected greeting: %s but got: %s", dialer, expected, got)
+		}
+
+		// an empty body is the zero value.
+		c.Emit("pointer", nil)
+		if !<-empty {
+			t.Fatalf("[%s] expected a nil body", dialer)
+		}
+
+		// a body which cannot be decoded is an error.
+		_, err = c.Ask(context.TODO(), "value", []byte(`{"count":"NaN"}`))
+		if err == nil {
+			t.Fatalf("[%s] expected a decode error", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnGenericDecodeError(t *testing.T) {
+	events := make(neffos.Events)
+	called := false
+	neffos.On(events, "value", func(c *neffos.NSConn, msg neffos.Message, body testGenericRequest) error {
+		called = true
+		return nil
+	})
+
+	err := events["value"](nil, neffos.Message{Event: "value", Body: []byte("{")})
+	var decodeErr *neffos.BodyDecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a body decode error but got: %v", err)
+	}
+
+	if expected, got := "neffos_test.testGenericRequest", decodeErr.Type; decodeErr.Event != "value" || expected != got {
+		t.Fatalf("expected the event and the type of the error but got: %#+v", decodeErr)
+	}
+
+	if called {
+		t.Fatalf("expected the callback not to be called")
+	}
+
+	// the decoded values are reset before they are reused.
+	var got []testGenericRequest
+	neffos.On(events, "value", func(c *neffos.NSConn, msg neffos.Message, body testGenericRequest) error {
+		got = append(got, body)
+		return nil
+	})
+
+	events["value"](nil, neffos.Message{Event: "value", Body: []byte(`{"name":"first","count":1}`)})
+	events["value"](nil, neffos.Message{Event: "value", Body: []byte(`{"name":"second"}`)})
+
+	if expected := (testGenericRequest{Name: "second"}); got[1] != expected {
+		t.Fatalf("expected the second body: %#+v but got: %#+v", expected, got[1])
+	}
+}
+
+func BenchmarkOnGeneric(b *testing.B) {
+	events := make(neffos.Events)
+	neffos.On(events, "value", func(c *neffos.NSConn, msg neffos.Message, body testGenericRequest) error {
+		return nil
+	})
+
+	cb := events["value"]
+	msg := neffos.Message{Event: "value", Body: []byte(`{"name":"neffos","count":1}`)}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		cb(nil, msg)
+	}
+}
@@ -94,3 +94,161 @@ func TestConnHandlerStructDynamicEmbedded(t *testing.T) {
 		t.Fatalf("expected output error to be: %v but got: %v", s.namespace, err)
 	}
 }
+
+type testStructService interface {
+	Greet(name string) string
+}
+
+type testStructGreeter struct{ prefix string }
+
+func (g *testStructGreeter) Greet(name string) string { return g.prefix + name }
+
+type testStructCounter struct{ total int }
+
+type testStructFactory struct {
+	Conn    *NSConn
+	greeter testStructService
+	counter *testStructCounter
+
+	// per-connection state.
+	count        int
+	disconnected bool
+}
+
+func (s *testStructFactory) Namespace() string {
+	return "default"
+}
+
+func (s *testStructFactory) OnNamespaceConnected(msg Message) error {
+	s.counter.total++
+	return nil
+}
+
+func (s *testStructFactory) OnNamespaceDisconnect(c *NSConn, msg Message) error {
+	s.disconnected = true
+	return nil
+}
+
+func (s *testStructFactory) OnCount(msg Message) error {
+	s.count++
+	return Reply([]byte(s.greeter.Greet(s.Conn.namespace)))
+}
+
+func TestConnHandlerStructFactory(t *testing.T) {
+	counter := new(testStructCounter)
+
+	var controllers []*testStructFactory
+	s := NewStructFactory(func(counter *testStructCounter, c *NSConn, greeter testStructService) *testStructFactory {
+		controller := &testStructFactory{Conn: c, greeter: greeter, counter: counter}
+		controllers = append(controllers, controller)
+		return controller
+	}).Provide(&testStructGreeter{prefix: "hello "}, counter)
+	nss := s.GetNamespaces()
+
+	if expected, got := "default", s.namespace; expected != got {
+		t.Fatalf("expected namespace to be: %s but got: %s", expected, got)
+	}
+
+	events := nss[s.namespace]
+	for _, event := range []string{OnNamespaceConnect, OnNamespaceConnected, OnNamespaceDisconnect, "OnCount"} {
+		if events[event] == nil {
+			t.Fatalf("expected the %s event to be registered", event)
+		}
+	}
+
+	if events["Namespace"] != nil {
+		t.Fatalf("expected the Namespace method not to be registered as event")
+	}
+
+	nsConns := []*NSConn{{namespace: s.namespace}, {namespace: s.namespace}}
+	for _, nsConn := range nsConns {
+		if err := events[OnNamespaceConnect](nsConn, Message{}); err != nil {
+			t.Fatal(err)
+		}
+		events[OnNamespaceConnected](nsConn, Message{})
+	}
+
+	for i := 0; i < 3; i++ {
+		err := events["OnCount"](nsConns[0], Message{})
+		if body, ok := isReply(err); !ok || string(body) != "hello default" {
+			t.Fatalf("expected a reply of the greeter but got: %v", err)
+		}
+	}
+	events["OnCount"](nsConns[1], Message{})
+	events[OnNamespaceDisconnect](nsConns[1], Message{})
+
+	if expected, got := 2, len(controllers); expected != got {
+		t.Fatalf("expected a controller per connection: %d but got: %d", expected, got)
+	}
+
+	if expected, got := 2, counter.total; expected != got {
+		t.Fatalf("expected the shared counter to be: %d but got: %d", expected, got)
+	}
+
+	if controllers[0].count != 3 || controllers[1].count != 1 {
+		t.Fatalf("expected counts of 3 and 1 but got: %d and %d", controllers[0].count, controllers[1].count)
+	}
+
+	if controllers[0].disconnected || !controllers[1].disconnected {
+		t.Fatalf("expected only the second controller to be disconnected")
+	}
+}
+
+func TestConnHandlerStructFactoryError(t *testing.T) {
+	expectedErr := fmt.Errorf("unauthorized")
+	s := NewStructFactory(func(c *NSConn) (*testStructFactory, error) {
+		return nil, expectedErr
+	})
+	events := s.GetNamespaces()["default"]
+
+	nsConn := &NSConn{namespace: "default"}
+	if err := events[OnNamespaceConnect](nsConn, Message{}); err != expectedErr {
+		t.Fatalf("expected error: %v but got: %v", expectedErr, err)
+	}
+
+	if err := events["OnCount"](nsConn, Message{}); err != nil {
+		t.Fatalf("expected a nil error without a controller but got: %v", err)
+	}
+}
+
+func TestConnHandlerStructFactoryMissingDependency(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic for a missing dependency")
+		}
+	}()
+
+	NewStructFactory(func(c *NSConn, counter *testStructCounter) *testStructFactory {
+		return nil
+	}).Events()
+}
+
+func BenchmarkConnHandlerStructDynamic(b *testing.B) {
+	events := NewStruct(&testStructDynamic{Namespace: "default"}).Events()
+	nsConn := &NSConn{namespace: "default"}
+	events[OnNamespaceConnect](nsConn, Message{})
+
+	cb := events["OnMySecondEvent"]
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		cb(nsConn, Message{})
+	}
+}
+
+func BenchmarkConnHandlerStructFactory(b *testing.B) {
+	events := NewStructFactory(func(c *NSConn) *testStructDynamic {
+		return &testStructDynamic{Conn: c}
+	}).Events()
+	nsConn := &NSConn{namespace: "default"}
+	events[OnNamespaceConnect](nsConn, Message{})
+
+	cb := events["OnMySecondEvent"]
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		cb(nsConn, Message{})
+	}
+}
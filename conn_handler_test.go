@@ -0,0 +1,485 @@
+package neffos
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventsPrefixMatch(t *testing.T) {
+	var fired string
+	handler := func(name string) MessageHandlerFunc {
+		return func(c *NSConn, msg Message) error {
+			fired = name + ":" + msg.Event
+			return nil
+		}
+	}
+
+	events := Events{
+		"doc.edit.insert": handler("exact"),
+		"doc.edit.*":      handler("doc.edit.*"),
+		"doc.*":           handler("doc.*"),
+		"a.b.c.*":         handler("a.b.c.*"),
+		OnAnyEvent:        handler("any"),
+	}
+	events.OnPrefix("chat.", handler("chat.*"))
+	events.OnPrefix("room.*", handler("room.*"))
+
+	var tests = []struct {
+		event    string
+		expected string
+	}{
+		// exact > longest prefix.
+		{"doc.edit.insert", "exact:doc.edit.insert"},
+		{"doc.edit.delete", "doc.edit.*:doc.edit.delete"},
+		{"doc.edit.cursor.move", "doc.edit.*:doc.edit.cursor.move"},
+		// longest prefix > shorter prefix.
+		{"doc.cursor.move", "doc.*:doc.cursor.move"},
+		// the prefix itself is not under the prefix.
+		{"doc.edit", "doc.*:doc.edit"},
+		{"doc", "any:doc"},
+		// prefix > OnAnyEvent.
+		{"chat.message", "chat.*:chat.message"},
+		{"room.join", "room.*:room.join"},
+		// intermediate segments without handlers.
+		{"a.b.c.d.e", "a.b.c.*:a.b.c.d.e"},
+		{"a.b.x", "any:a.b.x"},
+		// not a prefix, the segments should match.
+		{"docs.edit", "any:docs.edit"},
+		{".edit", "any:.edit"},
+		{"", "any:"},
+	}
+
+	for _, tt := range tests {
+		fired = ""
+		events.fireEvent(nil, Message{Event: tt.event})
+		if fired != tt.expected {
+			t.Fatalf("[%s] expected: %s but got: %s", tt.event, tt.expected, fired)
+		}
+	}
+
+	// without OnAnyEvent.
+	delete(events, OnAnyEvent)
+	fired = ""
+	events.fireEvent(nil, Message{Event: "unknown.event"})
+	if fired != "" {
+		t.Fatalf("expected no callback to be fired but got: %s", fired)
+	}
+}
+
+func TestEventsOnAny(t *testing.T) {
+	var fired []string
+	handler := func(name string, err error) MessageHandlerFunc {
+		return func(c *NSConn, msg Message) error {
+			fired = append(fired, name+":"+msg.Event+":"+string(msg.Body))
+			return err
+		}
+	}
+
+	errAudit := errors.New("audit")
+
+	events := Events{
+		"chat":     handler("chat", nil),
+		"doc.*":    handler("doc.*", nil),
+		"reject":   handler("reject", nil),
+		OnRoomJoin: handler("join", nil),
+	}
+	events.OnAny(AnyEventAlways, func(c *NSConn, msg Message) error {
+		fired = append(fired, "any:"+msg.Event+":"+string(msg.Body))
+		if msg.Event == "reject" {
+			return errAudit
+		}
+		return nil
+	})
+
+	var tests = []struct {
+		event    string
+		expected []string
+		err      error
+	}{
+		// any before the specific callback, with the same message.
+		{"chat", []string{"any:chat:body", "chat:chat:body"}, nil},
+		{"doc.edit", []string{"any:doc.edit:body", "doc.*:doc.edit:body"}, nil},
+		// without a specific callback.
+		{"unknown", []string{"any:unknown:body"}, nil},
+		// the error of any stops the specific callback.
+		{"reject", []string{"any:reject:body"}, errAudit},
+		// lifecycle events are not passed to any.
+		{OnRoomJoin, []string{"join:" + OnRoomJoin + ":body"}, nil},
+		{OnNamespaceConnect, nil, nil},
+	}
+
+	for _, tt := range tests {
+		fired = nil
+		err := events.fireEvent(nil, Message{Event: tt.event, Body: []byte("body")})
+		if err != tt.err {
+			t.Fatalf("[%s] expected error: %v but got: %v", tt.event, tt.err, err)
+		}
+
+		if !reflect.DeepEqual(fired, tt.expected) {
+			t.Fatalf("[%s] expected: %v but got: %v", tt.event, tt.expected, fired)
+		}
+	}
+
+	// back to the default precedence, the lifecycle events are passed to any.
+	events.OnAny(AnyEventFallback, handler("any", nil))
+	for event, expected := range map[string]string{"chat": "chat:chat:body", OnNamespaceConnect: "any:" + OnNamespaceConnect + ":body"} {
+		fired = nil
+		events.fireEvent(nil, Message{Event: event, Body: []byte("body")})
+		if len(fired) != 1 || fired[0] != expected {
+			t.Fatalf("[%s] expected: %s but got: %v", event, expected, fired)
+		}
+	}
+}
+
+func TestEventsUse(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next MessageHandlerFunc) MessageHandlerFunc {
+			return func(c *NSConn, msg Message) error {
+				calls = append(calls, name+">")
+				if msg.Event == "forbidden" && name == "auth" {
+					return errors.New("forbidden")
+				}
+				err := next(c, msg)
+				calls = append(calls, "<"+name)
+				return err
+			}
+		}
+	}
+
+	events := Events{
+		"chat": func(c *NSConn, msg Message) error {
+			calls = append(calls, "chat")
+			return nil
+		},
+	}
+	events.Use(mw("log"), mw("auth"))
+	events.Use(mw("metrics"))
+	// registered after the middleware.
+	events.On("forbidden", func(c *NSConn, msg Message) error {
+		calls = append(calls, "forbidden")
+		return nil
+	})
+
+	var tests = []struct {
+		event    string
+		expected []string
+		err      string
+	}{
+		{"chat", []string{"log>", "auth>", "metrics>", "chat", "<metrics", "<auth", "<log"}, ""},
+		// without a callback, i.e a lifecycle event.
+		{OnNamespaceConnect, []string{"log>", "auth>", "metrics>", "<metrics", "<auth", "<log"}, ""},
+		// short-circuit.
+		{"forbidden", []string{"log>", "auth>", "<log"}, "forbidden"},
+	}
+
+	for _, tt := range tests {
+		calls = nil
+		err := events.fireEvent(nil, Message{Event: tt.event})
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) {
+			t.Fatalf("[%s] expected error: %q but got: %v", tt.event, tt.err, err)
+		}
+
+		if !reflect.DeepEqual(calls, tt.expected) {
+			t.Fatalf("[%s] expected calls:\n%v\nbut got:\n%v", tt.event, tt.expected, calls)
+		}
+	}
+
+	// the middleware are kept by a join of the events.
+	joined := JoinConnHandlers(Namespaces{"default": events}, WithBinaryNamespace("default")).GetNamespaces()["default"]
+	calls = nil
+	joined.fireEvent(nil, Message{Event: "chat"})
+	if expected := tests[0].expected; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected calls of the joined events:\n%v\nbut got:\n%v", expected, calls)
+	}
+}
+
+func TestEventsDirection(t *testing.T) {
+	events := Events{}
+	events.ServerOnly("grantAdmin", "purgeRoom").ClientOnly("report")
+	cfg := events.config()
+
+	tests := []struct {
+		event       string
+		isClient    bool
+		expectedErr error
+	}{
+		{"grantAdmin", false, ErrServerOnlyEvent},
+		{"grantAdmin", true, nil},
+		{"purgeRoom", false, ErrServerOnlyEvent},
+		{"report", false, nil},
+		{"report", true, ErrClientOnlyEvent},
+		{"chat", false, nil},
+		{"chat", true, nil},
+	}
+
+	for i, tt := range tests {
+		if err := cfg.rejectIncoming(tt.event, tt.isClient); err != tt.expectedErr {
+			t.Fatalf("[%d] expected error of the %s event: %v but got: %v", i, tt.event, tt.expectedErr, err)
+		}
+	}
+
+	// joined events keep their directions.
+	joined := JoinConnHandlers(events).GetNamespaces()[""]
+	if err := joined.config().rejectIncoming("grantAdmin", false); err != ErrServerOnlyEvent {
+		t.Fatalf("expected the joined events to keep their directions but got: %v", err)
+	}
+}
+
+func TestEventsSetLive(t *testing.T) {
+	var fired uint32
+	handler := func(c *NSConn, msg Message) error {
+		atomic.AddUint32(&fired, 1)
+		return nil
+	}
+
+	events := Events{"a": handler}
+	events.makeLive()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				events.fireEvent(nil, Message{Event: "a"})
+				events.fireEvent(nil, Message{Event: "b"})
+			}
+		}()
+	}
+
+	for j := 0; j < 500; j++ {
+		events.Set("b", handler)
+		events.Remove("b")
+		events.Use(func(next MessageHandlerFunc) MessageHandlerFunc { return next })
+	}
+	wg.Wait()
+
+	if expected, got := uint32(2000), atomic.LoadUint32(&fired); got < expected {
+		t.Fatalf("expected at least %d fired events but got: %d", expected, got)
+	}
+
+	events.Set("c", handler)
+	if _, ok := events["c"]; ok {
+		t.Fatalf("expected the live events not to modify the registered map")
+	}
+
+	fired = 0
+	events.fireEvent(nil, Message{Event: "c"})
+	if fired != 1 {
+		t.Fatalf("expected the event which was set to be fired")
+	}
+
+	// joined events are not served and do not share the callbacks.
+	joined := JoinConnHandlers(events).GetNamespaces()[""]
+	if s := joined.state(); (s != nil && s.served) || joined["c"] == nil {
+		t.Fatalf("expected the joined events to be a copy of the live events")
+	}
+
+	joined.Remove("c")
+	if events.current()["c"] == nil {
+		t.Fatalf("expected the joined events not to modify the live events")
+	}
+}
+
+func TestEventsState(t *testing.T) {
+	var fired []string
+	handler := func(name string) MessageHandlerFunc {
+		return func(c *NSConn, msg Message) error {
+			fired = append(fired, name)
+			return nil
+		}
+	}
+
+	events := Events{"a": handler("a")}
+	events.makeLive()
+
+	// registered directly after the events are served.
+	events.On("late", handler("late"))
+	ns := newNSConn(nil, "", events)
+	ns.fireEvent(Message{Event: "late"})
+	if expected := []string{"late"}; !reflect.DeepEqual(fired, expected) {
+		t.Fatalf("expected the callback which was registered after the events are served to be fired but got: %v", fired)
+	}
+
+	// the state is loaded once by the namespace connection, the modifications reach it.
+	events.Set("b", handler("b"))
+	events.Use(func(next MessageHandlerFunc) MessageHandlerFunc {
+		return func(c *NSConn, msg Message) error {
+			fired = append(fired, "mw")
+			return next(c, msg)
+		}
+	})
+
+	fired = nil
+	ns.fireEvent(Message{Event: "b"})
+	if expected := []string{"mw", "b"}; !reflect.DeepEqual(fired, expected) {
+		t.Fatalf("expected calls:\n%v\nbut got:\n%v", expected, fired)
+	}
+
+	plain := Events{"a": func(c *NSConn, msg Message) error { return nil }}
+	plain.makeLive()
+	ns = newNSConn(nil, "", plain)
+	msg := Message{Event: "a"}
+	if allocs := testing.AllocsPerRun(100, func() { ns.fireEvent(msg) }); allocs != 0 {
+		t.Fatalf("expected no allocations per fired event but got: %v", allocs)
+	}
+}
+
+func TestEventsConcurrentConfig(t *testing.T) {
+	events := Events{}
+	if cfg := events.config(); cfg.isConcurrent("chat") || cfg.concurrencyLimit() != DefaultConcurrencyLimit {
+		t.Fatalf("expected ordered events with the default limit")
+	}
+
+	events.Concurrent("report")
+	if cfg := events.config(); !cfg.isConcurrent("report") || cfg.isConcurrent("chat") {
+		t.Fatalf("expected only the report event to be concurrent")
+	}
+
+	events.Concurrent(OnAnyEvent).Ordered("chat").SetConcurrencyLimit(2)
+	cfg := events.config()
+	for event, expected := range map[string]bool{"report": true, "chat": false, "other": true} {
+		if got := cfg.isConcurrent(event); expected != got {
+			t.Fatalf("expected the %s event to be concurrent: %v but got: %v", event, expected, got)
+		}
+	}
+
+	if expected, got := 2, cfg.concurrencyLimit(); expected != got {
+		t.Fatalf("expected limit: %d but got: %d", expected, got)
+	}
+}
+
+func TestMergeEvents(t *testing.T) {
+	var calls []string
+	handler := func(name string) MessageHandlerFunc {
+		return func(c *NSConn, msg Message) error {
+			calls = append(calls, name+":"+msg.Event)
+			if msg.Event == OnNamespaceConnect && name == "base" {
+				return errors.New("unauthorized")
+			}
+			return nil
+		}
+	}
+
+	base := Events{
+		OnNamespaceConnected: handler("base"),
+		OnNamespaceConnect:   handler("base"),
+		"refresh":            handler("base"),
+		"report":             handler("base"),
+	}
+	base.Use(func(next MessageHandlerFunc) MessageHandlerFunc {
+		return func(c *NSConn, msg Message) error {
+			calls = append(calls, "mw")
+			return next(c, msg)
+		}
+	})
+
+	overrides := Events{
+		OnNamespaceConnected: handler("override"),
+		OnNamespaceConnect:   handler("override"),
+		"report":             handler("override"),
+		"chat":               handler("override"),
+	}
+
+	var tests = []struct {
+		options  []MergeOption
+		event    string
+		expected []string
+	}{
+		{nil, OnNamespaceConnected, []string{"mw", "override:" + OnNamespaceConnected}},
+		{[]MergeOption{ChainLifecycle}, OnNamespaceConnected, []string{"mw", "base:" + OnNamespaceConnected, "override:" + OnNamespaceConnected}},
+		// the override is not fired when the base one fails.
+		{[]MergeOption{ChainLifecycle}, OnNamespaceConnect, []string{"mw", "base:" + OnNamespaceConnect}},
+		{[]MergeOption{ChainLifecycle}, "refresh", []string{"mw", "base:refresh"}},
+		// not a lifecycle event, the override wins.
+		{[]MergeOption{ChainLifecycle}, "report", []string{"mw", "override:report"}},
+		{nil, "chat", []string{"mw", "override:chat"}},
+	}
+
+	for i, tt := range tests {
+		calls = nil
+		merged := MergeEvents(base, overrides, tt.options...)
+		merged.fireEvent(nil, Message{Event: tt.event})
+		if !reflect.DeepEqual(calls, tt.expected) {
+			t.Fatalf("[%d:%s] expected calls:\n%v\nbut got:\n%v", i, tt.event, tt.expected, calls)
+		}
+	}
+
+	// the shared events are not modified.
+	if len(base.current()) != 4 || base["chat"] != nil {
+		t.Fatalf("expected the base events to be kept as they are but got: %v", base)
+	}
+
+	nss := Namespaces{"a": overrides, "b": Events{"chat": handler("b")}}.WithShared(base, ChainLifecycle)
+	calls = nil
+	nss["b"].fireEvent(nil, Message{Event: OnNamespaceConnected})
+	nss["a"].fireEvent(nil, Message{Event: OnNamespaceConnected})
+	if expected := []string{
+		"mw", "base:" + OnNamespaceConnected,
+		"mw", "base:" + OnNamespaceConnected, "override:" + OnNamespaceConnected,
+	}; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected calls of the shared events:\n%v\nbut got:\n%v", expected, calls)
+	}
+
+	_, err := MergeEventsStrict(base, overrides, ChainLifecycle)
+	if !errors.Is(err, ErrDuplicateEvent) || err.Error() != "duplicate event: report" {
+		t.Fatalf("expected duplicate event error of the report event but got: %v", err)
+	}
+
+	if _, err = MergeEventsStrict(base, Events{OnNamespaceConnected: handler("override"), "chat": handler("override")}); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+}
+
+func TestNamespacesDescribe(t *testing.T) {
+	noop := func(*NSConn, Message) error { return nil }
+
+	chat := Events{
+		"send":               noop,
+		"edit":               noop,
+		OnNamespaceConnected: noop,
+		OnAnyEvent:           noop,
+	}
+	chat.Use(func(next MessageHandlerFunc) MessageHandlerFunc { return next })
+	chat.WithTimeout("send", time.Second)
+
+	nss := JoinConnHandlers(Namespaces{"chat": chat}, WithBinaryNamespace("chat"), Events{"ping": noop}).GetNamespaces()
+	nss.SetDynamic(func(namespace string) (Events, bool) {
+		return Events{"whoami": noop}, namespace == "tenant-1"
+	})
+	nss.makeLive()
+	// a live registration.
+	nss["chat"].Set("delete", noop)
+	nss.lookup("tenant-1")
+
+	expected := map[string][]string{
+		"":         {"ping"},
+		"chat":     {OnAnyEvent, OnNamespaceConnected, "delete", "edit", "send"},
+		"tenant-1": {"whoami"},
+	}
+
+	description := nss.Describe()
+	if !reflect.DeepEqual(description, expected) {
+		t.Fatalf("expected description:\n%v\nbut got:\n%v", expected, description)
+	}
+
+	// a copy.
+	description["chat"][0] = "modified"
+	delete(description, "")
+	if got := nss.Describe(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the description to be unaffected by the modification but got:\n%v", got)
+	}
+
+	if got, expected := (WithTimeout{Events: Events{"ping": noop}}).Describe(), map[string][]string{"": {"ping"}}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected description:\n%v\nbut got:\n%v", expected, got)
+	}
+
+	if got, expected := NewStruct(new(testStructStatic)).Describe(), map[string][]string{"default": {"OnMyEvent"}}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected description of the struct:\n%v\nbut got:\n%v", expected, got)
+	}
+}
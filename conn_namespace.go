@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
 // NSConn describes a connection connected to a specific namespace,
@@ -18,6 +19,10 @@ type NSConn struct {
 	namespace string
 	// Static from server, client can select which to use or not.
 	events Events
+	// the state of the events, loaded once, it's nil if they are neither configured nor served.
+	state *eventsState
+	// the default `Message.SetBinary` of the emitted messages, see `WithBinaryNamespace`.
+	binary bool
 
 	// Dynamically channels/rooms for each connected namespace.
 	// Client can ask to join, server can forcely join a connection to a room.
@@ -28,6 +33,16 @@ type NSConn struct {
 	// value is just a temporarily value.
 	// Storage across event callbacks for this namespace.
 	value reflect.Value
+	// the bound methods of the controller of this namespace connection, see `NewStructFactory`.
+	controller []MessageHandlerFunc
+	// the running callbacks of the concurrent events, see `Events#Concurrent`.
+	concurrency chan struct{}
+	// the body of the connect reply, see `SetConnectReply` and `ConnectData`.
+	connectData []byte
+	// the token buckets of the rate limited events, see `Events#SetLimited`.
+	// They are dropped with the namespace connection when it's disconnected.
+	buckets      map[string]*tokenBucket
+	bucketsMutex sync.Mutex
 }
 
 func newNSConn(c *Conn, namespace string, events Events) *NSConn {
@@ -35,10 +50,46 @@ func newNSConn(c *Conn, namespace string, events Events) *NSConn {
 		Conn:      c,
 		namespace: namespace,
 		events:    events,
+		state:     events.state(),
+		binary:    events.config().isBinary(),
 		rooms:     make(map[string]*Room),
 	}
 }
 
+// current returns the current callbacks of the namespace connection's events, see `Events#Set`.
+func (ns *NSConn) current() Events {
+	if ns.state != nil {
+		return ns.state.current()
+	}
+
+	return ns.events
+}
+
+// config returns the configuration of the namespace connection's events, it's nil if there is none.
+func (ns *NSConn) config() *eventsConfig {
+	return ns.state.configuration()
+}
+
+// fireEvent fires the callback of the "msg" event of the namespace connection's events.
+func (ns *NSConn) fireEvent(msg Message) error {
+	return ns.current().fire(ns, msg, ns.config())
+}
+
+// SetConnectReply sets the "body" of the reply to the remote side's connect request,
+// it should be called by the `OnNamespaceConnect` callback, i.e to send the initial state of the namespace
+// within the connect handshake, before any other message of the namespace.
+// The remote `Conn#Connect` receives it through the `ConnectData` of its namespace connection.
+// Returning a `Reply` from the `OnNamespaceConnect` callback does the same.
+func (ns *NSConn) SetConnectReply(body []byte) {
+	ns.connectData = body
+}
+
+// ConnectData returns the body of the connect reply, see `SetConnectReply`.
+// On the side which asked to connect it's the body which the remote side replied with, empty if none.
+func (ns *NSConn) ConnectData() []byte {
+	return ns.connectData
+}
+
 // String method simply returns the Conn's ID().
 // Useful method to this connected to a namespace connection to be passed on `Server#Broadcast` method
 // to exclude itself from the broadcasted message's receivers.
@@ -53,7 +104,18 @@ func (ns *NSConn) Emit(event string, body []byte) bool {
 		return false
 	}
 
-	return ns.Conn.Write(Message{Namespace: ns.namespace, Event: event, Body: body})
+	return ns.Conn.Write(Message{Namespace: ns.namespace, Event: event, Body: body, SetBinary: ns.binary})
+}
+
+// EmitCtx acts like `Emit` but the message carries the trace ID of the "ctx",
+// i.e the `Message#Context` of the handled message, see `TraceIDFromContext`.
+// It's safe to use from a callback which outlives its dispatch, i.e an `Events#Async` one.
+func (ns *NSConn) EmitCtx(ctx context.Context, event string, body []byte) bool {
+	if ns == nil {
+		return false
+	}
+
+	return ns.Conn.Write(Message{Namespace: ns.namespace, Event: event, Body: body, SetBinary: ns.binary, TraceID: TraceIDFromContext(ctx)})
 }
 
 // EmitBinary acts like `Emit` but it sets the `Message.SetBinary` to true
@@ -66,13 +128,67 @@ func (ns *NSConn) EmitBinary(event string, body []byte) bool {
 	return ns.Conn.Write(Message{Namespace: ns.namespace, Event: event, Body: body, SetBinary: true})
 }
 
+// EmitText acts like `Emit` but it always sends the data as text,
+// even if this namespace is declared as binary through `WithBinaryNamespace`.
+func (ns *NSConn) EmitText(event string, body []byte) bool {
+	if ns == nil {
+		return false
+	}
+
+	return ns.Conn.Write(Message{Namespace: ns.namespace, Event: event, Body: body})
+}
+
 // Ask method writes a message to the remote side and blocks until a response or an error received.
 func (ns *NSConn) Ask(ctx context.Context, event string, body []byte) (Message, error) {
 	if ns == nil {
 		return Message{}, ErrWrite
 	}
 
-	return ns.Conn.Ask(ctx, Message{Namespace: ns.namespace, Event: event, Body: body})
+	return ns.Conn.Ask(ctx, Message{Namespace: ns.namespace, Event: event, Body: body, SetBinary: ns.binary})
+}
+
+// DeferReply returns a `ReplyFunc` which can be used to answer the incoming "msg" later on,
+// i.e from another goroutine after a database call.
+// The reply keeps the same Namespace, Room and Event of the "msg"
+// and, if "msg" was sent by an `Ask`, the remote `Ask` call unblocks with it.
+// The event callback should return the `ErrReplyDeferred`.
+//
+// Example:
+//
+//	func(c *neffos.NSConn, msg neffos.Message) error {
+//		reply := c.DeferReply(msg)
+//		go func() {
+//			body, err := fetch(msg.Body)
+//			reply(body, err)
+//		}()
+//		return neffos.ErrReplyDeferred
+//	}
+func (ns *NSConn) DeferReply(msg Message) ReplyFunc {
+	replied := new(uint32)
+
+	return func(body []byte, err error) error {
+		if !atomic.CompareAndSwapUint32(replied, 0, 1) {
+			return ErrReplySent
+		}
+
+		if ns == nil || ns.Conn.IsClosed() || ns.Conn.Namespace(ns.namespace) != ns {
+			return ErrWrite
+		}
+
+		msg.IsLocal = false
+		msg.Body = body
+		msg.Err = ns.translateError(msg, err)
+		if err != nil && msg.Err == nil && msg.wait == "" {
+			// translated to nothing, see `Server#SetErrorTranslator`.
+			return nil
+		}
+
+		if !ns.Conn.Write(msg) {
+			return ErrWrite
+		}
+
+		return nil
+	}
 }
 
 // JoinRoom method can be used to join a connection to a specific room, rooms are dynamic.
@@ -140,12 +256,13 @@ func (ns *NSConn) forceLeaveAll(isLocal bool) {
 	leaveMsg := Message{Namespace: ns.namespace, Event: OnRoomLeave, IsForced: true, IsLocal: isLocal}
 	for room := range ns.rooms {
 		leaveMsg.Room = room
-		ns.events.fireEvent(ns, leaveMsg)
+		ns.fireEvent(leaveMsg)
 
 		delete(ns.rooms, room)
+		ns.notifyRoomLeft(room)
 
 		leaveMsg.Event = OnRoomLeft
-		ns.events.fireEvent(ns, leaveMsg)
+		ns.fireEvent(leaveMsg)
 
 		leaveMsg.Event = OnRoomLeave
 	}
@@ -163,6 +280,20 @@ func (ns *NSConn) Disconnect(ctx context.Context) error {
 	}, true)
 }
 
+// notifyRoomJoined updates the server's room members, server-side only.
+func (ns *NSConn) notifyRoomJoined(room string) {
+	if !ns.Conn.IsClient() && ns.Conn.server.stackExchangeOpen() {
+		ns.Conn.server.roomJoined(ns.namespace, room)
+	}
+}
+
+// notifyRoomLeft updates the server's room members, server-side only.
+func (ns *NSConn) notifyRoomLeft(room string) {
+	if !ns.Conn.IsClient() && ns.Conn.server.stackExchangeOpen() {
+		ns.Conn.server.roomLeft(ns.namespace, room)
+	}
+}
+
 func (ns *NSConn) askRoomJoin(ctx context.Context, roomName string) (*Room, error) {
 	ns.roomsMutex.RLock()
 	room, ok := ns.rooms[roomName]
@@ -183,7 +314,7 @@ func (ns *NSConn) askRoomJoin(ctx context.Context, roomName string) (*Room, erro
 		return nil, err
 	}
 
-	err = ns.events.fireEvent(ns, joinMsg)
+	err = ns.fireEvent(joinMsg)
 	if err != nil {
 		return nil, err
 	}
@@ -192,9 +323,10 @@ func (ns *NSConn) askRoomJoin(ctx context.Context, roomName string) (*Room, erro
 	ns.roomsMutex.Lock()
 	ns.rooms[roomName] = room
 	ns.roomsMutex.Unlock()
+	ns.notifyRoomJoined(roomName)
 
 	joinMsg.Event = OnRoomJoined
-	ns.events.fireEvent(ns, joinMsg)
+	ns.fireEvent(joinMsg)
 	return room, nil
 }
 
@@ -207,18 +339,19 @@ func (ns *NSConn) replyRoomJoin(msg Message) {
 	_, ok := ns.rooms[msg.Room]
 	ns.roomsMutex.RUnlock()
 	if !ok {
-		err := ns.events.fireEvent(ns, msg)
+		err := ns.fireEvent(msg)
 		if err != nil {
-			msg.Err = err
+			msg.Err = ns.translateRejection(msg, err)
 			ns.Conn.Write(msg)
 			return
 		}
 		ns.roomsMutex.Lock()
 		ns.rooms[msg.Room] = newRoom(ns, msg.Room)
 		ns.roomsMutex.Unlock()
+		ns.notifyRoomJoined(msg.Room)
 
 		msg.Event = OnRoomJoined
-		ns.events.fireEvent(ns, msg)
+		ns.fireEvent(msg)
 	}
 
 	ns.Conn.writeEmptyReply(msg.wait)
@@ -247,7 +380,7 @@ func (ns *NSConn) askRoomLeave(ctx context.Context, msg Message, lock bool) erro
 	}
 
 	// msg.IsLocal = true
-	err = ns.events.fireEvent(ns, msg)
+	err = ns.fireEvent(msg)
 	if err != nil {
 		return err
 	}
@@ -261,9 +394,10 @@ func (ns *NSConn) askRoomLeave(ctx context.Context, msg Message, lock bool) erro
 	if lock {
 		ns.roomsMutex.Unlock()
 	}
+	ns.notifyRoomLeft(msg.Room)
 
 	msg.Event = OnRoomLeft
-	ns.events.fireEvent(ns, msg)
+	ns.fireEvent(msg)
 
 	return nil
 }
@@ -281,7 +415,7 @@ func (ns *NSConn) replyRoomLeave(msg Message) {
 
 	// if client then we need to respond to server and delete the room without ask the local event.
 	if ns.Conn.IsClient() {
-		ns.events.fireEvent(ns, msg)
+		ns.fireEvent(msg)
 
 		ns.roomsMutex.Lock()
 		delete(ns.rooms, msg.Room)
@@ -290,14 +424,14 @@ func (ns *NSConn) replyRoomLeave(msg Message) {
 		ns.Conn.writeEmptyReply(msg.wait)
 
 		msg.Event = OnRoomLeft
-		ns.events.fireEvent(ns, msg)
+		ns.fireEvent(msg)
 		return
 	}
 
 	// server-side, check for error on the local event first.
-	err := ns.events.fireEvent(ns, msg)
+	err := ns.fireEvent(msg)
 	if err != nil {
-		msg.Err = err
+		msg.Err = ns.translateRejection(msg, err)
 		ns.Conn.Write(msg)
 		return
 	}
@@ -305,9 +439,10 @@ func (ns *NSConn) replyRoomLeave(msg Message) {
 	ns.roomsMutex.Lock()
 	delete(ns.rooms, msg.Room)
 	ns.roomsMutex.Unlock()
+	ns.notifyRoomLeft(msg.Room)
 
 	msg.Event = OnRoomLeft
-	ns.events.fireEvent(ns, msg)
+	ns.fireEvent(msg)
 
 	ns.Conn.writeEmptyReply(msg.wait)
 }
@@ -0,0 +1,159 @@
+package neffos
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+)
+
+// DynamicNamespaceCacheSize is the maximum number of the dynamic namespaces
+// which their events are kept, the least recently connected ones are dropped first.
+// See `Namespaces#SetDynamic`.
+const DynamicNamespaceCacheSize = 1024
+
+// dynamicNamespaces keeps the factory of the dynamic namespaces and a bounded cache of their events.
+type dynamicNamespaces struct {
+	factory func(namespace string) (Events, bool)
+
+	mu    sync.Mutex
+	size  int
+	cache map[string]*list.Element
+	// the front is the most recently used one.
+	order *list.List
+}
+
+type dynamicNamespaceEntry struct {
+	namespace string
+	events    Events
+}
+
+// dynamicRegistration keeps the dynamic namespaces of a `Namespaces` aside of its map,
+// so a remote side cannot reach them through a namespace name, see `eventsState` too.
+type dynamicRegistration struct {
+	// the map of the namespaces, it keeps the key of the registration valid.
+	namespaces Namespaces
+	dynamic    *dynamicNamespaces
+}
+
+var (
+	dynamicRegistrationsMu sync.RWMutex
+	// keyed by the identity of the map of the namespaces.
+	dynamicRegistrations = make(map[uintptr]dynamicRegistration)
+)
+
+// get returns the events of the "namespace", from the cache or the factory.
+func (d *dynamicNamespaces) get(namespace string) (Events, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.cache[namespace]; ok {
+		d.order.MoveToFront(elem)
+		return elem.Value.(*dynamicNamespaceEntry).events, true
+	}
+
+	events, ok := d.factory(namespace)
+	if !ok {
+		return nil, false
+	}
+
+	if events == nil {
+		events = Events{}
+	}
+	events.makeLive()
+
+	d.cache[namespace] = d.order.PushFront(&dynamicNamespaceEntry{namespace: namespace, events: events})
+	if d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.cache, oldest.Value.(*dynamicNamespaceEntry).namespace)
+	}
+
+	return events, true
+}
+
+// cached returns the events of the cached namespaces.
+func (d *dynamicNamespaces) cached() map[string]Events {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	namespaces := make(map[string]Events, d.order.Len())
+	for elem := d.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*dynamicNamespaceEntry)
+		namespaces[entry.namespace] = entry.events
+	}
+
+	return namespaces
+}
+
+// SetDynamic registers the "factory" of the namespaces which are not declared upfront,
+// i.e "tenant-<id>" namespaces. It's called when a connection asks to connect to an undeclared namespace:
+// the returned events accept the connection with their callbacks, false rejects it with the `ErrBadNamespace` as before.
+// The events of a namespace are cached, so the "factory" is not called again on its next connections,
+// up to the `DynamicNamespaceCacheSize` namespaces. A nil "factory" removes the dynamic namespaces.
+//
+// It should be registered to both the server and the client side, so both of them can connect to such a namespace.
+// The middleware of the `Namespaces#Use` do not apply to the dynamic namespaces,
+// the "factory" can register them through `Events#Use` instead.
+// A stackexchange which receives the messages of the declared namespaces only, i.e the redis `StreamsStackExchange`,
+// can't be used with the dynamic namespaces, see `StaticNamespacesStackExchange`.
+//
+// Example:
+//
+//	namespaces.SetDynamic(func(namespace string) (neffos.Events, bool) {
+//		if !strings.HasPrefix(namespace, "tenant-") {
+//			return nil, false
+//		}
+//
+//		return newTenantEvents(strings.TrimPrefix(namespace, "tenant-")), true
+//	})
+func (nss Namespaces) SetDynamic(factory func(namespace string) (Events, bool)) {
+	if factory == nil {
+		nss.setDynamic(nil)
+		return
+	}
+
+	nss.setDynamic(&dynamicNamespaces{
+		factory: factory,
+		size:    DynamicNamespaceCacheSize,
+		cache:   make(map[string]*list.Element),
+		order:   list.New(),
+	})
+}
+
+// setDynamic registers the "dynamic" namespaces, a nil one removes them.
+func (nss Namespaces) setDynamic(dynamic *dynamicNamespaces) {
+	key := reflect.ValueOf(nss).Pointer()
+
+	dynamicRegistrationsMu.Lock()
+	if dynamic == nil {
+		delete(dynamicRegistrations, key)
+	} else {
+		dynamicRegistrations[key] = dynamicRegistration{namespaces: nss, dynamic: dynamic}
+	}
+	dynamicRegistrationsMu.Unlock()
+}
+
+// dynamic returns the dynamic namespaces, it's nil if there is no factory.
+func (nss Namespaces) dynamic() *dynamicNamespaces {
+	if nss == nil {
+		return nil
+	}
+
+	dynamicRegistrationsMu.RLock()
+	r := dynamicRegistrations[reflect.ValueOf(nss).Pointer()]
+	dynamicRegistrationsMu.RUnlock()
+	return r.dynamic
+}
+
+// lookup returns the events of a declared or a dynamic "namespace" and reports whether it exists.
+func (nss Namespaces) lookup(namespace string) (Events, bool) {
+	if events, ok := nss[namespace]; ok {
+		return events, true
+	}
+
+	if dynamic := nss.dynamic(); dynamic != nil {
+		return dynamic.get(namespace)
+	}
+
+	return nil, false
+}
@@ -0,0 +1,52 @@
+package neffos
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDynamicNamespacesCache(t *testing.T) {
+	calls := make(map[string]int)
+	nss := Namespaces{"default": Events{}}
+	nss.SetDynamic(func(namespace string) (Events, bool) {
+		calls[namespace]++
+		return Events{}, namespace != "rejected"
+	})
+
+	if _, ok := nss.lookup("rejected"); ok {
+		t.Fatalf("expected the rejected namespace to not exist")
+	}
+
+	// the factory is kept aside of the namespaces.
+	if len(nss) != 1 {
+		t.Fatalf("expected the declared namespaces only but got: %v", nss)
+	}
+
+	first, ok := nss.lookup("tenant-0")
+	if !ok {
+		t.Fatalf("expected the dynamic namespace to exist")
+	}
+
+	for i := 1; i < DynamicNamespaceCacheSize; i++ {
+		nss.lookup("tenant-" + strconv.Itoa(i))
+	}
+
+	// the most recently used one now.
+	if events, _ := nss.lookup("tenant-0"); events.state() != first.state() {
+		t.Fatalf("expected the cached events")
+	}
+
+	// drops the least recently used, the tenant-1.
+	nss.lookup("overflow")
+	nss.lookup("tenant-0")
+	nss.lookup("tenant-1")
+
+	if calls["tenant-0"] != 1 || calls["tenant-1"] != 2 || calls["rejected"] != 1 {
+		t.Fatalf("unexpected factory calls: tenant-0: %d, tenant-1: %d, rejected: %d", calls["tenant-0"], calls["tenant-1"], calls["rejected"])
+	}
+
+	nss.SetDynamic(nil)
+	if _, ok := nss.lookup("tenant-0"); ok {
+		t.Fatalf("expected the dynamic namespaces to be removed")
+	}
+}
@@ -3,8 +3,11 @@ package neffos_test
 import (
 	"bytes"
 	"context"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/kataras/neffos"
 )
@@ -163,3 +166,211 @@ func TestJoinAndLeaveRoomInsideHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestBinaryNamespace(t *testing.T) {
+	type frame struct {
+		namespace string
+		event     string
+		binary    bool
+	}
+
+	var (
+		serverFrames = make(chan frame, 8)
+		clientFrames = make(chan frame, 8)
+
+		record = func(frames chan frame) neffos.MessageHandlerFunc {
+			return func(c *neffos.NSConn, msg neffos.Message) error {
+				frames <- frame{msg.Namespace, msg.Event, msg.SetBinary}
+				return nil
+			}
+		}
+
+		namespaces = func(frames chan frame, reply bool) neffos.ConnHandler {
+			nss := neffos.Namespaces{}
+			for _, namespace := range []string{"chat", "voice-meta"} {
+				nss.On(namespace, "ping", func(c *neffos.NSConn, msg neffos.Message) error {
+					frames <- frame{msg.Namespace, msg.Event, msg.SetBinary}
+					if reply {
+						c.Emit("pong", msg.Body)
+					}
+					return nil
+				})
+				nss.On(namespace, "pong", record(frames))
+				nss.On(namespace, "text", record(frames))
+			}
+
+			return neffos.JoinConnHandlers(nss, neffos.WithBinaryNamespace("voice-meta"))
+		}
+
+		expect = func(adapter string, frames chan frame, expected frame) {
+			t.Helper()
+
+			select {
+			case got := <-frames:
+				if got != expected {
+					t.Fatalf("[%s] expected frame: %#+v but got: %#+v", adapter, expected, got)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("[%s] expected frame: %#+v but got nothing", adapter, expected)
+			}
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", namespaces(serverFrames, true))
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", namespaces(clientFrames, false), func(adapter string, client *neffos.Client) {
+		for _, namespace := range []string{"chat", "voice-meta"} {
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatalf("[%s] %v", adapter, err)
+			}
+
+			binary := namespace == "voice-meta"
+
+			c.Emit("ping", []byte("data"))
+			expect(adapter, serverFrames, frame{namespace, "ping", binary})
+			expect(adapter, clientFrames, frame{namespace, "pong", binary})
+
+			// overridable per message.
+			c.EmitText("text", []byte("data"))
+			expect(adapter, serverFrames, frame{namespace, "text", false})
+		}
+	})
+	defer teardownClient()
+}
+
+func TestDynamicNamespaces(t *testing.T) {
+	var (
+		serverCalls, clientCalls uint32
+		factory                  = func(calls *uint32) func(string) (neffos.Events, bool) {
+			return func(namespace string) (neffos.Events, bool) {
+				if !strings.HasPrefix(namespace, "tenant-") {
+					return nil, false
+				}
+
+				atomic.AddUint32(calls, 1)
+				tenant := strings.TrimPrefix(namespace, "tenant-")
+				return neffos.Events{
+					"whoami": func(c *neffos.NSConn, msg neffos.Message) error {
+						return neffos.Reply([]byte(tenant))
+					},
+				}, true
+			}
+		}
+	)
+
+	serverNamespaces := neffos.Namespaces{"default": neffos.Events{}}
+	serverNamespaces.SetDynamic(factory(&serverCalls))
+
+	teardownServer := runTestServer("localhost:8080", serverNamespaces)
+	defer teardownServer()
+
+	clientNamespaces := neffos.Namespaces{"default": neffos.Events{}}
+	clientNamespaces.SetDynamic(factory(&clientCalls))
+
+	err := runTestClient("localhost:8080", clientNamespaces, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		for _, tenant := range []string{"1", "2"} {
+			c, err := client.Connect(context.TODO(), "tenant-"+tenant)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := c.Ask(context.TODO(), "whoami", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := string(msg.Body); got != tenant {
+				t.Fatalf("[%s] expected tenant: %s but got: %s", dialer, tenant, got)
+			}
+		}
+
+		if _, err := client.Connect(context.TODO(), "other"); err != neffos.ErrBadNamespace {
+			t.Fatalf("[%s] expected the bad namespace error but got: %v", dialer, err)
+		}
+
+		// the reserved namespace of the factory.
+		if _, err := client.Connect(context.TODO(), "_dynamic"); err != neffos.ErrBadNamespace {
+			t.Fatalf("[%s] expected the bad namespace error but got: %v", dialer, err)
+		}
+
+		if _, err := client.Connect(context.TODO(), "default"); err != nil {
+			t.Fatal(err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events are cached per namespace, shared by the connections of the server and the client.
+	if expected, got := uint32(2), atomic.LoadUint32(&serverCalls); expected != got {
+		t.Fatalf("expected server factory calls: %d but got: %d", expected, got)
+	}
+
+	if expected, got := uint32(2), atomic.LoadUint32(&clientCalls); expected != got {
+		t.Fatalf("expected client factory calls: %d but got: %d", expected, got)
+	}
+}
+
+func TestConnectReplyData(t *testing.T) {
+	var (
+		state  = []byte(`{"rooms":["lobby","vip"]}`)
+		events = neffos.Namespaces{
+			"set": neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						c.SetConnectReply(state)
+					}
+					return nil
+				},
+			},
+			"reply": neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						return neffos.Reply(state)
+					}
+					return nil
+				},
+			},
+			"empty": neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		for _, namespace := range []string{"set", "reply"} {
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.ConnectData(); !bytes.Equal(got, state) {
+				t.Fatalf("[%s:%s] expected connect data: %s but got: %s", dialer, namespace, state, got)
+			}
+
+			// it's connected.
+			if c.Conn.Namespace(namespace) != c {
+				t.Fatalf("[%s:%s] expected the namespace to be connected", dialer, namespace)
+			}
+		}
+
+		c, err := client.Connect(context.TODO(), "empty")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := c.ConnectData(); len(got) != 0 {
+			t.Fatalf("[%s] expected empty connect data but got: %s", dialer, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -36,6 +36,7 @@ func (r *Room) Emit(event string, body []byte) bool {
 		Room:      r.Name,
 		Event:     event,
 		Body:      body,
+		SetBinary: r.NSConn.binary,
 	})
 }
 
@@ -3,12 +3,25 @@ package neffos_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/kataras/neffos"
+
+	gorilla "github.com/kataras/neffos/gorilla"
+
+	gobwas "github.com/gobwas/ws"
+	"github.com/gorilla/websocket"
 )
 
 func TestConnect(t *testing.T) {
@@ -207,60 +220,28 @@ func TestOnAnyEvent(t *testing.T) {
 	}
 }
 
-func TestOnNativeMessageAndMessageError(t *testing.T) {
+func TestOnAnyEventAlways(t *testing.T) {
 	var (
-		wg                             sync.WaitGroup
-		namespace                      = "" // empty namespace and OnNativeMessage event defined to allow native websocket messages to come through.
-		eventThatWillGiveErrorByServer = "event_error_server"
-		eventErrorText                 = "this event will give error by server"
-		nativeMessage                  = []byte("this is a native/raw websocket message")
-		events                         = neffos.Events{
-			neffos.OnNativeMessage: func(c *neffos.NSConn, msg neffos.Message) error {
-				defer wg.Done()
-
-				expectedMessage := neffos.Message{
-					Event:    neffos.OnNativeMessage,
-					Body:     nativeMessage,
-					IsNative: true,
-				}
-
-				if !reflect.DeepEqual(expectedMessage, msg) {
-					t.Fatalf("expected a native message to be:\n%#+v\n\tbut got:\n%#+v", expectedMessage, msg)
-				}
-
-				return nil
+		namespace = "default"
+		audited   = make(chan string, 8)
+		events    = neffos.Events{
+			"allowed": func(c *neffos.NSConn, msg neffos.Message) error {
+				return neffos.Reply(append([]byte("allowed:"), msg.Body...))
 			},
 		}
 	)
+	events.OnAny(neffos.AnyEventAlways, func(c *neffos.NSConn, msg neffos.Message) error {
+		audited <- msg.Event
+		if string(msg.Body) == "forbidden" {
+			return errors.New("forbidden")
+		}
+		return nil
+	})
 
-	serverHandler := neffos.JoinConnHandlers(neffos.Namespaces{namespace: events},
-		neffos.Events{
-			eventThatWillGiveErrorByServer: func(c *neffos.NSConn, msg neffos.Message) error {
-				return fmt.Errorf(eventErrorText)
-			},
-		})
-	teardownServer := runTestServer("localhost:8080", serverHandler)
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
 	defer teardownServer()
 
-	clientHandler := neffos.JoinConnHandlers(neffos.Namespaces{namespace: events},
-		neffos.Events{
-			eventThatWillGiveErrorByServer: func(c *neffos.NSConn, msg neffos.Message) error {
-				defer wg.Done()
-				if !c.Conn.IsClient() {
-					t.Fatalf("this should only be executed by client-side, if not then the JoinConnHandlers didn't work as expected")
-				}
-
-				if msg.Err == nil {
-					t.Fatalf("expected an error from event: %s", eventThatWillGiveErrorByServer)
-				}
-				if expected, got := eventErrorText, msg.Err.Error(); expected != got {
-					t.Fatalf("expected an error from event: %s to match: '%s' but got: '%s'", eventThatWillGiveErrorByServer, expected, got)
-				}
-				return nil
-			},
-		})
-
-	err := runTestClient("localhost:8080", clientHandler, func(dialer string, client *neffos.Client) {
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
 		defer client.Close()
 
 		c, err := client.Connect(context.TODO(), namespace)
@@ -268,52 +249,135 @@ func TestOnNativeMessageAndMessageError(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		// Ask is not available on native websocket messages of course.
-		wg.Add(1)
-		c.Conn.Write(neffos.Message{
-			Body:     nativeMessage,
-			IsNative: true,
-		})
+		msg, err := c.Ask(context.TODO(), "allowed", []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "allowed:data", string(msg.Body); expected != got {
+			t.Fatalf("[%s] expected body: %s but got: %s", dialer, expected, got)
+		}
 
-		wg.Add(1)
-		c.Emit(eventThatWillGiveErrorByServer, []byte("doesn't matter"))
+		// the error of the any callback is sent back like the error of the event's callback.
+		if _, err = c.Ask(context.TODO(), "allowed", []byte("forbidden")); err == nil || err.Error() != "forbidden" {
+			t.Fatalf("[%s] expected the forbidden error but got: %v", dialer, err)
+		}
 
-		wg.Wait()
+		// the namespace connect is not audited.
+		for _, expected := range []string{"allowed", "allowed"} {
+			if got := <-audited; expected != got {
+				t.Fatalf("[%s] expected audited event: %s but got: %s", dialer, expected, got)
+			}
+		}
 	})()
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func TestOnNativeMessageOnly(t *testing.T) {
-	// when the only one namespace is "" and event OnNativeMessage.
+func TestServerUse(t *testing.T) {
 	var (
-		wg            sync.WaitGroup
-		namespace     = ""
-		nativeMessage = []byte("this is a native/raw websocket message")
-		events        = neffos.Events{
-			neffos.OnNativeMessage: func(c *neffos.NSConn, msg neffos.Message) error {
-				defer wg.Done()
+		namespace = "default"
+		calls     = make(chan string, 16)
+		events    = neffos.Events{
+			"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+				calls <- "chat"
+				return neffos.Reply(msg.Body)
+			},
+		}
+	)
+	events.Use(func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+		return func(c *neffos.NSConn, msg neffos.Message) error {
+			if msg.Event != neffos.OnNamespaceDisconnect { // on client close.
+				calls <- "events:" + msg.Event
+			}
+			return next(c, msg)
+		}
+	})
 
-				expectedMessage := neffos.Message{
-					Event:    neffos.OnNativeMessage,
-					Body:     nativeMessage,
-					IsNative: true,
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events}, func(s *neffos.Server) {
+		s.Use(func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+			return func(c *neffos.NSConn, msg neffos.Message) error {
+				if msg.Event == neffos.OnNamespaceConnect && c.Conn.Get("rejected") == nil {
+					c.Conn.Set("rejected", true)
+					return errors.New("unauthorized")
 				}
 
-				if !reflect.DeepEqual(expectedMessage, msg) {
-					t.Fatalf("expected a native message to be:\n%#+v\n\tbut got:\n%#+v", expectedMessage, msg)
+				if msg.Event != neffos.OnNamespaceDisconnect {
+					calls <- "server:" + msg.Event
 				}
+				return next(c, msg)
+			}
+		})
+	})
+	defer teardownServer()
 
-				return nil
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		// the namespace connect is rejected by the server middleware without a callback.
+		if _, err := client.Connect(context.TODO(), namespace); err == nil || err.Error() != "unauthorized" {
+			t.Fatalf("[%s] expected the unauthorized error but got: %v", dialer, err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(context.TODO(), "chat", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, expected := range []string{
+			"server:" + neffos.OnNamespaceConnect, "events:" + neffos.OnNamespaceConnect,
+			"server:" + neffos.OnNamespaceConnected, "events:" + neffos.OnNamespaceConnected,
+			"server:chat", "events:chat", "chat",
+		} {
+			if got := <-calls; expected != got {
+				t.Fatalf("[%s] expected call: %s but got: %s", dialer, expected, got)
+			}
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventsSetWithReply(t *testing.T) {
+	var (
+		namespace   = "default"
+		expectedErr = neffos.NewError(400, "empty body", nil)
+		echoes      = make(chan string, 1)
+		events      = neffos.Events{
+			"classic": func(c *neffos.NSConn, msg neffos.Message) error {
+				return neffos.Reply([]byte("classic"))
 			},
 		}
+		reply = func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+			if len(msg.Body) == 0 {
+				return nil, expectedErr
+			}
+
+			return append([]byte("reply:"), msg.Body...), nil
+		}
 	)
 
-	teardownServer := runTestServer("localhost:8080", events)
+	events.SetWithReply("reply", reply)
+	events.SetWithReply("echo", reply, neffos.EmitUnaskedReply)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
 	defer teardownServer()
 
-	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{
+		"reply": func(c *neffos.NSConn, msg neffos.Message) error {
+			echoes <- "unexpected reply: " + string(msg.Body)
+			return nil
+		},
+		"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+			echoes <- string(msg.Body)
+			return nil
+		},
+	}}, func(dialer string, client *neffos.Client) {
 		defer client.Close()
 
 		c, err := client.Connect(context.TODO(), namespace)
@@ -321,104 +385,1767 @@ func TestOnNativeMessageOnly(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		wg.Add(1)
-		c.Conn.Write(neffos.Message{
-			Body:     nativeMessage,
-			IsNative: true,
-		})
+		for event, expected := range map[string]string{"reply": "reply:data", "echo": "reply:data", "classic": "classic"} {
+			msg, err := c.Ask(context.TODO(), event, []byte("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
 
-		wg.Wait()
+			if got := string(msg.Body); expected != got {
+				t.Fatalf("[%s] expected body of the %s event: %s but got: %s", dialer, event, expected, got)
+			}
+		}
+
+		if _, err = c.Ask(context.TODO(), "reply", nil); !errors.Is(err, expectedErr) {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, expectedErr, err)
+		}
+
+		// the reply of an emit is discarded, the "echo" one is emitted back.
+		c.Emit("reply", []byte("emit"))
+		c.Emit("echo", []byte("emit"))
+		if expected, got := "reply:emit", <-echoes; expected != got {
+			t.Fatalf("[%s] expected echo: %s but got: %s", dialer, expected, got)
+		}
 	})()
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-// No need to encourage users to use go routines for event sending even if it's totally safe in neffos.
-// It works but ^
-// func TestSimultaneouslyEventsRoutines(t *testing.T) {
-// 	// test multiple goroutines sending events, it should work because the lib it is designed to take care of these things.
-// 	var (
-// 		wg               sync.WaitGroup
-// 		namespace        = "namespace1"
-// 		event1           = "event1"
-// 		event2           = "event2"
-// 		event3           = "event3"
-// 		expectedMessages = map[string]neffos.Message{
-// 			event1: {
-// 				Namespace: namespace,
-// 				Event:     event1,
-// 				Body:      []byte("body1"),
-// 			},
-// 			event2: {
-// 				Namespace: namespace,
-// 				Event:     event2,
-// 				Body:      []byte("body2"),
-// 			},
-// 			event3: {
-// 				Namespace: namespace,
-// 				Event:     event3,
-// 				Body:      []byte("body3"),
-// 			},
-// 		}
-// 		events = neffos.Events{
-// 			neffos.OnAnyEvent: func(c *neffos.NSConn, msg neffos.Message) error {
-// 				if neffos.IsSystemEvent(msg.Event) {
-// 					return nil
-// 				}
+func TestServerSetEvent(t *testing.T) {
+	var (
+		namespace = "app"
+		servers   = make(chan *neffos.Server, len(testAdapters))
+	)
 
-// 				expectedMessage := expectedMessages[msg.Event]
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(s *neffos.Server) {
+		servers <- s
+	})
+	defer teardownServer()
 
-// 				if !reflect.DeepEqual(msg, expectedMessage) {
-// 					t.Fatalf("expected message:\n%#+v\n\tbut got:\n%#+v", expectedMessage, msg)
-// 				}
+	gobwasServer, gorillaServer := <-servers, <-servers
+	<-servers // nhooyr.
+	<-servers // tcp.
+	if err := gobwasServer.SetEvent("unknown", "plugin", nil); err != neffos.ErrBadNamespace {
+		t.Fatalf("expected the bad namespace error but got: %v", err)
+	}
 
-// 				if c.Conn.IsClient() {
-// 					// wait for server's reply to the client's send act before done with this event test.
-// 					defer wg.Done()
-// 				} else {
-// 					// send back to the client the message as it's.
-// 					return neffos.Reply(msg.Body)
-// 				}
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
 
-// 				return nil
-// 			},
-// 		}
-// 	)
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-// 	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
-// 	defer teardownServer()
+		// both servers share the same namespaces.
+		if err = gorillaServer.SetEvent(namespace, "plugin", func(c *neffos.NSConn, msg neffos.Message) error {
+			return neffos.Reply([]byte("plugin"))
+		}); err != nil {
+			t.Fatal(err)
+		}
 
-// 	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: events},
-// 		func(dialer string, client *neffos.Client) {
-// 			defer client.Close()
+		msg, err := c.Ask(context.TODO(), "plugin", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "plugin", string(msg.Body); expected != got {
+			t.Fatalf("[%s] expected body: %s but got: %s", dialer, expected, got)
+		}
 
-// 			c, err := client.Connect(nil, namespace)
-// 			if err != nil {
-// 				t.Fatal(err)
-// 			}
+		gorillaServer.SetEvent(namespace, "plugin", nil)
 
-// 			send := func(event string) {
-// 				wg.Add(1)
-// 				msg := expectedMessages[event]
-// 				c.Emit(msg.Event, msg.Body)
-// 			}
+		// no callback, no reply.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, err = c.Ask(ctx, "plugin", nil); err != context.DeadlineExceeded {
+			t.Fatalf("[%s] expected the removed event not to reply but got: %v", dialer, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
 
-// 			for i := 0; i < 5; i++ {
-// 				go send(event1)
-// 				go send(event2)
-// 				go send(event3)
-// 			}
+func TestReservedEvents(t *testing.T) {
+	var (
+		namespace = "default"
+		connected uint32
+		audited   = make(chan string, 4)
+		errs      = make(chan error, 4)
+		events    = neffos.Events{
+			neffos.OnNamespaceConnected: func(c *neffos.NSConn, msg neffos.Message) error {
+				atomic.AddUint32(&connected, 1)
+				return nil
+			},
+		}
+	)
 
-// 			for i := 0; i < 5; i++ {
-// 				go send(event3)
-// 				go send(event2)
-// 				go send(event1)
-// 			}
+	events.OnAny(neffos.AnyEventAlways, func(c *neffos.NSConn, msg neffos.Message) error {
+		audited <- msg.Event
+		return nil
+	})
 
-// 			wg.Wait()
-// 		})()
-// 	if err != nil {
-// 		t.Fatal(err)
-// 	}
-// }
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events}, func(s *neffos.Server) {
+		s.OnError = func(c *neffos.Conn, err error) {
+			errs <- err
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the events which are fired by the server itself only.
+		for _, event := range []string{neffos.OnNamespaceConnected, neffos.OnAnyEvent} {
+			if _, err = c.Ask(context.TODO(), event, nil); !errors.Is(err, neffos.ErrReservedEvent) {
+				t.Fatalf("[%s] expected the reserved event error of the %s event but got: %v", dialer, event, err)
+			}
+
+			if err = <-errs; err != neffos.ErrReservedEvent {
+				t.Fatalf("[%s] expected the OnError to be fired with the reserved event error but got: %v", dialer, err)
+			}
+		}
+
+		// the names of the configuration are not events, they are audited like the rest.
+		c.Emit("_anyEventAlways", nil)
+		select {
+		case event := <-audited:
+			if expected := "_anyEventAlways"; expected != event {
+				t.Fatalf("[%s] expected the audited event: %s but got: %s", dialer, expected, event)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] expected the event to be audited", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := uint32(len(testAdapters)), atomic.LoadUint32(&connected); expected != got {
+		t.Fatalf("expected the OnNamespaceConnected to be fired on connect only: %d times but got: %d", expected, got)
+	}
+}
+
+func TestEventsServerOnly(t *testing.T) {
+	var (
+		namespace    = "default"
+		serverFired  = make(chan string, 4)
+		clientFired  = make(chan string, 4)
+		errs         = make(chan error, 4)
+		serverEvents = neffos.Events{
+			neffos.OnNamespaceConnected: func(c *neffos.NSConn, msg neffos.Message) error {
+				c.Emit("grantAdmin", nil)
+				return nil
+			},
+			"grantAdmin": func(c *neffos.NSConn, msg neffos.Message) error {
+				serverFired <- msg.Event
+				return nil
+			},
+			"report": func(c *neffos.NSConn, msg neffos.Message) error {
+				serverFired <- msg.Event
+				return nil
+			},
+		}
+		clientEvents = neffos.Events{
+			"grantAdmin": func(c *neffos.NSConn, msg neffos.Message) error {
+				clientFired <- msg.Event
+				return nil
+			},
+			"report": func(c *neffos.NSConn, msg neffos.Message) error {
+				clientFired <- msg.Event
+				return nil
+			},
+		}
+	)
+
+	serverEvents.ServerOnly("grantAdmin").ClientOnly("report")
+	clientEvents.ServerOnly("grantAdmin").ClientOnly("report")
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: serverEvents}, func(s *neffos.Server) {
+		s.OnError = func(c *neffos.Conn, err error) {
+			errs <- err
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: clientEvents}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// server-side emits work normally.
+		if expected, got := "grantAdmin", <-clientFired; expected != got {
+			t.Fatalf("[%s] expected the client to receive: %s but got: %s", dialer, expected, got)
+		}
+
+		if _, err = c.Ask(context.TODO(), "grantAdmin", nil); !errors.Is(err, neffos.ErrServerOnlyEvent) {
+			t.Fatalf("[%s] expected the server-only event error but got: %v", dialer, err)
+		}
+
+		if err = <-errs; err != neffos.ErrServerOnlyEvent {
+			t.Fatalf("[%s] expected the OnError to be fired with the server-only event error but got: %v", dialer, err)
+		}
+
+		// client-side emits work normally.
+		c.Emit("report", nil)
+		if expected, got := "report", <-serverFired; expected != got {
+			t.Fatalf("[%s] expected the server to receive: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-serverFired:
+		t.Fatalf("expected the server not to fire: %s", event)
+	case event := <-clientFired:
+		t.Fatalf("expected the client not to fire: %s", event)
+	default:
+	}
+}
+
+func TestEventsAsync(t *testing.T) {
+	var (
+		namespace = "default"
+		release   = make(chan struct{})
+		events    = neffos.Events{
+			"slow": func(c *neffos.NSConn, msg neffos.Message) error {
+				<-release
+				return neffos.Reply([]byte("slow"))
+			},
+			"fast": func(c *neffos.NSConn, msg neffos.Message) error {
+				return neffos.Reply([]byte("fast"))
+			},
+		}
+	)
+
+	pool := neffos.NewHandlerPool(neffos.HandlerPoolOptions{Workers: 2, Ordering: neffos.Concurrent})
+	defer pool.Close()
+	events.Async(neffos.OnAnyEvent, pool)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		slowReply := make(chan neffos.Message, 1)
+		go func() {
+			msg, err := c.Ask(context.TODO(), "slow", nil)
+			if err != nil {
+				t.Error(err)
+			}
+			slowReply <- msg
+		}()
+
+		// the slow callback is running, the connection reads the next message.
+		msg, err := c.Ask(context.TODO(), "fast", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "fast", string(msg.Body); expected != got {
+			t.Fatalf("[%s] expected body: %s but got: %s", dialer, expected, got)
+		}
+
+		release <- struct{}{}
+		if expected, got := "slow", string((<-slowReply).Body); expected != got {
+			t.Fatalf("[%s] expected the asynchronous reply: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, stats := uint64(2*len(testAdapters)), pool.Stats(); stats.Handled != expected || stats.QueueDepth != 0 {
+		t.Fatalf("expected %d handled callbacks on an empty queue but got: %#+v", expected, stats)
+	}
+}
+
+// concurrentRun is the state of the concurrent callbacks of a single connection of the `TestEventsConcurrent`.
+type concurrentRun struct {
+	running  int32
+	overlaps chan int32
+	barrier  sync.WaitGroup
+}
+
+func TestEventsConcurrent(t *testing.T) {
+	var (
+		namespace = "default"
+		mu        sync.Mutex
+		ordered   []string
+		// the state of the concurrent callbacks of each dialer's run, by the connection's id.
+		runs   sync.Map
+		events = neffos.Events{
+			"ordered": func(c *neffos.NSConn, msg neffos.Message) error {
+				mu.Lock()
+				ordered = append(ordered, string(msg.Body))
+				mu.Unlock()
+				return nil
+			},
+			"concurrent": func(c *neffos.NSConn, msg neffos.Message) error {
+				v, _ := runs.Load(c.Conn.ID())
+				run := v.(*concurrentRun)
+				run.overlaps <- atomic.AddInt32(&run.running, 1)
+				run.barrier.Done()
+				// all the concurrent callbacks of the connection are running at the same time.
+				run.barrier.Wait()
+				atomic.AddInt32(&run.running, -1)
+				return neffos.Reply(msg.Body)
+			},
+			"done": func(c *neffos.NSConn, msg neffos.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				body := strings.Join(ordered, ",")
+				ordered = nil
+				return neffos.Reply([]byte(body))
+			},
+		}
+	)
+
+	events.Concurrent("concurrent")
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		run := &concurrentRun{overlaps: make(chan int32, 3)}
+		run.barrier.Add(3)
+		runs.Store(client.ID, run)
+		defer runs.Delete(client.ID)
+
+		replies := make(chan string, 3)
+		for i := 1; i <= 3; i++ {
+			go func(body string) {
+				msg, err := c.Ask(context.TODO(), "concurrent", []byte(body))
+				if err != nil {
+					t.Error(err)
+				}
+				replies <- string(msg.Body)
+			}("c" + strconv.Itoa(i))
+
+			c.Emit("ordered", []byte(strconv.Itoa(i*2-1)))
+			c.Emit("ordered", []byte(strconv.Itoa(i*2)))
+		}
+
+		got := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			got[<-replies] = true
+		}
+		if !got["c1"] || !got["c2"] || !got["c3"] {
+			t.Fatalf("[%s] expected the replies of the concurrent events but got: %v", dialer, got)
+		}
+
+		msg, err := c.Ask(context.TODO(), "done", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "1,2,3,4,5,6", string(msg.Body); expected != got {
+			t.Fatalf("[%s] expected the ordered events in order: %s but got: %s", dialer, expected, got)
+		}
+
+		max := int32(0)
+		for i := 0; i < 3; i++ {
+			if n := <-run.overlaps; n > max {
+				max = n
+			}
+		}
+		if max != 3 {
+			t.Fatalf("[%s] expected the concurrent events to overlap but got a maximum of %d running", dialer, max)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventsWithTimeout(t *testing.T) {
+	var (
+		namespace = "default"
+		release   = make(chan struct{})
+		cancelled = make(chan error, 4)
+		errs      = make(chan error, 4)
+		events    = neffos.Events{
+			"slow": func(c *neffos.NSConn, msg neffos.Message) error {
+				<-msg.Context().Done()
+				cancelled <- msg.Context().Err()
+				<-release
+				// discarded.
+				return neffos.Reply([]byte("late"))
+			},
+			"default": func(c *neffos.NSConn, msg neffos.Message) error {
+				<-msg.Context().Done()
+				return nil
+			},
+			"fast": func(c *neffos.NSConn, msg neffos.Message) error {
+				if msg.Context().Err() != nil {
+					t.Fatalf("expected a live context")
+				}
+				return neffos.Reply([]byte("fast"))
+			},
+		}
+	)
+	defer close(release)
+
+	events.WithTimeout(neffos.OnAnyEvent, 100*time.Millisecond).WithTimeout("slow", 50*time.Millisecond)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events}, func(s *neffos.Server) {
+		s.OnError = func(c *neffos.Conn, err error) {
+			errs <- err
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, event := range []string{"slow", "default"} {
+			start := time.Now()
+			if _, err = c.Ask(context.TODO(), event, nil); !errors.Is(err, neffos.ErrHandlerTimeout) {
+				t.Fatalf("[%s] expected the handler timeout error of the %s event but got: %v", dialer, event, err)
+			}
+
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("[%s] expected the %s event to time out but it took: %s", dialer, event, elapsed)
+			}
+
+			err = <-errs
+			if eventErr, ok := err.(*neffos.EventError); !ok || eventErr.Event != event || !errors.Is(err, neffos.ErrHandlerTimeout) {
+				t.Fatalf("[%s] expected the OnError to be fired with the handler timeout error of the %s event but got: %v", dialer, event, err)
+			}
+		}
+
+		if err = <-cancelled; err != context.DeadlineExceeded {
+			t.Fatalf("[%s] expected the context of the slow handler to be cancelled but got: %v", dialer, err)
+		}
+
+		// the slow handler is still running, the connection reads the next message.
+		msg, err := c.Ask(context.TODO(), "fast", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "fast", string(msg.Body); expected != got {
+			t.Fatalf("[%s] expected body: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventError(t *testing.T) {
+	var (
+		namespace   = "default"
+		errNotFound = errors.New("not found")
+		errTyped    = neffos.NewError(1404, "user not found", []byte("42"))
+		errs        = make(chan error, 4)
+		events      = neffos.Events{
+			"find": func(c *neffos.NSConn, msg neffos.Message) error {
+				return errNotFound
+			},
+			"findTyped": func(c *neffos.NSConn, msg neffos.Message) error {
+				return errTyped
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events}, func(s *neffos.Server) {
+		s.OnError = func(c *neffos.Conn, err error) {
+			errs <- err
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the remote side receives the error of the callback only.
+		if _, err = c.Ask(context.TODO(), "find", nil); err == nil || err.Error() != errNotFound.Error() {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, errNotFound, err)
+		}
+
+		var eventErr *neffos.EventError
+		if err = <-errs; !errors.As(err, &eventErr) || !errors.Is(err, errNotFound) {
+			t.Fatalf("[%s] expected the OnError to be fired with an event error but got: %v", dialer, err)
+		}
+
+		if eventErr.Namespace != namespace || eventErr.Event != "find" || eventErr.ConnID == "" || errors.Unwrap(err) != errNotFound {
+			t.Fatalf("[%s] unexpected event error: %#+v", dialer, eventErr)
+		}
+
+		if expected, got := fmt.Sprintf(`event "find" of namespace "default" (connection %s): not found`, eventErr.ConnID), err.Error(); expected != got {
+			t.Fatalf("[%s] expected error text: %s but got: %s", dialer, expected, got)
+		}
+
+		_, err = c.Ask(context.TODO(), "findTyped", nil)
+		var typed *neffos.Error
+		if !errors.As(err, &typed) || typed.Code() != 1404 || string(typed.Data()) != "42" {
+			t.Fatalf("[%s] expected the typed error but got: %v", dialer, err)
+		}
+
+		if err = <-errs; !errors.Is(err, errTyped) {
+			t.Fatalf("[%s] expected the OnError to be fired with the typed error but got: %v", dialer, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnNativeMessageAndMessageError(t *testing.T) {
+	var (
+		wg                             sync.WaitGroup
+		namespace                      = "" // empty namespace and OnNativeMessage event defined to allow native websocket messages to come through.
+		eventThatWillGiveErrorByServer = "event_error_server"
+		eventErrorText                 = "this event will give error by server"
+		nativeMessage                  = []byte("this is a native/raw websocket message")
+		events                         = neffos.Events{
+			neffos.OnNativeMessage: func(c *neffos.NSConn, msg neffos.Message) error {
+				defer wg.Done()
+
+				expectedMessage := neffos.Message{
+					Event:    neffos.OnNativeMessage,
+					Body:     nativeMessage,
+					IsNative: true,
+				}
+
+				if !reflect.DeepEqual(expectedMessage, msg) {
+					t.Fatalf("expected a native message to be:\n%#+v\n\tbut got:\n%#+v", expectedMessage, msg)
+				}
+
+				return nil
+			},
+		}
+	)
+
+	serverHandler := neffos.JoinConnHandlers(neffos.Namespaces{namespace: events},
+		neffos.Events{
+			eventThatWillGiveErrorByServer: func(c *neffos.NSConn, msg neffos.Message) error {
+				return fmt.Errorf(eventErrorText)
+			},
+		})
+	teardownServer := runTestServer("localhost:8080", serverHandler)
+	defer teardownServer()
+
+	clientHandler := neffos.JoinConnHandlers(neffos.Namespaces{namespace: events},
+		neffos.Events{
+			eventThatWillGiveErrorByServer: func(c *neffos.NSConn, msg neffos.Message) error {
+				defer wg.Done()
+				if !c.Conn.IsClient() {
+					t.Fatalf("this should only be executed by client-side, if not then the JoinConnHandlers didn't work as expected")
+				}
+
+				if msg.Err == nil {
+					t.Fatalf("expected an error from event: %s", eventThatWillGiveErrorByServer)
+				}
+				if expected, got := eventErrorText, msg.Err.Error(); expected != got {
+					t.Fatalf("expected an error from event: %s to match: '%s' but got: '%s'", eventThatWillGiveErrorByServer, expected, got)
+				}
+				return nil
+			},
+		})
+
+	err := runTestClient("localhost:8080", clientHandler, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Ask is not available on native websocket messages of course.
+		wg.Add(1)
+		c.Conn.Write(neffos.Message{
+			Body:     nativeMessage,
+			IsNative: true,
+		})
+
+		wg.Add(1)
+		c.Emit(eventThatWillGiveErrorByServer, []byte("doesn't matter"))
+
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnNativeMessageOnly(t *testing.T) {
+	// when the only one namespace is "" and event OnNativeMessage.
+	var (
+		wg            sync.WaitGroup
+		namespace     = ""
+		nativeMessage = []byte("this is a native/raw websocket message")
+		events        = neffos.Events{
+			neffos.OnNativeMessage: func(c *neffos.NSConn, msg neffos.Message) error {
+				defer wg.Done()
+
+				expectedMessage := neffos.Message{
+					Event:    neffos.OnNativeMessage,
+					Body:     nativeMessage,
+					IsNative: true,
+				}
+
+				if !reflect.DeepEqual(expectedMessage, msg) {
+					t.Fatalf("expected a native message to be:\n%#+v\n\tbut got:\n%#+v", expectedMessage, msg)
+				}
+
+				return nil
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		c.Conn.Write(neffos.Message{
+			Body:     nativeMessage,
+			IsNative: true,
+		})
+
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// No need to encourage users to use go routines for event sending even if it's totally safe in neffos.
+// It works but ^
+// func TestSimultaneouslyEventsRoutines(t *testing.T) {
+// 	// test multiple goroutines sending events, it should work because the lib it is designed to take care of these things.
+// 	var (
+// 		wg               sync.WaitGroup
+// 		namespace        = "namespace1"
+// 		event1           = "event1"
+// 		event2           = "event2"
+// 		event3           = "event3"
+// 		expectedMessages = map[string]neffos.Message{
+// 			event1: {
+// 				Namespace: namespace,
+// 				Event:     event1,
+// 				Body:      []byte("body1"),
+// 			},
+// 			event2: {
+// 				Namespace: namespace,
+// 				Event:     event2,
+// 				Body:      []byte("body2"),
+// 			},
+// 			event3: {
+// 				Namespace: namespace,
+// 				Event:     event3,
+// 				Body:      []byte("body3"),
+// 			},
+// 		}
+// 		events = neffos.Events{
+// 			neffos.OnAnyEvent: func(c *neffos.NSConn, msg neffos.Message) error {
+// 				if neffos.IsSystemEvent(msg.Event) {
+// 					return nil
+// 				}
+
+// 				expectedMessage := expectedMessages[msg.Event]
+
+// 				if !reflect.DeepEqual(msg, expectedMessage) {
+// 					t.Fatalf("expected message:\n%#+v\n\tbut got:\n%#+v", expectedMessage, msg)
+// 				}
+
+// 				if c.Conn.IsClient() {
+// 					// wait for server's reply to the client's send act before done with this event test.
+// 					defer wg.Done()
+// 				} else {
+// 					// send back to the client the message as it's.
+// 					return neffos.Reply(msg.Body)
+// 				}
+
+// 				return nil
+// 			},
+// 		}
+// 	)
+
+// 	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
+// 	defer teardownServer()
+
+// 	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: events},
+// 		func(dialer string, client *neffos.Client) {
+// 			defer client.Close()
+
+// 			c, err := client.Connect(nil, namespace)
+// 			if err != nil {
+// 				t.Fatal(err)
+// 			}
+
+// 			send := func(event string) {
+// 				wg.Add(1)
+// 				msg := expectedMessages[event]
+// 				c.Emit(msg.Event, msg.Body)
+// 			}
+
+// 			for i := 0; i < 5; i++ {
+// 				go send(event1)
+// 				go send(event2)
+// 				go send(event3)
+// 			}
+
+// 			for i := 0; i < 5; i++ {
+// 				go send(event3)
+// 				go send(event2)
+// 				go send(event1)
+// 			}
+
+// 			wg.Wait()
+// 		})()
+// 	if err != nil {
+// 		t.Fatal(err)
+// 	}
+// }
+
+func TestMessageExpiryDrop(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		servers   []*neffos.Server
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"expired": func(c *neffos.NSConn, msg neffos.Message) error {
+					t.Fatalf("expired message should not be dispatched")
+					return nil
+				},
+				"alive": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						wg.Done()
+					}
+					return nil
+				},
+			},
+		}
+		millis = func(d time.Duration) int64 {
+			return time.Now().Add(d).UnixNano() / int64(time.Millisecond)
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// passes the client's tolerance but not the server's one.
+		if !c.Conn.Write(neffos.Message{Namespace: namespace, Event: "expired", Expiry: millis(-time.Minute)}) {
+			t.Fatalf("[%s] expected message to be written because of the client's tolerance", dialer)
+		}
+
+		if c.Conn.Write(neffos.Message{Namespace: namespace, Event: "expired", Expiry: millis(-time.Hour)}) {
+			t.Fatalf("[%s] expected expired message to be dropped before write", dialer)
+		}
+
+		if expected, got := uint64(1), client.Metrics().ExpiredOutbound; expected != got {
+			t.Fatalf("[%s] expected %d expired outbound messages but got %d", dialer, expected, got)
+		}
+
+		wg.Add(1)
+		c.Conn.Write(neffos.Message{Namespace: namespace, Event: "alive", Expiry: millis(time.Minute)})
+		wg.Wait()
+	}, neffos.WithExpiryTolerance(2*time.Minute))()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range servers {
+		if expected, got := uint64(1), s.Metrics().ExpiredInbound; expected != got {
+			t.Fatalf("[%d] expected %d expired inbound messages but got %d", i, expected, got)
+		}
+	}
+}
+
+func TestTypedErrorAcrossTheWire(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		typedErr  = neffos.NewError(409, "conflict", []byte("version:2"))
+		testErr   = func(dialer string, err error) {
+			var remoteErr *neffos.Error
+			if !errors.As(err, &remoteErr) {
+				t.Fatalf("[%s] expected a typed error but got: %#+v", dialer, err)
+			}
+
+			if remoteErr.Code() != 409 || remoteErr.Error() != "conflict" || string(remoteErr.Data()) != "version:2" {
+				t.Fatalf("[%s] expected typed error to match: %#+v but got: %#+v", dialer, typedErr, remoteErr)
+			}
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{
+		"update": func(c *neffos.NSConn, msg neffos.Message) error {
+			return typedErr
+		},
+	}})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{
+		"update": func(c *neffos.NSConn, msg neffos.Message) error {
+			// the error echo of an emit.
+			defer wg.Done()
+			testErr("echo", msg.Err)
+			return nil
+		},
+	}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.Ask(context.TODO(), "update", nil)
+		testErr(dialer, err)
+
+		wg.Add(1)
+		c.Emit("update", nil)
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectRejectionTypedError(t *testing.T) {
+	var (
+		unauthorized = neffos.NewError(401, "unauthorized", []byte(`{"login":"/auth"}`))
+		maintenance  = neffos.NewError(503, "maintenance", []byte("retry-after:60"))
+		overCapacity = neffos.NewError(429, "over capacity", nil)
+		full         = neffos.NewError(1001, "room is full", []byte("max:2"))
+
+		testErr = func(dialer string, err error, expected error) {
+			t.Helper()
+
+			var remoteErr *neffos.Error
+			if !errors.As(err, &remoteErr) {
+				t.Fatalf("[%s] expected a typed error but got: %#+v", dialer, err)
+			}
+
+			expectedErr := expected.(*neffos.Error)
+			if remoteErr.Code() != expectedErr.Code() || remoteErr.Error() != expectedErr.Error() || !bytes.Equal(remoteErr.Data(), expectedErr.Data()) {
+				t.Fatalf("[%s] expected typed error to match: %#+v but got: %#+v", dialer, expectedErr, remoteErr)
+			}
+
+			if !errors.Is(err, expected) {
+				t.Fatalf("[%s] expected the error to be the %v", dialer, expected)
+			}
+		}
+
+		// the clients of all dialers are connected before the tests.
+		serverConns = make(chan *neffos.Conn, len(testAdapters))
+	)
+
+	serverNamespaces := neffos.Namespaces{
+		"private": neffos.Events{
+			neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+				return unauthorized
+			},
+		},
+		"rooms": neffos.Events{
+			neffos.OnRoomJoin: func(c *neffos.NSConn, msg neffos.Message) error {
+				return maintenance
+			},
+		},
+		"server-initiated": neffos.Events{},
+		"server-rooms":     neffos.Events{},
+	}
+
+	clientNamespaces := neffos.Namespaces{
+		"private": neffos.Events{},
+		"rooms":   neffos.Events{},
+		"server-initiated": neffos.Events{
+			neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+				return overCapacity
+			},
+		},
+		"server-rooms": neffos.Events{
+			neffos.OnRoomJoin: func(c *neffos.NSConn, msg neffos.Message) error {
+				return full
+			},
+		},
+		"local": neffos.Events{
+			neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+				return overCapacity
+			},
+		},
+	}
+
+	teardownServer := runTestServer("localhost:8080", serverNamespaces, func(s *neffos.Server) {
+		s.OnConnect = func(c *neffos.Conn) error {
+			serverConns <- c
+			return nil
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", clientNamespaces, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+		serverConn := <-serverConns
+
+		// client asks, the server replies (replyConnect).
+		_, err := client.Connect(context.TODO(), "private")
+		testErr(dialer+": client connect", err, unauthorized)
+
+		c, err := client.Connect(context.TODO(), "rooms")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.JoinRoom(context.TODO(), "room1")
+		testErr(dialer+": client join", err, maintenance)
+
+		// the local callback rejects the client's own ask (askConnect).
+		if _, err = client.Connect(context.TODO(), "local"); err != overCapacity {
+			t.Fatalf("[%s] expected the local error: %v but got: %v", dialer, overCapacity, err)
+		}
+
+		// server asks, the client replies.
+		_, err = serverConn.Connect(context.TODO(), "server-initiated")
+		testErr(dialer+": server connect", err, overCapacity)
+
+		if _, err = client.Connect(context.TODO(), "server-rooms"); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = serverConn.Namespace("server-rooms").JoinRoom(context.TODO(), "room1")
+		testErr(dialer+": server join", err, full)
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeferReply(t *testing.T) {
+	var (
+		namespace = "default"
+		body      = []byte("data")
+		expectErr = "deferred error"
+		lateReply = make(chan neffos.ReplyFunc, 2)
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{
+		"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+			reply := c.DeferReply(msg)
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				if err := reply(append(msg.Body, []byte("ok")...), nil); err != nil {
+					t.Error(err)
+				}
+
+				if err := reply(nil, nil); err != neffos.ErrReplySent {
+					t.Errorf("expected error: %v but got: %v", neffos.ErrReplySent, err)
+				}
+			}()
+			return neffos.ErrReplyDeferred
+		},
+		"askErr": func(c *neffos.NSConn, msg neffos.Message) error {
+			reply := c.DeferReply(msg)
+			go reply(nil, errors.New(expectErr))
+			return neffos.ErrReplyDeferred
+		},
+		"late": func(c *neffos.NSConn, msg neffos.Message) error {
+			lateReply <- c.DeferReply(msg)
+			return neffos.ErrReplyDeferred
+		},
+	}})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := c.Ask(context.TODO(), "ask", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := append(body, []byte("ok")...); !bytes.Equal(response.Body, expected) {
+			t.Fatalf("[%s] expected response with body: %s but got: %s", dialer, expected, response.Body)
+		}
+
+		_, err = c.Ask(context.TODO(), "askErr", nil)
+		if err == nil || err.Error() != expectErr {
+			t.Fatalf("[%s] expected error: %s but got: %v", dialer, expectErr, err)
+		}
+
+		c.Emit("late", nil)
+		reply := <-lateReply
+		client.Close()
+		<-client.NotifyClose
+		time.Sleep(50 * time.Millisecond)
+
+		if err = reply(body, nil); err != neffos.ErrWrite {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrWrite, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageSentAtStamp(t *testing.T) {
+	var (
+		namespace = "default"
+		sentAt    = make(chan int64, 2)
+		events    = neffos.Namespaces{namespace: neffos.Events{
+			"stamp": func(c *neffos.NSConn, msg neffos.Message) error {
+				if msg.Age() < 0 || msg.Age() > time.Minute {
+					t.Errorf("unexpected age: %s", msg.Age())
+				}
+
+				sentAt <- msg.SentAt
+				if !c.Conn.IsClient() {
+					// a new message, stamped by the server.
+					c.Emit("stamp", nil)
+				}
+				return nil
+			},
+		}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.StampSentAt = true
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("stamp", nil)
+
+		if got := <-sentAt; got != 0 {
+			t.Fatalf("[%s] expected the client's message to not be stamped but got: %d", dialer, got)
+		}
+
+		if got := <-sentAt; got == 0 {
+			t.Fatalf("[%s] expected the server's message to be stamped", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("stamp", nil)
+
+		if got := <-sentAt; got == 0 {
+			t.Fatalf("[%s] expected the client's message to be stamped", dialer)
+		}
+		<-sentAt
+	}, neffos.WithStampSentAt())()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnNativeMessageFrameType(t *testing.T) {
+	var (
+		nativeMessage = []byte("this is a native/raw websocket message")
+		events        = neffos.Events{
+			neffos.OnNativeMessage: func(c *neffos.NSConn, msg neffos.Message) error {
+				if string(msg.Body) == "write" {
+					// manual reply, honors the incoming frame type.
+					c.Conn.Write(neffos.Message{Body: nativeMessage, IsNative: true, SetBinary: msg.SetBinary})
+					return nil
+				}
+
+				return neffos.Reply(msg.Body)
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	for _, adapter := range testWebsocketAdapters {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/"+adapter, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, frameType := range []int{websocket.TextMessage, websocket.BinaryMessage} {
+			for _, body := range [][]byte{nativeMessage, []byte("write")} {
+				if err = conn.WriteMessage(frameType, body); err != nil {
+					t.Fatal(err)
+				}
+
+				conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+				typ, b, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if typ != frameType {
+					t.Fatalf("[%s] expected reply of frame type: %d but got: %d", adapter, frameType, typ)
+				}
+
+				if !bytes.Equal(b, nativeMessage) {
+					t.Fatalf("[%s] expected reply: %s but got: %s", adapter, nativeMessage, b)
+				}
+			}
+
+			// the heartbeats are not intercepted on the native connections.
+			if err = conn.WriteMessage(frameType, []byte(neffos.HeartbeatPing)); err != nil {
+				t.Fatal(err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			if _, b, err := conn.ReadMessage(); err != nil || string(b) != neffos.HeartbeatPing {
+				t.Fatalf("[%s] expected the native message: %s but got: %q: %v", adapter, neffos.HeartbeatPing, b, err)
+			}
+		}
+
+		conn.Close()
+	}
+}
+
+func TestOnNativeMessageMixedMode(t *testing.T) {
+	var (
+		namespace = "chat"
+		events    = neffos.Namespaces{
+			"": neffos.Events{
+				neffos.OnNativeMessage: func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	defer server.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	url := strings.Replace(httpServer.URL, "http", "ws", 1)
+
+	// the neffos clients use the namespaces as usual.
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := c.Ask(context.TODO(), "echo", []byte("data"))
+	if err != nil || string(msg.Body) != "data" {
+		t.Fatalf("expected the reply of the namespace's event but got: %q: %v", msg.Body, err)
+	}
+
+	expectNative := func(conn *websocket.Conn, send, reply string) {
+		t.Helper()
+
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatal(err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		_, b, err := conn.ReadMessage()
+		if reply == "" {
+			if err == nil {
+				t.Fatalf("expected no reply to: %s but got: %s", send, b)
+			}
+			return
+		}
+
+		if err != nil || string(b) != reply {
+			t.Fatalf("expected the native reply: %s but got: %s: %v", reply, b, err)
+		}
+	}
+
+	// the raw clients are acknowledged on their first message, even if it starts like a server's ack.
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectNative(conn, "A native message", "A native message")
+	expectNative(conn, "native", "native")
+	// a native message which parses as a neffos one of an unknown namespace.
+	expectNative(conn, ";unknown;;event;0;0;body", "")
+	conn.Close()
+
+	server.NativeUnknownNamespaces = true
+	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expectNative(conn, ";unknown;;event;0;0;body", ";unknown;;event;0;0;body")
+}
+
+func TestControlFrameEvents(t *testing.T) {
+	type frame struct {
+		event string
+		body  string
+	}
+
+	frames := make(chan frame, 3)
+	observe := func(c *neffos.NSConn, msg neffos.Message) error {
+		frames <- frame{msg.Event, string(msg.Body)}
+		return nil
+	}
+
+	events := neffos.Events{
+		neffos.OnPing:       observe,
+		neffos.OnPong:       observe,
+		neffos.OnCloseFrame: observe,
+	}
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	expect := func(adapter, event, body string) {
+		t.Helper()
+
+		select {
+		case f := <-frames:
+			if f.event != event || f.body != body {
+				t.Fatalf("[%s] expected event: %s with body: %q but got: %s with body: %q", adapter, event, body, f.event, f.body)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] expected event: %s to be fired", adapter, event)
+		}
+	}
+
+	// the nhooyr socket does not report the control frames.
+	for _, adapter := range []string{"gobwas", "gorilla"} {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/"+adapter, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pongs := make(chan string, 1)
+		conn.SetPongHandler(func(appData string) error {
+			pongs <- appData
+			return nil
+		})
+		go conn.ReadMessage() // process the control frames.
+
+		deadline := time.Now().Add(3 * time.Second)
+		if err = conn.WriteControl(websocket.PingMessage, []byte("hello"), deadline); err != nil {
+			t.Fatal(err)
+		}
+		expect(adapter, neffos.OnPing, "hello")
+
+		// the auto-pong reply is kept.
+		select {
+		case appData := <-pongs:
+			if appData != "hello" {
+				t.Fatalf("[%s] expected pong: hello but got: %s", adapter, appData)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] expected pong reply", adapter)
+		}
+
+		if err = conn.WriteControl(websocket.PongMessage, []byte("world"), deadline); err != nil {
+			t.Fatal(err)
+		}
+		expect(adapter, neffos.OnPong, "world")
+
+		closePayload := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")
+		if err = conn.WriteControl(websocket.CloseMessage, closePayload, deadline); err != nil {
+			t.Fatal(err)
+		}
+		expect(adapter, neffos.OnCloseFrame, string(closePayload))
+
+		conn.Close()
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	var (
+		namespace = "default"
+		servers   []*neffos.Server
+		conns     = make(chan *neffos.Conn, len(testAdapters))
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnAnyEvent: func(c *neffos.NSConn, msg neffos.Message) error {
+					t.Errorf("expected the heartbeats to not fire any event but got: %s", msg.Event)
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+		s.OnConnect = func(c *neffos.Conn) error {
+			conns <- c
+			return nil
+		}
+	})
+	defer teardownServer()
+
+	// as the neffos.js client does.
+	for i, adapter := range testWebsocketAdapters {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/"+adapter, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = conn.WriteMessage(websocket.TextMessage, []byte("M")); err != nil {
+			t.Fatal(err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, b, err := conn.ReadMessage(); err != nil || b[0] != 'A' {
+			t.Fatalf("[%s] expected the acknowledgement but got: %q: %v", adapter, b, err)
+		}
+
+		serverConn := <-conns
+		connected := serverConn.LastActivity()
+		time.Sleep(10 * time.Millisecond)
+
+		if err = conn.WriteMessage(websocket.TextMessage, []byte(neffos.HeartbeatPing)); err != nil {
+			t.Fatal(err)
+		}
+
+		typ, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if typ != websocket.TextMessage || string(b) != neffos.HeartbeatPong {
+			t.Fatalf("[%s] expected the heartbeat pong but got: %q", adapter, b)
+		}
+
+		if !serverConn.LastActivity().After(connected) {
+			t.Fatalf("[%s] expected the last activity to be updated by the heartbeat", adapter)
+		}
+
+		if expected, got := uint64(1), servers[i].Metrics().Heartbeats; expected != got {
+			t.Fatalf("[%s] expected heartbeats: %d but got: %d", adapter, expected, got)
+		}
+
+		conn.Close()
+	}
+
+	// the go client sends the same heartbeats.
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+		<-conns
+
+		deadline := time.Now().Add(3 * time.Second)
+		for client.Metrics().Heartbeats < 2 {
+			if time.Now().After(deadline) {
+				t.Fatalf("[%s] expected the heartbeat pongs of the server", dialer)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}, neffos.WithHeartbeat(20*time.Millisecond))()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range servers {
+		minimum := uint64(2)
+		if i < len(testWebsocketAdapters) {
+			minimum++ // the one of the neffos.js-like client above.
+		}
+
+		if got := s.Metrics().Heartbeats; got < minimum {
+			t.Fatalf("[%d] expected the heartbeat pings of the go client but got: %d", i, got)
+		}
+	}
+}
+
+func TestEventsRateLimited(t *testing.T) {
+	var (
+		namespace = "default"
+		created   uint32
+		errs      = make(chan error, 8)
+		events    = neffos.Events{}
+	)
+
+	events.SetLimited("createRoom", func(c *neffos.NSConn, msg neffos.Message) error {
+		atomic.AddUint32(&created, 1)
+		return neffos.Reply([]byte("created"))
+	}, neffos.Rate{Per: time.Minute, Burst: 1})
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events}, func(s *neffos.Server) {
+		s.OnError = func(c *neffos.Conn, err error) {
+			errs <- err
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(context.TODO(), "createRoom", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(context.TODO(), "createRoom", nil); !errors.Is(err, neffos.ErrRateLimited) {
+			t.Fatalf("[%s] expected the rate limited error but got: %v", dialer, err)
+		}
+
+		var eventErr *neffos.EventError
+		if err = <-errs; !errors.As(err, &eventErr) || eventErr.Event != "createRoom" || !errors.Is(err, neffos.ErrRateLimited) {
+			t.Fatalf("[%s] expected the OnError to be fired with the rate limited error but got: %v", dialer, err)
+		}
+
+		// dropped, not sent back.
+		c.Emit("createRoom", nil)
+		if err = <-errs; !errors.Is(err, neffos.ErrRateLimited) {
+			t.Fatalf("[%s] expected the OnError to be fired for the dropped message but got: %v", dialer, err)
+		}
+
+		// the limits are discarded with the namespace connection.
+		if err = c.Disconnect(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+
+		if c, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(context.TODO(), "createRoom", nil); err != nil {
+			t.Fatalf("[%s] expected a new limit after the reconnection but got: %v", dialer, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := uint32(2*len(testAdapters)), atomic.LoadUint32(&created); expected != got {
+		t.Fatalf("expected callbacks: %d but got: %d", expected, got)
+	}
+}
+
+func TestEventsSetCtx(t *testing.T) {
+	type claimsKey struct{}
+
+	var (
+		namespace = "default"
+		events    = neffos.Events{
+			// both callback types on the same events.
+			"plain": func(c *neffos.NSConn, msg neffos.Message) error {
+				return neffos.Reply([]byte("plain"))
+			},
+		}
+	)
+
+	events.Use(func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+		return func(c *neffos.NSConn, msg neffos.Message) error {
+			return next(c, msg.WithContext(context.WithValue(msg.Context(), claimsKey{}, "admin")))
+		}
+	})
+
+	events.SetCtx("whoami", func(ctx context.Context, c *neffos.NSConn, msg neffos.Message) error {
+		if neffos.NSConnFromContext(ctx) != c {
+			return fmt.Errorf("expected the namespace connection of the context")
+		}
+
+		if m, ok := neffos.MessageFromContext(ctx); !ok || m.Event != msg.Event {
+			return fmt.Errorf("expected the message of the context")
+		}
+
+		if msg.TraceID == "" || neffos.TraceIDFromContext(ctx) != msg.TraceID {
+			return fmt.Errorf("expected trace ID: %q but got: %q", msg.TraceID, neffos.TraceIDFromContext(ctx))
+		}
+
+		if _, ok := ctx.Deadline(); !ok {
+			return fmt.Errorf("expected the deadline of the handler timeout")
+		}
+
+		claims, _ := ctx.Value(claimsKey{}).(string)
+		return neffos.Reply([]byte(claims))
+	})
+
+	events.SetCtx("slow", func(ctx context.Context, c *neffos.NSConn, msg neffos.Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	events.WithTimeout(neffos.OnAnyEvent, 100*time.Millisecond)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := c.Ask(context.TODO(), "whoami", nil)
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		if expected, got := "admin", string(msg.Body); expected != got {
+			t.Fatalf("[%s] expected body: %s but got: %s", dialer, expected, got)
+		}
+
+		if msg, err = c.Ask(context.TODO(), "plain", nil); err != nil || string(msg.Body) != "plain" {
+			t.Fatalf("[%s] expected the plain reply but got: %s: %v", dialer, msg.Body, err)
+		}
+
+		// cancelled by the handler timeout.
+		if _, err = c.Ask(context.TODO(), "slow", nil); !errors.Is(err, neffos.ErrHandlerTimeout) {
+			t.Fatalf("[%s] expected the handler timeout error but got: %v", dialer, err)
+		}
+	}, neffos.WithGenerateTraceID())()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFragmentedMessages(t *testing.T) {
+	var (
+		parts  = []string{"this is a fragmented ", "native message with ", "pings between its frames"}
+		events = neffos.Events{
+			neffos.OnNativeMessage: func(c *neffos.NSConn, msg neffos.Message) error {
+				return neffos.Reply(msg.Body)
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.SetMaxMessageSize(128)
+	})
+	defer teardownServer()
+
+	writeFrame := func(conn net.Conn, op gobwas.OpCode, fin bool, payload []byte) {
+		t.Helper()
+
+		// client frames are masked.
+		if err := gobwas.WriteFrame(conn, gobwas.MaskFrameInPlace(gobwas.NewFrame(op, fin, payload))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// writes the "parts" as the frames of a single message with a ping after each one but the last.
+	writeFragmented := func(conn net.Conn, op gobwas.OpCode, parts []string) {
+		t.Helper()
+
+		for i, part := range parts {
+			if i > 0 {
+				op = gobwas.OpContinuation
+			}
+
+			last := i == len(parts)-1
+			writeFrame(conn, op, last, []byte(part))
+			if !last {
+				writeFrame(conn, gobwas.OpPing, true, []byte(strconv.Itoa(i)))
+			}
+		}
+	}
+
+	for _, adapter := range testWebsocketAdapters {
+		conn, br, _, err := gobwas.Dial(context.TODO(), "ws://localhost:8080/"+adapter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r io.Reader = conn
+		if br != nil {
+			r = io.MultiReader(br, conn)
+		}
+
+		readFrame := func() gobwas.Frame {
+			t.Helper()
+
+			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			f, err := gobwas.ReadFrame(r)
+			if err != nil {
+				t.Fatalf("[%s] %v", adapter, err)
+			}
+			return f
+		}
+
+		for _, op := range []gobwas.OpCode{gobwas.OpText, gobwas.OpBinary} {
+			writeFragmented(conn, op, parts)
+
+			for i := 0; i < len(parts)-1; i++ {
+				if f := readFrame(); f.Header.OpCode != gobwas.OpPong || string(f.Payload) != strconv.Itoa(i) {
+					t.Fatalf("[%s] expected the pong of the ping: %d but got: %#+v", adapter, i, f.Header)
+				}
+			}
+
+			f := readFrame()
+			if f.Header.OpCode != op || !f.Header.Fin {
+				t.Fatalf("[%s] expected a single reply frame of: %v but got: %#+v", adapter, op, f.Header)
+			}
+
+			if expected, got := strings.Join(parts, ""), string(f.Payload); expected != got {
+				t.Fatalf("[%s] expected the reassembled message: %q but got: %q", adapter, expected, got)
+			}
+		}
+
+		// the size limit applies to the whole message, not to each one of its frames.
+		writeFragmented(conn, gobwas.OpText, []string{strings.Repeat("a", 100), strings.Repeat("b", 100)})
+		f := readFrame()
+		if f.Header.OpCode == gobwas.OpPong {
+			f = readFrame()
+		}
+		if code, _ := gobwas.ParseCloseFrameData(f.Payload); f.Header.OpCode != gobwas.OpClose || code != gobwas.StatusMessageTooBig {
+			t.Fatalf("[%s] expected the close frame of a too large message but got: %#+v: %s", adapter, f.Header, f.Payload)
+		}
+
+		conn.Close()
+	}
+
+	// a close frame is answered with a close frame.
+	for _, adapter := range testWebsocketAdapters {
+		conn, br, _, err := gobwas.Dial(context.TODO(), "ws://localhost:8080/"+adapter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r io.Reader = conn
+		if br != nil {
+			r = io.MultiReader(br, conn)
+		}
+
+		writeFrame(conn, gobwas.OpClose, true, gobwas.NewCloseFrameBody(4000, "bye"))
+
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		f, err := gobwas.ReadFrame(r)
+		if err != nil {
+			t.Fatalf("[%s] expected the close frame reply but got: %v", adapter, err)
+		}
+
+		if code, _ := gobwas.ParseCloseFrameData(f.Payload); f.Header.OpCode != gobwas.OpClose || code != 4000 {
+			t.Fatalf("[%s] expected the close frame reply but got: %#+v: %s", adapter, f.Header, f.Payload)
+		}
+
+		conn.Close()
+	}
+}
+
+func TestConnIDDuringAck(t *testing.T) {
+	server, client := neffos.NewPipeSockets()
+	defer server.NetConn().Close()
+
+	var (
+		stop = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+
+	// the ID of the client connection is read while the acknowledgement arrives.
+	hammer := func(c *neffos.Conn) {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						_ = c.ID()
+						_ = c.String()
+						_ = c.Is("fake")
+					}
+				}
+			}()
+		}
+	}
+
+	go func() {
+		// the fake server reads the client's acknowledgement and replies with the ID.
+		if _, _, err := server.ReadData(0); err != nil {
+			t.Error(err)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		server.WriteBinary([]byte("Afake"), 0)
+	}()
+
+	c, err := neffos.Dial(context.TODO(), func(ctx context.Context, url string) (neffos.Socket, error) {
+		return client, nil
+	}, "/", neffos.Namespaces{}, hammer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	close(stop)
+	wg.Wait()
+
+	if expected, got := "fake", c.ID; expected != got {
+		t.Fatalf("expected the ID of the acknowledgement: %s but got: %s", expected, got)
+	}
+}
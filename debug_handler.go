@@ -0,0 +1,206 @@
+package neffos
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The pagination of the `Server#DebugHandler`.
+const (
+	// DefaultDebugPageSize is the number of the connections of a page when the "limit" is missing.
+	DefaultDebugPageSize = 100
+	// MaxDebugPageSize is the maximum "limit" of a page.
+	MaxDebugPageSize = 1000
+)
+
+// DebugReport is the live state of a server, see `Server#DebugHandler`.
+type DebugReport struct {
+	// Total is the number of the connections which match the filters, of all pages.
+	Total int `json:"total"`
+	// Offset and Limit are the pagination of the `Connections`.
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	// Connections is the page of the matched connections, sorted by their ID.
+	Connections []DebugConn `json:"connections"`
+	// Metrics is the snapshot of the server's counters, see `Server#Metrics`.
+	Metrics Metrics `json:"metrics"`
+}
+
+// DebugConn is the state of a connection of a `DebugReport`.
+type DebugConn struct {
+	ID             string `json:"id"`
+	RemoteAddr     string `json:"remoteAddr"`
+	Acknowledged   bool   `json:"acknowledged"`
+	ReconnectTries int    `json:"reconnectTries"`
+	// Namespaces are the connected namespaces with their joined rooms.
+	Namespaces map[string][]string `json:"namespaces"`
+	// PendingAsks is the number of the `Ask` calls which wait for a reply.
+	PendingAsks  int       `json:"pendingAsks"`
+	LastActivity time.Time `json:"lastActivity"`
+	// SlowWrites and BroadcastsStopped are the slow consumer state, see `Conn#SlowStats`.
+	SlowWrites        int  `json:"slowWrites"`
+	BroadcastsStopped bool `json:"broadcastsStopped"`
+}
+
+// snapshotConns returns the connections of the server through its index of the IDs,
+// so it never blocks the broadcasts, unlike the `Do`.
+func (s *Server) snapshotConns() []*Conn {
+	s.connectionsByIDMutex.RLock()
+	conns := make([]*Conn, 0, len(s.connectionsByID))
+	for _, byID := range s.connectionsByID {
+		for c := range byID {
+			conns = append(conns, c)
+		}
+	}
+	s.connectionsByIDMutex.RUnlock()
+
+	return conns
+}
+
+func newDebugConn(c *Conn) DebugConn {
+	info := DebugConn{
+		ID:             c.ID(),
+		Acknowledged:   c.isAcknowledged(),
+		ReconnectTries: c.ReconnectTries,
+		Namespaces:     make(map[string][]string),
+		LastActivity:   c.LastActivity(),
+	}
+
+	slow := c.SlowStats()
+	info.SlowWrites, info.BroadcastsStopped = slow.ConsecutiveSlowWrites, slow.BroadcastsStopped
+
+	if addr := c.RealRemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+
+	c.connectedNamespacesMutex.RLock()
+	for namespace, ns := range c.connectedNamespaces {
+		rooms := make([]string, 0)
+		for _, room := range ns.Rooms() {
+			rooms = append(rooms, room.Name)
+		}
+		sort.Strings(rooms)
+		info.Namespaces[namespace] = rooms
+	}
+	c.connectedNamespacesMutex.RUnlock()
+
+	c.waitingMessagesMutex.RLock()
+	info.PendingAsks = len(c.waitingMessages)
+	c.waitingMessagesMutex.RUnlock()
+
+	return info
+}
+
+// DebugHandler returns a `http.Handler` for the on-call debugging of the server,
+// it should be mounted behind an authentication middleware, it exposes the connections
+// and it can close them.
+//
+// A GET request writes the `DebugReport` as JSON, the connections are filtered by the "namespace"
+// and "id" query parameters and paginated by the "offset" and "limit" ones,
+// see `DefaultDebugPageSize` and `MaxDebugPageSize`.
+// A DELETE request closes the connections of the required "id" query parameter.
+//
+// The state of the connections is collected without blocking the broadcasts,
+// each connection is read under its own locks.
+//
+// Usage:
+//
+//	http.Handle("/debug/neffos", auth(server.DebugHandler()))
+func (s *Server) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		id, namespace := query.Get("id"), query.Get("namespace")
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			if id == "" {
+				http.Error(w, "missing id", http.StatusBadRequest)
+				return
+			}
+
+			closed := 0
+			for _, c := range s.snapshotConns() {
+				if c.ID() == id {
+					c.Close()
+					closed++
+				}
+			}
+
+			if closed == 0 {
+				http.Error(w, "connection not found", http.StatusNotFound)
+				return
+			}
+
+			writeDebugJSON(w, map[string]int{"closed": closed})
+			return
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		offset, err := strconv.Atoi(query.Get("offset"))
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = DefaultDebugPageSize
+		} else if limit > MaxDebugPageSize {
+			limit = MaxDebugPageSize
+		}
+
+		conns := s.snapshotConns()
+		matched := conns[:0]
+		for _, c := range conns {
+			if id != "" && c.ID() != id {
+				continue
+			}
+
+			if namespace != "" && c.Namespace(namespace) == nil {
+				continue
+			}
+
+			matched = append(matched, c)
+		}
+
+		sort.Slice(matched, func(i, j int) bool {
+			if a, b := matched[i].ID(), matched[j].ID(); a != b {
+				return a < b
+			}
+			return matched[i].serverConnID < matched[j].serverConnID
+		})
+
+		report := DebugReport{
+			Total:       len(matched),
+			Offset:      offset,
+			Limit:       limit,
+			Connections: make([]DebugConn, 0),
+			Metrics:     s.Metrics(),
+		}
+
+		if offset < len(matched) {
+			page := matched[offset:]
+			if len(page) > limit {
+				page = page[:limit]
+			}
+
+			for _, c := range page {
+				report.Connections = append(report.Connections, newDebugConn(c))
+			}
+		}
+
+		writeDebugJSON(w, report)
+	})
+}
+
+func writeDebugJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
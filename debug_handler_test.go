@@ -0,0 +1,128 @@
+package neffos_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+func TestDebugHandler(t *testing.T) {
+	events := neffos.Namespaces{"default": neffos.Events{}, "other": neffos.Events{}}
+	server := neffos.New(nil, events)
+	defer server.Close()
+
+	var clients []*neffos.Client
+	for i := 0; i < 3; i++ {
+		client, err := neffos.Dial(context.TODO(), neffos.PipeDialer(server), "/", events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			if _, err = c.JoinRoom(context.TODO(), "room"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = client.Connect(context.TODO(), "other"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		clients = append(clients, client)
+	}
+
+	// the connections are registered by the server asynchronously.
+	for deadline := time.Now().Add(time.Second); server.GetTotalConnections() != 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 server connections but got: %d", server.GetTotalConnections())
+		}
+	}
+
+	handler := server.DebugHandler()
+	get := func(query string) neffos.DebugReport {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status code: %d but got: %d", http.StatusOK, rec.Code)
+		}
+
+		var report neffos.DebugReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	report := get("")
+	if report.Total != 3 || len(report.Connections) != 3 || report.Limit != neffos.DefaultDebugPageSize {
+		t.Fatalf("expected 3 connections but got: %#+v", report)
+	}
+	for i := 1; i < len(report.Connections); i++ {
+		if report.Connections[i-1].ID > report.Connections[i].ID {
+			t.Fatalf("expected the connections to be sorted by their ID")
+		}
+	}
+
+	id := clients[0].ID
+	report = get("id=" + id)
+	if report.Total != 1 {
+		t.Fatalf("expected one connection with the ID: %s but got: %d", id, report.Total)
+	}
+	conn := report.Connections[0]
+	if !conn.Acknowledged || conn.RemoteAddr != "pipe" || conn.PendingAsks != 0 || conn.LastActivity.IsZero() {
+		t.Fatalf("unexpected connection: %#+v", conn)
+	}
+	if rooms, ok := conn.Namespaces["default"]; !ok || len(rooms) != 1 || rooms[0] != "room" {
+		t.Fatalf("expected the joined room of the default namespace but got: %v", conn.Namespaces)
+	}
+	if _, ok := conn.Namespaces["other"]; !ok {
+		t.Fatalf("expected the other namespace but got: %v", conn.Namespaces)
+	}
+
+	if report = get("namespace=other"); report.Total != 1 || report.Connections[0].ID != id {
+		t.Fatalf("expected the connection of the other namespace but got: %#+v", report)
+	}
+
+	if report = get("offset=1&limit=1"); report.Total != 3 || len(report.Connections) != 1 || report.Offset != 1 {
+		t.Fatalf("expected the second page but got: %#+v", report)
+	}
+	if report = get("offset=10"); report.Total != 3 || len(report.Connections) != 0 {
+		t.Fatalf("expected an empty page but got: %#+v", report)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/debug", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code: %d but got: %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/debug?id="+id, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status code: %d but got: %d", http.StatusOK, rec.Code)
+	}
+
+	select {
+	case <-clients[0].NotifyClose:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the client to be closed")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/debug", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status code: %d but got: %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
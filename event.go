@@ -1,6 +1,8 @@
 package neffos
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -17,6 +19,9 @@ type MessageHandlerFunc func(*NSConn, Message) error
 var (
 	// OnNamespaceConnect is the event name which its callback is fired right before namespace connect,
 	// if non-nil error then the remote connection's `Conn.Connect` will fail and send that error text.
+	// An `Error` of the `NewError` arrives at the remote `Conn.Connect` as a typed error with the same code and data,
+	// so the reason of a refusal (i.e unauthorized or over-capacity) can be told apart.
+	// A `Reply` accepts the connection and sends its body within the connect reply, see `NSConn#SetConnectReply`.
 	// Connection is not ready to emit data to the namespace.
 	OnNamespaceConnect = "_OnNamespaceConnect"
 	// OnNamespaceConnected is the event name which its callback is fired after namespace successfully connected.
@@ -27,7 +32,8 @@ var (
 	// For server-side connections the reply matters, so if error returned then the client-side cannot disconnect yet,
 	// for client-side the return value does not matter.
 	OnNamespaceDisconnect = "_OnNamespaceDisconnect" // if allowed to connect then it's allowed to disconnect as well.
-	// OnRoomJoin is the event name which its callback is fired right before room join.
+	// OnRoomJoin is the event name which its callback is fired right before room join,
+	// its error fails the remote `NSConn.JoinRoom` like the `OnNamespaceConnect` one.
 	OnRoomJoin = "_OnRoomJoin" // able to check if allowed to join.
 	// OnRoomJoined is the event name which its callback is fired after the connection has successfully joined to a room.
 	OnRoomJoined = "_OnRoomJoined" // able to broadcast messages to room.
@@ -36,12 +42,35 @@ var (
 	// OnRoomLeft is the event name which its callback is fired after the connection has successfully left from a room.
 	OnRoomLeft = "_OnRoomLeft" // if allowed to join to a room, then its allowed to leave from it.
 	// OnAnyEvent is the event name which its callback is fired when incoming message's event is not declared to the ConnHandler(`Events` or `Namespaces`).
+	// Use the `Events#OnAny` to fire it for all the application events instead.
 	OnAnyEvent = "_OnAnyEvent" // when event no match.
 	// OnNativeMessage is fired on incoming native/raw websocket messages.
 	// If this event defined then an incoming message can pass the check (it's an invalid message format)
 	// with just the Message's Body filled, the Event is "OnNativeMessage" and IsNative always true.
+	// The Message's SetBinary reports whether it was sent as a binary frame,
+	// a `Reply` of its callback is sent back as a native message of the same frame type.
 	// This event should be defined under an empty namespace in order this to work.
+	//
+	// When the empty namespace has other events or there are other namespaces too (mixed mode),
+	// the neffos messages and the native ones are accepted on the same connection:
+	// a frame which parses as a neffos message is a neffos message, the rest are native ones,
+	// so a native payload with the format of a neffos message (7 fields separated by ';') is not a native message,
+	// see the `Server.NativeUnknownNamespaces` and `WithNativeUnknownNamespaces` for the ones with an unknown namespace.
+	// Server-side, a client which does not start with the acknowledgement, i.e a raw websocket client,
+	// is acknowledged on its first message, which can not start with the 'M' of an acknowledgement,
+	// and it's connected to the empty namespace so it can receive native messages.
 	OnNativeMessage = "_OnNativeMessage"
+	// OnPing is fired when a ping control frame is received, the Message's Body is its payload.
+	// It's observational only, the socket replies with a pong as usual.
+	// This event should be defined under an empty namespace and the `Socket` should complete
+	// the `SocketControlObserver` interface, like the gorilla and gobwas ones do.
+	OnPing = "_OnPing"
+	// OnPong is fired when a pong control frame is received, the Message's Body is its payload, see `OnPing`.
+	OnPong = "_OnPong"
+	// OnCloseFrame is fired when a close control frame is received, the Message's Body is its payload:
+	// the status code, as a 2-byte big-endian number, followed by the reason, if any. See `OnPing`.
+	// It's observational only, the connection is closed as usual.
+	OnCloseFrame = "_OnCloseFrame"
 )
 
 // IsSystemEvent reports whether the "event" is a system event,
@@ -57,12 +86,30 @@ func IsSystemEvent(event string) bool {
 	}
 }
 
+// isLocalEvent reports whether the "event" is fired by the connection itself only,
+// a remote side cannot send it, see `ErrReservedEvent`.
+func isLocalEvent(event string) bool {
+	switch event {
+	case OnNamespaceConnected, OnRoomJoined, OnRoomLeft, OnAnyEvent,
+		OnNativeMessage, OnPing, OnPong, OnCloseFrame:
+		return true
+	default:
+		return false
+	}
+}
+
 // CloseError can be used to send and close a remote connection in the event callback's return statement.
 type CloseError struct {
 	error
 	Code int
 }
 
+// NewCloseError returns a new `CloseError` of the "code" and the "err",
+// i.e a `Socket` implementation can report the close status of the remote side through it.
+func NewCloseError(code int, err error) CloseError {
+	return CloseError{error: err, Code: code}
+}
+
 func (err CloseError) Error() string {
 	return fmt.Sprintf("[%d] %s", err.Code, err.error.Error())
 }
@@ -128,6 +175,109 @@ func IsTimeoutError(err error) bool {
 	return false
 }
 
+// Error is a typed error which keeps its code and data across the wire,
+// the remote side receives an `*Error` value as its `Message.Err` field
+// and as the error of its `Ask`, `Connect` and `JoinRoom` calls.
+// Use the `NewError` function to create a new one and `errors.As` to retrieve it.
+//
+// The codes are reserved as follows:
+//   - 100-599 follow the HTTP status codes, neffos uses them for its own errors
+//     (i.e 403 for the `ErrServerOnlyEvent`, 503 for the `ErrHandlerPoolFull` and 504 for the `ErrHandlerTimeout`),
+//     the applications can use them with the same meaning, i.e 401 for unauthorized or 503 for maintenance.
+//     The errors of neffos are told apart by their message too, see `Is`.
+//   - 600-999 are reserved for the future errors of neffos.
+//   - 1000 and above, and the negative codes, are free for the application-specific errors.
+type Error struct {
+	code    int
+	message string
+	data    []byte
+}
+
+// NewError returns a new typed error with a "code", a "message" and optional "data".
+// Event callbacks can return such an error and the remote side
+// can access its code and data through the `Code` and `Data` methods.
+func NewError(code int, message string, data []byte) error {
+	return &Error{code: code, message: message, data: data}
+}
+
+// Error returns the error's message.
+func (e *Error) Error() string {
+	return e.message
+}
+
+// Code returns the error's code.
+func (e *Error) Code() int {
+	return e.code
+}
+
+// Data returns the error's data, if any.
+func (e *Error) Data() []byte {
+	return e.data
+}
+
+// Is reports whether the "target" is a typed error with the same code and message,
+// useful to compare a remote error with a local `NewError` value through `errors.Is`.
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return e.code == t.code && e.message == t.message
+	}
+
+	return false
+}
+
+// EventError wraps the error of an event callback with the namespace, the event and the connection
+// which it was fired for, the `Server.OnError` receives it. The remote side receives the "Err" only.
+// Use the `errors.Is` and `errors.As` functions to check the wrapped error.
+type EventError struct {
+	Namespace string
+	Event     string
+	ConnID    string
+	// TraceID is the `Message.TraceID` of the incoming message, if any.
+	TraceID string
+	Err     error
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("event %q of namespace %q (connection %s): %v", e.Event, e.Namespace, e.ConnID, e.Err)
+}
+
+// Unwrap returns the error of the event callback.
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// errorEnvelopePrefix is the prefix of a serialized `Error`,
+// followed by its JSON representation, see `writeOutput` and `resolveError`.
+const errorEnvelopePrefix = "neffos.Error:"
+
+type errorEnvelope struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    []byte `json:"data,omitempty"`
+}
+
+func encodeError(e *Error) []byte {
+	b, err := json.Marshal(errorEnvelope{Code: e.code, Message: e.message, Data: e.data})
+	if err != nil {
+		return []byte(e.message)
+	}
+
+	return append([]byte(errorEnvelopePrefix), b...)
+}
+
+func decodeError(errorText string) (*Error, bool) {
+	if !strings.HasPrefix(errorText, errorEnvelopePrefix) {
+		return nil, false
+	}
+
+	var envelope errorEnvelope
+	if err := json.Unmarshal([]byte(errorText[len(errorEnvelopePrefix):]), &envelope); err != nil {
+		return nil, false
+	}
+
+	return &Error{code: envelope.Code, message: envelope.Message, data: envelope.Data}, true
+}
+
 type reply struct {
 	Body []byte
 }
@@ -151,3 +301,52 @@ func isReply(err error) ([]byte, bool) {
 func Reply(body []byte) error {
 	return reply{body}
 }
+
+// IsReply reports whether the "err" returned from an event callback is a `Reply`,
+// i.e for the middleware which should not count the replies as failures.
+func IsReply(err error) bool {
+	_, ok := isReply(err)
+	return ok
+}
+
+var (
+	// ErrReplyDeferred can be returned from an event callback which captured a `ReplyFunc`
+	// through `NSConn#DeferReply` to tell that the reply will be sent later on,
+	// nothing is sent back to the other side when the callback returns.
+	ErrReplyDeferred = errors.New("reply deferred")
+	// ErrReplySent may return from a `ReplyFunc` which was already called once.
+	ErrReplySent = errors.New("reply already sent")
+	// ErrHandlerTimeout is sent back to the remote side when an event callback does not return
+	// within its timeout, see `Events#WithTimeout`. Compare it through `errors.Is`.
+	ErrHandlerTimeout = NewError(504, "handler timeout", nil)
+	// ErrHandlerPoolFull is sent back to the remote side when the queue of a `HandlerPool`
+	// with the `OverflowReject` policy is full, see `Events#Async`. Compare it through `errors.Is`.
+	ErrHandlerPoolFull = NewError(503, "handler pool is full", nil)
+	// ErrServerOnlyEvent is sent back to the client when it sends an event which only the server can send,
+	// see `Events#ServerOnly`. Compare it through `errors.Is`.
+	ErrServerOnlyEvent = NewError(403, "server-only event", nil)
+	// ErrClientOnlyEvent is sent back to the server when it sends an event which only the client can send,
+	// see `Events#ClientOnly`. Compare it through `errors.Is`.
+	ErrClientOnlyEvent = NewError(403, "client-only event", nil)
+	// ErrRateLimited is sent back to the remote side, as the error of its `Ask`,
+	// when an event is received more often than its rate limit allows, see `Events#SetLimited`.
+	// Compare it through `errors.Is`.
+	ErrRateLimited = NewError(429, "rate limited", nil)
+	// ErrRejected is sent back to the client, instead of the error of its refused namespace connect/disconnect
+	// or room join/leave, when the `Server#SetErrorTranslator` translates that error to nil.
+	// Compare it through `errors.Is`.
+	ErrRejected = NewError(403, "rejected", nil)
+	// ErrReceiveOnly is returned from the `Ask` of a receive-only connection, i.e a `Server#ServeSSE` one,
+	// which can not reply. Compare it through `errors.Is`.
+	ErrReceiveOnly = NewError(501, "receive-only connection", nil)
+	// ErrReservedEvent is sent back to the remote side when it sends an event which is fired by the connection itself only,
+	// i.e the `OnNamespaceConnected` or the `OnAnyEvent`, their callbacks are not fired. Compare it through `errors.Is`.
+	ErrReservedEvent = NewError(400, "reserved event", nil)
+)
+
+// ReplyFunc sends the reply of a deferred message, see `NSConn#DeferReply`.
+// If "err" is not nil then the other side receives that error (`Ask` returns it),
+// otherwise it receives the "body".
+// It is valid exactly once, next calls return `ErrReplySent`.
+// It returns `ErrWrite` if the connection (or its namespace) is closed.
+type ReplyFunc func(body []byte, err error) error
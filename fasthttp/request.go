@@ -0,0 +1,43 @@
+package fasthttp
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/valyala/fasthttp"
+)
+
+// convertRequest returns a copy of the fasthttp request as a server-side `http.Request`,
+// it does not share memory with the "ctx" as it lives as long as the connection.
+func convertRequest(ctx *fasthttp.RequestCtx) (*http.Request, error) {
+	requestURI := string(ctx.RequestURI())
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &http.Request{
+		Method:     string(ctx.Method()),
+		URL:        u,
+		Proto:      string(ctx.Request.Header.Protocol()),
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Host:       string(ctx.Host()),
+		RemoteAddr: ctx.RemoteAddr().String(),
+		RequestURI: requestURI,
+		TLS:        ctx.TLSConnectionState(),
+	}
+
+	var ok bool
+	if r.ProtoMajor, r.ProtoMinor, ok = http.ParseHTTPVersion(r.Proto); !ok {
+		r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/1.1", 1, 1
+	}
+
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		r.Header.Add(string(key), string(value))
+	})
+	// as the net/http server does.
+	r.Header.Del("Host")
+
+	return r, nil
+}
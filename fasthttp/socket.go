@@ -0,0 +1,186 @@
+package fasthttp
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	"github.com/fasthttp/websocket"
+)
+
+// Socket completes the `neffos.Socket` interface,
+// it describes the underline websocket connection.
+type Socket struct {
+	UnderlyingConn *websocket.Conn
+	request        *http.Request
+
+	netConn *netConn
+	// guards the reads and the writes against the release of the fasthttp connection,
+	// see `release`.
+	released sync.RWMutex
+
+	mu sync.Mutex
+}
+
+func newSocket(underline *websocket.Conn, request *http.Request) *Socket {
+	return &Socket{
+		UnderlyingConn: underline,
+		request:        request,
+		netConn: &netConn{
+			Conn:   underline.UnderlyingConn(),
+			closed: make(chan struct{}),
+		},
+	}
+}
+
+// release waits for the close of the connection and its running reads and writes,
+// the fasthttp connection is released when the hijack handler returns, see `Upgrade`.
+func (s *Socket) release() {
+	<-s.netConn.closed
+
+	s.released.Lock()
+	// closes or releases the connection, if the fasthttp server keeps the hijacked connections.
+	s.netConn.Conn.Close()
+	s.released.Unlock()
+}
+
+// acquire reports whether the connection can be used, the "release" should be called after its use.
+func (s *Socket) acquire() (release func(), ok bool) {
+	s.released.RLock()
+	if s.netConn.isClosed() {
+		s.released.RUnlock()
+		return nil, false
+	}
+
+	return s.released.RUnlock, true
+}
+
+// netConn closes the connection without its release, see `Socket#release`.
+type netConn struct {
+	net.Conn
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *netConn) Close() (err error) {
+	c.once.Do(func() {
+		conn := c.Conn
+		if hijacked, ok := conn.(interface{ UnsafeConn() net.Conn }); ok {
+			// the close of the hijacked one is a no-op or a release.
+			conn = hijacked.UnsafeConn()
+		}
+
+		err = conn.Close()
+		close(c.closed)
+	})
+
+	return
+}
+
+func (c *netConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// NetConn returns the underline net connection.
+func (s *Socket) NetConn() net.Conn {
+	return s.netConn
+}
+
+// Request returns the http request value, converted from the fasthttp one.
+func (s *Socket) Request() *http.Request {
+	return s.request
+}
+
+// ReadData reads binary or text messages from the remote connection.
+func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
+	release, ok := s.acquire()
+	if !ok {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	defer release()
+
+	for {
+		if timeout > 0 {
+			s.UnderlyingConn.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		opCode, data, err := s.UnderlyingConn.ReadMessage()
+		if err != nil {
+			if err == websocket.ErrReadLimit {
+				return nil, 0, neffos.ErrMessageTooLarge
+			}
+			return nil, 0, err
+		}
+
+		if opCode != websocket.BinaryMessage && opCode != websocket.TextMessage {
+			continue
+		}
+
+		return data, neffos.MessageType(opCode), err
+	}
+}
+
+// SetReadLimit sets the maximum size in bytes for a message read from the remote connection,
+// it completes the `neffos.SocketReadLimiter` interface.
+func (s *Socket) SetReadLimit(limit int64) {
+	s.UnderlyingConn.SetReadLimit(limit)
+}
+
+// WriteClose sends a close message with the "code" and "reason" to the remote connection,
+// it completes the `neffos.SocketCloser` interface.
+func (s *Socket) WriteClose(code int, reason string, timeout time.Duration) error {
+	release, ok := s.acquire()
+	if !ok {
+		return io.ErrUnexpectedEOF
+	}
+	defer release()
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	s.mu.Lock()
+	err := s.UnderlyingConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	s.mu.Unlock()
+
+	return err
+}
+
+// WriteBinary sends a binary message to the remote connection.
+func (s *Socket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.write(body, websocket.BinaryMessage, timeout)
+}
+
+// WriteText sends a text message to the remote connection.
+func (s *Socket) WriteText(body []byte, timeout time.Duration) error {
+	return s.write(body, websocket.TextMessage, timeout)
+}
+
+func (s *Socket) write(body []byte, opCode int, timeout time.Duration) error {
+	release, ok := s.acquire()
+	if !ok {
+		return io.ErrUnexpectedEOF
+	}
+	defer release()
+
+	if timeout > 0 {
+		s.UnderlyingConn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	s.mu.Lock()
+	err := s.UnderlyingConn.WriteMessage(opCode, body)
+	s.mu.Unlock()
+
+	return err
+}
@@ -0,0 +1,71 @@
+package fasthttp
+
+import (
+	"net/http"
+
+	"github.com/kataras/neffos"
+
+	"github.com/fasthttp/websocket"
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultUpgrader is a fasthttp/websocket Upgrader with all fields set to the default values.
+var DefaultUpgrader = websocket.FastHTTPUpgrader{}
+
+// Handler returns a fasthttp request handler which serves the neffos "server" through the "upgrader",
+// like the `neffos.Server` does as an `http.Handler`, see `Upgrade`.
+func Handler(server *neffos.Server, upgrader websocket.FastHTTPUpgrader) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		Upgrade(server, upgrader, ctx)
+	}
+}
+
+// Upgrade upgrades the fasthttp request to a websocket connection and serves it through the neffos "server",
+// with the same registration and acknowledgement as the `neffos.Server#ServeHTTP`.
+// The server's Upgrader is not used, it can be constructed with a nil one, i.e `neffos.New(nil, events)`.
+// The `Request` of the connection's socket is converted from the fasthttp one,
+// so the server's `IDGenerator` and `OnConnect` can read its headers, i.e for authentication.
+//
+// It returns the error of the upgrade, which is reported to the `OnUpgradeError` too.
+// The connection is served after the fasthttp handler returns, see `fasthttp.RequestCtx#Hijack`.
+func Upgrade(server *neffos.Server, upgrader websocket.FastHTTPUpgrader, ctx *fasthttp.RequestCtx) error {
+	if ctx.IsHead() {
+		// the reconnection check of the clients, see `neffos.IsTryingToReconnect`.
+		ctx.SetStatusCode(fasthttp.StatusFound)
+		return nil
+	}
+
+	r, err := convertRequest(ctx)
+	if err == nil {
+		err = upgrader.Upgrade(ctx, func(underline *websocket.Conn) {
+			socket := newSocket(underline, r)
+			// closes the socket on errors too.
+			server.ServeSocket(&responseWriter{header: make(http.Header)}, r, socket, nil)
+			// the fasthttp connection is released when this handler returns.
+			socket.release()
+		})
+	} else {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusBadRequest), fasthttp.StatusBadRequest)
+	}
+
+	if err != nil && server.OnUpgradeError != nil {
+		server.OnUpgradeError(err)
+	}
+
+	return err
+}
+
+// responseWriter is passed to the server's `IDGenerator`, the upgrade response is already sent.
+type responseWriter struct {
+	header http.Header
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {}
@@ -0,0 +1,104 @@
+package fasthttp_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	neffosfasthttp "github.com/kataras/neffos/fasthttp"
+	"github.com/kataras/neffos/gorilla"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestUpgrade(t *testing.T) {
+	var (
+		namespace       = "default"
+		errUnauthorized = errors.New("unauthorized")
+		events          = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+		requests = make(chan *http.Request, 1)
+	)
+
+	server := neffos.New(nil, events)
+	server.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+		return r.Header.Get("X-User")
+	}
+	server.OnConnect = func(c *neffos.Conn) error {
+		r := c.Socket().Request()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			return errUnauthorized
+		}
+
+		requests <- r
+		return nil
+	}
+	defer server.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go fasthttp.Serve(ln, neffosfasthttp.Handler(server, neffosfasthttp.DefaultUpgrader))
+
+	header := http.Header{"X-User": {"kataras"}, "Authorization": {"Bearer token"}}
+	url := "ws://" + ln.Addr().String() + "/ws?X-Websocket-Header-X-Tenant=neffos"
+	client, err := neffos.Dial(context.TODO(), gorilla.Dialer(nil, header), url, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := c.Ask(context.TODO(), "echo", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "data", string(reply.Body); expected != got {
+		t.Fatalf("expected reply: %s but got: %s", expected, got)
+	}
+
+	r := <-requests
+	if r.Method != http.MethodGet || r.URL.Path != "/ws" || r.Host != ln.Addr().String() || r.RemoteAddr == "" {
+		t.Fatalf("expected the converted request but got: %s %s %s from: %s", r.Method, r.Host, r.URL, r.RemoteAddr)
+	}
+
+	if expected, got := "neffos", r.Header.Get("X-Tenant"); expected != got {
+		t.Fatalf("expected the URL parameter as header: %s but got: %s", expected, got)
+	}
+
+	if expected, got := "kataras", client.ID; expected != got {
+		t.Fatalf("expected the connection ID of the server's IDGenerator: %s but got: %s", expected, got)
+	}
+
+	if expected, got := uint64(1), server.GetTotalConnections(); expected != got {
+		t.Fatalf("expected total connections: %d but got: %d", expected, got)
+	}
+
+	client.Close()
+	for deadline := time.Now().Add(3 * time.Second); server.GetTotalConnections() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the connection to be removed after its close")
+		}
+	}
+
+	// the OnConnect's error terminates the connection.
+	_, err = neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, events)
+	if err == nil || err.Error() != errUnauthorized.Error() {
+		t.Fatalf("expected the OnConnect error: %v but got: %v", errUnauthorized, err)
+	}
+}
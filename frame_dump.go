@@ -0,0 +1,145 @@
+package neffos
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// DefaultFrameDumpMaxLength is the default maximum length of the dumped frames and bodies,
+// see `Server#SetFrameDump`.
+const DefaultFrameDumpMaxLength = 256
+
+// SetFrameDump enables the debug mode which writes every incoming and outgoing frame
+// of the server's connections to the "w", including the acknowledgement, the heartbeat
+// and the namespace and room control messages. Each line has the direction, the connection ID,
+// the raw frame, escaped for text and hex-encoded for binary ones, and the summary of its parsed message.
+//
+// The frames and the bodies are truncated to "maxLength" bytes,
+// a zero one defaults to the `DefaultFrameDumpMaxLength`, a negative one disables the truncation.
+// The optional "redact" returns the body of a message to dump instead of its actual one,
+// i.e to mask the secrets, it's applied to both the summary and the raw frame.
+//
+// It's meant for diagnosing the interoperability with other clients, not for production,
+// when disabled the frames cost just a nil check. It should be set before serve.
+//
+// Defaults to a nil "w", disabled.
+func (s *Server) SetFrameDump(w io.Writer, maxLength int, redact func(msg Message) []byte) {
+	s.frameDump = newFrameDumper(w, maxLength, redact)
+}
+
+// WithFrameDump is a `DialOption` which writes every frame of the client connection to the "w".
+// See `Server#SetFrameDump` too.
+func WithFrameDump(w io.Writer, maxLength int, redact func(msg Message) []byte) DialOption {
+	return func(c *Conn) {
+		c.frameDump = newFrameDumper(w, maxLength, redact)
+	}
+}
+
+// frameDumper writes the frames of the connections, see `Server#SetFrameDump`.
+type frameDumper struct {
+	mu        sync.Mutex
+	w         io.Writer
+	maxLength int
+	redact    func(msg Message) []byte
+}
+
+func newFrameDumper(w io.Writer, maxLength int, redact func(msg Message) []byte) *frameDumper {
+	if w == nil {
+		return nil
+	}
+
+	if maxLength == 0 {
+		maxLength = DefaultFrameDumpMaxLength
+	}
+
+	return &frameDumper{w: w, maxLength: maxLength, redact: redact}
+}
+
+// dump writes the "b" frame of the "c" connection, "out" is true for the outgoing ones.
+func (d *frameDumper) dump(c *Conn, out bool, binary bool, b []byte) {
+	direction := "in"
+	if out {
+		direction = "out"
+	}
+
+	typ := "text"
+	if binary {
+		typ = "binary"
+	}
+
+	msgTyp := MessageType(TextMessage)
+	if binary {
+		msgTyp = BinaryMessage
+	}
+
+	frame, summary := b, "invalid"
+	switch msg := c.DeserializeMessage(msgTyp, b); {
+	case bytes.Equal(b, heartbeatPingB) || bytes.Equal(b, heartbeatPongB):
+		summary = "heartbeat"
+	case len(b) > 0 && isACK(b) && (msg.isInvalid || msg.IsNative):
+		summary = "ack"
+	case msg.IsNative:
+		body := d.body(c, b, msg, &frame)
+		summary = "native body=" + d.format(body, binary)
+	case !msg.isInvalid:
+		body := d.body(c, b, msg, &frame)
+		summary = fmt.Sprintf("message namespace=%q room=%q event=%q wait=%q err=%q body=%s",
+			msg.Namespace, msg.Room, msg.Event, msg.wait, errorText(msg.Err), d.format(body, binary))
+	}
+
+	line := fmt.Sprintf("neffos: %s conn=%s %s %dB frame=%s %s\n", direction, c.ID(), typ, len(b), d.format(frame, binary), summary)
+
+	d.mu.Lock()
+	io.WriteString(d.w, line)
+	d.mu.Unlock()
+}
+
+// body returns the body of the "msg" to dump, if a redaction is registered
+// the redacted one which replaces the body of the "b" frame of the "msg" too.
+func (d *frameDumper) body(c *Conn, b []byte, msg Message, frame *[]byte) []byte {
+	if d.redact == nil {
+		return msg.Body
+	}
+
+	body := d.redact(msg)
+	switch {
+	case msg.IsNative:
+		*frame = body
+	case c.jsonProtocol:
+		msg.Body = body
+		*frame = writeJSONMessage(new(bytes.Buffer), msg)
+	default:
+		// the body is the tail of the frame, compressed or not.
+		raw := deserializeMessage(TextMessage, b, false, false, false, nil)
+		*frame = append(b[:len(b)-len(raw.Body):len(b)-len(raw.Body)], body...)
+	}
+
+	return body
+}
+
+// format returns the "b" escaped, or hex-encoded if "binary", truncated to the max length.
+func (d *frameDumper) format(b []byte, binary bool) string {
+	var suffix string
+	if d.maxLength > 0 && len(b) > d.maxLength {
+		suffix = "...(" + strconv.Itoa(len(b)-d.maxLength) + " more bytes)"
+		b = b[:d.maxLength]
+	}
+
+	if binary {
+		return hex.EncodeToString(b) + suffix
+	}
+
+	return strconv.Quote(string(b)) + suffix
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,128 @@
+package neffos_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gorilla "github.com/kataras/neffos/gorilla"
+)
+
+// lockedBuffer is a `bytes.Buffer` safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+// expectLine waits for a line which contains all of the "parts".
+func (b *lockedBuffer) expectLine(t *testing.T, side string, parts ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		lines := b.lines()
+		for _, line := range lines {
+			found := true
+			for _, part := range parts {
+				if !strings.Contains(line, part) {
+					found = false
+					break
+				}
+			}
+
+			if found {
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("[%s] expected a line with: %q but got:\n%s", side, parts, strings.Join(lines, "\n"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFrameDump(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+				"binary": func(c *neffos.NSConn, msg neffos.Message) error {
+					return nil
+				},
+			},
+		}
+		redact = func(msg neffos.Message) []byte {
+			return bytes.Replace(msg.Body, []byte("secret"), []byte("***"), -1)
+		}
+		serverDump = new(lockedBuffer)
+		clientDump = new(lockedBuffer)
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	server.SetFrameDump(serverDump, 0, redact)
+	defer server.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	url := strings.Replace(httpServer.URL, "http", "ws", 1)
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, events, neffos.WithFrameDump(clientDump, 16, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the acknowledgement.
+	serverDump.expectLine(t, "server", "neffos: in conn="+client.ID, `frame="M`, " ack")
+	serverDump.expectLine(t, "server", "neffos: out conn="+client.ID, `frame="A`+client.ID, " ack")
+	clientDump.expectLine(t, "client", "neffos: out conn= text", " ack")
+
+	// the control messages.
+	serverDump.expectLine(t, "server", "neffos: in", `event="_OnNamespaceConnect"`, `namespace="default"`)
+
+	if _, err = c.Ask(context.TODO(), "echo", []byte("password=secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	// redacted.
+	serverDump.expectLine(t, "server", "neffos: in", `event="echo"`, `body="password=***"`)
+	serverDump.expectLine(t, "server", "neffos: out", `event="echo"`, `;password=***"`)
+	for _, line := range serverDump.lines() {
+		if strings.Contains(line, "secret") {
+			t.Fatalf("expected the body to be redacted but got: %s", line)
+		}
+	}
+
+	// truncated.
+	clientDump.expectLine(t, "client", "neffos: in", `event="echo"`, `body="password=secret"`, "more bytes)")
+
+	c.EmitBinary("binary", []byte{0xCA, 0xFE})
+	serverDump.expectLine(t, "server", "neffos: in", " binary ", `event="binary"`, "body=cafe")
+}
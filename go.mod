@@ -3,12 +3,24 @@ module github.com/kataras/neffos
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.23.0
+	github.com/coder/websocket v1.8.12
+	github.com/eclipse/paho.mqtt.golang v1.4.2
+	github.com/fasthttp/websocket v1.5.3
 	github.com/gobwas/httphead v0.0.0-20180130184737-2c6c146eadee // indirect
 	github.com/gobwas/pool v0.2.0 // indirect
 	github.com/gobwas/ws v1.0.3
+	github.com/golang/snappy v0.0.1 // indirect
 	github.com/gorilla/websocket v1.4.2
 	github.com/iris-contrib/go.uuid v2.0.0+incompatible
+	github.com/jackc/pgx/v4 v4.18.1
 	github.com/mediocregopher/radix/v3 v3.5.0
-	github.com/nats-io/nats.go v1.9.2
-	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
+	github.com/nats-io/jwt v0.3.2 // indirect
+	github.com/nats-io/nats.go v1.13.0
+	github.com/nsqio/go-nsq v1.1.0
+	github.com/segmentio/kafka-go v0.4.39
+	github.com/valyala/fasthttp v1.47.0
+	github.com/vmihailenco/msgpack v4.0.4+incompatible
+	golang.org/x/sync v0.1.0
+	google.golang.org/protobuf v1.28.1
 )
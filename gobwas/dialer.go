@@ -14,8 +14,15 @@ var DefaultDialer = Dialer(gobwas.DefaultDialer)
 // Dialer is a `neffos.Dialer` type for the gobwas/ws subprotocol implementation.
 // Should be used on `Dial` to create a new client/client-side connection.
 // To send headers to the server set the dialer's `Header` field to a `gobwas.HandshakeHeaderHTTP`.
+// The unix domain socket urls are dialed through their socket file, see `neffos.ParseUnixURL`.
 func Dialer(dialer gobwas.Dialer) neffos.Dialer {
 	return func(ctx context.Context, url string) (neffos.Socket, error) {
+		dialer := dialer
+		if socketPath, wsURL, ok := neffos.ParseUnixURL(url); ok {
+			url = wsURL
+			dialer.NetDial = neffos.UnixNetDial(socketPath)
+		}
+
 		underline, _, _, err := dialer.Dial(ctx, url)
 		if err != nil {
 			return nil, err
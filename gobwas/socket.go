@@ -1,6 +1,7 @@
 package gobwas
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
 	"net"
@@ -22,7 +23,13 @@ type Socket struct {
 
 	reader         *wsutil.Reader
 	controlHandler wsutil.FrameHandlerFunc
-	state          gobwas.State
+	// see `SetControlObserver`.
+	controlObserver func(typ neffos.ControlFrameType, payload []byte)
+	state           gobwas.State
+	// see `SetReadLimit`.
+	readLimit int64
+	// see `SetWriteBuffer`.
+	buffer *neffos.WriteBuffer
 
 	mu sync.Mutex
 }
@@ -33,9 +40,14 @@ func newSocket(underline net.Conn, request *http.Request, client bool) *Socket {
 		state = gobwas.StateClientSide
 	}
 
-	controlHandler := wsutil.ControlFrameHandler(underline, state)
+	s := &Socket{
+		UnderlyingConn: underline,
+		request:        request,
+		state:          state,
+		controlHandler: wsutil.ControlFrameHandler(underline, state),
+	}
 
-	reader := &wsutil.Reader{
+	s.reader = &wsutil.Reader{
 		Source:          underline,
 		State:           state,
 		CheckUTF8:       true,
@@ -44,18 +56,55 @@ func newSocket(underline net.Conn, request *http.Request, client bool) *Socket {
 		// be received between text/binary continuation frames.
 		// Read `gobwas/wsutil/reader#NextReader`.
 		//
-		OnIntermediate: controlHandler,
+		OnIntermediate: s.handleControl,
 	}
 
-	return &Socket{
-		UnderlyingConn: underline,
-		request:        request,
-		state:          state,
-		reader:         reader,
-		controlHandler: controlHandler,
+	return s
+}
+
+// handleControl replies to a control frame and reports it to the observer, if any.
+func (s *Socket) handleControl(hdr gobwas.Header, r io.Reader) error {
+	if s.controlObserver == nil {
+		s.mu.Lock()
+		err := s.controlHandler(hdr, r)
+		s.mu.Unlock()
+		return err
+	}
+
+	payload := make([]byte, hdr.Length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+
+	// the reply is written under the lock of the writes.
+	s.mu.Lock()
+	err := s.controlHandler(hdr, bytes.NewReader(payload))
+	s.mu.Unlock()
+
+	s.controlObserver(controlFrameType(hdr.OpCode), payload)
+	return err
+}
+
+func controlFrameType(op gobwas.OpCode) neffos.ControlFrameType {
+	switch op {
+	case gobwas.OpPing:
+		return neffos.PingControlFrame
+	case gobwas.OpPong:
+		return neffos.PongControlFrame
+	case gobwas.OpClose:
+		return neffos.CloseControlFrame
+	default:
+		return 0
 	}
 }
 
+// SetControlObserver registers the "observer" of the received control frames,
+// it completes the `neffos.SocketControlObserver` interface.
+// The control frames are handled as usual, i.e a ping is replied with a pong, before the "observer" is called.
+func (s *Socket) SetControlObserver(observer func(typ neffos.ControlFrameType, payload []byte)) {
+	s.controlObserver = observer
+}
+
 // NetConn returns the underline net connection.
 func (s *Socket) NetConn() net.Conn {
 	return s.UnderlyingConn
@@ -67,6 +116,8 @@ func (s *Socket) Request() *http.Request {
 }
 
 // ReadData reads binary or text messages from the remote connection.
+// The frames of a fragmented message are reassembled, the control frames are answered,
+// even between them, and a close frame is replied and returned as a `neffos.CloseError`.
 func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
 	for {
 		if timeout > 0 {
@@ -75,56 +126,100 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, er
 
 		hdr, err := s.reader.NextFrame()
 		if err != nil {
-			if err == io.EOF {
-				return nil, 0, io.ErrUnexpectedEOF // for io.ReadAll to return an error if connection remotely closed.
-			}
-			return nil, 0, err
-		}
-
-		if hdr.OpCode == gobwas.OpClose {
-			return nil, 0, io.ErrUnexpectedEOF // for io.ReadAll to return an error if connection remotely closed.
+			return nil, 0, readError(err)
 		}
 
 		if hdr.OpCode.IsControl() {
-			err = s.controlHandler(hdr, s.reader)
+			// the close frame ends with a wsutil.ClosedError.
+			err = s.handleControl(hdr, s.reader)
 			if err != nil {
-				return nil, 0, err
+				return nil, 0, readError(err)
 			}
 			continue
 		}
 
-		if hdr.OpCode&gobwas.OpBinary == 0 && hdr.OpCode&gobwas.OpText == 0 {
+		if hdr.OpCode != gobwas.OpText && hdr.OpCode != gobwas.OpBinary {
+			// a continuation frame without its first one.
 			err = s.reader.Discard()
 			if err != nil {
-				return nil, 0, err
+				return nil, 0, readError(err)
 			}
 			continue
 		}
 
-		b, err := ioutil.ReadAll(s.reader)
+		if s.readLimit > 0 && hdr.Length > s.readLimit {
+			return nil, 0, neffos.ErrMessageTooLarge
+		}
+
+		// reads until the final frame of a fragmented message,
+		// the intermediate control frames are handled by the `handleControl`.
+		var r io.Reader = s.reader
+		if s.readLimit > 0 {
+			r = io.LimitReader(r, s.readLimit+1)
+		}
+
+		b, err := ioutil.ReadAll(r)
 		if err != nil {
-			return nil, 0, err
+			return nil, 0, readError(err)
+		}
+
+		if s.readLimit > 0 && int64(len(b)) > s.readLimit {
+			return nil, 0, neffos.ErrMessageTooLarge
 		}
 
 		return b, neffos.MessageType(hdr.OpCode), nil
 	}
+}
+
+func readError(err error) error {
+	if closedErr, ok := err.(wsutil.ClosedError); ok {
+		return neffos.NewCloseError(int(closedErr.Code), closedErr)
+	}
+
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF // for io.ReadAll to return an error if connection remotely closed.
+	}
+
+	return err
+}
+
+// SetReadLimit sets the maximum size in bytes for a message read from the remote connection,
+// it completes the `neffos.SocketReadLimiter` interface.
+func (s *Socket) SetReadLimit(limit int64) {
+	s.readLimit = limit
+}
 
-	// for {
-	// 	if timeout > 0 {
-	// 		s.UnderlyingConn.SetReadDeadline(time.Now().Add(timeout))
-	// 	}
+// SetWriteBuffer enables the buffering of the written messages,
+// it completes the `neffos.SocketWriteBuffer` interface.
+func (s *Socket) SetWriteBuffer(size int, maxLatency time.Duration) {
+	s.mu.Lock()
+	s.buffer = neffos.NewWriteBuffer(s.UnderlyingConn, &s.mu, size, maxLatency)
+	s.mu.Unlock()
+}
 
-	// 	b, code, err := wsutil.ReadData(s.UnderlyingConn, s.state)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
+// Flush writes the buffered messages to the remote connection,
+// it completes the `neffos.SocketFlusher` interface.
+func (s *Socket) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// 	if code != defaultOp {
-	// 		continue
-	// 	}
+	if s.buffer == nil {
+		return nil
+	}
 
-	// 	return b, nil
-	// }
+	return s.buffer.Flush()
+}
+
+// WriteClose sends a close message with the "code" and "reason" to the remote connection,
+// it completes the `neffos.SocketCloser` interface.
+// The buffered messages, if any, are written before it.
+func (s *Socket) WriteClose(code int, reason string, timeout time.Duration) error {
+	body := gobwas.NewCloseFrameBody(gobwas.StatusCode(code), reason)
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	return s.write(body, gobwas.OpClose, timeout)
 }
 
 // WriteBinary sends a binary message to the remote connection.
@@ -139,6 +234,15 @@ func (s *Socket) WriteText(body []byte, timeout time.Duration) error {
 
 func (s *Socket) write(body []byte, op gobwas.OpCode, timeout time.Duration) error {
 	s.mu.Lock()
+	if s.buffer != nil && op != gobwas.OpClose {
+		err := wsutil.WriteMessage(s.buffer, s.state, op, body)
+		if err == nil {
+			err = s.buffer.Commit(timeout)
+		}
+		s.mu.Unlock()
+		return err
+	}
+
 	if timeout > 0 {
 		s.UnderlyingConn.SetWriteDeadline(time.Now().Add(timeout))
 	}
@@ -14,8 +14,25 @@ var DefaultDialer = Dialer(gorilla.DefaultDialer, make(http.Header))
 
 // Dialer is a `neffos.Dialer` type for the gorilla/websocket subprotocol implementation.
 // Should be used on `Dial` to create a new client/client-side connection.
+// The "dialer" is used as it is, i.e its `EnableCompression`, `ReadBufferSize`, `WriteBufferSize`,
+// `WriteBufferPool` and `TLSClientConfig` fields, a nil one has the default values of the `gorilla.DefaultDialer`.
+// The "requestHeader" is sent to the server, it can be nil.
+// The unix domain socket urls are dialed through their socket file, see `neffos.ParseUnixURL`.
 func Dialer(dialer *gorilla.Dialer, requestHeader http.Header) neffos.Dialer {
 	return func(ctx context.Context, url string) (neffos.Socket, error) {
+		if socketPath, wsURL, ok := neffos.ParseUnixURL(url); ok {
+			unixDialer := *gorilla.DefaultDialer
+			if dialer != nil {
+				unixDialer = *dialer
+			}
+			unixDialer.NetDial = nil
+			unixDialer.NetDialContext = neffos.UnixNetDial(socketPath)
+			unixDialer.Proxy = nil
+
+			url = wsURL
+			dialer = &unixDialer
+		}
+
 		underline, _, err := dialer.DialContext(ctx, url, requestHeader)
 		if err != nil {
 			return nil, err
@@ -1,6 +1,7 @@
 package gorilla
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"sync"
@@ -49,6 +50,9 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, er
 
 		opCode, data, err := s.UnderlyingConn.ReadMessage()
 		if err != nil {
+			if err == gorilla.ErrReadLimit {
+				return nil, 0, neffos.ErrMessageTooLarge
+			}
 			return nil, 0, err
 		}
 
@@ -61,6 +65,53 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, er
 	}
 }
 
+// SetReadLimit sets the maximum size in bytes for a message read from the remote connection,
+// it completes the `neffos.SocketReadLimiter` interface.
+func (s *Socket) SetReadLimit(limit int64) {
+	s.UnderlyingConn.SetReadLimit(limit)
+}
+
+// SetControlObserver registers the "observer" of the received control frames,
+// it completes the `neffos.SocketControlObserver` interface.
+// The default handlers of the gorilla connection, i.e the pong reply to a ping, are called first.
+func (s *Socket) SetControlObserver(observer func(typ neffos.ControlFrameType, payload []byte)) {
+	pingHandler := s.UnderlyingConn.PingHandler()
+	s.UnderlyingConn.SetPingHandler(func(appData string) error {
+		err := pingHandler(appData)
+		observer(neffos.PingControlFrame, []byte(appData))
+		return err
+	})
+
+	pongHandler := s.UnderlyingConn.PongHandler()
+	s.UnderlyingConn.SetPongHandler(func(appData string) error {
+		err := pongHandler(appData)
+		observer(neffos.PongControlFrame, []byte(appData))
+		return err
+	})
+
+	closeHandler := s.UnderlyingConn.CloseHandler()
+	s.UnderlyingConn.SetCloseHandler(func(code int, text string) error {
+		err := closeHandler(code, text)
+		observer(neffos.CloseControlFrame, gorilla.FormatCloseMessage(code, text))
+		return err
+	})
+}
+
+// WriteClose sends a close message with the "code" and "reason" to the remote connection,
+// it completes the `neffos.SocketCloser` interface.
+func (s *Socket) WriteClose(code int, reason string, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	s.mu.Lock()
+	err := s.UnderlyingConn.WriteControl(gorilla.CloseMessage, gorilla.FormatCloseMessage(code, reason), deadline)
+	s.mu.Unlock()
+
+	return err
+}
+
 // WriteBinary sends a binary message to the remote connection.
 func (s *Socket) WriteBinary(body []byte, timeout time.Duration) error {
 	return s.write(body, gorilla.BinaryMessage, timeout)
@@ -71,6 +122,36 @@ func (s *Socket) WriteText(body []byte, timeout time.Duration) error {
 	return s.write(body, gorilla.TextMessage, timeout)
 }
 
+// PrepareMessage prepares the "data" of a text or binary message,
+// it completes the `neffos.SocketPreparedWriter` interface.
+// The message is compressed once for all of the connections which write it.
+func (s *Socket) PrepareMessage(data []byte, binary bool) (interface{}, error) {
+	opCode := gorilla.TextMessage
+	if binary {
+		opCode = gorilla.BinaryMessage
+	}
+
+	return gorilla.NewPreparedMessage(opCode, data)
+}
+
+// WritePrepared sends a message prepared by the `PrepareMessage` to the remote connection.
+func (s *Socket) WritePrepared(pm interface{}, timeout time.Duration) error {
+	prepared, ok := pm.(*gorilla.PreparedMessage)
+	if !ok {
+		return fmt.Errorf("gorilla: unexpected prepared message: %T", pm)
+	}
+
+	if timeout > 0 {
+		s.UnderlyingConn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	s.mu.Lock()
+	err := s.UnderlyingConn.WritePreparedMessage(prepared)
+	s.mu.Unlock()
+
+	return err
+}
+
 func (s *Socket) write(body []byte, opCode int, timeout time.Duration) error {
 	if timeout > 0 {
 		s.UnderlyingConn.SetWriteDeadline(time.Now().Add(timeout))
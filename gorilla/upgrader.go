@@ -13,6 +13,9 @@ var DefaultUpgrader = Upgrader(gorilla.Upgrader{})
 
 // Upgrader is a `neffos.Upgrader` type for the gorilla/websocket subprotocol implementation.
 // Should be used on `New` to construct the neffos server.
+// The "upgrader" is used as it is, i.e its `EnableCompression`, `ReadBufferSize`, `WriteBufferSize`,
+// `WriteBufferPool`, `CheckOrigin` and `Error` fields, its zero value is the `DefaultUpgrader` one.
+// When the permessage-deflate is negotiated the text and binary messages of the `Socket` are compressed.
 func Upgrader(upgrader gorilla.Upgrader) neffos.Upgrader {
 	return func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
 		underline, err := upgrader.Upgrade(w, r, w.Header())
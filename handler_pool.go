@@ -0,0 +1,249 @@
+package neffos
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// HandlerOrdering decides the order which the callbacks of a `HandlerPool` are fired in.
+type HandlerOrdering uint8
+
+const (
+	// OrderPerConnection fires the callbacks of the messages of a connection one by one, in the order they were received,
+	// each connection is assigned to a worker. It's the default ordering.
+	OrderPerConnection HandlerOrdering = iota
+	// Concurrent fires the callbacks on any idle worker,
+	// the messages of a connection may be handled in parallel and out of order.
+	Concurrent
+)
+
+// OverflowPolicy decides what happens to an incoming message when the queue of a `HandlerPool` is full.
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock makes the connection wait for a free slot before it reads its next message.
+	// It's the default policy.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowReject drops the message, the `ErrHandlerPoolFull` is sent back to the remote side
+	// (an `Ask` returns it) and it fires the `Server#OnError`.
+	OverflowReject
+	// OverflowInline fires the callback on the connection's reader goroutine, as if it was not asynchronous.
+	// It would fire it before the queued messages of its connection, so it's valid on the `Concurrent` ordering only,
+	// the `OrderPerConnection` ordering falls back to the `OverflowBlock` policy.
+	OverflowInline
+)
+
+// HandlerPoolOptions are the options of a `HandlerPool`, see `NewHandlerPool`.
+type HandlerPoolOptions struct {
+	// Workers is the number of the goroutines which fire the callbacks.
+	// Defaults to the number of the CPUs.
+	Workers int
+	// QueueSize is the number of the messages which can wait for a worker,
+	// each worker has its own queue of that size on the `OrderPerConnection` ordering.
+	// Defaults to 1024.
+	QueueSize int
+	// Ordering decides the order of the callbacks. Defaults to `OrderPerConnection`.
+	Ordering HandlerOrdering
+	// Overflow decides what happens to a message when the queue is full. Defaults to `OverflowBlock`.
+	// The `OverflowInline` is replaced by the `OverflowBlock` on the `OrderPerConnection` ordering.
+	Overflow OverflowPolicy
+}
+
+// HandlerPoolStats is a snapshot of the counters of one or more `HandlerPool`, see `Metrics`.
+type HandlerPoolStats struct {
+	// Workers is the number of the workers.
+	Workers int
+	// QueueSize is the capacity of the queues.
+	QueueSize int
+	// QueueDepth is the number of the messages which wait for a worker, at the time of the snapshot.
+	QueueDepth uint64
+	// Handled is the number of the callbacks which were fired by the workers.
+	Handled uint64
+	// Rejected is the number of the messages which were dropped because of the `OverflowReject` policy.
+	Rejected uint64
+	// Inline is the number of the callbacks which were fired on the reader goroutine
+	// because of the `OverflowInline` policy or a closed pool.
+	Inline uint64
+}
+
+func (st HandlerPoolStats) add(other HandlerPoolStats) HandlerPoolStats {
+	st.Workers += other.Workers
+	st.QueueSize += other.QueueSize
+	st.QueueDepth += other.QueueDepth
+	st.Handled += other.Handled
+	st.Rejected += other.Rejected
+	st.Inline += other.Inline
+	return st
+}
+
+// HandlerPool is a bounded pool of goroutines which fire the callbacks of the asynchronous events,
+// so a slow callback does not delay the next messages of its connection, see `Events#Async`.
+// A pool can be shared by many namespaces, servers and clients.
+type HandlerPool struct {
+	opts HandlerPoolOptions
+	// a queue per worker on the `OrderPerConnection`, a shared one on the `Concurrent` ordering.
+	queues []chan func()
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// all fields below are modified through the atomic package.
+	depth    uint64
+	handled  uint64
+	rejected uint64
+	inline   uint64
+}
+
+// NewHandlerPool returns a new `HandlerPool` and starts its workers.
+// Register it to the events through the `Events#Async` and stop it through its `Close`
+// when it's not used anymore.
+func NewHandlerPool(opts HandlerPoolOptions) *HandlerPool {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+
+	if opts.Overflow == OverflowInline && opts.Ordering == OrderPerConnection {
+		// an inline callback would overtake the queued ones of its connection.
+		opts.Overflow = OverflowBlock
+	}
+
+	p := &HandlerPool{
+		opts:   opts,
+		closed: make(chan struct{}),
+	}
+
+	if opts.Ordering == Concurrent {
+		queue := make(chan func(), opts.QueueSize)
+		p.queues = []chan func(){queue}
+		for i := 0; i < opts.Workers; i++ {
+			go p.work(queue)
+		}
+	} else {
+		p.queues = make([]chan func(), opts.Workers)
+		for i := range p.queues {
+			p.queues[i] = make(chan func(), opts.QueueSize)
+			go p.work(p.queues[i])
+		}
+	}
+
+	return p
+}
+
+func (p *HandlerPool) work(queue chan func()) {
+	for {
+		select {
+		case <-p.closed:
+			return
+		case job := <-queue:
+			atomic.AddUint64(&p.depth, ^uint64(0))
+			atomic.AddUint64(&p.handled, 1)
+			job()
+		}
+	}
+}
+
+// queue returns the queue of the "c" connection.
+func (p *HandlerPool) queue(c *Conn) chan func() {
+	if len(p.queues) == 1 {
+		return p.queues[0]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(c.ID()))
+	return p.queues[h.Sum32()%uint32(len(p.queues))]
+}
+
+// submit queues the "job" of the "c" connection, it fires it on the caller's goroutine
+// when the pool is closed or full with the `OverflowInline` policy (`Concurrent` ordering only).
+func (p *HandlerPool) submit(c *Conn, job func()) error {
+	select {
+	case <-p.closed:
+		p.runInline(job)
+		return nil
+	default:
+	}
+
+	queue := p.queue(c)
+	atomic.AddUint64(&p.depth, 1)
+
+	select {
+	case queue <- job:
+		return nil
+	default:
+	}
+
+	switch p.opts.Overflow {
+	case OverflowReject:
+		atomic.AddUint64(&p.depth, ^uint64(0))
+		atomic.AddUint64(&p.rejected, 1)
+		return ErrHandlerPoolFull
+	case OverflowInline:
+		atomic.AddUint64(&p.depth, ^uint64(0))
+		p.runInline(job)
+		return nil
+	}
+
+	select {
+	case queue <- job:
+		return nil
+	case <-p.closed:
+		atomic.AddUint64(&p.depth, ^uint64(0))
+		p.runInline(job)
+		return nil
+	case <-c.closeCh:
+		atomic.AddUint64(&p.depth, ^uint64(0))
+		return ErrWrite
+	}
+}
+
+func (p *HandlerPool) runInline(job func()) {
+	atomic.AddUint64(&p.inline, 1)
+	job()
+}
+
+// Stats returns a snapshot of the counters of the pool.
+func (p *HandlerPool) Stats() HandlerPoolStats {
+	return HandlerPoolStats{
+		Workers:    p.opts.Workers,
+		QueueSize:  len(p.queues) * p.opts.QueueSize,
+		QueueDepth: atomic.LoadUint64(&p.depth),
+		Handled:    atomic.LoadUint64(&p.handled),
+		Rejected:   atomic.LoadUint64(&p.rejected),
+		Inline:     atomic.LoadUint64(&p.inline),
+	}
+}
+
+// Close stops the workers, the messages which wait on the queue are discarded
+// and the next ones are handled on the reader goroutine of their connection.
+func (p *HandlerPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+}
+
+// handlerPoolStats returns the sum of the stats of the pools of the "namespaces", a pool is counted once.
+func handlerPoolStats(namespaces Namespaces) (stats HandlerPoolStats) {
+	seen := make(map[*HandlerPool]struct{})
+	for _, events := range namespaces {
+		cfg := events.config()
+		if cfg == nil {
+			continue
+		}
+
+		for _, p := range cfg.pools {
+			if _, ok := seen[p]; ok {
+				continue
+			}
+			seen[p] = struct{}{}
+			stats = stats.add(p.Stats())
+		}
+	}
+
+	return
+}
@@ -0,0 +1,184 @@
+package neffos
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestPoolConn returns a connection with just the fields which the handler pool uses.
+func newTestPoolConn(id string) *Conn {
+	c := &Conn{closeCh: make(chan struct{})}
+	c.setID(id)
+	return c
+}
+
+func TestHandlerPoolOverflow(t *testing.T) {
+	c := newTestPoolConn("conn")
+
+	tests := []struct {
+		overflow       OverflowPolicy
+		expectedErr    error
+		expectedInline uint64
+	}{
+		{OverflowReject, ErrHandlerPoolFull, 0},
+		{OverflowInline, nil, 1},
+	}
+
+	for _, tt := range tests {
+		p := NewHandlerPool(HandlerPoolOptions{Workers: 1, QueueSize: 1, Ordering: Concurrent, Overflow: tt.overflow})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		started, release := make(chan struct{}), make(chan struct{})
+		// the worker is busy.
+		if err := p.submit(c, func() { close(started); <-release; wg.Done() }); err != nil {
+			t.Fatal(err)
+		}
+		<-started
+		// the queue is full.
+		if err := p.submit(c, func() { wg.Done() }); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, got := uint64(1), p.Stats().QueueDepth; expected != got {
+			t.Fatalf("[%d] expected queue depth: %d but got: %d", tt.overflow, expected, got)
+		}
+
+		inline := false
+		if err := p.submit(c, func() { inline = true }); err != tt.expectedErr {
+			t.Fatalf("[%d] expected error: %v but got: %v", tt.overflow, tt.expectedErr, err)
+		}
+
+		if inline != (tt.expectedInline == 1) {
+			t.Fatalf("[%d] expected the job to run inline: %v", tt.overflow, tt.expectedInline == 1)
+		}
+
+		close(release)
+		wg.Wait()
+
+		stats := p.Stats()
+		if tt.overflow == OverflowReject && stats.Rejected != 1 {
+			t.Fatalf("expected a rejected job but got: %d", stats.Rejected)
+		}
+
+		if expected, got := tt.expectedInline, stats.Inline; expected != got {
+			t.Fatalf("[%d] expected inline jobs: %d but got: %d", tt.overflow, expected, got)
+		}
+
+		p.Close()
+	}
+}
+
+func TestHandlerPoolInlineOrderPerConnection(t *testing.T) {
+	c := newTestPoolConn("conn")
+	p := NewHandlerPool(HandlerPoolOptions{Workers: 1, QueueSize: 1, Overflow: OverflowInline})
+	defer p.Close()
+
+	var (
+		mu    sync.Mutex
+		order []int
+	)
+	record := func(i int) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	// the worker is busy.
+	if err := p.submit(c, func() { close(started); <-release; record(1)() }); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// the queue is full.
+	if err := p.submit(c, record(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// it waits for a free slot instead of overtaking the queued ones.
+	submitted := make(chan error)
+	go func() { submitted <- p.submit(c, record(3)) }()
+
+	select {
+	case <-submitted:
+		t.Fatalf("expected the submit to block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		mu.Lock()
+		n := len(order)
+		mu.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected all the jobs to be fired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if expected, got := []int{1, 2, 3}, order; !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected order: %v but got: %v", expected, got)
+	}
+
+	if inline := p.Stats().Inline; inline != 0 {
+		t.Fatalf("expected no inline jobs but got: %d", inline)
+	}
+}
+
+func TestHandlerPoolOrderPerConnection(t *testing.T) {
+	p := NewHandlerPool(HandlerPoolOptions{Workers: 4})
+	defer p.Close()
+
+	conns := []*Conn{
+		newTestPoolConn("a"),
+		newTestPoolConn("b"),
+		newTestPoolConn("c"),
+	}
+
+	var (
+		mu  sync.Mutex
+		got = make(map[*Conn][]int)
+		wg  sync.WaitGroup
+	)
+
+	for i := 0; i < 100; i++ {
+		for _, c := range conns {
+			i, c := i, c
+			wg.Add(1)
+			if err := p.submit(c, func() {
+				mu.Lock()
+				got[c] = append(got[c], i)
+				mu.Unlock()
+				wg.Done()
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	wg.Wait()
+
+	for _, c := range conns {
+		for i, v := range got[c] {
+			if i != v {
+				t.Fatalf("[%s] expected the jobs in order but got: %v", c.id, got[c])
+			}
+		}
+	}
+
+	if expected, got := uint64(300), p.Stats().Handled; expected != got {
+		t.Fatalf("expected handled jobs: %d but got: %d", expected, got)
+	}
+}
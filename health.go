@@ -0,0 +1,93 @@
+package neffos
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// HealthReport is a snapshot of the health of a server, see `Server.Health`.
+type HealthReport struct {
+	// Ready reports whether the server can serve new connections:
+	// it's not shutting down and its stackexchange, if any, is healthy.
+	Ready bool `json:"ready"`
+	// ShuttingDown is true after the `Server.Close` or the `Server.Shutdown`.
+	ShuttingDown bool `json:"shuttingDown"`
+	// Connections is the number of the connections of the server, see `Server.GetTotalConnections`.
+	Connections uint64 `json:"connections"`
+	// StackExchange is the health of the stackexchange, it's nil if the server does not use one.
+	StackExchange *StackExchangeHealthReport `json:"stackExchange,omitempty"`
+}
+
+// StackExchangeHealthReport is the health of the stackexchange of a server, see `HealthReport`.
+type StackExchangeHealthReport struct {
+	// Healthy reports whether the stackexchange has no failure which is not recovered yet,
+	// its ping succeeded and its publish queue, if any, is not full.
+	Healthy bool `json:"healthy"`
+	// Failed is true if a `StackExchangeErrorReporter` has failed and not recovered yet,
+	// see `Server.StackExchangeHealthy`.
+	Failed bool `json:"failed"`
+	// PingError is the error of the `PingableStackExchange`, if any.
+	PingError string `json:"pingError,omitempty"`
+	// QueueDepth is the number of the messages which wait on the publish queue,
+	// see `Server.SetStackExchangeQueue`.
+	QueueDepth int `json:"queueDepth"`
+	// QueueSize is the capacity of the publish queue, zero if there is no queue.
+	QueueSize int `json:"queueSize"`
+}
+
+// Health returns the health of the server, it can be used on readiness probes, see `HealthHandler` too.
+// The stackexchange is pinged if it's a `PingableStackExchange`, the "ctx" bounds that round trip.
+// A server which uses a stackexchange is not ready when the stackexchange is not reachable,
+// its connections would not receive the messages of the other server instances.
+func (s *Server) Health(ctx context.Context) HealthReport {
+	report := HealthReport{
+		ShuttingDown: atomic.LoadUint32(&s.closed) == 1,
+		Connections:  s.GetTotalConnections(),
+	}
+
+	if s.usesStackExchange() {
+		exc := &StackExchangeHealthReport{Failed: !s.StackExchangeHealthy()}
+
+		if pinger, ok := s.StackExchange.(PingableStackExchange); ok && s.stackExchangeOpen() {
+			if err := pinger.Ping(ctx); err != nil {
+				exc.PingError = err.Error()
+			}
+		}
+
+		if q := s.stackExchangeQueue; q != nil {
+			exc.QueueDepth = int(atomic.LoadUint64(&q.counters.exchangeQueueDepth))
+			exc.QueueSize = q.size
+		}
+
+		exc.Healthy = !exc.Failed && exc.PingError == "" && (exc.QueueSize == 0 || exc.QueueDepth < exc.QueueSize)
+		report.StackExchange = exc
+	}
+
+	report.Ready = !report.ShuttingDown && (report.StackExchange == nil || report.StackExchange.Healthy)
+	return report
+}
+
+// HealthHandler returns a `http.Handler` which writes the `Health` of the server as JSON,
+// with a status code of 200 when the server is ready and 503 otherwise. The request's context bounds the ping.
+//
+// Usage:
+//
+//	http.Handle("/readyz", server.HealthHandler())
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := s.Health(r.Context())
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		if report.Ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(report)
+	})
+}
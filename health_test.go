@@ -0,0 +1,94 @@
+package neffos_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+)
+
+// pingableStackExchange fails its `Ping` with the err, if any.
+type pingableStackExchange struct {
+	*neffos.InMemoryStackExchange
+
+	mu  sync.Mutex
+	err error
+}
+
+func (exc *pingableStackExchange) Ping(ctx context.Context) error {
+	exc.mu.Lock()
+	defer exc.mu.Unlock()
+	return exc.err
+}
+
+func (exc *pingableStackExchange) setErr(err error) {
+	exc.mu.Lock()
+	exc.err = err
+	exc.mu.Unlock()
+}
+
+func TestServerHealth(t *testing.T) {
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{"default": neffos.Events{}})
+	defer server.Close()
+
+	if report := server.Health(context.TODO()); !report.Ready || report.StackExchange != nil {
+		t.Fatalf("expected a ready server without a stackexchange report but got: %#+v", report)
+	}
+
+	exc := &pingableStackExchange{InMemoryStackExchange: neffos.NewInMemoryStackExchange()}
+	if err := server.UseStackExchange(neffos.InstrumentStackExchange(exc)); err != nil {
+		t.Fatal(err)
+	}
+	server.SetStackExchangeQueue(8)
+
+	expect := func(expectedStatus int, expectedPingError string) neffos.HealthReport {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		server.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if rec.Code != expectedStatus {
+			t.Fatalf("expected status code: %d but got: %d", expectedStatus, rec.Code)
+		}
+
+		var report neffos.HealthReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+
+		if report.StackExchange == nil {
+			t.Fatalf("expected a stackexchange report")
+		}
+
+		if got := report.StackExchange.PingError; expectedPingError != got {
+			t.Fatalf("expected ping error: %q but got: %q", expectedPingError, got)
+		}
+
+		if expected, got := 8, report.StackExchange.QueueSize; expected != got {
+			t.Fatalf("expected queue size: %d but got: %d", expected, got)
+		}
+
+		return report
+	}
+
+	expect(http.StatusOK, "")
+
+	exc.setErr(errors.New("unreachable"))
+	if report := expect(http.StatusServiceUnavailable, "unreachable"); report.ShuttingDown || report.StackExchange.Healthy {
+		t.Fatalf("expected an unhealthy stackexchange of a running server but got: %#+v", report.StackExchange)
+	}
+
+	exc.setErr(nil)
+	expect(http.StatusOK, "")
+
+	server.Close()
+	if report := expect(http.StatusServiceUnavailable, ""); !report.ShuttingDown {
+		t.Fatalf("expected a shutting down server")
+	}
+}
@@ -0,0 +1,256 @@
+// Package loadtest provides a swarm of neffos clients for the capacity planning of a deployment.
+// A `Swarm` dials many connections with a controlled ramp up, runs a script on each one
+// and aggregates their latencies, throughput and failures into a `Report`.
+//
+// Usage:
+//
+//	swarm := &loadtest.Swarm{URL: "ws://localhost:8080/echo", Connections: 10000, RampUp: time.Minute, Namespaces: []string{"default"}}
+//	swarm.Script = func(i int, c *neffos.Conn) error {
+//		for j := 0; j < 100; j++ {
+//			swarm.Emit(c.Namespace("default"), "chat", []byte("hello"))
+//			time.Sleep(time.Second)
+//		}
+//		return nil
+//	}
+//	report, err := swarm.Run(ctx)
+//	fmt.Println(report)
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	websocket "github.com/gorilla/websocket"
+)
+
+// The per connection defaults of the `Swarm`, small enough for tens of thousands of connections on one host.
+const (
+	// DefaultBufferSize is the size of the read and write buffers of the `DefaultDialer`.
+	DefaultBufferSize = 1024
+	// DefaultMaxMessageSize is the default `Swarm.MaxMessageSize`.
+	DefaultMaxMessageSize = 64 * 1024
+)
+
+// DefaultDialer is the default `Swarm.Dialer`, a gorilla websocket dialer with small buffers
+// and a shared write buffer pool, so the idle connections do not hold a write buffer.
+var DefaultDialer = gorilla.Dialer(&websocket.Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 45 * time.Second,
+	ReadBufferSize:   DefaultBufferSize,
+	WriteBufferSize:  DefaultBufferSize,
+	WriteBufferPool:  new(sync.Pool),
+}, nil)
+
+// The disconnect reasons of a `Report`.
+const (
+	// DisconnectCompleted is the reason of the connections which their script returned without an error.
+	DisconnectCompleted = "completed"
+	// DisconnectScriptError is the reason of the connections which their script returned an error.
+	DisconnectScriptError = "script error"
+	// DisconnectRemote is the reason of the connections which were closed before their script returned,
+	// i.e by the server or by a network error.
+	DisconnectRemote = "remote"
+	// DisconnectCanceled is the reason of the connections which were closed by the cancellation of the `Swarm#Run`.
+	DisconnectCanceled = "canceled"
+)
+
+// Swarm dials a number of client connections to a server and runs a script on each one,
+// see its `Run` method.
+type Swarm struct {
+	// URL is the url of the server, i.e "ws://localhost:8080/echo".
+	URL string
+	// Dialer dials the connections, defaults to the `DefaultDialer`.
+	Dialer neffos.Dialer
+	// Handler is the handler of the connections, defaults to
+	// the `neffos.Namespaces` of the `Namespaces` with no events.
+	Handler neffos.ConnHandler
+	// DialOptions are passed to the `neffos.Dial` of each connection.
+	DialOptions []neffos.DialOption
+	// MaxMessageSize is the limit of the size of the incoming messages of each connection,
+	// see `neffos.WithMaxMessageSize`. Defaults to the `DefaultMaxMessageSize`, a negative one disables it.
+	MaxMessageSize int64
+
+	// Connections is the number of the connections to dial.
+	Connections int
+	// RampUp is the duration in which all the connections are dialed, at a steady rate.
+	// Defaults to zero, all connections are dialed at once.
+	RampUp time.Duration
+	// Namespaces are the namespaces which each connection is connected to, before its script.
+	Namespaces []string
+	// Script runs on each connected connection, its "i" is the index of the connection,
+	// the connection is closed when it returns. It should return when the context of the `Run` is canceled,
+	// the connections are closed on cancellation too.
+	// A nil one keeps the connections open until the cancellation.
+	//
+	// Send the messages through the `Swarm#Emit` and `Swarm#Ask` to count them in the report.
+	Script func(i int, c *neffos.Conn) error
+
+	stats *stats
+	conns sync.Map // map[*neffos.Conn]struct{}
+}
+
+// Run dials the connections and runs their script, it blocks until all of them are closed
+// and returns their report. When the "ctx" is canceled no more connections are dialed
+// and the open ones are closed.
+func (s *Swarm) Run(ctx context.Context) (*Report, error) {
+	if s.Connections <= 0 {
+		return nil, errors.New("loadtest: no connections")
+	}
+
+	dialer := s.Dialer
+	if dialer == nil {
+		dialer = DefaultDialer
+	}
+
+	handler := s.Handler
+	if handler == nil {
+		namespaces := make(neffos.Namespaces, len(s.Namespaces))
+		for _, namespace := range s.Namespaces {
+			namespaces[namespace] = neffos.Events{}
+		}
+		handler = namespaces
+	}
+
+	dialOptions := s.DialOptions
+	if maxMessageSize := s.MaxMessageSize; maxMessageSize >= 0 {
+		if maxMessageSize == 0 {
+			maxMessageSize = DefaultMaxMessageSize
+		}
+		dialOptions = append([]neffos.DialOption{neffos.WithMaxMessageSize(maxMessageSize)}, dialOptions...)
+	}
+
+	s.stats = newStats()
+	start := time.Now()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// one goroutine closes all the open connections on cancellation.
+	go func() {
+		<-ctx.Done()
+		s.conns.Range(func(key, value interface{}) bool {
+			key.(*neffos.Conn).Close()
+			return true
+		})
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		interval = s.RampUp / time.Duration(s.Connections)
+		ticker   *time.Ticker
+		dialed   int
+	)
+
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+
+dial:
+	for ; dialed < s.Connections; dialed++ {
+		if dialed > 0 && ticker != nil {
+			select {
+			case <-ctx.Done():
+				break dial
+			case <-ticker.C:
+			}
+		} else if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.run(ctx, i, dialer, handler, dialOptions)
+		}(dialed)
+	}
+
+	wg.Wait()
+	return s.stats.report(dialed, time.Since(start)), nil
+}
+
+// run dials the "i" connection and runs its script.
+func (s *Swarm) run(ctx context.Context, i int, dialer neffos.Dialer, handler neffos.ConnHandler, dialOptions []neffos.DialOption) {
+	var conn *neffos.Conn
+	dialOptions = append(dialOptions[:len(dialOptions):len(dialOptions)], func(c *neffos.Conn) {
+		conn = c
+	})
+
+	start := time.Now()
+	client, err := neffos.Dial(ctx, dialer, s.URL, handler, dialOptions...)
+	if err != nil {
+		s.stats.connectFailed(ctx)
+		return
+	}
+	defer client.Close()
+
+	for _, namespace := range s.Namespaces {
+		if _, err = client.Connect(ctx, namespace); err != nil {
+			s.stats.connectFailed(ctx)
+			return
+		}
+	}
+	s.stats.connectLatency.observe(time.Since(start))
+	atomic.AddUint64(&s.stats.connected, 1)
+
+	s.conns.Store(conn, struct{}{})
+	defer s.conns.Delete(conn)
+	if ctx.Err() != nil {
+		// the open connections may be closed before it's stored.
+		conn.Close()
+	}
+
+	if s.Script != nil {
+		err = s.Script(i, conn)
+	} else {
+		<-client.NotifyClose
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		s.stats.disconnected(DisconnectCanceled)
+	case conn.IsClosed():
+		s.stats.disconnected(DisconnectRemote)
+	case err != nil:
+		s.stats.disconnected(DisconnectScriptError)
+	default:
+		s.stats.disconnected(DisconnectCompleted)
+	}
+}
+
+// Emit is like the `neffos.NSConn#Emit` but it's counted in the report of the `Run`.
+func (s *Swarm) Emit(c *neffos.NSConn, event string, body []byte) bool {
+	ok := c.Emit(event, body)
+	if s.stats != nil {
+		if ok {
+			atomic.AddUint64(&s.stats.emits, 1)
+		} else {
+			atomic.AddUint64(&s.stats.emitFailures, 1)
+		}
+	}
+
+	return ok
+}
+
+// Ask is like the `neffos.NSConn#Ask` but its round trip time is observed in the report of the `Run`,
+// the asks which fail, including the ones which the remote side answers with an error, are counted as failures.
+func (s *Swarm) Ask(ctx context.Context, c *neffos.NSConn, event string, body []byte) (neffos.Message, error) {
+	start := time.Now()
+	msg, err := c.Ask(ctx, event, body)
+	if s.stats != nil {
+		if err != nil {
+			atomic.AddUint64(&s.stats.askFailures, 1)
+		} else {
+			s.stats.askRTT.observe(time.Since(start))
+		}
+	}
+
+	return msg, err
+}
@@ -0,0 +1,135 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+func TestSwarm(t *testing.T) {
+	var (
+		namespace = "default"
+		emitted   uint64
+		accepted  uint64
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"emit": func(c *neffos.NSConn, msg neffos.Message) error {
+					atomic.AddUint64(&emitted, 1)
+					return nil
+				},
+				"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	server := neffos.New(nil, events)
+	// every fifth connection is rejected.
+	server.OnConnect = func(c *neffos.Conn) error {
+		if atomic.AddUint64(&accepted, 1)%5 == 0 {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+	defer server.Close()
+
+	swarm := &Swarm{
+		Dialer:      neffos.PipeDialer(server),
+		Connections: 20,
+		RampUp:      20 * time.Millisecond,
+		Namespaces:  []string{namespace},
+	}
+	swarm.Script = func(i int, c *neffos.Conn) error {
+		ns := c.Namespace(namespace)
+		for j := 0; j < 3; j++ {
+			swarm.Emit(ns, "emit", nil)
+		}
+
+		if _, err := swarm.Ask(context.TODO(), ns, "ask", []byte("body")); err != nil {
+			return err
+		}
+
+		if i%2 == 0 {
+			return errors.New("script error")
+		}
+		return nil
+	}
+
+	report, err := swarm.Run(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Dialed != 20 || report.Connected != 16 || report.ConnectFailures != 4 {
+		t.Fatalf("expected 20 dialed, 16 connected and 4 failed connections but got:\n%s", report)
+	}
+	if report.ConnectLatency.Count != 16 {
+		t.Fatalf("expected 16 connect latencies but got:\n%s", report)
+	}
+	if report.Emits != 48 || report.EmitFailures != 0 || report.EmitThroughput <= 0 {
+		t.Fatalf("expected 48 emits but got:\n%s", report)
+	}
+	if report.AskRTT.Count != 16 || report.AskFailures != 0 {
+		t.Fatalf("expected 16 asks but got:\n%s", report)
+	}
+	if completed, failed := report.Disconnects[DisconnectCompleted], report.Disconnects[DisconnectScriptError]; completed+failed != 16 || failed == 0 {
+		t.Fatalf("expected 16 completed or failed scripts but got:\n%s", report)
+	}
+	if expected, got := "connections: dialed=20 connected=16 failed=4", report.String(); !strings.Contains(got, expected) {
+		t.Fatalf("expected the text report to contain: %q but got:\n%s", expected, got)
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err = json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Emits != report.Emits || decoded.AskRTT.Count != report.AskRTT.Count {
+		t.Fatalf("expected the JSON report to be decoded but got: %s", b)
+	}
+
+	// the emits are sent before the asks, on the same connection.
+	if got := atomic.LoadUint64(&emitted); got != 48 {
+		t.Fatalf("expected the server to receive 48 emits but got: %d", got)
+	}
+}
+
+func TestSwarmCancel(t *testing.T) {
+	server := neffos.New(nil, neffos.Namespaces{"default": neffos.Events{}})
+	defer server.Close()
+
+	swarm := &Swarm{
+		Dialer:      neffos.PipeDialer(server),
+		Connections: 100,
+		RampUp:      time.Second,
+		Namespaces:  []string{"default"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	report, err := swarm.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Dialed == 0 || report.Dialed == 100 {
+		t.Fatalf("expected the ramp up to be canceled but got:\n%s", report)
+	}
+	if report.ConnectFailures != 0 || report.Disconnects[DisconnectCanceled] != report.Connected {
+		t.Fatalf("expected all connections to be closed by the cancellation but got:\n%s", report)
+	}
+	if report.Duration >= time.Second {
+		t.Fatalf("expected the run to return on cancellation but it took: %s", report.Duration)
+	}
+}
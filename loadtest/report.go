@@ -0,0 +1,194 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+// Report is the result of a `Swarm#Run`. It's printed as text through its `String`
+// and as JSON through the `encoding/json` package.
+type Report struct {
+	// Duration is the duration of the run, from the first dial to the close of the last connection.
+	Duration time.Duration `json:"duration"`
+	// Dialed is the number of the dialed connections, less than the `Swarm.Connections` if the run was canceled.
+	Dialed int `json:"dialed"`
+	// Connected is the number of the connections which were acknowledged and connected to their namespaces.
+	Connected uint64 `json:"connected"`
+	// ConnectFailures is the number of the connections which failed to dial,
+	// to be acknowledged or to connect to a namespace, the ones which failed because of the cancellation are not counted.
+	ConnectFailures uint64 `json:"connectFailures"`
+	// ConnectLatency is the time from the dial of a connection until it's connected to its namespaces.
+	ConnectLatency neffos.LatencyHistogram `json:"connectLatency"`
+
+	// Emits is the number of the messages sent through the `Swarm#Emit`.
+	Emits uint64 `json:"emits"`
+	// EmitFailures is the number of the messages which were not sent because their connection was closed.
+	EmitFailures uint64 `json:"emitFailures"`
+	// EmitThroughput is the number of the emits per second of the run.
+	EmitThroughput float64 `json:"emitThroughput"`
+
+	// AskRTT is the round trip time of the successful asks, see `Swarm#Ask`.
+	AskRTT neffos.LatencyHistogram `json:"askRTT"`
+	// AskFailures is the number of the failed asks.
+	AskFailures uint64 `json:"askFailures"`
+
+	// Disconnects is the number of the closed connections by their reason, i.e `DisconnectCompleted`.
+	Disconnects map[string]uint64 `json:"disconnects"`
+}
+
+// String returns the report as text.
+func (r *Report) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "duration: %s\n", r.Duration)
+	fmt.Fprintf(&b, "connections: dialed=%d connected=%d failed=%d\n", r.Dialed, r.Connected, r.ConnectFailures)
+	fmt.Fprintf(&b, "connect latency: %s\n", formatHistogram(r.ConnectLatency))
+	fmt.Fprintf(&b, "emits: %d (%.1f/s) failed=%d\n", r.Emits, r.EmitThroughput, r.EmitFailures)
+	fmt.Fprintf(&b, "asks: %d failed=%d\n", r.AskRTT.Count, r.AskFailures)
+	fmt.Fprintf(&b, "ask rtt: %s\n", formatHistogram(r.AskRTT))
+
+	reasons := make([]string, 0, len(r.Disconnects))
+	for reason := range r.Disconnects {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	b.WriteString("disconnects:")
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, " %s=%d", reason, r.Disconnects[reason])
+	}
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+// formatHistogram returns the count, the mean and the percentiles of the "h",
+// each percentile is the upper bound of its bucket.
+func formatHistogram(h neffos.LatencyHistogram) string {
+	if h.Count == 0 {
+		return "count=0"
+	}
+
+	return fmt.Sprintf("count=%d mean=%s p50<=%s p90<=%s p99<=%s", h.Count, h.Sum/time.Duration(h.Count),
+		percentile(h, 0.5), percentile(h, 0.9), percentile(h, 0.99))
+}
+
+func percentile(h neffos.LatencyHistogram, q float64) string {
+	target := uint64(q * float64(h.Count))
+	if target == 0 {
+		target = 1
+	}
+
+	var total uint64
+	for i, count := range h.Counts {
+		total += count
+		if total >= target {
+			if i < len(neffos.LatencyBuckets) {
+				return neffos.LatencyBuckets[i].String()
+			}
+			break
+		}
+	}
+
+	return "inf"
+}
+
+// stats keeps the live values of a `Report`, they are modified through the atomic package.
+type stats struct {
+	connected       uint64
+	connectFailures uint64
+	connectLatency  histogram
+
+	emits        uint64
+	emitFailures uint64
+
+	askFailures uint64
+	askRTT      histogram
+
+	mu          sync.Mutex
+	disconnects map[string]uint64
+}
+
+func newStats() *stats {
+	return &stats{disconnects: make(map[string]uint64)}
+}
+
+// connectFailed counts a failed connection, unless it failed because of the "ctx" cancellation.
+func (st *stats) connectFailed(ctx context.Context) {
+	if ctx.Err() == nil {
+		atomic.AddUint64(&st.connectFailures, 1)
+	}
+}
+
+func (st *stats) disconnected(reason string) {
+	st.mu.Lock()
+	st.disconnects[reason]++
+	st.mu.Unlock()
+}
+
+func (st *stats) report(dialed int, duration time.Duration) *Report {
+	r := &Report{
+		Duration:        duration,
+		Dialed:          dialed,
+		Connected:       atomic.LoadUint64(&st.connected),
+		ConnectFailures: atomic.LoadUint64(&st.connectFailures),
+		ConnectLatency:  st.connectLatency.snapshot(),
+		Emits:           atomic.LoadUint64(&st.emits),
+		EmitFailures:    atomic.LoadUint64(&st.emitFailures),
+		AskRTT:          st.askRTT.snapshot(),
+		AskFailures:     atomic.LoadUint64(&st.askFailures),
+		Disconnects:     make(map[string]uint64),
+	}
+
+	if seconds := duration.Seconds(); seconds > 0 {
+		r.EmitThroughput = float64(r.Emits) / seconds
+	}
+
+	st.mu.Lock()
+	for reason, n := range st.disconnects {
+		r.Disconnects[reason] = n
+	}
+	st.mu.Unlock()
+
+	return r
+}
+
+// histogram keeps the live values of a `neffos.LatencyHistogram`.
+type histogram struct {
+	count  uint64
+	sumNs  uint64
+	counts [11]uint64 // len(neffos.LatencyBuckets)+1.
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for ; i < len(neffos.LatencyBuckets) && i < len(h.counts)-1; i++ {
+		if d <= neffos.LatencyBuckets[i] {
+			break
+		}
+	}
+
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sumNs, uint64(d))
+}
+
+func (h *histogram) snapshot() neffos.LatencyHistogram {
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+
+	return neffos.LatencyHistogram{
+		Counts: counts,
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    time.Duration(atomic.LoadUint64(&h.sumNs)),
+	}
+}
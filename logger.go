@@ -0,0 +1,64 @@
+package neffos
+
+// Logger is the structured logger of a server and its connections, see `Server#SetLogger` and `WithLogger`.
+// The "keyvals" are alternating keys and values, i.e "conn", "id", "namespace", "chat", "err", err.
+// Its method set matches the `*slog.Logger` one, see `NewSlogLogger`.
+//
+// The upgrade failures, the acknowledgement outcomes, the namespace connections and disconnections,
+// the write failures, the errors of the `StackExchange` and the close reasons of the connections are logged.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// SetLogger sets the "logger" of the server and its connections.
+// It should be set before serve.
+//
+// Defaults to nil, nothing is logged.
+func (s *Server) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+// WithLogger is a `DialOption` which sets the "logger" of the client connection.
+// See `Server#SetLogger` too.
+func WithLogger(logger Logger) DialOption {
+	return func(c *Conn) {
+		c.logger = logger
+	}
+}
+
+func (s *Server) logError(msg string, keyvals ...interface{}) {
+	if s.logger != nil {
+		s.logger.Error(msg, keyvals...)
+	}
+}
+
+func (s *Server) logInfo(msg string, keyvals ...interface{}) {
+	if s.logger != nil {
+		s.logger.Info(msg, keyvals...)
+	}
+}
+
+// logFields prepends the connection's fields to the "keyvals".
+func (c *Conn) logFields(keyvals []interface{}) []interface{} {
+	return append([]interface{}{"conn", c.ID(), "client", c.IsClient()}, keyvals...)
+}
+
+func (c *Conn) logDebug(msg string, keyvals ...interface{}) {
+	if c.logger != nil {
+		c.logger.Debug(msg, c.logFields(keyvals)...)
+	}
+}
+
+func (c *Conn) logInfo(msg string, keyvals ...interface{}) {
+	if c.logger != nil {
+		c.logger.Info(msg, c.logFields(keyvals)...)
+	}
+}
+
+func (c *Conn) logError(msg string, keyvals ...interface{}) {
+	if c.logger != nil {
+		c.logger.Error(msg, c.logFields(keyvals)...)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build go1.21
+
+package neffos
+
+import "log/slog"
+
+// NewSlogLogger returns a `Logger` which writes to the "logger", the `slog.Default()` one if nil.
+//
+// Usage:
+//
+//	server.SetLogger(neffos.NewSlogLogger(slog.New(slog.NewJSONHandler(os.Stderr, nil))))
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return logger
+}
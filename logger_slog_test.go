@@ -0,0 +1,48 @@
+//go:build go1.21
+
+package neffos_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kataras/neffos"
+
+	gorilla "github.com/kataras/neffos/gorilla"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+		buf       bytes.Buffer
+		handler   = slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	defer server.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	url := strings.Replace(httpServer.URL, "http", "ws", 1)
+	logger := neffos.NewSlogLogger(slog.New(handler))
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, events, neffos.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `level=INFO msg="namespace connected" conn=` + client.ID + ` client=true namespace=default`
+	if got := buf.String(); !strings.Contains(got, expected) {
+		t.Fatalf("expected the entry: %s but got:\n%s", expected, got)
+	}
+}
@@ -0,0 +1,135 @@
+package neffos_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gorilla "github.com/kataras/neffos/gorilla"
+)
+
+// recordLogger is a `neffos.Logger` which records its entries as "level msg key=value...".
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordLogger) log(level, msg string, keyvals []interface{}) {
+	entry := level + " " + msg
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		entry += fmt.Sprintf(" %v=%v", keyvals[i], keyvals[i+1])
+	}
+
+	l.mu.Lock()
+	l.entries = append(l.entries, entry)
+	l.mu.Unlock()
+}
+
+func (l *recordLogger) Debug(msg string, keyvals ...interface{}) { l.log("DEBUG", msg, keyvals) }
+func (l *recordLogger) Info(msg string, keyvals ...interface{})  { l.log("INFO", msg, keyvals) }
+func (l *recordLogger) Error(msg string, keyvals ...interface{}) { l.log("ERROR", msg, keyvals) }
+
+// expect waits for an entry which contains all of the "parts".
+func (l *recordLogger) expect(t *testing.T, side string, parts ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		l.mu.Lock()
+		for _, entry := range l.entries {
+			found := true
+			for _, part := range parts {
+				if !strings.Contains(entry, part) {
+					found = false
+					break
+				}
+			}
+
+			if found {
+				l.mu.Unlock()
+				return
+			}
+		}
+		entries := strings.Join(l.entries, "\n")
+		l.mu.Unlock()
+
+		if time.Now().After(deadline) {
+			t.Fatalf("[%s] expected an entry with: %q but got:\n%s", side, parts, entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var (
+		namespace = "default"
+		errFail   = errors.New("fail")
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"fail": func(c *neffos.NSConn, msg neffos.Message) error {
+					return errFail
+				},
+			},
+			"private": neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						return neffos.ErrRejected
+					}
+					return nil
+				},
+			},
+		}
+		serverLogger = new(recordLogger)
+		clientLogger = new(recordLogger)
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	server.SetLogger(serverLogger)
+	defer server.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	url := strings.Replace(httpServer.URL, "http", "ws", 1)
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, events, neffos.WithLogger(clientLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := "conn=" + client.ID
+	serverLogger.expect(t, "server", "DEBUG connection acknowledged", conn, "client=false")
+	clientLogger.expect(t, "client", "DEBUG connection acknowledged", conn, "client=true")
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverLogger.expect(t, "server", "INFO namespace connected", conn, "namespace=default")
+	clientLogger.expect(t, "client", "INFO namespace connected", conn, "namespace=default")
+
+	if _, err = client.Connect(context.TODO(), "private"); err == nil {
+		t.Fatalf("expected the namespace connect to be rejected")
+	}
+	serverLogger.expect(t, "server", "INFO namespace connect rejected", "namespace=private", "err="+neffos.ErrRejected.Error())
+	clientLogger.expect(t, "client", "INFO namespace connect rejected", "namespace=private")
+
+	c.Ask(context.TODO(), "fail", nil)
+	serverLogger.expect(t, "server", "ERROR event failed", conn, "namespace=default", "event=fail", "err=fail")
+
+	if err = c.Disconnect(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	serverLogger.expect(t, "server", "INFO namespace disconnected", conn, "namespace=default")
+	clientLogger.expect(t, "client", "INFO namespace disconnected", conn, "namespace=default")
+
+	client.Close()
+	clientLogger.expect(t, "client", "DEBUG connection closed", "reason=local")
+	serverLogger.expect(t, "server", "INFO connection closed", conn, "reason=")
+}
@@ -0,0 +1,259 @@
+package longpoll
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+// DefaultDialer is a long-polling dialer through the `http.DefaultClient`, see `Dialer`.
+var DefaultDialer = Dialer(nil)
+
+// errClosed is the error of the client's `ReadData` when the server closed the connection.
+var errClosed = neffos.NewCloseError(1000, errors.New("longpoll: connection closed"))
+
+// Dialer is a `neffos.Dialer` type for the long-polling transport, see `Handler`.
+// Should be used on `neffos.Dial` to create a new client/client-side connection,
+// the url's scheme can be "ws" or "http" (and their secure ones), i.e "http://localhost:8080/longpoll".
+// The "client" sends the requests, a nil one is the `http.DefaultClient`,
+// its `Timeout`, if any, should be greater than the server's `Options.PollTimeout`.
+func Dialer(client *http.Client) neffos.Dialer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return func(ctx context.Context, url string) (neffos.Socket, error) {
+		url = httpURL(url)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		id := resp.Header.Get(IDHeaderKey)
+		if resp.StatusCode != http.StatusOK || id == "" {
+			return nil, fmt.Errorf("longpoll: open: unexpected response: %s", resp.Status)
+		}
+
+		s := newClientSocket(client, url, id)
+		go s.startPolling()
+		return s, nil
+	}
+}
+
+// httpURL converts the websocket "url" to an http one,
+// the `neffos.Dial` prefixes the urls without a websocket scheme with the "ws://".
+func httpURL(url string) string {
+	if strings.HasPrefix(url, "ws://") && strings.Contains(url[len("ws://"):], "://") {
+		url = url[len("ws://"):]
+	}
+
+	if strings.HasPrefix(url, "ws") {
+		url = "http" + url[len("ws"):]
+	}
+
+	return url
+}
+
+// clientSocket is the `neffos.Socket` of the client-side long-polling connections.
+type clientSocket struct {
+	client  *http.Client
+	url     string
+	id      string
+	netConn *netConn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// inbound are the polled messages, it's closed when the polling stops, with the "err".
+	inbound chan frame
+	err     error
+	// mu keeps the order of the sent messages.
+	mu        sync.Mutex
+	closeOnce sync.Once
+}
+
+func newClientSocket(client *http.Client, url, id string) *clientSocket {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &clientSocket{
+		client:  client,
+		url:     url,
+		id:      id,
+		ctx:     ctx,
+		cancel:  cancel,
+		inbound: make(chan frame, 64),
+	}
+	s.netConn = &netConn{
+		close:      s.close,
+		localAddr:  addr(""),
+		remoteAddr: addr(url),
+	}
+	return s
+}
+
+func (s *clientSocket) NetConn() net.Conn {
+	return s.netConn
+}
+
+func (s *clientSocket) Request() *http.Request {
+	return nil
+}
+
+func (s *clientSocket) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(IDHeaderKey, s.id)
+	return req, nil
+}
+
+func (s *clientSocket) startPolling() {
+	defer close(s.inbound)
+
+	for {
+		frames, err := s.poll()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				err = errClosed
+			}
+
+			s.err = err
+			return
+		}
+
+		for _, f := range frames {
+			select {
+			case s.inbound <- f:
+			case <-s.ctx.Done():
+				s.err = errClosed
+				return
+			}
+		}
+	}
+}
+
+func (s *clientSocket) poll() ([]frame, error) {
+	req, err := s.newRequest(s.ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return readFrames(resp.Body, 0)
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusGone:
+		return nil, errClosed
+	default:
+		return nil, fmt.Errorf("longpoll: poll: unexpected response: %s", resp.Status)
+	}
+}
+
+func (s *clientSocket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case f, ok := <-s.inbound:
+		if !ok {
+			return nil, 0, s.err
+		}
+
+		return f.data, f.typ, nil
+	case <-timer:
+		return nil, 0, errTimeout
+	}
+}
+
+func (s *clientSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.write(frame{typ: neffos.BinaryMessage, data: body}, timeout)
+}
+
+func (s *clientSocket) WriteText(body []byte, timeout time.Duration) error {
+	return s.write(frame{typ: neffos.TextMessage, data: body}, timeout)
+}
+
+func (s *clientSocket) write(f frame, timeout time.Duration) error {
+	ctx := s.ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, err := s.newRequest(ctx, http.MethodPost, appendFrame(nil, f))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return errClosed
+		}
+
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusGone:
+		return errClosed
+	case http.StatusServiceUnavailable:
+		return ErrQueueFull
+	case http.StatusRequestEntityTooLarge:
+		return neffos.ErrMessageTooLarge
+	default:
+		return fmt.Errorf("longpoll: post: unexpected response: %s", resp.Status)
+	}
+}
+
+// close stops the polling and notifies the server.
+func (s *clientSocket) close() error {
+	s.closeOnce.Do(func() {
+		s.cancel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if req, err := s.newRequest(ctx, http.MethodDelete, nil); err == nil {
+			if resp, err := s.client.Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package longpoll
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/kataras/neffos"
+)
+
+// frameHeaderSize is the size of the header of each frame of a request or a poll response's body:
+// the message type, 1 byte, and the big-endian length of its payload, 4 bytes.
+const frameHeaderSize = 5
+
+// frame is a text or a binary message.
+type frame struct {
+	typ  neffos.MessageType
+	data []byte
+	// err is returned from the server socket's `ReadData` instead, i.e a too large message.
+	err error
+}
+
+var errInvalidFrame = errors.New("longpoll: invalid frame")
+
+func appendFrame(b []byte, f frame) []byte {
+	var header [frameHeaderSize]byte
+	header[0] = byte(f.typ)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(f.data)))
+
+	b = append(b, header[:]...)
+	return append(b, f.data...)
+}
+
+// readFrames reads the frames of the "r" body,
+// a non-zero "maxSize" limits the size of each frame's payload.
+func readFrames(r io.Reader, maxSize int64) ([]frame, error) {
+	var (
+		frames []frame
+		header [frameHeaderSize]byte
+	)
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return frames, nil
+			}
+
+			return nil, err
+		}
+
+		typ, length := neffos.MessageType(header[0]), int64(binary.BigEndian.Uint32(header[1:]))
+		if typ != neffos.TextMessage && typ != neffos.BinaryMessage {
+			return nil, errInvalidFrame
+		}
+
+		if maxSize > 0 && length > maxSize {
+			return nil, neffos.ErrMessageTooLarge
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, frame{typ: typ, data: data})
+	}
+}
@@ -0,0 +1,226 @@
+// Package longpoll provides the HTTP long-polling fallback transport of neffos,
+// for the clients which can not use websocket, i.e behind proxies which block it.
+//
+// The client opens a connection with a POST request and receives its session token in the `IDHeaderKey` header,
+// then it sends its messages with POST requests and receives the server's ones with GET requests (polls),
+// which block until messages are queued for the connection or the `Options.PollTimeout` lapses,
+// both carry the token in the `IDHeaderKey` header. A DELETE request closes the connection.
+// The bodies are frames of the message type (1 byte), the big-endian length (4 bytes) and the payload of each message.
+//
+// Server-side the connections are regular `neffos.Conn`s, with a virtual socket backed by their queues,
+// `Dialer` is the client-side transport.
+package longpoll
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+// IDHeaderKey is the header of the connection's session token, the response of the opening request sets it
+// and the rest of the requests of the connection should send it.
+// The token is random and unrelated to the `neffos.Conn#ID`, which the client receives on its acknowledgement,
+// so connections with the same ID, i.e of a custom `neffos.Server#IDGenerator`, do not share their sessions.
+const IDHeaderKey = "X-Neffos-Longpoll-Id"
+
+// Options are the options of the long-polling `Handler`.
+type Options struct {
+	// PollTimeout is the duration which a poll blocks when there are no queued messages,
+	// then it returns an empty (204) response. Defaults to 25 seconds.
+	PollTimeout time.Duration
+	// InactivityTimeout closes a connection which its client did not post or poll within,
+	// the connection is closed as if its websocket was closed, i.e `Server#OnDisconnect` is fired.
+	// Defaults to two times the `PollTimeout`.
+	InactivityTimeout time.Duration
+	// QueueSize is the maximum number of the queued messages of each direction of a connection,
+	// a post which exceeds it is refused (503) and a write which exceeds it fails with the `ErrQueueFull`.
+	// Defaults to 1024.
+	QueueSize int
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.PollTimeout <= 0 {
+		opts.PollTimeout = 25 * time.Second
+	}
+
+	if opts.InactivityTimeout <= 0 {
+		opts.InactivityTimeout = 2 * opts.PollTimeout
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+
+	return opts
+}
+
+type handler struct {
+	server  *neffos.Server
+	options Options
+
+	mu      sync.RWMutex
+	sockets map[string]*serverSocket
+}
+
+// Handler returns the `http.Handler` of the long-polling transport of the neffos "server",
+// i.e `mux.Handle("/longpoll", longpoll.Handler(server, longpoll.Options{}))`.
+// The server's Upgrader is not used, see `neffos.Server#ServeSocket`.
+func Handler(server *neffos.Server, options Options) http.Handler {
+	return &handler{
+		server:  server,
+		options: options.withDefaults(),
+		sockets: make(map[string]*serverSocket),
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(IDHeaderKey)
+	if token == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "missing session token", http.StatusBadRequest)
+			return
+		}
+
+		h.open(w, r)
+		return
+	}
+
+	h.mu.RLock()
+	socket := h.sockets[token]
+	h.mu.RUnlock()
+
+	if socket == nil {
+		http.Error(w, "unknown connection", http.StatusGone)
+		return
+	}
+
+	socket.touch()
+
+	switch r.Method {
+	case http.MethodGet:
+		h.poll(w, r, socket)
+	case http.MethodPost:
+		h.post(w, r, socket)
+	case http.MethodDelete:
+		socket.close()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) open(w http.ResponseWriter, r *http.Request) {
+	// the socket's request lives as long as its connection.
+	request := r.Clone(context.Background())
+	request.Body = http.NoBody
+
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		registered bool
+		socket     *serverSocket
+	)
+
+	socket = newServerSocket(request, h.options, func() {
+		h.mu.Lock()
+		if h.sockets[token] == socket {
+			delete(h.sockets, token)
+		}
+		h.mu.Unlock()
+	})
+
+	// the socket is closed by the server on errors, i.e on a failed `OnConnect`,
+	// the client still polls the acknowledgement's error.
+	h.server.ServeSocket(w, request, socket, func(w http.ResponseWriter, r *http.Request) string {
+		h.mu.Lock()
+		h.sockets[token] = socket
+		h.mu.Unlock()
+		registered = true
+		return h.server.IDGenerator(w, r)
+	})
+
+	if !registered { // closed server.
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set(IDHeaderKey, token)
+	w.WriteHeader(http.StatusOK)
+}
+
+// newToken returns a new random session token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (h *handler) poll(w http.ResponseWriter, r *http.Request, socket *serverSocket) {
+	if !socket.acquirePoll() {
+		http.Error(w, "another poll is in progress", http.StatusConflict)
+		return
+	}
+	defer socket.releasePoll()
+	defer socket.touch()
+
+	frames, ok := socket.poll(r)
+	if !ok {
+		socket.remove()
+		http.Error(w, "connection closed", http.StatusGone)
+		return
+	}
+
+	if len(frames) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var b []byte
+	for _, f := range frames {
+		b = appendFrame(b, f)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func (h *handler) post(w http.ResponseWriter, r *http.Request, socket *serverSocket) {
+	if socket.isClosed() {
+		http.Error(w, "connection closed", http.StatusGone)
+		return
+	}
+
+	frames, err := readFrames(r.Body, socket.getReadLimit())
+	if err != nil {
+		if err == neffos.ErrMessageTooLarge {
+			// close the connection as the websocket ones do.
+			socket.push([]frame{{err: err}})
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = socket.push(frames); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
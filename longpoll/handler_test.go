@@ -0,0 +1,330 @@
+package longpoll_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/longpoll"
+)
+
+const namespace = "default"
+
+func runTestServer(t *testing.T, events neffos.Namespaces, options longpoll.Options, configure func(*neffos.Server)) (*httptest.Server, chan *neffos.Conn) {
+	t.Helper()
+
+	conns := make(chan *neffos.Conn, 16)
+	server := neffos.New(nil, events)
+	server.OnConnect = func(c *neffos.Conn) error {
+		conns <- c
+		return nil
+	}
+
+	if configure != nil {
+		configure(server)
+	}
+
+	httpServer := httptest.NewServer(longpoll.Handler(server, options))
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Close()
+	})
+
+	return httpServer, conns
+}
+
+func TestDial(t *testing.T) {
+	var (
+		n        = 50
+		received = make(chan string, n)
+		polled   = make(chan string, n)
+		events   = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+				"message": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						polled <- string(msg.Body)
+					} else {
+						received <- string(msg.Body)
+					}
+					return nil
+				},
+			},
+		}
+		disconnected = make(chan struct{})
+	)
+
+	httpServer, conns := runTestServer(t, events, longpoll.Options{PollTimeout: 100 * time.Millisecond}, func(s *neffos.Server) {
+		s.OnDisconnect = func(c *neffos.Conn) {
+			close(disconnected)
+		}
+	})
+
+	client, err := neffos.Dial(context.TODO(), longpoll.DefaultDialer, httpServer.URL, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn := <-conns
+	if expected, got := serverConn.ID(), client.ID; expected != got {
+		t.Fatalf("expected the client's ID to be: %s but got: %s", expected, got)
+	}
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := c.Ask(context.TODO(), "echo", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "data", string(msg.Body); expected != got {
+		t.Fatalf("expected body: %s but got: %s", expected, got)
+	}
+
+	// the order is kept on both directions.
+	serverNS := serverConn.Namespace(namespace)
+	for i := 0; i < n; i++ {
+		c.Emit("message", []byte(strconv.Itoa(i)))
+		serverNS.Emit("message", []byte(strconv.Itoa(i)))
+	}
+
+	for _, ch := range []chan string{received, polled} {
+		for i := 0; i < n; i++ {
+			select {
+			case got := <-ch:
+				if expected := strconv.Itoa(i); expected != got {
+					t.Fatalf("expected message: %s but got: %s", expected, got)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("expected message: %d", i)
+			}
+		}
+	}
+
+	client.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the server connection to be closed")
+	}
+}
+
+func TestDialConnectError(t *testing.T) {
+	httpServer, _ := runTestServer(t, neffos.Namespaces{}, longpoll.Options{PollTimeout: 100 * time.Millisecond}, func(s *neffos.Server) {
+		s.OnConnect = func(c *neffos.Conn) error {
+			return fmt.Errorf("not allowed")
+		}
+	})
+
+	_, err := neffos.Dial(context.TODO(), longpoll.DefaultDialer, httpServer.URL, neffos.Namespaces{})
+	if err == nil || err.Error() != "not allowed" {
+		t.Fatalf("expected the OnConnect error but got: %v", err)
+	}
+}
+
+// rawClient speaks the transport's requests, to test the server side.
+type rawClient struct {
+	t   *testing.T
+	url string
+	id  string
+}
+
+func openRaw(t *testing.T, url string) *rawClient {
+	t.Helper()
+
+	resp, err := http.Post(url, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	id := resp.Header.Get(longpoll.IDHeaderKey)
+	if resp.StatusCode != http.StatusOK || id == "" {
+		t.Fatalf("expected the connection's ID but got: %s", resp.Status)
+	}
+
+	return &rawClient{t: t, url: url, id: id}
+}
+
+func (c *rawClient) do(method string, body []byte) (int, []byte) {
+	c.t.Helper()
+
+	req, err := http.NewRequest(method, c.url, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set(longpoll.IDHeaderKey, c.id)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return resp.StatusCode, b
+}
+
+func frames(messages ...string) []byte {
+	var b []byte
+	for _, m := range messages {
+		var header [5]byte
+		header[0] = byte(neffos.TextMessage)
+		binary.BigEndian.PutUint32(header[1:], uint32(len(m)))
+		b = append(append(b, header[:]...), m...)
+	}
+
+	return b
+}
+
+func TestAtMostOncePerPoll(t *testing.T) {
+	httpServer, conns := runTestServer(t, neffos.Namespaces{}, longpoll.Options{PollTimeout: 100 * time.Millisecond}, nil)
+
+	c := openRaw(t, httpServer.URL)
+	serverConn := <-conns
+
+	// the acknowledgement.
+	if status, _ := c.do(http.MethodPost, frames("M")); status != http.StatusNoContent {
+		t.Fatalf("expected the post to be accepted but got: %d", status)
+	}
+
+	status, body := c.do(http.MethodGet, nil)
+	if expected := frames("A" + serverConn.ID()); status != http.StatusOK || !bytes.Equal(expected, body) {
+		t.Fatalf("expected the acknowledgement but got: %d: %q", status, body)
+	}
+
+	// already delivered.
+	if status, body = c.do(http.MethodGet, nil); status != http.StatusNoContent {
+		t.Fatalf("expected an empty poll but got: %d: %q", status, body)
+	}
+
+	// the queued messages of a poll are delivered together, in order.
+	serverConn.Socket().WriteText([]byte("1"), 0)
+	serverConn.Socket().WriteText([]byte("2"), 0)
+	if status, body = c.do(http.MethodGet, nil); status != http.StatusOK || !bytes.Equal(frames("1", "2"), body) {
+		t.Fatalf("expected the queued messages but got: %d: %q", status, body)
+	}
+
+	// the queued messages are delivered after the close too.
+	serverConn.Socket().WriteText([]byte("3"), 0)
+	serverConn.Close()
+	if status, body = c.do(http.MethodGet, nil); status != http.StatusOK || !bytes.Equal(frames("3"), body) {
+		t.Fatalf("expected the last message but got: %d: %q", status, body)
+	}
+
+	if status, _ = c.do(http.MethodGet, nil); status != http.StatusGone {
+		t.Fatalf("expected the closed connection to be gone but got: %d", status)
+	}
+}
+
+func TestQueueSize(t *testing.T) {
+	httpServer, conns := runTestServer(t, neffos.Namespaces{}, longpoll.Options{PollTimeout: 100 * time.Millisecond, QueueSize: 2}, nil)
+
+	c := openRaw(t, httpServer.URL)
+	serverConn := <-conns
+
+	socket := serverConn.Socket()
+	for i := 0; i < 2; i++ {
+		if err := socket.WriteText([]byte("data"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := socket.WriteText([]byte("data"), 0); err != longpoll.ErrQueueFull {
+		t.Fatalf("expected the queue full error but got: %v", err)
+	}
+
+	// the whole post is refused.
+	if status, _ := c.do(http.MethodPost, frames("M", "a", "b")); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected the post to be refused but got: %d", status)
+	}
+
+	if status, _ := c.do(http.MethodPost, frames("M")); status != http.StatusNoContent {
+		t.Fatalf("expected the post to be accepted but got: %d", status)
+	}
+}
+
+func TestInactivityTimeout(t *testing.T) {
+	disconnected := make(chan struct{})
+	httpServer, conns := runTestServer(t, neffos.Namespaces{}, longpoll.Options{PollTimeout: 50 * time.Millisecond, InactivityTimeout: 150 * time.Millisecond}, func(s *neffos.Server) {
+		s.OnDisconnect = func(c *neffos.Conn) {
+			close(disconnected)
+		}
+	})
+
+	c := openRaw(t, httpServer.URL)
+	<-conns
+	c.do(http.MethodPost, frames("M"))
+
+	// active.
+	for i := 0; i < 4; i++ {
+		c.do(http.MethodGet, nil)
+	}
+
+	select {
+	case <-disconnected:
+		t.Fatalf("expected the active connection to not be closed")
+	default:
+	}
+
+	select {
+	case <-disconnected:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the inactive connection to be closed")
+	}
+
+	if status, _ := c.do(http.MethodGet, nil); status != http.StatusGone {
+		t.Fatalf("expected the expired connection to be gone but got: %d", status)
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	httpServer, conns := runTestServer(t, neffos.Namespaces{}, longpoll.Options{PollTimeout: 100 * time.Millisecond}, func(s *neffos.Server) {
+		s.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			return "same"
+		}
+	})
+
+	first := openRaw(t, httpServer.URL)
+	firstConn := <-conns
+	second := openRaw(t, httpServer.URL)
+	secondConn := <-conns
+
+	if first.id == second.id || first.id == firstConn.ID() {
+		t.Fatalf("expected different session tokens than the connection ID: %s but got: %s and %s", firstConn.ID(), first.id, second.id)
+	}
+
+	for _, c := range []*rawClient{first, second} {
+		c.do(http.MethodPost, frames("M"))
+		c.do(http.MethodGet, nil) // the acknowledgement.
+	}
+
+	// each session keeps polling its own connection.
+	firstConn.Socket().WriteText([]byte("1"), 0)
+	secondConn.Socket().WriteText([]byte("2"), 0)
+
+	for _, tt := range []struct {
+		c        *rawClient
+		expected string
+	}{{first, "1"}, {second, "2"}} {
+		if status, body := tt.c.do(http.MethodGet, nil); status != http.StatusOK || !bytes.Equal(frames(tt.expected), body) {
+			t.Fatalf("expected the message: %s but got: %d: %q", tt.expected, status, body)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package longpoll
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+// ErrQueueFull is returned when a message is sent but the queue of its direction is full, see `Options.QueueSize`.
+var ErrQueueFull = errors.New("longpoll: queue is full")
+
+var errTimeout = errors.New("longpoll: i/o timeout")
+
+// serverSocket is the virtual `neffos.Socket` of a long-polling connection,
+// backed by the queue of the posted messages and the queue of the messages to be polled.
+type serverSocket struct {
+	request *http.Request
+	netConn *netConn
+	options Options
+
+	// inbound are the posted messages, pushMu makes the capacity check and the push of a request atomic.
+	inbound chan frame
+	pushMu  sync.Mutex
+
+	// outbound are the messages of the next poll, each message is delivered once, even if the poll's response fails.
+	mu       sync.Mutex
+	outbound []frame
+	notify   chan struct{}
+	polling  bool
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	// expiry closes the socket when the client does not post or poll within the `Options.InactivityTimeout`.
+	expiry *time.Timer
+	// remove removes the socket from the handler.
+	remove func()
+	// see `SetReadLimit`.
+	readLimit int64
+}
+
+func newServerSocket(r *http.Request, options Options, remove func()) *serverSocket {
+	s := &serverSocket{
+		request: r,
+		options: options,
+		inbound: make(chan frame, options.QueueSize),
+		notify:  make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+		remove:  remove,
+	}
+	s.netConn = &netConn{
+		close:      s.close,
+		localAddr:  addr(r.Host),
+		remoteAddr: addr(r.RemoteAddr),
+	}
+	s.expiry = time.AfterFunc(options.InactivityTimeout, s.expire)
+	return s
+}
+
+func (s *serverSocket) NetConn() net.Conn {
+	return s.netConn
+}
+
+func (s *serverSocket) Request() *http.Request {
+	return s.request
+}
+
+func (s *serverSocket) SetReadLimit(limit int64) {
+	atomic.StoreInt64(&s.readLimit, limit)
+}
+
+func (s *serverSocket) getReadLimit() int64 {
+	return atomic.LoadInt64(&s.readLimit)
+}
+
+func (s *serverSocket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case f := <-s.inbound:
+		if f.err != nil {
+			return nil, 0, f.err
+		}
+
+		if limit := s.getReadLimit(); limit > 0 && int64(len(f.data)) > limit {
+			return nil, 0, neffos.ErrMessageTooLarge
+		}
+
+		return f.data, f.typ, nil
+	case <-s.closed:
+		return nil, 0, io.ErrUnexpectedEOF
+	case <-timer:
+		return nil, 0, errTimeout
+	}
+}
+
+func (s *serverSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.write(frame{typ: neffos.BinaryMessage, data: body})
+}
+
+func (s *serverSocket) WriteText(body []byte, timeout time.Duration) error {
+	return s.write(frame{typ: neffos.TextMessage, data: body})
+}
+
+func (s *serverSocket) write(f frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	if len(s.outbound) >= s.options.QueueSize {
+		return ErrQueueFull
+	}
+
+	// the body may be reused by the caller after the write.
+	f.data = append([]byte(nil), f.data...)
+	s.outbound = append(s.outbound, f)
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// push queues the posted frames, all or none of them.
+func (s *serverSocket) push(frames []frame) error {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+
+	if len(s.inbound)+len(frames) > cap(s.inbound) {
+		return ErrQueueFull
+	}
+
+	for _, f := range frames {
+		s.inbound <- f
+	}
+
+	return nil
+}
+
+// take returns and removes the queued messages of the next poll.
+func (s *serverSocket) take() []frame {
+	s.mu.Lock()
+	frames := s.outbound
+	s.outbound = nil
+	s.mu.Unlock()
+
+	return frames
+}
+
+// poll blocks until there are queued messages, the socket is closed or the `Options.PollTimeout` lapses.
+// It returns false when the socket is closed and all of its messages are delivered.
+func (s *serverSocket) poll(r *http.Request) ([]frame, bool) {
+	timer := time.NewTimer(s.options.PollTimeout)
+	defer timer.Stop()
+
+	for {
+		if frames := s.take(); len(frames) > 0 {
+			return frames, true
+		}
+
+		select {
+		case <-s.notify:
+		case <-s.closed:
+			// no more writes, the messages written before the close are still delivered.
+			frames := s.take()
+			return frames, len(frames) > 0
+		case <-timer.C:
+			return nil, true
+		case <-r.Context().Done():
+			return nil, true
+		}
+	}
+}
+
+// acquirePoll reports whether there is no other in-progress poll.
+func (s *serverSocket) acquirePoll() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.polling {
+		return false
+	}
+
+	s.polling = true
+	return true
+}
+
+func (s *serverSocket) releasePoll() {
+	s.mu.Lock()
+	s.polling = false
+	s.mu.Unlock()
+}
+
+// touch postpones the expiry of the socket, the client is active.
+func (s *serverSocket) touch() {
+	s.expiry.Reset(s.options.InactivityTimeout)
+}
+
+func (s *serverSocket) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes the socket, so its connection is closed with the normal Close/OnDisconnect path,
+// it's removed from the handler once its queued messages are polled or it expires.
+func (s *serverSocket) close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+
+	s.mu.Lock()
+	empty := len(s.outbound) == 0
+	s.mu.Unlock()
+
+	if empty {
+		s.remove()
+	}
+
+	return nil
+}
+
+func (s *serverSocket) expire() {
+	s.close()
+	s.remove()
+}
+
+// netConn is the net connection of the sockets, it's used to close them,
+// its reads and writes are not used by neffos.
+type netConn struct {
+	close      func() error
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+var _ net.Conn = (*netConn)(nil)
+
+func (c *netConn) Read(b []byte) (int, error)  { return 0, io.ErrClosedPipe }
+func (c *netConn) Write(b []byte) (int, error) { return 0, io.ErrClosedPipe }
+func (c *netConn) Close() error                { return c.close() }
+
+func (c *netConn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *netConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *netConn) SetDeadline(t time.Time) error      { return nil }
+func (c *netConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *netConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// addr is the address of the http requests of a connection.
+type addr string
+
+func (a addr) Network() string { return "tcp" }
+func (a addr) String() string  { return string(a) }
@@ -2,10 +2,13 @@ package neffos
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,6 +31,11 @@ import (
 // Internal `serializeMessage` and
 // exported `DeserializeMessage` functions
 // do the job on `Conn#Write`, `NSConn#Emit` and `Room#Emit` calls.
+//
+// A message passed to `Server#Broadcast` is cloned before it's queued,
+// so its caller may reuse or mutate the message and its `Body` right after the call.
+// The `Body` of a received message is owned by its event callback,
+// use `Clone` to keep it after the callback returns.
 type Message struct {
 	wait string
 
@@ -55,6 +63,15 @@ type Message struct {
 	// not exposed to the subscribers (rest of the clients).
 	// This is the ID across neffos servers when scale.
 	from string
+	// the instance ID of the server which published this message to the StackExchange,
+	// its own delivery is dropped, see `Server#InstanceID`.
+	origin string
+	// the identifier of a publish through a `CompositeStackExchange`,
+	// the same message delivered by both of its stackexchanges is written once.
+	publishID string
+	// the compression of the message's envelope, set by the server which publishes it,
+	// see `Server#SetStackExchangeCompression`.
+	exchangeCompression *exchangeCompression
 	// When sent by the same connection of the current running server instance.
 	// This field is serialized/deserialized but it's clean on sending or receiving from a client
 	// and it's only used on StackExchange feature.
@@ -96,8 +113,72 @@ type Message struct {
 	// If true then the writer's checks will not lock connectedNamespacesMutex or roomsMutex again. May be useful in the future, keep that solution.
 	locked bool
 
+	// reports whether the Body is compressed, see `compressBody`.
+	// It's set and cleared automatically on `Conn#Write` and deserialization.
+	compressed bool
+
+	// the header of a chunk of a large body, see `NSConn#EmitLarge`.
+	chunk chunkHeader
+
+	// the prepared message shared by the connections of a `Server#Broadcast`, see `SocketPreparedWriter`.
+	prepared *preparedMessage
+
+	// the reason of an invalid incoming message, see `Server.StrictParsing`.
+	parseErr *ParseError
+
+	// the context of the event callback which handles this incoming message, see `Context`.
+	ctx context.Context
+
+	// the receiver connection's codec, see `Unmarshal`.
+	// This field is not filled on sending/receiving.
+	codec MessageCodec
+
 	// if server or client should write using Binary message or if the incoming message was readen as binary.
 	SetBinary bool
+
+	// Expiry is the absolute time, in unix milliseconds, after which this message is worthless
+	// and it should not be delivered, i.e presence and typing indicators.
+	// Expired messages are dropped on `Conn#Write` and before dispatch on the receiver side,
+	// see `Server.ExpiryTolerance` and `WithExpiryTolerance` for clock skew tolerance.
+	// Zero means no expiry.
+	// This field is serialized/deserialized as a message extension.
+	Expiry int64
+	// SentAt is the time, in unix milliseconds, that the sender wrote this message,
+	// filled automatically when the `Server.StampSentAt` or the `WithStampSentAt` option is enabled.
+	// It is based on the sender's clock, the clock skew between the two sides
+	// is not corrected, so the `Age` of a message may be inaccurate or even negative.
+	// Zero when not stamped.
+	// This field is serialized/deserialized as a message extension.
+	SentAt int64
+	// TraceID is an optional correlation ID which joins the logs of a message across the hops,
+	// i.e client, server, StackExchange, another server and its client.
+	// It's generated on `Conn#Write` and `Conn#Ask` when the `Server.GenerateTraceID`
+	// or the `WithGenerateTraceID` option is enabled, unless it is already set.
+	// The replies and the messages which are written while an incoming message is handled
+	// (see `Conn#TraceID`) keep the trace ID of the incoming one.
+	// This field is serialized/deserialized as a message extension.
+	TraceID string
+}
+
+// Age returns the time elapsed since the message was sent, see `SentAt`.
+// It returns zero if the message was not stamped.
+func (m *Message) Age() time.Duration {
+	if m.SentAt == 0 {
+		return 0
+	}
+
+	return time.Duration(nowMillis()-m.SentAt) * time.Millisecond
+}
+
+// Context returns the context of the event callback which handles this incoming message,
+// it's cancelled when the connection is closed or when the callback times out, see `Events#WithTimeout`.
+// It defaults to the `context.Background`, i.e for the messages which are not dispatched to a callback.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+
+	return m.ctx
 }
 
 func (m *Message) isConnect() bool {
@@ -116,11 +197,47 @@ func (m *Message) isRoomLeft() bool {
 	return m.Event == OnRoomLeft
 }
 
+// isExpired reports whether the message's Expiry has passed, based on the "now" unix milliseconds
+// and a "tolerance" for clock skew between the two sides.
+func (m *Message) isExpired(now int64, tolerance time.Duration) bool {
+	return m.Expiry > 0 && now > m.Expiry+int64(tolerance/time.Millisecond)
+}
+
 // Serialize returns this message's transport format.
 func (m Message) Serialize() []byte {
 	return serializeMessage(m)
 }
 
+// Clone returns a deep copy of this message,
+// its `Body` does not share memory with the original one.
+func (m Message) Clone() Message {
+	if m.Body != nil {
+		m.Body = append(make([]byte, 0, len(m.Body)), m.Body...)
+	}
+
+	return m
+}
+
+// cloneMessages returns a deep copy of the "msgs".
+func cloneMessages(msgs []Message) []Message {
+	cloned := make([]Message, len(msgs))
+	for i := range msgs {
+		cloned[i] = msgs[i].Clone()
+	}
+
+	return cloned
+}
+
+// SerializeExchange returns this message's StackExchange envelope.
+// Unlike `Serialize`, it keeps the fields which are not sent to the clients,
+// i.e the `To`, `IsForced`, `IsLocal`, `IsNative`, `SetBinary` and the `Server#Broadcast`'s excluded connection,
+// so a message published to other servers is handled exactly like a local one.
+// The envelope is versioned, see `ExchangeEnvelopeVersion`.
+// See `DeserializeExchangeMessage` and `Conn#DeserializeExchangeMessage`.
+func (m Message) SerializeExchange() []byte {
+	return writeExchangeEnvelope(new(bytes.Buffer), m)
+}
+
 type (
 	// MessageObjectMarshaler is an optional interface that "objects"
 	// can implement to customize their byte representation, see `Object` package-level function.
@@ -149,6 +266,10 @@ var (
 // otherwise the DefaultMarshaler will be used instead.
 // Errors are pushed to the result, use the object's Marshal method to catch those when necessary.
 func Marshal(v interface{}) []byte {
+	return marshal(v, DefaultMarshaler)
+}
+
+func marshal(v interface{}, defaultMarshaler func(interface{}) ([]byte, error)) []byte {
 	if v == nil {
 		panic("nil assigment")
 	}
@@ -161,7 +282,7 @@ func Marshal(v interface{}) []byte {
 	if marshaler, ok := v.(MessageObjectMarshaler); ok {
 		body, err = marshaler.Marshal()
 	} else {
-		body, err = DefaultMarshaler(v)
+		body, err = defaultMarshaler(v)
 	}
 
 	if err != nil {
@@ -172,7 +293,8 @@ func Marshal(v interface{}) []byte {
 
 // Unmarshal unmarshals this Message's body to the "outPtr".
 // The "outPtr" must be a pointer to a value that can customize its decoded value
-// by implementing the `MessageObjectUnmarshaler`, otherwise the `DefaultUnmarshaler` will be used instead.
+// by implementing the `MessageObjectUnmarshaler`, otherwise the connection's `MessageCodec`
+// or, if not any, the `DefaultUnmarshaler` will be used instead.
 func (m *Message) Unmarshal(outPtr interface{}) error {
 	if outPtr == nil {
 		panic("nil assigment")
@@ -182,6 +304,10 @@ func (m *Message) Unmarshal(outPtr interface{}) error {
 		return unmarshaler.Unmarshal(m.Body)
 	}
 
+	if m.codec != nil {
+		return m.codec.Unmarshal(m.Body, outPtr)
+	}
+
 	return DefaultUnmarshaler(m.Body, outPtr)
 }
 
@@ -230,6 +356,18 @@ func genWait(isClientConn bool) string {
 	return wait
 }
 
+// waitScopeSeparator separates the parts of a connection's wait token, see `Conn#genWait`.
+const waitScopeSeparator = '-'
+
+// waitScope returns the connection's scope of a "wait" token, if any.
+func waitScope(wait string) string {
+	if idx := strings.LastIndexByte(wait, waitScopeSeparator); idx > 0 {
+		return wait[idx+1:]
+	}
+
+	return ""
+}
+
 // func genWaitConfirmation(wait string) string {
 // 	return string(waitIsConfirmationPrefix) + wait
 // }
@@ -240,9 +378,14 @@ func genWaitStackExchange(wait string) string {
 	}
 
 	// This is the second special character.
-	// If found, it is removed on the deserialization
-	// and Message.FromStackExchange is set to true.
-	return string(wait[0]+waitComesFromStackExchange) + wait[1:]
+	// If found, Message.FromStackExchange is set to true on the deserialization.
+	return wait[:1] + string(waitComesFromStackExchange) + wait[1:]
+}
+
+// stackExchangeWaitToken removes the second special character of a "wait" of `genWaitStackExchange`,
+// the result is the token of the stackexchange's waiter, see `StackExchange.NotifyAsk`.
+func stackExchangeWaitToken(wait string) string {
+	return wait[:1] + wait[2:]
 }
 
 var (
@@ -251,94 +394,392 @@ var (
 
 	messageSeparatorString = ";"
 	messageSeparator       = []byte(messageSeparatorString)
+	messageSeparatorByte   = messageSeparatorString[0]
 	// we use this because has zero chance to be part of end-developer's Message.Namespace, Room, Event, To and Err fields,
-	// semicolon has higher probability to exists on those values. See `escape` and `unescape`.
+	// semicolon has higher probability to exists on those values. See `writeEscaped` and `unescape`.
 	messageFieldSeparatorReplacement = "@%!semicolon@%!"
 )
 
-// called on `serializeMessage` to all message's fields except the body (and error).
-func escape(s string) string {
+// called on `DeserializeMessage` to all message's fields except the body (and error).
+func unescape(s string) string {
 	if len(s) == 0 {
 		return s
 	}
 
-	return strings.Replace(s, messageSeparatorString, messageFieldSeparatorReplacement, -1)
+	return strings.Replace(s, messageFieldSeparatorReplacement, messageSeparatorString, -1)
 }
 
-// called on `DeserializeMessage` to all message's fields except the body (and error).
-func unescape(s string) string {
-	if len(s) == 0 {
-		return s
+func serializeMessage(msg Message) []byte {
+	if msg.IsNative && msg.wait == "" {
+		return msg.Body
 	}
 
-	return strings.Replace(s, messageFieldSeparatorReplacement, messageSeparatorString, -1)
+	return serializeMessageTo(new(bytes.Buffer), msg)
 }
 
-func serializeMessage(msg Message) (out []byte) {
+// serializeMessageTo writes the serialized "msg" to the "buf" and returns its bytes,
+// they are valid until the next modification of the "buf".
+// Native messages are not written, their `Message.Body` is returned instead.
+func serializeMessageTo(buf *bytes.Buffer, msg Message) []byte {
 	if msg.IsNative && msg.wait == "" {
-		out = msg.Body
+		return msg.Body
+	}
+
+	return writeMessage(buf, msg, false)
+}
+
+// writeMessage writes the "msg" to the "buf" and returns its bytes,
+// if "exchange" is true then the StackExchange envelope fields are written too.
+func writeMessage(buf *bytes.Buffer, msg Message, exchange bool) []byte {
+	if msg.FromExplicit != "" {
+		if msg.wait != "" {
+			// this should never happen unless manual set of FromExplicit by end-developer which is forbidden by the higher level calls.
+			panic("msg.wait and msg.FromExplicit cannot work together")
+		}
+
+		msg.wait = msg.FromExplicit
+	}
+
+	// the extensions of most of the messages fit in that, so they are not allocated.
+	var ext [64]byte
+	writeOutput(buf, msg.wait, msg.Namespace, msg.Room, msg.Event, msg.Body, msg.Err, msg.isNoOp, appendExtensions(ext[:0], msg, exchange))
+	return buf.Bytes()
+}
+
+// bufferPool keeps the buffers which serialize the outgoing messages of `Conn#Write`.
+// A buffer is released right after the socket's write returns, so its bytes are never exposed
+// to the end-developer. The `Message.Body` is never pooled, incoming bodies are owned by the handlers.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// buffers that grew larger than that, i.e by a big message, are not kept in the pool.
+const maxPooledBufferSize = 64 * 1024
+
+func acquireBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func releaseBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// Message extensions are optional fields that are appended to the isNoOp segment
+// of the serialized message, e.g. "0?x=1589790000000". They are written only when set,
+// so the output of messages without them remains the same and older receivers
+// (which compare the whole segment with "1") are not affected unless a sender uses them.
+const messageExtensionsPrefix = '?'
+
+const (
+	extensionSeparator = '&'
+	// Message.Expiry.
+	extensionExpiry = "x"
+	// Message.SentAt.
+	extensionSentAt = "s"
+	// compressed Message.Body, see `Conn#Write`.
+	extensionCompressed = "z"
+	// chunk of a large Message.Body, see `NSConn#EmitLarge`.
+	extensionChunk = "c"
+	// Message.TraceID.
+	extensionTraceID = "i"
+
+	// StackExchange envelope only, see `Message.SerializeExchange`.
+	extensionFrom   = "f"
+	extensionOrigin = "o"
+	extensionTo     = "t"
+	extensionBinary = "b"
+	extensionNative = "n"
+	extensionForced = "F"
+	extensionLocal  = "L"
+
+	// the publish of a `CompositeStackExchange`.
+	extensionPublishID = "p"
+)
+
+// appendExtensions appends the serialized extensions of the "msg" to "ext" and returns the result.
+func appendExtensions(ext []byte, msg Message, exchange bool) []byte {
+	if msg.Expiry > 0 {
+		ext = strconv.AppendInt(appendExtensionKey(ext, extensionExpiry), msg.Expiry, 10)
+	}
+
+	if msg.SentAt > 0 {
+		ext = strconv.AppendInt(appendExtensionKey(ext, extensionSentAt), msg.SentAt, 10)
+	}
+
+	if msg.compressed {
+		ext = appendExtension(ext, extensionCompressed, "1")
+	}
+
+	if msg.chunk.id != "" {
+		ext = appendExtension(ext, extensionChunk, msg.chunk.String())
+	}
+
+	if msg.TraceID != "" {
+		ext = appendExtension(ext, extensionTraceID, msg.TraceID)
+	}
+
+	if !exchange {
+		return ext
+	}
+
+	if msg.from != "" {
+		ext = appendExtension(ext, extensionFrom, msg.from)
+	}
+
+	if msg.origin != "" {
+		ext = appendExtension(ext, extensionOrigin, msg.origin)
+	}
+
+	if msg.To != "" {
+		ext = appendExtension(ext, extensionTo, msg.To)
+	}
+
+	if msg.publishID != "" {
+		ext = appendExtension(ext, extensionPublishID, msg.publishID)
+	}
+
+	for _, flag := range []struct {
+		key   string
+		value bool
+	}{
+		{extensionBinary, msg.SetBinary},
+		{extensionNative, msg.IsNative},
+		{extensionForced, msg.IsForced},
+		{extensionLocal, msg.IsLocal},
+	} {
+		if flag.value {
+			ext = appendExtension(ext, flag.key, "1")
+		}
+	}
+
+	return ext
+}
+
+func appendExtension(ext []byte, key, value string) []byte {
+	return append(appendExtensionKey(ext, key), url.QueryEscape(value)...)
+}
+
+func appendExtensionKey(ext []byte, key string) []byte {
+	if len(ext) == 0 {
+		ext = append(ext, messageExtensionsPrefix)
 	} else {
-		if msg.FromExplicit != "" {
-			if msg.wait != "" {
-				// this should never happen unless manual set of FromExplicit by end-developer which is forbidden by the higher level calls.
-				panic("msg.wait and msg.FromExplicit cannot work together")
+		ext = append(ext, extensionSeparator)
+	}
+
+	ext = append(ext, key...)
+	return append(ext, '=')
+}
+
+// splits the isNoOp segment to its value and the extensions (without the prefix), if any.
+func splitExtensions(segment []byte) ([]byte, []byte) {
+	if idx := bytes.IndexByte(segment, messageExtensionsPrefix); idx >= 0 {
+		return segment[:idx], segment[idx+1:]
+	}
+
+	return segment, nil
+}
+
+// parseExtensions fills the "msg" fields from the "ext" key-value pairs,
+// unknown keys and invalid values are ignored.
+// The StackExchange envelope fields are filled only if "exchange" is true,
+// they are never accepted from a remote client.
+func parseExtensions(ext []byte, msg *Message, exchange bool) {
+	for len(ext) > 0 {
+		var pair []byte
+		if idx := bytes.IndexByte(ext, extensionSeparator); idx >= 0 {
+			pair, ext = ext[:idx], ext[idx+1:]
+		} else {
+			pair, ext = ext, nil
+		}
+
+		idx := bytes.IndexByte(pair, '=')
+		if idx <= 0 {
+			continue
+		}
+
+		value := string(pair[idx+1:])
+		if bytes.ContainsAny(pair[idx+1:], "%+") {
+			var err error
+			if value, err = url.QueryUnescape(value); err != nil {
+				continue
 			}
+		}
 
-			msg.wait = msg.FromExplicit
+		key := pair[:idx]
+		switch string(key) {
+		case extensionExpiry:
+			msg.Expiry, _ = strconv.ParseInt(value, 10, 64)
+			continue
+		case extensionSentAt:
+			msg.SentAt, _ = strconv.ParseInt(value, 10, 64)
+			continue
+		case extensionCompressed:
+			msg.compressed = value == "1"
+			continue
+		case extensionChunk:
+			msg.chunk, _ = parseChunkHeader(value)
+			continue
+		case extensionTraceID:
+			msg.TraceID = value
+			continue
 		}
-		out = serializeOutput(msg.wait, escape(msg.Namespace), escape(msg.Room), escape(msg.Event), msg.Body, msg.Err, msg.isNoOp)
-	}
 
-	return out
+		if !exchange {
+			continue
+		}
+
+		switch string(key) {
+		case extensionFrom:
+			msg.from = value
+		case extensionOrigin:
+			msg.origin = value
+		case extensionTo:
+			msg.To = value
+		case extensionPublishID:
+			msg.publishID = value
+		case extensionBinary:
+			msg.SetBinary = value == "1"
+		case extensionNative:
+			msg.IsNative = value == "1"
+		case extensionForced:
+			msg.IsForced = value == "1"
+		case extensionLocal:
+			msg.IsLocal = value == "1"
+		}
+	}
 }
 
-func serializeOutput(wait, namespace, room, event string,
+// writeOutput writes the message fields to the "buf", the namespace, room and event are escaped.
+// The number of fields should match the deserializer's, see `validMessageSepCount`.
+func writeOutput(buf *bytes.Buffer, wait, namespace, room, event string,
 	body []byte,
 	err error,
 	isNoOp bool,
-) []byte {
+	ext []byte,
+) {
 
 	var (
 		isErrorByte = falseByte
 		isNoOpByte  = falseByte
-		waitByte    = []byte{}
+		errText     string
 	)
 
-	if err != nil {
-		if b, ok := isReply(err); ok {
-			body = b
-		} else {
-			body = []byte(err.Error())
-			isErrorByte = trueByte
-		}
+	body, errText, isError := outputError(body, err)
+	if isError {
+		isErrorByte = trueByte
 	}
 
 	if isNoOp {
 		isNoOpByte = trueByte
 	}
 
-	if wait != "" {
-		waitByte = []byte(wait)
+	buf.Grow(len(wait) + len(namespace) + len(room) + len(event) + len(ext) + len(body) + len(errText) + validMessageSepCount + 1)
+
+	buf.WriteString(wait)
+	buf.WriteByte(messageSeparatorByte)
+	writeEscaped(buf, namespace)
+	buf.WriteByte(messageSeparatorByte)
+	writeEscaped(buf, room)
+	buf.WriteByte(messageSeparatorByte)
+	writeEscaped(buf, event)
+	buf.WriteByte(messageSeparatorByte)
+	buf.Write(isErrorByte)
+	buf.WriteByte(messageSeparatorByte)
+	buf.Write(isNoOpByte)
+	buf.Write(ext)
+	buf.WriteByte(messageSeparatorByte)
+	buf.Write(body)
+	buf.WriteString(errText)
+}
+
+// outputError returns the body and the error text of an outgoing message
+// and reports whether it should be marked as error, see `Reply` and `NewError`.
+func outputError(body []byte, err error) ([]byte, string, bool) {
+	if err == nil {
+		return body, "", false
 	}
 
-	msg := bytes.Join([][]byte{ // this number of fields should match the deserializer's, see `validMessageSepCount`.
-		waitByte,
-		[]byte(namespace),
-		[]byte(room),
-		[]byte(event),
-		isErrorByte,
-		isNoOpByte,
-		body,
-	}, messageSeparator)
+	if b, ok := isReply(err); ok {
+		return b, "", false
+	}
 
-	return msg
+	if typed, ok := asError(err); ok {
+		return encodeError(typed), "", true
+	}
+
+	return nil, err.Error(), true
+}
+
+// asError acts like the errors.As for the `*Error` but
+// it does not allocate when the "err" is not wrapped.
+func asError(err error) (*Error, bool) {
+	if typed, ok := err.(*Error); ok {
+		return typed, true
+	}
+
+	switch err.(type) {
+	case interface{ Unwrap() error }, interface{ Unwrap() []error }, interface{ As(interface{}) bool }:
+		var typed *Error
+		if errors.As(err, &typed) {
+			return typed, true
+		}
+	}
+
+	return nil, false
+}
+
+// called on `writeOutput` to all message's fields except the body (and error),
+// it writes the "s" to the "buf" with its separators replaced.
+func writeEscaped(buf *bytes.Buffer, s string) {
+	for {
+		idx := strings.IndexByte(s, messageSeparatorByte)
+		if idx == -1 {
+			buf.WriteString(s)
+			return
+		}
+
+		buf.WriteString(s[:idx])
+		buf.WriteString(messageFieldSeparatorReplacement)
+		s = s[idx+1:]
+	}
 }
 
 // DeserializeMessage accepts a serialized message []byte
 // and returns a neffos Message.
 // When allowNativeMessages only Body is filled and check about message format is skipped.
+// The fields are checked against the `DefaultMessageLimits`.
 func DeserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shouldHandleOnlyNativeMessages bool) Message {
-	wait, namespace, room, event, body, err, isNoOp, isInvalid := deserializeInput(b, allowNativeMessages, shouldHandleOnlyNativeMessages)
+	msg := deserializeMessage(msgTyp, b, allowNativeMessages, shouldHandleOnlyNativeMessages, false, &DefaultMessageLimits)
+	decompressMessage(&msg, 0)
+	return msg
+}
+
+// DeserializeExchangeMessage returns a Message from a StackExchange envelope,
+// see `Message.SerializeExchange`. The `Message.FromStackExchange` is always true.
+// It accepts envelopes of any version, see `ExchangeEnvelopeVersion`.
+func DeserializeExchangeMessage(b []byte) Message {
+	_, b, err := readExchangeEnvelope(b)
+	if err != nil {
+		return Message{isInvalid: true, FromStackExchange: true}
+	}
+	// the exchange is trusted, its envelope carries more fields.
+	msg := deserializeMessage(TextMessage, b, false, false, true, nil)
+	decompressMessage(&msg, 0)
+	msg.FromStackExchange = true
+	return msg
+}
+
+// deserializeMessage returns a Message from "b",
+// a nil "limits" means that the fields are not limited.
+func deserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shouldHandleOnlyNativeMessages, exchange bool, limits *MessageLimits) Message {
+	wait, namespace, room, event, body, err, isNoOp, isInvalid, ext := deserializeInput(b, allowNativeMessages, shouldHandleOnlyNativeMessages, limits)
 
 	fromExplicit := ""
 	if isServerConnID(wait) {
@@ -346,14 +787,11 @@ func DeserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shoul
 		wait = ""
 	}
 
+	// the second special char is kept, the client replies with the same wait
+	// so the server can notify the stackexchange's waiter, see `stackExchangeWaitToken`.
 	fromStackExchange := len(wait) > 2 && wait[1] == waitComesFromStackExchange
-	if fromStackExchange {
-		// remove the second special char, we need to reform it,
-		// this wait token is compared to the waiter side as it's without the information about stackexchnage.
-		wait = string(wait[0]) + wait[2:]
-	}
 
-	return Message{
+	msg := Message{
 		wait:              wait,
 		Namespace:         unescape(namespace),
 		Room:              unescape(room),
@@ -373,11 +811,76 @@ func DeserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shoul
 		locked:            false,
 		SetBinary:         msgTyp == BinaryMessage,
 	}
+
+	if len(ext) > 0 {
+		if limits != nil && bytes.Count(ext, []byte{extensionSeparator}) >= limits.maxExtensions() {
+			return Message{isInvalid: true}
+		}
+
+		parseExtensions(ext, &msg, exchange)
+	}
+
+	return msg
+}
+
+// MessageLimits are the limits of the fields of an incoming message.
+// They are checked before the fields are parsed, a message which exceeds them is invalid
+// (or native, if native messages are allowed) and it is dropped.
+// Zero values fallback to the `DefaultMessageLimits`.
+// See `Server#SetMessageLimits` and `WithMessageLimits`.
+type MessageLimits struct {
+	// MaxWaitLength is the maximum length of the wait token.
+	MaxWaitLength int
+	// MaxNamespaceLength is the maximum length of the namespace.
+	MaxNamespaceLength int
+	// MaxRoomLength is the maximum length of the room.
+	MaxRoomLength int
+	// MaxEventLength is the maximum length of the event.
+	MaxEventLength int
+	// MaxExtensionsLength is the maximum length of the message extensions.
+	MaxExtensionsLength int
+	// MaxExtensions is the maximum number of the message extensions.
+	MaxExtensions int
+}
+
+// DefaultMessageLimits are the default, generous, limits of an incoming message's fields.
+var DefaultMessageLimits = MessageLimits{
+	MaxWaitLength:       256,
+	MaxNamespaceLength:  1024,
+	MaxRoomLength:       1024,
+	MaxEventLength:      1024,
+	MaxExtensionsLength: 4096,
+	MaxExtensions:       32,
+}
+
+func limitOrDefault(limit, def int) int {
+	if limit <= 0 {
+		return def
+	}
+
+	return limit
+}
+
+// fields returns the maximum lengths of the fields, except the body, by their position.
+func (l *MessageLimits) fields() [validMessageSepCount - 1]int {
+	return [validMessageSepCount - 1]int{
+		limitOrDefault(l.MaxWaitLength, DefaultMessageLimits.MaxWaitLength),
+		limitOrDefault(l.MaxNamespaceLength, DefaultMessageLimits.MaxNamespaceLength),
+		limitOrDefault(l.MaxRoomLength, DefaultMessageLimits.MaxRoomLength),
+		limitOrDefault(l.MaxEventLength, DefaultMessageLimits.MaxEventLength),
+		len(trueByte),
+		// the isNoOp field and its extensions.
+		len(trueByte) + 1 + limitOrDefault(l.MaxExtensionsLength, DefaultMessageLimits.MaxExtensionsLength),
+	}
+}
+
+func (l *MessageLimits) maxExtensions() int {
+	return limitOrDefault(l.MaxExtensions, DefaultMessageLimits.MaxExtensions)
 }
 
 const validMessageSepCount = 7
 
-var knownErrors = []error{ErrBadNamespace, ErrBadRoom, ErrWrite, ErrInvalidPayload}
+var knownErrors = []error{ErrBadNamespace, ErrBadRoom, ErrWrite, ErrInvalidPayload, ErrIncompleteTransfer}
 
 // RegisterKnownError registers an error that it's "known" to both server and client sides.
 // This simply adds an error to a list which, if its static text matches
@@ -398,6 +901,10 @@ func RegisterKnownError(err error) {
 }
 
 func resolveError(errorText string) error {
+	if typed, ok := decodeError(errorText); ok {
+		return typed
+	}
+
 	for _, knownErr := range knownErrors {
 		if resolver, ok := knownErr.(interface {
 			ResolveError(errorText string) bool
@@ -415,7 +922,7 @@ func resolveError(errorText string) error {
 	return errors.New(errorText)
 }
 
-func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessages bool) ( // go-lint: ignore line
+func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessages bool, limits *MessageLimits) ( // go-lint: ignore line
 	wait,
 	namespace,
 	room,
@@ -424,6 +931,7 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 	err error,
 	isNoOp bool,
 	isInvalid bool,
+	ext []byte,
 ) {
 
 	if len(b) == 0 {
@@ -437,9 +945,9 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 		return
 	}
 
-	// Note: Go's SplitN returns the remainder in[6] but JavasSript's string.split behaves differently.
-	dts := bytes.SplitN(b, messageSeparator, validMessageSepCount)
-	if len(dts) != validMessageSepCount {
+	// Note: like Go's SplitN, the remainder is in dts[6] but JavasSript's string.split behaves differently.
+	var dts [validMessageSepCount][]byte
+	if !splitMessage(b, &dts, limits) {
 		if !allowNativeMessages {
 			isInvalid = true
 			return
@@ -450,12 +958,15 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 		return
 	}
 
-	wait = string(dts[0])
-	namespace = string(dts[1])
-	room = string(dts[2])
-	event = string(dts[3])
+	// the wait, namespace, room and event fields are converted
+	// to a single string, their fields are slices of it.
+	header := string(b[:len(dts[0])+len(dts[1])+len(dts[2])+len(dts[3])+3])
+	wait, header = header[:len(dts[0])], header[len(dts[0])+1:]
+	namespace, header = header[:len(dts[1])], header[len(dts[1])+1:]
+	room, event = header[:len(dts[2])], header[len(dts[2])+1:]
 	isError := bytes.Equal(dts[4], trueByte)
-	isNoOp = bytes.Equal(dts[5], trueByte)
+	noOp, ext := splitExtensions(dts[5])
+	isNoOp = bytes.Equal(noOp, trueByte)
 	if b := dts[6]; len(b) > 0 {
 		if isError {
 			errorText := string(b)
@@ -468,6 +979,35 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 	return
 }
 
+// splitMessage acts like the bytes.SplitN(b, messageSeparator, validMessageSepCount)
+// but it fills the "dts" array instead of allocating a new slice.
+// It reports false if "b" has less fields than `validMessageSepCount`
+// or a field is longer than its limit, the separators are searched up to the limits.
+func splitMessage(b []byte, dts *[validMessageSepCount][]byte, limits *MessageLimits) bool {
+	var max [validMessageSepCount - 1]int
+	if limits != nil {
+		max = limits.fields()
+	}
+
+	for i := 0; i < validMessageSepCount-1; i++ {
+		field := b
+		if max[i] > 0 && len(field) > max[i]+1 {
+			field = field[:max[i]+1]
+		}
+
+		idx := bytes.IndexByte(field, messageSeparatorByte)
+		if idx == -1 {
+			return false
+		}
+
+		dts[i] = b[:idx:idx]
+		b = b[idx+1:]
+	}
+
+	dts[validMessageSepCount-1] = b
+	return true
+}
+
 func genEmptyReplyToWait(wait string) []byte {
 	return append([]byte(wait), bytes.Repeat(messageSeparator, validMessageSepCount-1)...)
 }
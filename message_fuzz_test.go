@@ -0,0 +1,84 @@
+//go:build go1.18
+
+package neffos
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The seed corpus is at the testdata/fuzz/FuzzDeserializeMessage directory, run with:
+// go test -run=XXX -fuzz=FuzzDeserializeMessage
+func FuzzDeserializeMessage(f *testing.F) {
+	for _, bm := range benchMessages {
+		f.Add(serializeMessage(bm.msg), false)
+	}
+	f.Add([]byte(""), false)
+	f.Add([]byte("$1"), false)
+	f.Add([]byte(";;;;;;"), true)
+	f.Add([]byte("native message"), true)
+
+	limits := DefaultMessageLimits
+	f.Fuzz(func(t *testing.T, b []byte, allowNativeMessages bool) {
+		for _, msgTyp := range []MessageType{TextMessage, BinaryMessage} {
+			msg := deserializeMessage(msgTyp, b, allowNativeMessages, false, false, &limits)
+			decompressMessage(&msg, 1024*1024)
+
+			if msg.isInvalid {
+				continue
+			}
+
+			if msg.IsNative {
+				if !allowNativeMessages || !bytes.Equal(msg.Body, b) {
+					t.Fatalf("unexpected native message: %#+v", msg)
+				}
+				continue
+			}
+
+			if len(msg.wait) > limits.MaxWaitLength || len(msg.Event) > limits.MaxEventLength ||
+				len(msg.Namespace) > limits.MaxNamespaceLength || len(msg.Room) > limits.MaxRoomLength {
+				t.Fatalf("expected fields to be limited but got: %#+v", msg)
+			}
+
+			if strings.Contains(msg.wait, messageSeparatorString) {
+				t.Fatalf("expected the wait token to be a single field but got: %q", msg.wait)
+			}
+
+			if (msgTyp == BinaryMessage) != msg.SetBinary {
+				t.Fatalf("expected SetBinary to match the message type")
+			}
+		}
+	})
+}
+
+// The seed corpus is at the testdata/fuzz/FuzzConnDeserializeMessage directory, run with:
+// go test -run=XXX -fuzz=FuzzConnDeserializeMessage
+func FuzzConnDeserializeMessage(f *testing.F) {
+	for _, bm := range benchMessages {
+		f.Add(serializeMessage(bm.msg), false, false)
+	}
+	f.Add([]byte(`{"namespace":"default","event":"chat","body":"aGk="}`), false, true)
+	f.Add([]byte(`{"wait":`), true, true)
+	f.Add([]byte(";unknown;;chat;0;0;body"), true, false)
+
+	nss := Namespaces{"": Events{OnNativeMessage: func(*NSConn, Message) error { return nil }}, "default": Events{}}
+	f.Fuzz(func(t *testing.T, b []byte, strict, jsonProtocol bool) {
+		socket, _ := NewPipeSockets()
+		c := newConn(socket, nss)
+		c.strictParsing = strict
+		c.jsonProtocol = jsonProtocol
+		c.nativeUnknownNamespaces = true
+		c.maxMessageSize = 1024
+
+		for _, msgTyp := range []MessageType{TextMessage, BinaryMessage} {
+			msg := c.DeserializeMessage(msgTyp, b)
+			// a body larger than the payload is a decompressed one.
+			if len(msg.Body) > len(b) && int64(len(msg.Body)) > c.maxMessageSize {
+				t.Fatalf("expected the decompressed body to be limited but got: %d bytes", len(msg.Body))
+			}
+
+			DeserializeMessage(msgTyp, b, strict, jsonProtocol)
+		}
+	})
+}
@@ -2,9 +2,14 @@ package neffos
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestMessageSerialization(t *testing.T) {
@@ -140,3 +145,502 @@ func TestMessageSerialization(t *testing.T) {
 		t.Fatalf("expected a unescaped message to be:\n%#+v\n\tbut got:\n%#+v", msg, msgGot)
 	}
 }
+
+func TestMessageExpiry(t *testing.T) {
+	msg := Message{
+		Namespace: "default",
+		Event:     "typing",
+		Body:      []byte("body"),
+		Expiry:    1589790000000,
+	}
+
+	expected := []byte(";default;;typing;0;0?x=1589790000000;body")
+	got := serializeMessage(msg)
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("expected %s but got %s", expected, got)
+	}
+
+	if msgGot := DeserializeMessage(TextMessage, got, false, false); !reflect.DeepEqual(msg, msgGot) {
+		t.Fatalf("expected a message with expiry to be:\n%#+v\n\tbut got:\n%#+v", msg, msgGot)
+	}
+
+	if !msg.isExpired(msg.Expiry+1, 0) {
+		t.Fatalf("expected message to be expired")
+	}
+
+	if msg.isExpired(msg.Expiry+1, time.Second) {
+		t.Fatalf("expected message not to be expired because of the tolerance")
+	}
+
+	if msg.Expiry = 0; msg.isExpired(nowMillis(), 0) {
+		t.Fatalf("expected message without expiry to never expire")
+	}
+}
+
+func TestMessageTypedError(t *testing.T) {
+	typedErr := NewError(403, "forbidden; no access", []byte(`{"reason":"role"}`))
+
+	got := serializeMessage(Message{Namespace: "default", Event: "chat", Err: typedErr})
+	msg := DeserializeMessage(TextMessage, got, false, false)
+
+	var remoteErr *Error
+	if !errors.As(msg.Err, &remoteErr) {
+		t.Fatalf("expected a typed error but got: %#+v", msg.Err)
+	}
+
+	if expected, got := 403, remoteErr.Code(); expected != got {
+		t.Fatalf("expected code: %d but got: %d", expected, got)
+	}
+
+	if expected, got := typedErr.Error(), remoteErr.Error(); expected != got {
+		t.Fatalf("expected message: %s but got: %s", expected, got)
+	}
+
+	if expected, got := []byte(`{"reason":"role"}`), remoteErr.Data(); !bytes.Equal(expected, got) {
+		t.Fatalf("expected data: %s but got: %s", expected, got)
+	}
+
+	if !errors.Is(msg.Err, typedErr) {
+		t.Fatalf("expected remote error to match the local one")
+	}
+
+	// plain errors keep working as strings.
+	got = serializeMessage(Message{Namespace: "default", Event: "chat", Err: errors.New("plain")})
+	if msg = DeserializeMessage(TextMessage, got, false, false); msg.Err == nil || msg.Err.Error() != "plain" {
+		t.Fatalf("expected a plain error but got: %#+v", msg.Err)
+	}
+
+	if _, ok := msg.Err.(*Error); ok {
+		t.Fatalf("expected a plain error not to be a typed one")
+	}
+}
+
+var benchMessage = Message{
+	wait:      "$1589790000000",
+	Namespace: "default",
+	Room:      "room;1",
+	Event:     "chat",
+	Body:      bytes.Repeat([]byte("body;"), 64),
+}
+
+// benchMessages are the messages of the serialize and deserialize benchmarks.
+var benchMessages = []struct {
+	name string
+	msg  Message
+}{
+	{"small text", Message{Namespace: "default", Event: "chat", Body: []byte("hello")}},
+	{"escaped text", benchMessage},
+	{"large binary", Message{Namespace: "default", Event: "file", Body: bytes.Repeat([]byte{0, 1, 2, 3}, 16*1024), SetBinary: true}},
+	{"error", Message{wait: "$1589790000000", Namespace: "default", Event: "chat", Err: ErrBadRoom}},
+	{"extensions", Message{Namespace: "default", Event: "typing", Body: []byte("1"), Expiry: 1589790005000, SentAt: 1589790000000}},
+}
+
+func BenchmarkSerializeMessage(b *testing.B) {
+	for _, bm := range benchMessages {
+		msg := bm.msg
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				serializeMessage(msg)
+			}
+		})
+	}
+}
+
+func BenchmarkDeserializeMessage(b *testing.B) {
+	for _, bm := range benchMessages {
+		payload := serializeMessage(bm.msg)
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				DeserializeMessage(TextMessage, payload, false, false)
+			}
+		})
+	}
+}
+
+// discardSocket is a `Socket` which drops everything written to it.
+type discardSocket struct{ Socket }
+
+func (discardSocket) WriteBinary(body []byte, timeout time.Duration) error { return nil }
+func (discardSocket) WriteText(body []byte, timeout time.Duration) error   { return nil }
+
+func BenchmarkConnWrite(b *testing.B) {
+	c := newConn(discardSocket{}, Namespaces{"default": Events{}})
+	c.connectedNamespaces["default"] = newNSConn(c, "default", Events{})
+	c.readiness.unwait(nil)
+	msg := benchMessage
+	msg.Room = ""
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Write(msg)
+	}
+}
+
+// recordSocket is a `Socket` which keeps a copy of everything written to it.
+type recordSocket struct {
+	Socket
+	mu      sync.Mutex
+	written [][]byte
+}
+
+func (s *recordSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.WriteText(body, timeout)
+}
+
+func (s *recordSocket) WriteText(body []byte, timeout time.Duration) error {
+	s.mu.Lock()
+	s.written = append(s.written, append([]byte(nil), body...))
+	s.mu.Unlock()
+	return nil
+}
+
+func TestConnWritePooledBuffers(t *testing.T) {
+	var (
+		socket  = new(recordSocket)
+		c       = newConn(socket, Namespaces{"default": Events{}})
+		wg      sync.WaitGroup
+		writers = 8
+		writes  = 200
+	)
+
+	c.connectedNamespaces["default"] = newNSConn(c, "default", Events{})
+	c.readiness.unwait(nil)
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				// different sizes to make sure a pooled buffer never leaks the previous contents.
+				body := bytes.Repeat([]byte{byte('a' + i)}, (i+1)*(j%10+1))
+				if !c.Write(Message{Namespace: "default", Event: fmt.Sprintf("event;%d", i), Body: body}) {
+					t.Errorf("expected write to succeed")
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if expected, got := writers*writes, len(socket.written); expected != got {
+		t.Fatalf("expected %d written messages but got %d", expected, got)
+	}
+
+	for _, b := range socket.written {
+		msg := DeserializeMessage(TextMessage, b, false, false)
+		var i int
+		if _, err := fmt.Sscanf(msg.Event, "event;%d", &i); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(msg.Body) == 0 || len(msg.Body)%(i+1) != 0 || !bytes.Equal(msg.Body, bytes.Repeat([]byte{byte('a' + i)}, len(msg.Body))) {
+			t.Fatalf("unexpected message body of %s: %s", msg.Event, msg.Body)
+		}
+	}
+}
+
+// preparedSocket is a `SocketPreparedWriter` which records its prepared messages.
+type preparedSocket struct {
+	recordSocket
+	prepared *int32
+}
+
+func (s *preparedSocket) PrepareMessage(data []byte, binary bool) (interface{}, error) {
+	atomic.AddInt32(s.prepared, 1)
+	return append([]byte(nil), data...), nil
+}
+
+func (s *preparedSocket) WritePrepared(pm interface{}, timeout time.Duration) error {
+	return s.recordSocket.WriteText(append([]byte("prepared:"), pm.([]byte)...), timeout)
+}
+
+func TestConnWritePrepared(t *testing.T) {
+	var (
+		prepared int32
+		sockets  []*preparedSocket
+		conns    []*Conn
+	)
+
+	for i := 0; i < 3; i++ {
+		socket := &preparedSocket{prepared: &prepared}
+		c := newConn(socket, Namespaces{"default": Events{}})
+		c.connectedNamespaces["default"] = newNSConn(c, "default", Events{})
+		c.readiness.unwait(nil)
+
+		sockets = append(sockets, socket)
+		conns = append(conns, c)
+	}
+
+	msg := Message{Namespace: "default", Event: "chat", Body: []byte("data"), prepared: new(preparedMessage)}
+	for _, c := range conns {
+		c.Write(msg)
+	}
+
+	if expected, got := int32(1), prepared; expected != got {
+		t.Fatalf("expected the message to be prepared %d time(s) but got %d", expected, got)
+	}
+
+	expected := append([]byte("prepared:"), msg.Serialize()...)
+	for _, socket := range sockets {
+		if got := socket.written; len(got) != 1 || !bytes.Equal(expected, got[0]) {
+			t.Fatalf("expected the prepared message: %q but got: %q", expected, got)
+		}
+	}
+
+	// a connection which writes different data, i.e its own generated trace ID, writes it as it is.
+	conns[0].generateTraceID = true
+	conns[0].Write(msg)
+	if got := sockets[0].written[1]; bytes.HasPrefix(got, []byte("prepared:")) || !bytes.Contains(got, []byte(traceIDPrefix)) {
+		t.Fatalf("expected the message to be written as it is but got: %q", got)
+	}
+
+	// not broadcasted.
+	msg.prepared = nil
+	conns[1].Write(msg)
+	if got := sockets[1].written[1]; !bytes.Equal(msg.Serialize(), got) {
+		t.Fatalf("expected the message to be written as it is but got: %q", got)
+	}
+}
+
+func TestMessageExchangeEnvelope(t *testing.T) {
+	msg := Message{
+		wait:      "$1589790000000",
+		Namespace: "default",
+		Room:      "room;1",
+		Event:     "chat",
+		Body:      []byte("body;data"),
+		from:      "conn;ID",
+		origin:    "instanceID",
+		To:        "to&ID",
+		SetBinary: true,
+		IsNative:  true,
+		IsForced:  true,
+		IsLocal:   true,
+		Expiry:    1589790000000,
+	}
+
+	got := DeserializeExchangeMessage(msg.SerializeExchange())
+	msg.FromStackExchange = true
+	if !reflect.DeepEqual(msg, got) {
+		t.Fatalf("expected exchange message to be:\n%#+v\n\tbut got:\n%#+v", msg, got)
+	}
+
+	// the envelope fields are never sent to or accepted from the clients.
+	if serialized := msg.Serialize(); bytes.Contains(serialized, []byte("conn")) {
+		t.Fatalf("expected envelope fields to not be serialized but got: %s", serialized)
+	}
+
+	got = DeserializeMessage(TextMessage, msg.SerializeExchange(), false, false)
+	if got.from != "" || got.origin != "" || got.To != "" || got.SetBinary || got.IsNative || got.IsForced || got.IsLocal {
+		t.Fatalf("expected envelope fields to be ignored but got: %#+v", got)
+	}
+}
+
+func TestMessageExchangeEnvelopeVersions(t *testing.T) {
+	expected := Message{
+		Namespace:         "default",
+		Room:              "room",
+		Event:             "chat",
+		Body:              []byte("body;data"),
+		from:              "connID",
+		To:                "toID",
+		IsForced:          true,
+		FromStackExchange: true,
+	}
+
+	// produced by a simulated older serializer which writes version 0, unversioned, envelopes.
+	legacy := []byte(";default;room;chat;0;0?f=connID&t=toID&F=1;body;data")
+	if got := DeserializeExchangeMessage(legacy); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected version 0 envelope to be:\n%#+v\n\tbut got:\n%#+v", expected, got)
+	}
+
+	// produced by a simulated newer serializer, its unknown extensions are skipped.
+	newer := append([]byte{exchangeEnvelopeMagic, ExchangeEnvelopeVersion + 1},
+		";default;room;chat;0;0?f=connID&t=toID&F=1&newfield=value;body;data"...)
+	if got := DeserializeExchangeMessage(newer); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected newer envelope to be:\n%#+v\n\tbut got:\n%#+v", expected, got)
+	}
+
+	b := expected.SerializeExchange()
+	if version, _, _ := readExchangeEnvelope(b); version != ExchangeEnvelopeVersion {
+		t.Fatalf("expected envelope of version: %d but got: %d", ExchangeEnvelopeVersion, version)
+	}
+	if got := DeserializeExchangeMessage(b); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected envelope to be:\n%#+v\n\tbut got:\n%#+v", expected, got)
+	}
+
+	// rolling upgrade from servers before the versioned envelope.
+	ExchangeEnvelopeWriteVersion = 0
+	defer func() { ExchangeEnvelopeWriteVersion = ExchangeEnvelopeVersion }()
+
+	b = expected.SerializeExchange()
+	if !bytes.Equal(b, legacy) {
+		t.Fatalf("expected version 0 envelope: %q but got: %q", legacy, b)
+	}
+}
+
+func TestConnStaleReply(t *testing.T) {
+	events := Events{
+		"ask": func(c *NSConn, msg Message) error {
+			t.Fatalf("a reply should not be fired as event: %s", msg.Body)
+			return nil
+		},
+	}
+
+	newClientConn := func() *Conn {
+		c := newConn(new(recordSocket), Namespaces{"default": events})
+		c.connectedNamespaces["default"] = newNSConn(c, "default", events)
+		c.readiness.unwait(nil)
+		return c
+	}
+
+	// the connection of the previous session and the current one, after a reconnect.
+	previous, current := newClientConn(), newClientConn()
+
+	wait := current.genWait()
+	ch := make(chan Message, 1)
+	current.waitingMessages[wait] = ch
+
+	// a late reply of the previous session which, without the scope, would match the current waiter.
+	staleWait := strings.TrimSuffix(wait, current.waitScope) + previous.waitScope
+	stale := serializeMessage(Message{wait: staleWait, Namespace: "default", Event: "ask", Body: []byte("previous")})
+
+	if err := current.HandlePayload(TextMessage, stale); err != ErrStaleReply {
+		t.Fatalf("expected error: %v but got: %v", ErrStaleReply, err)
+	}
+
+	if expected, got := uint64(1), current.counters.snapshot().StaleReplies; expected != got {
+		t.Fatalf("expected %d stale replies but got: %d", expected, got)
+	}
+
+	reply := serializeMessage(Message{wait: wait, Namespace: "default", Event: "ask", Body: []byte("current")})
+	if err := current.HandlePayload(TextMessage, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := <-ch; string(msg.Body) != "current" {
+		t.Fatalf("expected the reply of the current session but got: %s", msg.Body)
+	}
+
+	// incoming asks of the remote side are not replies, their scope is not checked.
+	if current.isStaleReply("1589790000000000000-1-"+previous.waitScope, true) {
+		t.Fatalf("expected a remote wait token to be accepted")
+	}
+}
+
+func TestMessageSentAt(t *testing.T) {
+	sentAt := nowMillis() - 1500
+	msg := Message{Namespace: "default", Event: "chat", SentAt: sentAt}
+
+	if got := DeserializeMessage(TextMessage, msg.Serialize(), false, false); got.SentAt != sentAt {
+		t.Fatalf("expected sent at: %d but got: %d", sentAt, got.SentAt)
+	}
+
+	got := DeserializeExchangeMessage(msg.SerializeExchange())
+	if got.SentAt != sentAt {
+		t.Fatalf("expected sent at: %d through the exchange envelope but got: %d", sentAt, got.SentAt)
+	}
+
+	if age := got.Age(); age < 1500*time.Millisecond || age > time.Minute {
+		t.Fatalf("unexpected age: %s", age)
+	}
+
+	if age := (&Message{}).Age(); age != 0 {
+		t.Fatalf("expected zero age of a not stamped message but got: %s", age)
+	}
+
+	if b := (Message{Namespace: "default", Event: "chat"}).Serialize(); bytes.IndexByte(b, messageExtensionsPrefix) != -1 {
+		t.Fatalf("expected no extensions when not stamped but got: %s", b)
+	}
+}
+
+func TestSerializeMessageFormat(t *testing.T) {
+	expected := []string{
+		";default;;chat;0;0;hello",
+		"$1589790000000;default;room@%!semicolon@%!1;chat;0;0;" + strings.Repeat("body;", 64),
+		"",
+		"$1589790000000;default;;chat;1;0;bad room",
+		";default;;typing;0;0?x=1589790005000&s=1589790000000;1",
+	}
+
+	for i, bm := range benchMessages {
+		if expected[i] == "" {
+			continue
+		}
+
+		if got := string(serializeMessage(bm.msg)); got != expected[i] {
+			t.Fatalf("[%s] expected:\n%s\nbut got:\n%s", bm.name, expected[i], got)
+		}
+	}
+}
+
+func TestMessageTraceID(t *testing.T) {
+	msg := Message{Namespace: "default", Event: "chat", Body: []byte("hello"), TraceID: "4f2a9c0d1e3b5a76-1"}
+
+	if expected, got := ";default;;chat;0;0?i=4f2a9c0d1e3b5a76-1;hello", string(serializeMessage(msg)); expected != got {
+		t.Fatalf("expected serialized message: %s but got: %s", expected, got)
+	}
+
+	if got := DeserializeMessage(TextMessage, serializeMessage(msg), false, false); got.TraceID != msg.TraceID {
+		t.Fatalf("expected trace ID: %s but got: %s", msg.TraceID, got.TraceID)
+	}
+
+	if got := DeserializeExchangeMessage(msg.SerializeExchange()); got.TraceID != msg.TraceID {
+		t.Fatalf("expected trace ID through the exchange envelope: %s but got: %s", msg.TraceID, got.TraceID)
+	}
+
+	if a, b := genTraceID(), genTraceID(); a == b || !strings.HasPrefix(a, traceIDPrefix) {
+		t.Fatalf("expected unique trace IDs with the process prefix but got: %s and %s", a, b)
+	}
+}
+
+func TestMessageClone(t *testing.T) {
+	msg := Message{Namespace: "default", Event: "chat", Body: []byte("hello"), TraceID: "trace", Expiry: 42}
+	cloned := msg.Clone()
+
+	if !reflect.DeepEqual(msg, cloned) {
+		t.Fatalf("expected clone: %#+v but got: %#+v", msg, cloned)
+	}
+
+	msg.Body[0] = 'j'
+	if expected, got := "hello", string(cloned.Body); expected != got {
+		t.Fatalf("expected the cloned body to not share memory with the original: %s but got: %s", expected, got)
+	}
+
+	if cloned = (Message{Event: "chat"}).Clone(); cloned.Body != nil {
+		t.Fatalf("expected a nil body to be kept nil but got: %#v", cloned.Body)
+	}
+}
+
+func TestMessageLimits(t *testing.T) {
+	limits := MessageLimits{MaxEventLength: 8, MaxExtensions: 2}
+	long := strings.Repeat("e", 9)
+
+	var tests = []struct {
+		payload             string
+		allowNativeMessages bool
+		invalid             bool
+		native              bool
+	}{
+		{";default;;" + strings.Repeat("e", 8) + ";0;0;body", false, false, false},
+		{";default;;" + long + ";0;0;body", false, true, false},
+		// a message which exceeds the limits is not a neffos message.
+		{";default;;" + long + ";0;0;body", true, false, true},
+		{";" + strings.Repeat("n", DefaultMessageLimits.MaxNamespaceLength+1) + ";;chat;0;0;body", false, true, false},
+		{";default;;chat;0;0?x=1&s=1;body", false, false, false},
+		{";default;;chat;0;0?x=1&s=1&z=1;body", false, true, false},
+		// the body is not limited.
+		{";default;;chat;0;0;" + strings.Repeat(";", 4096), false, false, false},
+	}
+
+	for i, tt := range tests {
+		msg := deserializeMessage(TextMessage, []byte(tt.payload), tt.allowNativeMessages, false, false, &limits)
+		if msg.isInvalid != tt.invalid || msg.IsNative != tt.native {
+			t.Fatalf("[%d] expected invalid: %v and native: %v but got: %v and %v", i, tt.invalid, tt.native, msg.isInvalid, msg.IsNative)
+		}
+	}
+}
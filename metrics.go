@@ -0,0 +1,241 @@
+package neffos
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is a snapshot of the counters of a server or a client,
+// see `Server#Metrics` and `Client#Metrics`.
+type Metrics struct {
+	// ExpiredOutbound is the number of messages that were dropped
+	// instead of sent because their `Message.Expiry` had passed.
+	ExpiredOutbound uint64
+	// ExpiredInbound is the number of incoming messages that were dropped
+	// before dispatch because their `Message.Expiry` had passed.
+	ExpiredInbound uint64
+	// StaleReplies is the number of incoming replies that were dropped
+	// because their wait token belongs to another connection, i.e of a previous session.
+	StaleReplies uint64
+	// SuppressedEchoes is the number of the messages which were dropped instead of written to a connection
+	// because the `StackExchange` delivered them back to the server instance which published them.
+	SuppressedEchoes uint64
+	// Heartbeats is the number of the heartbeat pings and pongs which were received, see `HeartbeatPing`.
+	Heartbeats uint64
+	// SlowWrites is the number of the slow writes, see `Server.SlowWriteThreshold`.
+	SlowWrites uint64
+	// SlowConsumers is the number of the connections which are excluded from the broadcasts,
+	// at the time of the snapshot, see `SlowConsumerStopBroadcasts`.
+	SlowConsumers uint64
+	// SkippedDisconnects is the number of the closed connections which their `Server.OnDisconnect`
+	// was skipped because the server had stopped, see `Server#Close` and `Server#Shutdown`.
+	SkippedDisconnects uint64
+	// ExpiredWaitingMessages is the number of the wait tokens of the `Server#Ask` calls
+	// which were removed because they were abandoned, see `Server#SetWaitingMessagesTTL`.
+	ExpiredWaitingMessages uint64
+
+	// HandlerPool holds the counters of the pools of the asynchronous events,
+	// the pools of many namespaces are summed, see `Events#Async`.
+	HandlerPool HandlerPoolStats
+
+	// StackExchange holds the counters of the stackexchange traffic,
+	// they are kept only for a `StackExchange` wrapped by the `InstrumentStackExchange`.
+	StackExchange StackExchangeMetrics
+}
+
+// StackExchangeMetrics is a snapshot of the counters of the stackexchange traffic of a server,
+// see `InstrumentStackExchange`.
+type StackExchangeMetrics struct {
+	// Published is the number of the messages published through the stackexchange.
+	Published uint64
+	// PublishedBytes is the total size of the bodies of the published messages.
+	PublishedBytes uint64
+	// PublishErrors is the number of the messages which their publish failed.
+	PublishErrors uint64
+	// Received is the number of the messages which the stackexchange wrote to the server's connections,
+	// a message written to many connections is counted once per connection.
+	Received uint64
+	// ReceivedBytes is the total size of the bodies of the received messages.
+	ReceivedBytes uint64
+	// Latency is the time from the publish of a message to its write to a connection,
+	// it's measured only when the `Server.StampSentAt` is true.
+	Latency LatencyHistogram
+	// Compressed is the number of the published envelopes which were compressed,
+	// it's kept without the `InstrumentStackExchange` too, see `Server.SetStackExchangeCompression`.
+	Compressed uint64
+	// CompressedRawBytes is the total size of the compressed envelopes before their compression.
+	CompressedRawBytes uint64
+	// CompressedBytes is the total size of the compressed envelopes.
+	CompressedBytes uint64
+	// QueueDepth is the number of the messages which wait on the publish queue, at the time of the snapshot,
+	// it's kept without the `InstrumentStackExchange` too, see `Server.SetStackExchangeQueue`.
+	QueueDepth uint64
+	// QueueDropped is the number of the messages which were dropped from a full publish queue.
+	QueueDropped uint64
+	// Pool holds the statistics of the connection pool of a `StackExchangePoolReporter`,
+	// the pools of many stackexchanges are summed.
+	Pool StackExchangePoolStats
+}
+
+// StackExchangePoolStats is a snapshot of the connection pool of a stackexchange,
+// see `StackExchangePoolReporter`.
+type StackExchangePoolStats struct {
+	// Size is the configured number of the pooled connections.
+	Size int
+	// Idle is the number of the pooled connections which are available for a command.
+	Idle int
+	// Open is the number of the open connections, idle or in use.
+	Open int
+	// Created is the number of the connections which were opened.
+	Created uint64
+	// Closed is the number of the connections which were closed, i.e on a network error.
+	Closed uint64
+	// DialErrors is the number of the failed attempts to open a connection.
+	DialErrors uint64
+}
+
+func (st StackExchangePoolStats) add(other StackExchangePoolStats) StackExchangePoolStats {
+	st.Size += other.Size
+	st.Idle += other.Idle
+	st.Open += other.Open
+	st.Created += other.Created
+	st.Closed += other.Closed
+	st.DialErrors += other.DialErrors
+	return st
+}
+
+// LatencyBuckets are the upper bounds of the buckets of a `LatencyHistogram`,
+// the last bucket of a histogram counts the larger latencies.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram is a snapshot of observed latencies.
+type LatencyHistogram struct {
+	// Counts holds the number of the observations of each bucket of the `LatencyBuckets`,
+	// they are not cumulative. Its last element counts the larger ones.
+	Counts []uint64
+	// Count is the number of the observations.
+	Count uint64
+	// Sum is the sum of the observations.
+	Sum time.Duration
+}
+
+// counters keeps the live values of a `Metrics`,
+// server-side connections share the server's counters.
+// All fields are 64-bit and modified through the atomic package.
+type counters struct {
+	expiredOutbound uint64
+	expiredInbound  uint64
+	staleReplies    uint64
+
+	suppressedEchoes uint64
+	heartbeats       uint64
+
+	slowWrites    uint64
+	slowConsumers uint64
+
+	skippedDisconnects     uint64
+	expiredWaitingMessages uint64
+
+	exchangePublished      uint64
+	exchangePublishedBytes uint64
+	exchangePublishErrors  uint64
+	exchangeReceived       uint64
+	exchangeReceivedBytes  uint64
+	exchangeLatency        latencyHistogram
+
+	exchangeCompressed         uint64
+	exchangeCompressedRawBytes uint64
+	exchangeCompressedBytes    uint64
+
+	exchangeQueueDepth   uint64
+	exchangeQueueDropped uint64
+}
+
+// latencyHistogram keeps the live values of a `LatencyHistogram`.
+type latencyHistogram struct {
+	count  uint64
+	sumNs  uint64
+	counts [11]uint64 // len(LatencyBuckets)+1.
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	i := 0
+	for ; i < len(LatencyBuckets) && i < len(h.counts)-1; i++ {
+		if d <= LatencyBuckets[i] {
+			break
+		}
+	}
+
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sumNs, uint64(d))
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+
+	return LatencyHistogram{
+		Counts: counts,
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    time.Duration(atomic.LoadUint64(&h.sumNs)),
+	}
+}
+
+func newCounters() *counters {
+	return new(counters)
+}
+
+func (c *counters) incr(field *uint64) {
+	atomic.AddUint64(field, 1)
+}
+
+func (c *counters) add(field *uint64, n int) {
+	atomic.AddUint64(field, uint64(n))
+}
+
+func (c *counters) snapshot() Metrics {
+	return Metrics{
+		ExpiredOutbound:        atomic.LoadUint64(&c.expiredOutbound),
+		ExpiredInbound:         atomic.LoadUint64(&c.expiredInbound),
+		StaleReplies:           atomic.LoadUint64(&c.staleReplies),
+		SuppressedEchoes:       atomic.LoadUint64(&c.suppressedEchoes),
+		Heartbeats:             atomic.LoadUint64(&c.heartbeats),
+		SlowWrites:             atomic.LoadUint64(&c.slowWrites),
+		SlowConsumers:          atomic.LoadUint64(&c.slowConsumers),
+		SkippedDisconnects:     atomic.LoadUint64(&c.skippedDisconnects),
+		ExpiredWaitingMessages: atomic.LoadUint64(&c.expiredWaitingMessages),
+		StackExchange: StackExchangeMetrics{
+			Published:      atomic.LoadUint64(&c.exchangePublished),
+			PublishedBytes: atomic.LoadUint64(&c.exchangePublishedBytes),
+			PublishErrors:  atomic.LoadUint64(&c.exchangePublishErrors),
+			Received:       atomic.LoadUint64(&c.exchangeReceived),
+			ReceivedBytes:  atomic.LoadUint64(&c.exchangeReceivedBytes),
+			Latency:        c.exchangeLatency.snapshot(),
+
+			Compressed:         atomic.LoadUint64(&c.exchangeCompressed),
+			CompressedRawBytes: atomic.LoadUint64(&c.exchangeCompressedRawBytes),
+			CompressedBytes:    atomic.LoadUint64(&c.exchangeCompressedBytes),
+
+			QueueDepth:   atomic.LoadUint64(&c.exchangeQueueDepth),
+			QueueDropped: atomic.LoadUint64(&c.exchangeQueueDropped),
+		},
+	}
+}
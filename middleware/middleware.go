@@ -0,0 +1,75 @@
+// Package middleware provides builtin middleware for the neffos event callbacks,
+// register them through the `neffos.Events#Use`, `neffos.Namespaces#Use` or `neffos.Server#Use`.
+package middleware
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+// Printer is the logger of the middleware, i.e the standard `log.Logger`.
+type Printer interface {
+	Printf(format string, v ...interface{})
+}
+
+// Logger returns a middleware which prints the namespace, the room, the event, the connection ID,
+// the duration and the error, if any, of each fired event through the "printer".
+func Logger(printer Printer) neffos.Middleware {
+	return func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+		return func(c *neffos.NSConn, msg neffos.Message) error {
+			start := time.Now()
+			err := next(c, msg)
+
+			id := ""
+			if c != nil && c.Conn != nil {
+				id = c.Conn.ID()
+			}
+
+			if err != nil {
+				printer.Printf("%s %s/%s/%s %s: %v", id, msg.Namespace, msg.Room, msg.Event, time.Since(start), err)
+			} else {
+				printer.Printf("%s %s/%s/%s %s", id, msg.Namespace, msg.Room, msg.Event, time.Since(start))
+			}
+
+			return err
+		}
+	}
+}
+
+// PanicError is the error of a recovered panic of an event callback, see `Recover`.
+type PanicError struct {
+	// Value is the value which was passed to the panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine which panicked.
+	Stack []byte
+}
+
+// Error returns the panic's value, the stack trace is not included
+// because the error is sent to the remote side.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Recover returns a middleware which recovers from a panic of the next callbacks and returns it as a `*PanicError`,
+// the panic and its stack trace are printed through the "printer", if not nil.
+// Register it first, so it recovers from the panics of the rest of the middleware too.
+func Recover(printer Printer) neffos.Middleware {
+	return func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+		return func(c *neffos.NSConn, msg neffos.Message) (err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					panicErr := &PanicError{Value: v, Stack: debug.Stack()}
+					if printer != nil {
+						printer.Printf("%s/%s: %v\n%s", msg.Namespace, msg.Event, v, panicErr.Stack)
+					}
+					err = panicErr
+				}
+			}()
+
+			return next(c, msg)
+		}
+	}
+}
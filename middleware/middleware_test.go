@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kataras/neffos"
+)
+
+type testPrinter []string
+
+func (p *testPrinter) Printf(format string, v ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, v...))
+}
+
+func TestRecover(t *testing.T) {
+	var printer testPrinter
+	h := Recover(&printer)(func(c *neffos.NSConn, msg neffos.Message) error {
+		panic("boom")
+	})
+
+	err := h(nil, neffos.Message{Namespace: "default", Event: "chat"})
+	panicErr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("expected a *PanicError but got: %v", err)
+	}
+
+	if expected, got := "panic: boom", panicErr.Error(); expected != got {
+		t.Fatalf("expected error: %s but got: %s", expected, got)
+	}
+
+	if len(printer) != 1 || !strings.HasPrefix(printer[0], "default/chat: boom\n") {
+		t.Fatalf("expected the panic to be printed but got: %v", printer)
+	}
+
+	errNext := errors.New("next")
+	h = Recover(nil)(func(c *neffos.NSConn, msg neffos.Message) error {
+		return errNext
+	})
+	if err = h(nil, neffos.Message{}); err != errNext {
+		t.Fatalf("expected the error of the next callback but got: %v", err)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var printer testPrinter
+	h := Logger(&printer)(func(c *neffos.NSConn, msg neffos.Message) error {
+		if msg.Event == "fail" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	h(nil, neffos.Message{Namespace: "default", Room: "room", Event: "chat"})
+	h(nil, neffos.Message{Namespace: "default", Event: "fail"})
+
+	if len(printer) != 2 {
+		t.Fatalf("expected two lines but got: %v", printer)
+	}
+
+	if !strings.HasPrefix(printer[0], " default/room/chat ") || strings.Contains(printer[0], ": ") {
+		t.Fatalf("unexpected line: %s", printer[0])
+	}
+
+	if !strings.HasPrefix(printer[1], " default//fail ") || !strings.HasSuffix(printer[1], ": failed") {
+		t.Fatalf("unexpected line: %s", printer[1])
+	}
+}
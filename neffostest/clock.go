@@ -0,0 +1,135 @@
+package neffostest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+// Clock is a fake `neffos.Clock` for the deterministic tests of the time-based behavior,
+// i.e the ack wait of the `neffos.Conn#Connect`, the heartbeats, the rate limits
+// and the read timeouts of the in-memory sockets. Its time moves only by the `Advance`,
+// its timers and sleeps fire when it passes their time.
+//
+// Usage:
+//
+//	clock := neffostest.NewClock()
+//	server, client := neffostest.Pair(t, handler, neffostest.WithClock(clock))
+//	clock.BlockUntil(1) // i.e the read timeout of the server.
+//	clock.Advance(time.Minute)
+type Clock struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	timers  []*clockTimer
+}
+
+var _ neffos.Clock = (*Clock)(nil)
+
+// NewClock returns a new fake `Clock`, its time starts at 2000-01-01 UTC.
+func NewClock() *Clock {
+	c := &Clock{now: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	now := c.now
+	c.mu.Unlock()
+
+	return now
+}
+
+// NewTimer returns a `neffos.Timer` which fires when the clock is advanced by "d".
+func (c *Clock) NewTimer(d time.Duration) neffos.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &clockTimer{clock: c, when: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+
+	c.timers = append(c.timers, t)
+	c.changed.Broadcast()
+	return t
+}
+
+// Sleep blocks until the clock is advanced by "d".
+func (c *Clock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+// Advance moves the time of the clock by "d" and fires its due timers, in order.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	pending := c.timers[:0]
+	var due []*clockTimer
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+
+		due = append(due, t)
+	}
+	c.timers = pending
+
+	for len(due) > 0 {
+		next := 0
+		for i, t := range due {
+			if t.when.Before(due[next].when) {
+				next = i
+			}
+		}
+
+		due[next].ch <- due[next].when
+		due = append(due[:next], due[next+1:]...)
+	}
+
+	c.changed.Broadcast()
+}
+
+// BlockUntil blocks until the clock has at least "n" pending timers and sleeps,
+// i.e until the goroutines under test wait for it before an `Advance`.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+	c.mu.Unlock()
+}
+
+type clockTimer struct {
+	clock *Clock
+	when  time.Time
+	ch    chan time.Time
+}
+
+func (t *clockTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *clockTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.changed.Broadcast()
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,249 @@
+package neffostest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+func TestClock(t *testing.T) {
+	clock := NewClock()
+	start := clock.Now()
+
+	second, first := clock.NewTimer(2*time.Second), clock.NewTimer(time.Second)
+	stopped := clock.NewTimer(time.Second)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatalf("expected the timer to be stopped once")
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case now := <-first.C():
+		if expected := start.Add(time.Second); !now.Equal(expected) {
+			t.Fatalf("expected the time: %s but got: %s", expected, now)
+		}
+	default:
+		t.Fatalf("expected the first timer to fire")
+	}
+
+	select {
+	case <-second.C():
+		t.Fatalf("expected the second timer to be pending")
+	case <-stopped.C():
+		t.Fatalf("expected the stopped timer to never fire")
+	default:
+	}
+
+	slept := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Minute)
+		close(slept)
+	}()
+
+	clock.BlockUntil(2)
+	clock.Advance(time.Minute)
+	<-slept
+	<-second.C()
+
+	if expected, got := start.Add(time.Minute+time.Second), clock.Now(); !got.Equal(expected) {
+		t.Fatalf("expected the time: %s but got: %s", expected, got)
+	}
+}
+
+func TestClockAckWait(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		close    bool
+		expected error
+	}{
+		{"deadline", false, context.DeadlineExceeded},
+		{"closed", true, neffos.ErrWrite},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewClock()
+			server := neffos.New(nil, neffos.Namespaces{"default": neffos.Events{}})
+			server.Clock = clock
+			defer server.Close()
+
+			errs := make(chan error, 1)
+			server.OnConnect = func(c *neffos.Conn) error {
+				// waits for the acknowledgement, which is never sent.
+				_, err := c.Connect(context.TODO(), "default")
+				errs <- err
+				return err
+			}
+
+			socket, err := neffos.PipeDialer(server)(context.TODO(), "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer socket.NetConn().Close()
+
+			if tt.close {
+				socket.NetConn().Close()
+			}
+
+			start := time.Now()
+			for {
+				clock.BlockUntil(1)
+				clock.Advance(5 * time.Second)
+
+				select {
+				case err = <-errs:
+				case <-time.After(10 * time.Millisecond):
+					continue
+				}
+				break
+			}
+
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expected the error: %v but got: %v", tt.expected, err)
+			}
+			if took := time.Since(start); took > time.Second {
+				t.Fatalf("expected the ack wait to follow the clock but it took: %s", took)
+			}
+		})
+	}
+}
+
+func TestClockReadTimeout(t *testing.T) {
+	clock := NewClock()
+	handler := neffos.WithTimeout{
+		ReadTimeout: time.Minute,
+		Namespaces:  neffos.Namespaces{"default": neffos.Events{}},
+	}
+
+	_, client := Pair(t, handler, WithClock(clock), WithConnect("default"))
+
+	// the reads of both sides wait for their timeout.
+	clock.BlockUntil(2)
+	if client.IsClosed() {
+		t.Fatalf("expected the client to be open before the read timeout")
+	}
+
+	start := time.Now()
+	clock.Advance(time.Minute)
+
+	select {
+	case <-client.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the idle client to be closed by the read timeout")
+	}
+
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("expected the read timeout to follow the clock but it took: %s", took)
+	}
+}
+
+func TestClockWaitingMessagesTTL(t *testing.T) {
+	const ttl = time.Minute
+
+	clock := NewClock()
+	server := neffos.New(nil, neffos.Namespaces{"default": neffos.Events{}})
+	server.Clock = clock
+	server.SetWaitingMessagesTTL(ttl)
+	defer server.Close()
+
+	// waits for a reply which is never sent, longer than the ttl.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Ask(ctx, neffos.Message{Namespace: "default", Event: "ask"})
+
+	expired := func() bool {
+		return server.Metrics().ExpiredWaitingMessages > 0
+	}
+
+	advanced := time.Duration(0)
+	for !expired() {
+		if advanced > 2*ttl {
+			t.Fatalf("expected the wait token to be expired after the ttl")
+		}
+
+		// the timer of the sweeper, started by the ask.
+		clock.BlockUntil(1)
+		clock.Advance(ttl / 2)
+		advanced += ttl / 2
+
+		for deadline := time.Now().Add(50 * time.Millisecond); !expired() && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+
+		if expired() && advanced < ttl {
+			t.Fatalf("expected the wait token to be kept until the ttl but it expired after: %s", advanced)
+		}
+	}
+
+	if expected, got := uint64(1), server.Metrics().ExpiredWaitingMessages; expected != got {
+		t.Fatalf("expected expired waiting messages: %d but got: %d", expected, got)
+	}
+}
+
+func TestClockActivity(t *testing.T) {
+	var (
+		clock     = NewClock()
+		processed = make(chan time.Duration, 1)
+		handled   = make(chan time.Duration, 1)
+		events    = neffos.Events{
+			"work": func(c *neffos.NSConn, msg neffos.Message) error {
+				clock.Advance(time.Second)
+				return nil
+			},
+		}
+		serverConn *neffos.Conn
+	)
+
+	work := ExpectEvent(t, events, "work", DefaultTimeout)
+	_, client := Pair(t, neffos.Namespaces{"default": events}, WithClock(clock), WithConnect("default"),
+		WithServer(func(server *neffos.Server) {
+			server.OnConnect = func(c *neffos.Conn) error {
+				serverConn = c
+				return nil
+			}
+			server.OnMessageProcessed = func(c *neffos.Conn, msg neffos.Message, d time.Duration, err error) {
+				if msg.Event == "work" {
+					processed <- d
+				}
+			}
+			server.OnAfterEvent = func(c *neffos.NSConn, msg neffos.Message, d time.Duration, err error) {
+				if msg.Event == "work" {
+					handled <- d
+				}
+			}
+		}))
+
+	start := clock.Now()
+	if got := client.LastActivity(); !got.Equal(start) {
+		t.Fatalf("expected the client's activity at: %s but got: %s", start, got)
+	}
+	if got := serverConn.LastActivity(); !got.Equal(start) {
+		t.Fatalf("expected the server connection's activity at: %s but got: %s", start, got)
+	}
+
+	clock.Advance(time.Minute)
+	EmitAndExpect(t, client.Namespace("default"), "work", nil, work)
+
+	if expected, got := start.Add(time.Minute), serverConn.LastActivity(); !got.Equal(expected) {
+		t.Fatalf("expected the server connection's activity at: %s but got: %s", expected, got)
+	}
+
+	select {
+	case d := <-processed:
+		if expected := time.Second; d != expected {
+			t.Fatalf("expected the processing duration: %s but got: %s", expected, d)
+		}
+	case <-time.After(DefaultTimeout):
+		t.Fatalf("expected the processed message")
+	}
+
+	select {
+	case d := <-handled:
+		if expected := time.Second; d != expected {
+			t.Fatalf("expected the event duration: %s but got: %s", expected, d)
+		}
+	case <-time.After(DefaultTimeout):
+		t.Fatalf("expected the handled event")
+	}
+}
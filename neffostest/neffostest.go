@@ -0,0 +1,233 @@
+// Package neffostest provides helpers for the tests of the neffos applications:
+// a connected server and client pair in one call and the expectations of their events.
+//
+// Usage:
+//
+//	events := neffos.Events{"chat": onChat}
+//	chat := neffostest.ExpectEvent(t, events, "chat", time.Second)
+//	_, client := neffostest.Pair(t, neffos.Namespaces{"default": events}, neffostest.WithConnect("default"))
+//	client.Namespace("default").Emit("chat", []byte("hello"))
+//	msg := chat()
+package neffostest
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+)
+
+// DefaultTimeout is the timeout of the dial and the namespace connects of the `Pair`.
+var DefaultTimeout = 5 * time.Second
+
+type options struct {
+	websocket     bool
+	clientHandler neffos.ConnHandler
+	configure     []func(*neffos.Server)
+	dialOptions   []neffos.DialOption
+	namespaces    []string
+}
+
+// Option is the type of the optional input arguments of the `Pair` function.
+type Option func(*options)
+
+// WithWebsocket is an `Option` which serves the server through a real `httptest.Server`
+// and dials it through the gorilla websocket, i.e for the integration tests.
+// Defaults to the in-memory sockets, see `neffos.PipeDialer`.
+func WithWebsocket() Option {
+	return func(o *options) {
+		o.websocket = true
+	}
+}
+
+// WithClientHandler is an `Option` which sets the handler of the client,
+// defaults to the handler of the server.
+func WithClientHandler(handler neffos.ConnHandler) Option {
+	return func(o *options) {
+		o.clientHandler = handler
+	}
+}
+
+// WithServer is an `Option` which configures the server before the client is dialed,
+// i.e to register its `OnConnect` or to call its `Use`.
+func WithServer(configure func(server *neffos.Server)) Option {
+	return func(o *options) {
+		o.configure = append(o.configure, configure)
+	}
+}
+
+// WithDialOptions is an `Option` which passes the "dialOptions" to the `neffos.Dial` of the client.
+func WithDialOptions(dialOptions ...neffos.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, dialOptions...)
+	}
+}
+
+// WithClock is an `Option` which sets the "clock" to both the server and the client, see `NewClock`.
+// The read timeouts of the in-memory sockets follow it too.
+func WithClock(clock neffos.Clock) Option {
+	return func(o *options) {
+		o.configure = append(o.configure, func(server *neffos.Server) {
+			server.Clock = clock
+		})
+		o.dialOptions = append(o.dialOptions, neffos.WithClock(clock))
+	}
+}
+
+// WithConnect is an `Option` which connects the client to the "namespaces",
+// they can be retrieved through its `neffos.Conn#Namespace`.
+func WithConnect(namespaces ...string) Option {
+	return func(o *options) {
+		o.namespaces = append(o.namespaces, namespaces...)
+	}
+}
+
+// Pair returns a new server of the "handler" and a client connection which is acknowledged by it,
+// over the in-memory sockets by default, see `WithWebsocket`.
+// Both of them are closed when the test and its subtests complete, see `testing.T#Cleanup`.
+// It fails the test if the client can not be connected.
+func Pair(t testing.TB, handler neffos.ConnHandler, opts ...Option) (server *neffos.Server, client *neffos.Conn) {
+	t.Helper()
+
+	o := options{clientHandler: handler}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var (
+		dialer neffos.Dialer
+		url    = "/"
+	)
+
+	if o.websocket {
+		server = neffos.New(gorilla.DefaultUpgrader, handler)
+		httpServer := httptest.NewServer(server)
+		t.Cleanup(httpServer.Close)
+
+		dialer = gorilla.DefaultDialer
+		url = strings.Replace(httpServer.URL, "http", "ws", 1)
+	} else {
+		server = neffos.New(nil, handler)
+		dialer = neffos.PipeDialer(server)
+	}
+	// the cleanups run in the reverse order, the server is closed last.
+	t.Cleanup(server.Close)
+
+	for _, configure := range o.configure {
+		configure(server)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	dialOptions := append(o.dialOptions, func(c *neffos.Conn) {
+		client = c
+	})
+
+	c, err := neffos.Dial(ctx, dialer, url, o.clientHandler, dialOptions...)
+	if err != nil {
+		t.Fatalf("neffostest: dial: %v", err)
+	}
+	t.Cleanup(c.Close)
+
+	for _, namespace := range o.namespaces {
+		if _, err = c.Connect(ctx, namespace); err != nil {
+			t.Fatalf("neffostest: connect to namespace: %s: %v", namespace, err)
+		}
+	}
+
+	return server, client
+}
+
+// ExpectEvent registers a callback of the "event" to the "events", which wraps the existing one, if any,
+// and returns a function which waits for its next fire and returns its message.
+// The returned function fails the test if the event is not fired in the "timeout".
+// Each call waits for the next fire, the fires before the call are kept in order.
+//
+// It should be called before the "events" are served, on the side which the event is expected,
+// if both server and client share the "events" the fires of both sides are counted.
+func ExpectEvent(t testing.TB, events neffos.Events, event string, timeout time.Duration) func() neffos.Message {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		messages []neffos.Message
+		notify   = make(chan struct{}, 1)
+	)
+
+	cb := events[event]
+	events[event] = func(c *neffos.NSConn, msg neffos.Message) error {
+		mu.Lock()
+		messages = append(messages, msg)
+		mu.Unlock()
+
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+
+		if cb != nil {
+			return cb(c, msg)
+		}
+
+		return nil
+	}
+
+	return func() neffos.Message {
+		t.Helper()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		for {
+			mu.Lock()
+			if len(messages) > 0 {
+				msg := messages[0]
+				messages = messages[1:]
+				mu.Unlock()
+				return msg
+			}
+			mu.Unlock()
+
+			select {
+			case <-notify:
+			case <-timer.C:
+				t.Fatalf("neffostest: expected the event: %s to be fired in: %s", event, timeout)
+				return neffos.Message{}
+			}
+		}
+	}
+}
+
+// Ask sends the "event" with the "body" through the "c" and waits for its reply,
+// like the `neffos.NSConn#Ask` but it fails the test on error or if there is no reply in the "timeout".
+func Ask(t testing.TB, c *neffos.NSConn, event string, body []byte, timeout time.Duration) neffos.Message {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	msg, err := c.Ask(ctx, event, body)
+	if err != nil {
+		t.Fatalf("neffostest: ask: %s: %v", event, err)
+	}
+
+	return msg
+}
+
+// EmitAndExpect sends the "event" with the "body" through the "c" and waits
+// for the "expect" event, as returned by the `ExpectEvent`, i.e the one which the remote side emits back.
+func EmitAndExpect(t testing.TB, c *neffos.NSConn, event string, body []byte, expect func() neffos.Message) neffos.Message {
+	t.Helper()
+
+	if !c.Emit(event, body) {
+		t.Fatalf("neffostest: emit: %s: connection closed", event)
+	}
+
+	return expect()
+}
@@ -0,0 +1,71 @@
+package neffostest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+func TestPair(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"pipe", nil},
+		{"websocket", []Option{WithWebsocket()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				namespace = "default"
+				events    = neffos.Events{
+					"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+						if !c.Conn.IsClient() {
+							return neffos.Reply(msg.Body)
+						}
+						return nil
+					},
+					"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+						if !c.Conn.IsClient() {
+							c.Emit("notified", msg.Body)
+						}
+						return nil
+					},
+				}
+				connected = make(chan string, 1)
+				notify    = ExpectEvent(t, events, "notify", time.Second)
+				notified  = ExpectEvent(t, events, "notified", time.Second)
+			)
+
+			opts := append(tt.opts, WithConnect(namespace), WithServer(func(server *neffos.Server) {
+				server.OnConnect = func(c *neffos.Conn) error {
+					connected <- c.ID()
+					return nil
+				}
+			}))
+			_, client := Pair(t, neffos.Namespaces{namespace: events}, opts...)
+
+			if expected, got := client.ID(), <-connected; expected != got {
+				t.Fatalf("expected the client ID: %s but got: %s", expected, got)
+			}
+
+			c := client.Namespace(namespace)
+			if c == nil {
+				t.Fatalf("expected the client to be connected to the namespace: %s", namespace)
+			}
+
+			if expected, got := "hello", string(Ask(t, c, "echo", []byte("hello"), time.Second).Body); expected != got {
+				t.Fatalf("expected the reply: %s but got: %s", expected, got)
+			}
+
+			msg := EmitAndExpect(t, c, "notify", []byte("body"), notified)
+			if expected, got := "body", string(msg.Body); expected != got {
+				t.Fatalf("expected the body: %s but got: %s", expected, got)
+			}
+
+			if msg = notify(); msg.Event != "notify" || string(msg.Body) != "body" {
+				t.Fatalf("expected the notify event to be fired on the server but got: %#+v", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package nhooyr
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/kataras/neffos"
+
+	nhooyr "github.com/coder/websocket"
+)
+
+// DefaultDialer is a nhooyr.io/websocket dialer with all dial options set to the default values.
+var DefaultDialer = Dialer(nil)
+
+// Dialer is a `neffos.Dialer` type for the nhooyr.io/websocket (github.com/coder/websocket) subprotocol implementation.
+// Should be used on `Dial` to create a new client/client-side connection.
+// The "options" can be nil, to send headers to the server set its `HTTPHeader` field.
+// The unix domain socket urls are dialed through their socket file, see `neffos.ParseUnixURL`.
+func Dialer(options *nhooyr.DialOptions) neffos.Dialer {
+	return func(ctx context.Context, url string) (neffos.Socket, error) {
+		if socketPath, wsURL, ok := neffos.ParseUnixURL(url); ok {
+			url = wsURL
+			options = unixDialOptions(options, socketPath)
+		}
+
+		underline, _, err := nhooyr.Dial(ctx, url, options)
+		if err != nil {
+			return nil, err
+		}
+
+		return newSocket(underline, nil, true), nil
+	}
+}
+
+// unixDialOptions returns a copy of the "options" which dials the unix domain socket of the "socketPath".
+func unixDialOptions(options *nhooyr.DialOptions, socketPath string) *nhooyr.DialOptions {
+	unixOptions := new(nhooyr.DialOptions)
+	if options != nil {
+		*unixOptions = *options
+	}
+
+	client := new(http.Client)
+	if unixOptions.HTTPClient != nil {
+		*client = *unixOptions.HTTPClient
+	}
+
+	transport, ok := client.Transport.(*http.Transport)
+	if ok {
+		transport = transport.Clone()
+	} else {
+		transport = new(http.Transport)
+	}
+	transport.Proxy = nil
+	transport.DialContext = neffos.UnixNetDial(socketPath)
+
+	client.Transport = transport
+	unixOptions.HTTPClient = client
+	return unixOptions
+}
@@ -0,0 +1,12 @@
+//go:build go1.9
+// +build go1.9
+
+package nhooyr
+
+import nhooyr "github.com/coder/websocket"
+
+// Options is just an alias for the `nhooyr.io/websocket.DialOptions` struct type.
+type Options = nhooyr.DialOptions
+
+// AcceptOptions is just an alias for the `nhooyr.io/websocket.AcceptOptions` struct type.
+type AcceptOptions = nhooyr.AcceptOptions
@@ -0,0 +1,140 @@
+package nhooyr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	nhooyr "github.com/coder/websocket"
+)
+
+// Socket completes the `neffos.Socket` interface,
+// it describes the underline websocket connection.
+type Socket struct {
+	UnderlyingConn *nhooyr.Conn
+	request        *http.Request
+
+	netConn net.Conn
+	client  bool
+	// see `SetReadLimit`.
+	readLimit int64
+}
+
+func newSocket(underline *nhooyr.Conn, request *http.Request, client bool) *Socket {
+	s := &Socket{
+		UnderlyingConn: underline,
+		request:        request,
+		client:         client,
+		netConn: &netConn{
+			Conn: nhooyr.NetConn(context.Background(), underline, nhooyr.MessageBinary),
+			ws:   underline,
+		},
+	}
+
+	// like the gorilla and gobwas ones, no read limit unless `SetReadLimit` is called,
+	// note that the above NetConn resets it too.
+	underline.SetReadLimit(-1)
+	return s
+}
+
+// netConn is the net connection of the socket, its reads and writes are not used by neffos.
+// It's closed without the close handshake, like the gorilla and gobwas ones,
+// the nhooyr.io/websocket net connection would wait for the remote close frame.
+type netConn struct {
+	net.Conn
+	ws *nhooyr.Conn
+}
+
+func (c *netConn) Close() error {
+	return c.ws.CloseNow()
+}
+
+// NetConn returns the underline net connection.
+// Its addresses are mocked for the client-side connections, see `nhooyr.io/websocket.NetConn`.
+func (s *Socket) NetConn() net.Conn {
+	return s.netConn
+}
+
+// Request returns the http request value.
+func (s *Socket) Request() *http.Request {
+	return s.request
+}
+
+// ReadData reads binary or text messages from the remote connection.
+// Note that the connection is closed if the "timeout" expires, see `nhooyr.io/websocket.Conn#Read`.
+func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	typ, data, err := s.UnderlyingConn.Read(ctx)
+	if err != nil {
+		if s.readLimit > 0 && strings.Contains(err.Error(), "read limited at") {
+			return nil, 0, neffos.ErrMessageTooLarge
+		}
+
+		return nil, 0, closeError(err)
+	}
+
+	return data, neffos.MessageType(typ), nil
+}
+
+// closeError maps the errors of a closed connection to a `neffos.CloseError`,
+// so `neffos.IsCloseError` reports them.
+func closeError(err error) error {
+	if code := nhooyr.CloseStatus(err); code != -1 {
+		return neffos.NewCloseError(int(code), err)
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return neffos.NewCloseError(int(nhooyr.StatusAbnormalClosure), err)
+	}
+
+	return err
+}
+
+// SetReadLimit sets the maximum size in bytes for a message read from the remote connection,
+// it completes the `neffos.SocketReadLimiter` interface.
+func (s *Socket) SetReadLimit(limit int64) {
+	s.readLimit = limit
+	s.UnderlyingConn.SetReadLimit(limit)
+}
+
+// WriteClose sends a close message with the "code" and "reason" to the remote connection,
+// it completes the `neffos.SocketCloser` interface.
+// It performs the close handshake, which has its own timeouts, so the "timeout" is not used.
+func (s *Socket) WriteClose(code int, reason string, timeout time.Duration) error {
+	return s.UnderlyingConn.Close(nhooyr.StatusCode(code), reason)
+}
+
+// WriteBinary sends a binary message to the remote connection.
+func (s *Socket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.write(body, nhooyr.MessageBinary, timeout)
+}
+
+// WriteText sends a text message to the remote connection.
+func (s *Socket) WriteText(body []byte, timeout time.Duration) error {
+	return s.write(body, nhooyr.MessageText, timeout)
+}
+
+// write sends a message, the nhooyr.io/websocket connection is safe for concurrent writes.
+// Note that the connection is closed if the "timeout" expires, see `nhooyr.io/websocket.Conn#Write`.
+func (s *Socket) write(body []byte, typ nhooyr.MessageType, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return closeError(s.UnderlyingConn.Write(ctx, typ, body))
+}
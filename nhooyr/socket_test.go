@@ -0,0 +1,67 @@
+package nhooyr_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/nhooyr"
+)
+
+func TestSocketCloseError(t *testing.T) {
+	sockets := make(chan neffos.Socket, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := nhooyr.DefaultUpgrader(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		sockets <- socket
+	}))
+	defer httpServer.Close()
+
+	client, err := nhooyr.DefaultDialer(context.TODO(), strings.Replace(httpServer.URL, "http", "ws", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := <-sockets
+	if server.Request() == nil {
+		t.Fatalf("expected the server-side socket to keep the request")
+	}
+
+	if err = client.WriteBinary([]byte("data"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	b, typ, err := server.ReadData(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "data" || typ != neffos.BinaryMessage {
+		t.Fatalf("expected a binary message of: data but got: %q of type: %d", b, typ)
+	}
+
+	go client.(neffos.SocketCloser).WriteClose(4000, "bye", time.Second)
+
+	_, _, err = server.ReadData(time.Second)
+	closeErr, ok := err.(neffos.CloseError)
+	if !ok || !neffos.IsCloseError(err) {
+		t.Fatalf("expected a close error but got: %#+v", err)
+	}
+	if expected, got := 4000, closeErr.Code; expected != got {
+		t.Fatalf("expected close code: %d but got: %d", expected, got)
+	}
+
+	// closed without a close frame.
+	client.NetConn().Close()
+	server.NetConn().Close()
+	if _, _, err = server.ReadData(time.Second); !neffos.IsCloseError(err) {
+		t.Fatalf("expected a close error after the close but got: %#+v", err)
+	}
+}
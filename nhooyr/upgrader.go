@@ -0,0 +1,27 @@
+package nhooyr
+
+import (
+	"net/http"
+
+	"github.com/kataras/neffos"
+
+	nhooyr "github.com/coder/websocket"
+)
+
+// DefaultUpgrader is a nhooyr.io/websocket Upgrader with all accept options set to the default values.
+var DefaultUpgrader = Upgrader(nil)
+
+// Upgrader is a `neffos.Upgrader` type for the nhooyr.io/websocket (github.com/coder/websocket) subprotocol implementation.
+// Should be used on `neffos.New` to construct the neffos server.
+// The "options" can be nil, the cross-origin requests are rejected by default,
+// see the `OriginPatterns` and `InsecureSkipVerify` fields of the `nhooyr.AcceptOptions`.
+func Upgrader(options *nhooyr.AcceptOptions) neffos.Upgrader {
+	return func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
+		underline, err := nhooyr.Accept(w, r, options)
+		if err != nil {
+			return nil, err
+		}
+
+		return newSocket(underline, r, false), nil
+	}
+}
@@ -0,0 +1,61 @@
+package otelneffos_test
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+	"github.com/kataras/neffos/otelneffos"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// The spans of the events are exported to the standard output.
+func Example() {
+	exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	defer provider.Shutdown(context.Background())
+	otel.SetTracerProvider(provider)
+
+	events := neffos.Namespaces{"default": neffos.Events{}}
+	events["default"].SetCtx("search", func(ctx context.Context, c *neffos.NSConn, msg neffos.Message) error {
+		// the "ctx" carries the event's span, pass it to the downstream calls,
+		// i.e http.NewRequestWithContext(ctx, ...) or db.QueryContext(ctx, ...).
+		return neffos.Reply(msg.Body)
+	})
+
+	// nil for the global provider.
+	tracer := otelneffos.New(nil)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	server.Use(tracer.Middleware())
+
+	go http.ListenAndServe(":8080", server)
+
+	// the client-side, the asks are traced through the tracer.
+	client, err := neffos.Dial(context.Background(), gorilla.DefaultDialer, "ws://localhost:8080", events)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.Background(), "default")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reply, err := tracer.Ask(context.Background(), c.Conn, neffos.Message{Namespace: "default", Event: "search", Body: []byte("neffos")})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("%s", reply.Body)
+}
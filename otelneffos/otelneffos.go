@@ -0,0 +1,141 @@
+// Package otelneffos provides the OpenTelemetry tracing of the neffos events.
+// It's a separate module so the core one does not depend on the OpenTelemetry packages.
+//
+// Register the `Tracer#Middleware` through the `neffos.Server#Use` on the server-side
+// and the `neffos.Namespaces#Use` on the client-side, and send the traced requests through the `Tracer#Ask`.
+package otelneffos
+
+import (
+	"context"
+
+	"github.com/kataras/neffos"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the spans.
+const ScopeName = "github.com/kataras/neffos/otelneffos"
+
+// The attributes of the spans.
+const (
+	NamespaceKey = attribute.Key("neffos.namespace")
+	RoomKey      = attribute.Key("neffos.room")
+	EventKey     = attribute.Key("neffos.event")
+	ConnIDKey    = attribute.Key("neffos.conn.id")
+	// TraceIDKey is the `neffos.Message.TraceID` of an incoming message
+	// when it's not a W3C trace context, i.e a generated one, see `neffos.Server.GenerateTraceID`.
+	TraceIDKey = attribute.Key("neffos.trace_id")
+)
+
+// Tracer traces the incoming events and the asks of the neffos connections.
+//
+// The span context of an ask is sent through the `neffos.Message.TraceID`,
+// formatted as a W3C "traceparent", so the span of its event on the remote side is a child of it.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TraceContext
+}
+
+// New returns a new `Tracer` of the "provider", the global one if nil, see `otel.SetTracerProvider`.
+func New(provider trace.TracerProvider) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return &Tracer{tracer: provider.Tracer(ScopeName)}
+}
+
+// Middleware returns a middleware which starts a span for each dispatched event,
+// with its namespace, room, event and connection ID as attributes.
+// The span is a child of the remote one when the `neffos.Message.TraceID` carries its context, see `Ask`.
+// The span is set to the `neffos.Message#Context`, so the spans of the downstream calls
+// of a callback of the `neffos.Events#SetCtx` nest under it.
+//
+// The errors of the callbacks, except the replies, set the status of the span to error.
+func (t *Tracer) Middleware() neffos.Middleware {
+	return func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+		return func(c *neffos.NSConn, msg neffos.Message) error {
+			ctx := msg.Context()
+			if ctx == context.Background() && c != nil && c.Conn != nil {
+				// i.e a lifecycle event, keep the cancellation on close.
+				ctx = c.Conn.Context()
+			}
+
+			attrs := attributes(c, msg)
+			if msg.TraceID != "" {
+				remote := trace.SpanContextFromContext(t.extract(ctx, msg.TraceID))
+				if remote.IsValid() && remote.IsRemote() {
+					ctx = trace.ContextWithRemoteSpanContext(ctx, remote)
+				} else {
+					attrs = append(attrs, TraceIDKey.String(msg.TraceID))
+				}
+			}
+
+			ctx, span := t.tracer.Start(ctx, spanName(msg), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+			defer span.End()
+
+			err := next(c, msg.WithContext(ctx))
+			if err != nil && err != neffos.ErrReplyDeferred && !neffos.IsReply(err) {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+
+			return err
+		}
+	}
+}
+
+// Ask is like the `neffos.Conn#Ask` but it's traced by a span which covers the full round trip,
+// a child of the span of the "ctx", if any, i.e the one of an event callback.
+// The span context is sent as the `neffos.Message.TraceID`, which should not be set.
+func (t *Tracer) Ask(ctx context.Context, c *neffos.Conn, msg neffos.Message) (neffos.Message, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	attrs := append(attributes(nil, msg), ConnIDKey.String(c.ID()))
+	ctx, span := t.tracer.Start(ctx, spanName(msg), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	defer span.End()
+
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	msg.TraceID = carrier.Get("traceparent")
+
+	reply, err := c.Ask(ctx, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return reply, err
+}
+
+// extract returns the "ctx" with the remote span context of the "traceID", if it's a W3C "traceparent".
+func (t *Tracer) extract(ctx context.Context, traceID string) context.Context {
+	return t.propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceID})
+}
+
+func spanName(msg neffos.Message) string {
+	if msg.Namespace == "" {
+		return msg.Event
+	}
+
+	return msg.Namespace + "." + msg.Event
+}
+
+func attributes(c *neffos.NSConn, msg neffos.Message) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{NamespaceKey.String(msg.Namespace), EventKey.String(msg.Event)}
+	if msg.Room != "" {
+		attrs = append(attrs, RoomKey.String(msg.Room))
+	}
+
+	if c != nil && c.Conn != nil {
+		attrs = append(attrs, ConnIDKey.String(c.Conn.ID()))
+	}
+
+	return attrs
+}
@@ -0,0 +1,140 @@
+package otelneffos_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+	"github.com/kataras/neffos/otelneffos"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	var (
+		recorder = tracetest.NewSpanRecorder()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		tracer   = otelneffos.New(provider)
+
+		namespace    = "default"
+		errFail      = errors.New("fail")
+		notified     = make(chan struct{})
+		serverEvents = neffos.Namespaces{namespace: neffos.Events{
+			"fail": func(c *neffos.NSConn, msg neffos.Message) error {
+				return errFail
+			},
+			"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+				close(notified)
+				return nil
+			},
+		}}
+		clientEvents = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+	serverEvents[namespace].SetCtx("echo", func(ctx context.Context, c *neffos.NSConn, msg neffos.Message) error {
+		// i.e a database call.
+		_, span := provider.Tracer("db").Start(ctx, "query")
+		span.End()
+		return neffos.Reply(msg.Body)
+	})
+	clientEvents.Use(tracer.Middleware())
+
+	server := neffos.New(gorilla.DefaultUpgrader, serverEvents)
+	server.Use(tracer.Middleware())
+	defer server.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1), clientEvents, neffos.WithGenerateTraceID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := tracer.Ask(context.TODO(), c.Conn, neffos.Message{Namespace: namespace, Event: "echo", Body: []byte("data")})
+	if err != nil || string(reply.Body) != "data" {
+		t.Fatalf("expected the reply but got: %q: %v", reply.Body, err)
+	}
+
+	if _, err = tracer.Ask(context.TODO(), c.Conn, neffos.Message{Namespace: namespace, Event: "fail"}); err == nil {
+		t.Fatalf("expected the error of the event")
+	}
+
+	// a trace ID which is not a trace context.
+	c.Emit("notify", nil)
+	<-notified
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		key := span.Name() + " " + span.SpanKind().String()
+		spans[key] = append(spans[key], span)
+	}
+
+	get := func(key string) sdktrace.ReadOnlySpan {
+		t.Helper()
+		if len(spans[key]) == 0 {
+			t.Fatalf("expected the span: %s but got: %v", key, spans)
+		}
+		return spans[key][0]
+	}
+
+	// the connect events of both sides.
+	if connected := spans["default._OnNamespaceConnected server"]; len(connected) != 2 {
+		t.Fatalf("expected the connected event's spans of both sides but got: %v", spans)
+	}
+	if got := get("default._OnNamespaceConnect server").Attributes(); !hasAttribute(got, "neffos.conn.id", client.ID) || !hasAttribute(got, "neffos.namespace", namespace) {
+		t.Fatalf("expected the attributes of the connection but got: %v", got)
+	}
+
+	// the remote event is a child of the ask and the downstream calls are children of the event.
+	ask, event, query := get("default.echo client"), get("default.echo server"), get("query internal")
+	if event.Parent().SpanID() != ask.SpanContext().SpanID() || !event.Parent().IsRemote() {
+		t.Fatalf("expected the event's span to be a child of the ask's one")
+	}
+	if query.Parent().SpanID() != event.SpanContext().SpanID() {
+		t.Fatalf("expected the downstream span to be a child of the event's one")
+	}
+	if ask.SpanContext().TraceID() != query.SpanContext().TraceID() {
+		t.Fatalf("expected the spans of the ask to share its trace")
+	}
+	if event.Status().Code == codes.Error || ask.Status().Code == codes.Error {
+		t.Fatalf("expected the reply to not be an error")
+	}
+	if event.EndTime().After(ask.EndTime()) {
+		t.Fatalf("expected the ask's span to cover the round trip")
+	}
+
+	if failed := get("default.fail server"); failed.Status().Code != codes.Error || failed.Status().Description != errFail.Error() {
+		t.Fatalf("expected the error status but got: %v", failed.Status())
+	}
+	if notify := get("default.notify server"); notify.Parent().IsValid() || !hasAttribute(notify.Attributes(), "neffos.trace_id", "") {
+		t.Fatalf("expected the generated trace ID as an attribute of a root span but got: %v", notify.Attributes())
+	}
+
+	if failed := get("default.fail client"); failed.Status().Code != codes.Error {
+		t.Fatalf("expected the error status of the ask but got: %v", failed.Status())
+	}
+}
+
+// hasAttribute reports whether the "attrs" contain the "key" with the "value", any value if empty.
+func hasAttribute(attrs []attribute.KeyValue, key, value string) bool {
+	for _, attr := range attrs {
+		if string(attr.Key) == key && (value == "" || attr.Value.AsString() == value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,196 @@
+package neffos
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ParseError describes a malformed incoming payload,
+// it's fired on the `Server.OnError` when the `Server.StrictParsing` is enabled.
+type ParseError struct {
+	// Offset is the byte offset of the payload where the parsing failed.
+	Offset int
+	// Field is the name of the malformed field, i.e "isError", "extensions" or "json".
+	Field string
+	// Raw is the received payload, truncated to `maxParseErrorRawSize` bytes.
+	Raw []byte
+}
+
+// the maximum size of the `ParseError.Raw`, so a huge payload is not kept in memory or logs.
+const maxParseErrorRawSize = 256
+
+func newParseError(b []byte, offset int, field string) *ParseError {
+	if len(b) > maxParseErrorRawSize {
+		b = b[:maxParseErrorRawSize]
+	}
+
+	return &ParseError{Offset: offset, Field: field, Raw: append([]byte(nil), b...)}
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid %s field at offset %d", e.Field, e.Offset)
+}
+
+// the names of the serialized message fields, by their position.
+var messageFieldNames = [validMessageSepCount]string{"wait", "namespace", "room", "event", "isError", "isNoOp", "body"}
+
+// parsePayload validates a serialized message strictly and returns the first malformed field, if any.
+// The wait token is returned even if a later field is malformed, so the error can be sent back.
+func parsePayload(b []byte, limits *MessageLimits) (string, *ParseError) {
+	if len(b) == 0 {
+		return "", newParseError(b, 0, messageFieldNames[0])
+	}
+
+	max := limits.fields()
+
+	var (
+		dts     [validMessageSepCount][]byte
+		offsets [validMessageSepCount]int
+		offset  int
+		rest    = b
+	)
+
+	for i := 0; i < validMessageSepCount-1; i++ {
+		idx := bytes.IndexByte(rest, messageSeparatorByte)
+		if idx == -1 || idx > max[i] {
+			var wait string
+			if i > 0 {
+				wait = string(dts[0])
+			}
+
+			if idx == -1 {
+				// the separator after this field is missing.
+				return wait, newParseError(b, len(b), messageFieldNames[i])
+			}
+
+			// longer than its limit.
+			return wait, newParseError(b, offset+max[i], messageFieldNames[i])
+		}
+
+		dts[i], offsets[i] = rest[:idx], offset
+		offset += idx + 1
+		rest = rest[idx+1:]
+	}
+	dts[validMessageSepCount-1], offsets[validMessageSepCount-1] = rest, offset
+
+	wait := string(dts[0])
+
+	if !isBoolField(dts[4]) {
+		return wait, newParseError(b, offsets[4], messageFieldNames[4])
+	}
+
+	noOp, ext := splitExtensions(dts[5])
+	if !isBoolField(noOp) {
+		return wait, newParseError(b, offsets[5], messageFieldNames[5])
+	}
+
+	if ext != nil {
+		if pairOffset, ok := validExtensions(ext, limits.maxExtensions()); !ok {
+			return wait, newParseError(b, offsets[5]+len(noOp)+1+pairOffset, "extensions")
+		}
+	}
+
+	return wait, nil
+}
+
+func isBoolField(b []byte) bool {
+	return bytes.Equal(b, trueByte) || bytes.Equal(b, falseByte)
+}
+
+// validExtensions reports whether all the "ext" key-value pairs are well formed
+// and the values of the known keys are valid, otherwise it returns the offset of the first malformed pair.
+func validExtensions(ext []byte, max int) (int, bool) {
+	offset := 0
+	for n := 1; len(ext) > 0; n++ {
+		if n > max {
+			return offset, false
+		}
+
+		pair := ext
+		if idx := bytes.IndexByte(ext, extensionSeparator); idx >= 0 {
+			pair = ext[:idx]
+		}
+
+		idx := bytes.IndexByte(pair, '=')
+		if idx <= 0 {
+			return offset, false
+		}
+
+		value, err := url.QueryUnescape(string(pair[idx+1:]))
+		if err != nil {
+			return offset, false
+		}
+
+		switch string(pair[:idx]) {
+		case extensionExpiry, extensionSentAt:
+			if _, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return offset, false
+			}
+		case extensionCompressed:
+			if value != "1" {
+				return offset, false
+			}
+		case extensionChunk:
+			if _, ok := parseChunkHeader(value); !ok {
+				return offset, false
+			}
+		}
+
+		if len(pair) == len(ext) {
+			break
+		}
+
+		offset += len(pair) + 1
+		ext = ext[len(pair)+1:]
+	}
+
+	return 0, true
+}
+
+// parseJSONPayload returns the `ParseError` of a malformed `JSONProtocol` frame.
+func parseJSONPayload(b []byte) (string, *ParseError) {
+	var v jsonMessage
+	err := json.Unmarshal(b, &v)
+	if err == nil {
+		// the body is a malformed JSON string.
+		return v.Wait, newParseError(b, 0, "body")
+	}
+
+	offset := 0
+	switch typed := err.(type) {
+	case *json.SyntaxError:
+		offset = int(typed.Offset)
+	case *json.UnmarshalTypeError:
+		offset = int(typed.Offset)
+	}
+
+	return "", newParseError(b, offset, "json")
+}
+
+// reportParseError fires the `ParseError` of the invalid "msg" on the `Server.OnError`
+// and sends it back to the remote side if the payload carried a wait token.
+func (c *Conn) reportParseError(msg Message) {
+	if msg.parseErr == nil {
+		return
+	}
+
+	c.fireError(msg.parseErr)
+
+	if msg.wait == "" {
+		return
+	}
+
+	// it is written directly, the namespace of the payload may not be connected or even valid.
+	reply := Message{wait: msg.wait, Err: msg.parseErr}
+	if c.jsonProtocol {
+		c.writeJSON(reply)
+		return
+	}
+
+	buf := acquireBuffer()
+	c.write(serializeMessageTo(buf, reply), false)
+	releaseBuffer(buf)
+}
@@ -0,0 +1,63 @@
+package neffos
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParsePayload(t *testing.T) {
+	var tests = []struct {
+		payload string
+		wait    string
+		field   string
+		offset  int
+	}{
+		{";default;;chat;0;0;body", "", "", 0},
+		{"$1;default;room;chat;1;0;error text", "$1", "", 0},
+		{";default;;chat;0;0?x=1589790005000&s=1&z=1&c=1.0.1.4&unknown=value;body", "", "", 0},
+		{"", "", "wait", 0},
+		{"garbage", "", "wait", 7},
+		{"$1;default;;chat", "$1", "event", 16},
+		{"$1;default;;chat;2;0;body", "$1", "isError", 17},
+		{";default;;chat;0;true;body", "", "isNoOp", 17},
+		{";default;;chat;0;0?x=soon;body", "", "extensions", 19},
+		{";default;;chat;0;0?s=1&novalue;body", "", "extensions", 23},
+		{";default;;chat;0;0?c=1.2.1.4;body", "", "extensions", 19},
+		{";default;;chat;0;0?t=%zz;body", "", "extensions", 19},
+	}
+
+	for _, tt := range tests {
+		wait, err := parsePayload([]byte(tt.payload), &DefaultMessageLimits)
+		if wait != tt.wait {
+			t.Fatalf("[%s] expected wait: %q but got: %q", tt.payload, tt.wait, wait)
+		}
+
+		if tt.field == "" {
+			if err != nil {
+				t.Fatalf("[%s] expected a valid payload but got: %v", tt.payload, err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Fatalf("[%s] expected a parse error", tt.payload)
+		}
+
+		if err.Field != tt.field || err.Offset != tt.offset || string(err.Raw) != tt.payload {
+			t.Fatalf("[%s] expected field: %s at offset: %d but got: %#+v", tt.payload, tt.field, tt.offset, err)
+		}
+	}
+
+	large := bytes.Repeat([]byte("a"), maxParseErrorRawSize*2)
+	if _, err := parsePayload(large, &DefaultMessageLimits); err == nil || len(err.Raw) != maxParseErrorRawSize {
+		t.Fatalf("expected the raw payload to be truncated to %d bytes", maxParseErrorRawSize)
+	}
+}
+
+func TestParsePayloadLimits(t *testing.T) {
+	limits := MessageLimits{MaxEventLength: 4}
+	_, err := parsePayload([]byte(";default;;event;0;0;body"), &limits)
+	if err == nil || err.Field != "event" || err.Offset != 14 {
+		t.Fatalf("expected the event field to exceed its limit at offset 14 but got: %#+v", err)
+	}
+}
@@ -0,0 +1,327 @@
+package neffos
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PipeBufferSize is the number of the messages which a pipe socket buffers
+// before its writes block, like the buffer of a network connection, see `NewPipeSockets`.
+var PipeBufferSize = 256
+
+// NewPipeSockets returns two connected in-memory sockets, i.e for tests without networking.
+// The messages which the one writes are read by the other, in order.
+// The reads and writes support the timeouts and the deadlines of their `NetConn`,
+// a closed socket fails the pending and the next reads and writes of both sides,
+// the messages which were written before the close are still read.
+//
+// See `PipeDialer` to dial a server through them, with the same acknowledgement as the network sockets.
+func NewPipeSockets() (server Socket, client Socket) {
+	serverConn, clientConn := newPipeConns(SystemClock)
+	return &pipeSocket{conn: serverConn, request: newPipeRequest("/")}, &pipeSocket{conn: clientConn, request: newPipeRequest("/")}
+}
+
+// PipeDialer returns a `Dialer` which connects to the "server" through a new pair of `NewPipeSockets`,
+// the server-side one is served like the `Server#ServeSocket` does.
+// The "url" of the `Dial` is the URL of the server-side request, i.e for its query parameters.
+//
+// Usage:
+//
+//	client, err := neffos.Dial(ctx, neffos.PipeDialer(server), "/echo", events)
+func PipeDialer(server *Server) Dialer {
+	return func(ctx context.Context, rawURL string) (Socket, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// the timeouts of both sides follow the clock of the server, see `Server.Clock`.
+		serverConn, clientConn := newPipeConns(clockOrSystem(server.Clock))
+		// the server-side one is modified by the server.
+		r := newPipeRequest(rawURL)
+		serverSocket := &pipeSocket{conn: serverConn, request: r}
+
+		// the server may connect the client to a namespace on its `OnConnect`,
+		// so it's served after the client's reader starts.
+		go server.ServeSocket(&pipeResponseWriter{header: make(http.Header)}, r, serverSocket, nil)
+		return &pipeSocket{conn: clientConn, request: newPipeRequest(rawURL)}, nil
+	}
+}
+
+// newPipeRequest returns the synthetic request of the "rawURL" of the sockets of a pipe.
+func newPipeRequest(rawURL string) *http.Request {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		u = &url.URL{Path: "/"}
+	}
+	// i.e the "ws://" prefix of the `Dial`.
+	u.Scheme, u.Host = "", ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Host:       pipeAddr{}.String(),
+		RemoteAddr: pipeAddr{}.String(),
+		RequestURI: u.RequestURI(),
+	}
+}
+
+// pipeResponseWriter is passed to the server's `IDGenerator`, there is no response to write.
+type pipeResponseWriter struct {
+	header http.Header
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *pipeResponseWriter) WriteHeader(statusCode int) {}
+
+// pipeSocket is a socket of the `NewPipeSockets`, each message is a chunk of its pipe connection,
+// prefixed by its type.
+type pipeSocket struct {
+	conn    *pipeConn
+	request *http.Request
+	// see `SetReadLimit`.
+	readLimit int64
+}
+
+func (s *pipeSocket) NetConn() net.Conn {
+	return s.conn
+}
+
+func (s *pipeSocket) Request() *http.Request {
+	return s.request
+}
+
+func (s *pipeSocket) ReadData(timeout time.Duration) ([]byte, MessageType, error) {
+	if timeout > 0 {
+		s.conn.SetReadDeadline(s.conn.clock.Now().Add(timeout))
+	}
+
+	chunk, err := s.conn.readChunk()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if s.readLimit > 0 && int64(len(chunk)-1) > s.readLimit {
+		return nil, 0, ErrMessageTooLarge
+	}
+
+	return chunk[1:], MessageType(chunk[0]), nil
+}
+
+func (s *pipeSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.write(BinaryMessage, body, timeout)
+}
+
+func (s *pipeSocket) WriteText(body []byte, timeout time.Duration) error {
+	return s.write(TextMessage, body, timeout)
+}
+
+func (s *pipeSocket) write(typ byte, body []byte, timeout time.Duration) error {
+	if timeout > 0 {
+		s.conn.SetWriteDeadline(s.conn.clock.Now().Add(timeout))
+	}
+
+	// the "body" is not retained.
+	chunk := make([]byte, len(body)+1)
+	chunk[0] = typ
+	copy(chunk[1:], body)
+	return s.conn.writeChunk(chunk)
+}
+
+// SetReadLimit completes the `SocketReadLimiter` interface.
+func (s *pipeSocket) SetReadLimit(limit int64) {
+	s.readLimit = limit
+}
+
+var errPipeClosed = errors.New("use of closed network connection")
+
+// pipeTimeoutError is the error of the reads and writes after their deadline, see `IsTimeoutError`.
+type pipeTimeoutError struct{}
+
+func (pipeTimeoutError) Error() string   { return "i/o timeout" }
+func (pipeTimeoutError) Timeout() bool   { return true }
+func (pipeTimeoutError) Temporary() bool { return true }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeConn is an in-memory `net.Conn` which sends its writes as chunks to the other side,
+// unlike the `net.Pipe` its writes are buffered, so both sides can write while they handle a message.
+type pipeConn struct {
+	in  chan []byte
+	out chan []byte
+	// closed, by both sides, when any of them is closed.
+	done      chan struct{}
+	closeOnce *sync.Once
+	// this side is closed, its reads fail even if there are unread chunks.
+	closed uint32
+	// the clock of the deadlines.
+	clock Clock
+
+	readDeadline  atomic.Value
+	writeDeadline atomic.Value
+
+	// the rest of the chunk of a partial `Read`.
+	pending []byte
+}
+
+func newPipeConns(clock Clock) (*pipeConn, *pipeConn) {
+	a, b := make(chan []byte, PipeBufferSize), make(chan []byte, PipeBufferSize)
+	done, closeOnce := make(chan struct{}), new(sync.Once)
+
+	return &pipeConn{in: a, out: b, done: done, closeOnce: closeOnce, clock: clock},
+		&pipeConn{in: b, out: a, done: done, closeOnce: closeOnce, clock: clock}
+}
+
+// deadlineTimer returns a channel which is closed on the "deadline", nil if it's zero.
+// The "expired" is true if the deadline has passed already.
+func (c *pipeConn) deadlineTimer(deadline *atomic.Value) (timeout <-chan time.Time, stop func() bool, expired bool) {
+	t, _ := deadline.Load().(time.Time)
+	if t.IsZero() {
+		return nil, func() bool { return false }, false
+	}
+
+	d := t.Sub(c.clock.Now())
+	if d <= 0 {
+		return nil, nil, true
+	}
+
+	timer := c.clock.NewTimer(d)
+	return timer.C(), timer.Stop, false
+}
+
+func (c *pipeConn) readChunk() ([]byte, error) {
+	if atomic.LoadUint32(&c.closed) == 1 {
+		return nil, c.opError("read", errPipeClosed)
+	}
+
+	// the chunks which were written before the close of the other side are read first.
+	select {
+	case chunk := <-c.in:
+		return chunk, nil
+	default:
+	}
+
+	timeout, stop, expired := c.deadlineTimer(&c.readDeadline)
+	if expired {
+		return nil, c.opError("read", pipeTimeoutError{})
+	}
+	defer stop()
+
+	select {
+	case chunk := <-c.in:
+		return chunk, nil
+	case <-c.done:
+		if atomic.LoadUint32(&c.closed) == 0 {
+			select {
+			case chunk := <-c.in:
+				return chunk, nil
+			default:
+			}
+		}
+
+		return nil, c.opError("read", errPipeClosed)
+	case <-timeout:
+		return nil, c.opError("read", pipeTimeoutError{})
+	}
+}
+
+func (c *pipeConn) writeChunk(chunk []byte) error {
+	select {
+	case <-c.done:
+		return c.opError("write", errPipeClosed)
+	default:
+	}
+
+	timeout, stop, expired := c.deadlineTimer(&c.writeDeadline)
+	if expired {
+		return c.opError("write", pipeTimeoutError{})
+	}
+	defer stop()
+
+	select {
+	case c.out <- chunk:
+		return nil
+	case <-c.done:
+		return c.opError("write", errPipeClosed)
+	case <-timeout:
+		return c.opError("write", pipeTimeoutError{})
+	}
+}
+
+func (c *pipeConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "pipe", Source: pipeAddr{}, Addr: pipeAddr{}, Err: err}
+}
+
+// Read reads the written chunks of the other side as a stream.
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		chunk, err := c.readChunk()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = chunk
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends a copy of the "b" as a chunk.
+func (c *pipeConn) Write(b []byte) (int, error) {
+	if err := c.writeChunk(append([]byte(nil), b...)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *pipeConn) Close() error {
+	atomic.StoreUint32(&c.closed, 1)
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Store(t)
+	return nil
+}
@@ -0,0 +1,110 @@
+package neffos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+func TestPipeSockets(t *testing.T) {
+	server, client := neffos.NewPipeSockets()
+
+	if err := client.WriteText([]byte("text"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteBinary([]byte("binary"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []struct {
+		body string
+		typ  neffos.MessageType
+	}{{"text", neffos.TextMessage}, {"binary", neffos.BinaryMessage}} {
+		b, typ, err := server.ReadData(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected.body || typ != expected.typ {
+			t.Fatalf("expected: %q of type: %d but got: %q of type: %d", expected.body, expected.typ, b, typ)
+		}
+	}
+
+	if _, _, err := server.ReadData(20 * time.Millisecond); !neffos.IsTimeoutError(err) {
+		t.Fatalf("expected a timeout error but got: %v", err)
+	}
+	// like the network connections, the deadline is kept until it's reset.
+	server.NetConn().SetReadDeadline(time.Time{})
+
+	server.(neffos.SocketReadLimiter).SetReadLimit(4)
+	client.WriteText([]byte("large"), 0)
+	if _, _, err := server.ReadData(0); err != neffos.ErrMessageTooLarge {
+		t.Fatalf("expected: %v but got: %v", neffos.ErrMessageTooLarge, err)
+	}
+
+	// the messages which were written before the close are still read.
+	client.WriteText([]byte("last"), 0)
+	client.NetConn().Close()
+
+	if b, _, err := server.ReadData(0); err != nil || string(b) != "last" {
+		t.Fatalf("expected the last message but got: %q: %v", b, err)
+	}
+	if _, _, err := server.ReadData(0); !neffos.IsCloseError(err) {
+		t.Fatalf("expected a close error but got: %v", err)
+	}
+	if err := server.WriteText([]byte("closed"), 0); !neffos.IsCloseError(err) {
+		t.Fatalf("expected a close error but got: %v", err)
+	}
+}
+
+func TestPipeDialer(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						if expected, got := "pipe", c.Conn.Socket().Request().URL.Query().Get("name"); expected != got {
+							t.Errorf("expected the query of the dial url: %q but got: %q", expected, got)
+						}
+						return neffos.Reply(msg.Body)
+					}
+					return nil
+				},
+			},
+		}
+		disconnected = make(chan struct{})
+	)
+
+	server := neffos.New(nil, events)
+	server.OnDisconnect = func(c *neffos.Conn) {
+		close(disconnected)
+	}
+	defer server.Close()
+
+	client, err := neffos.Dial(context.TODO(), neffos.PipeDialer(server), "/echo?name=pipe", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := c.Ask(context.TODO(), "echo", []byte("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "body", string(msg.Body); expected != got {
+		t.Fatalf("expected the reply: %q but got: %q", expected, got)
+	}
+
+	client.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the server connection to be closed after the client's close")
+	}
+}
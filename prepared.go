@@ -0,0 +1,52 @@
+package neffos
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+)
+
+// preparedMessage is the prepared message of a `Server#Broadcast` message,
+// it's shared by its connections so the sockets which implement the `SocketPreparedWriter`
+// prepare it once per socket type instead of once per connection, i.e gorilla compresses it once.
+type preparedMessage struct {
+	mu      sync.Mutex
+	entries []preparedEntry
+}
+
+type preparedEntry struct {
+	typ    reflect.Type
+	data   []byte
+	binary bool
+	pm     interface{}
+}
+
+// load returns the prepared message of the serialized "data" for the "w" socket's type, it prepares it on the first call.
+// It returns nil when the message can not be prepared or when the "data" differs from the prepared one,
+// i.e a connection's trace ID or sent time, so the caller writes it as it is.
+func (p *preparedMessage) load(w SocketPreparedWriter, data []byte, binary bool) interface{} {
+	typ := reflect.TypeOf(w)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, entry := range p.entries {
+		if entry.typ == typ {
+			if entry.binary != binary || !bytes.Equal(entry.data, data) {
+				return nil
+			}
+
+			return entry.pm
+		}
+	}
+
+	// the "data" is a pooled buffer.
+	data = append([]byte(nil), data...)
+	pm, err := w.PrepareMessage(data, binary)
+	if err != nil {
+		return nil
+	}
+
+	p.entries = append(p.entries, preparedEntry{typ: typ, data: data, binary: binary, pm: pm})
+	return pm
+}
@@ -6,6 +6,8 @@ import (
 )
 
 // processes is a collection of `process`.
+// An entry lives as long as it's referenced, see `get` and `release`,
+// so the namespaces of the remote side cannot grow it.
 type processes struct {
 	entries map[string]*process
 	locker  *sync.RWMutex
@@ -18,28 +20,63 @@ func newProcesses() *processes {
 	}
 }
 
+// get returns the process of the "name", it's created if missing.
+// The caller should `release` it when it's done.
 func (p *processes) get(name string) *process {
-	p.locker.RLock()
+	p.locker.Lock()
 	entry := p.entries[name]
-	p.locker.RUnlock()
-
 	if entry == nil {
 		entry = &process{
 			finished: make(chan struct{}),
 		}
-
-		p.locker.Lock()
 		p.entries[name] = entry
-		p.locker.Unlock()
 	}
+	entry.refs++
+	p.locker.Unlock()
 
 	return entry
 }
 
+// release removes the "entry" of the "name" when there is no other reference to it.
+func (p *processes) release(name string, entry *process) {
+	p.locker.Lock()
+	entry.refs--
+	if entry.refs <= 0 && p.entries[name] == entry {
+		delete(p.entries, name)
+	}
+	p.locker.Unlock()
+}
+
+// wait waits for the process of the "name" to be done, if it exists, it never creates one.
+func (p *processes) wait(name string) {
+	p.locker.Lock()
+	entry := p.entries[name]
+	if entry == nil {
+		p.locker.Unlock()
+		return
+	}
+	entry.refs++
+	p.locker.Unlock()
+
+	entry.Wait()
+	p.release(name, entry)
+}
+
+// len returns the number of the entries.
+func (p *processes) len() int {
+	p.locker.RLock()
+	n := len(p.entries)
+	p.locker.RUnlock()
+
+	return n
+}
+
 // process is used on connections on specific actions that needs to wait for an answer from the other side.
 // Take for example the `Conn#handleMessage.tryNamespace` which waits for `Conn#askConnect` to finish on the specific namespace.
 type process struct {
 	done uint32
+	// the references of the `processes`, guarded by its locker.
+	refs int
 
 	finished chan struct{}
 	waiting  sync.WaitGroup
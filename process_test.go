@@ -1,7 +1,9 @@
 package neffos
 
 import (
+	"context"
 	"fmt"
+	"runtime"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -80,3 +82,98 @@ func TestProcessSingalFinished(t *testing.T) {
 		t.Fatalf("%s process should tik-tok for %d seconds but: %d", testProcessName, sleepSecs, counts)
 	}
 }
+
+func TestProcessesBogusNamespaces(t *testing.T) {
+	server := New(nil, Namespaces{"default": Events{}})
+	defer server.Close()
+
+	c, remote := newTestACKConn(server)
+	defer remote.NetConn().Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	for i := 0; i < 10000; i++ {
+		if _, ok := c.tryNamespace(Message{Namespace: fmt.Sprintf("bogus-%d", i), Event: "event"}); ok {
+			t.Fatalf("expected the bogus namespace to be rejected")
+		}
+	}
+
+	if n := c.processes.len(); n != 0 {
+		t.Fatalf("expected no processes but got: %d", n)
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if grown := int64(after.HeapAlloc) - int64(before.HeapAlloc); grown > 1<<20 {
+		t.Fatalf("expected a stable heap but it has grown by: %d bytes", grown)
+	}
+}
+
+func TestProcessesBlockTryNamespace(t *testing.T) {
+	server := New(nil, Namespaces{"default": Events{}})
+	defer server.Close()
+
+	c, remote := newTestACKConn(server)
+	defer remote.NetConn().Close()
+
+	// like the `askConnect` does.
+	p := c.processes.get("default")
+	p.Start()
+
+	result := make(chan bool)
+	go func() {
+		_, ok := c.tryNamespace(Message{Namespace: "default", Event: "event"})
+		result <- ok
+	}()
+
+	select {
+	case <-result:
+		t.Fatalf("expected the tryNamespace to wait for the connect process")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.connectedNamespacesMutex.Lock()
+	c.connectedNamespaces["default"] = newNSConn(c, "default", Events{})
+	c.connectedNamespacesMutex.Unlock()
+
+	p.Done()
+	c.processes.release("default", p)
+
+	select {
+	case ok := <-result:
+		if !ok {
+			t.Fatalf("expected the connected namespace")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the tryNamespace to be unblocked")
+	}
+
+	if n := c.processes.len(); n != 0 {
+		t.Fatalf("expected the finished process to be removed but got: %d processes", n)
+	}
+}
+
+func TestProcessesConnect(t *testing.T) {
+	events := Namespaces{"default": Events{}}
+	server := New(nil, events)
+	defer server.Close()
+
+	client, err := Dial(context.TODO(), PipeDialer(server), "/", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Connect(context.TODO(), "missing"); err == nil {
+		t.Fatalf("expected the missing namespace to be rejected")
+	}
+
+	if n := client.conn.processes.len(); n != 0 {
+		t.Fatalf("expected the connect processes to be removed but got: %d", n)
+	}
+}
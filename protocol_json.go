@@ -0,0 +1,178 @@
+package neffos
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// JSONProtocol is the websocket subprotocol of the JSON envelope protocol,
+// an alternative wire format for clients which cannot use the neffos.js or its delimiter-based format.
+// Each frame is a JSON object:
+//
+//	{"wait":"...","namespace":"...","room":"...","event":"...","body":...,"err":"..."}
+//
+// The "body" is written as raw JSON if the `Message.Body` is valid JSON, otherwise as a JSON string.
+// On read, a JSON string "body" is decoded to its value and any other JSON value is kept as it is.
+//
+// The connect, disconnect, join and leave semantics are the same as the default protocol's,
+// i.e {"wait":"$1","namespace":"default","event":"_OnNamespaceConnect"} connects to the "default" namespace
+// and its reply carries the same "wait" token, e.g {"wait":"$1"}. Client-side asks should use wait tokens prefixed with '$'.
+// There is no acknowledgement message to send, the server writes
+// {"event":"_OnAck","body":"<connection id>"} (or the "err" on `Server.OnConnect` failure) once it's ready.
+//
+// It is enabled by the `Server.AllowJSONProtocol`, a client selects it
+// by the "protocol=json" URL query parameter or by this subprotocol.
+// Note that the subprotocol should be registered to the upgrader too (e.g. the gorilla's `Upgrader.Subprotocols`),
+// otherwise browsers reject the handshake.
+const JSONProtocol = "neffos.json"
+
+const (
+	jsonProtocolURLParam      = "protocol"
+	jsonProtocolURLParamValue = "json"
+	// the event of the message which the server writes on a JSON connection's acknowledgement.
+	jsonProtocolAckEvent = "_OnAck"
+)
+
+// isJSONProtocolRequest reports whether the client of "r" requested the `JSONProtocol`.
+func isJSONProtocolRequest(r *http.Request) bool {
+	if r.URL.Query().Get(jsonProtocolURLParam) == jsonProtocolURLParamValue {
+		return true
+	}
+
+	for _, protocols := range r.Header["Sec-Websocket-Protocol"] {
+		for _, protocol := range strings.Split(protocols, ",") {
+			if strings.TrimSpace(protocol) == JSONProtocol {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsJSONProtocol reports whether this connection uses the `JSONProtocol`.
+func (c *Conn) IsJSONProtocol() bool {
+	return c.jsonProtocol
+}
+
+type jsonMessage struct {
+	Wait      string          `json:"wait,omitempty"`
+	Namespace string          `json:"namespace,omitempty"`
+	Room      string          `json:"room,omitempty"`
+	Event     string          `json:"event,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Err       string          `json:"err,omitempty"`
+	NoOp      bool            `json:"noOp,omitempty"`
+	Expiry    int64           `json:"expiry,omitempty"`
+	SentAt    int64           `json:"sentAt,omitempty"`
+	TraceID   string          `json:"traceId,omitempty"`
+}
+
+// writeJSONMessage writes the "msg" to the "buf" as a `JSONProtocol` frame and returns its bytes.
+func writeJSONMessage(buf *bytes.Buffer, msg Message) []byte {
+	body, errText, isError := outputError(msg.Body, msg.Err)
+	if isError && errText == "" {
+		// typed error.
+		errText, body = string(body), nil
+	}
+
+	v := jsonMessage{
+		Wait:      msg.wait,
+		Namespace: msg.Namespace,
+		Room:      msg.Room,
+		Event:     msg.Event,
+		Err:       errText,
+		NoOp:      msg.isNoOp,
+		Expiry:    msg.Expiry,
+		SentAt:    msg.SentAt,
+		TraceID:   msg.TraceID,
+	}
+
+	if msg.FromExplicit != "" {
+		v.Wait = msg.FromExplicit
+	}
+
+	if len(body) > 0 {
+		if json.Valid(body) {
+			v.Body = body
+		} else {
+			v.Body, _ = json.Marshal(string(body))
+		}
+	}
+
+	// the encoder appends a new line, it's not part of the frame.
+	json.NewEncoder(buf).Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})
+}
+
+// deserializeJSONMessage returns a Message from a `JSONProtocol` frame.
+func deserializeJSONMessage(msgTyp MessageType, b []byte) Message {
+	var v jsonMessage
+	if err := json.Unmarshal(b, &v); err != nil {
+		return Message{isInvalid: true}
+	}
+
+	msg := Message{
+		wait:      v.Wait,
+		Namespace: v.Namespace,
+		Room:      v.Room,
+		Event:     v.Event,
+		isNoOp:    v.NoOp,
+		Expiry:    v.Expiry,
+		SentAt:    v.SentAt,
+		TraceID:   v.TraceID,
+		SetBinary: msgTyp == BinaryMessage,
+	}
+
+	if isServerConnID(msg.wait) {
+		msg.FromExplicit, msg.wait = msg.wait, ""
+	}
+
+	if v.Err != "" {
+		msg.Err = resolveError(v.Err)
+		msg.isError = true
+	}
+
+	if len(v.Body) > 0 && !bytes.Equal(v.Body, []byte("null")) {
+		msg.Body = v.Body
+		if v.Body[0] == '"' {
+			var s string
+			if err := json.Unmarshal(v.Body, &s); err != nil {
+				return Message{isInvalid: true}
+			}
+			msg.Body = []byte(s)
+		}
+	}
+
+	return msg
+}
+
+// acknowledgeJSON acknowledges a server-side `JSONProtocol` connection,
+// its client does not send an ack message. It reports false if `Server.OnConnect` failed.
+func (c *Conn) acknowledgeJSON() bool {
+	ack := Message{Event: jsonProtocolAckEvent, Body: []byte(c.ID())}
+	if err := c.readiness.wait(); err != nil {
+		ack.Body, ack.Err = nil, err
+		c.writeJSON(ack)
+		return false
+	}
+
+	atomic.StoreUint32(c.acknowledged, 1)
+	c.logDebug("connection acknowledged", "protocol", "json")
+	c.applyReadLimit()
+	c.handleQueue()
+
+	ok := c.writeJSON(ack)
+	c.applyWriteBuffer()
+	return ok
+}
+
+func (c *Conn) writeJSON(msg Message) bool {
+	buf := acquireBuffer()
+	ok := c.write(writeJSONMessage(buf, msg), false)
+	releaseBuffer(buf)
+	return ok
+}
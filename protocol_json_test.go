@@ -0,0 +1,39 @@
+package neffos
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJSONProtocolMessage(t *testing.T) {
+	var tests = []struct {
+		msg      Message
+		expected string
+	}{
+		{Message{Namespace: "default", Event: "chat", Body: []byte(`{"text":"hello"}`)},
+			`{"namespace":"default","event":"chat","body":{"text":"hello"}}`},
+		{Message{Namespace: "default", Room: "room;1", Event: "chat", Body: []byte("hello")},
+			`{"namespace":"default","room":"room;1","event":"chat","body":"hello"}`},
+		{Message{wait: "$1", Namespace: "default", Event: "chat", Err: ErrBadRoom},
+			`{"wait":"$1","namespace":"default","event":"chat","err":"bad room"}`},
+		{Message{Namespace: "default", Event: "typing", Expiry: 1589790005000},
+			`{"namespace":"default","event":"typing","expiry":1589790005000}`},
+	}
+
+	for i, tt := range tests {
+		got := writeJSONMessage(new(bytes.Buffer), tt.msg)
+		if string(got) != tt.expected {
+			t.Fatalf("[%d] expected:\n%s\nbut got:\n%s", i, tt.expected, got)
+		}
+
+		msg := deserializeJSONMessage(TextMessage, got)
+		if msg.isInvalid || msg.wait != tt.msg.wait || msg.Namespace != tt.msg.Namespace || msg.Room != tt.msg.Room ||
+			msg.Event != tt.msg.Event || !bytes.Equal(msg.Body, tt.msg.Body) || msg.Err != tt.msg.Err || msg.Expiry != tt.msg.Expiry {
+			t.Fatalf("[%d] expected message: %#+v but got: %#+v", i, tt.msg, msg)
+		}
+	}
+
+	if msg := deserializeJSONMessage(TextMessage, []byte("default;chat")); !msg.isInvalid {
+		t.Fatalf("expected a non-JSON frame to be invalid")
+	}
+}
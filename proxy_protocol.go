@@ -0,0 +1,269 @@
+package neffos
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidProxyHeader is returned by the reads of a connection accepted by the `ProxyProtocolListener`
+// when it does not start with a valid PROXY protocol header, the connection is closed.
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// DefaultProxyHeaderTimeout is the default time to read the PROXY protocol header, see `ProxyProtocolListener`.
+var DefaultProxyHeaderTimeout = 5 * time.Second
+
+// The signatures of the PROXY protocol headers.
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// the maximum length of a v1 header, including the CRLF.
+	proxyV1MaxLength = 107
+	// the signature, the version and command, the family and the length of the addresses.
+	proxyV2HeaderLength = 16
+)
+
+// ProxyProtocolListener wraps the "ln" to accept connections through a load balancer or a proxy
+// which sends the HAProxy PROXY protocol (version 1 or 2) header, i.e HAProxy's "send-proxy" option,
+// so the `RemoteAddr` of its connections, the `http.Request.RemoteAddr` and the `Conn#RealRemoteAddr`
+// are the original client's address instead of the proxy's one.
+//
+// The header is read before the first read of a connection or its `RemoteAddr` call, within the "headerTimeout",
+// a zero one defaults to the `DefaultProxyHeaderTimeout`. A connection without a valid header is closed
+// and its reads fail with the `ErrInvalidProxyHeader`, so it's rejected before its HTTP request is read.
+// The "LOCAL" (v2) and "UNKNOWN" (v1) headers, i.e the health checks of the proxy, keep the proxy's address.
+//
+// Usage:
+//
+//	ln, _ := net.Listen("tcp", ":8080")
+//	http.Serve(neffos.ProxyProtocolListener(ln, 0), server)
+func ProxyProtocolListener(ln net.Listener, headerTimeout time.Duration) net.Listener {
+	if headerTimeout <= 0 {
+		headerTimeout = DefaultProxyHeaderTimeout
+	}
+
+	return &proxyListener{Listener: ln, headerTimeout: headerTimeout}
+}
+
+type proxyListener struct {
+	net.Listener
+	headerTimeout time.Duration
+}
+
+func (ln *proxyListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConn{
+		Conn:          conn,
+		reader:        bufio.NewReader(conn),
+		headerTimeout: ln.headerTimeout,
+	}, nil
+}
+
+// proxyConn is a connection of the `ProxyProtocolListener`,
+// its header is read lazily so the listener's accept loop never blocks.
+type proxyConn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyConn) readHeader() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		src, dst, err := readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+
+		if err != nil {
+			c.err = err
+			c.Conn.Close()
+			return
+		}
+
+		c.remoteAddr, c.localAddr = src, dst
+	})
+
+	return c.err
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.readHeader() == nil && c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.readHeader() == nil && c.localAddr != nil {
+		return c.localAddr
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads a v1 or v2 PROXY protocol header and returns its source and destination addresses,
+// they are nil for the "LOCAL" and "UNKNOWN" headers.
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	b, err := r.Peek(len(proxyV1Signature))
+	if err != nil {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	if bytes.Equal(b, proxyV1Signature) {
+		return readProxyHeaderV1(r)
+	}
+
+	return readProxyHeaderV2(r)
+}
+
+// readProxyHeaderV1 reads a header of the human-readable version,
+// i.e "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, ErrInvalidProxyHeader
+		}
+
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	src, err := parseProxyAddr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dst, err := parseProxyAddr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return src, dst, nil
+}
+
+func parseProxyAddr(host, port string, v4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != v4 {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readProxyHeaderV2 reads a header of the binary version.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	version, command := header[12]>>4, header[12]&0x0F
+	if version != 2 || command > 1 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	// the addresses and the TLVs, which are skipped.
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	if command == 0 { // LOCAL.
+		return nil, nil, nil
+	}
+
+	family, transport := header[13]>>4, header[13]&0x0F
+	if transport != 1 && transport != 2 { // STREAM and DGRAM.
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	var ipLength int
+	switch family {
+	case 0: // UNSPEC.
+		return nil, nil, nil
+	case 1: // INET.
+		ipLength = net.IPv4len
+	case 2: // INET6.
+		ipLength = net.IPv6len
+	case 3: // UNIX, the addresses are not IP ones.
+		return nil, nil, nil
+	default:
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	if len(payload) < 2*ipLength+4 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[:ipLength]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLength:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[ipLength : 2*ipLength]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLength+2:])),
+	}
+
+	return src, dst, nil
+}
+
+// RealRemoteAddr returns the address of the remote side of the connection.
+// It's the original client's address when the connection was accepted through a proxy
+// which sends the PROXY protocol header, see `ProxyProtocolListener`,
+// so it should be preferred over the address of the request, i.e for logs and limits per IP.
+func (c *Conn) RealRemoteAddr() net.Addr {
+	if netConn := c.socket.NetConn(); netConn != nil {
+		return netConn.RemoteAddr()
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package neffos_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gorilla "github.com/kataras/neffos/gorilla"
+
+	"github.com/gorilla/websocket"
+)
+
+// proxyV2Header returns a v2 header of the "command" and "family" with the "addresses".
+func proxyV2Header(command, family byte, addresses []byte) []byte {
+	header := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	proxyLn := neffos.ProxyProtocolListener(ln, time.Second)
+
+	v4Addresses := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xDC, 0x04, 0x01, 0xBB}
+	v6Addresses := make([]byte, 36)
+	copy(v6Addresses, net.ParseIP("2001:db8::1"))
+	copy(v6Addresses[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(v6Addresses[32:], 56324)
+	binary.BigEndian.PutUint16(v6Addresses[34:], 443)
+
+	tests := []struct {
+		name       string
+		header     []byte
+		remoteAddr string // empty for the proxy's address.
+		localAddr  string
+		invalid    bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), "192.0.2.1:56324", "198.51.100.1:443", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), "[2001:db8::1]:56324", "[2001:db8::2]:443", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", "", false},
+		{"v2 tcp4", proxyV2Header(1, 0x11, v4Addresses), "192.0.2.1:56324", "198.51.100.1:443", false},
+		{"v2 tcp6 with TLVs", proxyV2Header(1, 0x21, append(v6Addresses, 0x04, 0x00, 0x01, 0x00)), "[2001:db8::1]:56324", "[2001:db8::2]:443", false},
+		{"v2 local", proxyV2Header(0, 0x00, nil), "", "", false},
+		{"v1 without CRLF", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"), "", "", true},
+		{"v1 invalid address", []byte("PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n"), "", "", true},
+		{"v1 invalid port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n"), "", "", true},
+		{"v2 invalid version", append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x11, 0x11, 0, 12), "", "", true},
+		{"v2 short addresses", proxyV2Header(1, 0x11, v4Addresses[:8]), "", "", true},
+		{"no header", []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"), "", "", true},
+	}
+
+	for _, tt := range tests {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client.Write(append(tt.header, "data"...))
+
+		conn, err := proxyLn.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := make([]byte, 4)
+		_, err = io.ReadFull(conn, b)
+		if tt.invalid {
+			if err != neffos.ErrInvalidProxyHeader {
+				t.Fatalf("[%s] expected the invalid header error but got: %v", tt.name, err)
+			}
+
+			// the connection is closed.
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err = client.Read(b); err == nil {
+				t.Fatalf("[%s] expected the connection to be closed", tt.name)
+			}
+		} else {
+			if err != nil || string(b) != "data" {
+				t.Fatalf("[%s] expected the data after the header but got: %q: %v", tt.name, b, err)
+			}
+
+			remoteAddr, localAddr := tt.remoteAddr, tt.localAddr
+			if remoteAddr == "" {
+				remoteAddr, localAddr = client.LocalAddr().String(), client.RemoteAddr().String()
+			}
+
+			if got := conn.RemoteAddr().String(); remoteAddr != got {
+				t.Fatalf("[%s] expected remote address: %s but got: %s", tt.name, remoteAddr, got)
+			}
+
+			if got := conn.LocalAddr().String(); localAddr != got {
+				t.Fatalf("[%s] expected local address: %s but got: %s", tt.name, localAddr, got)
+			}
+		}
+
+		conn.Close()
+		client.Close()
+	}
+}
+
+func TestConnRealRemoteAddr(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+		addrs     = make(chan [2]string, 1)
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	server.OnConnect = func(c *neffos.Conn) error {
+		addrs <- [2]string{c.RealRemoteAddr().String(), c.Socket().Request().RemoteAddr}
+		return nil
+	}
+	defer server.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := &http.Server{Handler: server}
+	go httpServer.Serve(neffos.ProxyProtocolListener(ln, 0))
+	defer httpServer.Close()
+
+	// the load balancer.
+	dialer := gorilla.Dialer(&websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			_, err = conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 5555 8080\r\n"))
+			return conn, err
+		},
+	}, nil)
+
+	client, err := neffos.Dial(context.TODO(), dialer, "ws://"+ln.Addr().String(), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	got := <-addrs
+	if expected := "203.0.113.7:5555"; got[0] != expected || got[1] != expected {
+		t.Fatalf("expected the real remote address: %s but got: %v", expected, got)
+	}
+}
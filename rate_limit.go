@@ -0,0 +1,105 @@
+package neffos
+
+import (
+	"time"
+)
+
+// Rate is the rate limit of an event per connection, see `Events#SetLimited`.
+type Rate struct {
+	// Per is the duration which a message is allowed once per, i.e a second for 1 message per second.
+	// A zero or negative value disables the limit.
+	Per time.Duration
+	// Burst is the number of the messages which are allowed at once,
+	// after a quiet period, before the "Per" applies. Defaults to 1.
+	Burst int
+}
+
+func (r Rate) burst() float64 {
+	if r.Burst <= 0 {
+		return 1
+	}
+
+	return float64(r.Burst)
+}
+
+// tokenBucket limits the messages of an event of a namespace connection,
+// it's refilled with a token every `Rate.Per` up to the `Rate.Burst`.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow reports whether a message is allowed at "now" and takes its token.
+func (b *tokenBucket) allow(rate Rate, now time.Time) bool {
+	burst := rate.burst()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(elapsed) / float64(rate.Per)
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+
+	if !now.Before(b.last) {
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// SetLimited registers the "msgHandler" as the callback of the "event", like the `Set` method,
+// and limits the incoming messages of that event per connection to the "rate",
+// i.e neffos.Rate{Per: 10 * time.Second, Burst: 1} for one message every ten seconds.
+// A message over the limit is dropped before its callback and middleware are fired,
+// the `ErrRateLimited` is sent back to the remote side if it was sent by an `Ask`,
+// and the `Server#OnError` is fired with it.
+//
+// Each namespace connection keeps its own limits, they are discarded when it's disconnected.
+func (e Events) SetLimited(event string, msgHandler MessageHandlerFunc, rate Rate) {
+	// the rate is stored first, so the callback is never fired without it.
+	e.updateConfig(func(cfg *eventsConfig) {
+		if rate.Per <= 0 {
+			delete(cfg.rates, event)
+			return
+		}
+
+		if cfg.rates == nil {
+			cfg.rates = make(map[string]Rate)
+		}
+		cfg.rates[event] = rate
+	})
+	e.Set(event, msgHandler)
+}
+
+func (cfg *eventsConfig) rate(event string) (Rate, bool) {
+	if cfg == nil || len(cfg.rates) == 0 {
+		return Rate{}, false
+	}
+
+	rate, ok := cfg.rates[event]
+	return rate, ok
+}
+
+// allow reports whether an incoming message of the "event" is allowed by its "rate" at "now".
+func (ns *NSConn) allow(event string, rate Rate, now time.Time) bool {
+	ns.bucketsMutex.Lock()
+	defer ns.bucketsMutex.Unlock()
+
+	if ns.buckets == nil {
+		ns.buckets = make(map[string]*tokenBucket)
+	}
+
+	bucket, ok := ns.buckets[event]
+	if !ok {
+		bucket = new(tokenBucket)
+		ns.buckets[event] = bucket
+	}
+
+	return bucket.allow(rate, now)
+}
@@ -0,0 +1,82 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitBurstAndRefill(t *testing.T) {
+	var (
+		ns    = newNSConn(nil, "default", Events{})
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		rate  = Rate{Per: time.Second, Burst: 3}
+	)
+
+	tests := []struct {
+		event    string
+		at       time.Duration
+		expected bool
+	}{
+		// the burst.
+		{"typing", 0, true},
+		{"typing", 0, true},
+		{"typing", 0, true},
+		{"typing", 100 * time.Millisecond, false},
+		// each event has its own bucket.
+		{"other", 100 * time.Millisecond, true},
+		// a token is refilled every second.
+		{"typing", time.Second, true},
+		{"typing", time.Second, false},
+		{"typing", 1500 * time.Millisecond, false},
+		{"typing", 2 * time.Second, true},
+		// a quiet period refills up to the burst only.
+		{"typing", time.Minute, true},
+		{"typing", time.Minute, true},
+		{"typing", time.Minute, true},
+		{"typing", time.Minute, false},
+		// a clock which goes backwards does not refill.
+		{"typing", 30 * time.Second, false},
+	}
+
+	for i, tt := range tests {
+		if got := ns.allow(tt.event, rate, start.Add(tt.at)); got != tt.expected {
+			t.Fatalf("[%d] expected %s at %s to be allowed: %v but got: %v", i, tt.event, tt.at, tt.expected, got)
+		}
+	}
+
+	// the default burst.
+	if !ns.allow("createRoom", Rate{Per: 10 * time.Second}, start) || ns.allow("createRoom", Rate{Per: 10 * time.Second}, start.Add(9*time.Second)) {
+		t.Fatalf("expected one message per ten seconds")
+	}
+
+	if !ns.allow("createRoom", Rate{Per: 10 * time.Second}, start.Add(10*time.Second)) {
+		t.Fatalf("expected the token to be refilled after ten seconds")
+	}
+}
+
+func TestEventsSetLimited(t *testing.T) {
+	events := Events{}
+	events.SetLimited("createRoom", func(*NSConn, Message) error { return nil }, Rate{Per: 10 * time.Second, Burst: 1})
+	events.makeLive()
+	// a live modification.
+	events.SetLimited("typing", func(*NSConn, Message) error { return nil }, Rate{Per: 100 * time.Millisecond, Burst: 10})
+
+	cfg := events.config()
+	if rate, ok := cfg.rate("createRoom"); !ok || rate.Per != 10*time.Second || rate.Burst != 1 {
+		t.Fatalf("unexpected rate of the createRoom event: %#+v", rate)
+	}
+
+	if rate, ok := cfg.rate("typing"); !ok || rate.Burst != 10 {
+		t.Fatalf("unexpected rate of the typing event: %#+v", rate)
+	}
+
+	if events.current()["typing"] == nil {
+		t.Fatalf("expected the callback of the typing event")
+	}
+
+	// a zero rate removes the limit.
+	events.SetLimited("typing", func(*NSConn, Message) error { return nil }, Rate{})
+	if _, ok := events.config().rate("typing"); ok {
+		t.Fatalf("expected the rate of the typing event to be removed")
+	}
+}
@@ -173,8 +173,9 @@ func isArgOf(fnType reflect.Type, argType reflect.Type) bool {
 	return false
 }
 
-func makeEventFromMethod(v reflect.Value, method reflect.Method, eventMatcher EventMatcherFunc) (eventName string, cb MessageHandlerFunc) {
-	eventName = method.Name
+// eventOfMethod returns the event name of the "methodName", false if the "eventMatcher" does not match it.
+func eventOfMethod(methodName string, eventMatcher EventMatcherFunc) (string, bool) {
+	eventName := methodName
 
 	// if method looks like a system event, i.e
 	// OnNamespaceConnected, then convert its registered event name
@@ -189,15 +190,19 @@ func makeEventFromMethod(v reflect.Value, method reflect.Method, eventMatcher Ev
 
 	if !IsSystemEvent(eventName) {
 		if eventMatcher != nil {
-			newName, ok := eventMatcher(method.Name)
-			if !ok {
-				return "", nil
-			}
-
-			eventName = newName
+			return eventMatcher(methodName)
 		}
 	}
 
+	return eventName, true
+}
+
+func makeEventFromMethod(v reflect.Value, method reflect.Method, eventMatcher EventMatcherFunc) (eventName string, cb MessageHandlerFunc) {
+	eventName, ok := eventOfMethod(method.Name, eventMatcher)
+	if !ok {
+		return "", nil
+	}
+
 	if isArgOf(method.Type, nsConnType) {
 		// it should accept NSConn - static "controller".
 		cb = v.Method(method.Index).Interface().(func(*NSConn, Message) error)
@@ -308,3 +313,123 @@ func makeEventsFromStruct(v reflect.Value, eventMatcher EventMatcherFunc, inject
 
 	return events
 }
+
+// resolveDependency returns the first of the "dependencies" which can be passed as a "typ" argument.
+func resolveDependency(typ reflect.Type, dependencies []reflect.Value) (reflect.Value, bool) {
+	for _, dep := range dependencies {
+		if dep.Type() == typ {
+			return dep, true
+		}
+	}
+
+	for _, dep := range dependencies {
+		if dep.Type().AssignableTo(typ) {
+			return dep, true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+func makeEventsFromFactory(factory reflect.Value, eventMatcher EventMatcherFunc, dependencies []reflect.Value) Events {
+	events := make(Events)
+
+	factoryType := factory.Type()
+	typ := factoryType.Out(0)
+
+	// resolve the input arguments of the factory, the NSConn one is set on each call.
+	args := make([]reflect.Value, factoryType.NumIn())
+	nsConnArgIndex := -1
+	for i := range args {
+		in := factoryType.In(i)
+		if in == nsConnType {
+			nsConnArgIndex = i
+			continue
+		}
+
+		dep, ok := resolveDependency(in, dependencies)
+		if !ok {
+			panic("NewStructFactory: no value provided for the input argument of type " + in.String())
+		}
+
+		Debugf("Input argument [%d] of the [%s] factory is filled with a value of [%s]", func() dargs {
+			return dargs{i, nameOf(typ), dep.Type().String()}
+		})
+
+		args[i] = dep
+	}
+
+	var (
+		msgHandlerType       = reflect.FuncOf([]reflect.Type{typ, msgType}, []reflect.Type{errType}, false)
+		nsConnMsgHandlerType = reflect.FuncOf([]reflect.Type{typ, nsConnType, msgType}, []reflect.Type{errType}, false)
+		// the indexes of the event methods, their bound methods are stored in the same order on the NSConn.
+		methodIndexes []int
+	)
+
+	for i, n := 0, typ.NumMethod(); i < n; i++ {
+		method := typ.Method(i)
+		if method.Type != msgHandlerType && method.Type != nsConnMsgHandlerType {
+			continue
+		}
+
+		eventName, ok := eventOfMethod(method.Name, eventMatcher)
+		if !ok {
+			continue
+		}
+
+		Debugf("Event [\"%s\"] is handled by [%s.%s] method", func() dargs {
+			return dargs{eventName, nameOf(typ), method.Name}
+		})
+
+		slot := len(methodIndexes)
+		methodIndexes = append(methodIndexes, method.Index)
+
+		events[eventName] = func(c *NSConn, msg Message) error {
+			if slot >= len(c.controller) {
+				// the controller is not created yet.
+				return nil
+			}
+
+			return c.controller[slot](c, msg)
+		}
+	}
+
+	cb, hasNamespaceConnect := events[OnNamespaceConnect]
+
+	events[OnNamespaceConnect] = func(c *NSConn, msg Message) error {
+		in := make([]reflect.Value, len(args))
+		copy(in, args)
+		if nsConnArgIndex != -1 {
+			in[nsConnArgIndex] = reflect.ValueOf(c)
+		}
+
+		out := factory.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			return out[1].Interface().(error)
+		}
+
+		// bind the methods once per connection.
+		controller := make([]MessageHandlerFunc, len(methodIndexes))
+		for i, methodIndex := range methodIndexes {
+			switch fn := out[0].Method(methodIndex).Interface().(type) {
+			case func(Message) error:
+				controller[i] = func(_ *NSConn, msg Message) error {
+					return fn(msg)
+				}
+			case func(*NSConn, Message) error:
+				controller[i] = fn
+			}
+		}
+
+		c.value = out[0]
+		c.controller = controller
+
+		if hasNamespaceConnect {
+			return cb(c, msg)
+		}
+
+		return nil
+	}
+
+	return events
+}
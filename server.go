@@ -49,7 +49,8 @@ type Server struct {
 	IDGenerator   IDGenerator
 	StackExchange StackExchange
 
-	// If `StackExchange` is set then this field is ignored.
+	// If `StackExchange` is set then this field is ignored,
+	// the broadcasts are always written to the local connections by order then.
 	//
 	// It overrides the default behavior(when no StackExchange is not used)
 	// which publishes a message independently.
@@ -66,6 +67,53 @@ type Server struct {
 	//
 	// Defaults to false.
 	FireDisconnectAlways bool
+	// ExpiryTolerance is the clock skew tolerance between the server and its clients
+	// when checking a `Message.Expiry`. A message is considered expired
+	// when the current time is after its expiry plus this tolerance.
+	//
+	// Defaults to 0.
+	ExpiryTolerance time.Duration
+	// StampSentAt, if true, fills the `Message.SentAt` of the messages
+	// written by the server's connections, unless it is already set.
+	//
+	// Defaults to false.
+	StampSentAt bool
+	// Clock is the source of the time of the server's connections, see `Clock`.
+	// Tests can set a fake one, i.e the `neffostest.Clock`, before the server accepts connections.
+	//
+	// Defaults to nil, the `SystemClock` is used instead.
+	Clock Clock
+	// GenerateTraceID, if true, generates the `Message.TraceID` of the messages
+	// written or broadcasted by the server, unless it is already set or inherited.
+	//
+	// Defaults to false.
+	GenerateTraceID bool
+	// Codec is the `MessageCodec` used by `Conn#Marshal` and `Message#Unmarshal`
+	// of the server's connections. Clients should dial with the same codec, see `WithCodec`,
+	// otherwise the handshake fails with the `ErrCodecMismatch`.
+	//
+	// Defaults to nil, the `DefaultMarshaler` and `DefaultUnmarshaler` are used instead.
+	Codec MessageCodec
+	// AllowJSONProtocol, if true, allows clients to select the `JSONProtocol`
+	// on upgrade, for partners whose websocket clients cannot use the default protocol.
+	// The server serves both protocols simultaneously, the event callbacks are not affected.
+	//
+	// Defaults to false.
+	AllowJSONProtocol bool
+	// StrictParsing, if true, validates the incoming messages strictly, i.e the isError
+	// and isNoOp fields should be "0" or "1" and the message extensions should be well formed.
+	// A malformed message fires the `OnError` with a `*ParseError`, which describes its malformed field
+	// and keeps its received payload (truncated), and it is sent back to the client if the message carried a wait token.
+	// It is useful to debug clients which implement the protocol.
+	//
+	// Defaults to false, malformed messages are silently dropped.
+	StrictParsing bool
+	// NativeUnknownNamespaces, if true, fires the incoming messages of a namespace which is not registered
+	// as native messages (with their whole payload) on the mixed mode, see `OnNativeMessage`.
+	// It's the escape hatch of the native payloads which happen to parse as neffos messages.
+	//
+	// Defaults to false, they are neffos messages of a bad namespace.
+	NativeUnknownNamespaces bool
 
 	mu         sync.RWMutex
 	namespaces Namespaces
@@ -77,22 +125,115 @@ type Server struct {
 	count uint64
 
 	connections       map[*Conn]struct{}
+	// secondary index of the connections by their IDs, see `SendTo`.
+	// The IDs are not guaranteed to be unique, a custom `IDGenerator` may return the same ID
+	// for many connections (i.e of the same user).
+	connectionsByID      map[string]map[*Conn]struct{}
+	connectionsByIDMutex sync.RWMutex
+	// see `SetStackExchangeBatch`.
+	stackExchangeBatch *stackExchangeBatch
+	// see `SetStackExchangeQueue`.
+	stackExchangeQueue *stackExchangeQueue
+	// see `SetExchangeFilter`.
+	exchangeFilter func(msg Message) bool
+	// see `SetStackExchangeCompression`.
+	exchangeCompression *exchangeCompression
+	// true when an `InstrumentStackExchange` is registered.
+	instrumentStackExchange bool
+	// the instrumented stackexchanges which report their pool, see `Metrics`.
+	poolReporters []StackExchangePoolReporter
+	// the first registered stackexchange which supports the cluster presence, see `ClusterLookup`.
+	presence PresenceStackExchange
+	// non-nil if the presence's stackexchange can deliver to a single instance, see `SendToCluster`.
+	unicast UnicastStackExchange
+
+	// the deduplication of the deliveries of a `CompositeStackExchange`, if any.
+	exchangeDedup *exchangeDedup
+
+	// set by `Shutdown`, the stackexchange is not used after it.
+	stackExchangeClosed uint32
+
+	// the number of the failed stackexchanges, see `StackExchangeHealthy`.
+	unhealthyStackExchanges int32
+
+	// the number of the joined connections of each namespace's room,
+	// see `roomJoined` and `roomLeft`.
+	roomCounts      map[string]map[string]int
+	roomCountsMutex sync.Mutex
 	connect           chan *Conn
 	disconnect        chan *Conn
 	actions           chan action
 	broadcastMessages chan []Message
+	// closed by the `Close` and the `Shutdown`, the loop of the server stops
+	// when all of its connections are disconnected, then it closes the "stopped".
+	closeCh chan struct{}
+	stopped chan struct{}
 
 	broadcaster *broadcaster
 
 	// messages that this server must waits
 	// for a reply from one of its own connections(see `waitMessages`).
-	waitingMessages      map[string]chan Message
-	waitingMessagesMutex sync.RWMutex
+	waitingMessages *waitingMessages
+	// see `SetWaitingMessagesTTL`.
+	waitingMessagesTTL        int64
+	waitingMessagesTTLChanged chan struct{}
+	sweepWaitingMessagesOnce  sync.Once
 
 	closed uint32
 
+	// see `SetMaxMessageSize`.
+	maxMessageSize int64
+	// see `SetWriteBuffer`.
+	writeBufferSize       int
+	writeBufferMaxLatency time.Duration
+	// see `SetCompression`.
+	compressionThreshold int
+	// see `SetChunking`.
+	chunkSize       int
+	maxTransferSize int64
+	transferTimeout time.Duration
+	// see `SetMessageValidator`.
+	messageValidators []MessageValidator
+	// see `SetErrorTranslator`.
+	errorTranslator ErrorTranslator
+	// see `Use`.
+	middleware []Middleware
+	// see `SetMessageLimits`.
+	messageLimits MessageLimits
+	// see `SetLogger`.
+	logger Logger
+	// see `SetFrameDump`.
+	frameDump *frameDumper
+
+	// shared with all of its connections, see `Metrics`.
+	counters *counters
+
 	// OnUpgradeError can be optionally registered to catch upgrade errors.
 	OnUpgradeError func(err error)
+	// OnError can be optionally registered to catch errors of a connection
+	// which are not sent to the remote side, e.g. `ErrMessageTooLarge`,
+	// and the errors of the event callbacks, wrapped by an `*EventError` with their namespace, event and connection.
+	OnError func(c *Conn, err error)
+	// OnStackExchangeError can be optionally registered to catch the asynchronous errors of a `StackExchange`,
+	// i.e a lost connection to its broker, and its recoveries.
+	// The "recovered" is true when the stackexchange is functional again after the "err" failure,
+	// see `StackExchangeErrorReporter` and `StackExchangeHealthy`.
+	OnStackExchangeError func(err error, recovered bool)
+	// OnBeforeEvent can be optionally registered to be called before the callback of each event
+	// of all the namespaces, including the lifecycle events (i.e `OnNamespaceConnected`) and their middleware.
+	// When it returns an error the callback is not fired and the error is handled like the error of the callback.
+	// It's the integration point of the metrics and the tracing of the events, see `OnAfterEvent` too.
+	OnBeforeEvent func(ns *NSConn, msg Message) error
+	// OnAfterEvent can be optionally registered to be called after the callback of each event,
+	// see `OnBeforeEvent`, with its duration and its error. The error is nil when the callback replied to the message.
+	// It's not called when the `OnBeforeEvent` returns an error.
+	OnAfterEvent func(ns *NSConn, msg Message, dur time.Duration, err error)
+	// OnMessageProcessed can be optionally registered to be called after each incoming message is handled,
+	// with the duration of its deserialization and its dispatch and the error of its handling, if any.
+	// Unlike the `OnAfterEvent` it's called for all messages: the native ones, the lifecycle events,
+	// the replies and the dropped ones, i.e the invalid or the expired messages.
+	// It's the integration point of a histogram of the processing time of the events.
+	OnMessageProcessed func(c *Conn, msg Message, dur time.Duration, err error)
 	// OnConnect can be optionally registered to be notified for any new neffos client connection,
 	// it can be used to force-connect a client to a specific namespace(s) or to send data immediately or
 	// even to cancel a client connection and dissalow its connection when its return error value is not nil.
@@ -101,6 +242,20 @@ type Server struct {
 	// OnDisconnect can be optionally registered to notify about a connection's disconnect.
 	// Don't confuse it with the `OnNamespaceDisconnect`, this callback is for the entire client side connection.
 	OnDisconnect func(c *Conn)
+	// SlowWriteThreshold enables the detection of the slow consumers: a write of a connection
+	// which takes at least that long, or which hits the write timeout, is a slow one.
+	// See `OnSlowConsumer`, `Conn#SlowStats` and `Metrics.SlowWrites`.
+	// Defaults to zero, disabled.
+	SlowWriteThreshold time.Duration
+	// OnSlowConsumer can be optionally registered to be notified for each slow write of a connection,
+	// see `SlowWriteThreshold`. The returned action decides whether the connection is kept,
+	// excluded from the broadcasts until it recovers or closed.
+	// It's called by the goroutine of the write, it should return fast.
+	OnSlowConsumer func(c *Conn, stats SlowStats) SlowConsumerAction
+	// PresenceAliases can be optionally registered to announce more names of a connection,
+	// i.e a user name, to the cluster presence, besides its ID. It's called once, after the stackexchange's `OnConnect`.
+	// See `ClusterLookup`.
+	PresenceAliases func(c *Conn) []string
 }
 
 // New constructs and returns a new neffos server.
@@ -113,6 +268,7 @@ type Server struct {
 func New(upgrader Upgrader, connHandler ConnHandler) *Server {
 	readTimeout, writeTimeout := getTimeouts(connHandler)
 	namespaces := connHandler.GetNamespaces()
+	namespaces.makeLive()
 	s := &Server{
 		uuid:              uuid.Must(uuid.NewV4()).String(),
 		upgrader:          upgrader,
@@ -120,13 +276,21 @@ func New(upgrader Upgrader, connHandler ConnHandler) *Server {
 		readTimeout:       readTimeout,
 		writeTimeout:      writeTimeout,
 		connections:       make(map[*Conn]struct{}),
+		connectionsByID:   make(map[string]map[*Conn]struct{}),
+		roomCounts:        make(map[string]map[string]int),
 		connect:           make(chan *Conn, 1),
 		disconnect:        make(chan *Conn),
 		actions:           make(chan action),
 		broadcastMessages: make(chan []Message),
+		closeCh:           make(chan struct{}),
+		stopped:           make(chan struct{}),
 		broadcaster:       newBroadcaster(),
-		waitingMessages:   make(map[string]chan Message),
+		waitingMessages:   newWaitingMessages(),
+		counters:          newCounters(),
 		IDGenerator:       DefaultIDGenerator,
+
+		waitingMessagesTTL:        int64(DefaultWaitingMessagesTTL),
+		waitingMessagesTTLChanged: make(chan struct{}, 1),
 	}
 
 	go s.start()
@@ -145,10 +309,36 @@ func (s *Server) UseStackExchange(exc StackExchange) error {
 		return nil
 	}
 
+	if in, ok := exc.(*instrumentedStackExchange); ok {
+		in.counters = s.counters
+		s.instrumentStackExchange = true
+		if r, ok := in.StackExchange.(StackExchangePoolReporter); ok {
+			s.poolReporters = append(s.poolReporters, r)
+		}
+	}
+
+	if r, ok := exc.(StackExchangeErrorReporter); ok {
+		r.SetErrorHandler(s.stackExchangeErrorHandler(exc))
+	}
+
+	if composite, ok := unwrapStackExchange(exc).(*CompositeStackExchange); ok && s.exchangeDedup == nil {
+		s.exchangeDedup = composite.dedup
+	}
+
+	if s.namespaces.dynamic() != nil && requiresStaticNamespaces(exc) {
+		return ErrDynamicNamespacesUnsupported
+	}
+
 	if err := stackExchangeInit(exc, s.namespaces); err != nil {
 		return err
 	}
 
+	if s.presence == nil {
+		if err := s.joinPresence(exc); err != nil {
+			return err
+		}
+	}
+
 	if s.usesStackExchange() {
 		s.StackExchange = wrapStackExchanges(s.StackExchange, exc)
 	} else {
@@ -158,37 +348,458 @@ func (s *Server) UseStackExchange(exc StackExchange) error {
 	return nil
 }
 
+// stackExchangeErrorHandler returns the error handler of a registered "exc",
+// it keeps the server's health and re-issues the subscriptions on the "exc" when it recovers.
+func (s *Server) stackExchangeErrorHandler(exc StackExchange) func(err error, recovered bool) {
+	failed := new(uint32)
+
+	return func(err error, recovered bool) {
+		if recovered {
+			s.logInfo("stackexchange recovered", "err", err)
+			if atomic.CompareAndSwapUint32(failed, 1, 0) {
+				atomic.AddInt32(&s.unhealthyStackExchanges, -1)
+				s.resubscribe(exc)
+			}
+		} else {
+			s.logError("stackexchange failed", "err", err)
+			if atomic.CompareAndSwapUint32(failed, 0, 1) {
+				atomic.AddInt32(&s.unhealthyStackExchanges, 1)
+			}
+		}
+
+		if s.OnStackExchangeError != nil {
+			s.OnStackExchangeError(err, recovered)
+		}
+	}
+}
+
+// resubscribe re-issues the namespace and room subscriptions of the server's current state on the "exc".
+func (s *Server) resubscribe(exc StackExchange) {
+	if roomExc, ok := exc.(RoomStackExchange); ok {
+		s.roomCountsMutex.Lock()
+		for namespace, rooms := range s.roomCounts {
+			for room := range rooms {
+				roomExc.SubscribeRoom(namespace, room)
+			}
+		}
+		s.roomCountsMutex.Unlock()
+	}
+
+	s.Do(func(c *Conn) {
+		c.connectedNamespacesMutex.RLock()
+		namespaces := make([]string, 0, len(c.connectedNamespaces))
+		for namespace := range c.connectedNamespaces {
+			namespaces = append(namespaces, namespace)
+		}
+		c.connectedNamespacesMutex.RUnlock()
+
+		for _, namespace := range namespaces {
+			exc.Subscribe(c, namespace)
+		}
+	}, true)
+}
+
+// StackExchangeHealthy reports whether all the registered stackexchanges are functional,
+// a stackexchange is not functional after a failure which is not recovered yet,
+// see `OnStackExchangeError`. It can be used on readiness probes.
+//
+// It reports true when no `StackExchange` is registered.
+func (s *Server) StackExchangeHealthy() bool {
+	return atomic.LoadInt32(&s.unhealthyStackExchanges) == 0
+}
+
+// SetExchangeFilter registers a "filter" which decides which messages are published through the `StackExchange`,
+// i.e on `Broadcast` and `SendTo`. A message that the "filter" returns false for is kept instance-local,
+// it's still written to the connections of this server but not to the connections of the other server instances.
+// The "filter" runs on the broadcast hot path, for each message, it should be fast and allocation-free.
+// It should be set once, before serve.
+//
+// Defaults to nil, all messages are published.
+func (s *Server) SetExchangeFilter(filter func(msg Message) bool) {
+	s.exchangeFilter = filter
+}
+
+// SetStackExchangeCompression compresses the envelopes of the messages which are published through the `StackExchange`
+// when their size is at least "threshold" bytes, i.e to reduce the billed bandwidth to the broker.
+// The body of the envelope is compressed after its version header, see `ExchangeEnvelopeVersion`,
+// and an envelope which is not smaller compressed is published as it is.
+// The server instances read the compressed envelopes regardless of their own setting,
+// enable it after all of them are upgraded to a version which reads them.
+// The envelopes are not compressed while the `ExchangeEnvelopeWriteVersion` is 0.
+// The sizes before and after the compression are reported through the `Metrics.StackExchange`.
+// It should be set once, before serve.
+//
+// Defaults to a zero "threshold", the envelopes are not compressed.
+func (s *Server) SetStackExchangeCompression(threshold int) {
+	if threshold <= 0 {
+		s.exchangeCompression = nil
+		return
+	}
+
+	s.exchangeCompression = &exchangeCompression{threshold: threshold, counters: s.counters}
+}
+
+// SetStackExchangeBatch buffers the messages which are published through the `StackExchange`,
+// i.e on `Broadcast`, and publishes them together, through a single `StackExchange.Publish` call,
+// when "size" messages are buffered or "maxLatency" has passed since the first one, whichever comes first.
+// The messages are published in the order they were broadcasted and
+// the buffered ones are published on `Close`.
+// The stackexchange implementations write a batch with fewer round trips, i.e the redis one pipelines its commands.
+// It should be set once, before serve.
+//
+// Defaults to a zero "maxLatency", each broadcast is published immediately.
+func (s *Server) SetStackExchangeBatch(size int, maxLatency time.Duration) {
+	if size <= 1 || maxLatency <= 0 || s.stackExchangeBatch != nil {
+		return
+	}
+
+	s.stackExchangeBatch = newStackExchangeBatch(size, maxLatency)
+	go s.stackExchangeBatch.run(func(msgs []Message) bool {
+		return s.StackExchange.Publish(msgs)
+	})
+}
+
+// SetStackExchangeQueue puts a queue of "size" messages between the broadcasts and the `StackExchange`,
+// the messages which are published through it, i.e on `Broadcast`, are queued and the caller returns immediately,
+// a single goroutine publishes them in the order they were broadcasted.
+// So a slow or lost broker, i.e a redis server which is down, does not delay the broadcasts.
+// When the queue is full the oldest messages are dropped, the `OnStackExchangeError` is fired
+// with the `ErrStackExchangeQueueFull` on the first drop since the queue was drained
+// and the drops are counted on the `Metrics.StackExchange`, along with the depth of the queue.
+// The queued messages are published on `Shutdown` and `Close`.
+// It can be combined with the `SetStackExchangeBatch`, the queued messages are buffered after the queue.
+// It should be set once, before serve.
+//
+// Defaults to a zero "size", each broadcast publishes through the `StackExchange` itself.
+func (s *Server) SetStackExchangeQueue(size int) {
+	if size <= 0 || s.stackExchangeQueue != nil {
+		return
+	}
+
+	s.stackExchangeQueue = newStackExchangeQueue(size, s.counters, func() {
+		s.logError("stackexchange failed", "err", ErrStackExchangeQueueFull)
+		if s.OnStackExchangeError != nil {
+			s.OnStackExchangeError(ErrStackExchangeQueueFull, false)
+		}
+	})
+	go s.stackExchangeQueue.run(s.publishBatch)
+}
+
+// flushStackExchange publishes the queued and the buffered messages,
+// see `SetStackExchangeQueue` and `SetStackExchangeBatch`.
+func (s *Server) flushStackExchange() {
+	if s.stackExchangeQueue != nil {
+		s.stackExchangeQueue.flush()
+	}
+
+	if s.stackExchangeBatch != nil {
+		s.stackExchangeBatch.flush()
+	}
+}
+
+// publishToStackExchange publishes, queues or buffers the "msgs", see `SetStackExchangeQueue` and `SetStackExchangeBatch`.
+// The "msgs" are stamped with the `InstanceID` so their echo to this server is dropped.
+func (s *Server) publishToStackExchange(msgs []Message) bool {
+	if atomic.LoadUint32(&s.stackExchangeClosed) == 1 {
+		return false
+	}
+
+	if s.exchangeFilter != nil {
+		if msgs = filterMessages(msgs, s.exchangeFilter); len(msgs) == 0 {
+			return false
+		}
+	}
+
+	for i := range msgs {
+		msgs[i].origin = s.uuid
+		msgs[i].exchangeCompression = s.exchangeCompression
+	}
+
+	if s.instrumentStackExchange && s.StampSentAt {
+		// the receivers observe the exchange latency.
+		now := nowMillis()
+		for i := range msgs {
+			if msgs[i].SentAt == 0 {
+				msgs[i].SentAt = now
+			}
+		}
+	}
+
+	if s.stackExchangeQueue != nil {
+		s.stackExchangeQueue.add(msgs)
+		return true
+	}
+
+	return s.publishBatch(msgs)
+}
+
+// publishBatch publishes or buffers the "msgs", see `SetStackExchangeBatch`.
+func (s *Server) publishBatch(msgs []Message) bool {
+	if s.stackExchangeBatch != nil {
+		s.stackExchangeBatch.add(msgs)
+		return true
+	}
+
+	return s.StackExchange.Publish(msgs)
+}
+
+// SetMaxMessageSize sets the maximum size in bytes of an incoming message.
+// Connections that send a larger message are closed with the 1009 (message too big) close code
+// and the `OnError` is fired with the `ErrMessageTooLarge`.
+// The limit applies after the acknowledgement process, it should be set before serve.
+//
+// Defaults to 0, no limit.
+func (s *Server) SetMaxMessageSize(bytes int64) {
+	s.maxMessageSize = bytes
+}
+
+// SetWriteBuffer enables the buffering of the written messages, up to "size" bytes, for the sockets
+// which support it (see `SocketWriteBuffer`), i.e the gobwas and tcp ones, so many small messages cost fewer syscalls.
+// The buffered messages are written when the buffer is full, when the messages of a broadcast are written
+// or at the latest after the "maxLatency". The acknowledgement and the close messages are never delayed.
+// A zero "maxLatency" defaults to the `DefaultWriteBufferMaxLatency`. It should be set before serve.
+//
+// Defaults to 0, no buffering.
+func (s *Server) SetWriteBuffer(size int, maxLatency time.Duration) {
+	s.writeBufferSize = size
+	s.writeBufferMaxLatency = maxLatency
+}
+
+// SetCompression enables the application-level compression of the message bodies
+// which are larger than "threshold" bytes, for clients which do not support
+// the websocket permessage-deflate extension.
+// It's negotiated on the handshake, clients should dial with the `WithCompression` option,
+// the rest of them never receive compressed bodies. Incoming bodies are decompressed
+// before the event callbacks.
+// It should be set before serve.
+//
+// Defaults to 0, disabled.
+func (s *Server) SetCompression(threshold int) {
+	s.compressionThreshold = threshold
+}
+
+// SetChunking sets the size in bytes of the chunks of the `NSConn#EmitLarge`
+// and the maximum total size and duration of an incoming chunked transfer,
+// a transfer which exceeds them is dropped and its event callback
+// is fired with the `ErrMessageTooLarge` or the `ErrIncompleteTransfer` respectively.
+// The "chunkSize" should be smaller than the clients' maximum message size.
+// It should be set before serve.
+//
+// Zero values fallback to the `DefaultChunkSize`, `DefaultMaxTransferSize` and `DefaultTransferTimeout`.
+func (s *Server) SetChunking(chunkSize int, maxTransferSize int64, transferTimeout time.Duration) {
+	s.chunkSize = chunkSize
+	s.maxTransferSize = maxTransferSize
+	s.transferTimeout = transferTimeout
+}
+
+// SetMessageLimits sets the limits of the fields of the incoming messages,
+// i.e the maximum length of their event names, see `MessageLimits`.
+// It should be set before serve.
+//
+// Defaults to the `DefaultMessageLimits`.
+func (s *Server) SetMessageLimits(limits MessageLimits) {
+	s.messageLimits = limits
+}
+
+// Use registers one or more middleware to the events of all the namespaces of the server,
+// they are called before the ones of the `Events#Use`. It should be called before serve.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// Describe returns the names of the events of each namespace of the server,
+// including the dynamic namespaces which are currently cached, see `Namespaces#Describe`.
+func (s *Server) Describe() map[string][]string {
+	return s.namespaces.Describe()
+}
+
+// SetEvent registers the "msgHandler" as the callback of the "event" of the "namespace"
+// while the server is running, the existing connections fire it on their next message.
+// A nil "msgHandler" removes the callback of the "event". See `Events#Set` and `Events#Remove`.
+//
+// It returns the `ErrBadNamespace` if the "namespace" is not registered to the server.
+func (s *Server) SetEvent(namespace, event string, msgHandler MessageHandlerFunc) error {
+	events, ok := s.namespaces[namespace]
+	if !ok || events == nil {
+		return ErrBadNamespace
+	}
+
+	if msgHandler == nil {
+		events.Remove(event)
+		return nil
+	}
+
+	events.Set(event, msgHandler)
+	return nil
+}
+
+// MessageValidator is the type of function that validates an incoming message
+// before it is dispatched, see `Server#SetMessageValidator`.
+type MessageValidator func(c *Conn, msg *Message) error
+
+// SetMessageValidator sets one or more validators which are called, in order,
+// for each incoming message of the server's connections before its dispatch,
+// including the namespace connect/disconnect and the room join/leave messages.
+// The first non-nil error skips the dispatch, it is sent back
+// to the remote side if the message was an `Ask` and it fires the `OnError`.
+// It should be called before serve.
+func (s *Server) SetMessageValidator(validators ...MessageValidator) {
+	s.messageValidators = validators
+}
+
+// ErrorTranslator is the type of function that translates the error of an event callback
+// before it is sent to the remote side, see `Server#SetErrorTranslator`.
+type ErrorTranslator func(c *NSConn, msg Message, err error) error
+
+// SetErrorTranslator sets a function which translates the errors of the event callbacks
+// before they are sent to the client, i.e to hide the details of a database error behind a generic one.
+// It's applied to the error replies of the application events, `Ask` and `NSConn#DeferReply` ones included,
+// and to the refusals of the namespace connect/disconnect and the room join/leave.
+// The `OnError` and the rest of the local hooks still receive the original error.
+//
+// A nil result sends nothing back for an event, an `Ask` receives an empty successful reply instead,
+// however a refusal can not be turned into an acceptance, the `ErrRejected` is sent for it.
+// The typed errors of `NewError` are sent as they are, they are meant for the remote side already.
+// It should be called before serve.
+func (s *Server) SetErrorTranslator(translator ErrorTranslator) {
+	s.errorTranslator = translator
+}
+
+func (s *Server) validateMessage(c *Conn, msg *Message) error {
+	for _, validator := range s.messageValidators {
+		if err := validator(c, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// roomJoined increments the members of a namespace's room,
+// the `RoomStackExchange` is notified when its first member joins.
+func (s *Server) roomJoined(namespace, room string) {
+	roomExc, ok := s.StackExchange.(RoomStackExchange)
+	if !ok {
+		return
+	}
+
+	s.roomCountsMutex.Lock()
+	defer s.roomCountsMutex.Unlock()
+
+	rooms, ok := s.roomCounts[namespace]
+	if !ok {
+		rooms = make(map[string]int)
+		s.roomCounts[namespace] = rooms
+	}
+
+	rooms[room]++
+	if rooms[room] == 1 {
+		roomExc.SubscribeRoom(namespace, room)
+	}
+}
+
+// roomLeft decrements the members of a namespace's room,
+// the `RoomStackExchange` is notified when its last member leaves.
+func (s *Server) roomLeft(namespace, room string) {
+	roomExc, ok := s.StackExchange.(RoomStackExchange)
+	if !ok {
+		return
+	}
+
+	s.roomCountsMutex.Lock()
+	defer s.roomCountsMutex.Unlock()
+
+	rooms, ok := s.roomCounts[namespace]
+	if !ok || rooms[room] == 0 {
+		return
+	}
+
+	rooms[room]--
+	if rooms[room] == 0 {
+		delete(rooms, room)
+		if len(rooms) == 0 {
+			delete(s.roomCounts, namespace)
+		}
+
+		roomExc.UnsubscribeRoom(namespace, room)
+	}
+}
+
 // usesStackExchange reports whether this server
 // uses one or more `StackExchange`s.
 func (s *Server) usesStackExchange() bool {
 	return s.StackExchange != nil
 }
 
+// writesLocal reports whether this server writes its broadcasts to its local connections directly,
+// instead of through its stackexchange's delivery, see `OriginStackExchange`.
+func (s *Server) writesLocal() bool {
+	return preservesOrigin(s.StackExchange)
+}
+
+// stackExchangeOpen reports whether this server
+// uses one or more `StackExchange`s and they are not closed by `Shutdown`.
+func (s *Server) stackExchangeOpen() bool {
+	return s.usesStackExchange() && atomic.LoadUint32(&s.stackExchangeClosed) == 0
+}
+
 func (s *Server) start() {
-	atomic.StoreUint32(&s.closed, 0)
+	defer close(s.stopped)
+
+	var (
+		closing = s.closeCh
+		// the connections which were disconnected before their connect was received.
+		orphans = make(map[*Conn]struct{})
+	)
 
 	for {
+		if closing == nil && len(s.connections) == 0 {
+			// closed and all connections are disconnected.
+			return
+		}
+
 		select {
+		case <-closing:
+			closing = nil
 		case c := <-s.connect:
+			if _, ok := orphans[c]; ok {
+				delete(orphans, c)
+				continue
+			}
+
 			s.connections[c] = struct{}{}
+			s.indexConn(c)
 			atomic.AddUint64(&s.count, 1)
+
+			if closing == nil {
+				// it passed the closed check of the `ServeSocket` before the `Close`.
+				c.Close()
+			}
 		case c := <-s.disconnect:
-			if _, ok := s.connections[c]; ok {
-				// close(c.out)
-				delete(s.connections, c)
-				atomic.AddUint64(&s.count, ^uint64(0))
-				// println("disconnect...")
-				if s.OnDisconnect != nil {
-					// don't fire disconnect if was immediately closed on the `OnConnect` server event.
-					if !s.FireDisconnectAlways && (!c.readiness.isReady() || (c.readiness.err != nil)) {
-						continue
-					}
-					s.OnDisconnect(c)
-				}
+			if _, ok := s.connections[c]; !ok {
+				orphans[c] = struct{}{}
+				continue
+			}
 
-				if s.usesStackExchange() {
-					s.StackExchange.OnDisconnect(c)
+			// close(c.out)
+			delete(s.connections, c)
+			s.unindexConn(c)
+			c.releaseSlowConsumer()
+			atomic.AddUint64(&s.count, ^uint64(0))
+			if s.presence != nil {
+				s.withdrawPresence(c)
+			}
+			// println("disconnect...")
+			if s.OnDisconnect != nil {
+				// don't fire disconnect if was immediately closed on the `OnConnect` server event.
+				if !s.FireDisconnectAlways && (!c.readiness.isReady() || (c.readiness.err != nil)) {
+					continue
 				}
+				s.OnDisconnect(c)
+			}
+
+			if s.stackExchangeOpen() {
+				s.StackExchange.OnDisconnect(c)
 			}
 		case msgs := <-s.broadcastMessages:
 			for c := range s.connections {
@@ -206,12 +817,122 @@ func (s *Server) start() {
 	}
 }
 
+// notifyDisconnect sends the closed "c" to the loop of the server, which fires the `OnDisconnect`.
+// The send is abandoned, and counted as a skipped disconnect, if the loop has stopped.
+func (s *Server) notifyDisconnect(c *Conn) {
+	select {
+	case s.disconnect <- c:
+	case <-s.stopped:
+		s.counters.incr(&s.counters.skippedDisconnects)
+	}
+}
+
+// Shutdown gracefully terminates the server and all of its connections.
+// Unlike the `Close`, the stackexchange is drained before the connections are closed:
+// the connections are unsubscribed from their namespaces and rooms, the buffered publishes
+// are flushed (see `SetStackExchangeBatch`) and a `ClosableStackExchange` is closed,
+// then the connections are closed and it waits for their disconnect.
+// The stackexchange does not deliver any message to the connections once it's called.
+//
+// It returns the "ctx" error if it's done before the shutdown completes
+// or the error of the `ClosableStackExchange`.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
+		return nil
+	}
+
+	var err error
+	if s.usesStackExchange() && atomic.CompareAndSwapUint32(&s.stackExchangeClosed, 0, 1) {
+		err = s.drainStackExchange(ctx)
+	}
+
+	s.Do(func(c *Conn) {
+		c.Close()
+	}, false)
+	close(s.closeCh)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stopped:
+	}
+
+	return err
+}
+
+// drainStackExchange unsubscribes the connections from the stackexchange and closes it, see `Shutdown`.
+func (s *Server) drainStackExchange(ctx context.Context) error {
+	s.connectionsByIDMutex.RLock()
+	conns := make([]*Conn, 0, len(s.connectionsByID))
+	for _, byID := range s.connectionsByID {
+		for c := range byID {
+			conns = append(conns, c)
+		}
+	}
+	s.connectionsByIDMutex.RUnlock()
+
+	for _, c := range conns {
+		c.connectedNamespacesMutex.RLock()
+		namespaces := make([]string, 0, len(c.connectedNamespaces))
+		for namespace := range c.connectedNamespaces {
+			namespaces = append(namespaces, namespace)
+		}
+		c.connectedNamespacesMutex.RUnlock()
+
+		for _, namespace := range namespaces {
+			s.StackExchange.Unsubscribe(c, namespace)
+		}
+		s.StackExchange.OnDisconnect(c)
+	}
+
+	if roomExc, ok := s.StackExchange.(RoomStackExchange); ok {
+		s.roomCountsMutex.Lock()
+		for namespace, rooms := range s.roomCounts {
+			for room := range rooms {
+				roomExc.UnsubscribeRoom(namespace, room)
+			}
+		}
+		s.roomCounts = make(map[string]map[string]int)
+		s.roomCountsMutex.Unlock()
+	}
+
+	s.flushStackExchange()
+
+	if s.presence != nil {
+		s.presence.PresenceLeave(s.uuid)
+	}
+
+	closer, ok := s.StackExchange.(ClosableStackExchange)
+	if !ok {
+		return nil
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- closer.Close()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
 // Close terminates the server and all of its connections, client connections are getting notified.
 func (s *Server) Close() {
 	if atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
+		s.flushStackExchange()
+
+		if s.presence != nil {
+			s.presence.PresenceLeave(s.uuid)
+		}
+
 		s.Do(func(c *Conn) {
 			c.Close()
 		}, false)
+		close(s.closeCh)
 	}
 }
 
@@ -265,12 +986,19 @@ func IsTryingToReconnect(err error) (ok bool) {
 // This header key should match with that browser-client's `whenResourceOnline->re-dial` uses.
 const websocketReconectHeaderKey = "X-Websocket-Reconnect"
 
+// InstanceID returns the unique identifier of this server instance, generated on `New`.
+// It's the origin of the messages this server publishes to its `StackExchange`,
+// useful for logging.
+func (s *Server) InstanceID() string {
+	return s.uuid
+}
+
 func isServerConnID(s string) bool {
 	return strings.HasPrefix(s, "neffos(0x")
 }
 
 func genServerConnID(s *Server, c *Conn) string {
-	return fmt.Sprintf("neffos(0x%s(%s%p))", s.uuid, c.id, c)
+	return fmt.Sprintf("neffos(0x%s(%s%p))", s.uuid, c.ID(), c)
 }
 
 // Upgrade handles the connection, same as `ServeHTTP` but it can accept
@@ -308,6 +1036,7 @@ func (s *Server) Upgrade(
 
 	socket, err := s.upgrader(w, r)
 	if err != nil {
+		s.logError("upgrade failed", "remote", r.RemoteAddr, "err", err)
 		if s.OnUpgradeError != nil {
 			s.OnUpgradeError(err)
 		}
@@ -318,16 +1047,69 @@ func (s *Server) Upgrade(
 		socket = socketWrapper(socket)
 	}
 
+	return s.serveSocket(w, r, socket, customIDGen)
+}
+
+// ServeSocket serves a "socket" which is already upgraded from the "r" request, same as `Upgrade` does after the upgrade,
+// for the protocol implementations which can not upgrade through an `http.ResponseWriter`, i.e the fasthttp one.
+// The server's Upgrader is not used. The "w" is passed to the IDGenerator only, as the response is already sent.
+// The "socket" is closed by the server, even on errors, i.e when the server is closed or the `OnConnect` fails.
+func (s *Server) ServeSocket(w http.ResponseWriter, r *http.Request, socket Socket, customIDGen IDGenerator) (*Conn, error) {
+	if atomic.LoadUint32(&s.closed) > 0 {
+		socket.NetConn().Close()
+		return nil, errServerClosed
+	}
+
+	tryParseURLParamsToHeaders(r)
+	return s.serveSocket(w, r, socket, customIDGen)
+}
+
+func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request, socket Socket, customIDGen IDGenerator) (*Conn, error) {
 	c := newConn(socket, s.namespaces)
+	c.tlsState = socketTLSState(r, socket.NetConn())
+
+	idReq := r
+	if r != nil && r.TLS == nil && c.tlsState != nil {
+		// i.e the unwrapped TLS connections, the IDGenerator receives a copy
+		// of the request with their state, the caller's request is not modified.
+		idReq = new(http.Request)
+		*idReq = *r
+		idReq.TLS = c.tlsState
+	}
+
 	if customIDGen != nil {
-		c.id = customIDGen(w, r)
+		c.setID(customIDGen(w, idReq))
 	} else {
-		c.id = s.IDGenerator(w, r)
+		c.setID(s.IDGenerator(w, idReq))
 	}
 	c.serverConnID = genServerConnID(s, c)
 
 	c.readTimeout = s.readTimeout
 	c.writeTimeout = s.writeTimeout
+	c.maxMessageSize = s.maxMessageSize
+	c.writeBufferSize = s.writeBufferSize
+	c.writeBufferMaxLatency = s.writeBufferMaxLatency
+	c.expiryTolerance = s.ExpiryTolerance
+	c.stampSentAt = s.StampSentAt
+	c.clock = clockOrSystem(s.Clock)
+	// the time of its creation follows the server's clock.
+	c.touch()
+	c.onBeforeEvent = s.OnBeforeEvent
+	c.onAfterEvent = s.OnAfterEvent
+	c.onMessageProcessed = s.OnMessageProcessed
+	c.generateTraceID = s.GenerateTraceID
+	c.compressionThreshold = s.compressionThreshold
+	c.chunkSize = s.chunkSize
+	c.maxTransferSize = s.maxTransferSize
+	c.transferTimeout = s.transferTimeout
+	c.codec = s.Codec
+	c.jsonProtocol = s.AllowJSONProtocol && isJSONProtocolRequest(r)
+	c.strictParsing = s.StrictParsing
+	c.nativeUnknownNamespaces = s.NativeUnknownNamespaces
+	c.messageLimits = s.messageLimits
+	c.logger = s.logger
+	c.frameDump = s.frameDump
+	c.counters = s.counters
 	c.server = s
 
 	retriesHeaderValue := r.Header.Get(websocketReconectHeaderKey)
@@ -342,7 +1124,12 @@ func (s *Server) Upgrade(
 		}(c)
 	}
 
-	s.connect <- c
+	select {
+	case s.connect <- c:
+	case <-s.stopped:
+		c.Close()
+		return nil, errServerClosed
+	}
 
 	go c.startReader()
 
@@ -350,9 +1137,14 @@ func (s *Server) Upgrade(
 	// to Broadcast inside the `OnConnect` custom func.
 	if s.usesStackExchange() {
 		if err := s.StackExchange.OnConnect(c); err != nil {
+			c.logError("stackexchange connect failed", "err", err)
 			c.readiness.unwait(err)
 			return nil, err
 		}
+
+		if s.presence != nil {
+			s.announcePresence(c)
+		}
 	}
 
 	// Start the reader before `OnConnect`, remember clients may remotely connect to namespace before `Server#OnConnect`
@@ -374,7 +1166,7 @@ func (s *Server) Upgrade(
 	// `#Write:serverReadyWaiter.unwait` (for things like server connect).
 	// All cases tested & worked perfectly.
 	if s.OnConnect != nil {
-		if err = s.OnConnect(c); err != nil {
+		if err := s.OnConnect(c); err != nil {
 			// TODO: Do something with that error.
 			// The most suitable thing we can do is to somehow send this to the client's `Dial` return statement.
 			// This can be done if client waits for "OK" signal or a failure with an error before return the websocket connection,
@@ -383,6 +1175,7 @@ func (s *Server) Upgrade(
 			// Think more later today.
 			// Done but with a lot of code.... will try to cleanup some things.
 			//println("OnConnect error: " + err.Error())
+			c.logInfo("connection rejected", "err", err)
 			c.readiness.unwait(err)
 			// No need to disconnect here, connection's .Close will be called on readiness ch errored.
 
@@ -409,6 +1202,17 @@ func (s *Server) GetTotalConnections() uint64 {
 	return atomic.LoadUint64(&s.count)
 }
 
+// Metrics returns a snapshot of the server's counters.
+func (s *Server) Metrics() Metrics {
+	m := s.counters.snapshot()
+	m.HandlerPool = handlerPoolStats(s.namespaces)
+	for _, r := range s.poolReporters {
+		m.StackExchange.Pool = m.StackExchange.Pool.add(r.PoolStats())
+	}
+
+	return m
+}
+
 type action struct {
 	call func(*Conn)
 	done chan struct{}
@@ -427,13 +1231,27 @@ func (s *Server) Do(fn func(*Conn), async bool) {
 		// <-act.done
 	}
 
-	s.actions <- act
+	select {
+	case s.actions <- act:
+	case <-s.stopped:
+		// closed, there are no connections.
+		return
+	}
+
 	if !async {
 		<-act.done
 	}
 }
 
 func publishMessages(c *Conn, msgs []Message) bool {
+	if c.broadcastsStopped() {
+		// a slow consumer, see `SlowConsumerStopBroadcasts`.
+		return true
+	}
+
+	// the messages are written, the buffered ones, if any, are written now.
+	defer c.flush()
+
 	for _, msg := range msgs {
 		if msg.from == c.ID() {
 			// if the message is not supposed to return back to any connection with this ID.
@@ -495,11 +1313,19 @@ func Exclude(connID string) fmt.Stringer { return stringerValue{connID} }
 //	nsConn OR nil,
 //  neffos.Message{Namespace: "default", Room: "roomName or empty", Event: "chat", Body: [...]})
 //
+// If a `StackExchange` is used, the "msgs" are published to the other server instances
+// and they are written to the local connections in order, unless it's an `OriginStackExchange`
+// which does not preserve their origin, the local connections receive them through its delivery then.
+//
 // Note that it if `StackExchange` is nil then its default behavior
 // doesn't wait for a publish to complete to all clients before any
 // next broadcast call. To change that behavior set the `Server.SyncBroadcaster` to true
 // before server start.
+//
+// The "msgs" are cloned before they are queued, the caller may mutate them
+// (and their bodies) right after this call returns.
 func (s *Server) Broadcast(exceptSender fmt.Stringer, msgs ...Message) {
+	msgs = cloneMessages(msgs)
 
 	if exceptSender != nil {
 		var fromExplicit, from string
@@ -522,13 +1348,32 @@ func (s *Server) Broadcast(exceptSender fmt.Stringer, msgs ...Message) {
 		}
 	}
 
+	if s.GenerateTraceID {
+		for i := range msgs {
+			if msgs[i].TraceID == "" && !msgs[i].IsNative {
+				msgs[i].TraceID = genTraceID()
+			}
+		}
+	}
+
+	for i := range msgs {
+		msgs[i].prepared = new(preparedMessage)
+	}
+
 	if s.usesStackExchange() {
-		s.StackExchange.Publish(msgs)
-		return
+		s.publishToStackExchange(msgs)
+		if !s.writesLocal() {
+			return
+		}
+		// the local connections are written by the server's loop, in order,
+		// the delivery of the exchange to this server is dropped, see `Conn#Write`.
 	}
 
-	if s.SyncBroadcaster {
-		s.broadcastMessages <- msgs
+	if s.SyncBroadcaster || s.usesStackExchange() {
+		select {
+		case s.broadcastMessages <- msgs:
+		case <-s.stopped:
+		}
 		return
 	}
 
@@ -543,22 +1388,37 @@ func (s *Server) Broadcast(exceptSender fmt.Stringer, msgs ...Message) {
 // The second argument is the request message
 // which should be sent to a specific namespace:event
 // like the `Conn.Ask`.
+//
+// If the `StackExchange` completes the `AskableStackExchange` interface
+// and the "msg.To" is filled, the request is routed to the server instance which owns that connection,
+// it may return the `ErrConnNotFound` if that connection is not connected to any instance.
 func (s *Server) Ask(ctx context.Context, msg Message) (Message, error) {
 	if ctx == nil {
 		ctx = context.TODO()
 	}
 
+	msg.exchangeCompression = s.exchangeCompression
+
+	if msg.To != "" && s.usesStackExchange() {
+		if askable, ok := s.StackExchange.(AskableStackExchange); ok {
+			return askable.AskConn(ctx, msg.To, msg)
+		}
+	}
+
 	msg.wait = genWait(false)
 
 	if s.usesStackExchange() {
-		msg.wait = genWaitStackExchange(msg.wait)
-		return s.StackExchange.Ask(ctx, msg, msg.wait)
+		if !s.stackExchangeOpen() {
+			return Message{}, ErrWrite
+		}
+
+		token := msg.wait
+		msg.wait = genWaitStackExchange(token)
+		return s.StackExchange.Ask(ctx, msg, token)
 	}
 
-	ch := make(chan Message)
-	s.waitingMessagesMutex.Lock()
-	s.waitingMessages[msg.wait] = ch
-	s.waitingMessagesMutex.Unlock()
+	ch := s.addWaitingMessage(msg.wait)
+	defer s.waitingMessages.remove(msg.wait)
 
 	s.Broadcast(nil, msg)
 
@@ -566,14 +1426,127 @@ func (s *Server) Ask(ctx context.Context, msg Message) (Message, error) {
 	case <-ctx.Done():
 		return Message{}, ctx.Err()
 	case receive := <-ch:
-		s.waitingMessagesMutex.Lock()
-		delete(s.waitingMessages, msg.wait)
-		s.waitingMessagesMutex.Unlock()
-
 		return receive, receive.Err
 	}
 }
 
+// filterMessages returns the "msgs" which the "filter" returns true for,
+// the "msgs" are returned as they are, without a copy, when all of them pass.
+func filterMessages(msgs []Message, filter func(msg Message) bool) []Message {
+	for i := range msgs {
+		if filter(msgs[i]) {
+			continue
+		}
+
+		// the "msgs" are written to the local connections too, do not modify them.
+		filtered := make([]Message, i, len(msgs)-1)
+		copy(filtered, msgs[:i])
+		for _, msg := range msgs[i+1:] {
+			if filter(msg) {
+				filtered = append(filtered, msg)
+			}
+		}
+
+		return filtered
+	}
+
+	return msgs
+}
+
+func (s *Server) indexConn(c *Conn) {
+	s.connectionsByIDMutex.Lock()
+	conns, ok := s.connectionsByID[c.ID()]
+	if !ok {
+		conns = make(map[*Conn]struct{})
+		s.connectionsByID[c.ID()] = conns
+	}
+	conns[c] = struct{}{}
+	s.connectionsByIDMutex.Unlock()
+}
+
+func (s *Server) unindexConn(c *Conn) {
+	s.connectionsByIDMutex.Lock()
+	if conns, ok := s.connectionsByID[c.ID()]; ok {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(s.connectionsByID, c.ID())
+		}
+	}
+	s.connectionsByIDMutex.Unlock()
+}
+
+// DeliveryStatus describes the result of a `Server#SendTo` for a single connection ID.
+type DeliveryStatus uint8
+
+const (
+	// NotDelivered is the status of an ID which was not found on this server
+	// and could not be forwarded through a `StackExchange`.
+	NotDelivered DeliveryStatus = iota
+	// DeliveredLocally is the status of an ID with at least one connection on this server
+	// which the message was written to.
+	DeliveredLocally
+	// Forwarded is the status of an ID which was not found on this server
+	// and the message was published through the `StackExchange`,
+	// or buffered, see `Server.SetStackExchangeBatch`.
+	// Note that the remote delivery is not confirmed.
+	Forwarded
+)
+
+// String returns the text of the status.
+func (st DeliveryStatus) String() string {
+	switch st {
+	case DeliveredLocally:
+		return "delivered locally"
+	case Forwarded:
+		return "forwarded"
+	default:
+		return "not delivered"
+	}
+}
+
+// SendTo sends the "msg" to the connections with the given "ids" at once,
+// it's the multi-recipient version of the `Message.To` through `Broadcast`.
+// Connections of this server are resolved by their ID and written directly,
+// the rest of the IDs are forwarded through the `StackExchange`, if any.
+// Duplicated IDs are sent once.
+//
+// It returns the `DeliveryStatus` of each one of the "ids".
+func (s *Server) SendTo(msg Message, ids ...string) map[string]DeliveryStatus {
+	status := make(map[string]DeliveryStatus, len(ids))
+
+	for _, id := range ids {
+		if _, sent := status[id]; sent {
+			continue
+		}
+
+		msg.To = id
+		st := NotDelivered
+
+		s.connectionsByIDMutex.RLock()
+		conns := make([]*Conn, 0, len(s.connectionsByID[id]))
+		for c := range s.connectionsByID[id] {
+			conns = append(conns, c)
+		}
+		s.connectionsByIDMutex.RUnlock()
+
+		for _, c := range conns {
+			if c.Write(msg) {
+				st = DeliveredLocally
+			}
+		}
+
+		if len(conns) == 0 && s.usesStackExchange() {
+			if s.publishToStackExchange([]Message{msg}) {
+				st = Forwarded
+			}
+		}
+
+		status[id] = st
+	}
+
+	return status
+}
+
 // GetConnectionsByNamespace can be used as an alternative way to retrieve
 // all connected connections to a specific "namespace" on a specific time point.
 // Do not use this function frequently, it is not designed to be fast or cheap, use it for debugging or logging every 'x' time.
@@ -618,4 +1591,16 @@ var (
 	ErrBadRoom = errors.New("bad room")
 	// ErrWrite may return from any connection's method when the underline connection is closed (unexpectedly).
 	ErrWrite = errors.New("write closed")
+	// ErrConnNotFound may return from a `Server#Ask` when its `Message.To` connection
+	// is not connected to any server instance, see `AskableStackExchange`.
+	ErrConnNotFound = errors.New("connection not found")
+	// ErrPresenceUnsupported may return from a `Server#ClusterLookup` and `Server#ClusterTotalConnections`
+	// when none of the server's stackexchanges supports the cluster presence, see `PresenceStackExchange`.
+	ErrPresenceUnsupported = errors.New("presence is not supported by the stackexchange")
+	// ErrDynamicNamespacesUnsupported may return from a `Server#UseStackExchange` when the server has dynamic namespaces
+	// and the stackexchange receives the messages of its declared namespaces only, see `StaticNamespacesStackExchange`.
+	ErrDynamicNamespacesUnsupported = errors.New("dynamic namespaces are not supported by the stackexchange")
+	// ErrStackExchangeQueueFull is fired on `Server#OnStackExchangeError` when the oldest messages
+	// of a full queue are dropped, see `Server#SetStackExchangeQueue`.
+	ErrStackExchangeQueueFull = errors.New("stackexchange queue is full, messages are dropped")
 )
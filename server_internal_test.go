@@ -0,0 +1,95 @@
+package neffos
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServerNotifyDisconnectStopped(t *testing.T) {
+	server := New(nil, Namespaces{"default": Events{}})
+	server.Close()
+
+	select {
+	case <-server.stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the loop of the closed server without connections to be stopped")
+	}
+
+	// i.e a connection which is closed after the loop is stopped.
+	c, remote := newTestACKConn(server)
+	defer remote.NetConn().Close()
+
+	done := make(chan struct{})
+	go func() {
+		server.notifyDisconnect(c)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the disconnect notification to be abandoned")
+	}
+
+	if expected, got := uint64(1), server.Metrics().SkippedDisconnects; expected != got {
+		t.Fatalf("expected skipped disconnects: %d but got: %d", expected, got)
+	}
+
+	// the rest of the loop's channels do not block either.
+	server.Do(func(*Conn) {}, false)
+	server.SyncBroadcaster = true
+	server.Broadcast(nil, Message{Namespace: "default", Event: "event"})
+}
+
+// tlsSocket is a `Socket` of an unwrapped TLS connection, its request has no TLS state.
+type tlsSocket struct {
+	Socket
+	conn net.Conn
+}
+
+func (s *tlsSocket) NetConn() net.Conn { return s.conn }
+
+type tlsConn struct {
+	net.Conn
+	state tls.ConnectionState
+}
+
+func (c *tlsConn) ConnectionState() tls.ConnectionState { return c.state }
+
+func TestServeSocketTLSState(t *testing.T) {
+	server := New(nil, Namespaces{"default": Events{}})
+	defer server.Close()
+
+	var idState *tls.ConnectionState
+	server.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+		idState = r.TLS
+		return DefaultIDGenerator(w, r)
+	}
+
+	serverConn, clientConn := newPipeConns(SystemClock)
+	defer clientConn.Close()
+
+	r := newPipeRequest("/")
+	socket := &tlsSocket{
+		Socket: &pipeSocket{conn: serverConn, request: r},
+		conn:   &tlsConn{Conn: serverConn, state: tls.ConnectionState{HandshakeComplete: true}},
+	}
+
+	c, err := server.ServeSocket(&pipeResponseWriter{header: make(http.Header)}, r, socket, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if idState == nil || !idState.HandshakeComplete {
+		t.Fatalf("expected the connection's TLS state on the IDGenerator")
+	}
+	if state := c.TLSState(); state == nil || !state.HandshakeComplete {
+		t.Fatalf("expected the connection's TLS state")
+	}
+	if r.TLS != nil {
+		t.Fatalf("expected the request to be unmodified")
+	}
+}
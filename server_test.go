@@ -3,8 +3,17 @@ package neffos_test
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -14,32 +23,73 @@ import (
 
 	gobwas "github.com/kataras/neffos/gobwas"
 	gorilla "github.com/kataras/neffos/gorilla"
+	nhooyr "github.com/kataras/neffos/nhooyr"
+	tcp "github.com/kataras/neffos/tcp"
 
+	"github.com/gorilla/websocket"
 	"golang.org/x/sync/errgroup"
 )
 
+// testAdapters are the adapters which the servers of `runTestServer` and the clients of `runTestClient` use.
+var testAdapters = []string{"gobwas", "gorilla", "nhooyr", "tcp", "pipe"}
+
+// testWebsocketAdapters are the `testAdapters` which are served over websocket, at "ws://addr/adapter".
+var testWebsocketAdapters = testAdapters[:3]
+
+// testNetworkAdapters are the `testAdapters` which are served over a network listener, all except the in-memory one.
+var testNetworkAdapters = testAdapters[:4]
+
+// testPipeServers are the in-memory servers of `runTestServer` by their address, see `neffos.PipeDialer`.
+var testPipeServers sync.Map
+
+// testTCPAddr returns the address of the tcp server of `runTestServer`, the next port of the "addr".
+func testTCPAddr(addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(port)
+	return net.JoinHostPort(host, strconv.Itoa(n+1))
+}
+
 func runTestServer(addr string, connHandler neffos.ConnHandler, configureServer ...func(*neffos.Server)) func() error {
 	gobwasServer := neffos.New(gobwas.DefaultUpgrader, connHandler)
 	gorillaServer := neffos.New(gorilla.DefaultUpgrader, connHandler)
+	nhooyrServer := neffos.New(nhooyr.DefaultUpgrader, connHandler)
+	tcpServer := neffos.New(nil, connHandler)
+	pipeServer := neffos.New(nil, connHandler)
 
 	for _, cfg := range configureServer {
 		cfg(gobwasServer)
 		cfg(gorillaServer)
+		cfg(nhooyrServer)
+		cfg(tcpServer)
+		cfg(pipeServer)
 	}
+	testPipeServers.Store(addr, pipeServer)
 
 	mux := http.NewServeMux()
 	mux.Handle("/gobwas", gobwasServer)
 	mux.Handle("/gorilla", gorillaServer)
+	mux.Handle("/nhooyr", nhooyrServer)
 
 	httpServer := http.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
 	go httpServer.ListenAndServe()
+
+	tcpListener, err := net.Listen("tcp", testTCPAddr(addr))
+	if err != nil {
+		panic(err)
+	}
+	go tcp.Serve(tcpServer, tcpListener)
 	time.Sleep(200 * time.Millisecond)
 
 	// teardown.
 	return func() error {
+		testPipeServers.Delete(addr)
+		pipeServer.Close()
+		tcpListener.Close()
+		tcpServer.Close()
+		nhooyrServer.Close()
 		gorillaServer.Close()
 		gobwasServer.Close()
 		return httpServer.Close()
@@ -91,7 +141,7 @@ func TestServerBroadcastTo(t *testing.T) {
 	})
 	defer teardownServer()
 
-	wg.Add(2)
+	wg.Add(len(testAdapters)) // one "conn_ID" per server.
 
 	teardownClient1 := runTestClient("localhost:8080", events,
 		func(dialer string, client *neffos.Client) {
@@ -180,7 +230,7 @@ func TestServerAsk(t *testing.T) {
 	})
 	defer teardownServer()
 
-	wg.Add(2) // two servers, a gorilla and gobwas.
+	wg.Add(len(testAdapters)) // one per server.
 
 	teardownClient1 := runTestClient("localhost:8080", clientEvents,
 		func(dialer string, client *neffos.Client) {
@@ -206,3 +256,1347 @@ func TestServerAsk(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestServerMaxMessageSize(t *testing.T) {
+	var (
+		namespace  = "default"
+		maxSize    = int64(1024)
+		errorCount uint32
+		events     = neffos.Namespaces{
+			namespace: neffos.Events{
+				"event": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() && int64(len(msg.Body)) > maxSize {
+						t.Fatalf("message larger than %d bytes should not be dispatched", maxSize)
+					}
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.SetMaxMessageSize(maxSize)
+		wsServer.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			// the client's limit should not apply to the ack messages.
+			return strings.Repeat("i", int(maxSize)*2)
+		}
+		wsServer.OnError = func(c *neffos.Conn, err error) {
+			if err != neffos.ErrMessageTooLarge {
+				t.Fatalf("expected error: %v but got: %v", neffos.ErrMessageTooLarge, err)
+			}
+			atomic.AddUint32(&errorCount, 1)
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !c.Emit("event", bytes.Repeat([]byte("a"), int(maxSize)/2)) {
+			t.Fatalf("[%s] expected small message to be written", dialer)
+		}
+
+		c.Emit("event", bytes.Repeat([]byte("a"), int(maxSize)*2))
+
+		select {
+		case <-client.NotifyClose:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[%s] expected connection to be closed by the server after a too large message", dialer)
+		}
+	}, neffos.WithMaxMessageSize(maxSize))()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := uint32(len(testAdapters)), atomic.LoadUint32(&errorCount); expected != got {
+		t.Fatalf("expected %d too large message errors but got %d", expected, got)
+	}
+}
+
+func TestServerSendTo(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = neffos.NewInMemoryStackExchange()
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	newServer := func() (*neffos.Server, string, func()) {
+		server := neffos.New(gorilla.DefaultUpgrader, events)
+		server.StackExchange = exc
+		httpServer := httptest.NewServer(server)
+		return server, strings.Replace(httpServer.URL, "http", "ws", 1), func() {
+			server.Close()
+			httpServer.Close()
+		}
+	}
+
+	serverA, urlA, teardownA := newServer()
+	defer teardownA()
+	_, urlB, teardownB := newServer()
+	defer teardownB()
+
+	dial := func(url string) (*neffos.NSConn, chan []byte) {
+		received := make(chan []byte, 4)
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, neffos.Namespaces{namespace: neffos.Events{
+			"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- msg.Body
+				return nil
+			},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c, received
+	}
+
+	local, localReceived := dial(urlA)
+	defer local.Conn.Close()
+	other, otherReceived := dial(urlA)
+	defer other.Conn.Close()
+	remote, remoteReceived := dial(urlB)
+	defer remote.Conn.Close()
+
+	body := []byte("data")
+	status := serverA.SendTo(neffos.Message{Namespace: namespace, Event: "notify", Body: body},
+		local.Conn.ID(), local.Conn.ID(), remote.Conn.ID())
+
+	expected := map[string]neffos.DeliveryStatus{
+		local.Conn.ID():  neffos.DeliveredLocally,
+		remote.Conn.ID(): neffos.Forwarded,
+	}
+	if !reflect.DeepEqual(expected, status) {
+		t.Fatalf("expected status: %v but got: %v", expected, status)
+	}
+
+	for _, received := range []chan []byte{localReceived, remoteReceived} {
+		select {
+		case b := <-received:
+			if !bytes.Equal(b, body) {
+				t.Fatalf("expected body: %s but got: %s", body, b)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected a message")
+		}
+	}
+
+	select {
+	case b := <-localReceived:
+		t.Fatalf("expected a duplicated ID to be sent once but got a second message: %s", b)
+	case b := <-otherReceived:
+		t.Fatalf("expected the message to be sent only to the given IDs but got: %s", b)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	withoutExchange := neffos.New(gorilla.DefaultUpgrader, events)
+	defer withoutExchange.Close()
+	if st := withoutExchange.SendTo(neffos.Message{Namespace: namespace, Event: "notify"}, "missing")["missing"]; st != neffos.NotDelivered {
+		t.Fatalf("expected status: %s but got: %s", neffos.NotDelivered, st)
+	}
+}
+
+func TestServerMessageValidator(t *testing.T) {
+	var (
+		namespace  = "default"
+		errTooLong = errors.New("body too long")
+		errVIP     = errors.New("vip room")
+		dispatched uint32
+		validated  uint32
+		errorCount uint32
+		events     = neffos.Namespaces{
+			namespace: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						atomic.AddUint32(&dispatched, 1)
+					}
+					return nil
+				},
+			},
+			"private": neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.SetMessageValidator(
+			func(c *neffos.Conn, msg *neffos.Message) error {
+				atomic.AddUint32(&validated, 1)
+				if msg.Event == neffos.OnNamespaceConnect && msg.Namespace == "private" {
+					return neffos.ErrBadNamespace
+				}
+				return nil
+			},
+			func(c *neffos.Conn, msg *neffos.Message) error {
+				if msg.Event == "chat" && len(msg.Body) > 4 {
+					return errTooLong
+				}
+
+				if msg.Event == neffos.OnRoomJoin && msg.Room == "vip" {
+					return errVIP
+				}
+				return nil
+			},
+		)
+		wsServer.OnError = func(c *neffos.Conn, err error) {
+			atomic.AddUint32(&errorCount, 1)
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		if _, err := client.Connect(context.TODO(), "private"); err != neffos.ErrBadNamespace {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrBadNamespace, err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(context.TODO(), "vip"); err == nil || err.Error() != errVIP.Error() {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, errVIP, err)
+		}
+
+		if _, err = c.JoinRoom(context.TODO(), "lobby"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(context.TODO(), "chat", []byte("too long")); err == nil || err.Error() != errTooLong.Error() {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, errTooLong, err)
+		}
+
+		c.Emit("chat", []byte("ok"))
+		// make sure the emitted message has been processed before close.
+		if _, err = c.Ask(context.TODO(), "chat", []byte("too long")); err == nil {
+			t.Fatalf("[%s] expected an error", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := uint32(len(testAdapters)), atomic.LoadUint32(&dispatched); expected != got {
+		t.Fatalf("expected %d dispatched messages but got: %d", expected, got)
+	}
+
+	// private connect, vip join and two long chat messages per dialer.
+	if expected, got := uint32(4*len(testAdapters)), atomic.LoadUint32(&errorCount); expected != got {
+		t.Fatalf("expected %d errors but got: %d", expected, got)
+	}
+
+	if atomic.LoadUint32(&validated) == 0 {
+		t.Fatalf("expected validators to be called")
+	}
+}
+
+func TestServerCompression(t *testing.T) {
+	var (
+		namespace = "default"
+		threshold = 1024
+		body      = bytes.Repeat([]byte(`{"widget":"value"};`), threshold)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"snapshot": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !bytes.Equal(msg.Body, body) {
+						t.Errorf("expected body to be decompressed before the event callback")
+					}
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.SetCompression(threshold)
+	})
+	defer teardownServer()
+
+	test := func(options ...neffos.DialOption) {
+		err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+			defer client.Close()
+
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := c.Ask(context.TODO(), "snapshot", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(response.Body, body) {
+				t.Fatalf("[%s] expected response body of %d bytes but got %d bytes", dialer, len(body), len(response.Body))
+			}
+		}, options...)()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// negotiated.
+	test(neffos.WithCompression(threshold))
+	// a client which does not support it.
+	test()
+}
+
+func TestEmitLarge(t *testing.T) {
+	var (
+		namespace = "default"
+		chunkSize = 16 * 1024
+		payloads  = [][]byte{
+			bytes.Repeat([]byte("a"), 256*1024),
+			bytes.Repeat([]byte("b"), 256*1024+1),
+			bytes.Repeat([]byte("c"), chunkSize),
+			[]byte("d"),
+		}
+		received = make(chan neffos.Message, len(payloads)*2)
+		events   = neffos.Namespaces{
+			namespace: neffos.Events{
+				"file": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						received <- msg
+					}
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.SetMaxMessageSize(int64(chunkSize) * 2)
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// concurrent transfers on the same connection.
+		var wg sync.WaitGroup
+		for _, payload := range payloads {
+			wg.Add(1)
+			go func(payload []byte) {
+				defer wg.Done()
+				if err := c.EmitLarge("file", bytes.NewReader(payload), int64(len(payload))); err != nil {
+					t.Error(err)
+				}
+			}(payload)
+		}
+		wg.Wait()
+
+		for range payloads {
+			select {
+			case msg := <-received:
+				if msg.Err != nil {
+					t.Fatalf("[%s] %v", dialer, msg.Err)
+				}
+
+				var found bool
+				for _, payload := range payloads {
+					if bytes.Equal(msg.Body, payload) {
+						found = true
+						break
+					}
+				}
+
+				if !found {
+					t.Fatalf("[%s] received a corrupted body of %d bytes", dialer, len(msg.Body))
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("[%s] expected all transfers to be completed", dialer)
+			}
+		}
+	}, neffos.WithChunking(chunkSize, 0, 0))()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerJSONProtocol(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						return nil
+					}
+					return neffos.Reply(msg.Body)
+				},
+				"fail": func(c *neffos.NSConn, msg neffos.Message) error {
+					return fmt.Errorf("failed")
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.AllowJSONProtocol = true
+	})
+	defer teardownServer()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/gorilla?protocol=json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expect := func(expected string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, exp map[string]interface{}
+		json.Unmarshal(b, &got)
+		json.Unmarshal([]byte(expected), &exp)
+		if _, ok := exp["body"]; !ok {
+			// the ack's body is the generated connection ID.
+			delete(got, "body")
+		}
+
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("expected frame: %s but got: %s", expected, b)
+		}
+	}
+
+	send := func(frame string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect(`{"event":"_OnAck"}`)
+
+	send(`{"wait":"$1","namespace":"default","event":"_OnNamespaceConnect"}`)
+	// an empty reply, like the default protocol's one.
+	expect(`{"wait":"$1"}`)
+
+	send(`{"wait":"$2","namespace":"default","event":"echo","body":{"text":"hello"}}`)
+	expect(`{"wait":"$2","namespace":"default","event":"echo","body":{"text":"hello"}}`)
+
+	send(`{"wait":"$3","namespace":"default","event":"echo","body":"hello"}`)
+	expect(`{"wait":"$3","namespace":"default","event":"echo","body":"hello"}`)
+
+	send(`{"wait":"$4","namespace":"default","event":"fail"}`)
+	expect(`{"wait":"$4","namespace":"default","event":"fail","err":"failed"}`)
+
+	send(`{"wait":"$5","namespace":"default","room":"room1","event":"_OnRoomJoin"}`)
+	expect(`{"wait":"$5"}`)
+
+	// both protocols on the same server.
+	err = runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := c.Ask(context.TODO(), "echo", []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, got := "hello", string(response.Body); expected != got {
+			t.Fatalf("[%s] expected body: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerTraceID(t *testing.T) {
+	var (
+		namespace = "default"
+		traces    = make(chan string, 8)
+		untraced  = make(chan string, 2)
+		errTrace  = make(chan string, 2)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+					if msg.TraceID != c.Conn.TraceID() {
+						t.Errorf("expected the connection's current trace ID: %s but got: %s", msg.TraceID, c.Conn.TraceID())
+					}
+
+					if traceID := neffos.TraceIDFromContext(msg.Context()); msg.TraceID != traceID {
+						t.Errorf("expected the context's trace ID: %s but got: %s", msg.TraceID, traceID)
+					}
+
+					traces <- msg.TraceID
+					// carries the trace ID of the handled message.
+					c.EmitCtx(msg.Context(), "notify", nil)
+					c.Conn.Server().Broadcast(c, neffos.Message{Namespace: namespace, Event: "notify", TraceID: msg.TraceID})
+					// does not inherit it, a new one is generated.
+					c.Emit("untraced", nil)
+					return neffos.Reply([]byte("ok"))
+				},
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					traces <- msg.TraceID
+					return nil
+				},
+				"untraced": func(c *neffos.NSConn, msg neffos.Message) error {
+					untraced <- msg.TraceID
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.GenerateTraceID = true
+		wsServer.SetMessageValidator(func(c *neffos.Conn, msg *neffos.Message) error {
+			if msg.Event == "invalid" {
+				return fmt.Errorf("invalid event")
+			}
+			return nil
+		})
+		wsServer.OnError = func(c *neffos.Conn, err error) {
+			errTrace <- c.TraceID()
+		}
+	})
+	defer teardownServer()
+
+	otherClient, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, "ws://localhost:8080/gorilla", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherClient.Close()
+	if _, err = otherClient.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, "ws://localhost:8080/gorilla", events, neffos.WithGenerateTraceID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	nsConn, err := c.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := nsConn.Ask(context.TODO(), "ask", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceID := response.TraceID
+	if traceID == "" {
+		t.Fatalf("expected a generated trace ID on the reply")
+	}
+
+	// the asked one, the emitted and the broadcasted ones.
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-traces:
+			if got != traceID {
+				t.Fatalf("[%d] expected trace ID: %s but got: %s", i, traceID, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%d] expected a message with the trace ID", i)
+		}
+	}
+
+	select {
+	case got := <-untraced:
+		if got == "" || got == traceID {
+			t.Fatalf("expected a new trace ID on the plain emit but got: %q", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the plain emit")
+	}
+
+	nsConn.Conn.Write(neffos.Message{Namespace: namespace, Event: "invalid", TraceID: "custom"})
+	select {
+	case got := <-errTrace:
+		if expected := "custom"; got != expected {
+			t.Fatalf("expected the error handler to get the trace ID: %s but got: %s", expected, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the error handler to be fired")
+	}
+}
+
+func TestServerStrictParsing(t *testing.T) {
+	var (
+		namespace = "default"
+		errs      = make(chan error, 4)
+		events    = neffos.Namespaces{namespace: neffos.Events{"chat": func(*neffos.NSConn, neffos.Message) error { return nil }}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.StrictParsing = true
+		wsServer.OnError = func(c *neffos.Conn, err error) {
+			errs <- err
+		}
+	})
+	defer teardownServer()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/gorilla", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	read := func() string {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	expectParseError := func(field string, offset int) {
+		t.Helper()
+		select {
+		case err := <-errs:
+			parseErr, ok := err.(*neffos.ParseError)
+			if !ok {
+				t.Fatalf("expected a parse error but got: %v", err)
+			}
+
+			if parseErr.Field != field || parseErr.Offset != offset {
+				t.Fatalf("expected field: %s at offset: %d but got: %#+v", field, offset, parseErr)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected the error handler to be fired")
+		}
+	}
+
+	// the ack of a client without a codec.
+	conn.WriteMessage(websocket.TextMessage, []byte("M"))
+	if got := read(); !strings.HasPrefix(got, "A") {
+		t.Fatalf("expected ack reply but got: %s", got)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("$1;default;;chat;2;0;body"))
+	expectParseError("isError", 17)
+	// sent back because of the wait token.
+	if expected, got := "$1;;;;1;0;invalid isError field at offset 17", read(); expected != got {
+		t.Fatalf("expected reply: %s but got: %s", expected, got)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("garbage"))
+	expectParseError("wait", 7)
+}
+
+func TestServerBroadcastOwnership(t *testing.T) {
+	// the caller of Broadcast owns its messages, it may mutate them right after the call,
+	// i.e an in-place JSON patch, without affecting what the recipients receive.
+	var (
+		namespace = "default"
+		original  = []byte(`{"value":"original"}`)
+		clients   = 8
+		times     = 20
+		received  = make(chan []byte, clients*times)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"patch": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- msg.Body
+					return nil
+				},
+			},
+		}
+	)
+
+	srv := neffos.New(gorilla.DefaultUpgrader, events)
+	httpServer := httptest.NewServer(srv)
+	defer httpServer.Close()
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+	for i := 0; i < clients; i++ {
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if _, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < times; i++ {
+		body := append([]byte(nil), original...)
+		msgs := []neffos.Message{{Namespace: namespace, Event: "patch", Body: body}}
+		srv.Broadcast(nil, msgs...)
+
+		copy(body, `{"value":"mutated!"}`)
+		msgs[0].Event = "mutated"
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for i := 0; i < clients*times; i++ {
+		select {
+		case body := <-received:
+			if !bytes.Equal(body, original) {
+				t.Fatalf("expected body: %s but got: %s", original, body)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected %d messages but got %d", clients*times, i)
+		}
+	}
+}
+
+func TestServerEventHooks(t *testing.T) {
+	type recorder struct {
+		mu    sync.Mutex
+		calls []string
+	}
+
+	var (
+		namespace    = "default"
+		errFail      = errors.New("fail")
+		errForbidden = errors.New("forbidden")
+		server       = new(recorder)
+		client       = new(recorder)
+		events       = neffos.Events{
+			"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+				return neffos.Reply(msg.Body)
+			},
+			"fail": func(c *neffos.NSConn, msg neffos.Message) error {
+				return errFail
+			},
+			"forbidden": func(c *neffos.NSConn, msg neffos.Message) error {
+				t.Errorf("expected the forbidden event to be vetoed")
+				return nil
+			},
+		}
+	)
+
+	before := func(r *recorder) func(*neffos.NSConn, neffos.Message) error {
+		return func(ns *neffos.NSConn, msg neffos.Message) error {
+			if msg.Event == neffos.OnNamespaceDisconnect {
+				// the previous client may be still disconnecting.
+				return nil
+			}
+
+			r.mu.Lock()
+			r.calls = append(r.calls, "before:"+msg.Event)
+			r.mu.Unlock()
+
+			if msg.Event == "forbidden" {
+				return errForbidden
+			}
+			return nil
+		}
+	}
+
+	after := func(r *recorder) func(*neffos.NSConn, neffos.Message, time.Duration, error) {
+		return func(ns *neffos.NSConn, msg neffos.Message, dur time.Duration, err error) {
+			if dur < 0 {
+				t.Errorf("expected a positive duration but got: %s", dur)
+			}
+
+			if msg.Event == neffos.OnNamespaceDisconnect {
+				return
+			}
+
+			r.mu.Lock()
+			r.calls = append(r.calls, fmt.Sprintf("after:%s:%v", msg.Event, err))
+			r.mu.Unlock()
+		}
+	}
+
+	// waits for the "expected" calls, in order, since the lifecycle events may be fired after the replies.
+	expect := func(dialer, side string, r *recorder, expected []string) {
+		t.Helper()
+
+		var got []string
+		for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			r.mu.Lock()
+			got = append(got[:0], r.calls...)
+			r.mu.Unlock()
+
+			if reflect.DeepEqual(got, expected) {
+				return
+			}
+		}
+
+		t.Fatalf("[%s] expected %s calls:\n%v\nbut got:\n%v", dialer, side, expected, got)
+	}
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events}, func(wsServer *neffos.Server) {
+		wsServer.OnBeforeEvent = before(server)
+		wsServer.OnAfterEvent = after(server)
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: events}, func(dialer string, c *neffos.Client) {
+		defer c.Close()
+
+		server.mu.Lock()
+		server.calls = nil
+		server.mu.Unlock()
+		client.mu.Lock()
+		client.calls = nil
+		client.mu.Unlock()
+
+		nsConn, err := c.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = nsConn.Ask(context.TODO(), "echo", []byte("ok")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = nsConn.Ask(context.TODO(), "fail", nil); err == nil || err.Error() != errFail.Error() {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, errFail, err)
+		}
+
+		if _, err = nsConn.Ask(context.TODO(), "forbidden", nil); err == nil || err.Error() != errForbidden.Error() {
+			t.Fatalf("[%s] expected the veto error: %v but got: %v", dialer, errForbidden, err)
+		}
+
+		expect(dialer, "server", server, []string{
+			"before:" + neffos.OnNamespaceConnect, "after:" + neffos.OnNamespaceConnect + ":<nil>",
+			"before:" + neffos.OnNamespaceConnected, "after:" + neffos.OnNamespaceConnected + ":<nil>",
+			"before:echo", "after:echo:<nil>",
+			"before:fail", "after:fail:fail",
+			"before:forbidden",
+		})
+
+		expect(dialer, "client", client, []string{
+			"before:" + neffos.OnNamespaceConnect, "after:" + neffos.OnNamespaceConnect + ":<nil>",
+			"before:" + neffos.OnNamespaceConnected, "after:" + neffos.OnNamespaceConnected + ":<nil>",
+		})
+	}, neffos.WithOnBeforeEvent(before(client)), neffos.WithOnAfterEvent(after(client)))()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerOnMessageProcessed(t *testing.T) {
+	var (
+		namespace = "default"
+		errFail   = errors.New("fail")
+		events    = neffos.Namespaces{
+			"": neffos.Events{
+				neffos.OnNativeMessage: func(c *neffos.NSConn, msg neffos.Message) error {
+					return nil
+				},
+			},
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+				"fail": func(c *neffos.NSConn, msg neffos.Message) error {
+					return errFail
+				},
+			},
+		}
+	)
+
+	type recorder struct {
+		mu    sync.Mutex
+		calls []string
+	}
+
+	record := func(r *recorder) func(c *neffos.Conn, msg neffos.Message, dur time.Duration, err error) {
+		return func(c *neffos.Conn, msg neffos.Message, dur time.Duration, err error) {
+			if dur <= 0 {
+				t.Errorf("expected a positive duration but got: %s", dur)
+			}
+
+			result := fmt.Sprint(err)
+			if errors.Is(err, errFail) {
+				// wrapped by an *EventError.
+				result = errFail.Error()
+			}
+
+			r.mu.Lock()
+			r.calls = append(r.calls, msg.Event+":"+result)
+			r.mu.Unlock()
+		}
+	}
+
+	var server, client recorder
+	wsServer := neffos.New(nil, events)
+	wsServer.OnMessageProcessed = record(&server)
+	defer wsServer.Close()
+
+	var conn *neffos.Conn
+	c, err := neffos.Dial(context.TODO(), neffos.PipeDialer(wsServer), "/", events,
+		neffos.WithOnMessageProcessed(record(&client)), func(c *neffos.Conn) { conn = c })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	nsConn, err := c.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = nsConn.Ask(context.TODO(), "echo", []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = nsConn.Ask(context.TODO(), "fail", nil); err == nil || err.Error() != errFail.Error() {
+		t.Fatalf("expected error: %v but got: %v", errFail, err)
+	}
+
+	// an expired and a native message, the server does not reply to them.
+	expired := neffos.Message{Namespace: namespace, Event: "echo", Expiry: 1}
+	if err = conn.Socket().WriteText(expired.Serialize(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Socket().WriteText([]byte("native"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		neffos.OnNamespaceConnect + ":<nil>",
+		"echo:<nil>",
+		"fail:fail",
+		"echo:" + neffos.ErrMessageExpired.Error(),
+		neffos.OnNativeMessage + ":<nil>",
+	}
+
+	var got []string
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		server.mu.Lock()
+		got = append(got[:0], server.calls...)
+		server.mu.Unlock()
+
+		if reflect.DeepEqual(got, expected) {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected server calls:\n%v\nbut got:\n%v", expected, got)
+	}
+
+	// the replies of the asks, the one of the connect is an empty reply.
+	client.mu.Lock()
+	got = client.calls
+	client.mu.Unlock()
+
+	if expected := []string{":<nil>", "echo:<nil>", "fail:<nil>"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected client calls:\n%v\nbut got:\n%v", expected, got)
+	}
+}
+
+func TestServerCloseConcurrentDisconnects(t *testing.T) {
+	const n = 2000
+
+	baseline := runtime.NumGoroutine()
+
+	var disconnected uint64
+	server := neffos.New(nil, neffos.Namespaces{"default": neffos.Events{}})
+	server.FireDisconnectAlways = true
+	server.OnDisconnect = func(c *neffos.Conn) {
+		atomic.AddUint64(&disconnected, 1)
+	}
+
+	clients := make([]*neffos.Client, 0, n)
+	for i := 0; i < n; i++ {
+		client, err := neffos.Dial(context.TODO(), neffos.PipeDialer(server), "/", neffos.Namespaces{"default": neffos.Events{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+
+	for deadline := time.Now().Add(3 * time.Second); server.GetTotalConnections() != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d server connections but got: %d", n, server.GetTotalConnections())
+		}
+	}
+
+	// the clients close their connections while the server is closed.
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *neffos.Client) {
+			defer wg.Done()
+			<-start
+			client.Close()
+		}(client)
+	}
+	close(start)
+	server.Close()
+	wg.Wait()
+
+	var got int
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if got = runtime.NumGoroutine(); got <= baseline {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("expected at most %d goroutines but got: %d, leaked:\n%s", baseline, got, buf)
+		}
+	}
+
+	if expected, got := uint64(n), atomic.LoadUint64(&disconnected)+server.Metrics().SkippedDisconnects; expected != got {
+		t.Fatalf("expected %d fired or skipped disconnects but got: %d", expected, got)
+	}
+	if total := server.GetTotalConnections(); total != 0 {
+		t.Fatalf("expected no server connections but got: %d", total)
+	}
+}
+
+func TestServerDescribe(t *testing.T) {
+	noop := func(*neffos.NSConn, neffos.Message) error { return nil }
+	namespaces := neffos.Namespaces{"chat": neffos.Events{"send": noop, neffos.OnRoomJoined: noop}}
+	namespaces.SetDynamic(func(namespace string) (neffos.Events, bool) {
+		return neffos.Events{"whoami": noop}, true
+	})
+
+	server := neffos.New(gorilla.DefaultUpgrader, namespaces)
+	defer server.Close()
+
+	if expected, got := map[string][]string{"chat": {neffos.OnRoomJoined, "send"}}, server.Describe(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected description:\n%v\nbut got:\n%v", expected, got)
+	}
+
+	// registered while the server is running.
+	if err := server.SetEvent("chat", "edit", noop); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := []string{neffos.OnRoomJoined, "edit", "send"}, server.Describe()["chat"]; !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected events:\n%v\nbut got:\n%v", expected, got)
+	}
+}
+
+func TestServerErrorTranslator(t *testing.T) {
+	var (
+		namespace     = "default"
+		errDB         = errors.New("pq: relation \"users\" does not exist")
+		errSuppressed = errors.New("suppressed")
+		errInternal   = errors.New("internal error")
+		errTyped      = neffos.NewError(1001, "typed", []byte("data"))
+		events        = neffos.Namespaces{
+			namespace: neffos.Events{
+				"db": func(c *neffos.NSConn, msg neffos.Message) error {
+					return errDB
+				},
+				"deferred": func(c *neffos.NSConn, msg neffos.Message) error {
+					reply := c.DeferReply(msg)
+					go reply(nil, errDB)
+					return neffos.ErrReplyDeferred
+				},
+				"suppressed": func(c *neffos.NSConn, msg neffos.Message) error {
+					return errSuppressed
+				},
+				"typed": func(c *neffos.NSConn, msg neffos.Message) error {
+					return errTyped
+				},
+			},
+			"private": neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						return nil
+					}
+					return errDB
+				},
+			},
+			"hidden": neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						return nil
+					}
+					return errSuppressed
+				},
+			},
+		}
+		originals = make(chan error, 16)
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.OnError = func(c *neffos.Conn, err error) {
+			if errors.Is(err, errDB) {
+				originals <- err
+			}
+		}
+		wsServer.SetErrorTranslator(func(c *neffos.NSConn, msg neffos.Message, err error) error {
+			if err == errSuppressed {
+				return nil
+			}
+			return errInternal
+		})
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, c *neffos.Client) {
+		defer c.Close()
+
+		nsConn, err := c.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, event := range []string{"db", "deferred"} {
+			if _, err = nsConn.Ask(context.TODO(), event, nil); err == nil || err.Error() != errInternal.Error() {
+				t.Fatalf("[%s:%s] expected the translated error: %v but got: %v", dialer, event, errInternal, err)
+			}
+		}
+
+		// the local hooks receive the original error.
+		select {
+		case <-originals:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] expected the original error to be reported to the OnError", dialer)
+		}
+
+		reply, err := nsConn.Ask(context.TODO(), "suppressed", nil)
+		if err != nil {
+			t.Fatalf("[%s] expected an empty successful reply but got: %v", dialer, err)
+		}
+		if len(reply.Body) != 0 {
+			t.Fatalf("[%s] expected an empty reply but got: %s", dialer, reply.Body)
+		}
+
+		if _, err = nsConn.Ask(context.TODO(), "typed", nil); !errors.Is(err, errTyped) {
+			t.Fatalf("[%s] expected the typed error: %v but got: %v", dialer, errTyped, err)
+		}
+
+		if _, err = c.Connect(context.TODO(), "private"); err == nil || err.Error() != errInternal.Error() {
+			t.Fatalf("[%s] expected the translated connect error: %v but got: %v", dialer, errInternal, err)
+		}
+
+		if _, err = c.Connect(context.TODO(), "hidden"); !errors.Is(err, neffos.ErrRejected) {
+			t.Fatalf("[%s] expected the connect error: %v but got: %v", dialer, neffos.ErrRejected, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// compressionRecorder records the data frames which the server sends, see `TestGorillaUpgraderCompression`.
+type compressionRecorder struct {
+	net.Conn
+
+	mu         sync.Mutex
+	read       []byte
+	handshaken bool
+	compressed int
+	plain      int
+}
+
+func (c *compressionRecorder) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.mu.Lock()
+	c.read = append(c.read, b[:n]...)
+	c.parseFrames()
+	c.mu.Unlock()
+
+	return n, err
+}
+
+func (c *compressionRecorder) parseFrames() {
+	if !c.handshaken {
+		idx := bytes.Index(c.read, []byte("\r\n\r\n"))
+		if idx == -1 {
+			return
+		}
+
+		c.read = c.read[idx+4:]
+		c.handshaken = true
+	}
+
+	for len(c.read) >= 2 {
+		header, length := 2, int(c.read[1]&0x7f)
+		switch length {
+		case 126:
+			if len(c.read) < 4 {
+				return
+			}
+			header, length = 4, int(binary.BigEndian.Uint16(c.read[2:4]))
+		case 127:
+			if len(c.read) < 10 {
+				return
+			}
+			header, length = 10, int(binary.BigEndian.Uint64(c.read[2:10]))
+		}
+
+		if len(c.read) < header+length {
+			return
+		}
+
+		if opCode := c.read[0] & 0x0f; opCode == websocket.TextMessage || opCode == websocket.BinaryMessage {
+			if c.read[0]&0x40 != 0 { // RSV1, the permessage-deflate bit.
+				c.compressed++
+			} else {
+				c.plain++
+			}
+		}
+
+		c.read = c.read[header+length:]
+	}
+}
+
+func TestGorillaUpgraderCompression(t *testing.T) {
+	var (
+		namespace = "default"
+		body      = bytes.Repeat([]byte("compress me;"), 1024)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	server := neffos.New(gorilla.Upgrader(websocket.Upgrader{
+		EnableCompression: true,
+		ReadBufferSize:    512,
+		WriteBufferSize:   512,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			w.WriteHeader(http.StatusTeapot)
+		},
+	}), events)
+	defer server.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	// the custom error handler of the upgrader is used.
+	resp, err := http.Get(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, got := http.StatusTeapot, resp.StatusCode; expected != got {
+		t.Fatalf("expected the status code of the upgrader's error handler: %d but got: %d", expected, got)
+	}
+
+	recorder := new(compressionRecorder)
+	dialer := gorilla.Dialer(&websocket.Dialer{
+		EnableCompression: true,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+			recorder.Conn = conn
+			return recorder, err
+		},
+	}, nil)
+
+	client, err := neffos.Dial(context.TODO(), dialer, strings.Replace(httpServer.URL, "http", "ws", 1), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, setBinary := range []bool{false, true} {
+		reply, err := c.Conn.Ask(context.TODO(), neffos.Message{Namespace: namespace, Event: "echo", Body: body, SetBinary: setBinary})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(reply.Body, body) {
+			t.Fatalf("expected the echo of the compressed message")
+		}
+	}
+
+	recorder.mu.Lock()
+	compressed, plain := recorder.compressed, recorder.plain
+	recorder.mu.Unlock()
+
+	// the ack, the connect reply and the text and binary echoes.
+	if compressed < 4 || plain != 0 {
+		t.Fatalf("expected all the data frames of the server to be compressed but got: %d compressed and %d plain", compressed, plain)
+	}
+}
+
+// plainSocket hides the `neffos.SocketPreparedWriter` of its socket.
+type plainSocket struct{ neffos.Socket }
+
+func BenchmarkServerBroadcastCompression(b *testing.B) {
+	benchmarks := []struct {
+		name     string
+		upgrader func(neffos.Upgrader) neffos.Upgrader
+	}{
+		{"prepared", func(upgrader neffos.Upgrader) neffos.Upgrader { return upgrader }},
+		{"plain", func(upgrader neffos.Upgrader) neffos.Upgrader {
+			return func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
+				socket, err := upgrader(w, r)
+				return plainSocket{socket}, err
+			}
+		}},
+	}
+
+	for _, bench := range benchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			var (
+				namespace = "default"
+				clients   = 50
+				body      = bytes.Repeat([]byte("compress me;"), 1024)
+				received  = make(chan struct{}, clients)
+				events    = neffos.Namespaces{
+					namespace: neffos.Events{
+						"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+							received <- struct{}{}
+							return nil
+						},
+					},
+				}
+			)
+
+			server := neffos.New(bench.upgrader(gorilla.Upgrader(websocket.Upgrader{EnableCompression: true})), events)
+			defer server.Close()
+
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+
+			dialer := gorilla.Dialer(&websocket.Dialer{EnableCompression: true}, nil)
+			for i := 0; i < clients; i++ {
+				client, err := neffos.Dial(context.TODO(), dialer, strings.Replace(httpServer.URL, "http", "ws", 1), events)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer client.Close()
+
+				if _, err = client.Connect(context.TODO(), namespace); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				server.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "chat", Body: body})
+				for j := 0; j < clients; j++ {
+					<-received
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package neffos
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SlowConsumerAction is the action of the `Server.OnSlowConsumer` for a slow connection.
+type SlowConsumerAction uint8
+
+const (
+	// SlowConsumerIgnore keeps writing to the connection.
+	SlowConsumerIgnore SlowConsumerAction = iota
+	// SlowConsumerStopBroadcasts excludes the connection from the broadcasts until it recovers,
+	// its next write which is not slow, i.e a reply to one of its messages. The rest of its writes are not affected.
+	SlowConsumerStopBroadcasts
+	// SlowConsumerClose closes the connection.
+	SlowConsumerClose
+)
+
+// SlowStats are the statistics of the slow writes of a connection, see `Server.OnSlowConsumer`.
+type SlowStats struct {
+	// ConsecutiveSlowWrites is the number of the slow writes since the last write which was not slow.
+	ConsecutiveSlowWrites int
+	// ConsecutiveTimeouts is the number of the writes which hit the write timeout since the last write which was not slow.
+	ConsecutiveTimeouts int
+	// TotalSlowWrites is the number of the slow writes of the connection.
+	TotalSlowWrites uint64
+	// LastWriteDuration is the duration of the last slow write.
+	LastWriteDuration time.Duration
+	// BroadcastsStopped reports whether the connection is excluded from the broadcasts.
+	BroadcastsStopped bool
+}
+
+// slowWrites keeps the live values of the `SlowStats` of a connection.
+// All fields are modified through the atomic package.
+type slowWrites struct {
+	total       uint64
+	consecutive uint32
+	timeouts    uint32
+	stopped     uint32
+}
+
+// SlowStats returns the statistics of the slow writes of the connection, see `Server.SlowWriteThreshold`.
+func (c *Conn) SlowStats() SlowStats {
+	return SlowStats{
+		ConsecutiveSlowWrites: int(atomic.LoadUint32(&c.slowWrites.consecutive)),
+		ConsecutiveTimeouts:   int(atomic.LoadUint32(&c.slowWrites.timeouts)),
+		TotalSlowWrites:       atomic.LoadUint64(&c.slowWrites.total),
+		BroadcastsStopped:     c.broadcastsStopped(),
+	}
+}
+
+func (c *Conn) broadcastsStopped() bool {
+	return atomic.LoadUint32(&c.slowWrites.stopped) == 1
+}
+
+// observesWrites reports whether the writes of the connection are checked for slow consumers.
+func (c *Conn) observesWrites() bool {
+	return c.server != nil && c.server.SlowWriteThreshold > 0
+}
+
+// observeWrite checks whether the write which started at "start" and failed with "err", if any, was slow
+// and fires the `Server.OnSlowConsumer`. A write which was not slow resets the consecutive counters.
+func (c *Conn) observeWrite(start time.Time, err error) {
+	s := c.server
+	dur := time.Since(start)
+	timeout := IsTimeoutError(err)
+
+	if dur < s.SlowWriteThreshold && !timeout {
+		if atomic.LoadUint32(&c.slowWrites.consecutive) > 0 {
+			atomic.StoreUint32(&c.slowWrites.consecutive, 0)
+			atomic.StoreUint32(&c.slowWrites.timeouts, 0)
+		}
+
+		if atomic.CompareAndSwapUint32(&c.slowWrites.stopped, 1, 0) {
+			atomic.AddUint64(&s.counters.slowConsumers, ^uint64(0))
+			c.logInfo("slow consumer recovered")
+		}
+		return
+	}
+
+	atomic.AddUint64(&c.slowWrites.total, 1)
+	atomic.AddUint32(&c.slowWrites.consecutive, 1)
+	if timeout {
+		atomic.AddUint32(&c.slowWrites.timeouts, 1)
+	}
+	s.counters.incr(&s.counters.slowWrites)
+
+	if s.OnSlowConsumer == nil {
+		return
+	}
+
+	stats := c.SlowStats()
+	stats.LastWriteDuration = dur
+
+	switch s.OnSlowConsumer(c, stats) {
+	case SlowConsumerStopBroadcasts:
+		if atomic.CompareAndSwapUint32(&c.slowWrites.stopped, 0, 1) {
+			atomic.AddUint64(&s.counters.slowConsumers, 1)
+			c.logInfo("slow consumer broadcasts stopped", "slow_writes", stats.ConsecutiveSlowWrites)
+		}
+	case SlowConsumerClose:
+		c.logInfo("slow consumer closed", "slow_writes", stats.ConsecutiveSlowWrites)
+		c.Close()
+	}
+}
+
+// releaseSlowConsumer removes a closed connection from the slow consumers of the metrics.
+func (c *Conn) releaseSlowConsumer() {
+	if atomic.CompareAndSwapUint32(&c.slowWrites.stopped, 1, 0) {
+		atomic.AddUint64(&c.server.counters.slowConsumers, ^uint64(0))
+	}
+}
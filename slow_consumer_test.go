@@ -0,0 +1,154 @@
+package neffos
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// throttledSocket is a `Socket` which delays its writes, a slow consumer.
+type throttledSocket struct {
+	Socket
+	delay *int64
+}
+
+func (s *throttledSocket) throttle() {
+	if d := time.Duration(atomic.LoadInt64(s.delay)); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (s *throttledSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	s.throttle()
+	return s.Socket.WriteBinary(body, timeout)
+}
+
+func (s *throttledSocket) WriteText(body []byte, timeout time.Duration) error {
+	s.throttle()
+	return s.Socket.WriteText(body, timeout)
+}
+
+func TestSlowConsumer(t *testing.T) {
+	var (
+		delay  int64
+		action = uint32(SlowConsumerStopBroadcasts)
+		news   = make(chan struct{}, 8)
+		events = Namespaces{
+			"default": Events{
+				"news": func(c *NSConn, msg Message) error {
+					news <- struct{}{}
+					return nil
+				},
+				"ping": func(c *NSConn, msg Message) error {
+					return Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	server := New(nil, events)
+	server.SlowWriteThreshold = 20 * time.Millisecond
+	server.OnSlowConsumer = func(c *Conn, stats SlowStats) SlowConsumerAction {
+		if stats.LastWriteDuration < server.SlowWriteThreshold {
+			t.Errorf("expected a slow write but got: %s", stats.LastWriteDuration)
+		}
+		return SlowConsumerAction(atomic.LoadUint32(&action))
+	}
+	defer server.Close()
+
+	dialer := func(ctx context.Context, rawURL string) (Socket, error) {
+		serverConn, clientConn := newPipeConns(SystemClock)
+		r := newPipeRequest(rawURL)
+		socket := &throttledSocket{Socket: &pipeSocket{conn: serverConn, request: r}, delay: &delay}
+		go server.ServeSocket(&pipeResponseWriter{header: make(http.Header)}, r, socket, nil)
+		return &pipeSocket{conn: clientConn, request: newPipeRequest(rawURL)}, nil
+	}
+
+	client, err := Dial(context.TODO(), dialer, "/", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	waitFor("the server connection", func() bool { return len(server.snapshotConns()) == 1 })
+	conn := server.snapshotConns()[0]
+
+	expectNews := func(expected bool) {
+		t.Helper()
+		select {
+		case <-news:
+			if !expected {
+				t.Fatalf("expected the broadcast to be skipped")
+			}
+		case <-time.After(100 * time.Millisecond):
+			if expected {
+				t.Fatalf("expected the broadcast")
+			}
+		}
+	}
+
+	broadcast := Message{Namespace: "default", Event: "news"}
+
+	// a slow write stops the broadcasts.
+	atomic.StoreInt64(&delay, int64(30*time.Millisecond))
+	server.Broadcast(nil, broadcast)
+	expectNews(true)
+	waitFor("the stopped broadcasts", func() bool { return conn.SlowStats().BroadcastsStopped })
+
+	if stats := conn.SlowStats(); stats.ConsecutiveSlowWrites != 1 || stats.TotalSlowWrites != 1 {
+		t.Fatalf("expected one slow write but got: %#+v", stats)
+	}
+	if m := server.Metrics(); m.SlowWrites != 1 || m.SlowConsumers != 1 {
+		t.Fatalf("expected one slow write and one slow consumer but got: %#+v", m)
+	}
+	if info := newDebugConn(conn); info.SlowWrites != 1 || !info.BroadcastsStopped {
+		t.Fatalf("expected the slow consumer state on the debug info but got: %#+v", info)
+	}
+
+	atomic.StoreInt64(&delay, 0)
+	server.Broadcast(nil, broadcast)
+	expectNews(false)
+
+	// a fast write, the reply, recovers it.
+	if _, err = c.Ask(context.TODO(), "ping", []byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the recovery", func() bool { return !conn.SlowStats().BroadcastsStopped })
+
+	if stats := conn.SlowStats(); stats.ConsecutiveSlowWrites != 0 || stats.TotalSlowWrites != 1 {
+		t.Fatalf("expected the consecutive slow writes to be reset but got: %#+v", stats)
+	}
+	if m := server.Metrics(); m.SlowConsumers != 0 {
+		t.Fatalf("expected no slow consumers but got: %d", m.SlowConsumers)
+	}
+
+	server.Broadcast(nil, broadcast)
+	expectNews(true)
+
+	// a slow write closes it.
+	atomic.StoreUint32(&action, uint32(SlowConsumerClose))
+	atomic.StoreInt64(&delay, int64(30*time.Millisecond))
+	server.Broadcast(nil, broadcast)
+
+	select {
+	case <-client.NotifyClose:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the slow consumer to be closed")
+	}
+}
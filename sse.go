@@ -0,0 +1,284 @@
+package neffos
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SSENamespaceURLParam is the url parameter of the namespaces which a `Server#ServeSSE` connection is connected to,
+	// i.e "/events?namespace=chat&room=lobby".
+	SSENamespaceURLParam = "namespace"
+	// SSERoomURLParam is the url parameter of the rooms which a `Server#ServeSSE` connection joins,
+	// in each one of its namespaces, see `SSENamespaceURLParam`.
+	SSERoomURLParam = "room"
+)
+
+// SSEHeartbeatInterval is the interval of the comment lines which the `Server#ServeSSE` sends
+// to keep the idle connections alive through the proxies. Defaults to 15 seconds.
+var SSEHeartbeatInterval = 15 * time.Second
+
+// ServeSSE serves the Server-Sent Events fallback transport, for the receive-only clients
+// which can not upgrade to websocket, i.e `mux.HandleFunc("/events", server.ServeSSE)`.
+//
+// The connection is registered like the websocket ones, with the `Server#OnConnect` and `OnDisconnect`,
+// and it's connected to the namespaces of the `SSENamespaceURLParam` and joined to the rooms of the `SSERoomURLParam`
+// url parameters, so the `Server#Broadcast` and the room emits reach it as they reach the websocket clients.
+// Its namespaces and rooms can be managed through the server-side `Conn#Connect`, `NSConn#JoinRoom` and the rest too,
+// i.e inside a REST call of the client, their callbacks are fired but the client is only notified.
+//
+// Each text message is sent as a "data" event, of the neffos message format or of the JSON protocol one
+// when the request's "protocol" url parameter is "json" and the `Server#AllowJSONProtocol` is true,
+// the binary messages are sent base64-encoded as "binary" events.
+// The `Ask`s of the connection fail with the `ErrReceiveOnly`.
+func (s *Server) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, ErrReceiveOnly.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var ack []byte
+	if !(s.AllowJSONProtocol && isJSONProtocolRequest(r)) {
+		ack = append(ackBinaryB, codecName(s.Codec)...)
+	}
+
+	socket := newSSESocket(w, flusher, r, ack)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// the socket is closed on errors.
+	if c, err := s.ServeSocket(w, r, socket, nil); err == nil {
+		go s.connectSSE(c, r)
+	} else if s.OnUpgradeError != nil {
+		s.OnUpgradeError(err)
+	}
+
+	// the response must not be written after the handler returns,
+	// so block until the connection is closed.
+	ticker := time.NewTicker(SSEHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-socket.closed:
+			return
+		case <-r.Context().Done():
+			socket.NetConn().Close()
+		case <-ticker.C:
+			if err := socket.write([]byte(": heartbeat\n\n")); err != nil {
+				socket.NetConn().Close()
+			}
+		}
+	}
+}
+
+// connectSSE connects the "c" to the namespaces and rooms of the request's url parameters.
+func (s *Server) connectSSE(c *Conn, r *http.Request) {
+	query := r.URL.Query()
+	rooms := query[SSERoomURLParam]
+
+	for _, namespace := range query[SSENamespaceURLParam] {
+		ns, err := c.Connect(r.Context(), namespace)
+		if err != nil {
+			c.socket.(*sseSocket).writeError(err)
+			c.Close()
+			return
+		}
+
+		for _, room := range rooms {
+			if _, err = ns.JoinRoom(r.Context(), room); err != nil {
+				c.socket.(*sseSocket).writeError(err)
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// isReceiveOnly reports whether the connection is a `Server#ServeSSE` one.
+func (c *Conn) isReceiveOnly() bool {
+	_, ok := c.socket.(*sseSocket)
+	return ok
+}
+
+// askReceiveOnly completes the `Ask` of a receive-only connection, which can not reply.
+// The namespace connect/disconnect and room join/leave are accepted on the client's behalf
+// and the client is notified, the rest fail with the `ErrReceiveOnly`.
+func (c *Conn) askReceiveOnly(msg Message) (Message, error) {
+	switch msg.Event {
+	case OnNamespaceConnect, OnNamespaceDisconnect, OnRoomJoin, OnRoomLeave:
+		msg.wait = ""
+		if !c.Write(msg) {
+			return Message{}, ErrWrite
+		}
+
+		return Message{Namespace: msg.Namespace, Room: msg.Room, Event: msg.Event}, nil
+	default:
+		return Message{}, ErrReceiveOnly
+	}
+}
+
+// sseSocket is the write-only `Socket` of the `Server#ServeSSE` connections.
+type sseSocket struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	request *http.Request
+	netConn *sseNetConn
+
+	// ack is the acknowledgement of the client, the first `ReadData` returns it.
+	ack []byte
+
+	mu        sync.Mutex
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSSESocket(w http.ResponseWriter, flusher http.Flusher, r *http.Request, ack []byte) *sseSocket {
+	s := &sseSocket{
+		w:       w,
+		flusher: flusher,
+		request: r,
+		ack:     ack,
+		closed:  make(chan struct{}),
+	}
+	s.netConn = &sseNetConn{socket: s}
+	return s
+}
+
+func (s *sseSocket) NetConn() net.Conn {
+	return s.netConn
+}
+
+func (s *sseSocket) Request() *http.Request {
+	return s.request
+}
+
+// ReadData returns the client's acknowledgement once and then blocks until the connection is closed,
+// the "timeout" is not used, the idle connections are kept alive by the heartbeat comments.
+func (s *sseSocket) ReadData(timeout time.Duration) ([]byte, MessageType, error) {
+	s.mu.Lock()
+	ack := s.ack
+	s.ack = nil
+	s.mu.Unlock()
+
+	if ack != nil {
+		return ack, TextMessage, nil
+	}
+
+	select {
+	case <-s.closed:
+	case <-s.request.Context().Done():
+	}
+
+	return nil, 0, io.ErrUnexpectedEOF
+}
+
+var sseNewLine = []byte("\n")
+
+func (s *sseSocket) WriteText(body []byte, timeout time.Duration) error {
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(body, sseNewLine) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	return s.write(buf.Bytes())
+}
+
+func (s *sseSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	buf := make([]byte, 0, len("event: binary\ndata: \n\n")+base64.StdEncoding.EncodedLen(len(body)))
+	buf = append(buf, "event: binary\ndata: "...)
+	buf = append(buf, base64.StdEncoding.EncodeToString(body)...)
+	buf = append(buf, "\n\n"...)
+
+	return s.write(buf)
+}
+
+func (s *sseSocket) writeError(err error) error {
+	return s.write([]byte("event: error\ndata: " + strings.ReplaceAll(err.Error(), "\n", " ") + "\n\n"))
+}
+
+func (s *sseSocket) write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseSocket) close() {
+	// wait for the in-progress write, the response must not be written after the handler returns.
+	s.mu.Lock()
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	s.mu.Unlock()
+}
+
+// sseNetConn is the net connection of the `sseSocket`, it's used to close it,
+// its reads and writes are not used by neffos.
+type sseNetConn struct {
+	socket *sseSocket
+}
+
+var _ net.Conn = (*sseNetConn)(nil)
+
+func (c *sseNetConn) Read(b []byte) (int, error)  { return 0, ErrReceiveOnly }
+func (c *sseNetConn) Write(b []byte) (int, error) { return 0, ErrReceiveOnly }
+
+func (c *sseNetConn) Close() error {
+	c.socket.close()
+	return nil
+}
+
+func (c *sseNetConn) LocalAddr() net.Addr {
+	if addr, ok := c.socket.request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+
+	return sseAddr(c.socket.request.Host)
+}
+
+func (c *sseNetConn) RemoteAddr() net.Addr {
+	return sseAddr(c.socket.request.RemoteAddr)
+}
+
+func (c *sseNetConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sseNetConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sseNetConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// sseAddr is the address of the http request of a `Server#ServeSSE` connection.
+type sseAddr string
+
+func (a sseAddr) Network() string { return "tcp" }
+func (a sseAddr) String() string  { return string(a) }
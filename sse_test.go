@@ -0,0 +1,118 @@
+package neffos_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+func TestServeSSE(t *testing.T) {
+	var (
+		namespace = "default"
+		room      = "lobby"
+		conns     = make(chan *neffos.Conn, 1)
+		joined    = make(chan struct{}, 1)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnRoomJoined: func(c *neffos.NSConn, msg neffos.Message) error {
+					joined <- struct{}{}
+					return nil
+				},
+			},
+		}
+	)
+
+	heartbeat := neffos.SSEHeartbeatInterval
+	neffos.SSEHeartbeatInterval = 50 * time.Millisecond
+	defer func() { neffos.SSEHeartbeatInterval = heartbeat }()
+
+	server := neffos.New(nil, events)
+	server.OnConnect = func(c *neffos.Conn) error {
+		conns <- c
+		return nil
+	}
+	defer server.Close()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(server.ServeSSE))
+	defer httpServer.Close()
+
+	// client-to-server events are not accepted.
+	resp, err := http.Post(httpServer.URL, "text/plain", strings.NewReader("event"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, got := http.StatusMethodNotAllowed, resp.StatusCode; expected != got {
+		t.Fatalf("expected status code: %d but got: %d", expected, got)
+	}
+
+	resp, err = http.Get(httpServer.URL + "?namespace=" + namespace + "&room=" + room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if expected, got := "text/event-stream", resp.Header.Get("Content-Type"); expected != got {
+		t.Fatalf("expected content type: %s but got: %s", expected, got)
+	}
+
+	lines := make(chan string, 64)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	expectLine := func(prefix, contains string) {
+		t.Helper()
+		timeout := time.After(3 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("expected a %q line which contains %q but the stream ended", prefix, contains)
+				}
+				if strings.HasPrefix(line, prefix) && strings.Contains(line, contains) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("expected a %q line which contains %q", prefix, contains)
+			}
+		}
+	}
+
+	c := <-conns
+	// the acknowledgement, the client's ID.
+	expectLine("data: A", c.ID())
+	<-joined
+
+	// broadcasts reach the room.
+	server.Broadcast(nil, neffos.Message{Namespace: namespace, Room: room, Event: "chat", Body: []byte("hello")})
+	expectLine("data: ", "hello")
+
+	// binary messages are base64-encoded.
+	server.Broadcast(nil, neffos.Message{Namespace: namespace, Room: room, Event: "chat", Body: []byte("binary"), SetBinary: true})
+	expectLine("event: binary", "")
+
+	expectLine(": heartbeat", "")
+
+	// asks are not supported.
+	_, err = c.Namespace(namespace).Ask(context.TODO(), "chat", nil)
+	if !errors.Is(err, neffos.ErrReceiveOnly) {
+		t.Fatalf("expected the receive-only error but got: %v", err)
+	}
+
+	// a closed connection ends the stream.
+	c.Close()
+	for range lines {
+	}
+}
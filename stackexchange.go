@@ -2,6 +2,7 @@ package neffos
 
 import (
 	"context"
+	"sync"
 )
 
 // StackExchange is an optional interface
@@ -28,6 +29,8 @@ type StackExchange interface {
 	Publish(msgs []Message) bool
 	// Subscribe should subscribe to a specific namespace,
 	// it's called automatically on neffos namespace connected.
+	// It should be a no-op for an already subscribed namespace, the server re-issues
+	// the subscriptions when a `StackExchangeErrorReporter` recovers.
 	Subscribe(c *Conn, namespace string)
 	// Unsubscribe should unsubscribe from a specific namespace,
 	// it's called automatically on neffos namespace disconnect.
@@ -41,6 +44,26 @@ type StackExchange interface {
 	NotifyAsk(msg Message, token string) error
 }
 
+// OriginStackExchange is an optional interface for a `StackExchange`
+// which reports whether it publishes the `Message.SerializeExchange` envelope of the messages as it is,
+// so their origin, the `Server.InstanceID` of the publisher, reaches the receivers.
+//
+// A stackexchange is expected to carry that envelope, the `Server.Broadcast` writes the local connections directly
+// and drops the delivery of its own messages back from the stackexchange, see `Metrics.SuppressedEchoes`.
+// A stackexchange which publishes the `Message.Serialize` bytes instead should implement it and report false,
+// the local connections receive the broadcasts through the stackexchange's delivery then, like the rest of the servers.
+type OriginStackExchange interface {
+	// PreservesOrigin should report false if the published messages do not carry their origin to the receivers.
+	PreservesOrigin() bool
+}
+
+// preservesOrigin reports whether the "exc" preserves the origin of its messages,
+// that's true unless it's an `OriginStackExchange` which reports otherwise.
+func preservesOrigin(exc StackExchange) bool {
+	o, ok := exc.(OriginStackExchange)
+	return !ok || o.PreservesOrigin()
+}
+
 // StackExchangeInitializer is an optional interface for a `StackExchange`.
 // It contains a single `Init` method which accepts
 // the registered server namespaces  and returns error.
@@ -51,6 +74,173 @@ type StackExchangeInitializer interface {
 	Init(Namespaces) error
 }
 
+// StaticNamespacesStackExchange is an optional interface for a `StackExchange`
+// which provisions its broker resources, i.e a stream or a consumer per namespace,
+// for the namespaces it receives on its `Init` only.
+// The `Server.UseStackExchange` fails with the `ErrDynamicNamespacesUnsupported` when it requires them
+// and the server has dynamic namespaces (see `Namespaces#SetDynamic`),
+// the broadcasts to those namespaces would never be delivered otherwise.
+type StaticNamespacesStackExchange interface {
+	// RequiresStaticNamespaces should report whether the stackexchange receives
+	// the messages of the namespaces given on its `Init` only.
+	RequiresStaticNamespaces() bool
+}
+
+// requiresStaticNamespaces reports whether the "exc" is a `StaticNamespacesStackExchange` which requires them.
+func requiresStaticNamespaces(exc StackExchange) bool {
+	s, ok := exc.(StaticNamespacesStackExchange)
+	return ok && s.RequiresStaticNamespaces()
+}
+
+// AskableStackExchange is an optional interface for a `StackExchange`
+// which routes a `Server.Ask` of a specific connection (`Message.To`) to the server instance which owns it.
+// That instance performs the `Conn.Ask` locally and its reply is routed back.
+type AskableStackExchange interface {
+	// AskConn should publish the "msg" to the server instance of the "connID" connection
+	// and block until its reply or until the "ctx" is done, a duplicated reply should be dropped.
+	// The "ctx" deadline, if any, should be respected by the `Conn.Ask` of the owner instance too.
+	// It should return the `ErrConnNotFound` if no server instance owns the connection,
+	// when the underlying broker can tell.
+	AskConn(ctx context.Context, connID string, msg Message) (Message, error)
+}
+
+// RoomStackExchange is an optional interface for a `StackExchange`
+// which subscribes to the rooms of a namespace, per server instance,
+// instead of receiving the whole traffic of the namespace.
+// The server notifies it when the first of its connections joins a room
+// and when the last one leaves it.
+type RoomStackExchange interface {
+	// SubscribeRoom is called when the first connection of this server instance joins the "room".
+	SubscribeRoom(namespace, room string)
+	// UnsubscribeRoom is called when the last connection of this server instance leaves the "room".
+	UnsubscribeRoom(namespace, room string)
+}
+
+// PresenceStackExchange is an optional interface for a `StackExchange`
+// which keeps a registry of the connections of all the server instances in a shared store,
+// see `Server.ClusterLookup` and `Server.ClusterTotalConnections`.
+// The records of an instance should carry a TTL which is refreshed while the instance is alive,
+// so the records of a crashed instance expire.
+type PresenceStackExchange interface {
+	// PresenceJoin is called by the `Server.UseStackExchange`,
+	// it should start refreshing the records of the "instanceID" server instance.
+	// It should return the `ErrPresenceUnsupported` when the presence is disabled, the server ignores it then.
+	PresenceJoin(instanceID string) error
+	// PresenceLeave is called on `Server.Close`, it should stop refreshing and remove the records of the "instanceID".
+	PresenceLeave(instanceID string)
+	// PresenceConnected should register the "connID" connection and its "aliases" to the "instanceID".
+	PresenceConnected(instanceID, connID string, aliases []string)
+	// PresenceDisconnected should remove a connection registered by the `PresenceConnected`.
+	// A connection ID or alias may be registered more than once, it's removed when its last connection is removed.
+	PresenceDisconnected(instanceID, connID string, aliases []string)
+	// PresenceLookup should return the alive instance which holds a connection of the "name" ID or alias.
+	PresenceLookup(ctx context.Context, name string) (instanceID string, found bool, err error)
+	// PresenceTotal should return the number of the connections of all the alive instances.
+	PresenceTotal(ctx context.Context) (int, error)
+}
+
+// UnicastStackExchange is an optional interface for a `StackExchange`
+// which delivers a message to a single server instance, instead of publishing it to all of them,
+// see `Server.SendToCluster`. The server resolves the instance through its `PresenceStackExchange`.
+type UnicastStackExchange interface {
+	// SendToInstance should deliver the "msg" to the connections of the "msg.To" ID of the "instanceID" server instance
+	// and block until that instance confirms the delivery or until the "ctx" is done.
+	// It should return the `ErrConnNotFound` if the instance does not hold a connection of that ID
+	// and a non-nil error if the instance does not confirm the delivery, i.e it has crashed.
+	SendToInstance(ctx context.Context, instanceID string, msg Message) error
+}
+
+// ClosableStackExchange is an optional interface for a `StackExchange`
+// which is closed by the `Server.Shutdown`, after the server's connections are unsubscribed from it.
+type ClosableStackExchange interface {
+	// Close should stop the deliveries to the server's connections, none should be delivered after it returns,
+	// publish its pending messages and release its resources, i.e its connections to the broker.
+	Close() error
+}
+
+// PingableStackExchange is an optional interface for a `StackExchange`
+// which checks the connectivity to its broker on demand, see `Server.Health`.
+type PingableStackExchange interface {
+	// Ping should send a round trip to the broker, i.e its native ping command,
+	// and return a non-nil error if it fails or if the "ctx" is done before its reply.
+	Ping(ctx context.Context) error
+}
+
+// StackExchangePoolReporter is an optional interface for a `StackExchange`
+// which keeps a pool of connections to its broker. Its statistics are exposed through
+// the `Metrics.StackExchange` when it's wrapped by the `InstrumentStackExchange`.
+type StackExchangePoolReporter interface {
+	// PoolStats should return a snapshot of the statistics of the connection pool.
+	PoolStats() StackExchangePoolStats
+}
+
+// StackExchangeErrorReporter is an optional interface for a `StackExchange`
+// which reports its asynchronous errors, i.e a lost connection to its broker, and its recoveries.
+// The `Server.UseStackExchange` registers the `Server.OnStackExchangeError` through its `SetErrorHandler`
+// before its `Init`. The server is not healthy while one of its stackexchanges has failed,
+// see `Server.StackExchangeHealthy`, and it re-issues the subscriptions of its connections
+// to a stackexchange when it recovers.
+//
+// See `StackExchangeHealth` too.
+type StackExchangeErrorReporter interface {
+	// SetErrorHandler should register the "handler" of the stackexchange errors.
+	// The "handler" should be called with a false "recovered" on a failure
+	// and with a true "recovered" and the last failure when the stackexchange is functional again.
+	SetErrorHandler(handler func(err error, recovered bool))
+}
+
+// StackExchangeHealth can be used by a `StackExchange` implementation
+// to complete the `StackExchangeErrorReporter` interface.
+// Its `Fail` reports a failure and its `Recover` reports a recovery from the last one, if any.
+// The zero value is ready to use.
+type StackExchangeHealth struct {
+	mu      sync.Mutex
+	handler func(err error, recovered bool)
+	lastErr error
+}
+
+// SetErrorHandler registers the "handler" of the failures and the recoveries.
+func (h *StackExchangeHealth) SetErrorHandler(handler func(err error, recovered bool)) {
+	h.mu.Lock()
+	h.handler = handler
+	h.mu.Unlock()
+}
+
+// Fail reports the "err" failure.
+func (h *StackExchangeHealth) Fail(err error) {
+	h.mu.Lock()
+	h.lastErr = err
+	handler := h.handler
+	h.mu.Unlock()
+
+	if handler != nil {
+		handler(err, false)
+	}
+}
+
+// Recover reports a recovery from the last failure,
+// it's a no-op if there is no failure since the last recovery.
+// It is meant to be called on each successful operation.
+func (h *StackExchangeHealth) Recover() {
+	h.mu.Lock()
+	err := h.lastErr
+	h.lastErr = nil
+	handler := h.handler
+	h.mu.Unlock()
+
+	if err != nil && handler != nil {
+		handler(err, true)
+	}
+}
+
+// Healthy reports whether there is no failure since the last recovery.
+func (h *StackExchangeHealth) Healthy() bool {
+	h.mu.Lock()
+	healthy := h.lastErr == nil
+	h.mu.Unlock()
+	return healthy
+}
+
 func stackExchangeInit(s StackExchange, namespaces Namespaces) error {
 	if s != nil {
 		if sinit, ok := s.(StackExchangeInitializer); ok {
@@ -99,6 +289,14 @@ func (s *stackExchangeWrapper) Publish(msgs []Message) bool {
 	return okParent && okCurrent
 }
 
+func (s *stackExchangeWrapper) PreservesOrigin() bool {
+	return preservesOrigin(s.parent) && preservesOrigin(s.current)
+}
+
+func (s *stackExchangeWrapper) RequiresStaticNamespaces() bool {
+	return requiresStaticNamespaces(s.parent) || requiresStaticNamespaces(s.current)
+}
+
 func (s *stackExchangeWrapper) Ask(ctx context.Context, msg Message, token string) (Message, error) {
 	// we run Ask and if one is failing then we keep trying for all stackexchanges.
 	msg, err := s.parent.Ask(ctx, msg, token)
@@ -109,6 +307,32 @@ func (s *stackExchangeWrapper) Ask(ctx context.Context, msg Message, token strin
 	return msg, err
 }
 
+func (s *stackExchangeWrapper) AskConn(ctx context.Context, connID string, msg Message) (Message, error) {
+	var askables []AskableStackExchange
+	for _, exc := range []StackExchange{s.parent, s.current} {
+		if askable, ok := exc.(AskableStackExchange); ok {
+			askables = append(askables, askable)
+		}
+	}
+
+	if len(askables) == 0 {
+		msg.To = connID
+		token := genWait(false)
+		msg.wait = genWaitStackExchange(token)
+		return s.Ask(ctx, msg, token)
+	}
+
+	// ask the next one only if the connection is not found.
+	for _, askable := range askables {
+		response, err := askable.AskConn(ctx, connID, msg)
+		if err != ErrConnNotFound {
+			return response, err
+		}
+	}
+
+	return Message{}, ErrConnNotFound
+}
+
 func (s *stackExchangeWrapper) NotifyAsk(msg Message, token string) error {
 	err := s.parent.NotifyAsk(msg, token)
 	if err != nil {
@@ -127,3 +351,44 @@ func (s *stackExchangeWrapper) Unsubscribe(c *Conn, namespace string) {
 	s.parent.Unsubscribe(c, namespace)
 	s.current.Unsubscribe(c, namespace)
 }
+
+func (s *stackExchangeWrapper) Close() error {
+	var err error
+	for _, exc := range []StackExchange{s.parent, s.current} {
+		if closer, ok := exc.(ClosableStackExchange); ok {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+
+	return err
+}
+
+func (s *stackExchangeWrapper) Ping(ctx context.Context) error {
+	for _, exc := range []StackExchange{s.parent, s.current} {
+		if pinger, ok := exc.(PingableStackExchange); ok {
+			if err := pinger.Ping(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *stackExchangeWrapper) SubscribeRoom(namespace, room string) {
+	for _, exc := range []StackExchange{s.parent, s.current} {
+		if roomExc, ok := exc.(RoomStackExchange); ok {
+			roomExc.SubscribeRoom(namespace, room)
+		}
+	}
+}
+
+func (s *stackExchangeWrapper) UnsubscribeRoom(namespace, room string) {
+	for _, exc := range []StackExchange{s.parent, s.current} {
+		if roomExc, ok := exc.(RoomStackExchange); ok {
+			roomExc.UnsubscribeRoom(namespace, room)
+		}
+	}
+}
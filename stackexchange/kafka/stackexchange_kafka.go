@@ -0,0 +1,477 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	uuid "github.com/iris-contrib/go.uuid"
+	"github.com/segmentio/kafka-go"
+)
+
+// Config is used on the `NewStackExchange` package-level function.
+type Config struct {
+	// Brokers is the list of the kafka broker addresses.
+	// Defaults to "127.0.0.1:9092".
+	Brokers []string
+	// Topic is the topic of the messages, or the prefix of the topics if "TopicPerNamespace" is true.
+	// If you use the same kafka cluster for multiple neffos apps,
+	// set this to different values across your apps.
+	// Defaults to "neffos".
+	Topic string
+	// TopicPerNamespace publishes each namespace to its own topic, i.e "<Topic>.<namespace>".
+	// The topics are read from the declared namespaces on `Init`,
+	// so it can't be used with the dynamic namespaces of the `neffos.Namespaces#SetDynamic`.
+	// Defaults to false, all namespaces share the same topic.
+	TopicPerNamespace bool
+	// GroupID is the consumer group of this server instance, the partitions
+	// are assigned to its members by the client library.
+	// All the server instances should receive all the messages,
+	// so each one must have its own group.
+	// Defaults to "<Topic>-<instance ID>".
+	GroupID string
+	// QueueSize is the maximum number of the messages which wait to be written to the brokers.
+	// A `Publish` blocks while the queue is full, for "PublishTimeout" at most.
+	// Defaults to 1024.
+	QueueSize int
+	// PublishTimeout is the maximum duration that a `Publish` waits for a slow broker.
+	// On timeout the messages are dropped, the `Publish` returns false and the `ErrPublishQueueFull`
+	// is reported to the `Server.OnStackExchangeError`.
+	// Defaults to 5 seconds.
+	PublishTimeout time.Duration
+	// BatchSize is the maximum number of the messages of a single write.
+	// Defaults to 100.
+	BatchSize int
+}
+
+// ErrPublishQueueFull is reported to the `Server.OnStackExchangeError`
+// when the messages are dropped because the brokers are slow, see `Config.PublishTimeout`.
+var ErrPublishQueueFull = errors.New("kafka: publish queue is full")
+
+// the record headers.
+const (
+	// the ID of the publisher instance, an instance skips its own messages.
+	headerInstance = "neffos-instance"
+	// the wait token of a `NotifyAsk` record.
+	headerAsk = "neffos-ask"
+)
+
+// StackExchange is a `neffos.StackExchange` for kafka.
+//
+// Each server instance writes its messages to the topic and reads the messages of the other instances,
+// its own are delivered to its local connections on `Publish`, without a round trip.
+// Kafka subscriptions are topic-level, so the `Subscribe` and `Unsubscribe`
+// just filter the messages which are delivered to the local connections.
+//
+// The `Publish` queues the messages and a background writer sends them in batches,
+// a write failure is reported to the `Server.OnStackExchangeError`.
+// Use the `Server.UseStackExchange` to register it.
+type StackExchange struct {
+	cfg Config
+	id  string
+
+	writer *kafka.Writer
+	reader *kafka.Reader
+	queue  chan kafka.Message
+
+	health neffos.StackExchangeHealth
+
+	mu    sync.RWMutex
+	conns map[*neffos.Conn]map[string]struct{}
+
+	asks   map[string]chan neffos.Message
+	asksMu sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var (
+	_ neffos.StackExchange                 = (*StackExchange)(nil)
+	_ neffos.OriginStackExchange           = (*StackExchange)(nil)
+	_ neffos.StackExchangeInitializer      = (*StackExchange)(nil)
+	_ neffos.StackExchangeErrorReporter    = (*StackExchange)(nil)
+	_ neffos.ClosableStackExchange         = (*StackExchange)(nil)
+	_ neffos.StaticNamespacesStackExchange = (*StackExchange)(nil)
+)
+
+// NewStackExchange returns a new kafka StackExchange.
+// It starts reading and writing on `Init`.
+func NewStackExchange(cfg Config) (*StackExchange, error) {
+	if len(cfg.Brokers) == 0 {
+		cfg.Brokers = []string{"127.0.0.1:9092"}
+	}
+
+	if cfg.Topic == "" {
+		cfg.Topic = "neffos"
+	}
+
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1024
+	}
+
+	if cfg.PublishTimeout <= 0 {
+		cfg.PublishTimeout = 5 * time.Second
+	}
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.GroupID == "" {
+		cfg.GroupID = cfg.Topic + "-" + id.String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	exc := &StackExchange{
+		cfg: cfg,
+		id:  id.String(),
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Brokers...),
+			Balancer:               &kafka.Hash{},
+			BatchSize:              cfg.BatchSize,
+			BatchTimeout:           10 * time.Millisecond,
+			AllowAutoTopicCreation: true,
+		},
+		queue:  make(chan kafka.Message, cfg.QueueSize),
+		conns:  make(map[*neffos.Conn]map[string]struct{}),
+		asks:   make(map[string]chan neffos.Message),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	return exc, nil
+}
+
+func (exc *StackExchange) getTopic(namespace string) string {
+	if !exc.cfg.TopicPerNamespace {
+		return exc.cfg.Topic
+	}
+
+	if namespace == "" {
+		namespace = "_"
+	}
+
+	// the valid characters of a topic are [a-zA-Z0-9._-].
+	return exc.cfg.Topic + "." + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, namespace)
+}
+
+// SetErrorHandler registers the handler of the publish and read errors and their recoveries,
+// it's called automatically by the `Server.UseStackExchange`.
+func (exc *StackExchange) SetErrorHandler(handler func(err error, recovered bool)) {
+	exc.health.SetErrorHandler(handler)
+}
+
+func (exc *StackExchange) fireError(err error) {
+	exc.health.Fail(err)
+}
+
+// Init starts the reader of the "namespaces" topics and the background writer.
+// It's called automatically by the `Server.UseStackExchange`.
+func (exc *StackExchange) Init(namespaces neffos.Namespaces) error {
+	var topics []string
+	if exc.cfg.TopicPerNamespace {
+		for namespace := range namespaces {
+			topics = append(topics, exc.getTopic(namespace))
+		}
+	} else {
+		topics = []string{exc.cfg.Topic}
+	}
+
+	exc.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     exc.cfg.Brokers,
+		GroupID:     exc.cfg.GroupID,
+		GroupTopics: topics,
+		StartOffset: kafka.LastOffset,
+	})
+
+	go exc.read()
+	go exc.write()
+
+	return nil
+}
+
+func (exc *StackExchange) write() {
+	batch := make([]kafka.Message, 0, exc.cfg.BatchSize)
+
+	for {
+		select {
+		case <-exc.ctx.Done():
+			return
+		case m := <-exc.queue:
+			batch = append(batch[:0], m)
+		}
+
+		// take the rest of the queued messages, if any.
+	fill:
+		for len(batch) < exc.cfg.BatchSize {
+			select {
+			case m := <-exc.queue:
+				batch = append(batch, m)
+			default:
+				break fill
+			}
+		}
+
+		if err := exc.writer.WriteMessages(exc.ctx, batch...); err != nil {
+			if exc.ctx.Err() == nil {
+				exc.fireError(err)
+			}
+			continue
+		}
+
+		exc.health.Recover()
+	}
+}
+
+func (exc *StackExchange) read() {
+	for {
+		m, err := exc.reader.FetchMessage(exc.ctx)
+		if err != nil {
+			if exc.ctx.Err() != nil {
+				return
+			}
+
+			exc.fireError(err)
+			continue
+		}
+
+		exc.health.Recover()
+		exc.handleMessage(m)
+
+		if err = exc.reader.CommitMessages(exc.ctx, m); err != nil && exc.ctx.Err() == nil {
+			exc.fireError(err)
+		}
+	}
+}
+
+func getHeader(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
+
+func (exc *StackExchange) handleMessage(m kafka.Message) {
+	if token := getHeader(m, headerAsk); token != "" {
+		exc.asksMu.Lock()
+		ch, ok := exc.asks[token]
+		exc.asksMu.Unlock()
+
+		if ok {
+			select {
+			case ch <- neffos.DeserializeExchangeMessage(m.Value):
+			default:
+			}
+		}
+
+		return
+	}
+
+	if getHeader(m, headerInstance) == exc.id {
+		// delivered on publish.
+		return
+	}
+
+	exc.deliver(m.Value)
+}
+
+// deliver writes the exchange envelope "b" to the local connections.
+func (exc *StackExchange) deliver(b []byte) {
+	msg := neffos.DeserializeExchangeMessage(b)
+
+	var receivers []*neffos.Conn
+	exc.mu.RLock()
+	for c, namespaces := range exc.conns {
+		if msg.To != "" {
+			if c.ID() == msg.To {
+				receivers = append(receivers, c)
+			}
+			continue
+		}
+
+		if _, ok := namespaces[msg.Namespace]; ok {
+			receivers = append(receivers, c)
+		}
+	}
+	exc.mu.RUnlock()
+
+	for _, c := range receivers {
+		c.Write(c.DeserializeExchangeMessage(b))
+	}
+}
+
+// OnConnect registers the connection for its direct messages.
+// It's called automatically after the neffos server's OnConnect (if any)
+// on incoming client connections.
+func (exc *StackExchange) OnConnect(c *neffos.Conn) error {
+	exc.mu.Lock()
+	exc.conns[c] = make(map[string]struct{})
+	exc.mu.Unlock()
+	return nil
+}
+
+// PreservesOrigin implements the `neffos.OriginStackExchange`,
+// the envelopes of the messages are the values of the records.
+func (exc *StackExchange) PreservesOrigin() bool {
+	return true
+}
+
+// RequiresStaticNamespaces implements the `neffos.StaticNamespacesStackExchange`,
+// the topics of the "TopicPerNamespace" are read from the declared namespaces only.
+func (exc *StackExchange) RequiresStaticNamespaces() bool {
+	return exc.cfg.TopicPerNamespace
+}
+
+// Publish delivers the messages to the local connections and queues them for the other instances.
+// It's called automatically on neffos broadcasting.
+func (exc *StackExchange) Publish(msgs []neffos.Message) bool {
+	for _, msg := range msgs {
+		b := msg.SerializeExchange()
+		exc.deliver(b)
+
+		if !exc.enqueue(exc.newRecord(msg, b)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (exc *StackExchange) newRecord(msg neffos.Message, b []byte) kafka.Message {
+	return kafka.Message{
+		Topic: exc.getTopic(msg.Namespace),
+		// keep the order of a namespace's messages.
+		Key:     []byte(msg.Namespace),
+		Value:   b,
+		Headers: []kafka.Header{{Key: headerInstance, Value: []byte(exc.id)}},
+	}
+}
+
+// enqueue blocks while the queue is full, for `Config.PublishTimeout` at most.
+func (exc *StackExchange) enqueue(m kafka.Message) bool {
+	select {
+	case exc.queue <- m:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(exc.cfg.PublishTimeout)
+	defer timer.Stop()
+
+	select {
+	case exc.queue <- m:
+		return true
+	case <-timer.C:
+		exc.fireError(ErrPublishQueueFull)
+		return false
+	}
+}
+
+// Ask implements the server Ask feature for kafka. It blocks until response.
+func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (neffos.Message, error) {
+	ch := make(chan neffos.Message, 1)
+	exc.asksMu.Lock()
+	exc.asks[token] = ch
+	exc.asksMu.Unlock()
+
+	defer func() {
+		exc.asksMu.Lock()
+		delete(exc.asks, token)
+		exc.asksMu.Unlock()
+	}()
+
+	if !exc.Publish([]neffos.Message{msg}) {
+		return neffos.Message{}, neffos.ErrWrite
+	}
+
+	select {
+	case <-ctx.Done():
+		return neffos.Message{}, ctx.Err()
+	case response := <-ch:
+		return response, response.Err
+	}
+}
+
+// NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
+func (exc *StackExchange) NotifyAsk(msg neffos.Message, token string) error {
+	msg.ClearWait()
+	b := msg.SerializeExchange()
+
+	// the asker may be this instance.
+	exc.handleMessage(kafka.Message{Value: b, Headers: []kafka.Header{{Key: headerAsk, Value: []byte(token)}}})
+
+	record := exc.newRecord(msg, b)
+	record.Headers = append(record.Headers, kafka.Header{Key: headerAsk, Value: []byte(token)})
+	if !exc.enqueue(record) {
+		return ErrPublishQueueFull
+	}
+
+	return nil
+}
+
+// Subscribe subscribes the connection to a specific namespace,
+// it's called automatically on neffos namespace connected.
+func (exc *StackExchange) Subscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		namespaces[namespace] = struct{}{}
+	}
+	exc.mu.Unlock()
+}
+
+// Unsubscribe unsubscribes the connection from a specific namespace,
+// it's called automatically on neffos namespace disconnect.
+func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		delete(namespaces, namespace)
+	}
+	exc.mu.Unlock()
+}
+
+// OnDisconnect removes the connection which registered on the `OnConnect` method.
+// It's called automatically when a connection goes offline,
+// manually by server or client or by network failure.
+func (exc *StackExchange) OnDisconnect(c *neffos.Conn) {
+	exc.mu.Lock()
+	delete(exc.conns, c)
+	exc.mu.Unlock()
+}
+
+// Close stops the reader and the writer, the queued messages are dropped.
+// It's called automatically by the `neffos.Server.Shutdown`.
+func (exc *StackExchange) Close() error {
+	exc.cancel()
+
+	var err error
+	if exc.reader != nil {
+		err = exc.reader.Close()
+	}
+
+	if wErr := exc.writer.Close(); err == nil {
+		err = wErr
+	}
+
+	return err
+}
@@ -0,0 +1,75 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+func TestStackExchangeTopics(t *testing.T) {
+	exc, err := NewStackExchange(Config{Topic: "app", TopicPerNamespace: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"default":    "app.default",
+		"":           "app._",
+		"chat/rooms": "app.chat_rooms",
+	}
+
+	for namespace, expected := range tests {
+		if got := exc.getTopic(namespace); expected != got {
+			t.Fatalf("[%s] expected topic: %s but got: %s", namespace, expected, got)
+		}
+	}
+
+	exc.cfg.TopicPerNamespace = false
+	if expected, got := "app", exc.getTopic("default"); expected != got {
+		t.Fatalf("expected the shared topic: %s but got: %s", expected, got)
+	}
+
+	// the topics per namespace are read from the declared namespaces only.
+	if exc.RequiresStaticNamespaces() {
+		t.Fatalf("expected the shared topic to accept the dynamic namespaces")
+	}
+	exc.cfg.TopicPerNamespace = true
+	if !exc.RequiresStaticNamespaces() {
+		t.Fatalf("expected the topics per namespace to require static namespaces")
+	}
+}
+
+func TestStackExchangePublishBackpressure(t *testing.T) {
+	// not initialized, nothing is written to the brokers.
+	exc, err := NewStackExchange(Config{QueueSize: 1, PublishTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reported error
+	exc.SetErrorHandler(func(err error, recovered bool) { reported = err })
+
+	msg := neffos.Message{Namespace: "default", Event: "chat", Body: []byte("data")}
+	if !exc.Publish([]neffos.Message{msg}) {
+		t.Fatalf("expected the first message to be queued")
+	}
+
+	start := time.Now()
+	if exc.Publish([]neffos.Message{msg}) {
+		t.Fatalf("expected the second message to be dropped")
+	}
+
+	if elapsed := time.Since(start); elapsed < exc.cfg.PublishTimeout {
+		t.Fatalf("expected publish to wait for the queue for %s but it returned after %s", exc.cfg.PublishTimeout, elapsed)
+	}
+
+	if reported != ErrPublishQueueFull {
+		t.Fatalf("expected the reported error: %v but got: %v", ErrPublishQueueFull, reported)
+	}
+
+	record := <-exc.queue
+	if expected, got := exc.id, getHeader(record, headerInstance); expected != got {
+		t.Fatalf("expected instance header: %s but got: %s", expected, got)
+	}
+}
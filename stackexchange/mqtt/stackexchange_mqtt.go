@@ -0,0 +1,454 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	uuid "github.com/iris-contrib/go.uuid"
+)
+
+// Config is used on the `NewStackExchange` package-level function.
+type Config struct {
+	// Brokers is the list of the broker URLs, i.e "tcp://127.0.0.1:1883", "ssl://broker:8883".
+	// Defaults to "tcp://127.0.0.1:1883".
+	Brokers []string
+	// TopicPrefix is the first level of the topics, the messages are published to
+	// "<TopicPrefix>/<namespace>" and "<TopicPrefix>/<namespace>/<room>".
+	// If you use the same broker for multiple neffos apps,
+	// set this to different values across your apps.
+	// Defaults to "neffos".
+	TopicPrefix string
+	// QoS is the quality of service of the publishes and the subscriptions, 0 or 1.
+	// Defaults to 0, at most once.
+	QoS byte
+	// ClientID is the MQTT client identifier of this server instance, it must be unique per broker.
+	// Defaults to "<TopicPrefix>-<random uuid>".
+	ClientID string
+	// Username and Password are the optional credentials of the broker.
+	Username string
+	Password string
+	// Timeout is the maximum duration of the connect and of the `Publish` acknowledgements.
+	// Defaults to 5 seconds.
+	Timeout time.Duration
+	// MaxReconnectInterval is the maximum backoff between the reconnect attempts.
+	// Defaults to 10 seconds.
+	MaxReconnectInterval time.Duration
+}
+
+// ErrTimeout is reported to the `Server.OnStackExchangeError` when the broker
+// did not acknowledge a publish or a subscription in time, see `Config.Timeout`.
+var ErrTimeout = errors.New("mqtt: timeout")
+
+// StackExchange is a `neffos.StackExchange` for MQTT brokers, i.e EMQX or Mosquitto.
+//
+// Each server instance keeps a single client, it subscribes to the "<TopicPrefix>/<namespace>/#" topics
+// of the namespaces its connections are connected to and delivers the messages to them.
+// A server writes its own broadcasts to its connections directly,
+// their copy from the broker is dropped by the neffos server, see `neffos.Server.InstanceID`.
+//
+// The messages are never retained, a retained message of another publisher is ignored,
+// so a freshly started instance does not replay stale broadcasts.
+// On a lost connection the client reconnects and subscribes again, the failure and the recovery
+// are reported to the `Server.OnStackExchangeError`.
+// Use the `Server.UseStackExchange` to register it.
+type StackExchange struct {
+	cfg    Config
+	client mqtt.Client
+
+	health neffos.StackExchangeHealth
+
+	mu    sync.RWMutex
+	conns map[*neffos.Conn]map[string]struct{}
+	// the number of the local connections of each subscribed namespace.
+	namespaces map[string]int
+
+	// by their escaped tokens, the last level of their topics.
+	asks   map[string]chan neffos.Message
+	asksMu sync.Mutex
+}
+
+var (
+	_ neffos.StackExchange              = (*StackExchange)(nil)
+	_ neffos.OriginStackExchange        = (*StackExchange)(nil)
+	_ neffos.StackExchangeErrorReporter = (*StackExchange)(nil)
+	_ neffos.ClosableStackExchange      = (*StackExchange)(nil)
+)
+
+// NewStackExchange returns a new MQTT StackExchange,
+// it returns an error if the first connect to the brokers failed.
+func NewStackExchange(cfg Config) (*StackExchange, error) {
+	if len(cfg.Brokers) == 0 {
+		cfg.Brokers = []string{"tcp://127.0.0.1:1883"}
+	}
+
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = "neffos"
+	}
+
+	if cfg.QoS > 1 {
+		return nil, errors.New("mqtt: QoS should be 0 or 1")
+	}
+
+	if cfg.ClientID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientID = cfg.TopicPrefix + "-" + id.String()
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	if cfg.MaxReconnectInterval <= 0 {
+		cfg.MaxReconnectInterval = 10 * time.Second
+	}
+
+	exc := &StackExchange{
+		cfg:        cfg,
+		conns:      make(map[*neffos.Conn]map[string]struct{}),
+		namespaces: make(map[string]int),
+		asks:       make(map[string]chan neffos.Message),
+	}
+
+	opts := mqtt.NewClientOptions()
+	for _, broker := range cfg.Brokers {
+		opts.AddBroker(broker)
+	}
+	opts.SetClientID(cfg.ClientID)
+	opts.SetUsername(cfg.Username)
+	opts.SetPassword(cfg.Password)
+	opts.SetConnectTimeout(cfg.Timeout)
+	opts.SetWriteTimeout(cfg.Timeout)
+	opts.SetMaxReconnectInterval(cfg.MaxReconnectInterval)
+	opts.SetAutoReconnect(true)
+	// the subscriptions are issued again on each connect.
+	opts.SetCleanSession(true)
+	opts.SetDefaultPublishHandler(exc.handle)
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		exc.health.Fail(err)
+	})
+	opts.SetOnConnectHandler(func(mqtt.Client) {
+		go exc.resubscribe()
+	})
+
+	exc.client = mqtt.NewClient(opts)
+	if err := exc.wait(exc.client.Connect()); err != nil {
+		return nil, err
+	}
+
+	return exc, nil
+}
+
+// escapeTopicLevel escapes the MQTT separator and wildcards of a topic level,
+// the escaped levels never start with "$", it is reserved for the ask replies.
+func escapeTopicLevel(s string) string {
+	if s == "" {
+		return "%"
+	}
+
+	if !strings.ContainsAny(s, "/+#$%\x00") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '/', '+', '#', '$', '%', 0:
+			b.WriteByte('%')
+			b.WriteByte("0123456789ABCDEF"[c>>4])
+			b.WriteByte("0123456789ABCDEF"[c&15])
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// getTopic returns the topic of a namespace or of a namespace's room.
+func (exc *StackExchange) getTopic(namespace, room string) string {
+	topic := exc.cfg.TopicPrefix + "/" + escapeTopicLevel(namespace)
+	if room != "" {
+		topic += "/" + escapeTopicLevel(room)
+	}
+
+	return topic
+}
+
+// getAskTopicPrefix returns the prefix of the topics of the `Ask` replies,
+// they are followed by their escaped token.
+func (exc *StackExchange) getAskTopicPrefix() string {
+	return exc.cfg.TopicPrefix + "/$ask/"
+}
+
+// wait waits for the "token" completion, for `Config.Timeout` at most.
+func (exc *StackExchange) wait(token mqtt.Token) error {
+	if !token.WaitTimeout(exc.cfg.Timeout) {
+		return ErrTimeout
+	}
+
+	return token.Error()
+}
+
+// SetErrorHandler registers the handler of the connection and publish errors and their recoveries,
+// it's called automatically by the `Server.UseStackExchange`.
+func (exc *StackExchange) SetErrorHandler(handler func(err error, recovered bool)) {
+	exc.health.SetErrorHandler(handler)
+}
+
+// resubscribe subscribes again to the topics of the subscribed namespaces and of the pending asks,
+// the broker drops them on a new session.
+func (exc *StackExchange) resubscribe() {
+	filters := make(map[string]byte)
+	exc.mu.RLock()
+	for namespace := range exc.namespaces {
+		filters[exc.getTopic(namespace, "")+"/#"] = exc.cfg.QoS
+	}
+	exc.mu.RUnlock()
+
+	exc.asksMu.Lock()
+	for token := range exc.asks {
+		filters[exc.getAskTopicPrefix()+token] = exc.cfg.QoS
+	}
+	exc.asksMu.Unlock()
+
+	if len(filters) > 0 {
+		if err := exc.wait(exc.client.SubscribeMultiple(filters, nil)); err != nil {
+			exc.health.Fail(err)
+			return
+		}
+	}
+
+	exc.health.Recover()
+}
+
+func (exc *StackExchange) subscribe(topic string) {
+	if err := exc.wait(exc.client.Subscribe(topic, exc.cfg.QoS, nil)); err != nil {
+		exc.health.Fail(err)
+	}
+}
+
+func (exc *StackExchange) unsubscribe(topic string) {
+	if err := exc.wait(exc.client.Unsubscribe(topic)); err != nil {
+		exc.health.Fail(err)
+	}
+}
+
+// handle receives the messages of all the subscriptions.
+func (exc *StackExchange) handle(_ mqtt.Client, m mqtt.Message) {
+	if m.Retained() {
+		// published before this instance subscribed.
+		return
+	}
+
+	if askPrefix := exc.getAskTopicPrefix(); strings.HasPrefix(m.Topic(), askPrefix) {
+		exc.asksMu.Lock()
+		ch, ok := exc.asks[strings.TrimPrefix(m.Topic(), askPrefix)]
+		exc.asksMu.Unlock()
+
+		if ok {
+			select {
+			case ch <- neffos.DeserializeExchangeMessage(m.Payload()):
+			default:
+			}
+		}
+
+		return
+	}
+
+	exc.deliver(m.Payload())
+}
+
+// deliver writes the exchange envelope "b" to the local connections.
+func (exc *StackExchange) deliver(b []byte) {
+	msg := neffos.DeserializeExchangeMessage(b)
+
+	var receivers []*neffos.Conn
+	exc.mu.RLock()
+	for c, namespaces := range exc.conns {
+		if msg.To != "" && c.ID() != msg.To {
+			continue
+		}
+
+		if _, ok := namespaces[msg.Namespace]; ok {
+			receivers = append(receivers, c)
+		}
+	}
+	exc.mu.RUnlock()
+
+	for _, c := range receivers {
+		c.Write(c.DeserializeExchangeMessage(b))
+	}
+}
+
+// OnConnect registers the connection.
+// It's called automatically after the neffos server's OnConnect (if any)
+// on incoming client connections.
+func (exc *StackExchange) OnConnect(c *neffos.Conn) error {
+	exc.mu.Lock()
+	exc.conns[c] = make(map[string]struct{})
+	exc.mu.Unlock()
+	return nil
+}
+
+// PreservesOrigin implements the `neffos.OriginStackExchange`,
+// the envelopes of the messages are the payloads of the mqtt messages.
+func (exc *StackExchange) PreservesOrigin() bool {
+	return true
+}
+
+// Publish publishes the messages to their namespace or room topics
+// and waits for their acknowledgements when the `Config.QoS` is 1.
+// It's called automatically on neffos broadcasting.
+func (exc *StackExchange) Publish(msgs []neffos.Message) bool {
+	tokens := make([]mqtt.Token, 0, len(msgs))
+	for _, msg := range msgs {
+		// never retained, see `handle`.
+		tokens = append(tokens, exc.client.Publish(exc.getTopic(msg.Namespace, msg.Room), exc.cfg.QoS, false, msg.SerializeExchange()))
+	}
+
+	for _, token := range tokens {
+		if err := exc.wait(token); err != nil {
+			exc.health.Fail(err)
+			return false
+		}
+	}
+
+	exc.health.Recover()
+	return true
+}
+
+// Ask implements the server Ask feature for MQTT. It blocks until response.
+func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (neffos.Message, error) {
+	token = escapeTopicLevel(token)
+	ch := make(chan neffos.Message, 1)
+	exc.asksMu.Lock()
+	exc.asks[token] = ch
+	exc.asksMu.Unlock()
+
+	topic := exc.getAskTopicPrefix() + token
+	defer func() {
+		exc.asksMu.Lock()
+		delete(exc.asks, token)
+		exc.asksMu.Unlock()
+		exc.client.Unsubscribe(topic)
+	}()
+
+	if err := exc.wait(exc.client.Subscribe(topic, exc.cfg.QoS, nil)); err != nil {
+		return neffos.Message{}, err
+	}
+
+	if !exc.Publish([]neffos.Message{msg}) {
+		return neffos.Message{}, neffos.ErrWrite
+	}
+
+	select {
+	case <-ctx.Done():
+		return neffos.Message{}, ctx.Err()
+	case response := <-ch:
+		return response, response.Err
+	}
+}
+
+// NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
+func (exc *StackExchange) NotifyAsk(msg neffos.Message, token string) error {
+	msg.ClearWait()
+	return exc.wait(exc.client.Publish(exc.getAskTopicPrefix()+escapeTopicLevel(token), exc.cfg.QoS, false, msg.SerializeExchange()))
+}
+
+// Subscribe subscribes the connection to a specific namespace,
+// the namespace's topics are subscribed when its first local connection is connected.
+// It's called automatically on neffos namespace connected.
+func (exc *StackExchange) Subscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	namespaces, ok := exc.conns[c]
+	if !ok {
+		exc.mu.Unlock()
+		return
+	}
+
+	if _, subscribed := namespaces[namespace]; subscribed {
+		exc.mu.Unlock()
+		return
+	}
+
+	namespaces[namespace] = struct{}{}
+	exc.namespaces[namespace]++
+	first := exc.namespaces[namespace] == 1
+	exc.mu.Unlock()
+
+	if first {
+		// matches the namespace's topic and the topics of its rooms.
+		exc.subscribe(exc.getTopic(namespace, "") + "/#")
+	}
+}
+
+// Unsubscribe unsubscribes the connection from a specific namespace,
+// the namespace's topics are unsubscribed when its last local connection is disconnected.
+// It's called automatically on neffos namespace disconnect.
+func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	namespaces, ok := exc.conns[c]
+	if !ok {
+		exc.mu.Unlock()
+		return
+	}
+
+	last := exc.removeLocked(namespaces, namespace)
+	exc.mu.Unlock()
+
+	if last {
+		exc.unsubscribe(exc.getTopic(namespace, "") + "/#")
+	}
+}
+
+// removeLocked removes the "namespace" of a connection's "namespaces"
+// and reports whether it was the last local connection of the namespace.
+func (exc *StackExchange) removeLocked(namespaces map[string]struct{}, namespace string) bool {
+	if _, ok := namespaces[namespace]; !ok {
+		return false
+	}
+
+	delete(namespaces, namespace)
+	if exc.namespaces[namespace]--; exc.namespaces[namespace] > 0 {
+		return false
+	}
+
+	delete(exc.namespaces, namespace)
+	return true
+}
+
+// OnDisconnect removes the connection which registered on the `OnConnect` method.
+// It's called automatically when a connection goes offline,
+// manually by server or client or by network failure.
+func (exc *StackExchange) OnDisconnect(c *neffos.Conn) {
+	var topics []string
+	exc.mu.Lock()
+	for namespace := range exc.conns[c] {
+		if exc.removeLocked(exc.conns[c], namespace) {
+			topics = append(topics, exc.getTopic(namespace, "")+"/#")
+		}
+	}
+	delete(exc.conns, c)
+	exc.mu.Unlock()
+
+	for _, topic := range topics {
+		exc.unsubscribe(topic)
+	}
+}
+
+// Close disconnects from the brokers,
+// it waits 250 milliseconds at most for the in-flight publishes.
+// It's called automatically by the `neffos.Server.Shutdown`.
+func (exc *StackExchange) Close() error {
+	exc.client.Disconnect(250)
+	return nil
+}
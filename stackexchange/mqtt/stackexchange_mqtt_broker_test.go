@@ -0,0 +1,139 @@
+//go:build mqtt
+// +build mqtt
+
+package mqtt
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Run with an MQTT broker, i.e:
+//
+//	docker run --rm -p 1883:1883 emqx/emqx
+//	go test -tags mqtt ./stackexchange/mqtt
+//
+// The MQTT_BROKER environment variable overrides the default tcp://127.0.0.1:1883.
+func newTestConfig() Config {
+	cfg := Config{TopicPrefix: "neffostest", QoS: 1}
+	if broker := os.Getenv("MQTT_BROKER"); broker != "" {
+		cfg.Brokers = []string{broker}
+	}
+
+	return cfg
+}
+
+func TestStackExchange(t *testing.T) {
+	const namespace = "default"
+
+	// a stale broadcast, it should never be delivered.
+	opts := mqtt.NewClientOptions()
+	for _, broker := range newTestConfig().Brokers {
+		opts.AddBroker(broker)
+	}
+	if len(opts.Servers) == 0 {
+		opts.AddBroker("tcp://127.0.0.1:1883")
+	}
+	publisher := mqtt.NewClient(opts)
+	if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer publisher.Disconnect(0)
+
+	stale := neffos.Message{Namespace: namespace, Event: "notify", Body: []byte("stale")}
+	if token := publisher.Publish("neffostest/default", 1, true, stale.SerializeExchange()); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer publisher.Publish("neffostest/default", 1, true, []byte{}).Wait() // clear it.
+
+	newServer := func() (*neffos.Server, string) {
+		exc, err := NewStackExchange(newTestConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		if err = server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+
+		httpServer := httptest.NewServer(server)
+		t.Cleanup(func() {
+			server.Close()
+			httpServer.Close()
+			exc.Close()
+		})
+		return server, strings.Replace(httpServer.URL, "http", "ws", 1)
+	}
+
+	serverA, _ := newServer()
+	_, urlB := newServer()
+
+	received := make(chan string, 4)
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, urlB, neffos.Namespaces{namespace: neffos.Events{
+		"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+			received <- msg.Room + ":" + string(msg.Body)
+			return nil
+		},
+		"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+			return neffos.Reply([]byte("answer"))
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.JoinRoom(context.TODO(), "room"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(expected string) {
+		t.Helper()
+
+		select {
+		case got := <-received:
+			if expected != got {
+				t.Fatalf("expected: %s but got: %s", expected, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected: %s", expected)
+		}
+	}
+
+	serverA.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify", Body: []byte("data")})
+	expect(":data")
+
+	serverA.Broadcast(nil, neffos.Message{Namespace: namespace, Room: "room", Event: "notify", Body: []byte("room data")})
+	expect("room:room data")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	response, err := serverA.Ask(ctx, neffos.Message{Namespace: namespace, Event: "ask"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "answer", string(response.Body); expected != got {
+		t.Fatalf("expected response: %s but got: %s", expected, got)
+	}
+
+	select {
+	case got := <-received:
+		t.Fatalf("expected no more messages but got: %s", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,28 @@
+package mqtt
+
+import "testing"
+
+func TestStackExchangeTopics(t *testing.T) {
+	exc := &StackExchange{cfg: Config{TopicPrefix: "app"}}
+
+	tests := []struct {
+		namespace, room string
+		expected        string
+	}{
+		{"default", "", "app/default"},
+		{"default", "room", "app/default/room"},
+		{"", "", "app/%"},
+		{"chat/rooms", "#general+", "app/chat%2Frooms/%23general%2B"},
+		{"$SYS", "100%", "app/%24SYS/100%25"},
+	}
+
+	for _, tt := range tests {
+		if got := exc.getTopic(tt.namespace, tt.room); tt.expected != got {
+			t.Fatalf("[%s:%s] expected topic: %s but got: %s", tt.namespace, tt.room, tt.expected, got)
+		}
+	}
+
+	if expected, got := "app/$ask/%23123!", exc.getAskTopicPrefix()+escapeTopicLevel("#123!"); expected != got {
+		t.Fatalf("expected ask topic: %s but got: %s", expected, got)
+	}
+}
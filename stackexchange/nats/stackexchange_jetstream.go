@@ -0,0 +1,438 @@
+package nats
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	"github.com/nats-io/nats.go"
+)
+
+// JetStreamConfig is used on the `NewJetStreamStackExchange` package-level function.
+type JetStreamConfig struct {
+	// SubjectPrefix is the prefix of the subjects,
+	// each namespace is published to its own subject, i.e "<SubjectPrefix>.<namespace>".
+	// If you use the same nats server instance for multiple neffos apps,
+	// set this to different values across your apps.
+	// Defaults to "neffos".
+	SubjectPrefix string
+	// Durable is the prefix of this server instance's durable consumers, one per namespace.
+	// It should be unique per instance and stable across its restarts (e.g. a StatefulSet's pod name),
+	// so the messages published while the instance was down are delivered once it's up again.
+	// Defaults to the hostname.
+	Durable string
+	// Stream is the configuration of the stream which is created or updated on `Init`,
+	// i.e its retention (MaxAge, MaxMsgs, MaxBytes), Storage and Replicas.
+	// Its Name defaults to the upper-cased "SubjectPrefix" and its Subjects to "<SubjectPrefix>.>".
+	// If no limit is set, the MaxAge defaults to 24 hours.
+	Stream nats.StreamConfig
+	// PresenceTTL enables the cluster presence, see `neffos.Server.ClusterLookup`.
+	// The connections of each server instance are registered to the "<Stream.Name>_PRESENCE" key-value bucket
+	// whose records expire after PresenceTTL unless the instance writes them again, every third of the PresenceTTL,
+	// so the records of a crashed instance are removed.
+	//
+	// Defaults to 0, disabled.
+	PresenceTTL time.Duration
+}
+
+// JetStreamStackExchange is a `neffos.StackExchange` for nats JetStream,
+// a durable and replayable alternative to the core nats `StackExchange`.
+//
+// All the messages of a neffos app are stored to a single stream
+// and each server instance reads them through its own durable consumers, one per namespace,
+// a message is acknowledged after its local delivery. The delivery is at-least-once:
+// a message may be delivered more than once, the event callbacks should be idempotent.
+//
+// The stream and the consumers are provisioned on `Init`, use the `Server.UseStackExchange` to register it.
+// The consumers are of the declared namespaces only, so it can't be used with the `neffos.Namespaces#SetDynamic`.
+// A lost connection is reported to the `Server.OnStackExchangeError`
+// and the consumers are subscribed again once it's reconnected, which is reported as a recovery.
+type JetStreamStackExchange struct {
+	cfg JetStreamConfig
+
+	nc *nats.Conn
+	js nats.JetStreamContext
+
+	namespaces    []string
+	subscriptions map[string]*nats.Subscription // by namespace.
+	subMu         sync.Mutex
+
+	health neffos.StackExchangeHealth
+
+	mu    sync.RWMutex
+	conns map[*neffos.Conn]map[string]struct{}
+
+	// see `JetStreamConfig.PresenceTTL`.
+	presenceKV nats.KeyValue
+	presence   map[string]*jetStreamPresence // by instance.
+	presenceMu sync.Mutex
+}
+
+var (
+	_ neffos.StackExchange                 = (*JetStreamStackExchange)(nil)
+	_ neffos.OriginStackExchange           = (*JetStreamStackExchange)(nil)
+	_ neffos.StackExchangeInitializer      = (*JetStreamStackExchange)(nil)
+	_ neffos.StackExchangeErrorReporter    = (*JetStreamStackExchange)(nil)
+	_ neffos.PresenceStackExchange         = (*JetStreamStackExchange)(nil)
+	_ neffos.ClosableStackExchange         = (*JetStreamStackExchange)(nil)
+	_ neffos.StaticNamespacesStackExchange = (*JetStreamStackExchange)(nil)
+	_ neffos.PingableStackExchange         = (*JetStreamStackExchange)(nil)
+)
+
+// NewJetStreamStackExchange returns a new nats JetStream StackExchange.
+// The "url" and "options" are the same as the `NewStackExchange`'s,
+// the disconnect, reconnect and error handlers of the "options" are overridden.
+func NewJetStreamStackExchange(url string, cfg JetStreamConfig, options ...nats.Option) (*JetStreamStackExchange, error) {
+	if cfg.SubjectPrefix == "" {
+		cfg.SubjectPrefix = "neffos"
+	}
+
+	if cfg.Durable == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Durable = hostname
+	}
+
+	if cfg.Stream.Name == "" {
+		cfg.Stream.Name = sanitizeName(strings.ToUpper(cfg.SubjectPrefix))
+	}
+
+	if len(cfg.Stream.Subjects) == 0 {
+		cfg.Stream.Subjects = []string{cfg.SubjectPrefix + ".>"}
+	}
+
+	if cfg.Stream.MaxAge == 0 && cfg.Stream.MaxMsgs <= 0 && cfg.Stream.MaxBytes <= 0 {
+		cfg.Stream.MaxAge = 24 * time.Hour
+	}
+
+	opts := nats.GetDefaultOptions()
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	opts.Url = url
+
+	for _, opt := range options {
+		if opt == nil {
+			continue
+		}
+		if err := opt(&opts); err != nil {
+			return nil, err
+		}
+	}
+
+	servers := strings.Split(opts.Url, ",")
+	for i, s := range servers {
+		servers[i] = strings.TrimSpace(s)
+	}
+	opts.Servers = append(opts.Servers, servers...)
+	// keep reconnecting, the consumers are subscribed again on reconnect.
+	opts.MaxReconnect = -1
+
+	exc := &JetStreamStackExchange{
+		cfg:           cfg,
+		subscriptions: make(map[string]*nats.Subscription),
+		conns:         make(map[*neffos.Conn]map[string]struct{}),
+		presence:      make(map[string]*jetStreamPresence),
+	}
+
+	opts.DisconnectedErrCB = func(_ *nats.Conn, err error) {
+		if err == nil {
+			err = nats.ErrDisconnected
+		}
+		exc.fireError(err)
+	}
+	opts.ReconnectedCB = func(*nats.Conn) {
+		if err := exc.activate(); err != nil {
+			exc.fireError(err)
+			return
+		}
+		exc.health.Recover()
+	}
+	opts.AsyncErrorCB = func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		exc.fireError(err)
+	}
+
+	nc, err := opts.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	exc.nc = nc
+	exc.js = js
+	return exc, nil
+}
+
+// sanitizeName returns a valid stream or consumer name of "s",
+// they cannot contain whitespaces, ".", "*" and ">".
+func sanitizeName(s string) string {
+	if s == "" {
+		return "_"
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		default:
+			return r
+		}
+	}, s)
+}
+
+// Nats does not allow ending with ".", it uses pattern matching.
+func (exc *JetStreamStackExchange) getSubject(namespace string) string {
+	if namespace == "" {
+		namespace = "_"
+	}
+
+	return exc.cfg.SubjectPrefix + "." + namespace
+}
+
+// SetErrorHandler registers the handler of the connection errors and their recoveries,
+// it's called automatically by the `Server.UseStackExchange`.
+func (exc *JetStreamStackExchange) SetErrorHandler(handler func(err error, recovered bool)) {
+	exc.health.SetErrorHandler(handler)
+}
+
+func (exc *JetStreamStackExchange) fireError(err error) {
+	exc.health.Fail(err)
+}
+
+// Init creates or updates the stream and subscribes to the durable consumers of the "namespaces".
+// It's called automatically by the `Server.UseStackExchange`.
+// Ping sends a PING to the nats server and waits for its PONG, see `neffos.Server.Health`.
+func (exc *JetStreamStackExchange) Ping(ctx context.Context) error {
+	return ping(ctx, exc.nc)
+}
+
+func (exc *JetStreamStackExchange) Init(namespaces neffos.Namespaces) error {
+	exc.subMu.Lock()
+	for namespace := range namespaces {
+		exc.namespaces = append(exc.namespaces, namespace)
+	}
+	exc.subMu.Unlock()
+
+	return exc.activate()
+}
+
+// activate provisions the stream and the consumers and subscribes to them.
+// The subscriptions survive a reconnect, a consumer is created and subscribed again only if it's missing,
+// i.e the nats server was restarted with a memory storage.
+func (exc *JetStreamStackExchange) activate() error {
+	exc.subMu.Lock()
+	defer exc.subMu.Unlock()
+
+	stream := exc.cfg.Stream
+	if _, err := exc.js.StreamInfo(stream.Name); err == nats.ErrStreamNotFound {
+		if _, err = exc.js.AddStream(&stream); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if _, err = exc.js.UpdateStream(&stream); err != nil {
+		return err
+	}
+
+	for _, namespace := range exc.namespaces {
+		subject := exc.getSubject(namespace)
+		durable := sanitizeName(exc.cfg.Durable + "_" + sanitizeName(namespace))
+
+		// the consumers are not created by the subscriptions,
+		// otherwise they are deleted on unsubscribe and drain.
+		_, err := exc.js.ConsumerInfo(stream.Name, durable)
+		if err == nats.ErrConsumerNotFound {
+			_, err = exc.js.AddConsumer(stream.Name, &nats.ConsumerConfig{
+				Durable:        durable,
+				DeliverSubject: nats.NewInbox(),
+				DeliverPolicy:  nats.DeliverNewPolicy,
+				AckPolicy:      nats.AckExplicitPolicy,
+				FilterSubject:  subject,
+			})
+			if err != nil {
+				return err
+			}
+
+			if sub, ok := exc.subscriptions[namespace]; ok {
+				// its consumer was lost.
+				sub.Unsubscribe()
+			}
+		} else if err != nil {
+			return err
+		} else if _, ok := exc.subscriptions[namespace]; ok {
+			continue
+		}
+
+		sub, err := exc.js.Subscribe(subject, exc.handleMessage, nats.Bind(stream.Name, durable), nats.ManualAck())
+		if err != nil {
+			return err
+		}
+
+		exc.subscriptions[namespace] = sub
+	}
+
+	return nil
+}
+
+// handleMessage writes the message to the local connections and acknowledges it.
+func (exc *JetStreamStackExchange) handleMessage(m *nats.Msg) {
+	msg := neffos.DeserializeExchangeMessage(m.Data)
+
+	var receivers []*neffos.Conn
+	exc.mu.RLock()
+	for c, namespaces := range exc.conns {
+		if msg.To != "" {
+			if c.ID() == msg.To {
+				receivers = append(receivers, c)
+			}
+			continue
+		}
+
+		if _, ok := namespaces[msg.Namespace]; ok {
+			receivers = append(receivers, c)
+		}
+	}
+	exc.mu.RUnlock()
+
+	for _, c := range receivers {
+		c.Write(c.DeserializeExchangeMessage(m.Data))
+	}
+
+	m.Ack()
+}
+
+// OnConnect registers the connection for its direct messages.
+// It's called automatically after the neffos server's OnConnect (if any)
+// on incoming client connections.
+func (exc *JetStreamStackExchange) OnConnect(c *neffos.Conn) error {
+	exc.mu.Lock()
+	exc.conns[c] = make(map[string]struct{})
+	exc.mu.Unlock()
+	return nil
+}
+
+// PreservesOrigin implements the `neffos.OriginStackExchange`,
+// the envelopes of the messages are the data of the stream messages.
+func (exc *JetStreamStackExchange) PreservesOrigin() bool {
+	return true
+}
+
+// RequiresStaticNamespaces implements the `neffos.StaticNamespacesStackExchange`,
+// the durable consumers are created on `Init`, one per declared namespace.
+func (exc *JetStreamStackExchange) RequiresStaticNamespaces() bool {
+	return true
+}
+
+// Publish publishes messages to the stream, it waits for their acknowledgement.
+// It's called automatically on neffos broadcasting.
+func (exc *JetStreamStackExchange) Publish(msgs []neffos.Message) bool {
+	for _, msg := range msgs {
+		if !exc.publish(msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (exc *JetStreamStackExchange) publish(msg neffos.Message) bool {
+	// direct messages are published to their namespace's subject too,
+	// the receiver's server delivers them to the "msg.To" connection.
+	_, err := exc.js.Publish(exc.getSubject(msg.Namespace), msg.SerializeExchange(), nats.ExpectStream(exc.cfg.Stream.Name))
+	if err != nil {
+		return false
+	}
+
+	exc.health.Recover()
+	return true
+}
+
+// Ask implements server Ask for nats JetStream. It blocks.
+// The response is transferred through a core nats subject, it's not stored.
+func (exc *JetStreamStackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (response neffos.Message, err error) {
+	ch := make(chan neffos.Message, 1)
+	sub, err := exc.nc.Subscribe(token, func(m *nats.Msg) {
+		select {
+		case ch <- neffos.DeserializeExchangeMessage(m.Data):
+		default:
+		}
+	})
+	if err != nil {
+		return response, err
+	}
+	defer sub.Unsubscribe()
+
+	if !exc.publish(msg) {
+		return response, neffos.ErrWrite
+	}
+
+	select {
+	case <-ctx.Done():
+		return response, ctx.Err()
+	case response = <-ch:
+		return response, response.Err
+	}
+}
+
+// NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
+func (exc *JetStreamStackExchange) NotifyAsk(msg neffos.Message, token string) error {
+	msg.ClearWait()
+	if err := exc.nc.Publish(token, msg.SerializeExchange()); err != nil {
+		return err
+	}
+
+	return exc.nc.Flush()
+}
+
+// Subscribe subscribes the connection to a specific namespace,
+// it's called automatically on neffos namespace connected.
+func (exc *JetStreamStackExchange) Subscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		namespaces[namespace] = struct{}{}
+	}
+	exc.mu.Unlock()
+}
+
+// Unsubscribe unsubscribes the connection from a specific namespace,
+// it's called automatically on neffos namespace disconnect.
+func (exc *JetStreamStackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		delete(namespaces, namespace)
+	}
+	exc.mu.Unlock()
+}
+
+// OnDisconnect removes the connection which registered on the `OnConnect` method.
+// It's called automatically when a connection goes offline,
+// manually by server or client or by network failure.
+func (exc *JetStreamStackExchange) OnDisconnect(c *neffos.Conn) {
+	exc.mu.Lock()
+	delete(exc.conns, c)
+	exc.mu.Unlock()
+}
+
+// Close drains the subscriptions and closes the nats connection,
+// the durable consumers are kept so a next instance with the same `JetStreamConfig.Durable`
+// continues from the last acknowledged message.
+// The local connections are removed first, the messages which are drained are not delivered to them.
+// It's called automatically by the `neffos.Server.Shutdown`.
+func (exc *JetStreamStackExchange) Close() error {
+	exc.mu.Lock()
+	exc.conns = make(map[*neffos.Conn]map[string]struct{})
+	exc.mu.Unlock()
+
+	return exc.nc.Drain()
+}
@@ -0,0 +1,234 @@
+package nats
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	"github.com/nats-io/nats.go"
+)
+
+// jetStreamPresence is the local state of a joined server instance, see `JetStreamConfig.PresenceTTL`.
+// Its records are stored to the key-value bucket as:
+//
+//	<instance>.n.<base64 name>    the number of the connections of a connection ID or alias.
+//	<instance>.total              the number of the connections of the instance.
+//
+// The bucket's TTL removes the records which are not refreshed by the heartbeat of their instance.
+type jetStreamPresence struct {
+	names map[string]int
+	total int
+	stop  chan struct{}
+}
+
+func presenceNameKey(instanceID, name string) string {
+	return instanceID + ".n." + base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+func presenceTotalKey(instanceID string) string {
+	return instanceID + ".total"
+}
+
+// PresenceJoin creates the presence bucket, if it's missing, and starts the heartbeat of the "instanceID".
+// It returns the `neffos.ErrPresenceUnsupported` if the `JetStreamConfig.PresenceTTL` is not set.
+func (exc *JetStreamStackExchange) PresenceJoin(instanceID string) error {
+	if exc.cfg.PresenceTTL <= 0 {
+		return neffos.ErrPresenceUnsupported
+	}
+
+	exc.presenceMu.Lock()
+	defer exc.presenceMu.Unlock()
+
+	if exc.presenceKV == nil {
+		bucket := sanitizeName(exc.cfg.Stream.Name + "_PRESENCE")
+		kv, err := exc.js.KeyValue(bucket)
+		if err == nats.ErrBucketNotFound {
+			kv, err = exc.js.CreateKeyValue(&nats.KeyValueConfig{
+				Bucket:   bucket,
+				TTL:      exc.cfg.PresenceTTL,
+				Storage:  exc.cfg.Stream.Storage,
+				Replicas: exc.cfg.Stream.Replicas,
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		exc.presenceKV = kv
+	}
+
+	if _, ok := exc.presence[instanceID]; ok {
+		return nil
+	}
+
+	p := &jetStreamPresence{names: make(map[string]int), stop: make(chan struct{})}
+	exc.presence[instanceID] = p
+	if _, err := exc.presenceKV.Put(presenceTotalKey(instanceID), []byte("0")); err != nil {
+		exc.reportPresence(err)
+	}
+
+	go exc.presenceHeartbeat(instanceID, p)
+	return nil
+}
+
+// reportPresence reports a failed presence command, unless a failure is already reported.
+func (exc *JetStreamStackExchange) reportPresence(err error) {
+	if exc.health.Healthy() {
+		exc.fireError(err)
+	}
+}
+
+// presenceHeartbeat writes the records of the "instanceID" again every third of the TTL.
+func (exc *JetStreamStackExchange) presenceHeartbeat(instanceID string, p *jetStreamPresence) {
+	ticker := time.NewTicker(exc.cfg.PresenceTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			exc.presenceMu.Lock()
+			records := make(map[string][]byte, len(p.names)+1)
+			records[presenceTotalKey(instanceID)] = []byte(strconv.Itoa(p.total))
+			for name, n := range p.names {
+				records[presenceNameKey(instanceID, name)] = []byte(strconv.Itoa(n))
+			}
+			exc.presenceMu.Unlock()
+
+			for key, value := range records {
+				if _, err := exc.presenceKV.Put(key, value); err != nil {
+					exc.reportPresence(err)
+					break
+				}
+			}
+		}
+	}
+}
+
+// PresenceLeave stops the heartbeat and removes the records of the "instanceID".
+func (exc *JetStreamStackExchange) PresenceLeave(instanceID string) {
+	exc.presenceMu.Lock()
+	defer exc.presenceMu.Unlock()
+
+	p, ok := exc.presence[instanceID]
+	if !ok {
+		return
+	}
+	delete(exc.presence, instanceID)
+	close(p.stop)
+
+	exc.presenceKV.Delete(presenceTotalKey(instanceID))
+	for name := range p.names {
+		exc.presenceKV.Delete(presenceNameKey(instanceID, name))
+	}
+}
+
+// PresenceConnected registers the "connID" and its "aliases" to the "instanceID".
+func (exc *JetStreamStackExchange) PresenceConnected(instanceID, connID string, aliases []string) {
+	exc.presenceMu.Lock()
+	defer exc.presenceMu.Unlock()
+
+	p, ok := exc.presence[instanceID]
+	if !ok {
+		return
+	}
+
+	p.total++
+	for _, name := range append([]string{connID}, aliases...) {
+		p.names[name]++
+		if _, err := exc.presenceKV.Put(presenceNameKey(instanceID, name), []byte(strconv.Itoa(p.names[name]))); err != nil {
+			exc.reportPresence(err)
+		}
+	}
+
+	if _, err := exc.presenceKV.Put(presenceTotalKey(instanceID), []byte(strconv.Itoa(p.total))); err != nil {
+		exc.reportPresence(err)
+	}
+}
+
+// PresenceDisconnected removes a connection registered by the `PresenceConnected`.
+func (exc *JetStreamStackExchange) PresenceDisconnected(instanceID, connID string, aliases []string) {
+	exc.presenceMu.Lock()
+	defer exc.presenceMu.Unlock()
+
+	p, ok := exc.presence[instanceID]
+	if !ok {
+		return
+	}
+
+	p.total--
+	for _, name := range append([]string{connID}, aliases...) {
+		var err error
+		if p.names[name]--; p.names[name] <= 0 {
+			delete(p.names, name)
+			err = exc.presenceKV.Delete(presenceNameKey(instanceID, name))
+		} else {
+			_, err = exc.presenceKV.Put(presenceNameKey(instanceID, name), []byte(strconv.Itoa(p.names[name])))
+		}
+
+		if err != nil {
+			exc.reportPresence(err)
+		}
+	}
+
+	if _, err := exc.presenceKV.Put(presenceTotalKey(instanceID), []byte(strconv.Itoa(p.total))); err != nil {
+		exc.reportPresence(err)
+	}
+}
+
+// presenceRecords calls the "fn" for each record which matches the "keys" pattern until it returns false.
+func (exc *JetStreamStackExchange) presenceRecords(ctx context.Context, keys string, fn func(entry nats.KeyValueEntry) bool) error {
+	exc.presenceMu.Lock()
+	kv := exc.presenceKV
+	exc.presenceMu.Unlock()
+
+	if kv == nil {
+		return neffos.ErrPresenceUnsupported
+	}
+
+	w, err := kv.Watch(keys, nats.IgnoreDeletes())
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry := <-w.Updates():
+			if entry == nil || !fn(entry) { // nil after the current records.
+				return nil
+			}
+		}
+	}
+}
+
+// PresenceLookup returns the instance which holds a connection of the "name" ID or alias.
+func (exc *JetStreamStackExchange) PresenceLookup(ctx context.Context, name string) (instanceID string, found bool, err error) {
+	err = exc.presenceRecords(ctx, presenceNameKey("*", name), func(entry nats.KeyValueEntry) bool {
+		instanceID = entry.Key()[:strings.IndexByte(entry.Key(), '.')]
+		found = true
+		return false
+	})
+
+	return
+}
+
+// PresenceTotal returns the number of the connections of all the alive instances.
+func (exc *JetStreamStackExchange) PresenceTotal(ctx context.Context) (int, error) {
+	total := 0
+	err := exc.presenceRecords(ctx, presenceTotalKey("*"), func(entry nats.KeyValueEntry) bool {
+		if n, _ := strconv.Atoi(string(entry.Value())); n > 0 {
+			total += n
+		}
+		return true
+	})
+
+	return total, err
+}
@@ -0,0 +1,243 @@
+//go:build nats
+// +build nats
+
+package nats
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Run with a JetStream enabled nats server, i.e:
+//
+//	docker run --rm -p 4222:4222 nats -js
+//	go test -tags nats ./stackexchange/nats
+//
+// The NATS_URL environment variable overrides the default nats://127.0.0.1:4222.
+func newTestJetStreamStackExchange(t *testing.T, durable string) *JetStreamStackExchange {
+	t.Helper()
+
+	exc, err := NewJetStreamStackExchange(os.Getenv("NATS_URL"), JetStreamConfig{
+		SubjectPrefix: "neffostest",
+		Durable:       durable,
+		Stream:        nats.StreamConfig{Storage: nats.MemoryStorage, MaxAge: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return exc
+}
+
+func TestJetStreamStackExchange(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+		received  = make(chan []byte, 2)
+	)
+
+	newServer := func(durable string) (*neffos.Server, string, func()) {
+		exc := newTestJetStreamStackExchange(t, durable)
+		server := neffos.New(gorilla.DefaultUpgrader, events)
+		if err := server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+
+		httpServer := httptest.NewServer(server)
+		return server, strings.Replace(httpServer.URL, "http", "ws", 1), func() {
+			server.Close()
+			httpServer.Close()
+			exc.Close()
+		}
+	}
+
+	serverA, _, teardownA := newServer("a")
+	defer teardownA()
+	_, urlB, teardownB := newServer("b")
+	defer teardownB()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, urlB, neffos.Namespaces{namespace: neffos.Events{
+		"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+			received <- msg.Body
+			return nil
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	serverA.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify", Body: []byte("data")})
+
+	select {
+	case b := <-received:
+		if expected, got := "data", string(b); expected != got {
+			t.Fatalf("expected body: %s but got: %s", expected, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected a message from the other server instance")
+	}
+
+	select {
+	case b := <-received:
+		t.Fatalf("expected a single delivery but got a second one: %s", b)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestJetStreamStackExchangeProvisioning(t *testing.T) {
+	exc := newTestJetStreamStackExchange(t, "provisioning")
+	defer exc.Close()
+
+	var reported []error
+	exc.SetErrorHandler(func(err error, recovered bool) { reported = append(reported, err) })
+
+	if err := exc.Init(neffos.Namespaces{"default": neffos.Events{}, "": neffos.Events{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := exc.js.StreamInfo("NEFFOSTEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := time.Minute, info.Config.MaxAge; expected != got {
+		t.Fatalf("expected stream max age: %s but got: %s", expected, got)
+	}
+
+	for _, durable := range []string{"provisioning_default", "provisioning__"} {
+		if _, err = exc.js.ConsumerInfo("NEFFOSTEST", durable); err != nil {
+			t.Fatalf("expected the durable consumer: %s: %v", durable, err)
+		}
+	}
+
+	// a second Init of the same instance updates the stream and keeps the consumers.
+	if err = exc.activate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reported) > 0 {
+		t.Fatalf("expected no reported errors but got: %v", reported)
+	}
+}
+
+func TestJetStreamStackExchangeDurable(t *testing.T) {
+	namespaces := neffos.Namespaces{"default": neffos.Events{}}
+
+	exc := newTestJetStreamStackExchange(t, "durable")
+	if err := exc.Init(namespaces); err != nil {
+		t.Fatal(err)
+	}
+	// i.e a deploy.
+	exc.Close()
+
+	publisher := newTestJetStreamStackExchange(t, "publisher")
+	defer publisher.Close()
+	if err := publisher.Init(namespaces); err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer of the closed instance is kept and the message waits for it.
+	before, err := publisher.js.ConsumerInfo("NEFFOSTEST", "durable_default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !publisher.Publish([]neffos.Message{{Namespace: "default", Event: "notify", Body: []byte("data")}}) {
+		t.Fatalf("expected publish to succeed")
+	}
+
+	info, err := publisher.js.ConsumerInfo("NEFFOSTEST", "durable_default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := before.NumPending+1, info.NumPending; expected != got {
+		t.Fatalf("expected %d pending message but got: %d", expected, got)
+	}
+}
+
+func TestJetStreamStackExchangePresence(t *testing.T) {
+	const ttl = time.Second
+
+	ctx := context.TODO()
+
+	var exchanges []*JetStreamStackExchange
+	for i := 0; i < 2; i++ {
+		exc, err := NewJetStreamStackExchange(os.Getenv("NATS_URL"), JetStreamConfig{
+			SubjectPrefix: "neffostest",
+			Durable:       "presence",
+			Stream:        nats.StreamConfig{Storage: nats.MemoryStorage, MaxAge: time.Minute},
+			PresenceTTL:   ttl,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer exc.Close()
+
+		instanceID := "instance-" + strconv.Itoa(i)
+		if err = exc.PresenceJoin(instanceID); err != nil {
+			t.Fatal(err)
+		}
+		defer exc.PresenceLeave(instanceID)
+		exchanges = append(exchanges, exc)
+	}
+
+	exchanges[0].PresenceConnected("instance-0", "a", []string{"alice"})
+	exchanges[1].PresenceConnected("instance-1", "b", nil)
+
+	expectLookup := func(name, expected string) {
+		t.Helper()
+
+		instanceID, found, err := exchanges[1].PresenceLookup(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != (expected != "") || instanceID != expected {
+			t.Fatalf("[%s] expected instance: %q but got: %q", name, expected, instanceID)
+		}
+	}
+
+	expectLookup("a", "instance-0")
+	expectLookup("alice", "instance-0")
+	expectLookup("b", "instance-1")
+	expectLookup("missing", "")
+
+	if total, err := exchanges[0].PresenceTotal(ctx); err != nil || total != 2 {
+		t.Fatalf("expected 2 connections but got: %d (%v)", total, err)
+	}
+
+	exchanges[1].PresenceDisconnected("instance-1", "b", nil)
+	expectLookup("b", "")
+
+	// the heartbeat keeps the records of an alive instance.
+	time.Sleep(2 * ttl)
+	expectLookup("alice", "instance-0")
+
+	// the records of a crashed instance expire.
+	exchanges[0].presenceMu.Lock()
+	close(exchanges[0].presence["instance-0"].stop)
+	delete(exchanges[0].presence, "instance-0")
+	exchanges[0].presenceMu.Unlock()
+
+	time.Sleep(2 * ttl)
+	expectLookup("alice", "")
+
+	if total, err := exchanges[0].PresenceTotal(ctx); err != nil || total != 0 {
+		t.Fatalf("expected 0 connections but got: %d (%v)", total, err)
+	}
+}
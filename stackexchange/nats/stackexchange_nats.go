@@ -1,9 +1,12 @@
 package nats
 
 import (
+	"bytes"
 	"context"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kataras/neffos"
 
@@ -28,9 +31,21 @@ type StackExchange struct {
 	subscribe     chan subscribeAction
 	unsubscribe   chan unsubscribeAction
 	delSubscriber chan closeAction
+
+	// closed by `Close`, the run loop exits on it and closes runDone.
+	closed    chan struct{}
+	runDone   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
 }
 
-var _ neffos.StackExchange = (*StackExchange)(nil)
+var (
+	_ neffos.StackExchange         = (*StackExchange)(nil)
+	_ neffos.OriginStackExchange   = (*StackExchange)(nil)
+	_ neffos.AskableStackExchange  = (*StackExchange)(nil)
+	_ neffos.ClosableStackExchange = (*StackExchange)(nil)
+	_ neffos.PingableStackExchange = (*StackExchange)(nil)
+)
 
 type (
 	subscriber struct {
@@ -147,6 +162,8 @@ func NewStackExchange(url string, options ...nats.Option) (*StackExchange, error
 		delSubscriber: make(chan closeAction),
 		subscribe:     make(chan subscribeAction),
 		unsubscribe:   make(chan unsubscribeAction),
+		closed:        make(chan struct{}),
+		runDone:       make(chan struct{}),
 	}
 
 	go exc.run()
@@ -169,6 +186,14 @@ func (exc *StackExchange) run() {
 				}
 
 				subject := exc.getSubject(m.namespace, "", "")
+
+				sub.mu.RLock()
+				_, subscribed := sub.subscriptions[subject]
+				sub.mu.RUnlock()
+				if subscribed {
+					continue
+				}
+
 				// neffos.Debugf("[%s] subscribed to [%s]", m.conn.ID(), subject)
 				subscription, err := sub.subConn.Subscribe(subject, makeMsgHandler(sub.conn))
 				if err != nil {
@@ -217,10 +242,48 @@ func (exc *StackExchange) run() {
 
 				delete(exc.subscribers, m.conn)
 			}
+		case <-exc.closed:
+			for c, sub := range exc.subscribers {
+				sub.subConn.Close()
+				delete(exc.subscribers, c)
+			}
+			close(exc.runDone)
+			return
 		}
 	}
 }
 
+// Close stops the deliveries to the local connections, their subscriber connections are closed,
+// flushes the pending publishes and closes the publisher connection.
+// It's called automatically by the `Server.Shutdown`.
+func (exc *StackExchange) Close() error {
+	exc.closeOnce.Do(func() {
+		close(exc.closed)
+		<-exc.runDone
+
+		exc.closeErr = exc.publisher.Flush()
+		exc.publisher.Close()
+	})
+
+	return exc.closeErr
+}
+
+// Ping sends a PING to the nats server through the publisher connection and waits for its PONG,
+// see `neffos.Server.Health`.
+func (exc *StackExchange) Ping(ctx context.Context) error {
+	return ping(ctx, exc.publisher)
+}
+
+// ping flushes the "nc", a PING round trip, until the "ctx" is done,
+// or for up to the default flush timeout if the "ctx" has no deadline.
+func ping(ctx context.Context, nc *nats.Conn) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return nc.Flush()
+	}
+
+	return nc.FlushWithContext(ctx)
+}
+
 // Nats does not allow ending with ".", it uses pattern matching.
 func (exc *StackExchange) getSubject(namespace, room, connID string) string {
 	if connID != "" {
@@ -237,15 +300,48 @@ func (exc *StackExchange) getSubject(namespace, room, connID string) string {
 	return exc.SubjectPrefix + "." + namespace
 }
 
+// getAskSubject returns the subject of the `AskConn` requests of a connection.
+func (exc *StackExchange) getAskSubject(connID string) string {
+	return exc.getSubject("", "", connID) + ".ask"
+}
+
 func makeMsgHandler(c *neffos.Conn) nats.MsgHandler {
 	return func(m *nats.Msg) {
-		msg := c.DeserializeMessage(neffos.TextMessage, m.Data)
-		msg.FromStackExchange = true
+		msg := c.DeserializeExchangeMessage(m.Data)
 
 		c.Write(msg)
 	}
 }
 
+// makeAskHandler performs the `neffos.Conn.Ask` of the `AskConn` requests
+// and responds with its result.
+func makeAskHandler(c *neffos.Conn) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		// deadline;message
+		parts := bytes.SplitN(m.Data, []byte(";"), 2)
+		if len(parts) != 2 {
+			return
+		}
+
+		go func() {
+			ctx := context.Background()
+			if deadline, _ := strconv.ParseInt(string(parts[0]), 10, 64); deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, time.Unix(0, deadline))
+				defer cancel()
+			}
+
+			response, err := c.Ask(ctx, c.DeserializeExchangeMessage(parts[1]))
+			if err != nil {
+				response = neffos.Message{Err: err}
+			}
+
+			response.ClearWait()
+			m.Respond(response.SerializeExchange())
+		}()
+	}
+}
+
 // OnConnect prepares the connection nats subscriber
 // and subscribes to itself for direct neffos messages.
 // It's called automatically after the neffos server's OnConnect (if any)
@@ -265,6 +361,11 @@ func (exc *StackExchange) OnConnect(c *neffos.Conn) error {
 		return err
 	}
 
+	_, err = subConn.Subscribe(exc.getAskSubject(c.ID()), makeAskHandler(c))
+	if err != nil {
+		return err
+	}
+
 	subConn.Flush()
 
 	if err = subConn.LastError(); err != nil {
@@ -278,13 +379,26 @@ func (exc *StackExchange) OnConnect(c *neffos.Conn) error {
 		subConn: subConn,
 	}
 
-	exc.addSubscriber <- s
+	select {
+	case <-exc.closed:
+		subConn.Close()
+		return nats.ErrConnectionClosed
+	case exc.addSubscriber <- s:
+	}
 
 	return nil
 }
 
+// PreservesOrigin implements the `neffos.OriginStackExchange`,
+// the envelopes of the messages are published through nats as they are.
+func (exc *StackExchange) PreservesOrigin() bool {
+	return true
+}
+
 // Publish publishes messages through nats.
 // It's called automatically on neffos broadcasting.
+// The nats client buffers its writes, the messages of a batch are written
+// without a round trip each, see `neffos.Server.SetStackExchangeBatch`.
 func (exc *StackExchange) Publish(msgs []neffos.Message) bool {
 	for _, msg := range msgs {
 		if !exc.publish(msg) {
@@ -297,7 +411,7 @@ func (exc *StackExchange) Publish(msgs []neffos.Message) bool {
 
 func (exc *StackExchange) publish(msg neffos.Message) bool {
 	subject := exc.getSubject(msg.Namespace, msg.Room, msg.To)
-	b := msg.Serialize()
+	b := msg.SerializeExchange()
 
 	err := exc.publisher.Publish(subject, b)
 	// Let's not add logging options, let
@@ -317,7 +431,7 @@ func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token str
 
 	ch := make(chan neffos.Message)
 	sub, err := subConn.Subscribe(token, func(m *nats.Msg) {
-		ch <- neffos.DeserializeMessage(neffos.TextMessage, m.Data, false, false)
+		ch <- neffos.DeserializeExchangeMessage(m.Data)
 	})
 
 	if err != nil {
@@ -339,10 +453,34 @@ func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token str
 	}
 }
 
+// AskConn sends the "msg" as a nats request to the "connID" connection and blocks until its response.
+// The server instance which owns the connection performs the `neffos.Conn.Ask` and responds with its result.
+// It returns the `neffos.ErrConnNotFound` if there is no responder for that connection.
+func (exc *StackExchange) AskConn(ctx context.Context, connID string, msg neffos.Message) (neffos.Message, error) {
+	var deadline int64
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d.UnixNano()
+	}
+
+	msg.To = connID
+	payload := append([]byte(strconv.FormatInt(deadline, 10)+";"), msg.SerializeExchange()...)
+
+	m, err := exc.publisher.RequestWithContext(ctx, exc.getAskSubject(connID), payload)
+	if err != nil {
+		if err == nats.ErrNoResponders {
+			err = neffos.ErrConnNotFound
+		}
+		return neffos.Message{}, err
+	}
+
+	response := neffos.DeserializeExchangeMessage(m.Data)
+	return response, response.Err
+}
+
 // NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
 func (exc *StackExchange) NotifyAsk(msg neffos.Message, token string) error {
 	msg.ClearWait()
-	err := exc.publisher.Publish(token, msg.Serialize())
+	err := exc.publisher.Publish(token, msg.SerializeExchange())
 	if err != nil {
 		return err
 	}
@@ -353,18 +491,18 @@ func (exc *StackExchange) NotifyAsk(msg neffos.Message, token string) error {
 // Subscribe subscribes to a specific namespace,
 // it's called automatically on neffos namespace connected.
 func (exc *StackExchange) Subscribe(c *neffos.Conn, namespace string) {
-	exc.subscribe <- subscribeAction{
-		conn:      c,
-		namespace: namespace,
+	select {
+	case <-exc.closed:
+	case exc.subscribe <- subscribeAction{conn: c, namespace: namespace}:
 	}
 }
 
 // Unsubscribe unsubscribes from a specific namespace,
 // it's called automatically on neffos namespace disconnect.
 func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
-	exc.unsubscribe <- unsubscribeAction{
-		conn:      c,
-		namespace: namespace,
+	select {
+	case <-exc.closed:
+	case exc.unsubscribe <- unsubscribeAction{conn: c, namespace: namespace}:
 	}
 }
 
@@ -375,5 +513,8 @@ func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
 // It's called automatically when a connection goes offline,
 // manually by server or client or by network failure.
 func (exc *StackExchange) OnDisconnect(c *neffos.Conn) {
-	exc.delSubscriber <- closeAction{conn: c}
+	select {
+	case <-exc.closed:
+	case exc.delSubscriber <- closeAction{conn: c}:
+	}
 }
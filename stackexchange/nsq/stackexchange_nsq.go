@@ -0,0 +1,408 @@
+package nsq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kataras/neffos"
+
+	uuid "github.com/iris-contrib/go.uuid"
+	"github.com/nsqio/go-nsq"
+)
+
+// Config is used on the `NewStackExchange` package-level function.
+type Config struct {
+	// NSQDAddrs is the list of the TCP addresses of the nsqd daemons which the messages are published to,
+	// usually the nsqd of the host. The next one is used when a publish to the current one fails.
+	// They are consumed directly too, besides the ones discovered through the LookupdAddrs.
+	// Defaults to "127.0.0.1:4150".
+	NSQDAddrs []string
+	// LookupdAddrs is the list of the HTTP addresses of the nsqlookupd daemons,
+	// the nsqd daemons which the topic is published to by the other server instances are discovered through them
+	// and consumed as well. Optional.
+	LookupdAddrs []string
+	// TopicPrefix is the topic of the messages, all the namespaces of an app share it,
+	// and "<TopicPrefix>.ask" is the topic of the `Ask` replies.
+	// If you use the same nsqd daemons for multiple neffos apps,
+	// set this to different values across your apps.
+	// Defaults to "neffos".
+	TopicPrefix string
+	// MaxMessageSize is the maximum size of an envelope, it should match the "--max-msg-size" of the nsqd daemons.
+	// A larger message is not published, its `Publish` reports a `*MessageTooLargeError`.
+	// Defaults to 1048576, the default of the nsqd.
+	MaxMessageSize int
+	// NSQ is the configuration of the consumers and the producers, i.e its MaxInFlight, timeouts, TLS and authentication.
+	// Defaults to the `nsq.NewConfig()` with a MaxInFlight of 200 and a LookupdPollInterval of 15 seconds.
+	NSQ *nsq.Config
+	// Logger is the logger of the nsq clients.
+	// Defaults to nil, no logs.
+	Logger *log.Logger
+}
+
+// MessageTooLargeError is reported to the `Server.OnStackExchangeError` when the envelope of a published message
+// exceeds the `Config.MaxMessageSize`, the message is not published. The `Ask` returns it.
+type MessageTooLargeError struct {
+	// Size is the size of the envelope of the message.
+	Size int
+	// MaxSize is the `Config.MaxMessageSize`.
+	MaxSize int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("nsq: message of %d bytes exceeds the maximum size of %d bytes", e.Size, e.MaxSize)
+}
+
+// StackExchange is a `neffos.StackExchange` for NSQ.
+//
+// All the messages of an app are published to a single topic, the `Config.TopicPrefix`,
+// and each server instance consumes it through its own ephemeral channel, so every instance receives every publish
+// and delivers it to its connections of the message's namespace, or to its `Message.To` connection.
+// A server writes its own broadcasts to its connections directly,
+// their copy from the nsqd is dropped by the neffos server, see `neffos.Server.InstanceID`.
+// The ephemeral channels are removed by the nsqd when their instance disconnects,
+// so a freshly started instance does not replay stale broadcasts.
+//
+// The order of the messages is best effort: a single nsqd and a single producer keep it in practice
+// but NSQ does not guarantee it, i.e across nsqd daemons or when a channel overflows to its disk queue.
+// The ephemeral channels drop their messages when their memory queue is full.
+//
+// The producers and the consumers reconnect on a lost connection,
+// the publish failures and their recoveries are reported to the `Server.OnStackExchangeError`.
+// Use the `Server.UseStackExchange` to register it.
+type StackExchange struct {
+	cfg Config
+
+	producers []*nsq.Producer
+	// the index of the producer of the next publish.
+	current uint32
+
+	consumer    *nsq.Consumer
+	askConsumer *nsq.Consumer
+
+	health neffos.StackExchangeHealth
+
+	mu    sync.RWMutex
+	conns map[*neffos.Conn]map[string]struct{}
+
+	asks   map[string]chan neffos.Message
+	asksMu sync.Mutex
+
+	closeOnce sync.Once
+}
+
+var (
+	_ neffos.StackExchange              = (*StackExchange)(nil)
+	_ neffos.OriginStackExchange        = (*StackExchange)(nil)
+	_ neffos.StackExchangeErrorReporter = (*StackExchange)(nil)
+	_ neffos.ClosableStackExchange      = (*StackExchange)(nil)
+)
+
+// NewStackExchange returns a new NSQ StackExchange,
+// it returns an error if the nsqd daemons of the `Config.NSQDAddrs` cannot be consumed.
+func NewStackExchange(cfg Config) (*StackExchange, error) {
+	if len(cfg.NSQDAddrs) == 0 {
+		cfg.NSQDAddrs = []string{"127.0.0.1:4150"}
+	}
+
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = "neffos"
+	}
+
+	if !nsq.IsValidTopicName(cfg.TopicPrefix) || !nsq.IsValidTopicName(cfg.TopicPrefix+".ask") {
+		return nil, errors.New("nsq: invalid topic prefix, it should match [.a-zA-Z0-9_-] and be up to 60 characters")
+	}
+
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = 1024 * 1024
+	}
+
+	if cfg.NSQ == nil {
+		cfg.NSQ = nsq.NewConfig()
+		cfg.NSQ.MaxInFlight = 200
+		if err := cfg.NSQ.Set("lookupd_poll_interval", "15s"); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	// up to 64 characters.
+	channel := strings.Replace(id.String(), "-", "", -1) + "#ephemeral"
+
+	exc := &StackExchange{
+		cfg:   cfg,
+		conns: make(map[*neffos.Conn]map[string]struct{}),
+		asks:  make(map[string]chan neffos.Message),
+	}
+
+	for _, addr := range cfg.NSQDAddrs {
+		producer, err := nsq.NewProducer(addr, cfg.NSQ)
+		if err != nil {
+			exc.Close()
+			return nil, err
+		}
+		if l, lvl := exc.logger(); l != nil {
+			producer.SetLogger(l, lvl)
+		} else {
+			producer.SetLogger(nil, lvl)
+		}
+		exc.producers = append(exc.producers, producer)
+	}
+
+	// a single handler per consumer, the messages are delivered in the order they are received.
+	if exc.consumer, err = exc.consume(cfg.TopicPrefix, channel, exc.deliver); err != nil {
+		exc.Close()
+		return nil, err
+	}
+
+	if exc.askConsumer, err = exc.consume(cfg.TopicPrefix+".ask", channel, exc.answer); err != nil {
+		exc.Close()
+		return nil, err
+	}
+
+	return exc, nil
+}
+
+// logger returns the logger and the log level of the nsq clients.
+func (exc *StackExchange) logger() (*log.Logger, nsq.LogLevel) {
+	if exc.cfg.Logger == nil {
+		return nil, nsq.LogLevelError
+	}
+
+	return exc.cfg.Logger, nsq.LogLevelInfo
+}
+
+// consume connects a consumer of the "topic" to the nsqd and nsqlookupd daemons.
+func (exc *StackExchange) consume(topic, channel string, handle func(b []byte)) (*nsq.Consumer, error) {
+	consumer, err := nsq.NewConsumer(topic, channel, exc.cfg.NSQ)
+	if err != nil {
+		return nil, err
+	}
+	if l, lvl := exc.logger(); l != nil {
+		consumer.SetLogger(l, lvl)
+	} else {
+		consumer.SetLogger(nil, lvl)
+	}
+
+	consumer.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		handle(m.Body)
+		return nil
+	}))
+
+	if err = consumer.ConnectToNSQDs(exc.cfg.NSQDAddrs); err != nil {
+		consumer.Stop()
+		return nil, err
+	}
+
+	if len(exc.cfg.LookupdAddrs) > 0 {
+		if err = consumer.ConnectToNSQLookupds(exc.cfg.LookupdAddrs); err != nil {
+			consumer.Stop()
+			return nil, err
+		}
+	}
+
+	return consumer, nil
+}
+
+// SetErrorHandler registers the handler of the publish errors and their recoveries,
+// it's called automatically by the `Server.UseStackExchange`.
+func (exc *StackExchange) SetErrorHandler(handler func(err error, recovered bool)) {
+	exc.health.SetErrorHandler(handler)
+}
+
+// publish publishes the "bodies" to the "topic" through the current producer,
+// the next producers are tried on failure.
+func (exc *StackExchange) publish(topic string, bodies [][]byte) error {
+	for _, body := range bodies {
+		if len(body) > exc.cfg.MaxMessageSize {
+			return &MessageTooLargeError{Size: len(body), MaxSize: exc.cfg.MaxMessageSize}
+		}
+	}
+
+	var err error
+	current := atomic.LoadUint32(&exc.current)
+	for i := 0; i < len(exc.producers); i++ {
+		idx := (int(current) + i) % len(exc.producers)
+		producer := exc.producers[idx]
+
+		if len(bodies) == 1 {
+			err = producer.Publish(topic, bodies[0])
+		} else {
+			err = producer.MultiPublish(topic, bodies)
+		}
+
+		if err == nil {
+			atomic.StoreUint32(&exc.current, uint32(idx))
+			return nil
+		}
+	}
+
+	return err
+}
+
+// deliver writes the exchange envelope "b" to the local connections.
+func (exc *StackExchange) deliver(b []byte) {
+	msg := neffos.DeserializeExchangeMessage(b)
+
+	var receivers []*neffos.Conn
+	exc.mu.RLock()
+	for c, namespaces := range exc.conns {
+		if msg.To != "" && c.ID() != msg.To {
+			continue
+		}
+
+		if _, ok := namespaces[msg.Namespace]; ok {
+			receivers = append(receivers, c)
+		}
+	}
+	exc.mu.RUnlock()
+
+	for _, c := range receivers {
+		c.Write(c.DeserializeExchangeMessage(b))
+	}
+}
+
+// answer unblocks the `Ask` of a reply "b", token;message.
+func (exc *StackExchange) answer(b []byte) {
+	parts := bytes.SplitN(b, []byte(";"), 2)
+	if len(parts) != 2 {
+		return
+	}
+
+	exc.asksMu.Lock()
+	ch, ok := exc.asks[string(parts[0])]
+	exc.asksMu.Unlock()
+
+	if ok {
+		select {
+		case ch <- neffos.DeserializeExchangeMessage(parts[1]):
+		default:
+		}
+	}
+}
+
+// OnConnect registers the connection.
+// It's called automatically after the neffos server's OnConnect (if any)
+// on incoming client connections.
+func (exc *StackExchange) OnConnect(c *neffos.Conn) error {
+	exc.mu.Lock()
+	exc.conns[c] = make(map[string]struct{})
+	exc.mu.Unlock()
+	return nil
+}
+
+// PreservesOrigin implements the `neffos.OriginStackExchange`,
+// the envelopes of the messages are published to nsqd as they are.
+func (exc *StackExchange) PreservesOrigin() bool {
+	return true
+}
+
+// Publish publishes the messages to the topic, many messages are published through a single command.
+// It's called automatically on neffos broadcasting.
+func (exc *StackExchange) Publish(msgs []neffos.Message) bool {
+	bodies := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		bodies = append(bodies, msg.SerializeExchange())
+	}
+
+	if err := exc.publish(exc.cfg.TopicPrefix, bodies); err != nil {
+		exc.health.Fail(err)
+		return false
+	}
+
+	exc.health.Recover()
+	return true
+}
+
+// Ask implements the server Ask feature for NSQ. It blocks until response.
+func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (neffos.Message, error) {
+	ch := make(chan neffos.Message, 1)
+	exc.asksMu.Lock()
+	exc.asks[token] = ch
+	exc.asksMu.Unlock()
+
+	defer func() {
+		exc.asksMu.Lock()
+		delete(exc.asks, token)
+		exc.asksMu.Unlock()
+	}()
+
+	if err := exc.publish(exc.cfg.TopicPrefix, [][]byte{msg.SerializeExchange()}); err != nil {
+		exc.health.Fail(err)
+		return neffos.Message{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return neffos.Message{}, ctx.Err()
+	case response := <-ch:
+		return response, response.Err
+	}
+}
+
+// NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
+func (exc *StackExchange) NotifyAsk(msg neffos.Message, token string) error {
+	msg.ClearWait()
+	return exc.publish(exc.cfg.TopicPrefix+".ask", [][]byte{append([]byte(token+";"), msg.SerializeExchange()...)})
+}
+
+// Subscribe subscribes the connection to the messages of a specific namespace.
+// It's called automatically on neffos namespace connected.
+func (exc *StackExchange) Subscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		namespaces[namespace] = struct{}{}
+	}
+	exc.mu.Unlock()
+}
+
+// Unsubscribe unsubscribes the connection from the messages of a specific namespace.
+// It's called automatically on neffos namespace disconnect.
+func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		delete(namespaces, namespace)
+	}
+	exc.mu.Unlock()
+}
+
+// OnDisconnect removes the connection which registered on the `OnConnect` method.
+// It's called automatically when a connection goes offline,
+// manually by server or client or by network failure.
+func (exc *StackExchange) OnDisconnect(c *neffos.Conn) {
+	exc.mu.Lock()
+	delete(exc.conns, c)
+	exc.mu.Unlock()
+}
+
+// Close stops the consumers, their ephemeral channels are removed by the nsqd, and the producers.
+// The local connections are removed first, the messages which are in flight are not delivered to them.
+// It's called automatically by the `neffos.Server.Shutdown`.
+func (exc *StackExchange) Close() error {
+	exc.closeOnce.Do(func() {
+		exc.mu.Lock()
+		exc.conns = make(map[*neffos.Conn]map[string]struct{})
+		exc.mu.Unlock()
+
+		for _, consumer := range []*nsq.Consumer{exc.consumer, exc.askConsumer} {
+			if consumer != nil {
+				consumer.Stop()
+				<-consumer.StopChan
+			}
+		}
+
+		for _, producer := range exc.producers {
+			producer.Stop()
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,134 @@
+//go:build nsq
+// +build nsq
+
+package nsq
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+)
+
+// Run with an nsqd, i.e:
+//
+//	docker run --rm -p 4150:4150 nsqio/nsq /nsqd
+//	go test -tags nsq ./stackexchange/nsq
+//
+// The NSQD_ADDR environment variable overrides the default 127.0.0.1:4150.
+func newTestConfig() Config {
+	cfg := Config{TopicPrefix: "neffostest", MaxMessageSize: 1024}
+	if addr := os.Getenv("NSQD_ADDR"); addr != "" {
+		cfg.NSQDAddrs = []string{addr}
+	}
+
+	return cfg
+}
+
+func TestStackExchange(t *testing.T) {
+	const namespace = "default"
+
+	newServer := func() (*neffos.Server, string) {
+		exc, err := NewStackExchange(newTestConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		if err = server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+
+		httpServer := httptest.NewServer(server)
+		t.Cleanup(func() {
+			server.Close()
+			httpServer.Close()
+			exc.Close()
+		})
+		return server, strings.Replace(httpServer.URL, "http", "ws", 1)
+	}
+
+	serverA, _ := newServer()
+	_, urlB := newServer()
+
+	errs := make(chan error, 1)
+	serverA.OnStackExchangeError = func(err error, recovered bool) {
+		if !recovered {
+			errs <- err
+		}
+	}
+
+	received := make(chan string, 4)
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, urlB, neffos.Namespaces{namespace: neffos.Events{
+		"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+			received <- msg.Room + ":" + string(msg.Body)
+			return nil
+		},
+		"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+			return neffos.Reply([]byte("answer"))
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.JoinRoom(context.TODO(), "room"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(expected string) {
+		t.Helper()
+
+		select {
+		case got := <-received:
+			if expected != got {
+				t.Fatalf("expected: %s but got: %s", expected, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected: %s", expected)
+		}
+	}
+
+	serverA.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify", Body: []byte("data")})
+	expect(":data")
+
+	serverA.Broadcast(nil, neffos.Message{Namespace: namespace, Room: "room", Event: "notify", Body: []byte("room data")})
+	expect("room:room data")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	response, err := serverA.Ask(ctx, neffos.Message{Namespace: namespace, Event: "ask"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "answer", string(response.Body); expected != got {
+		t.Fatalf("expected response: %s but got: %s", expected, got)
+	}
+
+	serverA.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify", Body: make([]byte, 2048)})
+	select {
+	case err := <-errs:
+		if _, ok := err.(*MessageTooLargeError); !ok {
+			t.Fatalf("expected a *MessageTooLargeError but got: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected a *MessageTooLargeError")
+	}
+
+	select {
+	case got := <-received:
+		t.Fatalf("expected no more messages but got: %s", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
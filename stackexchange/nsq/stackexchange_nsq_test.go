@@ -0,0 +1,28 @@
+package nsq
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStackExchangeInvalidTopicPrefix(t *testing.T) {
+	for _, prefix := range []string{"app/chat", "app#ephemeral", strings.Repeat("a", 61)} {
+		if _, err := NewStackExchange(Config{TopicPrefix: prefix}); err == nil {
+			t.Fatalf("[%s] expected an error", prefix)
+		}
+	}
+}
+
+func TestStackExchangeMessageTooLarge(t *testing.T) {
+	exc := &StackExchange{cfg: Config{TopicPrefix: "app", MaxMessageSize: 8}}
+
+	err := exc.publish("app", [][]byte{[]byte("small"), []byte("too large")})
+	tooLarge, ok := err.(*MessageTooLargeError)
+	if !ok {
+		t.Fatalf("expected a *MessageTooLargeError but got: %v", err)
+	}
+
+	if tooLarge.Size != 9 || tooLarge.MaxSize != 8 {
+		t.Fatalf("expected size: 9 of max 8 but got: %d of max %d", tooLarge.Size, tooLarge.MaxSize)
+	}
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/kataras/neffos"
+)
+
+// newTestStackExchange returns a StackExchange which is not connected to a database,
+// for the paths which do not reach it.
+func newTestStackExchange(cfg Config) (*StackExchange, *[]error) {
+	exc := &StackExchange{
+		cfg:   cfg,
+		conns: make(map[*neffos.Conn]map[string]struct{}),
+		asks:  make(map[string]chan neffos.Message),
+	}
+
+	reported := new([]error)
+	exc.SetErrorHandler(func(err error, recovered bool) {
+		if !recovered {
+			*reported = append(*reported, err)
+		}
+	})
+
+	return exc, reported
+}
+
+func TestStackExchangeInlineNotification(t *testing.T) {
+	// the base64 of 3 bytes is 4 bytes long.
+	fits := (maxNotificationPayload - len("m:token:")) / 4 * 3
+
+	payload, ok := inlineNotification("token", make([]byte, fits))
+	if !ok {
+		t.Fatalf("expected a message of %d bytes to fit to a notification", fits)
+	}
+	if !strings.HasPrefix(payload, kindInline+":token:") || len(payload) > maxNotificationPayload {
+		t.Fatalf("expected an inline notification of at most %d bytes but got %d bytes", maxNotificationPayload, len(payload))
+	}
+
+	if _, ok = inlineNotification("token", make([]byte, fits+3)); ok {
+		t.Fatalf("expected a message of %d bytes to be overflowed to the table", fits+3)
+	}
+}
+
+func TestStackExchangePayloadTooLarge(t *testing.T) {
+	exc, reported := newTestStackExchange(Config{MaxMessageSize: 8})
+
+	if err := exc.notify("", make([]byte, 9)); err != ErrPayloadTooLarge {
+		t.Fatalf("expected the error: %v but got: %v", ErrPayloadTooLarge, err)
+	}
+
+	msg := neffos.Message{Namespace: "default", Event: "chat", Body: make([]byte, 16)}
+	if exc.Publish([]neffos.Message{msg}) {
+		t.Fatalf("expected the large message to be dropped")
+	}
+
+	if len(*reported) != 1 || (*reported)[0] != ErrPayloadTooLarge {
+		t.Fatalf("expected the reported error: %v but got: %v", ErrPayloadTooLarge, *reported)
+	}
+}
+
+func TestStackExchangeHandleNotification(t *testing.T) {
+	exc, reported := newTestStackExchange(Config{})
+
+	ch := make(chan neffos.Message, 1)
+	exc.asks["token"] = ch
+
+	// malformed and unknown payloads are ignored.
+	for _, payload := range []string{"", "m", "m:token", "x:token:data"} {
+		exc.handleNotification(payload)
+	}
+	if len(*reported) != 0 || len(ch) != 0 {
+		t.Fatalf("expected the malformed payloads to be ignored but got: %v", *reported)
+	}
+
+	// a corrupted inline message is reported.
+	exc.handleNotification("m:token:!")
+	if len(*reported) != 1 || len(ch) != 0 {
+		t.Fatalf("expected the corrupted payload to be reported but got: %v", *reported)
+	}
+
+	// the reply of an ask is delivered to its waiter.
+	msg := neffos.Message{Namespace: "default", Event: "ask", Body: []byte("answer")}
+	exc.handleNotification("m:token:" + base64.StdEncoding.EncodeToString(msg.SerializeExchange()))
+
+	select {
+	case got := <-ch:
+		if expected := "answer"; string(got.Body) != expected {
+			t.Fatalf("expected the reply: %s but got: %s", expected, got.Body)
+		}
+	default:
+		t.Fatalf("expected the reply to be delivered to the ask")
+	}
+
+	// the reply of an unknown ask is dropped.
+	exc.handleNotification("m:other:" + base64.StdEncoding.EncodeToString(msg.SerializeExchange()))
+	if len(ch) != 0 {
+		t.Fatalf("expected the reply of an unknown ask to be dropped")
+	}
+}
@@ -1,13 +1,21 @@
 package redis
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kataras/neffos"
 
+	uuid "github.com/iris-contrib/go.uuid"
 	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/trace"
 )
 
 // Config is used on the `StackExchange` package-level function.
@@ -17,34 +25,123 @@ type Config struct {
 	// Defaults to "tcp".
 	Network string
 	// Addr of a single redis server instance.
-	// See "Clusters" field for clusters support.
+	// See "Clusters" field for clusters support
+	// and "SentinelAddrs" for sentinel support.
 	// Defaults to "127.0.0.1:6379".
 	Addr string
 	// Clusters a list of network addresses for clusters.
 	// If not empty "Addr" is ignored.
 	Clusters []string
-
-	Password    string
+	// SentinelAddrs a list of network addresses of sentinels,
+	// the connections are made to the current primary of the "SentinelMasterName".
+	// If not empty "Addr" is ignored.
+	SentinelAddrs []string
+	// SentinelMasterName is the name of the primary which is monitored by the "SentinelAddrs".
+	SentinelMasterName string
+
+	// Username is the ACL user of the connections (redis 6+), it requires the Password.
+	// Defaults to empty, the default user.
+	Username string
+	Password string
+	// TLSConfig, if not nil, makes the connections over TLS,
+	// i.e with the client certificates of a managed redis server.
+	// It's used by the connection pool, the subscribers and the cluster's nodes.
+	TLSConfig *tls.Config
+
+	// DialTimeout is the timeout of the connect, read and write operations of a connection,
+	// see ReadTimeout and WriteTimeout to override the latter.
 	DialTimeout time.Duration
+	// ReadTimeout is the timeout of a single read of a connection.
+	// Defaults to the DialTimeout.
+	ReadTimeout time.Duration
+	// WriteTimeout is the timeout of a single write of a connection.
+	// Defaults to the DialTimeout.
+	WriteTimeout time.Duration
+	// DB is the database which is selected on each connection,
+	// it's not allowed for clusters.
+	// Defaults to 0.
+	DB int
+
+	// ChannelPrefix is prepended to every channel and key which is created by the stackexchanges,
+	// i.e a mandatory key prefix of a shared redis server.
+	// Instances with different prefixes do not receive each other's messages.
+	//
+	// Defaults to empty.
+	ChannelPrefix string
 
 	// MaxActive defines the size connection pool.
 	// Defaults to 10.
 	MaxActive int
+	// MinIdle is the number of the pooled connections which are always kept open,
+	// the idle connections over it, up to MaxActive, are closed one per second.
+	// Defaults to MaxActive.
+	MinIdle int
+
+	// HealthCheckInterval is the interval of the PING commands of the `StackExchange`
+	// which detect a lost redis server, see `Server.OnStackExchangeError`.
+	// Defaults to 5 seconds, a negative value disables the health checks.
+	HealthCheckInterval time.Duration
+	// MaxReconnectBackoff is the maximum delay between the reconnect attempts of the subscribers
+	// of the `StackExchange`, the delay starts from 200ms and doubles on each failed attempt.
+	// Defaults to 10 seconds.
+	MaxReconnectBackoff time.Duration
+
+	// PerRoom enables the room-level channels of the `StackExchange`.
+	// The messages of a room are published to their own channel
+	// and a server instance subscribes to it only while at least one of its connections is joined,
+	// instead of receiving the whole traffic of the namespace on each one of its connections.
+	// All server instances of the same channel should use the same mode.
+	// It is ignored by the `StreamsStackExchange`.
+	//
+	// Defaults to false, the namespace-level channels.
+	PerRoom bool
+
+	// PresenceTTL enables the cluster presence of the stackexchanges, see `neffos.Server.ClusterLookup`.
+	// The connections of each server instance are registered to redis keys which expire after PresenceTTL
+	// unless the instance refreshes them, every third of the PresenceTTL,
+	// so the records of a crashed instance are removed.
+	//
+	// Defaults to 0, disabled.
+	PresenceTTL time.Duration
 }
 
 // StackExchange is a `neffos.StackExchange` for redis.
 type StackExchange struct {
+	// the Config.ChannelPrefix, the channel includes it.
+	prefix  string
 	channel string
 
-	pool     *radix.Pool
-	connFunc radix.ConnFunc
+	pool      *radix.Pool
+	poolStats *poolStats
+	connFunc  radix.ConnFunc
+
+	health neffos.StackExchangeHealth
+	// non-nil if the cluster presence is enabled, see `Config.PresenceTTL`.
+	*presence
+	// consecutive failed dials of the subscribers, see `dialSubscriber`.
+	dialFailures        uint32
+	maxReconnectBackoff time.Duration
 
 	subscribers map[*neffos.Conn]*subscriber
 
+	// per-room mode, see `Config.PerRoom`.
+	perRoom    bool
+	roomPubSub radix.PubSubConn
+	roomMsgCh  chan radix.PubSubMessage
+	// the connections of each namespace, receivers of the room messages.
+	local   map[string]map[*neffos.Conn]struct{}
+	localMu sync.RWMutex
+
 	addSubscriber chan *subscriber
 	subscribe     chan subscribeAction
 	unsubscribe   chan unsubscribeAction
 	delSubscriber chan closeAction
+
+	// closed by `Close`, the run loop exits on it and closes runDone.
+	closed    chan struct{}
+	runDone   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
 }
 
 type (
@@ -52,6 +149,7 @@ type (
 		conn   *neffos.Conn
 		pubSub radix.PubSubConn
 		msgCh  chan<- radix.PubSubMessage
+		askCh  chan<- radix.PubSubMessage
 	}
 
 	subscribeAction struct {
@@ -69,45 +167,283 @@ type (
 	}
 )
 
-var _ neffos.StackExchange = (*StackExchange)(nil)
+var (
+	_ neffos.StackExchange        = (*StackExchange)(nil)
+	_ neffos.OriginStackExchange  = (*StackExchange)(nil)
+	_ neffos.AskableStackExchange = (*StackExchange)(nil)
+	_ neffos.RoomStackExchange    = (*StackExchange)(nil)
+
+	_ neffos.StackExchangeErrorReporter = (*StackExchange)(nil)
+	_ neffos.PresenceStackExchange      = (*StackExchange)(nil)
+	_ neffos.StackExchangePoolReporter  = (*StackExchange)(nil)
+	_ neffos.ClosableStackExchange      = (*StackExchange)(nil)
+	_ neffos.PingableStackExchange      = (*StackExchange)(nil)
+)
+
+// errClosed is returned by the `OnConnect` of a closed stackexchange.
+var errClosed = errors.New("redis: stackexchange is closed")
 
 // NewStackExchange returns a new redis StackExchange.
 // The "channel" input argument is the channel prefix for publish and subscribe.
 func NewStackExchange(cfg Config, channel string) (*StackExchange, error) {
+	pool, stats, connFunc, err := dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	exc := &StackExchange{
+		pool:      pool,
+		poolStats: stats,
+		connFunc:  connFunc,
+		// If you are using one redis server for multiple nefos servers,
+		// use a different channel for each neffos server.
+		// Otherwise a message sent from one server to all of its own clients will go
+		// to all clients of all nefos servers that use the redis server.
+		// We could use multiple channels but overcomplicate things here.
+		prefix:  cfg.ChannelPrefix,
+		channel: cfg.ChannelPrefix + channel,
+
+		subscribers:   make(map[*neffos.Conn]*subscriber),
+		addSubscriber: make(chan *subscriber),
+		delSubscriber: make(chan closeAction),
+		subscribe:     make(chan subscribeAction),
+		unsubscribe:   make(chan unsubscribeAction),
+		closed:        make(chan struct{}),
+		runDone:       make(chan struct{}),
+
+		maxReconnectBackoff: cfg.MaxReconnectBackoff,
+	}
+
+	if exc.maxReconnectBackoff <= 0 {
+		exc.maxReconnectBackoff = 10 * time.Second
+	}
+
+	if cfg.PresenceTTL > 0 {
+		exc.presence = newPresence(pool, exc.channel+".presence", cfg.PresenceTTL, exc.fail)
+	}
+
+	if cfg.PerRoom {
+		exc.perRoom = true
+		exc.roomPubSub = radix.PersistentPubSub("", "", exc.dialSubscriber)
+		exc.roomMsgCh = make(chan radix.PubSubMessage)
+		exc.local = make(map[string]map[*neffos.Conn]struct{})
+		go exc.runRooms()
+	}
+
+	go exc.run()
+
+	if cfg.HealthCheckInterval == 0 {
+		cfg.HealthCheckInterval = 5 * time.Second
+	}
+
+	if cfg.HealthCheckInterval > 0 {
+		go exc.checkHealth(cfg.HealthCheckInterval)
+	}
+
+	return exc, nil
+}
+
+// SetErrorHandler registers the handler of the connection errors and their recoveries,
+// it's called automatically by the `Server.UseStackExchange`.
+// Only the first failure of an outage is reported.
+func (exc *StackExchange) SetErrorHandler(handler func(err error, recovered bool)) {
+	exc.health.SetErrorHandler(handler)
+}
+
+// fail reports the "err" if there is no failure reported since the last recovery.
+func (exc *StackExchange) fail(err error) {
+	if exc.health.Healthy() {
+		exc.health.Fail(err)
+	}
+}
+
+// PoolStats returns a snapshot of the statistics of the connection pool,
+// see `neffos.InstrumentStackExchange`.
+func (exc *StackExchange) PoolStats() neffos.StackExchangePoolStats {
+	return exc.poolStats.snapshot(exc.pool)
+}
+
+// checkHealth pings the redis server every "interval".
+func (exc *StackExchange) checkHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-exc.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if err := exc.pool.Do(radix.Cmd(nil, "PING")); err != nil {
+			exc.fail(err)
+			continue
+		}
+
+		if atomic.LoadUint32(&exc.dialFailures) == 0 {
+			exc.health.Recover()
+		}
+	}
+}
+
+// Ping sends a PING to the redis server, see `neffos.Server.Health`.
+func (exc *StackExchange) Ping(ctx context.Context) error {
+	return ping(ctx, exc.pool)
+}
+
+// ping sends a PING through the "pool", it returns the "ctx" error if it's done before the reply.
+func ping(ctx context.Context, pool *radix.Pool) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- pool.Do(radix.Cmd(nil, "PING"))
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// dialSubscriber is the dialer of the subscribers, the redis client re-dials and re-subscribes
+// when their connection is lost. The failed attempts are delayed with an exponential backoff.
+func (exc *StackExchange) dialSubscriber(network, addr string) (radix.Conn, error) {
+	if failures := atomic.LoadUint32(&exc.dialFailures); failures > 0 {
+		backoff := exc.maxReconnectBackoff
+		if failures < 16 {
+			if d := 200 * time.Millisecond << (failures - 1); d < backoff {
+				backoff = d
+			}
+		}
+		time.Sleep(backoff)
+	}
+
+	conn, err := exc.connFunc(network, addr)
+	if err != nil {
+		atomic.AddUint32(&exc.dialFailures, 1)
+		exc.fail(err)
+		return nil, err
+	}
+
+	atomic.StoreUint32(&exc.dialFailures, 0)
+	exc.health.Recover()
+	return conn, nil
+}
+
+// dial returns the connection pool, its statistics and the connection dialer of the "cfg".
+// The "cfg" is validated before any connection is made.
+func dial(cfg Config) (*radix.Pool, *poolStats, radix.ConnFunc, error) {
 	if cfg.Network == "" {
 		cfg.Network = "tcp"
 	}
 
-	if cfg.Addr == "" && len(cfg.Clusters) == 0 {
+	if cfg.Addr == "" && len(cfg.Clusters) == 0 && len(cfg.SentinelAddrs) == 0 {
 		cfg.Addr = "127.0.0.1:6379"
 	}
 
+	if len(cfg.Clusters) > 0 && len(cfg.SentinelAddrs) > 0 {
+		return nil, nil, nil, errors.New("redis: clusters and sentinels cannot be used together")
+	}
+
+	if len(cfg.SentinelAddrs) > 0 && cfg.SentinelMasterName == "" {
+		return nil, nil, nil, errors.New("redis: sentinels require a master name")
+	}
+
+	if len(cfg.Clusters) > 0 && cfg.DB != 0 {
+		return nil, nil, nil, errors.New("redis: database selection is not allowed for clusters")
+	}
+
+	if cfg.Username != "" && cfg.Password == "" {
+		return nil, nil, nil, errors.New("redis: username requires a password")
+	}
+
+	if cfg.TLSConfig != nil && cfg.Network == "unix" {
+		return nil, nil, nil, errors.New("redis: TLS is not supported over unix sockets")
+	}
+
+	if cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 {
+		return nil, nil, nil, errors.New("redis: read and write timeouts cannot be negative")
+	}
+
 	if cfg.DialTimeout < 0 {
 		cfg.DialTimeout = 30 * time.Second
 	}
 
+	if cfg.MaxActive < 0 {
+		return nil, nil, nil, errors.New("redis: pool size cannot be negative")
+	}
+
 	if cfg.MaxActive == 0 {
 		cfg.MaxActive = 10
 	}
 
+	if cfg.MinIdle < 0 || cfg.MinIdle > cfg.MaxActive {
+		return nil, nil, nil, errors.New("redis: min idle connections should be between 0 and the pool size")
+	}
+
+	if cfg.MinIdle == 0 {
+		cfg.MinIdle = cfg.MaxActive
+	}
+
 	var dialOptions []radix.DialOpt
 
-	if cfg.Password != "" {
+	if cfg.Username != "" {
+		dialOptions = append(dialOptions, radix.DialAuthUser(cfg.Username, cfg.Password))
+	} else if cfg.Password != "" {
 		dialOptions = append(dialOptions, radix.DialAuthPass(cfg.Password))
 	}
 
+	if cfg.TLSConfig != nil {
+		dialOptions = append(dialOptions, radix.DialUseTLS(cfg.TLSConfig))
+	}
+
 	if cfg.DialTimeout > 0 {
 		dialOptions = append(dialOptions, radix.DialTimeout(cfg.DialTimeout))
 	}
 
+	// after the DialTimeout, they override its read and write timeouts.
+	if cfg.ReadTimeout > 0 {
+		dialOptions = append(dialOptions, radix.DialReadTimeout(cfg.ReadTimeout))
+	}
+
+	if cfg.WriteTimeout > 0 {
+		dialOptions = append(dialOptions, radix.DialWriteTimeout(cfg.WriteTimeout))
+	}
+
+	if cfg.DB != 0 {
+		dialOptions = append(dialOptions, radix.DialSelectDB(cfg.DB))
+	}
+
 	var connFunc radix.ConnFunc
 
-	if len(cfg.Clusters) > 0 {
-		cluster, err := radix.NewCluster(cfg.Clusters)
+	if len(cfg.SentinelAddrs) > 0 {
+		sentinel, err := radix.NewSentinel(cfg.SentinelMasterName, cfg.SentinelAddrs,
+			radix.SentinelPoolFunc(func(network, addr string) (radix.Client, error) {
+				return radix.NewPool(cfg.Network, addr, 1, radix.PoolConnFunc(func(_, addr string) (radix.Conn, error) {
+					return radix.Dial(cfg.Network, addr, dialOptions...)
+				}))
+			}))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		connFunc = func(network, addr string) (radix.Conn, error) {
+			// follow a failover.
+			primary, _ := sentinel.Addrs()
+			return radix.Dial(cfg.Network, primary, dialOptions...)
+		}
+	} else if len(cfg.Clusters) > 0 {
+		cluster, err := radix.NewCluster(cfg.Clusters,
+			radix.ClusterPoolFunc(func(network, addr string) (radix.Client, error) {
+				return radix.NewPool(network, addr, 1, radix.PoolConnFunc(func(network, addr string) (radix.Conn, error) {
+					return radix.Dial(network, addr, dialOptions...)
+				}))
+			}))
 		if err != nil {
 			// maybe an
 			// ERR This instance has cluster support disabled
-			return nil, err
+			return nil, nil, nil, err
 		}
 
 		connFunc = func(network, addr string) (radix.Conn, error) {
@@ -121,31 +457,56 @@ func NewStackExchange(cfg Config, channel string) (*StackExchange, error) {
 		}
 	}
 
-	pool, err := radix.NewPool("", "", cfg.MaxActive, radix.PoolConnFunc(connFunc))
+	stats := &poolStats{size: cfg.MaxActive}
+	poolOptions := []radix.PoolOpt{radix.PoolConnFunc(connFunc), radix.PoolWithTrace(stats.trace())}
+	if cfg.MinIdle < cfg.MaxActive {
+		// the connections over the MinIdle are kept in the overflow buffer which is drained while idle.
+		poolOptions = append(poolOptions, radix.PoolOnFullBuffer(cfg.MaxActive-cfg.MinIdle, time.Second))
+	}
+
+	pool, err := radix.NewPool("", "", cfg.MinIdle, poolOptions...)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
-	exc := &StackExchange{
-		pool:     pool,
-		connFunc: connFunc,
-		// If you are using one redis server for multiple nefos servers,
-		// use a different channel for each neffos server.
-		// Otherwise a message sent from one server to all of its own clients will go
-		// to all clients of all nefos servers that use the redis server.
-		// We could use multiple channels but overcomplicate things here.
-		channel: channel,
+	return pool, stats, connFunc, nil
+}
 
-		subscribers:   make(map[*neffos.Conn]*subscriber),
-		addSubscriber: make(chan *subscriber),
-		delSubscriber: make(chan closeAction),
-		subscribe:     make(chan subscribeAction),
-		unsubscribe:   make(chan unsubscribeAction),
+// poolStats keeps the statistics of a connection pool, see `StackExchange.PoolStats`.
+type poolStats struct {
+	size int
+
+	created    uint64
+	closed     uint64
+	dialErrors uint64
+}
+
+func (st *poolStats) trace() trace.PoolTrace {
+	return trace.PoolTrace{
+		ConnCreated: func(created trace.PoolConnCreated) {
+			if created.Err != nil {
+				atomic.AddUint64(&st.dialErrors, 1)
+				return
+			}
+			atomic.AddUint64(&st.created, 1)
+		},
+		ConnClosed: func(trace.PoolConnClosed) {
+			atomic.AddUint64(&st.closed, 1)
+		},
 	}
+}
 
-	go exc.run()
+func (st *poolStats) snapshot(pool *radix.Pool) neffos.StackExchangePoolStats {
+	created, closed := atomic.LoadUint64(&st.created), atomic.LoadUint64(&st.closed)
 
-	return exc, nil
+	return neffos.StackExchangePoolStats{
+		Size:       st.size,
+		Idle:       pool.NumAvailConns(),
+		Open:       int(created - closed),
+		Created:    created,
+		Closed:     closed,
+		DialErrors: atomic.LoadUint64(&st.dialErrors),
+	}
 }
 
 func (exc *StackExchange) run() {
@@ -173,12 +534,41 @@ func (exc *StackExchange) run() {
 				// neffos.Debugf("[%s] disconnected", m.conn.ID())
 				sub.pubSub.Close()
 				close(sub.msgCh)
+				close(sub.askCh)
 				delete(exc.subscribers, m.conn)
 			}
+		case <-exc.closed:
+			for c, sub := range exc.subscribers {
+				sub.pubSub.Close()
+				close(sub.msgCh)
+				close(sub.askCh)
+				delete(exc.subscribers, c)
+			}
+			close(exc.runDone)
+			return
 		}
 	}
 }
 
+// Close stops the deliveries to the local connections, their subscribers are closed,
+// and closes the connection pool. It's called automatically by the `Server.Shutdown`.
+func (exc *StackExchange) Close() error {
+	exc.closeOnce.Do(func() {
+		exc.presence.leaveAll()
+
+		if exc.perRoom {
+			exc.roomPubSub.Close()
+		}
+
+		close(exc.closed)
+		<-exc.runDone
+
+		exc.closeErr = exc.pool.Close()
+	})
+
+	return exc.closeErr
+}
+
 func (exc *StackExchange) getChannel(namespace, room, connID string) string {
 	if connID != "" {
 		// publish direct and let the server-side do the checks
@@ -194,6 +584,67 @@ func (exc *StackExchange) getChannel(namespace, room, connID string) string {
 	return exc.channel + "." + namespace + "."
 }
 
+// getMessageChannel returns the channel which the "msg" should be published to.
+func (exc *StackExchange) getMessageChannel(msg neffos.Message) string {
+	if exc.perRoom && msg.To == "" && msg.Room != "" {
+		return exc.getRoomChannel(msg.Namespace, msg.Room)
+	}
+
+	return exc.getChannel(msg.Namespace, msg.Room, msg.To)
+}
+
+// getRoomChannel returns the channel of a namespace's room on per-room mode.
+func (exc *StackExchange) getRoomChannel(namespace, room string) string {
+	return exc.channel + "." + namespace + ":" + room + "."
+}
+
+// runRooms delivers the room messages to the local connections of their namespace,
+// the connection's write drops them if it's not joined to the room.
+func (exc *StackExchange) runRooms() {
+	for {
+		var redisMsg radix.PubSubMessage
+		select {
+		case <-exc.closed:
+			return
+		case redisMsg = <-exc.roomMsgCh:
+		}
+
+		msg := neffos.DeserializeExchangeMessage(redisMsg.Message)
+
+		exc.localMu.RLock()
+		receivers := make([]*neffos.Conn, 0, len(exc.local[msg.Namespace]))
+		for c := range exc.local[msg.Namespace] {
+			receivers = append(receivers, c)
+		}
+		exc.localMu.RUnlock()
+
+		for _, c := range receivers {
+			c.Write(c.DeserializeExchangeMessage(redisMsg.Message))
+		}
+	}
+}
+
+// SubscribeRoom subscribes this server instance to a namespace's room on per-room mode,
+// it's called automatically when the first connection of this server joins the room.
+func (exc *StackExchange) SubscribeRoom(namespace, room string) {
+	if exc.perRoom {
+		exc.roomPubSub.Subscribe(exc.roomMsgCh, exc.getRoomChannel(namespace, room))
+	}
+}
+
+// UnsubscribeRoom unsubscribes this server instance from a namespace's room on per-room mode,
+// it's called automatically when the last connection of this server leaves the room.
+func (exc *StackExchange) UnsubscribeRoom(namespace, room string) {
+	if exc.perRoom {
+		exc.roomPubSub.Unsubscribe(exc.roomMsgCh, exc.getRoomChannel(namespace, room))
+	}
+}
+
+// getAskChannel returns the channel of the `AskConn` requests of a connection.
+func (exc *StackExchange) getAskChannel(connID string) string {
+	return exc.channel + "." + connID + ".ask"
+}
+
 // OnConnect prepares the connection redis subscriber
 // and subscribes to itself for direct neffos messages.
 // It's called automatically after the neffos server's OnConnect (if any)
@@ -203,46 +654,79 @@ func (exc *StackExchange) OnConnect(c *neffos.Conn) error {
 	go func() {
 		for redisMsg := range redisMsgCh {
 			// neffos.Debugf("[%s] send to client: [%s]", c.ID(), string(redisMsg.Message))
-			msg := c.DeserializeMessage(neffos.TextMessage, redisMsg.Message)
-			msg.FromStackExchange = true
+			msg := c.DeserializeExchangeMessage(redisMsg.Message)
 
 			c.Write(msg)
 		}
 	}()
 
-	pubSub := radix.PersistentPubSub("", "", exc.connFunc)
+	redisAskCh := make(chan radix.PubSubMessage)
+	go func() {
+		for redisMsg := range redisAskCh {
+			go exc.answer(c, redisMsg.Message)
+		}
+	}()
+
+	pubSub := radix.PersistentPubSub("", "", exc.dialSubscriber)
 	s := &subscriber{
 		conn:   c,
 		pubSub: pubSub,
 		msgCh:  redisMsgCh,
+		askCh:  redisAskCh,
 	}
 	selfChannel := exc.getChannel("", "", c.ID())
 	pubSub.PSubscribe(redisMsgCh, selfChannel)
+	pubSub.Subscribe(redisAskCh, exc.getAskChannel(c.ID()))
 
-	exc.addSubscriber <- s
+	select {
+	case <-exc.closed:
+		pubSub.Close()
+		close(redisMsgCh)
+		close(redisAskCh)
+		return errClosed
+	case exc.addSubscriber <- s:
+	}
 
 	return nil
 }
 
+// PreservesOrigin implements the `neffos.OriginStackExchange`,
+// the envelopes of the messages are published through redis as they are.
+func (exc *StackExchange) PreservesOrigin() bool {
+	return true
+}
+
 // Publish publishes messages through redis.
 // It's called automatically on neffos broadcasting.
+// The PUBLISH commands of many messages are pipelined, see `neffos.Server.SetStackExchangeBatch`.
 func (exc *StackExchange) Publish(msgs []neffos.Message) bool {
+	if len(msgs) == 1 {
+		return exc.publish(msgs[0])
+	}
+
+	cmds := make([]radix.CmdAction, 0, len(msgs))
 	for _, msg := range msgs {
-		if !exc.publish(msg) {
-			return false
-		}
+		cmds = append(cmds, radix.FlatCmd(nil, "PUBLISH", exc.getMessageChannel(msg), msg.SerializeExchange()))
+	}
+
+	if err := exc.pool.Do(radix.Pipeline(cmds...)); err != nil {
+		exc.fail(err)
+		return false
 	}
 
 	return true
 }
 
 func (exc *StackExchange) publish(msg neffos.Message) bool {
-	// channel := exc.getMessageChannel(c.ID(), msg)
-	channel := exc.getChannel(msg.Namespace, msg.Room, msg.To)
-	// neffos.Debugf("[%s] publish to channel [%s] the data [%s]\n", msg.FromExplicit, channel, string(msg.Serialize()))
+	channel := exc.getMessageChannel(msg)
+	// neffos.Debugf("[%s] publish to channel [%s] the data [%s]\n", msg.FromExplicit, channel, string(msg.SerializeExchange()))
+
+	if err := exc.publishCommand(channel, msg.SerializeExchange()); err != nil {
+		exc.fail(err)
+		return false
+	}
 
-	err := exc.publishCommand(channel, msg.Serialize())
-	return err == nil
+	return true
 }
 
 func (exc *StackExchange) publishCommand(channel string, b []byte) error {
@@ -251,8 +735,77 @@ func (exc *StackExchange) publishCommand(channel string, b []byte) error {
 }
 
 // Ask implements the server Ask feature for redis. It blocks until response.
-func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (response neffos.Message, err error) {
-	sub := radix.PersistentPubSub("", "", exc.connFunc)
+func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (neffos.Message, error) {
+	return ask(ctx, exc.connFunc, exc.prefix+token, func() error {
+		if !exc.publish(msg) {
+			return neffos.ErrWrite
+		}
+		return nil
+	})
+}
+
+// AskConn publishes the "msg" to the ask channel of the "connID" connection
+// and blocks until its response. The server instance which owns the connection
+// performs the `neffos.Conn.Ask` and publishes its response, see `answer`.
+// It returns the `neffos.ErrConnNotFound` if no server instance is subscribed to that channel.
+func (exc *StackExchange) AskConn(ctx context.Context, connID string, msg neffos.Message) (neffos.Message, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return neffos.Message{}, err
+	}
+
+	var deadline int64
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d.UnixNano()
+	}
+
+	msg.To = connID
+	// token;deadline;message
+	payload := append([]byte(token.String()+";"+strconv.FormatInt(deadline, 10)+";"), msg.SerializeExchange()...)
+
+	return ask(ctx, exc.connFunc, exc.prefix+token.String(), func() error {
+		var receivers int
+		if err := exc.pool.Do(radix.FlatCmd(&receivers, "PUBLISH", exc.getAskChannel(connID), payload)); err != nil {
+			return err
+		}
+
+		if receivers == 0 {
+			return neffos.ErrConnNotFound
+		}
+
+		return nil
+	})
+}
+
+// answer performs the `neffos.Conn.Ask` of an `AskConn` request's "payload"
+// and publishes its response to the request's token.
+func (exc *StackExchange) answer(c *neffos.Conn, payload []byte) {
+	parts := bytes.SplitN(payload, []byte(";"), 3)
+	if len(parts) != 3 {
+		return
+	}
+
+	token := string(parts[0])
+
+	ctx := context.Background()
+	if deadline, _ := strconv.ParseInt(string(parts[1]), 10, 64); deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, deadline))
+		defer cancel()
+	}
+
+	response, err := c.Ask(ctx, c.DeserializeExchangeMessage(parts[2]))
+	if err != nil {
+		response = neffos.Message{Err: err}
+	}
+
+	notifyAsk(exc.pool, response, exc.prefix+token)
+}
+
+// ask subscribes to the "token" channel, calls the "publish" and blocks until
+// the response is published to that channel, see `notifyAsk`.
+func ask(ctx context.Context, connFunc radix.ConnFunc, token string, publish func() error) (response neffos.Message, err error) {
+	sub := radix.PersistentPubSub("", "", connFunc)
 	msgCh := make(chan radix.PubSubMessage)
 	err = sub.Subscribe(msgCh, token)
 	if err != nil {
@@ -260,15 +813,15 @@ func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token str
 	}
 	defer sub.Close()
 
-	if !exc.publish(msg) {
-		return response, neffos.ErrWrite
+	if err = publish(); err != nil {
+		return
 	}
 
 	select {
 	case <-ctx.Done():
 		err = ctx.Err()
 	case redisMsg := <-msgCh:
-		response = neffos.DeserializeMessage(neffos.TextMessage, redisMsg.Message, false, false)
+		response = neffos.DeserializeExchangeMessage(redisMsg.Message)
 		err = response.Err
 	}
 
@@ -277,25 +830,46 @@ func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token str
 
 // NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
 func (exc *StackExchange) NotifyAsk(msg neffos.Message, token string) error {
+	return notifyAsk(exc.pool, msg, exc.prefix+token)
+}
+
+func notifyAsk(pool *radix.Pool, msg neffos.Message, token string) error {
 	msg.ClearWait()
-	return exc.publishCommand(token, msg.Serialize())
+	return pool.Do(radix.FlatCmd(nil, "PUBLISH", token, msg.SerializeExchange()))
 }
 
 // Subscribe subscribes to a specific namespace,
 // it's called automatically on neffos namespace connected.
 func (exc *StackExchange) Subscribe(c *neffos.Conn, namespace string) {
-	exc.subscribe <- subscribeAction{
-		conn:      c,
-		namespace: namespace,
+	if exc.perRoom {
+		exc.localMu.Lock()
+		conns, ok := exc.local[namespace]
+		if !ok {
+			conns = make(map[*neffos.Conn]struct{})
+			exc.local[namespace] = conns
+		}
+		conns[c] = struct{}{}
+		exc.localMu.Unlock()
+	}
+
+	select {
+	case <-exc.closed:
+	case exc.subscribe <- subscribeAction{conn: c, namespace: namespace}:
 	}
 }
 
 // Unsubscribe unsubscribes from a specific namespace,
 // it's called automatically on neffos namespace disconnect.
 func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
-	exc.unsubscribe <- unsubscribeAction{
-		conn:      c,
-		namespace: namespace,
+	if exc.perRoom {
+		exc.localMu.Lock()
+		exc.removeLocal(c, namespace)
+		exc.localMu.Unlock()
+	}
+
+	select {
+	case <-exc.closed:
+	case exc.unsubscribe <- unsubscribeAction{conn: c, namespace: namespace}:
 	}
 }
 
@@ -306,5 +880,27 @@ func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
 // It's called automatically when a connection goes offline,
 // manually by server or client or by network failure.
 func (exc *StackExchange) OnDisconnect(c *neffos.Conn) {
-	exc.delSubscriber <- closeAction{conn: c}
+	if exc.perRoom {
+		exc.localMu.Lock()
+		for namespace := range exc.local {
+			exc.removeLocal(c, namespace)
+		}
+		exc.localMu.Unlock()
+	}
+
+	select {
+	case <-exc.closed:
+	case exc.delSubscriber <- closeAction{conn: c}:
+	}
+}
+
+// removeLocal removes a connection from the receivers of a namespace's room messages,
+// caller should hold the lock.
+func (exc *StackExchange) removeLocal(c *neffos.Conn, namespace string) {
+	if conns, ok := exc.local[namespace]; ok {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(exc.local, namespace)
+		}
+	}
 }
@@ -0,0 +1,279 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// presence is the cluster presence registry of the stackexchanges, see `Config.PresenceTTL`.
+// It's stored in the following keys:
+//
+//	<key>                       sorted set of the alive instances, scored by their expiration (unix milliseconds).
+//	<key>.{<instance>}.names    hash of the connection IDs and aliases of an instance to their number of connections.
+//	<key>.{<instance>}.total    the number of the connections of an instance.
+//
+// The keys of an instance expire after the TTL unless its heartbeat refreshes them,
+// the heartbeat runs every third of the TTL.
+type presence struct {
+	pool *radix.Pool
+	key  string
+	ttl  time.Duration
+	// reports the failed commands, optional.
+	fail func(err error)
+
+	mu sync.Mutex
+	// the heartbeat of each joined instance.
+	joined map[string]chan struct{}
+}
+
+var (
+	presenceConnectedScript = radix.NewEvalScript(2, `
+for i = 2, #ARGV do
+	redis.call('HINCRBY', KEYS[1], ARGV[i], 1)
+end
+redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[2], ARGV[1])
+return 1`)
+
+	presenceDisconnectedScript = radix.NewEvalScript(2, `
+for i = 2, #ARGV do
+	if redis.call('HINCRBY', KEYS[1], ARGV[i], -1) <= 0 then
+		redis.call('HDEL', KEYS[1], ARGV[i])
+	end
+end
+if redis.call('DECR', KEYS[2]) <= 0 then
+	redis.call('DEL', KEYS[2])
+end
+return 1`)
+)
+
+func newPresence(pool *radix.Pool, key string, ttl time.Duration, fail func(err error)) *presence {
+	return &presence{
+		pool:   pool,
+		key:    key,
+		ttl:    ttl,
+		fail:   fail,
+		joined: make(map[string]chan struct{}),
+	}
+}
+
+func (p *presence) namesKey(instanceID string) string {
+	return p.key + ".{" + instanceID + "}.names"
+}
+
+func (p *presence) totalKey(instanceID string) string {
+	return p.key + ".{" + instanceID + "}.total"
+}
+
+func (p *presence) report(err error) {
+	if err != nil && p.fail != nil {
+		p.fail(err)
+	}
+}
+
+func (p *presence) isJoined(instanceID string) bool {
+	p.mu.Lock()
+	_, ok := p.joined[instanceID]
+	p.mu.Unlock()
+	return ok
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// PresenceJoin starts the heartbeat of the "instanceID" server instance,
+// it returns the `neffos.ErrPresenceUnsupported` if the `Config.PresenceTTL` is not set.
+func (p *presence) PresenceJoin(instanceID string) error {
+	if p == nil {
+		return neffos.ErrPresenceUnsupported
+	}
+
+	p.mu.Lock()
+	if _, ok := p.joined[instanceID]; ok {
+		p.mu.Unlock()
+		return nil
+	}
+	stop := make(chan struct{})
+	p.joined[instanceID] = stop
+	p.mu.Unlock()
+
+	p.report(p.refresh(instanceID))
+	go p.heartbeat(instanceID, stop)
+	return nil
+}
+
+func (p *presence) heartbeat(instanceID string, stop chan struct{}) {
+	ticker := time.NewTicker(p.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.report(p.refresh(instanceID))
+		}
+	}
+}
+
+// refresh extends the expiration of the "instanceID" records
+// and removes the expired instances from the alive ones.
+func (p *presence) refresh(instanceID string) error {
+	now := time.Now()
+	ttl := strconv.FormatInt(int64(p.ttl/time.Millisecond), 10)
+
+	return p.pool.Do(radix.Pipeline(
+		radix.FlatCmd(nil, "ZADD", p.key, unixMilli(now.Add(p.ttl)), instanceID),
+		radix.FlatCmd(nil, "ZREMRANGEBYSCORE", p.key, "-inf", unixMilli(now)),
+		radix.Cmd(nil, "PEXPIRE", p.key, ttl),
+		radix.Cmd(nil, "PEXPIRE", p.namesKey(instanceID), ttl),
+		radix.Cmd(nil, "PEXPIRE", p.totalKey(instanceID), ttl),
+	))
+}
+
+// PresenceLeave stops the heartbeat and removes the records of the "instanceID".
+func (p *presence) PresenceLeave(instanceID string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	stop, ok := p.joined[instanceID]
+	delete(p.joined, instanceID)
+	p.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	close(stop)
+	p.report(p.pool.Do(radix.Pipeline(
+		radix.Cmd(nil, "ZREM", p.key, instanceID),
+		radix.Cmd(nil, "DEL", p.namesKey(instanceID), p.totalKey(instanceID)),
+	)))
+}
+
+// leaveAll leaves all the joined instances, called on close.
+func (p *presence) leaveAll() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	instances := make([]string, 0, len(p.joined))
+	for instanceID := range p.joined {
+		instances = append(instances, instanceID)
+	}
+	p.mu.Unlock()
+
+	for _, instanceID := range instances {
+		p.PresenceLeave(instanceID)
+	}
+}
+
+// PresenceConnected registers the "connID" and its "aliases" to the "instanceID".
+func (p *presence) PresenceConnected(instanceID, connID string, aliases []string) {
+	if p == nil || !p.isJoined(instanceID) {
+		return
+	}
+
+	args := append([]string{p.namesKey(instanceID), p.totalKey(instanceID),
+		strconv.FormatInt(int64(p.ttl/time.Millisecond), 10), connID}, aliases...)
+	p.report(p.pool.Do(presenceConnectedScript.Cmd(nil, args...)))
+}
+
+// PresenceDisconnected removes a connection registered by the `PresenceConnected`.
+func (p *presence) PresenceDisconnected(instanceID, connID string, aliases []string) {
+	if p == nil || !p.isJoined(instanceID) {
+		return
+	}
+
+	args := append([]string{p.namesKey(instanceID), p.totalKey(instanceID),
+		strconv.FormatInt(int64(p.ttl/time.Millisecond), 10), connID}, aliases...)
+	p.report(p.pool.Do(presenceDisconnectedScript.Cmd(nil, args...)))
+}
+
+// alive returns the instances which are not expired.
+func (p *presence) alive() ([]string, error) {
+	var instances []string
+	err := p.pool.Do(radix.FlatCmd(&instances, "ZRANGEBYSCORE", p.key, unixMilli(time.Now()), "+inf"))
+	return instances, err
+}
+
+// PresenceLookup returns the alive instance which holds a connection of the "name" ID or alias.
+// The "ctx" is checked before the commands, they are not canceled by it.
+func (p *presence) PresenceLookup(ctx context.Context, name string) (string, bool, error) {
+	if p == nil {
+		return "", false, neffos.ErrPresenceUnsupported
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+
+	instances, err := p.alive()
+	if err != nil || len(instances) == 0 {
+		return "", false, err
+	}
+
+	exists := make([]int, len(instances))
+	cmds := make([]radix.CmdAction, len(instances))
+	for i, instanceID := range instances {
+		cmds[i] = radix.Cmd(&exists[i], "HEXISTS", p.namesKey(instanceID), name)
+	}
+
+	if err = p.pool.Do(radix.Pipeline(cmds...)); err != nil {
+		return "", false, err
+	}
+
+	for i, instanceID := range instances {
+		if exists[i] == 1 {
+			return instanceID, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// PresenceTotal returns the number of the connections of all the alive instances.
+func (p *presence) PresenceTotal(ctx context.Context) (int, error) {
+	if p == nil {
+		return 0, neffos.ErrPresenceUnsupported
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	instances, err := p.alive()
+	if err != nil || len(instances) == 0 {
+		return 0, err
+	}
+
+	keys := make([]string, len(instances))
+	for i, instanceID := range instances {
+		keys[i] = p.totalKey(instanceID)
+	}
+
+	var totals []string
+	if err = p.pool.Do(radix.Cmd(&totals, "MGET", keys...)); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, s := range totals {
+		if n, _ := strconv.Atoi(s); n > 0 {
+			total += n
+		}
+	}
+
+	return total, nil
+}
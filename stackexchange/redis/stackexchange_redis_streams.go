@@ -0,0 +1,462 @@
+package redis
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// StreamsConfig is used on the `NewStreamsStackExchange` package-level function.
+type StreamsConfig struct {
+	// Prefix is the key prefix of the streams,
+	// each namespace is published to its own stream, i.e "<Prefix>.<namespace>".
+	// The `Config.ChannelPrefix` is prepended to it.
+	// Defaults to "neffos".
+	Prefix string
+	// Group is the consumer group of this server instance, all groups receive all the messages.
+	// It should be unique per instance and stable across its restarts (e.g. a StatefulSet's pod name),
+	// so the messages published while the instance was down are delivered once it's up again.
+	// Defaults to the hostname.
+	Group string
+	// MaxLen caps the streams to approximately MaxLen entries,
+	// the oldest entries are evicted even if they are not delivered yet.
+	// Defaults to 10000, a negative value disables the cap.
+	MaxLen int64
+	// ClaimInterval is how often the pending (delivered but not acknowledged) entries of the Group are checked.
+	// Defaults to 30 seconds.
+	ClaimInterval time.Duration
+	// ClaimMinIdle is the minimum idle time of a pending entry before it's claimed and reprocessed,
+	// i.e the entries of a crashed instance's consumer.
+	// Defaults to 1 minute.
+	ClaimMinIdle time.Duration
+	// Block is the maximum duration that a read waits for new entries,
+	// it should be lower than the `Config.DialTimeout`.
+	// Defaults to 2 seconds.
+	Block time.Duration
+	// Count is the maximum number of entries of a single read.
+	// Defaults to 100.
+	Count int
+}
+
+// StreamsStackExchange is a `neffos.StackExchange` for redis
+// based on Streams and consumer groups, an alternative to the pub/sub `StackExchange`.
+//
+// Each server instance reads the streams as a consumer of its own `StreamsConfig.Group`
+// and acknowledges a message after its local delivery, so the messages published while an instance
+// is briefly disconnected are delivered once it's connected again. Pending entries
+// which are not acknowledged after the `StreamsConfig.ClaimMinIdle` (i.e of a crashed instance)
+// are claimed and delivered again.
+//
+// The delivery is at-least-once: a message may be delivered more than once,
+// the event callbacks should be idempotent. Enable the `Server.GenerateTraceID`
+// to get a unique `Message.TraceID` per broadcast which can be used to drop the duplicates.
+//
+// The streams are created on `Init`, use the `Server.UseStackExchange` to register it.
+// They are the streams of the declared namespaces only, so it can't be used with the `neffos.Namespaces#SetDynamic`.
+// The `Ask` and `NotifyAsk` are transferred through pub/sub, like the `StackExchange`'s.
+type StreamsStackExchange struct {
+	cfg      StreamsConfig
+	consumer string
+	// the Config.ChannelPrefix of the ask channels.
+	prefix string
+
+	pool      *radix.Pool
+	poolStats *poolStats
+	connFunc  radix.ConnFunc
+	// non-nil if the cluster presence is enabled, see `Config.PresenceTTL`.
+	*presence
+
+	streams []string
+
+	mu    sync.RWMutex
+	conns map[*neffos.Conn]map[string]struct{}
+
+	// closed by `Close`, the read and claim loops of the wg exit on it.
+	closed    chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+var (
+	_ neffos.StackExchange                 = (*StreamsStackExchange)(nil)
+	_ neffos.OriginStackExchange           = (*StreamsStackExchange)(nil)
+	_ neffos.StackExchangeInitializer      = (*StreamsStackExchange)(nil)
+	_ neffos.PresenceStackExchange         = (*StreamsStackExchange)(nil)
+	_ neffos.StackExchangePoolReporter     = (*StreamsStackExchange)(nil)
+	_ neffos.ClosableStackExchange         = (*StreamsStackExchange)(nil)
+	_ neffos.PingableStackExchange         = (*StreamsStackExchange)(nil)
+	_ neffos.StaticNamespacesStackExchange = (*StreamsStackExchange)(nil)
+)
+
+// the field of a stream entry which holds the message's exchange envelope.
+const streamMessageField = "m"
+
+// NewStreamsStackExchange returns a new redis Streams StackExchange,
+// see `StreamsStackExchange` for its delivery guarantees.
+func NewStreamsStackExchange(cfg Config, streamsCfg StreamsConfig) (*StreamsStackExchange, error) {
+	if streamsCfg.Prefix == "" {
+		streamsCfg.Prefix = "neffos"
+	}
+
+	if streamsCfg.Group == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		streamsCfg.Group = hostname
+	}
+
+	if streamsCfg.MaxLen == 0 {
+		streamsCfg.MaxLen = 10000
+	}
+
+	if streamsCfg.ClaimInterval <= 0 {
+		streamsCfg.ClaimInterval = 30 * time.Second
+	}
+
+	if streamsCfg.ClaimMinIdle <= 0 {
+		streamsCfg.ClaimMinIdle = time.Minute
+	}
+
+	if streamsCfg.Block <= 0 {
+		streamsCfg.Block = 2 * time.Second
+	}
+
+	if streamsCfg.Count <= 0 {
+		streamsCfg.Count = 100
+	}
+
+	pool, stats, connFunc, err := dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	streamsCfg.Prefix = cfg.ChannelPrefix + streamsCfg.Prefix
+
+	exc := &StreamsStackExchange{
+		cfg: streamsCfg,
+		// a new consumer per process, the entries of the previous one are claimed.
+		consumer:  streamsCfg.Group + "-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		prefix:    cfg.ChannelPrefix,
+		pool:      pool,
+		poolStats: stats,
+		connFunc:  connFunc,
+		conns:     make(map[*neffos.Conn]map[string]struct{}),
+		closed:    make(chan struct{}),
+	}
+
+	if cfg.PresenceTTL > 0 {
+		exc.presence = newPresence(pool, streamsCfg.Prefix+".presence", cfg.PresenceTTL, nil)
+	}
+
+	return exc, nil
+}
+
+func (exc *StreamsStackExchange) getStream(namespace string) string {
+	return exc.cfg.Prefix + "." + namespace
+}
+
+// PoolStats returns a snapshot of the statistics of the connection pool,
+// see `neffos.InstrumentStackExchange`.
+func (exc *StreamsStackExchange) PoolStats() neffos.StackExchangePoolStats {
+	return exc.poolStats.snapshot(exc.pool)
+}
+
+// Init creates the streams and the consumer group of the "namespaces"
+// and starts reading them. It's called automatically by the `Server.UseStackExchange`.
+func (exc *StreamsStackExchange) Init(namespaces neffos.Namespaces) error {
+	for namespace := range namespaces {
+		exc.streams = append(exc.streams, exc.getStream(namespace))
+	}
+
+	if err := exc.createGroups(); err != nil {
+		return err
+	}
+
+	exc.wg.Add(2)
+	go exc.read()
+	go exc.claim()
+
+	return nil
+}
+
+func (exc *StreamsStackExchange) createGroups() error {
+	for _, stream := range exc.streams {
+		// a new group receives the entries added after its creation.
+		err := exc.pool.Do(radix.Cmd(nil, "XGROUP", "CREATE", stream, exc.cfg.Group, "$", "MKSTREAM"))
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (exc *StreamsStackExchange) read() {
+	defer exc.wg.Done()
+
+	streams := make(map[string]*radix.StreamEntryID, len(exc.streams))
+	for _, stream := range exc.streams {
+		streams[stream] = nil // new entries of the group.
+	}
+
+	for {
+		reader := radix.NewStreamReader(exc.pool, radix.StreamReaderOpts{
+			Streams:  streams,
+			Group:    exc.cfg.Group,
+			Consumer: exc.consumer,
+			Block:    exc.cfg.Block,
+			Count:    exc.cfg.Count,
+		})
+
+		for {
+			stream, entries, ok := reader.Next()
+			if !ok {
+				break
+			}
+
+			if exc.isClosed() {
+				// not acknowledged, claimed by another consumer.
+				return
+			}
+
+			exc.deliver(stream, entries)
+		}
+
+		// i.e redis is down or its keys were removed, the entries
+		// which were read but not acknowledged are claimed later on.
+		neffos.Debugf("redis streams: read: %v", reader.Err())
+		select {
+		case <-exc.closed:
+			return
+		case <-time.After(exc.cfg.Block):
+		}
+		exc.createGroups()
+	}
+}
+
+func (exc *StreamsStackExchange) isClosed() bool {
+	select {
+	case <-exc.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (exc *StreamsStackExchange) claim() {
+	defer exc.wg.Done()
+
+	minIdle := strconv.FormatInt(int64(exc.cfg.ClaimMinIdle/time.Millisecond), 10)
+	count := strconv.Itoa(exc.cfg.Count)
+
+	ticker := time.NewTicker(exc.cfg.ClaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-exc.closed:
+			return
+		case <-ticker.C:
+		}
+
+		for _, stream := range exc.streams {
+			// [[id, consumer, idle, deliveries], ...]
+			var pending [][]string
+			if err := exc.pool.Do(radix.Cmd(&pending, "XPENDING", stream, exc.cfg.Group, "-", "+", count)); err != nil {
+				continue
+			}
+
+			if len(pending) == 0 {
+				continue
+			}
+
+			args := []string{stream, exc.cfg.Group, exc.consumer, minIdle}
+			for _, p := range pending {
+				if idle, err := strconv.ParseInt(p[2], 10, 64); err != nil || time.Duration(idle)*time.Millisecond < exc.cfg.ClaimMinIdle {
+					continue
+				}
+				args = append(args, p[0])
+			}
+
+			if len(args) == 4 {
+				continue
+			}
+
+			// only the entries which are still idle for at least the "minIdle" are claimed.
+			var entries []radix.StreamEntry
+			if err := exc.pool.Do(radix.Cmd(&entries, "XCLAIM", args...)); err != nil {
+				continue
+			}
+
+			exc.deliver(stream, entries)
+		}
+	}
+}
+
+// Close stops the read and claim loops, the entries which are read but not delivered are left pending
+// and claimed by another consumer of the group, and closes the connection pool.
+// It's called automatically by the `Server.Shutdown`.
+func (exc *StreamsStackExchange) Close() error {
+	exc.closeOnce.Do(func() {
+		exc.presence.leaveAll()
+
+		close(exc.closed)
+		// a read is blocked for up to the StreamsConfig.Block.
+		exc.wg.Wait()
+
+		exc.closeErr = exc.pool.Close()
+	})
+
+	return exc.closeErr
+}
+
+// Ping sends a PING to the redis server, see `neffos.Server.Health`.
+func (exc *StreamsStackExchange) Ping(ctx context.Context) error {
+	return ping(ctx, exc.pool)
+}
+
+// deliver writes the "entries" to the local connections and acknowledges them.
+func (exc *StreamsStackExchange) deliver(stream string, entries []radix.StreamEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(entries)+2)
+	ids = append(ids, stream, exc.cfg.Group)
+
+	for _, entry := range entries {
+		ids = append(ids, entry.ID.String())
+
+		b := []byte(entry.Fields[streamMessageField])
+		msg := neffos.DeserializeExchangeMessage(b)
+
+		var receivers []*neffos.Conn
+		exc.mu.RLock()
+		for c, namespaces := range exc.conns {
+			if msg.To != "" {
+				if c.ID() == msg.To {
+					receivers = append(receivers, c)
+				}
+				continue
+			}
+
+			if _, ok := namespaces[msg.Namespace]; ok {
+				receivers = append(receivers, c)
+			}
+		}
+		exc.mu.RUnlock()
+
+		for _, c := range receivers {
+			c.Write(c.DeserializeExchangeMessage(b))
+		}
+	}
+
+	exc.pool.Do(radix.Cmd(nil, "XACK", ids...))
+}
+
+// OnConnect registers the connection for its direct messages.
+// It's called automatically after the neffos server's OnConnect (if any)
+// on incoming client connections.
+func (exc *StreamsStackExchange) OnConnect(c *neffos.Conn) error {
+	exc.mu.Lock()
+	exc.conns[c] = make(map[string]struct{})
+	exc.mu.Unlock()
+	return nil
+}
+
+// PreservesOrigin implements the `neffos.OriginStackExchange`,
+// the envelopes of the messages are appended to the streams as they are.
+func (exc *StreamsStackExchange) PreservesOrigin() bool {
+	return true
+}
+
+// RequiresStaticNamespaces implements the `neffos.StaticNamespacesStackExchange`,
+// the streams and their consumer groups are created on `Init`, for the declared namespaces only.
+// It can't be used with the dynamic namespaces of the `neffos.Namespaces#SetDynamic`.
+func (exc *StreamsStackExchange) RequiresStaticNamespaces() bool {
+	return true
+}
+
+// Publish appends the messages to their namespace's stream.
+// It's called automatically on neffos broadcasting.
+// The XADD commands of many messages are pipelined, see `neffos.Server.SetStackExchangeBatch`.
+func (exc *StreamsStackExchange) Publish(msgs []neffos.Message) bool {
+	if len(msgs) == 1 {
+		return exc.publish(msgs[0])
+	}
+
+	cmds := make([]radix.CmdAction, 0, len(msgs))
+	for _, msg := range msgs {
+		cmds = append(cmds, exc.xadd(msg))
+	}
+
+	return exc.pool.Do(radix.Pipeline(cmds...)) == nil
+}
+
+func (exc *StreamsStackExchange) publish(msg neffos.Message) bool {
+	return exc.pool.Do(exc.xadd(msg)) == nil
+}
+
+// xadd returns the command which appends the "msg" to its namespace's stream.
+func (exc *StreamsStackExchange) xadd(msg neffos.Message) radix.CmdAction {
+	var args []interface{}
+	if exc.cfg.MaxLen > 0 {
+		args = append(args, "MAXLEN", "~", exc.cfg.MaxLen)
+	}
+	args = append(args, "*", streamMessageField, msg.SerializeExchange())
+
+	return radix.FlatCmd(nil, "XADD", exc.getStream(msg.Namespace), args...)
+}
+
+// Ask implements the server Ask feature for redis. It blocks until response.
+func (exc *StreamsStackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (neffos.Message, error) {
+	return ask(ctx, exc.connFunc, exc.prefix+token, func() error {
+		if !exc.publish(msg) {
+			return neffos.ErrWrite
+		}
+		return nil
+	})
+}
+
+// NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
+func (exc *StreamsStackExchange) NotifyAsk(msg neffos.Message, token string) error {
+	return notifyAsk(exc.pool, msg, exc.prefix+token)
+}
+
+// Subscribe subscribes the connection to a specific namespace,
+// it's called automatically on neffos namespace connected.
+func (exc *StreamsStackExchange) Subscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		namespaces[namespace] = struct{}{}
+	}
+	exc.mu.Unlock()
+}
+
+// Unsubscribe unsubscribes the connection from a specific namespace,
+// it's called automatically on neffos namespace disconnect.
+func (exc *StreamsStackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		delete(namespaces, namespace)
+	}
+	exc.mu.Unlock()
+}
+
+// OnDisconnect removes the connection which registered on the `OnConnect` method.
+// It's called automatically when a connection goes offline,
+// manually by server or client or by network failure.
+func (exc *StreamsStackExchange) OnDisconnect(c *neffos.Conn) {
+	exc.mu.Lock()
+	delete(exc.conns, c)
+	exc.mu.Unlock()
+}
@@ -0,0 +1,229 @@
+package redis
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix/v3"
+)
+
+const streamsTestNamespace = "default"
+
+// runStreamsTestServer serves a neffos server of a `StreamsStackExchange` of the "streamsCfg"
+// and connects a client to its namespace, the bodies of the client's "notify" events are sent to the returned channel.
+func runStreamsTestServer(t *testing.T, addr string, streamsCfg StreamsConfig) (*StreamsStackExchange, <-chan string) {
+	t.Helper()
+
+	exc, err := NewStreamsStackExchange(Config{Addr: addr}, streamsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{streamsTestNamespace: neffos.Events{}})
+	if err = server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Close()
+	})
+
+	received := make(chan string, 64)
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+		neffos.Namespaces{streamsTestNamespace: neffos.Events{
+			"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- string(msg.Body)
+				return nil
+			},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	if _, err = client.Connect(context.TODO(), streamsTestNamespace); err != nil {
+		t.Fatal(err)
+	}
+
+	return exc, received
+}
+
+func expectStreamsMessage(t *testing.T, received <-chan string, expected string) {
+	t.Helper()
+
+	select {
+	case got := <-received:
+		if expected != got {
+			t.Fatalf("expected message: %s but got: %s", expected, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected message: %s", expected)
+	}
+}
+
+func pendingEntries(t *testing.T, exc *StreamsStackExchange, stream string) int {
+	t.Helper()
+
+	// [count, first, last, [[consumer, count], ...]]
+	var summary []interface{}
+	if err := exc.pool.Do(radix.Cmd(&summary, "XPENDING", stream, exc.cfg.Group)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, _ := summary[0].(int64)
+	return int(n)
+}
+
+func TestStreamsStackExchangeAck(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+
+	exc, received := runStreamsTestServer(t, redisServer.Addr(), StreamsConfig{Group: "instance", Block: 100 * time.Millisecond})
+
+	for _, body := range []string{"1", "2", "3"} {
+		if !exc.Publish([]neffos.Message{{Namespace: streamsTestNamespace, Event: "notify", Body: []byte(body)}}) {
+			t.Fatalf("expected publish to succeed")
+		}
+	}
+
+	for _, body := range []string{"1", "2", "3"} {
+		expectStreamsMessage(t, received, body)
+	}
+
+	// the entries are acknowledged after their delivery.
+	stream := exc.getStream(streamsTestNamespace)
+	deadline := time.Now().Add(3 * time.Second)
+	for pendingEntries(t, exc, stream) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the delivered entries to be acknowledged")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStreamsStackExchangeClaim(t *testing.T) {
+	var (
+		redisServer = miniredis.RunT(t)
+		stream      = "neffos." + streamsTestNamespace
+		minIdle     = 500 * time.Millisecond
+	)
+
+	conn, err := radix.Dial("tcp", redisServer.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the entry of a crashed consumer of the group, read but never acknowledged.
+	msg := neffos.Message{Namespace: streamsTestNamespace, Event: "notify", Body: []byte("pending")}
+	for _, cmd := range []radix.CmdAction{
+		radix.Cmd(nil, "XGROUP", "CREATE", stream, "instance", "$", "MKSTREAM"),
+		radix.FlatCmd(nil, "XADD", stream, "*", streamMessageField, msg.SerializeExchange()),
+		radix.Cmd(nil, "XREADGROUP", "GROUP", "instance", "crashed", "STREAMS", stream, ">"),
+	} {
+		if err := conn.Do(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	exc, received := runStreamsTestServer(t, redisServer.Addr(), StreamsConfig{
+		Group:         "instance",
+		Block:         100 * time.Millisecond,
+		ClaimInterval: 50 * time.Millisecond,
+		ClaimMinIdle:  minIdle,
+	})
+
+	expectStreamsMessage(t, received, "pending")
+	if elapsed := time.Since(start); elapsed < minIdle {
+		t.Fatalf("expected the entry to be claimed after its minimum idle time: %s but it was claimed after: %s", minIdle, elapsed)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for pendingEntries(t, exc, stream) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the claimed entry to be acknowledged")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case got := <-received:
+		t.Fatalf("expected the claimed entry to be delivered once but got: %s", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestStreamsStackExchangeMaxLen(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+
+	exc, err := NewStreamsStackExchange(Config{Addr: redisServer.Addr()}, StreamsConfig{Group: "instance", MaxLen: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exc.Close()
+
+	msgs := make([]neffos.Message, 20)
+	for i := range msgs {
+		msgs[i] = neffos.Message{Namespace: streamsTestNamespace, Event: "notify"}
+	}
+
+	// pipelined and single.
+	if !exc.Publish(msgs) || !exc.Publish(msgs[:1]) {
+		t.Fatalf("expected publish to succeed")
+	}
+
+	var n int
+	if err = exc.pool.Do(radix.Cmd(&n, "XLEN", exc.getStream(streamsTestNamespace))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the "~" cap is exact on the miniredis.
+	if expected, got := 5, n; expected != got {
+		t.Fatalf("expected the stream to be capped to %d entries but got: %d", expected, got)
+	}
+}
+
+func TestStreamsStackExchangeRecreateGroups(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+
+	exc, received := runStreamsTestServer(t, redisServer.Addr(), StreamsConfig{Group: "instance", Block: 100 * time.Millisecond})
+
+	msg := neffos.Message{Namespace: streamsTestNamespace, Event: "notify", Body: []byte("before")}
+	if !exc.Publish([]neffos.Message{msg}) {
+		t.Fatalf("expected publish to succeed")
+	}
+	expectStreamsMessage(t, received, "before")
+
+	// the streams and their groups are lost, i.e a restarted redis without persistence.
+	redisServer.FlushAll()
+
+	// the read fails and the groups are created again, the entries
+	// which are appended before that are not delivered to the new group.
+	msg.Body = []byte("after")
+	deadline := time.After(3 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		exc.Publish([]neffos.Message{msg})
+
+		select {
+		case got := <-received:
+			if expected := "after"; expected != got {
+				t.Fatalf("expected message: %s but got: %s", expected, got)
+			}
+			return
+		case <-deadline:
+			t.Fatalf("expected the groups to be created again")
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,747 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix/v3"
+)
+
+// TestStackExchangePerRoom measures the copies of a room message that the redis server sends,
+// the PUBLISH reply is the number of its receivers.
+//
+// Two server instances with 10 connections each on the "chat" namespace
+// and a single one of them joined to the "room" room:
+//
+//	namespace-level channels: 20 copies, one for each connection of the namespace.
+//	room-level channels:       1 copy, for the only server instance with a joined connection.
+func TestStackExchangePerRoom(t *testing.T) {
+	const (
+		namespace = "chat"
+		room      = "room"
+		clients   = 10
+	)
+
+	for _, tt := range []struct {
+		perRoom        bool
+		expectedCopies int
+	}{
+		{perRoom: false, expectedCopies: 2 * clients},
+		{perRoom: true, expectedCopies: 1},
+	} {
+		redisServer := miniredis.RunT(t)
+
+		var (
+			exchanges []*StackExchange
+			conns     []*neffos.NSConn
+			received  = make(chan string, 4*clients)
+		)
+
+		for i := 0; i < 2; i++ {
+			exc, err := NewStackExchange(Config{Addr: redisServer.Addr(), PerRoom: tt.perRoom}, "neffostest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			exchanges = append(exchanges, exc)
+
+			server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+			if err = server.UseStackExchange(exc); err != nil {
+				t.Fatal(err)
+			}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			defer server.Close()
+
+			for j := 0; j < clients; j++ {
+				client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+					neffos.Namespaces{namespace: neffos.Events{
+						"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+							received <- c.Conn.ID()
+							return nil
+						},
+					}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer client.Close()
+
+				c, err := client.Connect(context.TODO(), namespace)
+				if err != nil {
+					t.Fatal(err)
+				}
+				conns = append(conns, c)
+			}
+		}
+
+		member := conns[0]
+		if _, err := member.JoinRoom(context.TODO(), room); err != nil {
+			t.Fatal(err)
+		}
+
+		// the subscriptions of the connections are asynchronous.
+		time.Sleep(100 * time.Millisecond)
+
+		msg := neffos.Message{Namespace: namespace, Room: room, Event: "notify"}
+		var copies int
+		if err := exchanges[1].pool.Do(radix.FlatCmd(&copies, "PUBLISH", exchanges[1].getMessageChannel(msg), msg.SerializeExchange())); err != nil {
+			t.Fatal(err)
+		}
+
+		if copies != tt.expectedCopies {
+			t.Fatalf("[perRoom:%v] expected %d copies of a room message but got: %d", tt.perRoom, tt.expectedCopies, copies)
+		}
+
+		expectReceived := func(expected string) {
+			t.Helper()
+
+			select {
+			case id := <-received:
+				if id != expected {
+					t.Fatalf("[perRoom:%v] expected the message on: %s but got it on: %s", tt.perRoom, expected, id)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("[perRoom:%v] expected a message on: %s", tt.perRoom, expected)
+			}
+		}
+
+		expectReceived(member.Conn.ID())
+
+		// the namespace messages are received by all.
+		if !exchanges[1].Publish([]neffos.Message{{Namespace: namespace, Event: "notify"}}) {
+			t.Fatalf("[perRoom:%v] expected publish to succeed", tt.perRoom)
+		}
+		for range conns {
+			select {
+			case <-received:
+			case <-time.After(3 * time.Second):
+				t.Fatalf("[perRoom:%v] expected a namespace message on each connection", tt.perRoom)
+			}
+		}
+
+		// the last member leaves, the server instance unsubscribes from the room.
+		if err := member.LeaveAll(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := exchanges[1].pool.Do(radix.FlatCmd(&copies, "PUBLISH", exchanges[1].getMessageChannel(msg), msg.SerializeExchange())); err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.perRoom && copies != 0 {
+			t.Fatalf("expected no copies of a room message after its last member left but got: %d", copies)
+		}
+
+		select {
+		case id := <-received:
+			t.Fatalf("[perRoom:%v] expected no more messages but got one on: %s", tt.perRoom, id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestStackExchangeShutdown(t *testing.T) {
+	const (
+		namespace = "chat"
+		room      = "room"
+	)
+
+	redisServer := miniredis.RunT(t)
+
+	exc, err := NewStackExchange(Config{Addr: redisServer.Addr(), PerRoom: true}, "neffostest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+	if err = server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+		neffos.Namespaces{namespace: neffos.Events{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.JoinRoom(context.TODO(), room); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscriptions of the connections are asynchronous.
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := radix.Dial("tcp", redisServer.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	channels := []string{
+		exc.getChannel(namespace, "", ""),
+		exc.getRoomChannel(namespace, room),
+		exc.getChannel("", "", c.Conn.ID()),
+	}
+
+	subscribers := func() (n int) {
+		t.Helper()
+
+		for _, channel := range channels {
+			var copies int
+			if err := conn.Do(radix.Cmd(&copies, "PUBLISH", channel, "")); err != nil {
+				t.Fatal(err)
+			}
+			n += copies
+		}
+		return
+	}
+
+	if got := subscribers(); got != len(channels) {
+		t.Fatalf("expected %d subscribers before the shutdown but got: %d", len(channels), got)
+	}
+
+	if err = server.Shutdown(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := subscribers(); got != 0 {
+		t.Fatalf("expected no subscribers after the shutdown but got: %d", got)
+	}
+
+	if got := server.GetTotalConnections(); got != 0 {
+		t.Fatalf("expected no connections after the shutdown but got: %d", got)
+	}
+
+	if err = exc.Close(); err != nil {
+		t.Fatalf("expected a second close to be a no-op but got: %v", err)
+	}
+}
+
+func TestStackExchangeHealth(t *testing.T) {
+	const namespace = "default"
+
+	redisServer := miniredis.RunT(t)
+
+	exc, err := NewStackExchange(Config{
+		Addr:                redisServer.Addr(),
+		HealthCheckInterval: 50 * time.Millisecond,
+		MaxReconnectBackoff: 100 * time.Millisecond,
+	}, "neffostest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reported := make(chan bool, 16)
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+	server.OnStackExchangeError = func(err error, recovered bool) {
+		reported <- recovered
+	}
+	if err = server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	defer server.Close()
+
+	received := make(chan []byte, 1)
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+		neffos.Namespaces{namespace: neffos.Events{
+			"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- msg.Body
+				return nil
+			},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+	// the subscriptions of the connections are asynchronous.
+	time.Sleep(100 * time.Millisecond)
+
+	expectReport := func(expected bool) {
+		t.Helper()
+
+		select {
+		case recovered := <-reported:
+			if expected != recovered {
+				t.Fatalf("expected a report with recovered: %v but got: %v", expected, recovered)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected a report with recovered: %v", expected)
+		}
+	}
+
+	redisServer.Close()
+	expectReport(false)
+	if server.StackExchangeHealthy() {
+		t.Fatalf("expected an unhealthy stackexchange while redis is down")
+	}
+
+	if err = redisServer.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	expectReport(true)
+	if !server.StackExchangeHealthy() {
+		t.Fatalf("expected a healthy stackexchange after redis is up again")
+	}
+
+	// the subscriber is connected again, publish as another server instance
+	// because the broadcasts of this server are written to its connections directly.
+	msg := neffos.Message{Namespace: namespace, Event: "notify", Body: []byte("data")}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		// the pool may return a connection of the previous redis server, retry.
+		exc.pool.Do(radix.FlatCmd(nil, "PUBLISH", exc.getMessageChannel(msg), msg.SerializeExchange()))
+
+		select {
+		case b := <-received:
+			if expected, got := "data", string(b); expected != got {
+				t.Fatalf("expected body: %s but got: %s", expected, got)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a message after the recovery")
+		}
+	}
+}
+
+func TestStackExchangePing(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+
+	exc, err := NewStackExchange(Config{Addr: redisServer.Addr(), HealthCheckInterval: -1}, "neffostest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err = exc.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	redisServer.Close()
+	if err = exc.Ping(ctx); err == nil {
+		t.Fatalf("expected a ping error while redis is down")
+	}
+}
+
+// BenchmarkStackExchangePublish compares the immediate and the batched publishes of broadcasts.
+// It runs against a local redis server, set the REDIS_ADDR environment variable, i.e:
+//
+//	REDIS_ADDR=127.0.0.1:6379 go test -run=^$ -bench=Publish ./stackexchange/redis
+//
+// Otherwise it runs against an in-process redis server.
+func BenchmarkStackExchangePublish(b *testing.B) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		redisServer, err := miniredis.Run()
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer redisServer.Close()
+		addr = redisServer.Addr()
+	}
+
+	for _, bb := range []struct {
+		name       string
+		size       int
+		maxLatency time.Duration
+	}{
+		{"immediate", 0, 0},
+		{"batch", 100, time.Millisecond},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			exc, err := NewStackExchange(Config{Addr: addr, HealthCheckInterval: -1}, "neffosbench")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{"default": neffos.Events{}})
+			if err = server.UseStackExchange(exc); err != nil {
+				b.Fatal(err)
+			}
+			server.SetStackExchangeBatch(bb.size, bb.maxLatency)
+
+			msg := neffos.Message{Namespace: "default", Event: "notify", Body: []byte("data")}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				server.Broadcast(nil, msg)
+			}
+			// publishes the buffered ones.
+			server.Close()
+		})
+	}
+}
+
+func TestStackExchangeChannelPrefix(t *testing.T) {
+	const namespace = "default"
+
+	redisServer := miniredis.RunT(t)
+
+	newServer := func(prefix string) (*neffos.Server, string) {
+		exc, err := NewStackExchange(Config{Addr: redisServer.Addr(), ChannelPrefix: prefix}, "neffos")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		if err = server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		t.Cleanup(func() {
+			server.Close()
+			httpServer.Close()
+		})
+		return server, strings.Replace(httpServer.URL, "http", "ws", 1)
+	}
+
+	received := make(chan string, 4)
+	dial := func(url, name string) *neffos.Client {
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, url, neffos.Namespaces{namespace: neffos.Events{
+			"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- name
+				return nil
+			},
+			"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+				return neffos.Reply([]byte(name))
+			},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Close)
+
+		if _, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	// two logical clusters on the same redis server.
+	serverA1, _ := newServer("team-a:")
+	_, urlA2 := newServer("team-a:")
+	_, urlB := newServer("team-b:")
+
+	clientA := dial(urlA2, "a")
+	clientB := dial(urlB, "b")
+
+	// the subscriptions of the connections are asynchronous.
+	time.Sleep(100 * time.Millisecond)
+
+	serverA1.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify"})
+
+	select {
+	case name := <-received:
+		if expected := "a"; expected != name {
+			t.Fatalf("expected the message on: %s but got it on: %s", expected, name)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected a message on the same cluster")
+	}
+
+	select {
+	case name := <-received:
+		t.Fatalf("expected no cross-talk but got a message on: %s", name)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	response, err := serverA1.Ask(ctx, neffos.Message{To: clientA.ID, Namespace: namespace, Event: "ask"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "a", string(response.Body); expected != got {
+		t.Fatalf("expected response: %s but got: %s", expected, got)
+	}
+
+	if _, err = serverA1.Ask(ctx, neffos.Message{To: clientB.ID, Namespace: namespace, Event: "ask"}); err != neffos.ErrConnNotFound {
+		t.Fatalf("expected the connection of the other cluster to not be found but got: %v", err)
+	}
+}
+
+func TestDialConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Clusters: []string{"127.0.0.1:7000"}, SentinelAddrs: []string{"127.0.0.1:26379"}, SentinelMasterName: "primary"},
+		{SentinelAddrs: []string{"127.0.0.1:26379"}},
+		{Clusters: []string{"127.0.0.1:7000"}, DB: 1},
+		{Username: "neffos"},
+		{Network: "unix", Addr: "/tmp/redis.sock", TLSConfig: &tls.Config{}},
+		{ReadTimeout: -time.Second},
+		{WriteTimeout: -time.Second},
+		{MaxActive: -1},
+		{MaxActive: 5, MinIdle: 6},
+		{MinIdle: -1},
+	} {
+		if _, _, _, err := dial(cfg); err == nil {
+			t.Fatalf("expected an error for: %#+v", cfg)
+		}
+	}
+}
+
+func TestStackExchangePresence(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+
+	var (
+		redisServer = miniredis.RunT(t)
+		ctx         = context.TODO()
+	)
+
+	var exchanges []*StackExchange
+	for i := 0; i < 2; i++ {
+		exc, err := NewStackExchange(Config{Addr: redisServer.Addr(), PresenceTTL: ttl}, "neffostest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer exc.PresenceLeave("instance-" + strconv.Itoa(i))
+		if err = exc.PresenceJoin("instance-" + strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+		exchanges = append(exchanges, exc)
+	}
+
+	exchanges[0].PresenceConnected("instance-0", "a", []string{"alice"})
+	exchanges[1].PresenceConnected("instance-1", "b", nil)
+	exchanges[1].PresenceConnected("instance-1", "b", nil)
+
+	expectLookup := func(name, expected string) {
+		t.Helper()
+
+		instanceID, found, err := exchanges[1].PresenceLookup(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != (expected != "") || instanceID != expected {
+			t.Fatalf("[%s] expected instance: %q but got: %q", name, expected, instanceID)
+		}
+	}
+
+	expectTotal := func(expected int) {
+		t.Helper()
+
+		total, err := exchanges[0].PresenceTotal(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != total {
+			t.Fatalf("expected %d connections but got: %d", expected, total)
+		}
+	}
+
+	expectLookup("a", "instance-0")
+	expectLookup("alice", "instance-0")
+	expectLookup("b", "instance-1")
+	expectLookup("missing", "")
+	expectTotal(3)
+
+	// a connection ID is removed with its last connection.
+	exchanges[1].PresenceDisconnected("instance-1", "b", nil)
+	expectLookup("b", "instance-1")
+	exchanges[1].PresenceDisconnected("instance-1", "b", nil)
+	expectLookup("b", "")
+	expectTotal(1)
+
+	// the heartbeat keeps the records of an alive instance,
+	// miniredis expires the keys only on FastForward.
+	time.Sleep(2 * ttl)
+	redisServer.FastForward(ttl / 2)
+	expectLookup("alice", "instance-0")
+
+	// the records of a crashed instance expire.
+	exchanges[0].presence.mu.Lock()
+	close(exchanges[0].presence.joined["instance-0"])
+	delete(exchanges[0].presence.joined, "instance-0")
+	exchanges[0].presence.mu.Unlock()
+
+	time.Sleep(2 * ttl)
+	redisServer.FastForward(2 * ttl)
+	expectLookup("alice", "")
+	expectTotal(0)
+
+	if redisServer.Exists("neffostest.presence.{instance-0}.names") {
+		t.Fatalf("expected the keys of the crashed instance to expire")
+	}
+
+	// disabled.
+	exc, err := NewStackExchange(Config{Addr: redisServer.Addr()}, "neffostest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = exc.PresenceJoin("instance"); err != neffos.ErrPresenceUnsupported {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrPresenceUnsupported, err)
+	}
+}
+
+// newTestCertificate returns a self-signed certificate of the 127.0.0.1.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "neffos"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func TestStackExchangeTLS(t *testing.T) {
+	const (
+		namespace = "default"
+		username  = "neffos"
+		password  = "secret"
+	)
+
+	serverCert, serverX509 := newTestCertificate(t)
+	clientCert, clientX509 := newTestCertificate(t)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientX509)
+	redisServer, err := miniredis.RunTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redisServer.Close()
+	redisServer.RequireUserAuth(username, password)
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(serverX509)
+	cfg := Config{
+		Addr:         redisServer.Addr(),
+		Username:     username,
+		Password:     password,
+		TLSConfig:    &tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{clientCert}},
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		MaxActive:    4,
+		MinIdle:      2,
+	}
+
+	// the errors are returned from the constructor.
+	wrongPassword := cfg
+	wrongPassword.Password = "wrong"
+	if _, err = NewStackExchange(wrongPassword, "neffostest"); err == nil {
+		t.Fatalf("expected an authentication error")
+	}
+
+	withoutCert := cfg
+	withoutCert.TLSConfig = &tls.Config{RootCAs: rootCAs}
+	if _, err = NewStackExchange(withoutCert, "neffostest"); err == nil {
+		t.Fatalf("expected a TLS error without the client certificate")
+	}
+
+	received := make(chan []byte, 1)
+	var servers []*neffos.Server
+	for i := 0; i < 2; i++ {
+		exc, err := NewStackExchange(cfg, "neffostest")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		if err = server.UseStackExchange(neffos.InstrumentStackExchange(exc)); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+		defer server.Close()
+		servers = append(servers, server)
+
+		if i == 0 {
+			continue
+		}
+
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+			neffos.Namespaces{namespace: neffos.Events{
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- msg.Body
+					return nil
+				},
+			}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if _, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	servers[0].Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify", Body: []byte("data")})
+
+	select {
+	case b := <-received:
+		if expected, got := "data", string(b); expected != got {
+			t.Fatalf("expected body: %s but got: %s", expected, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected a message over TLS")
+	}
+
+	pool := servers[0].Metrics().StackExchange.Pool
+	if pool.Size != cfg.MaxActive || pool.Open < cfg.MinIdle || pool.Created < uint64(cfg.MinIdle) || pool.DialErrors != 0 {
+		t.Fatalf("unexpected pool statistics: %#+v", pool)
+	}
+}
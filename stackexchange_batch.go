@@ -0,0 +1,86 @@
+package neffos
+
+import (
+	"time"
+)
+
+// stackExchangeBatch buffers the messages which are published through the server's `StackExchange`,
+// see `Server.SetStackExchangeBatch`.
+type stackExchangeBatch struct {
+	size       int
+	maxLatency time.Duration
+
+	queue   chan []Message
+	flushes chan chan struct{}
+}
+
+func newStackExchangeBatch(size int, maxLatency time.Duration) *stackExchangeBatch {
+	return &stackExchangeBatch{
+		size:       size,
+		maxLatency: maxLatency,
+		queue:      make(chan []Message, size),
+		flushes:    make(chan chan struct{}),
+	}
+}
+
+// run publishes the buffered messages through the "publish",
+// a single goroutine publishes them in the order they were added.
+func (b *stackExchangeBatch) run(publish func(msgs []Message) bool) {
+	var (
+		pending []Message
+		timer   *time.Timer
+		timerC  <-chan time.Time
+	)
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timerC = nil, nil
+		}
+
+		if len(pending) > 0 {
+			publish(pending)
+			pending = nil
+		}
+	}
+
+	for {
+		select {
+		case msgs := <-b.queue:
+			pending = append(pending, msgs...)
+			if len(pending) >= b.size {
+				flush()
+			} else if timer == nil {
+				timer = time.NewTimer(b.maxLatency)
+				timerC = timer.C
+			}
+		case <-timerC:
+			flush()
+		case done := <-b.flushes:
+		drain:
+			for {
+				select {
+				case msgs := <-b.queue:
+					pending = append(pending, msgs...)
+				default:
+					break drain
+				}
+			}
+
+			flush()
+			close(done)
+		}
+	}
+}
+
+// add buffers the "msgs", it blocks while the queue is full.
+func (b *stackExchangeBatch) add(msgs []Message) {
+	b.queue <- msgs
+}
+
+// flush publishes the buffered messages and waits for it.
+func (b *stackExchangeBatch) flush() {
+	done := make(chan struct{})
+	b.flushes <- done
+	<-done
+}
@@ -0,0 +1,375 @@
+package neffos
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MigrationMode is the mode of a `CompositeStackExchange`,
+// it decides which of its stackexchanges the messages are published to and received from.
+type MigrationMode uint8
+
+const (
+	// DualPublishPrimaryRead publishes to both stackexchanges and receives from the primary one only.
+	// It's the first step of a migration, the server instances keep reading the old broker
+	// while all of them start to publish to the new one too.
+	DualPublishPrimaryRead MigrationMode = iota
+	// DualPublishDualRead publishes to both stackexchanges and receives from both of them,
+	// a message which is delivered by both is written once.
+	// It's the second step of a migration, it can be rolled out while some instances are still
+	// on the `DualPublishPrimaryRead`.
+	DualPublishDualRead
+	// SecondaryOnly publishes to and receives from the secondary stackexchange only.
+	// It's the last step of a migration, roll it out once all the instances are on the `DualPublishDualRead`,
+	// afterwards the primary stackexchange can be removed.
+	SecondaryOnly
+)
+
+// String returns the name of the mode.
+func (m MigrationMode) String() string {
+	switch m {
+	case DualPublishPrimaryRead:
+		return "DualPublishPrimaryRead"
+	case DualPublishDualRead:
+		return "DualPublishDualRead"
+	case SecondaryOnly:
+		return "SecondaryOnly"
+	default:
+		return "MigrationMode(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+// CompositeStackExchange is a `StackExchange` which moves a cluster from a broker to another
+// without stopping it, i.e from redis to nats, see `MigrationMode`.
+// The messages it publishes carry a publish identifier, the server drops a message
+// which is delivered to a connection more than once, through both stackexchanges,
+// within the deduplication window, see `SetDedupWindow`.
+//
+// The `Ask` is performed through the stackexchanges it receives from, the primary one first.
+// The cluster presence and the unicast of its stackexchanges are not used.
+//
+// Use the `NewCompositeStackExchange` to create a new one
+// and register it to the server through its `Server.UseStackExchange`.
+type CompositeStackExchange struct {
+	primary   StackExchange
+	secondary StackExchange
+	mode      MigrationMode
+
+	dedup *exchangeDedup
+}
+
+var (
+	_ StackExchange                 = (*CompositeStackExchange)(nil)
+	_ StackExchangeInitializer      = (*CompositeStackExchange)(nil)
+	_ StackExchangeErrorReporter    = (*CompositeStackExchange)(nil)
+	_ RoomStackExchange             = (*CompositeStackExchange)(nil)
+	_ OriginStackExchange           = (*CompositeStackExchange)(nil)
+	_ StaticNamespacesStackExchange = (*CompositeStackExchange)(nil)
+	_ ClosableStackExchange         = (*CompositeStackExchange)(nil)
+	_ PingableStackExchange         = (*CompositeStackExchange)(nil)
+)
+
+// NewCompositeStackExchange returns a new `CompositeStackExchange` of the "primary", the stackexchange to migrate from,
+// and the "secondary", the stackexchange to migrate to.
+// The "mode" decides which of them are used.
+func NewCompositeStackExchange(primary, secondary StackExchange, mode MigrationMode) *CompositeStackExchange {
+	return &CompositeStackExchange{
+		primary:   primary,
+		secondary: secondary,
+		mode:      mode,
+		dedup:     newExchangeDedup(10*time.Second, 10000),
+	}
+}
+
+// SetDedupWindow sets the time that a publish identifier is remembered for, it should exceed
+// the delivery delay between the two stackexchanges, and the maximum number of the remembered publishes,
+// the oldest one is forgotten when it's exceeded.
+// It should be called once, before the exchange is registered to a server.
+//
+// Defaults to 10 seconds and 10000 publishes.
+func (exc *CompositeStackExchange) SetDedupWindow(window time.Duration, size int) *CompositeStackExchange {
+	if window > 0 && size > 0 {
+		exc.dedup = newExchangeDedup(window, size)
+	}
+
+	return exc
+}
+
+// Mode returns the mode of the exchange.
+func (exc *CompositeStackExchange) Mode() MigrationMode {
+	return exc.mode
+}
+
+// publishers returns the stackexchanges which the messages are published to.
+func (exc *CompositeStackExchange) publishers() []StackExchange {
+	if exc.mode == SecondaryOnly {
+		return []StackExchange{exc.secondary}
+	}
+
+	return []StackExchange{exc.primary, exc.secondary}
+}
+
+// readers returns the stackexchanges which the messages are received from.
+func (exc *CompositeStackExchange) readers() []StackExchange {
+	switch exc.mode {
+	case DualPublishDualRead:
+		return []StackExchange{exc.primary, exc.secondary}
+	case SecondaryOnly:
+		return []StackExchange{exc.secondary}
+	default:
+		return []StackExchange{exc.primary}
+	}
+}
+
+// Init initializes both stackexchanges.
+func (exc *CompositeStackExchange) Init(namespaces Namespaces) error {
+	for _, e := range []StackExchange{exc.primary, exc.secondary} {
+		if err := stackExchangeInit(e, namespaces); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetErrorHandler registers the "handler" to both stackexchanges.
+func (exc *CompositeStackExchange) SetErrorHandler(handler func(err error, recovered bool)) {
+	for _, e := range []StackExchange{exc.primary, exc.secondary} {
+		if r, ok := e.(StackExchangeErrorReporter); ok {
+			r.SetErrorHandler(handler)
+		}
+	}
+}
+
+// OnConnect prepares the connection on the stackexchanges it receives from,
+// it returns on the first error.
+func (exc *CompositeStackExchange) OnConnect(c *Conn) error {
+	for _, e := range exc.readers() {
+		if err := e.OnConnect(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OnDisconnect removes the connection from the stackexchanges it receives from.
+func (exc *CompositeStackExchange) OnDisconnect(c *Conn) {
+	for _, e := range exc.readers() {
+		e.OnDisconnect(c)
+	}
+}
+
+// Publish publishes the "msgs" with a new publish identifier to the stackexchanges it publishes to,
+// it reports false if any of them failed.
+func (exc *CompositeStackExchange) Publish(msgs []Message) bool {
+	// the "msgs" are written to the local connections too, do not modify them.
+	identified := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		if msg.publishID == "" {
+			msg.publishID = genPublishID()
+		}
+		identified[i] = msg
+	}
+
+	ok := true
+	for _, e := range exc.publishers() {
+		if !e.Publish(identified) {
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// PreservesOrigin reports whether both of the stackexchanges preserve the origin of the messages,
+// see `OriginStackExchange`.
+func (exc *CompositeStackExchange) PreservesOrigin() bool {
+	return preservesOrigin(exc.primary) && preservesOrigin(exc.secondary)
+}
+
+// RequiresStaticNamespaces reports whether any of the stackexchanges requires static namespaces,
+// see `StaticNamespacesStackExchange`.
+func (exc *CompositeStackExchange) RequiresStaticNamespaces() bool {
+	return requiresStaticNamespaces(exc.primary) || requiresStaticNamespaces(exc.secondary)
+}
+
+// Subscribe subscribes the connection to the "namespace" on the stackexchanges it receives from.
+func (exc *CompositeStackExchange) Subscribe(c *Conn, namespace string) {
+	for _, e := range exc.readers() {
+		e.Subscribe(c, namespace)
+	}
+}
+
+// Unsubscribe unsubscribes the connection from the "namespace" on the stackexchanges it receives from.
+func (exc *CompositeStackExchange) Unsubscribe(c *Conn, namespace string) {
+	for _, e := range exc.readers() {
+		e.Unsubscribe(c, namespace)
+	}
+}
+
+// SubscribeRoom subscribes to the "room" on the stackexchanges it receives from, if they are a `RoomStackExchange`.
+func (exc *CompositeStackExchange) SubscribeRoom(namespace, room string) {
+	for _, e := range exc.readers() {
+		if roomExc, ok := e.(RoomStackExchange); ok {
+			roomExc.SubscribeRoom(namespace, room)
+		}
+	}
+}
+
+// UnsubscribeRoom unsubscribes from the "room" on the stackexchanges it receives from, if they are a `RoomStackExchange`.
+func (exc *CompositeStackExchange) UnsubscribeRoom(namespace, room string) {
+	for _, e := range exc.readers() {
+		if roomExc, ok := e.(RoomStackExchange); ok {
+			roomExc.UnsubscribeRoom(namespace, room)
+		}
+	}
+}
+
+// Ask performs the "msg" ask through the stackexchanges it receives from,
+// the next one is tried if the first fails.
+func (exc *CompositeStackExchange) Ask(ctx context.Context, msg Message, token string) (response Message, err error) {
+	for _, e := range exc.readers() {
+		if response, err = e.Ask(ctx, msg, token); err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// NotifyAsk notifies the asker through the stackexchanges it publishes to,
+// the asker may receive from any of them. It returns the first error, if any.
+func (exc *CompositeStackExchange) NotifyAsk(msg Message, token string) error {
+	var err error
+	for _, e := range exc.publishers() {
+		if notifyErr := e.NotifyAsk(msg, token); err == nil {
+			err = notifyErr
+		}
+	}
+
+	return err
+}
+
+// Ping pings the stackexchanges it publishes to, if they are a `PingableStackExchange`.
+func (exc *CompositeStackExchange) Ping(ctx context.Context) error {
+	for _, e := range exc.publishers() {
+		if pinger, ok := e.(PingableStackExchange); ok {
+			if err := pinger.Ping(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close closes both stackexchanges, if they are a `ClosableStackExchange`, and returns the first error.
+func (exc *CompositeStackExchange) Close() error {
+	var err error
+	for _, e := range []StackExchange{exc.primary, exc.secondary} {
+		if closer, ok := e.(ClosableStackExchange); ok {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+
+	return err
+}
+
+var publishIDSeq uint64
+
+// genPublishID returns a new publish identifier, unique across the server instances,
+// see `CompositeStackExchange`.
+func genPublishID() string {
+	return traceIDPrefix + string(waitScopeSeparator) + strconv.FormatUint(atomic.AddUint64(&publishIDSeq, 1), 36)
+}
+
+// exchangeDedup remembers the connections which a publish was written to, for a time window,
+// see `CompositeStackExchange`. It's bounded by the number of the publishes.
+type exchangeDedup struct {
+	window time.Duration
+	size   int
+
+	mu      sync.Mutex
+	records map[string]*dedupRecord
+	// the publish identifiers by their arrival, a ring of "size" entries.
+	order []string
+	head  int
+}
+
+type dedupRecord struct {
+	expires time.Time
+	conns   map[*Conn]struct{}
+	// the index of the record in the ring.
+	slot int
+}
+
+func newExchangeDedup(window time.Duration, size int) *exchangeDedup {
+	return &exchangeDedup{
+		window:  window,
+		size:    size,
+		records: make(map[string]*dedupRecord),
+		order:   make([]string, 0, size),
+	}
+}
+
+// seen reports whether the publish of the "publishID" was already written to the "c" connection
+// and remembers it otherwise.
+func (d *exchangeDedup) seen(publishID string, c *Conn) bool {
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	record, ok := d.records[publishID]
+	if ok && now.After(record.expires) {
+		// a late duplicate is delivered.
+		delete(d.records, publishID)
+		ok = false
+	}
+
+	if !ok {
+		record = &dedupRecord{expires: now.Add(d.window), conns: make(map[*Conn]struct{})}
+		d.remember(publishID, record)
+	}
+
+	if _, ok = record.conns[c]; ok {
+		return true
+	}
+
+	record.conns[c] = struct{}{}
+	return false
+}
+
+// remember adds the "record" of the "publishID" to the ring, the oldest one is forgotten when it's full.
+func (d *exchangeDedup) remember(publishID string, record *dedupRecord) {
+	d.records[publishID] = record
+
+	if len(d.order) < d.size {
+		record.slot = len(d.order)
+		d.order = append(d.order, publishID)
+		return
+	}
+
+	// it may be remembered again, after its expiration, on another slot.
+	if oldest, ok := d.records[d.order[d.head]]; ok && oldest.slot == d.head {
+		delete(d.records, d.order[d.head])
+	}
+
+	record.slot = d.head
+	d.order[d.head] = publishID
+	d.head = (d.head + 1) % d.size
+}
+
+// len returns the number of the remembered publishes.
+func (d *exchangeDedup) len() int {
+	d.mu.Lock()
+	n := len(d.records)
+	d.mu.Unlock()
+	return n
+}
@@ -0,0 +1,49 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExchangeDedup(t *testing.T) {
+	var (
+		d     = newExchangeDedup(time.Hour, 2)
+		connA = new(Conn)
+		connB = new(Conn)
+	)
+
+	if d.seen("1", connA) || d.seen("1", connB) {
+		t.Fatalf("expected the first delivery of a publish to each connection to pass")
+	}
+
+	if !d.seen("1", connA) || !d.seen("1", connB) {
+		t.Fatalf("expected a second delivery of a publish to be a duplicate")
+	}
+
+	// the oldest publish is forgotten.
+	d.seen("2", connA)
+	d.seen("3", connA)
+	if expected, got := 2, d.len(); expected != got {
+		t.Fatalf("expected %d remembered publishes but got: %d", expected, got)
+	}
+
+	if d.seen("1", connA) {
+		t.Fatalf("expected a forgotten publish to pass")
+	}
+
+	if !d.seen("3", connA) {
+		t.Fatalf("expected a remembered publish to be a duplicate")
+	}
+
+	// the window is over.
+	d = newExchangeDedup(time.Millisecond, 2)
+	d.seen("1", connA)
+	time.Sleep(5 * time.Millisecond)
+	if d.seen("1", connA) {
+		t.Fatalf("expected a publish to pass after its window")
+	}
+
+	if expected, got := 1, d.len(); expected != got {
+		t.Fatalf("expected %d remembered publishes but got: %d", expected, got)
+	}
+}
@@ -0,0 +1,104 @@
+package neffos
+
+import "bytes"
+
+// ExchangeEnvelopeVersion is the latest version of the StackExchange envelope
+// that this package writes and understands, see `Message.SerializeExchange`.
+//
+// An envelope starts with the `exchangeEnvelopeMagic` byte and its version byte,
+// followed by its body. There is no negotiation between the server instances,
+// a reader accepts any version by the following compatibility table:
+//
+//	Version  Header      Body                                           Read by
+//	0        none        wait;namespace;room;event;isError;isNoOp;body  all versions
+//	1        0x1E 0x01   same as 0                                      1 and newer
+//	N > 1    0x1E N      same as 0, with newer extensions               1 and newer, partly
+//
+// A newer version should keep the body of the version 1 and only add extensions,
+// the readers skip the extensions they don't know, so a newer envelope is parsed by
+// the fields an older reader knows instead of being dropped during a rolling upgrade.
+// Version 0 readers (servers before the versioned envelope) do not understand the header,
+// see `ExchangeEnvelopeWriteVersion` to upgrade from them.
+//
+// The body of a versioned envelope may be compressed, see `Server.SetStackExchangeCompression`,
+// it's marked by the `exchangeEnvelopeCompressed` byte right after the header:
+//
+//	0x1E N 0x1A <deflate body>
+//
+// so the version stays readable and an uncompressed envelope is parsed as before.
+const ExchangeEnvelopeVersion byte = 1
+
+// ExchangeEnvelopeWriteVersion is the version of the envelopes written by `Message.SerializeExchange`.
+// Defaults to the `ExchangeEnvelopeVersion`. Set it to 0 while rolling
+// an upgrade from servers which do not read versioned envelopes,
+// and restore it once all of them are upgraded.
+var ExchangeEnvelopeWriteVersion = ExchangeEnvelopeVersion
+
+// the first byte of a versioned envelope, the ASCII record separator.
+// A version 0 envelope never starts with it, it starts with its wait token or its separator.
+const exchangeEnvelopeMagic byte = 0x1E
+
+// the first byte of a compressed envelope body, the ASCII substitute.
+// An uncompressed body never starts with it, like the version 0 envelope.
+const exchangeEnvelopeCompressed byte = 0x1A
+
+// exchangeCompression is the compression of the published envelopes of a server,
+// see `Server.SetStackExchangeCompression`.
+type exchangeCompression struct {
+	threshold int
+	counters  *counters
+}
+
+// writeExchangeEnvelope writes the envelope of the "msg" to the "buf" and returns its bytes.
+func writeExchangeEnvelope(buf *bytes.Buffer, msg Message) []byte {
+	version := ExchangeEnvelopeWriteVersion
+	if version > ExchangeEnvelopeVersion {
+		version = ExchangeEnvelopeVersion
+	}
+
+	if version > 0 {
+		buf.WriteByte(exchangeEnvelopeMagic)
+		buf.WriteByte(version)
+	}
+
+	header := buf.Len()
+	b := writeMessage(buf, msg, true)
+
+	// version 0 readers do not understand the compressed body.
+	if compression := msg.exchangeCompression; compression != nil && version > 0 && len(b)-header >= compression.threshold {
+		compressed := compressBody(b[header:])
+		if len(compressed)+1 >= len(b)-header {
+			// i.e a random body.
+			return b
+		}
+
+		if c := compression.counters; c != nil {
+			c.incr(&c.exchangeCompressed)
+			c.add(&c.exchangeCompressedRawBytes, len(b)-header)
+			c.add(&c.exchangeCompressedBytes, len(compressed)+1)
+		}
+
+		buf.Truncate(header)
+		buf.WriteByte(exchangeEnvelopeCompressed)
+		buf.Write(compressed)
+		return buf.Bytes()
+	}
+
+	return b
+}
+
+// readExchangeEnvelope returns the version and the body of the envelope "b", a compressed body is decompressed.
+// Unknown newer versions are returned as they are, their body is parsed like the latest known one.
+func readExchangeEnvelope(b []byte) (byte, []byte, error) {
+	if len(b) >= 2 && b[0] == exchangeEnvelopeMagic {
+		version, body := b[1], b[2:]
+		if len(body) > 0 && body[0] == exchangeEnvelopeCompressed {
+			body, err := decompressBody(body[1:], 0)
+			return version, body, err
+		}
+
+		return version, body, nil
+	}
+
+	return 0, b, nil
+}
@@ -0,0 +1,136 @@
+package neffos
+
+import (
+	"context"
+	"time"
+)
+
+// InstrumentStackExchange wraps the "exc" to count its traffic,
+// the counters are exposed through the `Metrics.StackExchange` of the server which registers it,
+// use the `Server.UseStackExchange` to register it.
+//
+// It counts the publishes, the publish errors, the received messages and their body sizes.
+// When the `Server.StampSentAt` is true, the `Message.SentAt` of the published messages is stamped
+// and the latency from their publish to their write to a connection is observed as well.
+// The pool statistics of a `StackExchangePoolReporter` are reported too.
+//
+// The optional interfaces of the "exc", i.e `AskableStackExchange` and `RoomStackExchange`,
+// are forwarded.
+func InstrumentStackExchange(exc StackExchange) StackExchange {
+	return &instrumentedStackExchange{StackExchange: exc}
+}
+
+// unwrapStackExchange returns the stackexchange which is wrapped by the `InstrumentStackExchange`, if any.
+func unwrapStackExchange(exc StackExchange) StackExchange {
+	if in, ok := exc.(*instrumentedStackExchange); ok {
+		return in.StackExchange
+	}
+
+	return exc
+}
+
+type instrumentedStackExchange struct {
+	StackExchange
+
+	// set on `Server.UseStackExchange`.
+	counters *counters
+}
+
+var (
+	_ StackExchangeInitializer      = (*instrumentedStackExchange)(nil)
+	_ AskableStackExchange          = (*instrumentedStackExchange)(nil)
+	_ RoomStackExchange             = (*instrumentedStackExchange)(nil)
+	_ StackExchangeErrorReporter    = (*instrumentedStackExchange)(nil)
+	_ ClosableStackExchange         = (*instrumentedStackExchange)(nil)
+	_ PingableStackExchange         = (*instrumentedStackExchange)(nil)
+	_ OriginStackExchange           = (*instrumentedStackExchange)(nil)
+	_ StaticNamespacesStackExchange = (*instrumentedStackExchange)(nil)
+)
+
+func (exc *instrumentedStackExchange) Publish(msgs []Message) bool {
+	ok := exc.StackExchange.Publish(msgs)
+
+	if c := exc.counters; c != nil {
+		field := &c.exchangePublished
+		if !ok {
+			field = &c.exchangePublishErrors
+		}
+		c.add(field, len(msgs))
+
+		if ok {
+			for i := range msgs {
+				c.add(&c.exchangePublishedBytes, len(msgs[i].Body))
+			}
+		}
+	}
+
+	return ok
+}
+
+func (exc *instrumentedStackExchange) Init(namespaces Namespaces) error {
+	return stackExchangeInit(exc.StackExchange, namespaces)
+}
+
+func (exc *instrumentedStackExchange) AskConn(ctx context.Context, connID string, msg Message) (Message, error) {
+	if askable, ok := exc.StackExchange.(AskableStackExchange); ok {
+		return askable.AskConn(ctx, connID, msg)
+	}
+
+	msg.To = connID
+	token := genWait(false)
+	msg.wait = genWaitStackExchange(token)
+	return exc.StackExchange.Ask(ctx, msg, token)
+}
+
+func (exc *instrumentedStackExchange) Close() error {
+	if closer, ok := exc.StackExchange.(ClosableStackExchange); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func (exc *instrumentedStackExchange) Ping(ctx context.Context) error {
+	if pinger, ok := exc.StackExchange.(PingableStackExchange); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (exc *instrumentedStackExchange) PreservesOrigin() bool {
+	return preservesOrigin(exc.StackExchange)
+}
+
+func (exc *instrumentedStackExchange) RequiresStaticNamespaces() bool {
+	return requiresStaticNamespaces(exc.StackExchange)
+}
+
+func (exc *instrumentedStackExchange) SubscribeRoom(namespace, room string) {
+	if roomExc, ok := exc.StackExchange.(RoomStackExchange); ok {
+		roomExc.SubscribeRoom(namespace, room)
+	}
+}
+
+func (exc *instrumentedStackExchange) UnsubscribeRoom(namespace, room string) {
+	if roomExc, ok := exc.StackExchange.(RoomStackExchange); ok {
+		roomExc.UnsubscribeRoom(namespace, room)
+	}
+}
+
+func (exc *instrumentedStackExchange) SetErrorHandler(handler func(err error, recovered bool)) {
+	if r, ok := exc.StackExchange.(StackExchangeErrorReporter); ok {
+		r.SetErrorHandler(handler)
+	}
+}
+
+// observeExchangeReceive counts a message of a `StackExchange` which is written to a connection,
+// see `InstrumentStackExchange`.
+func (c *counters) observeExchangeReceive(msg Message) {
+	c.incr(&c.exchangeReceived)
+	c.add(&c.exchangeReceivedBytes, len(msg.Body))
+
+	if msg.SentAt > 0 {
+		c.exchangeLatency.observe(time.Duration(nowMillis()-msg.SentAt) * time.Millisecond)
+	}
+}
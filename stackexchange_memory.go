@@ -0,0 +1,321 @@
+package neffos
+
+import (
+	"context"
+	"sync"
+)
+
+// InMemoryStackExchange is a `StackExchange` which connects servers of the same process,
+// i.e for integration tests of multiple server instances without a real broker.
+// It transfers the messages through their StackExchange envelope, like the real ones,
+// see `Message.SerializeExchange`.
+//
+// Use the `NewInMemoryStackExchange` to create a new one
+// and register it to each one of the servers through their `Server.UseStackExchange`.
+type InMemoryStackExchange struct {
+	mu    sync.RWMutex
+	conns map[*Conn]map[string]struct{} // the subscribed namespaces of each connection.
+	asks  map[string]chan Message
+
+	queue     chan []byte
+	queueOnce sync.Once
+
+	// the cluster presence of each joined instance.
+	presenceMu sync.RWMutex
+	presence   map[string]*memoryPresence
+}
+
+// the presence records of a server instance.
+type memoryPresence struct {
+	names map[string]int // connection IDs and aliases.
+	total int
+}
+
+var (
+	_ StackExchange         = (*InMemoryStackExchange)(nil)
+	_ AskableStackExchange  = (*InMemoryStackExchange)(nil)
+	_ PresenceStackExchange = (*InMemoryStackExchange)(nil)
+	_ UnicastStackExchange  = (*InMemoryStackExchange)(nil)
+	_ OriginStackExchange   = (*InMemoryStackExchange)(nil)
+)
+
+// NewInMemoryStackExchange returns a new in-memory StackExchange.
+// It delivers the messages synchronously, before `Publish` returns,
+// use its `SetQueueSize` to deliver them through a queue instead.
+func NewInMemoryStackExchange() *InMemoryStackExchange {
+	return &InMemoryStackExchange{
+		conns:    make(map[*Conn]map[string]struct{}),
+		asks:     make(map[string]chan Message),
+		presence: make(map[string]*memoryPresence),
+	}
+}
+
+// SetQueueSize makes the delivery asynchronous, through a buffered queue of "size" messages,
+// the messages are delivered in the order they were published.
+// A `Publish` blocks while the queue is full.
+// It should be called once, before the exchange is registered to a server.
+func (exc *InMemoryStackExchange) SetQueueSize(size int) *InMemoryStackExchange {
+	if size <= 0 {
+		return exc
+	}
+
+	exc.queueOnce.Do(func() {
+		exc.queue = make(chan []byte, size)
+		go func() {
+			for b := range exc.queue {
+				exc.deliver(b)
+			}
+		}()
+	})
+
+	return exc
+}
+
+// OnConnect registers the connection for its direct messages.
+func (exc *InMemoryStackExchange) OnConnect(c *Conn) error {
+	exc.mu.Lock()
+	exc.conns[c] = make(map[string]struct{})
+	exc.mu.Unlock()
+	return nil
+}
+
+// OnDisconnect removes the connection which registered on the `OnConnect` method.
+func (exc *InMemoryStackExchange) OnDisconnect(c *Conn) {
+	exc.mu.Lock()
+	delete(exc.conns, c)
+	exc.mu.Unlock()
+}
+
+// Subscribe subscribes the connection to a specific namespace.
+func (exc *InMemoryStackExchange) Subscribe(c *Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		namespaces[namespace] = struct{}{}
+	}
+	exc.mu.Unlock()
+}
+
+// Unsubscribe unsubscribes the connection from a specific namespace.
+func (exc *InMemoryStackExchange) Unsubscribe(c *Conn, namespace string) {
+	exc.mu.Lock()
+	if namespaces, ok := exc.conns[c]; ok {
+		delete(namespaces, namespace)
+	}
+	exc.mu.Unlock()
+}
+
+// PreservesOrigin implements the `OriginStackExchange`, the messages are delivered as they are published.
+func (exc *InMemoryStackExchange) PreservesOrigin() bool {
+	return true
+}
+
+// Publish delivers the messages to the connections of all the servers
+// which are registered to this exchange.
+func (exc *InMemoryStackExchange) Publish(msgs []Message) bool {
+	for _, msg := range msgs {
+		b := msg.SerializeExchange()
+
+		if exc.queue != nil {
+			exc.queue <- b
+			continue
+		}
+
+		exc.deliver(b)
+	}
+
+	return true
+}
+
+func (exc *InMemoryStackExchange) deliver(b []byte) {
+	msg := DeserializeExchangeMessage(b)
+
+	var receivers []*Conn
+	exc.mu.RLock()
+	for c, namespaces := range exc.conns {
+		if msg.To != "" {
+			if c.ID() == msg.To {
+				receivers = append(receivers, c)
+			}
+			continue
+		}
+
+		if _, ok := namespaces[msg.Namespace]; ok {
+			receivers = append(receivers, c)
+		}
+	}
+	exc.mu.RUnlock()
+
+	for _, c := range receivers {
+		c.Write(c.DeserializeExchangeMessage(b))
+	}
+}
+
+// Ask publishes the "msg" and blocks until its first response, see `NotifyAsk`.
+func (exc *InMemoryStackExchange) Ask(ctx context.Context, msg Message, token string) (Message, error) {
+	ch := make(chan Message, 1)
+	exc.mu.Lock()
+	exc.asks[token] = ch
+	exc.mu.Unlock()
+
+	defer func() {
+		exc.mu.Lock()
+		delete(exc.asks, token)
+		exc.mu.Unlock()
+	}()
+
+	if !exc.Publish([]Message{msg}) {
+		return Message{}, ErrWrite
+	}
+
+	select {
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case response := <-ch:
+		return response, response.Err
+	}
+}
+
+// NotifyAsk unblocks the `Ask` of the "token", if it's still waiting.
+func (exc *InMemoryStackExchange) NotifyAsk(msg Message, token string) error {
+	exc.mu.RLock()
+	ch, ok := exc.asks[token]
+	exc.mu.RUnlock()
+
+	if ok {
+		msg.ClearWait()
+		select {
+		case ch <- DeserializeExchangeMessage(msg.SerializeExchange()):
+		default: // a response is already there.
+		}
+	}
+
+	return nil
+}
+
+// AskConn asks the "connID" connection of any registered server and blocks until its response,
+// it returns the `ErrConnNotFound` if that connection is not registered.
+func (exc *InMemoryStackExchange) AskConn(ctx context.Context, connID string, msg Message) (Message, error) {
+	var receiver *Conn
+	exc.mu.RLock()
+	for c := range exc.conns {
+		if c.ID() == connID {
+			receiver = c
+			break
+		}
+	}
+	exc.mu.RUnlock()
+
+	if receiver == nil {
+		return Message{}, ErrConnNotFound
+	}
+
+	msg.To = connID
+	response, err := receiver.Ask(ctx, receiver.DeserializeExchangeMessage(msg.SerializeExchange()))
+	if err != nil {
+		return Message{}, err
+	}
+
+	return DeserializeExchangeMessage(response.SerializeExchange()), nil
+}
+
+// SendToInstance delivers the "msg" to the connections of the "msg.To" ID of the "instanceID" server only,
+// it returns the `ErrConnNotFound` if that server has no connection of that ID.
+func (exc *InMemoryStackExchange) SendToInstance(ctx context.Context, instanceID string, msg Message) error {
+	b := msg.SerializeExchange()
+
+	var receivers []*Conn
+	exc.mu.RLock()
+	for c := range exc.conns {
+		if c.ID() == msg.To && c.Server() != nil && c.Server().InstanceID() == instanceID {
+			receivers = append(receivers, c)
+		}
+	}
+	exc.mu.RUnlock()
+
+	if len(receivers) == 0 {
+		return ErrConnNotFound
+	}
+
+	delivered := false
+	for _, c := range receivers {
+		if c.Write(c.DeserializeExchangeMessage(b)) {
+			delivered = true
+		}
+	}
+
+	if !delivered {
+		return ErrWrite
+	}
+
+	return nil
+}
+
+// PresenceJoin registers the "instanceID" server instance to the cluster presence.
+// The records do not expire, they are removed on `PresenceLeave`.
+func (exc *InMemoryStackExchange) PresenceJoin(instanceID string) error {
+	exc.presenceMu.Lock()
+	exc.presence[instanceID] = &memoryPresence{names: make(map[string]int)}
+	exc.presenceMu.Unlock()
+	return nil
+}
+
+// PresenceLeave removes the records of the "instanceID".
+func (exc *InMemoryStackExchange) PresenceLeave(instanceID string) {
+	exc.presenceMu.Lock()
+	delete(exc.presence, instanceID)
+	exc.presenceMu.Unlock()
+}
+
+// PresenceConnected registers the "connID" and its "aliases" to the "instanceID".
+func (exc *InMemoryStackExchange) PresenceConnected(instanceID, connID string, aliases []string) {
+	exc.presenceMu.Lock()
+	if p, ok := exc.presence[instanceID]; ok {
+		p.total++
+		p.names[connID]++
+		for _, alias := range aliases {
+			p.names[alias]++
+		}
+	}
+	exc.presenceMu.Unlock()
+}
+
+// PresenceDisconnected removes a connection registered by the `PresenceConnected`.
+func (exc *InMemoryStackExchange) PresenceDisconnected(instanceID, connID string, aliases []string) {
+	exc.presenceMu.Lock()
+	if p, ok := exc.presence[instanceID]; ok {
+		p.total--
+		for _, name := range append([]string{connID}, aliases...) {
+			if p.names[name]--; p.names[name] <= 0 {
+				delete(p.names, name)
+			}
+		}
+	}
+	exc.presenceMu.Unlock()
+}
+
+// PresenceLookup returns the instance which holds a connection of the "name" ID or alias.
+func (exc *InMemoryStackExchange) PresenceLookup(ctx context.Context, name string) (string, bool, error) {
+	exc.presenceMu.RLock()
+	defer exc.presenceMu.RUnlock()
+
+	for instanceID, p := range exc.presence {
+		if _, ok := p.names[name]; ok {
+			return instanceID, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// PresenceTotal returns the number of the connections of all the joined instances.
+func (exc *InMemoryStackExchange) PresenceTotal(ctx context.Context) (int, error) {
+	exc.presenceMu.RLock()
+	defer exc.presenceMu.RUnlock()
+
+	total := 0
+	for _, p := range exc.presence {
+		total += p.total
+	}
+
+	return total, nil
+}
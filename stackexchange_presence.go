@@ -0,0 +1,204 @@
+package neffos
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// the states of a connection's cluster presence.
+const (
+	presenceNone uint32 = iota
+	presenceAnnouncing
+	presenceAnnounced
+	presenceWithdrawn
+)
+
+// joinPresence registers the "exc" as the server's presence registry
+// if it supports it, see `PresenceStackExchange`.
+func (s *Server) joinPresence(exc StackExchange) error {
+	exc = unwrapStackExchange(exc)
+
+	p, ok := exc.(PresenceStackExchange)
+	if !ok {
+		return nil
+	}
+
+	if err := p.PresenceJoin(s.uuid); err != nil {
+		if err == ErrPresenceUnsupported {
+			return nil
+		}
+		return err
+	}
+
+	s.presence = p
+	// the instances are resolved by the same stackexchange.
+	s.unicast, _ = exc.(UnicastStackExchange)
+	return nil
+}
+
+// announcePresence registers the "c" connection and its aliases to the cluster presence.
+// A connection which is disconnected while it's announced is withdrawn right after.
+func (s *Server) announcePresence(c *Conn) {
+	if s.PresenceAliases != nil {
+		c.presenceAliases = s.PresenceAliases(c)
+	}
+
+	if !atomic.CompareAndSwapUint32(&c.presenceState, presenceNone, presenceAnnouncing) {
+		return
+	}
+
+	s.presence.PresenceConnected(s.uuid, c.ID(), c.presenceAliases)
+
+	if !atomic.CompareAndSwapUint32(&c.presenceState, presenceAnnouncing, presenceAnnounced) {
+		// disconnected meanwhile.
+		s.presence.PresenceDisconnected(s.uuid, c.ID(), c.presenceAliases)
+	}
+}
+
+// withdrawPresence removes the "c" connection from the cluster presence,
+// it does not block the caller.
+func (s *Server) withdrawPresence(c *Conn) {
+	if atomic.SwapUint32(&c.presenceState, presenceWithdrawn) == presenceAnnounced {
+		go s.presence.PresenceDisconnected(s.uuid, c.ID(), c.presenceAliases)
+	}
+}
+
+// ClusterLookup reports whether a connection of the "connID" ID, or of a `PresenceAliases` alias,
+// is connected to any server instance of the cluster and returns the `InstanceID` of that instance.
+// The connections of this server are found without a round trip.
+//
+// It returns the `ErrPresenceUnsupported` if the server uses stackexchanges
+// and none of them is a `PresenceStackExchange`.
+func (s *Server) ClusterLookup(ctx context.Context, connID string) (instanceID string, found bool, err error) {
+	s.connectionsByIDMutex.RLock()
+	_, found = s.connectionsByID[connID]
+	s.connectionsByIDMutex.RUnlock()
+
+	if found {
+		return s.uuid, true, nil
+	}
+
+	if s.presence == nil {
+		if s.usesStackExchange() {
+			return "", false, ErrPresenceUnsupported
+		}
+
+		// this is the whole cluster.
+		return "", false, nil
+	}
+
+	return s.presence.PresenceLookup(ctx, connID)
+}
+
+// ClusterTotalConnections returns the number of the connections of all the server instances of the cluster.
+//
+// It returns the `ErrPresenceUnsupported` if the server uses stackexchanges
+// and none of them is a `PresenceStackExchange`.
+func (s *Server) ClusterTotalConnections(ctx context.Context) (int, error) {
+	if s.presence == nil {
+		if s.usesStackExchange() {
+			return 0, ErrPresenceUnsupported
+		}
+
+		return int(s.GetTotalConnections()), nil
+	}
+
+	return s.presence.PresenceTotal(ctx)
+}
+
+// SendToCluster sends the "msg" to the connections of the "connID" ID, wherever they are connected.
+// The connections of this server are written directly, otherwise the owner instance is resolved
+// through the cluster presence (see `ClusterLookup`) and the message is delivered to that instance only,
+// if the `StackExchange` is an `UnicastStackExchange`, or published to all of them through the `StackExchange`.
+// If the owner does not confirm the delivery, i.e it has crashed after the lookup, the lookup is retried once.
+//
+// It returns the `ErrConnNotFound` if no server instance holds a connection of that ID.
+// Without a cluster presence the message is published to all the instances, like the `Broadcast`
+// of a `Message.To`, and the `ErrConnNotFound` is returned only if the server does not use a `StackExchange`.
+func (s *Server) SendToCluster(ctx context.Context, connID string, msg Message) error {
+	msg.To = connID
+	msg.from = ""
+
+	if found, err := s.writeLocalTo(msg); found {
+		return err
+	}
+
+	if !s.usesStackExchange() {
+		return ErrConnNotFound
+	}
+
+	if s.exchangeFilter != nil && !s.exchangeFilter(msg) {
+		// instance-local, see `SetExchangeFilter`.
+		return ErrConnNotFound
+	}
+
+	if s.presence == nil {
+		if !s.publishToStackExchange([]Message{msg}) {
+			return ErrWrite
+		}
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		instanceID, found, lookupErr := s.presence.PresenceLookup(ctx, connID)
+		if lookupErr != nil {
+			return lookupErr
+		}
+
+		if !found {
+			return ErrConnNotFound
+		}
+
+		if instanceID == s.uuid {
+			// disconnected from this server before its presence was withdrawn.
+			if found, err = s.writeLocalTo(msg); found {
+				return err
+			}
+			return ErrConnNotFound
+		}
+
+		if s.unicast == nil {
+			if !s.publishToStackExchange([]Message{msg}) {
+				return ErrWrite
+			}
+			return nil
+		}
+
+		msg.origin = s.uuid
+		msg.exchangeCompression = s.exchangeCompression
+		if err = s.unicast.SendToInstance(ctx, instanceID, msg); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// writeLocalTo writes the "msg" to the connections of this server with the "msg.To" ID.
+// It reports whether there is at least one, the error is the `ErrWrite` if none of them accepted it.
+func (s *Server) writeLocalTo(msg Message) (bool, error) {
+	s.connectionsByIDMutex.RLock()
+	conns := make([]*Conn, 0, len(s.connectionsByID[msg.To]))
+	for c := range s.connectionsByID[msg.To] {
+		conns = append(conns, c)
+	}
+	s.connectionsByIDMutex.RUnlock()
+
+	if len(conns) == 0 {
+		return false, nil
+	}
+
+	delivered := false
+	for _, c := range conns {
+		if c.Write(msg) {
+			delivered = true
+		}
+	}
+
+	if !delivered {
+		return true, ErrWrite
+	}
+
+	return true, nil
+}
@@ -0,0 +1,100 @@
+package neffos
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// stackExchangeQueue decouples the broadcasts from the publishes of the server's `StackExchange`,
+// see `Server.SetStackExchangeQueue`.
+type stackExchangeQueue struct {
+	size     int
+	counters *counters
+	// called when the queue overflows for the first time since it was drained.
+	onDrop func()
+
+	mu       sync.Mutex
+	pending  []Message
+	dropping bool
+
+	signal  chan struct{}
+	flushes chan chan struct{}
+}
+
+func newStackExchangeQueue(size int, c *counters, onDrop func()) *stackExchangeQueue {
+	return &stackExchangeQueue{
+		size:     size,
+		counters: c,
+		onDrop:   onDrop,
+		signal:   make(chan struct{}, 1),
+		flushes:  make(chan chan struct{}),
+	}
+}
+
+// add queues the "msgs" without blocking,
+// the oldest messages are dropped when the queue is full.
+func (q *stackExchangeQueue) add(msgs []Message) {
+	q.mu.Lock()
+	q.pending = append(q.pending, msgs...)
+	dropped := 0
+	if n := len(q.pending) - q.size; n > 0 {
+		dropped = n
+		// do not keep the dropped ones reachable.
+		q.pending = append(q.pending[:0:0], q.pending[n:]...)
+	}
+	notify := dropped > 0 && !q.dropping
+	if dropped > 0 {
+		q.dropping = true
+	}
+	atomic.StoreUint64(&q.counters.exchangeQueueDepth, uint64(len(q.pending)))
+	q.mu.Unlock()
+
+	if dropped > 0 {
+		q.counters.add(&q.counters.exchangeQueueDropped, dropped)
+		if notify && q.onDrop != nil {
+			q.onDrop()
+		}
+	}
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// take removes and returns the queued messages.
+func (q *stackExchangeQueue) take() []Message {
+	q.mu.Lock()
+	msgs := q.pending
+	q.pending = nil
+	q.dropping = false
+	atomic.StoreUint64(&q.counters.exchangeQueueDepth, 0)
+	q.mu.Unlock()
+
+	return msgs
+}
+
+// run publishes the queued messages through the "publish",
+// a single goroutine publishes them in the order they were added.
+func (q *stackExchangeQueue) run(publish func(msgs []Message) bool) {
+	for {
+		select {
+		case <-q.signal:
+			if msgs := q.take(); len(msgs) > 0 {
+				publish(msgs)
+			}
+		case done := <-q.flushes:
+			if msgs := q.take(); len(msgs) > 0 {
+				publish(msgs)
+			}
+			close(done)
+		}
+	}
+}
+
+// flush publishes the queued messages and waits for it.
+func (q *stackExchangeQueue) flush() {
+	done := make(chan struct{})
+	q.flushes <- done
+	<-done
+}
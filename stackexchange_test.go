@@ -0,0 +1,1567 @@
+package neffos_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+)
+
+func TestStackExchangeEnvelope(t *testing.T) {
+	type observed struct {
+		Namespace, Room, Event string
+		Body                   string
+		SetBinary              bool
+	}
+
+	var (
+		namespace = "default"
+		body      = []byte("binary;data")
+		exc       = neffos.NewInMemoryStackExchange()
+
+		serverEvents = neffos.Namespaces{namespace: neffos.Events{
+			"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+				c.Conn.Server().Broadcast(c, msg)
+				return nil
+			},
+			"exclude": func(c *neffos.NSConn, msg neffos.Message) error {
+				c.Conn.Server().Broadcast(neffos.Exclude(string(msg.Body)), neffos.Message{Namespace: namespace, Event: "excluded"})
+				return nil
+			},
+		}}
+	)
+
+	newServer := func() *httptest.Server {
+		server := neffos.New(gorilla.DefaultUpgrader, serverEvents)
+		server.StackExchange = exc
+		return httptest.NewServer(server)
+	}
+
+	serverA, serverB := newServer(), newServer()
+	defer serverA.Close()
+	defer serverB.Close()
+
+	dial := func(s *httptest.Server, received chan<- observed) *neffos.NSConn {
+		record := func(c *neffos.NSConn, msg neffos.Message) error {
+			received <- observed{msg.Namespace, msg.Room, msg.Event, string(msg.Body), msg.SetBinary}
+			return nil
+		}
+
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(s.URL, "http", "ws", 1),
+			neffos.Namespaces{namespace: neffos.Events{"chat": record, "excluded": record}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	var (
+		sameInstance  = make(chan observed, 4)
+		otherInstance = make(chan observed, 4)
+		sender        = dial(serverA, make(chan observed, 4))
+		local         = dial(serverA, sameInstance)
+		remote        = dial(serverB, otherInstance)
+	)
+	defer sender.Conn.Close()
+	defer local.Conn.Close()
+	defer remote.Conn.Close()
+
+	receive := func(ch <-chan observed) observed {
+		select {
+		case o := <-ch:
+			return o
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected a message")
+			return observed{}
+		}
+	}
+
+	sender.EmitBinary("chat", body)
+
+	fromSame, fromOther := receive(sameInstance), receive(otherInstance)
+	if !reflect.DeepEqual(fromSame, fromOther) {
+		t.Fatalf("expected the same message regardless of the origin instance:\n%#+v\nbut got:\n%#+v", fromSame, fromOther)
+	}
+
+	if !fromOther.SetBinary || fromOther.Body != string(body) {
+		t.Fatalf("expected a binary message with body: %s but got: %#+v", body, fromOther)
+	}
+
+	// the excluded connection lives on the other instance.
+	sender.Emit("exclude", []byte(remote.Conn.ID()))
+
+	if o := receive(sameInstance); o.Event != "excluded" {
+		t.Fatalf("expected the excluded event but got: %#+v", o)
+	}
+
+	select {
+	case o := <-otherInstance:
+		t.Fatalf("expected the excluded connection to not receive the message but got: %#+v", o)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// errorReportingExchange is an `InMemoryStackExchange` which reports its asynchronous errors
+// and records its subscriptions.
+type errorReportingExchange struct {
+	*neffos.InMemoryStackExchange
+	handler    func(error, bool)
+	subscribed chan string
+}
+
+var _ neffos.StackExchangeErrorReporter = (*errorReportingExchange)(nil)
+
+func (exc *errorReportingExchange) SetErrorHandler(handler func(err error, recovered bool)) {
+	exc.handler = handler
+}
+
+func (exc *errorReportingExchange) Subscribe(c *neffos.Conn, namespace string) {
+	exc.InMemoryStackExchange.Subscribe(c, namespace)
+	exc.subscribed <- namespace
+}
+
+func TestStackExchangeErrorReporter(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = &errorReportingExchange{
+			InMemoryStackExchange: neffos.NewInMemoryStackExchange(),
+			subscribed:            make(chan string, 4),
+		}
+		expected  = errors.New("connection lost")
+		reported  error
+		recovered bool
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	defer server.Close()
+
+	if err := server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+
+	if exc.handler == nil {
+		t.Fatalf("expected the error handler to be registered")
+	}
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+		neffos.Namespaces{namespace: neffos.Events{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	expectSubscribe := func() {
+		t.Helper()
+
+		select {
+		case got := <-exc.subscribed:
+			if namespace != got {
+				t.Fatalf("expected a subscription to: %s but got: %s", namespace, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected a subscription to: %s", namespace)
+		}
+	}
+	expectSubscribe()
+
+	// not registered yet.
+	exc.handler(expected, false)
+	if server.StackExchangeHealthy() {
+		t.Fatalf("expected an unhealthy stackexchange after a failure")
+	}
+
+	server.OnStackExchangeError = func(err error, ok bool) { reported, recovered = err, ok }
+	exc.handler(expected, false)
+
+	if reported != expected || recovered {
+		t.Fatalf("expected the reported error: %v but got: %v (recovered: %v)", expected, reported, recovered)
+	}
+
+	exc.handler(expected, true)
+	if reported != expected || !recovered {
+		t.Fatalf("expected the recovery from: %v but got: %v (recovered: %v)", expected, reported, recovered)
+	}
+
+	if !server.StackExchangeHealthy() {
+		t.Fatalf("expected a healthy stackexchange after its recovery")
+	}
+
+	// the subscriptions of the connections are re-issued.
+	expectSubscribe()
+}
+
+func TestStackExchangeHealth(t *testing.T) {
+	var (
+		h        neffos.StackExchangeHealth
+		expected = errors.New("connection lost")
+		reported []string
+	)
+
+	h.SetErrorHandler(func(err error, recovered bool) {
+		reported = append(reported, fmt.Sprintf("%v:%v", err, recovered))
+	})
+
+	h.Recover() // no failure, no-op.
+	h.Fail(expected)
+	if h.Healthy() {
+		t.Fatalf("expected unhealthy after a failure")
+	}
+	h.Recover()
+	h.Recover()
+	if !h.Healthy() {
+		t.Fatalf("expected healthy after a recovery")
+	}
+
+	if expected, got := []string{"connection lost:false", "connection lost:true"}, reported; !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected reports: %v but got: %v", expected, got)
+	}
+}
+
+func TestInMemoryStackExchange(t *testing.T) {
+	for _, queueSize := range []int{0, 8} {
+		var (
+			namespace = "default"
+			servers   = 3
+			clients   = 2
+			times     = 20
+			exc       = neffos.NewInMemoryStackExchange().SetQueueSize(queueSize)
+			events    = neffos.Namespaces{namespace: neffos.Events{}}
+			received  = make(chan string, servers*clients*servers*times)
+		)
+
+		var (
+			srvs  []*neffos.Server
+			conns []*neffos.NSConn
+		)
+
+		for i := 0; i < servers; i++ {
+			server := neffos.New(gorilla.DefaultUpgrader, events)
+			if err := server.UseStackExchange(exc); err != nil {
+				t.Fatal(err)
+			}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			defer server.Close()
+			srvs = append(srvs, server)
+
+			for j := 0; j < clients; j++ {
+				client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+					neffos.Namespaces{namespace: neffos.Events{
+						"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+							received <- c.Conn.ID()
+							return nil
+						},
+					}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer client.Close()
+
+				c, err := client.Connect(context.TODO(), namespace)
+				if err != nil {
+					t.Fatal(err)
+				}
+				conns = append(conns, c)
+			}
+		}
+
+		// one client unsubscribes, it should not receive anything.
+		unsubscribed := conns[0]
+		if err := unsubscribed.Disconnect(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan struct{})
+		for _, server := range srvs {
+			go func(server *neffos.Server) {
+				for i := 0; i < times; i++ {
+					server.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify"})
+				}
+				done <- struct{}{}
+			}(server)
+		}
+		for range srvs {
+			<-done
+		}
+
+		counts := make(map[string]int)
+		for i := 0; i < (len(conns)-1)*servers*times; i++ {
+			select {
+			case id := <-received:
+				counts[id]++
+			case <-time.After(3 * time.Second):
+				t.Fatalf("[queue:%d] expected %d messages but got %d", queueSize, (len(conns)-1)*servers*times, i)
+			}
+		}
+
+		select {
+		case id := <-received:
+			t.Fatalf("[queue:%d] expected no more messages but got one for: %s", queueSize, id)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if n := counts[unsubscribed.Conn.ID()]; n > 0 {
+			t.Fatalf("[queue:%d] expected the unsubscribed connection to receive nothing but got %d messages", queueSize, n)
+		}
+
+		for _, c := range conns[1:] {
+			if expected, got := servers*times, counts[c.Conn.ID()]; expected != got {
+				t.Fatalf("[queue:%d] expected %d messages for: %s but got: %d", queueSize, expected, c.Conn.ID(), got)
+			}
+		}
+	}
+}
+
+// legacyStackExchange is an `InMemoryStackExchange` which does not opt in to the `OriginStackExchange`,
+// like a third-party stackexchange which publishes the `Message.Serialize` bytes.
+type legacyStackExchange struct {
+	*neffos.InMemoryStackExchange
+}
+
+func (exc *legacyStackExchange) PreservesOrigin() bool {
+	return false
+}
+
+// Publish drops the origin of the messages.
+func (exc *legacyStackExchange) Publish(msgs []neffos.Message) bool {
+	stripped := make([]neffos.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		stripped = append(stripped, neffos.Message{Namespace: msg.Namespace, Room: msg.Room, Event: msg.Event, Body: msg.Body, To: msg.To})
+	}
+
+	return exc.InMemoryStackExchange.Publish(stripped)
+}
+
+// customStackExchange hides the optional interfaces of its `StackExchange`,
+// like a third-party stackexchange which does not know about the `OriginStackExchange`.
+type customStackExchange struct {
+	neffos.StackExchange
+}
+
+func TestStackExchangeEchoSuppression(t *testing.T) {
+	tests := []struct {
+		name       string
+		exc        neffos.StackExchange
+		suppressed uint64
+	}{
+		{"origin", neffos.NewInMemoryStackExchange(), 1},
+		// the envelope is expected to be carried by default.
+		{"custom", &customStackExchange{neffos.NewInMemoryStackExchange()}, 1},
+		// the local connections receive the broadcast through the exchange, once.
+		{"legacy", &legacyStackExchange{neffos.NewInMemoryStackExchange()}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				namespace = "default"
+				exc       = tt.exc
+				received  = make(chan string, 8)
+			)
+
+			var servers []*neffos.Server
+			for i := 0; i < 2; i++ {
+				server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+				if err := server.UseStackExchange(exc); err != nil {
+					t.Fatal(err)
+				}
+				httpServer := httptest.NewServer(server)
+				defer httpServer.Close()
+				defer server.Close()
+				servers = append(servers, server)
+
+				client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+					neffos.Namespaces{namespace: neffos.Events{
+						"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+							received <- c.Conn.ID()
+							return nil
+						},
+					}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer client.Close()
+
+				if _, err = client.Connect(context.TODO(), namespace); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if servers[0].InstanceID() == "" || servers[0].InstanceID() == servers[1].InstanceID() {
+				t.Fatalf("expected unique instance IDs but got: %q and %q", servers[0].InstanceID(), servers[1].InstanceID())
+			}
+
+			// the exchange delivers the message to the publisher too.
+			servers[0].Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify"})
+
+			counts := make(map[string]int)
+			for i := 0; i < len(servers); i++ {
+				select {
+				case id := <-received:
+					counts[id]++
+				case <-time.After(3 * time.Second):
+					t.Fatalf("expected %d messages but got %d", len(servers), i)
+				}
+			}
+
+			select {
+			case id := <-received:
+				t.Fatalf("expected no duplicated messages but got one more for: %s", id)
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			if expected, got := len(servers), len(counts); expected != got {
+				t.Fatalf("expected %d receivers but got: %d", expected, got)
+			}
+
+			if expected, got := tt.suppressed, servers[0].Metrics().SuppressedEchoes; expected != got {
+				t.Fatalf("expected %d suppressed echoes but got: %d", expected, got)
+			}
+			if expected, got := uint64(0), servers[1].Metrics().SuppressedEchoes; expected != got {
+				t.Fatalf("expected %d suppressed echoes but got: %d", expected, got)
+			}
+		})
+	}
+}
+
+func TestServerExchangeFilter(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = neffos.NewInMemoryStackExchange()
+		received  = make(chan string, 8)
+	)
+
+	var (
+		servers []*neffos.Server
+		clients []*neffos.Client
+	)
+	for i := 0; i < 2; i++ {
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		server.SetExchangeFilter(func(msg neffos.Message) bool {
+			return msg.Event == "chat"
+		})
+		if err := server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+		defer server.Close()
+		servers = append(servers, server)
+
+		events := neffos.Events{}
+		for _, event := range []string{"chat", "local"} {
+			event := event
+			events[event] = func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- c.Conn.ID() + ":" + event
+				return nil
+			}
+		}
+
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+			neffos.Namespaces{namespace: events})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+
+		if _, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	servers[0].Broadcast(nil,
+		neffos.Message{Namespace: namespace, Event: "local"},
+		neffos.Message{Namespace: namespace, Event: "chat"})
+
+	expected := map[string]bool{
+		clients[0].ID + ":local": true,
+		clients[0].ID + ":chat":  true,
+		clients[1].ID + ":chat":  true,
+	}
+
+	for n := len(expected); n > 0; n-- {
+		select {
+		case got := <-received:
+			if !expected[got] {
+				t.Fatalf("unexpected message: %s", got)
+			}
+			delete(expected, got)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected messages: %v", expected)
+		}
+	}
+
+	select {
+	case got := <-received:
+		t.Fatalf("expected no more messages but got: %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStackExchangeAskConn(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = neffos.NewInMemoryStackExchange()
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	newServer := func() (*neffos.Server, string) {
+		server := neffos.New(gorilla.DefaultUpgrader, events)
+		if err := server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		t.Cleanup(func() {
+			server.Close()
+			httpServer.Close()
+		})
+		return server, strings.Replace(httpServer.URL, "http", "ws", 1)
+	}
+
+	serverA, _ := newServer()
+	_, urlB := newServer()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, urlB, neffos.Namespaces{namespace: neffos.Events{
+		"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+			return neffos.Reply(append(msg.Body, []byte(" ok")...))
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	response, err := serverA.Ask(ctx, neffos.Message{To: client.ID, Namespace: namespace, Event: "ask", Body: []byte("data")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "data ok", string(response.Body); expected != got {
+		t.Fatalf("expected response body: %s but got: %s", expected, got)
+	}
+
+	if _, err = serverA.Ask(ctx, neffos.Message{To: "unknown", Namespace: namespace, Event: "ask"}); err != neffos.ErrConnNotFound {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrConnNotFound, err)
+	}
+
+	// without a "To", the first reply of any server instance's connection.
+	response, err = serverA.Ask(ctx, neffos.Message{Namespace: namespace, Event: "ask", Body: []byte("any")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "any ok", string(response.Body); expected != got {
+		t.Fatalf("expected response body: %s but got: %s", expected, got)
+	}
+}
+
+// roomRecordingExchange is an `InMemoryStackExchange` which records its room subscriptions.
+type roomRecordingExchange struct {
+	*neffos.InMemoryStackExchange
+	events chan string
+}
+
+var _ neffos.RoomStackExchange = (*roomRecordingExchange)(nil)
+
+func (exc *roomRecordingExchange) SubscribeRoom(namespace, room string) {
+	exc.events <- "subscribe:" + namespace + ":" + room
+}
+
+func (exc *roomRecordingExchange) UnsubscribeRoom(namespace, room string) {
+	exc.events <- "unsubscribe:" + namespace + ":" + room
+}
+
+func TestRoomStackExchange(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = &roomRecordingExchange{InMemoryStackExchange: neffos.NewInMemoryStackExchange(), events: make(chan string, 8)}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+	if err := server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	defer server.Close()
+
+	var conns []*neffos.NSConn
+	for i := 0; i < 2; i++ {
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+			neffos.Namespaces{namespace: neffos.Events{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(context.TODO(), "room"); err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+	}
+
+	if err := conns[0].LeaveAll(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	// the last member, forced to leave.
+	if err := conns[1].Disconnect(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"subscribe:default:room", "unsubscribe:default:room"} {
+		select {
+		case got := <-exc.events:
+			if expected != got {
+				t.Fatalf("expected: %s but got: %s", expected, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected: %s", expected)
+		}
+	}
+
+	select {
+	case got := <-exc.events:
+		t.Fatalf("expected a single subscription but got: %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// batchRecordingExchange is an `InMemoryStackExchange` which records the published batches.
+type batchRecordingExchange struct {
+	*neffos.InMemoryStackExchange
+	batches chan []neffos.Message
+}
+
+func (exc *batchRecordingExchange) Publish(msgs []neffos.Message) bool {
+	exc.batches <- msgs
+	return exc.InMemoryStackExchange.Publish(msgs)
+}
+
+func TestServerStackExchangeBatch(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = &batchRecordingExchange{InMemoryStackExchange: neffos.NewInMemoryStackExchange(), batches: make(chan []neffos.Message, 8)}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+	if err := server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+	server.SetStackExchangeBatch(3, 100*time.Millisecond)
+
+	broadcast := func(bodies ...string) {
+		for _, body := range bodies {
+			server.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify", Body: []byte(body)})
+		}
+	}
+
+	expectBatch := func(expected ...string) {
+		t.Helper()
+
+		select {
+		case msgs := <-exc.batches:
+			got := make([]string, 0, len(msgs))
+			for _, msg := range msgs {
+				got = append(got, string(msg.Body))
+			}
+
+			if !reflect.DeepEqual(expected, got) {
+				t.Fatalf("expected batch: %v but got: %v", expected, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected batch: %v", expected)
+		}
+	}
+
+	// on size.
+	broadcast("1", "2", "3")
+	expectBatch("1", "2", "3")
+
+	// on max latency.
+	start := time.Now()
+	broadcast("4")
+	expectBatch("4")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected the batch to wait for its max latency but it was published after: %s", elapsed)
+	}
+
+	// on close.
+	broadcast("5", "6")
+	server.Close()
+	select {
+	case msgs := <-exc.batches:
+		if expected, got := 2, len(msgs); expected != got {
+			t.Fatalf("expected %d messages on close but got: %d", expected, got)
+		}
+	default:
+		t.Fatalf("expected the buffered messages to be published on close")
+	}
+}
+
+func TestInstrumentStackExchange(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = neffos.NewInMemoryStackExchange()
+		received  = make(chan struct{}, 3)
+	)
+
+	newServer := func() (*neffos.Server, string) {
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		server.StampSentAt = true
+		if err := server.UseStackExchange(neffos.InstrumentStackExchange(exc)); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		t.Cleanup(func() {
+			server.Close()
+			httpServer.Close()
+		})
+		return server, strings.Replace(httpServer.URL, "http", "ws", 1)
+	}
+
+	serverA, _ := newServer()
+	serverB, urlB := newServer()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, urlB, neffos.Namespaces{namespace: neffos.Events{
+		"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+			received <- struct{}{}
+			return nil
+		},
+		"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+			return neffos.Reply([]byte("ok"))
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		serverA.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify", Body: []byte("data")})
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-received:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected 3 messages but got %d", i)
+		}
+	}
+
+	published := serverA.Metrics().StackExchange
+	if published.Published != 3 || published.PublishedBytes != 12 || published.PublishErrors != 0 {
+		t.Fatalf("unexpected publish metrics: %#+v", published)
+	}
+
+	got := serverB.Metrics().StackExchange
+	if got.Received != 3 || got.ReceivedBytes != 12 {
+		t.Fatalf("unexpected receive metrics: %#+v", got)
+	}
+
+	if expected, got := uint64(3), got.Latency.Count; expected != got {
+		t.Fatalf("expected %d latency observations but got: %d", expected, got)
+	}
+
+	if expected, got := len(neffos.LatencyBuckets)+1, len(got.Latency.Counts); expected != got {
+		t.Fatalf("expected %d latency buckets but got: %d", expected, got)
+	}
+
+	// the optional interfaces are forwarded.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	response, err := serverA.Ask(ctx, neffos.Message{To: client.ID, Namespace: namespace, Event: "ask"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "ok", string(response.Body); expected != got {
+		t.Fatalf("expected response: %s but got: %s", expected, got)
+	}
+
+	if _, err = serverA.Ask(ctx, neffos.Message{To: "unknown", Namespace: namespace, Event: "ask"}); err != neffos.ErrConnNotFound {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrConnNotFound, err)
+	}
+
+	rooms := &roomRecordingExchange{InMemoryStackExchange: neffos.NewInMemoryStackExchange(), events: make(chan string, 1)}
+	neffos.InstrumentStackExchange(rooms).(neffos.RoomStackExchange).SubscribeRoom(namespace, "room")
+	if expected, got := "subscribe:default:room", <-rooms.events; expected != got {
+		t.Fatalf("expected: %s but got: %s", expected, got)
+	}
+}
+
+func TestStackExchangePresence(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = neffos.NewInMemoryStackExchange()
+		ctx       = context.TODO()
+	)
+
+	var (
+		servers []*neffos.Server
+		clients []*neffos.Client
+	)
+	for i, id := range []string{"a", "b"} {
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		server.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			return r.URL.Query().Get("id")
+		}
+		if i == 1 {
+			server.PresenceAliases = func(c *neffos.Conn) []string {
+				return []string{"alice"}
+			}
+		}
+		if err := server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+		defer server.Close()
+		servers = append(servers, server)
+
+		client, err := neffos.Dial(ctx, gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1)+"?id="+id,
+			neffos.Namespaces{namespace: neffos.Events{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+
+	lookup := func(server *neffos.Server, name string) (string, bool) {
+		t.Helper()
+
+		instanceID, found, err := server.ClusterLookup(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instanceID, found
+	}
+
+	for _, tt := range []struct {
+		server   *neffos.Server
+		name     string
+		expected *neffos.Server
+	}{
+		{servers[0], "a", servers[0]},
+		{servers[0], "b", servers[1]},
+		{servers[1], "a", servers[0]},
+		{servers[0], "alice", servers[1]},
+		{servers[1], "missing", nil},
+	} {
+		instanceID, found := lookup(tt.server, tt.name)
+		if tt.expected == nil {
+			if found {
+				t.Fatalf("[%s] expected not found but got instance: %s", tt.name, instanceID)
+			}
+			continue
+		}
+
+		if !found || instanceID != tt.expected.InstanceID() {
+			t.Fatalf("[%s] expected instance: %s but got: %s (found: %v)", tt.name, tt.expected.InstanceID(), instanceID, found)
+		}
+	}
+
+	if total, err := servers[1].ClusterTotalConnections(ctx); err != nil || total != 2 {
+		t.Fatalf("expected 2 connections but got: %d (%v)", total, err)
+	}
+
+	clients[1].Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, foundID := lookup(servers[0], "b")
+		_, foundAlias := lookup(servers[0], "alice")
+		total, _ := servers[0].ClusterTotalConnections(ctx)
+		if !foundID && !foundAlias && total == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the disconnected connection to be removed but got: id=%v, alias=%v, total=%d", foundID, foundAlias, total)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// the records of a closed server are removed.
+	servers[0].Close()
+	if _, found := lookup(servers[1], "a"); found {
+		t.Fatalf("expected the connection of a closed server to be removed")
+	}
+}
+
+func TestStackExchangePresenceUnsupported(t *testing.T) {
+	ctx := context.TODO()
+
+	// a single server is the whole cluster.
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{"default": neffos.Events{}})
+	defer server.Close()
+
+	if _, found, err := server.ClusterLookup(ctx, "missing"); err != nil || found {
+		t.Fatalf("expected not found without error but got: %v (%v)", found, err)
+	}
+
+	if total, err := server.ClusterTotalConnections(ctx); err != nil || total != 0 {
+		t.Fatalf("expected 0 connections without error but got: %d (%v)", total, err)
+	}
+
+	// hides the presence of the in-memory exchange.
+	exc := struct{ neffos.StackExchange }{neffos.NewInMemoryStackExchange()}
+	if err := server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := server.ClusterLookup(ctx, "missing"); err != neffos.ErrPresenceUnsupported {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrPresenceUnsupported, err)
+	}
+
+	if _, err := server.ClusterTotalConnections(ctx); err != neffos.ErrPresenceUnsupported {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrPresenceUnsupported, err)
+	}
+}
+
+// staticNamespacesExchange is an `InMemoryStackExchange` which receives the messages of its `Init` namespaces only.
+type staticNamespacesExchange struct {
+	*neffos.InMemoryStackExchange
+}
+
+func (exc *staticNamespacesExchange) RequiresStaticNamespaces() bool {
+	return true
+}
+
+func TestStackExchangeStaticNamespaces(t *testing.T) {
+	newExc := func() neffos.StackExchange {
+		return &staticNamespacesExchange{neffos.NewInMemoryStackExchange()}
+	}
+
+	tests := []struct {
+		name string
+		exc  neffos.StackExchange
+	}{
+		{"plain", newExc()},
+		{"instrumented", neffos.InstrumentStackExchange(newExc())},
+		{"composite", neffos.NewCompositeStackExchange(neffos.NewInMemoryStackExchange(), newExc(), neffos.DualPublishPrimaryRead)},
+	}
+
+	for _, tt := range tests {
+		namespaces := neffos.Namespaces{"default": neffos.Events{}}
+		server := neffos.New(gorilla.DefaultUpgrader, namespaces)
+		// declared namespaces only.
+		if err := server.UseStackExchange(tt.exc); err != nil {
+			t.Fatalf("[%s] %v", tt.name, err)
+		}
+		server.Close()
+
+		namespaces.SetDynamic(func(namespace string) (neffos.Events, bool) {
+			return neffos.Events{}, true
+		})
+		server = neffos.New(gorilla.DefaultUpgrader, namespaces)
+		if err := server.UseStackExchange(tt.exc); err != neffos.ErrDynamicNamespacesUnsupported {
+			t.Fatalf("[%s] expected error: %v but got: %v", tt.name, neffos.ErrDynamicNamespacesUnsupported, err)
+		}
+		server.Close()
+	}
+
+	// the rest of the stackexchanges subscribe to the dynamic namespaces on demand.
+	namespaces := neffos.Namespaces{}
+	namespaces.SetDynamic(func(namespace string) (neffos.Events, bool) {
+		return neffos.Events{}, true
+	})
+	server := neffos.New(gorilla.DefaultUpgrader, namespaces)
+	defer server.Close()
+
+	if err := server.UseStackExchange(neffos.NewInMemoryStackExchange()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// crashingStackExchange simulates a server instance which crashes
+// after it's resolved by a presence lookup, its deliveries are not confirmed.
+type crashingStackExchange struct {
+	*neffos.InMemoryStackExchange
+
+	mu      sync.Mutex
+	crash   string // the instance to crash on its next lookup.
+	crashed string
+}
+
+func (exc *crashingStackExchange) PresenceLookup(ctx context.Context, name string) (string, bool, error) {
+	instanceID, found, err := exc.InMemoryStackExchange.PresenceLookup(ctx, name)
+
+	exc.mu.Lock()
+	if found && instanceID == exc.crash {
+		exc.crash = ""
+		exc.crashed = instanceID
+		// its records are expired.
+		exc.InMemoryStackExchange.PresenceLeave(instanceID)
+	}
+	exc.mu.Unlock()
+
+	return instanceID, found, err
+}
+
+func (exc *crashingStackExchange) SendToInstance(ctx context.Context, instanceID string, msg neffos.Message) error {
+	exc.mu.Lock()
+	crashed := exc.crashed == instanceID
+	exc.mu.Unlock()
+
+	if crashed {
+		return context.DeadlineExceeded
+	}
+
+	return exc.InMemoryStackExchange.SendToInstance(ctx, instanceID, msg)
+}
+
+func TestServerSendToCluster(t *testing.T) {
+	var (
+		namespace = "default"
+		memory    = neffos.NewInMemoryStackExchange()
+		exc       = &crashingStackExchange{InMemoryStackExchange: memory}
+		received  = make(chan string, 8)
+		ctx       = context.TODO()
+	)
+
+	var servers []*neffos.Server
+	for i, id := range []string{"a", "b"} {
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		server.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			return r.URL.Query().Get("id")
+		}
+		// the sender resolves the instances through the crashing exchange.
+		var serverExc neffos.StackExchange = memory
+		if i == 0 {
+			serverExc = exc
+		}
+		if err := server.UseStackExchange(serverExc); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+		defer server.Close()
+		servers = append(servers, server)
+
+		client, err := neffos.Dial(ctx, gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1)+"?id="+id,
+			neffos.Namespaces{namespace: neffos.Events{
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- c.Conn.ID() + ":" + string(msg.Body)
+					return nil
+				},
+			}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if _, err = client.Connect(ctx, namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectReceived := func(expected string) {
+		t.Helper()
+
+		select {
+		case got := <-received:
+			if expected != got {
+				t.Fatalf("expected: %s but got: %s", expected, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected: %s but got nothing", expected)
+		}
+
+		select {
+		case got := <-received:
+			t.Fatalf("expected a single delivery but got: %s", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	send := func(connID, body string) error {
+		return servers[0].SendToCluster(ctx, connID, neffos.Message{Namespace: namespace, Event: "notify", Body: []byte(body)})
+	}
+
+	// found locally.
+	if err := send("a", "local"); err != nil {
+		t.Fatal(err)
+	}
+	expectReceived("a:local")
+
+	// found on the other instance.
+	if err := send("b", "remote"); err != nil {
+		t.Fatal(err)
+	}
+	expectReceived("b:remote")
+
+	// not found.
+	if err := send("missing", "nothing"); err != neffos.ErrConnNotFound {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrConnNotFound, err)
+	}
+
+	// the other instance crashes after the lookup.
+	exc.mu.Lock()
+	exc.crash = servers[1].InstanceID()
+	exc.mu.Unlock()
+
+	if err := send("b", "crashed"); err != neffos.ErrConnNotFound {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrConnNotFound, err)
+	}
+
+	select {
+	case got := <-received:
+		t.Fatalf("expected no delivery but got: %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServerSendToClusterWithoutPresence(t *testing.T) {
+	var (
+		namespace = "default"
+		received  = make(chan string, 1)
+		ctx       = context.TODO()
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+	defer server.Close()
+
+	msg := neffos.Message{Namespace: namespace, Event: "notify"}
+	if err := server.SendToCluster(ctx, "missing", msg); err != neffos.ErrConnNotFound {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrConnNotFound, err)
+	}
+
+	// hides the presence of the in-memory exchange, the message is published to all the instances.
+	memory := neffos.NewInMemoryStackExchange()
+	other := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+	defer other.Close()
+	for _, s := range []*neffos.Server{server, other} {
+		if err := s.UseStackExchange(struct{ neffos.StackExchange }{memory}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	httpServer := httptest.NewServer(other)
+	defer httpServer.Close()
+
+	client, err := neffos.Dial(ctx, gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+		neffos.Namespaces{namespace: neffos.Events{
+			"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- c.Conn.ID()
+				return nil
+			},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(ctx, namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.SendToCluster(ctx, client.ID, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case id := <-received:
+		if id != client.ID {
+			t.Fatalf("expected connection: %s but got: %s", client.ID, id)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the message to be delivered")
+	}
+}
+
+// closingStackExchange blocks its `Close` until the release,
+// the server's connections are still open while it's blocked.
+type closingStackExchange struct {
+	*neffos.InMemoryStackExchange
+
+	closing chan struct{}
+	release chan struct{}
+}
+
+func (exc *closingStackExchange) Close() error {
+	close(exc.closing)
+	<-exc.release
+	return nil
+}
+
+func TestServerShutdown(t *testing.T) {
+	var (
+		namespace = "default"
+		memory    = neffos.NewInMemoryStackExchange().SetQueueSize(64)
+		exc       = &closingStackExchange{InMemoryStackExchange: memory, closing: make(chan struct{}), release: make(chan struct{})}
+		received  = make(chan string, 1024)
+	)
+
+	var (
+		servers []*neffos.Server
+		clients []*neffos.Client
+	)
+
+	for i := 0; i < 2; i++ {
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		var serverExc neffos.StackExchange = memory
+		if i == 0 {
+			serverExc = neffos.InstrumentStackExchange(exc)
+		}
+		if err := server.UseStackExchange(serverExc); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+		defer server.Close()
+		servers = append(servers, server)
+
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+			neffos.Namespaces{namespace: neffos.Events{
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					select {
+					case received <- c.Conn.ID():
+					default:
+					}
+					return nil
+				},
+			}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if _, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+
+	stop := make(chan struct{})
+	broadcasting := make(chan struct{})
+	go func() {
+		defer close(broadcasting)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				servers[1].Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify"})
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-broadcasting
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for servers[0].Metrics().StackExchange.Received == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the stackexchange to deliver to the server before its shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- servers[0].Shutdown(context.TODO())
+	}()
+
+	select {
+	case <-exc.closing:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the stackexchange to be closed")
+	}
+
+	// the connections are open but unsubscribed, the exchange keeps publishing.
+	delivered := servers[0].Metrics().StackExchange.Received
+	time.Sleep(100 * time.Millisecond)
+	if got := servers[0].Metrics().StackExchange.Received; got != delivered {
+		t.Fatalf("expected no deliveries after the shutdown began but got %d", got-delivered)
+	}
+	if got := servers[0].GetTotalConnections(); got != 1 {
+		t.Fatalf("expected the connection to be closed after the stackexchange but got %d connections", got)
+	}
+
+	close(exc.release)
+
+	select {
+	case err := <-shutdownErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the shutdown to complete")
+	}
+
+	if got := servers[0].GetTotalConnections(); got != 0 {
+		t.Fatalf("expected no connections after the shutdown but got %d", got)
+	}
+
+	if err := servers[0].Shutdown(context.TODO()); err != nil {
+		t.Fatalf("expected a second shutdown to be a no-op but got: %v", err)
+	}
+
+	// the other server is not affected.
+	for len(received) > 0 {
+		<-received
+	}
+	select {
+	case id := <-received:
+		if id != clients[1].ID {
+			t.Fatalf("expected a delivery to the client of the other server but got one for: %s", id)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the other server to keep receiving")
+	}
+}
+
+// blockingStackExchange blocks its `Publish` until the release and records the published events.
+type blockingStackExchange struct {
+	*neffos.InMemoryStackExchange
+
+	started chan struct{}
+	release chan struct{}
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (exc *blockingStackExchange) Publish(msgs []neffos.Message) bool {
+	exc.started <- struct{}{}
+	<-exc.release
+
+	exc.mu.Lock()
+	for _, msg := range msgs {
+		exc.published = append(exc.published, msg.Event)
+	}
+	exc.mu.Unlock()
+
+	return exc.InMemoryStackExchange.Publish(msgs)
+}
+
+func TestServerStackExchangeQueue(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = &blockingStackExchange{
+			InMemoryStackExchange: neffos.NewInMemoryStackExchange(),
+			started:               make(chan struct{}, 8),
+			release:               make(chan struct{}),
+		}
+		errs = make(chan error, 8)
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+	server.OnStackExchangeError = func(err error, recovered bool) {
+		errs <- err
+	}
+	server.SetStackExchangeQueue(3)
+	if err := server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "1"})
+	select {
+	case <-exc.started:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the queue to publish")
+	}
+
+	// the publisher is blocked, the broadcasts do not wait for it.
+	done := make(chan struct{})
+	go func() {
+		for _, event := range []string{"2", "3", "4", "5", "6"} {
+			server.Broadcast(nil, neffos.Message{Namespace: namespace, Event: event})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the broadcasts to return while the stackexchange is blocked")
+	}
+
+	m := server.Metrics().StackExchange
+	if m.QueueDepth != 3 || m.QueueDropped != 2 {
+		t.Fatalf("expected a queue of 3 messages and 2 drops but got: %d and %d", m.QueueDepth, m.QueueDropped)
+	}
+
+	select {
+	case err := <-errs:
+		if err != neffos.ErrStackExchangeQueueFull {
+			t.Fatalf("expected the queue full error but got: %v", err)
+		}
+	default:
+		t.Fatalf("expected the queue full error to be fired")
+	}
+	if len(errs) > 0 {
+		t.Fatalf("expected the queue full error to be fired once but got %d more", len(errs))
+	}
+
+	// the queued messages are published on shutdown.
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(context.TODO())
+	}()
+	close(exc.release)
+
+	select {
+	case err := <-shutdownErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the shutdown to complete")
+	}
+
+	exc.mu.Lock()
+	published := exc.published
+	exc.mu.Unlock()
+
+	if expected := []string{"1", "4", "5", "6"}; !reflect.DeepEqual(expected, published) {
+		t.Fatalf("expected the oldest messages to be dropped and the rest to be published in order: %v but got: %v", expected, published)
+	}
+
+	if m := server.Metrics().StackExchange; m.QueueDepth != 0 {
+		t.Fatalf("expected an empty queue after the shutdown but got: %d", m.QueueDepth)
+	}
+}
+
+func TestCompositeStackExchange(t *testing.T) {
+	var (
+		namespace = "default"
+		// i.e redis and nats.
+		primary   = neffos.NewInMemoryStackExchange()
+		secondary = neffos.NewInMemoryStackExchange().SetQueueSize(16)
+	)
+
+	type instance struct {
+		server   *neffos.Server
+		received chan string
+	}
+
+	newInstance := func(exc neffos.StackExchange) instance {
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		if err := server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(server)
+		t.Cleanup(func() {
+			server.Close()
+			httpServer.Close()
+		})
+
+		received := make(chan string, 16)
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1),
+			neffos.Namespaces{namespace: neffos.Events{
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- string(msg.Body)
+					return nil
+				},
+			}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		if _, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+
+		return instance{server: server, received: received}
+	}
+
+	var (
+		legacy        = newInstance(primary)
+		primaryRead   = newInstance(neffos.NewCompositeStackExchange(primary, secondary, neffos.DualPublishPrimaryRead))
+		dualRead      = newInstance(neffos.NewCompositeStackExchange(primary, secondary, neffos.DualPublishDualRead))
+		secondaryOnly = newInstance(neffos.NewCompositeStackExchange(primary, secondary, neffos.SecondaryOnly))
+	)
+
+	expect := func(name string, inst instance, expected ...string) {
+		t.Helper()
+
+		for _, body := range expected {
+			select {
+			case got := <-inst.received:
+				if body != got {
+					t.Fatalf("[%s] expected: %s but got: %s", name, body, got)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("[%s] expected: %s", name, body)
+			}
+		}
+
+		select {
+		case got := <-inst.received:
+			t.Fatalf("[%s] expected no more messages but got: %s", name, got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	broadcast := func(inst instance, body string) {
+		inst.server.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "notify", Body: []byte(body)})
+	}
+
+	// published to both, each instance receives it once.
+	broadcast(primaryRead, "dual")
+	expect("legacy", legacy, "dual")
+	expect("primary read", primaryRead, "dual")
+	expect("dual read", dualRead, "dual")
+	expect("secondary only", secondaryOnly, "dual")
+
+	broadcast(dualRead, "dual read")
+	expect("legacy", legacy, "dual read")
+	expect("primary read", primaryRead, "dual read")
+	expect("dual read", dualRead, "dual read")
+	expect("secondary only", secondaryOnly, "dual read")
+
+	// published to the primary only, without a publish identifier.
+	broadcast(legacy, "legacy")
+	expect("legacy", legacy, "legacy")
+	expect("primary read", primaryRead, "legacy")
+	expect("dual read", dualRead, "legacy")
+	expect("secondary only", secondaryOnly)
+
+	// published to the secondary only.
+	broadcast(secondaryOnly, "secondary")
+	expect("legacy", legacy)
+	expect("primary read", primaryRead)
+	expect("dual read", dualRead, "secondary")
+	expect("secondary only", secondaryOnly, "secondary")
+}
@@ -0,0 +1,69 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+
+	"github.com/kataras/neffos"
+)
+
+// DefaultDialer is a plain TCP dialer, see `Dialer`.
+var DefaultDialer = Dialer(nil)
+
+// Dialer is a `neffos.Dialer` type for the TCP transport, see `Serve`.
+// Should be used on `neffos.Dial` to create a new client/client-side connection.
+// The url's host is dialed, i.e "localhost:8080" or "tcp://localhost:8080",
+// if "tlsConfig" is not nil then the connection is made over TLS.
+// The unix domain socket urls are dialed through their socket file, see `neffos.ParseUnixURL`.
+func Dialer(tlsConfig *tls.Config) neffos.Dialer {
+	return func(ctx context.Context, url string) (neffos.Socket, error) {
+		network, addr := "tcp", hostOf(url)
+		if socketPath, _, ok := neffos.ParseUnixURL(url); ok {
+			network, addr = "unix", socketPath
+		}
+
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if tlsConfig != nil {
+			config := tlsConfig
+			if config.ServerName == "" {
+				config = config.Clone()
+				if config.ServerName, _, _ = net.SplitHostPort(addr); network == "unix" {
+					config.ServerName = "localhost"
+				}
+			}
+
+			tlsConn := tls.Client(conn, config)
+			if err = tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			conn = tlsConn
+		}
+
+		return newSocket(conn, nil), nil
+	}
+}
+
+// hostOf returns the host of the "url", the `neffos.Dial` prefixes it with the "ws://" scheme.
+func hostOf(url string) string {
+	for {
+		idx := strings.Index(url, "://")
+		if idx == -1 {
+			break
+		}
+		url = url[idx+3:]
+	}
+
+	if idx := strings.IndexAny(url, "/?"); idx != -1 {
+		url = url[:idx]
+	}
+
+	return url
+}
@@ -0,0 +1,105 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+// ListenAndServe listens on the TCP network address "addr" and serves the neffos "server" through it, see `Serve`.
+// If "tlsConfig" is not nil then the connections are served over TLS.
+func ListenAndServe(server *neffos.Server, addr string, tlsConfig *tls.Config) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	return Serve(server, ln)
+}
+
+// Serve accepts the connections of the "ln" listener and serves them through the neffos "server",
+// with the same registration and acknowledgement as the `neffos.Server#ServeHTTP`,
+// so the namespaces, rooms, `Ask` and the `StackExchange` work as they do over websocket.
+// The server's Upgrader is not used, it can be constructed with a nil one, i.e `neffos.New(nil, events)`.
+// The `Request` of the connection's socket is a synthetic GET one, it carries the remote address
+// and, for the TLS connections, the connection state.
+//
+// It blocks until the "ln" is closed, it always returns a non-nil error.
+func Serve(server *neffos.Server, ln net.Listener) error {
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				// as the net/http server does.
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+
+				time.Sleep(delay)
+				continue
+			}
+
+			return err
+		}
+
+		delay = 0
+		go serveConn(server, conn)
+	}
+}
+
+func serveConn(server *neffos.Server, conn net.Conn) {
+	r := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: "/"},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Host:       conn.LocalAddr().String(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		RequestURI: "/",
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			if server.OnUpgradeError != nil {
+				server.OnUpgradeError(err)
+			}
+			return
+		}
+
+		state := tlsConn.ConnectionState()
+		r.TLS = &state
+	}
+
+	// closes the socket on errors too.
+	server.ServeSocket(&responseWriter{header: make(http.Header)}, r, newSocket(conn, r), nil)
+}
+
+// responseWriter is passed to the server's `IDGenerator`, there is no response to write.
+type responseWriter struct {
+	header http.Header
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {}
@@ -0,0 +1,78 @@
+package tcp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/tcp"
+)
+
+func TestServeTLS(t *testing.T) {
+	// borrow the certificates of the httptest package.
+	httpServer := httptest.NewTLSServer(http.NotFoundHandler())
+	serverConfig := httpServer.TLS
+	clientConfig := httpServer.Client().Transport.(*http.Transport).TLSClientConfig
+	httpServer.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := neffos.Namespaces{
+		"default": neffos.Events{
+			"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+				return neffos.Reply(msg.Body)
+			},
+		},
+	}
+
+	server := neffos.New(nil, events)
+	server.SetMaxMessageSize(1024)
+	server.OnConnect = func(c *neffos.Conn) error {
+		r := c.Socket().Request()
+		if r.TLS == nil || !r.TLS.HandshakeComplete {
+			return fmt.Errorf("expected the tls connection state")
+		}
+
+		if r.RemoteAddr == "" {
+			return fmt.Errorf("expected the remote address")
+		}
+
+		return nil
+	}
+	defer server.Close()
+
+	go tcp.Serve(server, tls.NewListener(ln, serverConfig))
+	defer ln.Close()
+
+	client, err := neffos.Dial(context.TODO(), tcp.Dialer(clientConfig), "tcp://"+ln.Addr().String(), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := c.Ask(context.TODO(), "echo", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "data", string(msg.Body); expected != got {
+		t.Fatalf("expected body: %s but got: %s", expected, got)
+	}
+
+	// a larger than the server's limit message closes the connection.
+	c.Emit("echo", make([]byte, 2048))
+	<-client.NotifyClose
+}
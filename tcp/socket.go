@@ -0,0 +1,188 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+)
+
+// The frame types, the data ones match the `neffos.TextMessage` and `neffos.BinaryMessage`.
+const (
+	textFrame   byte = neffos.TextMessage
+	binaryFrame byte = neffos.BinaryMessage
+	closeFrame  byte = 8
+)
+
+// frameHeaderSize is the size of the header of each frame:
+// its type and the big-endian length of its payload, 4 bytes.
+const frameHeaderSize = 5
+
+// Socket completes the `neffos.Socket` interface over a plain TCP (or TLS) connection,
+// each message is sent as a frame of its type and its length followed by its payload.
+type Socket struct {
+	UnderlyingConn net.Conn
+	request        *http.Request
+
+	reader *bufio.Reader
+	header [frameHeaderSize]byte
+	// see `SetReadLimit`.
+	readLimit int64
+	// see `SetWriteBuffer`.
+	buffer *neffos.WriteBuffer
+
+	mu sync.Mutex
+}
+
+func newSocket(underline net.Conn, request *http.Request) *Socket {
+	return &Socket{
+		UnderlyingConn: underline,
+		request:        request,
+		reader:         bufio.NewReader(underline),
+	}
+}
+
+// NetConn returns the underline net connection.
+func (s *Socket) NetConn() net.Conn {
+	return s.UnderlyingConn
+}
+
+// Request returns the http request value.
+// It's a synthetic one on the server-side, which carries the remote address
+// and, for the TLS connections, the connection state, see `Serve`.
+func (s *Socket) Request() *http.Request {
+	return s.request
+}
+
+// ReadData reads binary or text messages from the remote connection.
+// A close frame is returned as a `neffos.CloseError`.
+func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
+	if timeout > 0 {
+		s.UnderlyingConn.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	if _, err := io.ReadFull(s.reader, s.header[:]); err != nil {
+		return nil, 0, readError(err)
+	}
+
+	typ, length := s.header[0], int64(binary.BigEndian.Uint32(s.header[1:]))
+	if s.readLimit > 0 && length > s.readLimit {
+		return nil, 0, neffos.ErrMessageTooLarge
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, b); err != nil {
+		return nil, 0, readError(err)
+	}
+
+	switch typ {
+	case textFrame, binaryFrame:
+		return b, neffos.MessageType(typ), nil
+	case closeFrame:
+		code, reason := parseCloseFrame(b)
+		return nil, 0, neffos.NewCloseError(code, errors.New(reason))
+	default:
+		return nil, 0, errInvalidFrame
+	}
+}
+
+var errInvalidFrame = errors.New("tcp: invalid frame type")
+
+func readError(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF // for io.ReadAll to return an error if connection remotely closed.
+	}
+
+	return err
+}
+
+func parseCloseFrame(b []byte) (int, string) {
+	if len(b) < 2 {
+		return 0, ""
+	}
+
+	return int(binary.BigEndian.Uint16(b)), string(b[2:])
+}
+
+// SetReadLimit sets the maximum size in bytes for a message read from the remote connection,
+// it completes the `neffos.SocketReadLimiter` interface.
+func (s *Socket) SetReadLimit(limit int64) {
+	s.readLimit = limit
+}
+
+// SetWriteBuffer enables the buffering of the written messages,
+// it completes the `neffos.SocketWriteBuffer` interface.
+func (s *Socket) SetWriteBuffer(size int, maxLatency time.Duration) {
+	s.mu.Lock()
+	s.buffer = neffos.NewWriteBuffer(s.UnderlyingConn, &s.mu, size, maxLatency)
+	s.mu.Unlock()
+}
+
+// Flush writes the buffered messages to the remote connection,
+// it completes the `neffos.SocketFlusher` interface.
+func (s *Socket) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buffer == nil {
+		return nil
+	}
+
+	return s.buffer.Flush()
+}
+
+// WriteClose sends a close message with the "code" and "reason" to the remote connection,
+// it completes the `neffos.SocketCloser` interface.
+// The buffered messages, if any, are written before it.
+func (s *Socket) WriteClose(code int, reason string, timeout time.Duration) error {
+	body := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(body, uint16(code))
+	copy(body[2:], reason)
+
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	return s.write(body, closeFrame, timeout)
+}
+
+// WriteBinary sends a binary message to the remote connection.
+func (s *Socket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.write(body, binaryFrame, timeout)
+}
+
+// WriteText sends a text message to the remote connection.
+func (s *Socket) WriteText(body []byte, timeout time.Duration) error {
+	return s.write(body, textFrame, timeout)
+}
+
+func (s *Socket) write(body []byte, typ byte, timeout time.Duration) error {
+	var header [frameHeaderSize]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(body)))
+
+	s.mu.Lock()
+	if s.buffer != nil && typ != closeFrame {
+		s.buffer.Write(header[:])
+		s.buffer.Write(body)
+		err := s.buffer.Commit(timeout)
+		s.mu.Unlock()
+		return err
+	}
+
+	if timeout > 0 {
+		s.UnderlyingConn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	buffers := net.Buffers{header[:], body}
+	_, err := buffers.WriteTo(s.UnderlyingConn)
+	s.mu.Unlock()
+
+	return err
+}
@@ -0,0 +1,162 @@
+package neffos_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	gorilla "github.com/kataras/neffos/gorilla"
+
+	gobwasws "github.com/gobwas/ws"
+	"github.com/gorilla/websocket"
+)
+
+// newTestCertificate returns a certificate of the "commonName" signed by the "parent", a self-signed one if nil.
+func newTestCertificate(t *testing.T, commonName string, parent *tls.Certificate, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestConnTLSState(t *testing.T) {
+	var (
+		namespace  = "default"
+		ca         = newTestCertificate(t, "ca", nil, x509.ExtKeyUsageAny)
+		serverCert = newTestCertificate(t, "server", &ca, x509.ExtKeyUsageServerAuth)
+		clientCert = newTestCertificate(t, "client", &ca, x509.ExtKeyUsageClientAuth)
+		pool       = x509.NewCertPool()
+		events     = neffos.Namespaces{
+			namespace: neffos.Events{
+				"whoami": func(c *neffos.NSConn, msg neffos.Message) error {
+					state := c.Conn.TLSState()
+					if state == nil {
+						return neffos.Reply(nil)
+					}
+
+					return neffos.Reply([]byte(state.PeerCertificates[0].Subject.CommonName))
+				},
+			},
+		}
+	)
+	pool.AddCert(ca.Leaf)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	clientConfig := &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      pool,
+	}
+
+	tests := []struct {
+		adapter  string
+		upgrader neffos.Upgrader
+		dialer   neffos.Dialer
+	}{
+		{"gorilla", gorilla.DefaultUpgrader, gorilla.Dialer(&websocket.Dialer{TLSClientConfig: clientConfig}, nil)},
+		{"gobwas", gobwas.DefaultUpgrader, gobwas.Dialer(gobwasws.Dialer{TLSConfig: clientConfig})},
+	}
+
+	for _, tt := range tests {
+		server := neffos.New(tt.upgrader, events)
+		// the certificate's common name is the connection's ID.
+		server.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				t.Errorf("[%s] expected the request's TLS state on the IDGenerator", tt.adapter)
+				return neffos.DefaultIDGenerator(w, r)
+			}
+
+			return r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		server.OnConnect = func(c *neffos.Conn) error {
+			if state := c.TLSState(); state == nil || !state.HandshakeComplete {
+				t.Errorf("[%s] expected the TLS state on the OnConnect", tt.adapter)
+			}
+			return nil
+		}
+
+		httpServer := httptest.NewUnstartedServer(server)
+		httpServer.TLS = serverConfig
+		httpServer.StartTLS()
+
+		url := strings.Replace(httpServer.URL, "https", "wss", 1)
+		client, err := neffos.Dial(context.TODO(), tt.dialer, url, events)
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.adapter, err)
+		}
+
+		if expected, got := "client", client.ID; expected != got {
+			t.Fatalf("[%s] expected the connection's ID to be: %s but got: %s", tt.adapter, expected, got)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.adapter, err)
+		}
+
+		if state := c.Conn.TLSState(); state == nil || state.PeerCertificates[0].Subject.CommonName != "server" {
+			t.Fatalf("[%s] expected the client's TLS state to have the server's certificate", tt.adapter)
+		}
+
+		msg, err := c.Ask(context.TODO(), "whoami", nil)
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.adapter, err)
+		}
+
+		if expected, got := "client", string(msg.Body); expected != got {
+			t.Fatalf("[%s] expected the peer's certificate on the events: %s but got: %s", tt.adapter, expected, got)
+		}
+
+		client.Close()
+		httpServer.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,79 @@
+package neffos
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// UnixScheme is the scheme of the urls which are dialed through a unix domain socket, see `ParseUnixURL`.
+const UnixScheme = "unix://"
+
+// ParseUnixURL reports whether the "url" is a unix domain socket one, i.e "unix:///run/app.sock:/echo",
+// the path of the socket file and the path of the http request are separated by a colon, as nginx does.
+// It returns the socket's path and the websocket url to request through it, i.e "ws://localhost/echo".
+//
+// The dialers of the builtin adapters dial the socket's path instead of the url's host on such urls,
+// custom dialers can use it with the `UnixNetDial`.
+func ParseUnixURL(url string) (socketPath string, wsURL string, ok bool) {
+	if !strings.HasPrefix(url, UnixScheme) {
+		return "", "", false
+	}
+
+	socketPath, path := url[len(UnixScheme):], "/"
+	if idx := strings.IndexByte(socketPath, ':'); idx != -1 {
+		socketPath, path = socketPath[:idx], socketPath[idx+1:]
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+
+	if socketPath == "" {
+		return "", "", false
+	}
+
+	return socketPath, "ws://localhost" + path, true
+}
+
+// UnixNetDial returns a dial function which connects to the unix domain socket of the "socketPath",
+// whatever the network and the address it's called with are, see `ParseUnixURL`.
+func UnixNetDial(socketPath string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", socketPath)
+	}
+}
+
+// ListenUnix announces on the unix domain socket of the "socketPath",
+// the server can be served through it with the `http.Server#Serve`.
+// A stale socket file, which no process listens to, is removed first
+// and, if "mode" is not zero, the permissions of the socket file are set to it.
+// The socket file is removed when the listener is closed.
+//
+// Note that the unix peers have no IP address, the `Socket#Request().RemoteAddr`
+// and the `NetConn().RemoteAddr()` of their connections are the (usually empty) name of the peer's socket.
+func ListenUnix(socketPath string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+			conn.Close() // in use, let the listen fail.
+		} else {
+			os.Remove(socketPath)
+		}
+	}
+
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != 0 {
+		if err = os.Chmod(socketPath, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
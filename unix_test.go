@@ -0,0 +1,153 @@
+package neffos_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	gorilla "github.com/kataras/neffos/gorilla"
+	nhooyr "github.com/kataras/neffos/nhooyr"
+	tcp "github.com/kataras/neffos/tcp"
+)
+
+func TestParseUnixURL(t *testing.T) {
+	tests := []struct {
+		url        string
+		socketPath string
+		wsURL      string
+		ok         bool
+	}{
+		{"unix:///run/app.sock:/echo", "/run/app.sock", "ws://localhost/echo", true},
+		{"unix:///run/app.sock", "/run/app.sock", "ws://localhost/", true},
+		{"unix://app.sock:echo", "app.sock", "ws://localhost/echo", true},
+		{"unix://", "", "", false},
+		{"ws://localhost:8080/echo", "", "", false},
+	}
+
+	for i, tt := range tests {
+		socketPath, wsURL, ok := neffos.ParseUnixURL(tt.url)
+		if socketPath != tt.socketPath || wsURL != tt.wsURL || ok != tt.ok {
+			t.Fatalf("[%d] expected: %q %q %v but got: %q %q %v", i, tt.socketPath, tt.wsURL, tt.ok, socketPath, wsURL, ok)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "neffos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		socketPath    = filepath.Join(dir, "neffos.sock")
+		tcpSocketPath = filepath.Join(dir, "neffos_tcp.sock")
+		namespace     = "default"
+		events        = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						// the unix peers have no IP address.
+						if addr := c.Conn.Socket().NetConn().RemoteAddr(); addr == nil || addr.Network() != "unix" {
+							t.Errorf("expected a unix remote address but got: %v", addr)
+						}
+					}
+
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	// a stale socket file is removed.
+	stale, err := neffos.ListenUnix(socketPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := neffos.ListenUnix(socketPath, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(socketPath); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected the socket file's permissions to be set but got: %v: %v", fi.Mode(), err)
+	}
+
+	// an in use one is not.
+	if _, err = neffos.ListenUnix(socketPath, 0); err == nil {
+		t.Fatalf("expected to not listen on an in use socket file")
+	}
+
+	mux := http.NewServeMux()
+	for _, adapter := range testWebsocketAdapters {
+		var upgrader neffos.Upgrader
+		switch adapter {
+		case "gobwas":
+			upgrader = gobwas.DefaultUpgrader
+		case "gorilla":
+			upgrader = gorilla.DefaultUpgrader
+		case "nhooyr":
+			upgrader = nhooyr.DefaultUpgrader
+		}
+
+		server := neffos.New(upgrader, events)
+		defer server.Close()
+		mux.Handle("/"+adapter, server)
+	}
+
+	httpServer := &http.Server{Handler: mux}
+	go httpServer.Serve(ln)
+	defer httpServer.Close()
+
+	tcpLn, err := neffos.ListenUnix(tcpSocketPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpServer := neffos.New(nil, events)
+	defer tcpServer.Close()
+	go tcp.Serve(tcpServer, tcpLn)
+	defer tcpLn.Close()
+
+	dialers := map[string]neffos.Dialer{
+		"gobwas":  gobwas.DefaultDialer,
+		"gorilla": gorilla.DefaultDialer,
+		"nhooyr":  nhooyr.DefaultDialer,
+		"tcp":     tcp.DefaultDialer,
+	}
+
+	for _, adapter := range testNetworkAdapters {
+		url := neffos.UnixScheme + socketPath + ":/" + adapter
+		if adapter == "tcp" {
+			url = neffos.UnixScheme + tcpSocketPath
+		}
+
+		client, err := neffos.Dial(context.TODO(), dialers[adapter], url, events)
+		if err != nil {
+			t.Fatalf("[%s] %v", adapter, err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatalf("[%s] %v", adapter, err)
+		}
+
+		msg, err := c.Ask(context.TODO(), "echo", []byte(adapter))
+		if err != nil {
+			t.Fatalf("[%s] %v", adapter, err)
+		}
+
+		if expected, got := adapter, string(msg.Body); expected != got {
+			t.Fatalf("[%s] expected body: %s but got: %s", adapter, expected, got)
+		}
+
+		client.Close()
+	}
+}
@@ -0,0 +1,171 @@
+package neffos
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWaitingMessagesTTL is the default maximum age of a wait token of the `Server#Ask`,
+// see `Server#SetWaitingMessagesTTL`.
+const DefaultWaitingMessagesTTL = 10 * time.Minute
+
+// the number of the shards of the `waitingMessages`, so the lookups of the incoming replies
+// do not contend on a single lock.
+const waitingMessagesShards = 32
+
+// waitingMessages keeps the channels of the `Server#Ask` calls which wait for a reply
+// from one of the server's connections, by their wait token.
+type waitingMessages struct {
+	shards [waitingMessagesShards]waitingMessagesShard
+}
+
+type waitingMessagesShard struct {
+	mu      sync.RWMutex
+	entries map[string]waitingMessage
+}
+
+type waitingMessage struct {
+	ch      chan Message
+	created time.Time
+}
+
+func newWaitingMessages() *waitingMessages {
+	w := new(waitingMessages)
+	for i := range w.shards {
+		w.shards[i].entries = make(map[string]waitingMessage)
+	}
+
+	return w
+}
+
+func (w *waitingMessages) shard(wait string) *waitingMessagesShard {
+	h := fnv.New32a()
+	h.Write([]byte(wait))
+	return &w.shards[h.Sum32()%waitingMessagesShards]
+}
+
+// add registers the "wait" token and returns the channel of its reply,
+// the caller should `remove` it when it's done.
+func (w *waitingMessages) add(wait string, now time.Time) <-chan Message {
+	// buffered, so a reply never blocks the reader of the connection.
+	ch := make(chan Message, 1)
+
+	shard := w.shard(wait)
+	shard.mu.Lock()
+	shard.entries[wait] = waitingMessage{ch: ch, created: now}
+	shard.mu.Unlock()
+
+	return ch
+}
+
+func (w *waitingMessages) remove(wait string) {
+	shard := w.shard(wait)
+	shard.mu.Lock()
+	delete(shard.entries, wait)
+	shard.mu.Unlock()
+}
+
+// notify sends the reply "msg" to the waiter of its wait token and reports whether there is one.
+// Only the first reply is kept, the rest are dropped.
+func (w *waitingMessages) notify(msg Message) bool {
+	shard := w.shard(msg.wait)
+	shard.mu.RLock()
+	entry, ok := shard.entries[msg.wait]
+	shard.mu.RUnlock()
+
+	if ok {
+		select {
+		case entry.ch <- msg:
+		default:
+		}
+	}
+
+	return ok
+}
+
+// sweep removes the entries which were created before the "deadline" and returns their number.
+func (w *waitingMessages) sweep(deadline time.Time) int {
+	removed := 0
+	for i := range w.shards {
+		shard := &w.shards[i]
+		shard.mu.Lock()
+		for wait, entry := range shard.entries {
+			if entry.created.Before(deadline) {
+				delete(shard.entries, wait)
+				removed++
+			}
+		}
+		shard.mu.Unlock()
+	}
+
+	return removed
+}
+
+func (w *waitingMessages) len() int {
+	n := 0
+	for i := range w.shards {
+		shard := &w.shards[i]
+		shard.mu.RLock()
+		n += len(shard.entries)
+		shard.mu.RUnlock()
+	}
+
+	return n
+}
+
+// SetWaitingMessagesTTL sets the maximum age of the wait tokens of the `Ask` calls
+// which wait for a reply from the server's connections. The older ones are removed periodically,
+// i.e of an abandoned call, see `Metrics.ExpiredWaitingMessages`. A non-positive "ttl" disables their expiration.
+// It should be longer than the deadline of the `Ask` calls.
+//
+// Defaults to the `DefaultWaitingMessagesTTL`.
+func (s *Server) SetWaitingMessagesTTL(ttl time.Duration) {
+	atomic.StoreInt64(&s.waitingMessagesTTL, int64(ttl))
+
+	select {
+	case s.waitingMessagesTTLChanged <- struct{}{}:
+	default:
+	}
+}
+
+// addWaitingMessage registers the "wait" token of a `Server#Ask` at the time of the server's `Clock`.
+// The sweeper of the expired tokens is started on the first one, when the server is already configured.
+func (s *Server) addWaitingMessage(wait string) <-chan Message {
+	clock := clockOrSystem(s.Clock)
+	s.sweepWaitingMessagesOnce.Do(func() {
+		go s.sweepWaitingMessages(clock)
+	})
+
+	return s.waitingMessages.add(wait, clock.Now())
+}
+
+// sweepWaitingMessages removes the expired wait tokens every half of the ttl, until the server is closed.
+func (s *Server) sweepWaitingMessages(clock Clock) {
+	for {
+		ttl := time.Duration(atomic.LoadInt64(&s.waitingMessagesTTL))
+		interval := ttl / 2
+		if ttl <= 0 {
+			interval = DefaultWaitingMessagesTTL / 2
+		}
+
+		timer := clock.NewTimer(interval)
+		select {
+		case <-s.closeCh:
+			timer.Stop()
+			return
+		case <-s.waitingMessagesTTLChanged:
+			timer.Stop()
+			continue
+		case now := <-timer.C():
+			if ttl <= 0 {
+				continue
+			}
+
+			if removed := s.waitingMessages.sweep(now.Add(-ttl)); removed > 0 {
+				atomic.AddUint64(&s.counters.expiredWaitingMessages, uint64(removed))
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package neffos
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitingMessages(t *testing.T) {
+	w := newWaitingMessages()
+	now := time.Now()
+
+	ch := w.add("old", now.Add(-time.Minute))
+	w.add("new", now)
+	if n := w.len(); n != 2 {
+		t.Fatalf("expected 2 entries but got: %d", n)
+	}
+
+	// only the first reply is kept and the rest do not block.
+	if !w.notify(Message{wait: "old", Event: "first"}) || !w.notify(Message{wait: "old", Event: "second"}) {
+		t.Fatalf("expected the waiter to be notified")
+	}
+	if msg := <-ch; msg.Event != "first" {
+		t.Fatalf("expected the first reply but got: %s", msg.Event)
+	}
+	if w.notify(Message{wait: "missing"}) {
+		t.Fatalf("expected no waiter of a missing wait token")
+	}
+
+	if removed := w.sweep(now.Add(-time.Second)); removed != 1 {
+		t.Fatalf("expected the old entry to be swept but got: %d", removed)
+	}
+	if w.notify(Message{wait: "old"}) {
+		t.Fatalf("expected the swept entry to be removed")
+	}
+
+	w.remove("new")
+	if n := w.len(); n != 0 {
+		t.Fatalf("expected no entries but got: %d", n)
+	}
+}
+
+func TestServerWaitingMessagesTTL(t *testing.T) {
+	server := New(nil, Namespaces{"default": Events{}})
+	defer server.Close()
+
+	// i.e the tokens of abandoned calls.
+	for i := 0; i < 100; i++ {
+		server.addWaitingMessage(strconv.Itoa(i))
+	}
+
+	server.SetWaitingMessagesTTL(20 * time.Millisecond)
+	for deadline := time.Now().Add(time.Second); server.waitingMessages.len() > 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the expired entries to be swept but got: %d", server.waitingMessages.len())
+		}
+	}
+
+	if expected, got := uint64(100), server.Metrics().ExpiredWaitingMessages; expected != got {
+		t.Fatalf("expected expired waiting messages: %d but got: %d", expected, got)
+	}
+}
+
+func TestServerAskWaitingMessagesChurn(t *testing.T) {
+	events := Namespaces{
+		"default": Events{
+			"reply": func(c *NSConn, msg Message) error {
+				return Reply(msg.Body)
+			},
+			"ignore": func(c *NSConn, msg Message) error {
+				return nil
+			},
+		},
+	}
+
+	server := New(nil, events)
+	// the asks are broadcasted concurrently.
+	server.SyncBroadcaster = true
+	server.OnConnect = func(c *Conn) error {
+		_, err := c.Connect(context.TODO(), "default")
+		return err
+	}
+	defer server.Close()
+
+	dial := func() *Client {
+		client, err := Dial(context.TODO(), PipeDialer(server), "/", events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.conn.WaitConnect(context.TODO(), "default"); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	var clients []*Client
+	for i := 0; i < 5; i++ {
+		clients = append(clients, dial())
+	}
+
+	const (
+		workers = 8
+		rounds  = 50
+	)
+
+	var (
+		wg      sync.WaitGroup
+		maxSize int64
+		replied uint64
+		stop    = make(chan struct{})
+	)
+
+	// samples the size of the map under churn.
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				if n := int64(server.waitingMessages.len()); n > atomic.LoadInt64(&maxSize) {
+					atomic.StoreInt64(&maxSize, n)
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < rounds; j++ {
+				event := "reply"
+				if j%3 == 0 {
+					// times out.
+					event = "ignore"
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				if _, err := server.Ask(ctx, Message{Namespace: "default", Event: event, Body: []byte("body")}); err == nil {
+					atomic.AddUint64(&replied, 1)
+				}
+				cancel()
+			}
+		}(i)
+	}
+
+	// the connections are closed and replaced while they are asked.
+	for i := range clients {
+		time.Sleep(10 * time.Millisecond)
+		clients[i].Close()
+		clients[i] = dial()
+	}
+
+	wg.Wait()
+	close(stop)
+	<-sampled
+
+	for _, client := range clients {
+		client.Close()
+	}
+
+	if atomic.LoadUint64(&replied) == 0 {
+		t.Fatalf("expected replies to the asks")
+	}
+	if n := server.waitingMessages.len(); n != 0 {
+		t.Fatalf("expected no waiting messages after the calls but got: %d", n)
+	}
+	if max := atomic.LoadInt64(&maxSize); max > workers {
+		t.Fatalf("expected at most %d waiting messages, one per pending call, but got: %d", workers, max)
+	}
+}
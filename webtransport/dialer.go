@@ -0,0 +1,55 @@
+package webtransport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kataras/neffos"
+
+	"github.com/quic-go/webtransport-go"
+)
+
+// Dialer is a `neffos.Dialer` type for the WebTransport transport, see `Upgrade`.
+// Should be used on `neffos.Dial` to create a new client/client-side connection,
+// i.e "https://localhost:4433/echo", the "ws" and "wss" schemes are dialed as "https" too.
+// The "transport" dials the sessions, its `TLSClientConfig` should trust the server's certificate.
+// The "requestHeader" is sent to the server, it can be nil.
+func Dialer(transport *webtransport.Transport, requestHeader http.Header) neffos.Dialer {
+	return func(ctx context.Context, url string) (neffos.Socket, error) {
+		resp, session, err := transport.Dial(ctx, httpsURL(url), requestHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			session.CloseWithError(0, "")
+			return nil, fmt.Errorf("webtransport: unexpected response: %s", resp.Status)
+		}
+
+		stream, err := session.OpenStreamSync(ctx)
+		if err != nil {
+			session.CloseWithError(0, "")
+			return nil, err
+		}
+
+		return newSocket(session, stream, nil), nil
+	}
+}
+
+// httpsURL converts the websocket "url" to an https one,
+// the `neffos.Dial` prefixes the urls without a websocket scheme with the "ws://".
+func httpsURL(url string) string {
+	if strings.HasPrefix(url, "ws://") && strings.Contains(url[len("ws://"):], "://") {
+		url = url[len("ws://"):]
+	}
+
+	for _, scheme := range []string{"wss://", "ws://"} {
+		if strings.HasPrefix(url, scheme) {
+			return "https://" + url[len(scheme):]
+		}
+	}
+
+	return url
+}
@@ -0,0 +1,182 @@
+// Package webtransport provides the WebTransport (HTTP/3) adapter of neffos, through the quic-go/webtransport-go library.
+// Each session carries the neffos messages on a single bidirectional stream, which the client opens,
+// the datagrams of the sessions are not supported, the messages are always delivered reliably and in order.
+//
+// It's a separate module, as its dependencies require a newer Go version than the neffos one.
+package webtransport
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	"github.com/quic-go/webtransport-go"
+)
+
+// frameHeaderSize is the size of the header of each message of the stream:
+// its type, 1 byte, and the big-endian length of its payload, 4 bytes.
+const frameHeaderSize = 5
+
+// Socket completes the `neffos.Socket` interface,
+// it describes the single bidirectional stream of a WebTransport session,
+// each message is sent as its type and its length followed by its payload.
+// The datagrams of the session are not used.
+type Socket struct {
+	Session *webtransport.Session
+	Stream  *webtransport.Stream
+	request *http.Request
+	netConn *netConn
+
+	reader *bufio.Reader
+	header [frameHeaderSize]byte
+	// see `SetReadLimit`.
+	readLimit int64
+
+	mu sync.Mutex
+}
+
+func newSocket(session *webtransport.Session, stream *webtransport.Stream, request *http.Request) *Socket {
+	s := &Socket{
+		Session: session,
+		Stream:  stream,
+		request: request,
+		reader:  bufio.NewReader(stream),
+	}
+	s.netConn = &netConn{Stream: stream, session: session}
+	return s
+}
+
+// NetConn returns the net connection of the session's stream,
+// its `Close` closes the session.
+func (s *Socket) NetConn() net.Conn {
+	return s.netConn
+}
+
+// Request returns the http request value.
+func (s *Socket) Request() *http.Request {
+	return s.request
+}
+
+// ReadData reads binary or text messages from the remote connection.
+// The closed session is reported as a `neffos.CloseError` of its error code.
+func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
+	if timeout > 0 {
+		s.Stream.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	if _, err := io.ReadFull(s.reader, s.header[:]); err != nil {
+		return nil, 0, s.readError(err)
+	}
+
+	typ, length := neffos.MessageType(s.header[0]), int64(binary.BigEndian.Uint32(s.header[1:]))
+	if typ != neffos.TextMessage && typ != neffos.BinaryMessage {
+		return nil, 0, errInvalidFrame
+	}
+
+	if s.readLimit > 0 && length > s.readLimit {
+		return nil, 0, neffos.ErrMessageTooLarge
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, b); err != nil {
+		return nil, 0, s.readError(err)
+	}
+
+	return b, typ, nil
+}
+
+var errInvalidFrame = errors.New("webtransport: invalid frame")
+
+// readError maps the errors of a closed session or stream to a `neffos.CloseError`,
+// so `neffos.IsCloseError` reports them, as it does for the websocket ones.
+func (s *Socket) readError(err error) error {
+	var sessionErr *webtransport.SessionError
+	if errors.As(err, &sessionErr) {
+		return neffos.NewCloseError(int(sessionErr.ErrorCode), err)
+	}
+
+	var streamErr *webtransport.StreamError
+	if errors.As(err, &streamErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || s.Session.Context().Err() != nil {
+		return neffos.NewCloseError(1006, err) // abnormal closure.
+	}
+
+	return err
+}
+
+// SetReadLimit sets the maximum size in bytes for a message read from the remote connection,
+// it completes the `neffos.SocketReadLimiter` interface.
+func (s *Socket) SetReadLimit(limit int64) {
+	s.readLimit = limit
+}
+
+// WriteClose closes the session with the "code" and the "reason",
+// which the remote side reads as a `neffos.CloseError`, it completes the `neffos.SocketCloser` interface.
+func (s *Socket) WriteClose(code int, reason string, timeout time.Duration) error {
+	return s.Session.CloseWithError(webtransport.SessionErrorCode(code), reason)
+}
+
+// WriteBinary sends a binary message to the remote connection.
+func (s *Socket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.write(body, neffos.BinaryMessage, timeout)
+}
+
+// WriteText sends a text message to the remote connection.
+func (s *Socket) WriteText(body []byte, timeout time.Duration) error {
+	return s.write(body, neffos.TextMessage, timeout)
+}
+
+func (s *Socket) write(body []byte, typ neffos.MessageType, timeout time.Duration) error {
+	b := make([]byte, frameHeaderSize, frameHeaderSize+len(body))
+	b[0] = byte(typ)
+	binary.BigEndian.PutUint32(b[1:], uint32(len(body)))
+	b = append(b, body...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timeout > 0 {
+		s.Stream.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	_, err := s.Stream.Write(b)
+	return err
+}
+
+// netConn is the net connection of the socket, its reads and writes are not used by neffos.
+type netConn struct {
+	*webtransport.Stream
+	session *webtransport.Session
+}
+
+var _ net.Conn = (*netConn)(nil)
+
+func (c *netConn) Close() error {
+	return c.session.CloseWithError(0, "")
+}
+
+func (c *netConn) LocalAddr() net.Addr {
+	return c.session.LocalAddr()
+}
+
+func (c *netConn) RemoteAddr() net.Addr {
+	return c.session.RemoteAddr()
+}
+
+// acceptTimeout is the maximum time to wait for the stream of a new session.
+const acceptTimeout = 10 * time.Second
+
+func acceptStream(session *webtransport.Session) (*webtransport.Stream, error) {
+	ctx, cancel := context.WithTimeout(session.Context(), acceptTimeout)
+	defer cancel()
+
+	return session.AcceptStream(ctx)
+}
@@ -0,0 +1,47 @@
+package webtransport
+
+import (
+	"net/http"
+
+	"github.com/kataras/neffos"
+
+	"github.com/quic-go/webtransport-go"
+)
+
+// Handler returns an `http.Handler`, for the handler of the "wt" server's HTTP/3 one,
+// which serves the neffos "server" through the WebTransport sessions, see `Upgrade`.
+func Handler(server *neffos.Server, wt *webtransport.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(server, wt, w, r)
+	})
+}
+
+// Upgrade upgrades the request to a WebTransport session and serves it through the neffos "server",
+// with the same registration and acknowledgement as the `neffos.Server#ServeHTTP`.
+// The server's Upgrader is not used, it can be constructed with a nil one, i.e `neffos.New(nil, events)`.
+// The messages are exchanged through the first bidirectional stream which the client opens, see `Dialer`,
+// the datagrams are not supported.
+//
+// It returns the error of the upgrade, which is reported to the `OnUpgradeError` too.
+func Upgrade(server *neffos.Server, wt *webtransport.Server, w http.ResponseWriter, r *http.Request) (*neffos.Conn, error) {
+	session, err := wt.Upgrade(w, r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if server.OnUpgradeError != nil {
+			server.OnUpgradeError(err)
+		}
+		return nil, err
+	}
+
+	stream, err := acceptStream(session)
+	if err != nil {
+		session.CloseWithError(0, "")
+		if server.OnUpgradeError != nil {
+			server.OnUpgradeError(err)
+		}
+		return nil, err
+	}
+
+	// closes the socket on errors too.
+	return server.ServeSocket(w, r, newSocket(session, stream, r), nil)
+}
@@ -0,0 +1,147 @@
+package webtransport_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	neffoswt "github.com/kataras/neffos/webtransport"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+func newTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	clientConfig := &tls.Config{RootCAs: pool, NextProtos: []string{http3.NextProtoH3}}
+	return http3.ConfigureTLSConfig(serverConfig), clientConfig
+}
+
+func TestUpgrade(t *testing.T) {
+	var (
+		namespace = "default"
+		conns     = make(chan *neffos.Conn, 1)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	serverConfig, clientConfig := newTLSConfigs(t)
+
+	server := neffos.New(nil, events)
+	server.OnConnect = func(c *neffos.Conn) error {
+		conns <- c
+		return nil
+	}
+	defer server.Close()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wt := &webtransport.Server{
+		H3:          &http3.Server{TLSConfig: serverConfig},
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	webtransport.ConfigureHTTP3Server(wt.H3)
+	wt.H3.Handler = neffoswt.Handler(server, wt)
+	go wt.Serve(udpConn)
+	defer wt.Close()
+
+	transport := &webtransport.Transport{TLSClientConfig: clientConfig}
+	defer transport.Close()
+
+	url := "https://" + udpConn.LocalAddr().String() + "/echo"
+	client, err := neffos.Dial(context.TODO(), neffoswt.Dialer(transport, nil), url, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := c.Ask(context.TODO(), "echo", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "data", string(msg.Body); expected != got {
+		t.Fatalf("expected body: %s but got: %s", expected, got)
+	}
+
+	// the session closure is a close error, the client is disconnected as on websocket.
+	(<-conns).Socket().(neffos.SocketCloser).WriteClose(4000, "bye", 0)
+
+	select {
+	case <-client.NotifyClose:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the client to be closed")
+	}
+
+	socket, err := neffoswt.Dialer(transport, nil)(context.TODO(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stream is accepted on its first message.
+	if err = socket.WriteText([]byte("M"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	(<-conns).Socket().(neffos.SocketCloser).WriteClose(4000, "bye", 0)
+
+	_, _, err = socket.ReadData(5 * time.Second)
+	closeErr, ok := err.(neffos.CloseError)
+	if !ok || !neffos.IsCloseError(err) {
+		t.Fatalf("expected a close error but got: %#+v", err)
+	}
+	if expected, got := 4000, closeErr.Code; expected != got {
+		t.Fatalf("expected close code: %d but got: %d", expected, got)
+	}
+}
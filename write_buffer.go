@@ -0,0 +1,149 @@
+package neffos
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// The defaults of the `Server#SetWriteBuffer` and `WithWriteBuffer`.
+const (
+	DefaultWriteBufferSize       = 4096
+	DefaultWriteBufferMaxLatency = 2 * time.Millisecond
+)
+
+// applyWriteBuffer enables the write buffering of the socket, if configured and supported.
+// It's called after the ack so the ack messages are never buffered.
+func (c *Conn) applyWriteBuffer() {
+	if c.writeBufferSize <= 0 {
+		return
+	}
+
+	if b, ok := c.socket.(SocketWriteBuffer); ok {
+		b.SetWriteBuffer(c.writeBufferSize, c.writeBufferMaxLatency)
+	}
+}
+
+// flush writes the buffered messages of the socket, if it buffers its writes.
+func (c *Conn) flush() bool {
+	f, ok := c.socket.(SocketFlusher)
+	if !ok {
+		return true
+	}
+
+	if err := f.Flush(); err != nil {
+		if IsCloseError(err) {
+			c.Close()
+		}
+		return false
+	}
+
+	return true
+}
+
+// WriteBuffer buffers the messages of a socket which are written to its net connection,
+// it's used by the sockets which implement the `SocketWriteBuffer` and `SocketFlusher` interfaces.
+// The buffered messages are written on `Flush`, when the buffer exceeds its size
+// or after its max latency, whichever comes first.
+//
+// Its methods should be called under the "locker" of its socket's writes,
+// so only complete messages are written between the writes of the socket, i.e control frames.
+type WriteBuffer struct {
+	conn       net.Conn
+	locker     sync.Locker
+	size       int
+	maxLatency time.Duration
+
+	buf     []byte
+	timeout time.Duration
+	timer   *time.Timer
+	armed   bool
+	// the error of a failed write, it's returned on the next calls.
+	err error
+}
+
+// NewWriteBuffer returns a new `WriteBuffer` of the "conn". The "locker" guards the writes of its socket.
+// A zero "size" or "maxLatency" defaults to the `DefaultWriteBufferSize` and `DefaultWriteBufferMaxLatency`.
+func NewWriteBuffer(conn net.Conn, locker sync.Locker, size int, maxLatency time.Duration) *WriteBuffer {
+	if size <= 0 {
+		size = DefaultWriteBufferSize
+	}
+
+	if maxLatency <= 0 {
+		maxLatency = DefaultWriteBufferMaxLatency
+	}
+
+	return &WriteBuffer{
+		conn:       conn,
+		locker:     locker,
+		size:       size,
+		maxLatency: maxLatency,
+	}
+}
+
+// Write appends "p" to the buffer, a message may be written with several calls, see `Commit`.
+func (b *WriteBuffer) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// Commit marks the end of a message which was written with the "timeout".
+// It writes the buffer if it exceeds its size, otherwise it's written after the max latency.
+// The "timeout" of the last message applies to the write of the whole buffer.
+func (b *WriteBuffer) Commit(timeout time.Duration) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	b.timeout = timeout
+	if len(b.buf) >= b.size {
+		return b.Flush()
+	}
+
+	if !b.armed {
+		b.armed = true
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.maxLatency, b.flushLatency)
+		} else {
+			b.timer.Reset(b.maxLatency)
+		}
+	}
+
+	return nil
+}
+
+func (b *WriteBuffer) flushLatency() {
+	b.locker.Lock()
+	if b.armed {
+		b.Flush()
+	}
+	b.locker.Unlock()
+}
+
+// Flush writes the buffered messages to the net connection.
+func (b *WriteBuffer) Flush() error {
+	if b.armed {
+		b.armed = false
+		b.timer.Stop()
+	}
+
+	if b.err != nil || len(b.buf) == 0 {
+		return b.err
+	}
+
+	if b.timeout > 0 {
+		b.conn.SetWriteDeadline(time.Now().Add(b.timeout))
+	}
+
+	_, err := b.conn.Write(b.buf)
+	b.buf = b.buf[:0]
+	if err != nil {
+		b.err = err
+	}
+
+	return err
+}
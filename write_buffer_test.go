@@ -0,0 +1,224 @@
+package neffos_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	tcp "github.com/kataras/neffos/tcp"
+)
+
+// recordConn is a `net.Conn` which records its writes.
+type recordConn struct {
+	net.Conn
+
+	mu       sync.Mutex
+	writes   []string
+	deadline time.Time
+	err      error
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	c.writes = append(c.writes, string(b))
+	return len(b), nil
+}
+
+func (c *recordConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *recordConn) get() ([]string, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.writes...), c.deadline
+}
+
+func TestWriteBuffer(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		conn = new(recordConn)
+		b    = neffos.NewWriteBuffer(conn, &mu, 8, 50*time.Millisecond)
+	)
+
+	write := func(data string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		b.Write([]byte(data))
+		return b.Commit(time.Second)
+	}
+
+	write("ab")
+	write("cd")
+	if writes, _ := conn.get(); len(writes) != 0 {
+		t.Fatalf("expected the messages to be buffered but got: %q", writes)
+	}
+
+	// after the max latency.
+	time.Sleep(150 * time.Millisecond)
+	writes, deadline := conn.get()
+	if expected := []string{"abcd"}; strings.Join(writes, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected the buffered messages to be written together: %q but got: %q", expected, writes)
+	}
+	if deadline.IsZero() {
+		t.Fatalf("expected the timeout to apply to the write of the buffer")
+	}
+
+	// exceeds the size.
+	write("efghijkl")
+	if writes, _ = conn.get(); len(writes) != 2 || writes[1] != "efghijkl" {
+		t.Fatalf("expected the full buffer to be written immediately but got: %q", writes)
+	}
+
+	write("mn")
+	mu.Lock()
+	b.Flush()
+	mu.Unlock()
+	if writes, _ = conn.get(); len(writes) != 3 || writes[2] != "mn" {
+		t.Fatalf("expected the flushed message but got: %q", writes)
+	}
+
+	// the error of a write is returned on the next calls.
+	errWrite := errors.New("write error")
+	conn.mu.Lock()
+	conn.err = errWrite
+	conn.mu.Unlock()
+
+	write("op")
+	mu.Lock()
+	err := b.Flush()
+	mu.Unlock()
+	if err != errWrite {
+		t.Fatalf("expected the write error but got: %v", err)
+	}
+	if err = write("qr"); err != errWrite {
+		t.Fatalf("expected the previous write error but got: %v", err)
+	}
+}
+
+func TestServerWriteBuffer(t *testing.T) {
+	var (
+		namespace = "default"
+		n         = 100
+		received  = make(chan string, n)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+				"message": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- string(msg.Body)
+					return nil
+				},
+			},
+		}
+		// long enough to tell a flushed broadcast from an expired buffer.
+		maxLatency = 500 * time.Millisecond
+	)
+
+	newServer := func(upgrader neffos.Upgrader) (*neffos.Server, chan *neffos.Conn) {
+		conns := make(chan *neffos.Conn, 1)
+		server := neffos.New(upgrader, events)
+		server.SetWriteBuffer(1<<16, maxLatency)
+		// every broadcasted message is written.
+		server.SyncBroadcaster = true
+		server.OnConnect = func(c *neffos.Conn) error {
+			conns <- c
+			return nil
+		}
+		return server, conns
+	}
+
+	gobwasServer, gobwasConns := newServer(gobwas.DefaultUpgrader)
+	defer gobwasServer.Close()
+	httpServer := httptest.NewServer(gobwasServer)
+	defer httpServer.Close()
+
+	tcpServer, tcpConns := newServer(nil)
+	defer tcpServer.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go tcp.Serve(tcpServer, ln)
+
+	tests := []struct {
+		adapter string
+		dialer  neffos.Dialer
+		url     string
+		server  *neffos.Server
+		conns   chan *neffos.Conn
+	}{
+		{"gobwas", gobwas.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1), gobwasServer, gobwasConns},
+		{"tcp", tcp.DefaultDialer, ln.Addr().String(), tcpServer, tcpConns},
+	}
+
+	for _, tt := range tests {
+		// the acknowledgement is never buffered.
+		client, err := neffos.Dial(context.TODO(), tt.dialer, tt.url, events, neffos.WithWriteBuffer(1<<16, 10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.adapter, err)
+		}
+		serverConn := <-tt.conns
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.adapter, err)
+		}
+
+		if msg, err := c.Ask(context.TODO(), "echo", []byte("data")); err != nil || string(msg.Body) != "data" {
+			t.Fatalf("[%s] expected the reply but got: %q: %v", tt.adapter, msg.Body, err)
+		}
+
+		// the broadcasted messages are written when they are drained, before the max latency.
+		for i := 0; i < n; i++ {
+			tt.server.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "message", Body: []byte(strconv.Itoa(i))})
+		}
+
+		for i := 0; i < n; i++ {
+			select {
+			case got := <-received:
+				if expected := strconv.Itoa(i); expected != got {
+					t.Fatalf("[%s] expected message: %s but got: %s", tt.adapter, expected, got)
+				}
+			case <-time.After(maxLatency / 2):
+				t.Fatalf("[%s] expected message: %d", tt.adapter, i)
+			}
+		}
+
+		// the buffered messages are written before the close.
+		serverConn.Namespace(namespace).Emit("message", []byte("last"))
+		serverConn.Close()
+
+		select {
+		case got := <-received:
+			if expected := "last"; expected != got {
+				t.Fatalf("[%s] expected message: %s but got: %s", tt.adapter, expected, got)
+			}
+		case <-time.After(maxLatency / 2):
+			t.Fatalf("[%s] expected the last message", tt.adapter)
+		}
+
+		<-client.NotifyClose
+	}
+}